@@ -2,18 +2,44 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/cache"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/config"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/grpcapi"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/batch"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/docstore"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/idempotency"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/org"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/outbox"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/models/queue"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/token"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/upload"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/usage"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/webhook"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/worker"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/storage"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/web"
 )
 
@@ -31,39 +57,670 @@ func main() {
 	}
 	defer logger.Sync()
 
-	rabbitMQIP := os.Getenv("RABBITMQ_IP")
+	// Load the config from the file at CONFIG_FILE if set, with the environment applied over it
+	configPath := os.Getenv("CONFIG_FILE")
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		logger.Fatal("Error loading config:", err)
+	}
+	services.SetDataDir(cfg.DataDir)
+
 	webserverIP := os.Getenv("WEBSERVER_IP")
+	grpcPort, _ := strconv.Atoi(os.Getenv("GRPC_PORT")) // 0 (unset) disables the gRPC API
+	grpcCertFile, grpcKeyFile := os.Getenv("GRPC_TLS_CERT_FILE"), os.Getenv("GRPC_TLS_KEY_FILE")
+	if grpcPort != 0 && (grpcCertFile == "" || grpcKeyFile == "") {
+		panic("GRPC_PORT requires GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE, as gRPC is only served over TLS")
+	}
+	jobIDPrefix := os.Getenv("JOB_ID_PREFIX")
+	sfmGracePeriod, _ := time.ParseDuration(os.Getenv("SFM_GRACE_PERIOD"))  // 0 (unset) publishes immediately
+	videoLimits := loadVideoLimits()
+	uploadLimits := loadUploadLimits()
+	trainingLimits := loadTrainingLimits()
+	contentScanning := loadContentScanning()
+	admission := loadAdmission(cfg.Limits)
+	webhookConfig := loadWebhooks()
+	reaper := loadReaper()
+	retention := loadRetention()
+	maxDeliveryAttempts, _ := strconv.Atoi(os.Getenv("MAX_DELIVERY_ATTEMPTS")) // 0 (unset) uses the default
+	storageConfig := loadStorage()
+	cacheConfig, cacheTTL := loadCache()
+	brokerConfig := loadBroker(cfg.AMQPURI)
+	emailVerification := loadEmailVerification(logger)
+	oauthConfig := loadOAuth()
+	notificationsEnabled, notificationConfig := loadNotifications(logger)
+	credentialRules := loadCredentialRules(logger)
+	estimation := loadEstimationCoefficients()
+	jwtSecret := os.Getenv("JWT_SECRET_KEY")
+	accessTokenTTL, _ := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL"))     // 0 (unset) uses the default
+	refreshTokenTTL, _ := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL"))   // 0 (unset) uses the default
+	idempotencyTTL, _ := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL"))      // 0 (unset) uses the default
+	uploadSessionTTL, _ := time.ParseDuration(os.Getenv("UPLOAD_SESSION_TTL")) // 0 (unset) uses the default
+	resourceURLSecret := os.Getenv("RESOURCE_URL_SECRET")
+	if resourceURLSecret == "" {
+		resourceURLSecret = jwtSecret
+	}
+	workerAPIKey := os.Getenv("WORKER_API_KEY") // empty (unset) disables the worker API
+	shutdownTimeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || shutdownTimeout <= 0 {
+		shutdownTimeout = 30 * time.Second
+	}
+
+	tokenConfig := services.TokenConfig{
+		Secret:     []byte(jwtSecret),
+		AccessTTL:  accessTokenTTL,
+		RefreshTTL: refreshTokenTTL,
+	}
+
+	// Create the metrics shared by the services and served by the web server
+	appMetrics := metrics.NewMetrics()
+
+	// Trace requests, database commands, and jobs if a collector is configured
+	shutdownTracing := setupTracing(logger)
+	defer shutdownTracing()
 
 	// Create a MongoDB client
-	mongoURI := fmt.Sprintf("mongodb://%s:%s@%s:27017",
-        os.Getenv("MONGO_INITDB_ROOT_USERNAME"),
-        os.Getenv("MONGO_INITDB_ROOT_PASSWORD"),
-        os.Getenv("MONGO_IP"))
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(mongoURI))
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(cfg.MongoURI).SetMonitor(tracing.WrapMongoMonitor(metrics.NewMongoMonitor(appMetrics))))
 	if err != nil {
 		logger.Fatal("Error creating MongoDB client:", err)
 	}
 
-	// Create separate managers with the MongoDB client
-	sceneManager := scene.NewSceneManager(client, logger, false)
+	// Create separate managers with the MongoDB client. Scenes and users are stored in PostgreSQL instead if
+	// POSTGRES_URL is set.
+	sceneManager, userManager := newRepositories(client, credentialRules, jobIDPrefix, logger)
+	if err := sceneManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating scene indexes:", err)
+	}
 	queueManager := queue.NewQueueListManager(client, logger, false)
-	userManager := user.NewUserManager(client, logger, false)
+	if err := userManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating user indexes:", err)
+	}
+	auditManager := audit.NewAuditManager(client, logger, false)
+	orgManager := org.NewOrgManager(client, logger, false)
+	if err := orgManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating organization indexes:", err)
+	}
+	workerManager := worker.NewWorkerManager(client, logger, false)
+	if err := workerManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating worker indexes:", err)
+	}
+	// Workers can only register through the worker API, so uploads are not checked for available workers without it
+	registeredWorkers := workerManager
+	if workerAPIKey == "" {
+		registeredWorkers = nil
+	}
+	batchManager := batch.NewBatchManager(client, logger, false)
+	if err := batchManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating batch indexes:", err)
+	}
+	uploadManager := upload.NewUploadManager(client, logger, false)
+	if err := uploadManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating upload indexes:", err)
+	}
+	tokenManager := token.NewTokenManager(client, logger, false)
+	usageManager := usage.NewUsageManager(client, logger, false)
+	if err := usageManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating usage indexes:", err)
+	}
+	outboxManager := outbox.NewOutboxManager(client, logger, false)
+	if err := outboxManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating outbox indexes:", err)
+	}
+	if err := tokenManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating refresh token indexes:", err)
+	}
+	if err := auditManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating audit indexes:", err)
+	}
+	idempotencyManager := idempotency.NewIdempotencyManager(client, logger, false)
+	if err := idempotencyManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating idempotency key indexes:", err)
+	}
+	webhookManager := webhook.NewWebhookManager(client, logger, false)
+	if err := webhookManager.EnsureIndexes(context.Background()); err != nil {
+		logger.Error("Error creating webhook indexes:", err)
+	}
+
+	// Replace the integer status of scenes created before scene statuses were tracked. Scenes already migrated are skipped.
+	if _, err := sceneManager.MigrateLegacyStatus(context.Background()); err != nil {
+		logger.Error("Error migrating legacy scene status:", err)
+	}
+
+	// Move scenes stored in the previous flat layout into per scene directories. Scenes already migrated are skipped.
+	if _, err := sceneManager.MigrateStorageLayout(context.Background()); err != nil {
+		logger.Error("Error migrating storage layout:", err)
+	}
 
 	// Initialize services
-	mqService, err := services.NewAMPQService(rabbitMQIP, sceneManager, queueManager, logger)
+	store, err := storage.New(storageConfig)
+	if err != nil {
+		logger.Fatal("Error creating object storage:", err)
+	}
+
+	sceneCacheBackend, err := cache.New(cacheConfig)
+	if err != nil {
+		logger.Fatal("Error creating cache:", err)
+	}
+	sceneCache := services.NewSceneCache(sceneCacheBackend, cacheTTL, logger)
+
+	mq, err := broker.New(context.Background(), brokerConfig)
+	if err != nil {
+		logger.Panic("Error connecting to message broker:", err)
+	}
+	webhooks := services.NewWebhooks(webhookManager, userManager, webhookConfig, appMetrics, logger)
+	defer webhooks.Close()
+	var notifications *services.Notifications
+	if notificationsEnabled {
+		notifications, err = services.NewNotifications(notificationConfig, sceneManager, userManager, logger)
+		if err != nil {
+			logger.Fatal("Error loading notification templates:", err)
+		}
+		defer notifications.Close()
+	}
+	mqService, err := services.NewAMPQService(mq, sceneManager, queueManager, userManager, outboxManager, sfmGracePeriod, admission, reaper, maxDeliveryAttempts, store, sceneCache, webhooks, notifications, appMetrics, logger)
 	if err != nil {
 		logger.Panic("Error initializing AMPQ service:", err)
 	}
-	clientService := services.NewClientService(mqService, sceneManager, userManager, queueManager, logger)
+	auditLog := services.NewAuditLog(auditManager, logger)
+	defer auditLog.Close()
+	clientService := services.NewClientService(services.ClientServiceConfig{
+		MQService:           mqService,
+		SceneManager:        sceneManager,
+		UserManager:         userManager,
+		QueueManager:        queueManager,
+		UploadManager:       uploadManager,
+		TokenManager:        tokenManager,
+		UsageManager:        usageManager,
+		Store:               store,
+		SceneCache:          sceneCache,
+		AuditLog:            auditLog,
+		Webhooks:            webhooks,
+		OrgManager:          orgManager,
+		WorkerManager:       registeredWorkers,
+		BatchManager:        batchManager,
+		Metrics:             appMetrics,
+		Logger:              logger,
+		ChunkSize:           cfg.ChunkSize,
+		VideoLimits:         videoLimits,
+		UploadLimits:        uploadLimits,
+		TrainingLimits:      trainingLimits,
+		MaxHighPriorityJobs: cfg.Limits.MaxHighPriorityJobs,
+		Quotas:              quotaConfig(cfg.Limits),
+		Scanning:            contentScanning,
+		ResourceURLKey:      []byte(resourceURLSecret),
+		Tokens:              tokenConfig,
+		Verification:        emailVerification,
+		Estimation:          estimation,
+	})
+
+	clientService.SetRetention(retention)
+	clientService.SetUploadSessionTTL(uploadSessionTTL)
+	if err := clientService.SetOAuth(oauthConfig); err != nil {
+		logger.Fatal("Invalid login providers:", err)
+	}
 
 	// Initialize web server
-	jwtSecret := os.Getenv("JWT_SECRET_KEY")
-	server := web.NewWebServer(jwtSecret, clientService, logger)
+	workerService := services.NewWorkerService(sceneManager, userManager, sceneCache, workerManager, []byte(workerAPIKey), appMetrics, logger)
+	healthService := services.NewHealthService(client, mq, store, logger)
+	idempotencyService := services.NewIdempotency(idempotencyManager, idempotencyTTL, logger)
+	server := web.NewWebServer(strings.Join(cfg.CORSAllowedOrigins, ","), clientService, workerService, healthService, idempotencyService, rateLimitConfig(cfg.Limits), appMetrics, logger)
+	if err := server.SetDownloadThrottle(throttleConfig(cfg.Limits)); err != nil {
+		logger.Fatal("Invalid download throttle:", err)
+	}
 
 	fmt.Println("Starting server...")
 
-	// Start the web server
-	if err := server.Run(webserverIP, 5000); err != nil {
-		logger.Fatal("Error starting web server:", err)
+	// Start the web server, until it fails or SIGINT or SIGTERM is received
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Apply the tunables of the config whenever SIGHUP is received. Values left unchanged are not applied again, so a
+	// limit an admin changed at runtime is kept.
+	reloader := config.NewReloader(configPath, cfg, func(prev, next *config.Config) {
+		if next.ChunkSize != prev.ChunkSize {
+			clientService.SetChunkSize(next.ChunkSize)
+		}
+		if next.Limits.MaxInFlightJobs != prev.Limits.MaxInFlightJobs {
+			mqService.SetMaxInFlightJobs(next.Limits.MaxInFlightJobs)
+		}
+		if next.Limits.MaxHighPriorityJobs != prev.Limits.MaxHighPriorityJobs {
+			clientService.SetMaxHighPriorityJobs(next.Limits.MaxHighPriorityJobs)
+		}
+		if quotaConfig(next.Limits) != quotaConfig(prev.Limits) {
+			clientService.SetQuotas(quotaConfig(next.Limits))
+		}
+		if rateLimitConfig(next.Limits) != rateLimitConfig(prev.Limits) {
+			server.SetRateLimit(rateLimitConfig(next.Limits))
+		}
+		if !reflect.DeepEqual(throttleConfig(next.Limits), throttleConfig(prev.Limits)) {
+			if err := server.SetDownloadThrottle(throttleConfig(next.Limits)); err != nil {
+				logger.Error("Failed to apply download throttle:", err)
+			}
+		}
+	}, logger)
+	go reloader.Run(ctx)
+	serverErr := make(chan error, 2)
+	go func() {
+		serverErr <- server.Run(webserverIP, 5000)
+	}()
+	var grpcServer *grpcapi.Server
+	if grpcPort != 0 {
+		grpcServer, err = grpcapi.NewServer(clientService, appMetrics, logger, grpcCertFile, grpcKeyFile)
+		if err != nil {
+			logger.Fatal("Error creating gRPC server:", err)
+		}
+		go func() {
+			serverErr <- grpcServer.Run(webserverIP, grpcPort)
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		logger.Error("Error running server:", err)
+	case <-ctx.Done():
+		logger.Infof("Shutting down, waiting up to %s for in-flight requests...", shutdownTimeout)
+		if err := server.Shutdown(shutdownTimeout); err != nil {
+			logger.Warn("Error shutting down web server:", err)
+		}
+		if grpcServer != nil {
+			if err := grpcServer.Shutdown(shutdownTimeout); err != nil {
+				logger.Warn("Error shutting down gRPC server:", err)
+			}
+		}
+	}
+
+	// Stop the consumers and flush the outbox, then let the deferred calls flush webhooks, the audit log, and traces
+	mqService.Shutdown()
+}
+
+// loadVideoLimits returns the default upload limits of every training mode, with any limits set in the environment
+// applied to all modes. Unset or malformed values keep the defaults.
+func loadVideoLimits() map[string]services.VideoLimits {
+	limits := services.DefaultVideoLimits()
+
+	maxDuration, durationErr := time.ParseDuration(os.Getenv("VIDEO_MAX_DURATION"))
+	maxLongEdge, longEdgeErr := strconv.Atoi(os.Getenv("VIDEO_MAX_LONG_EDGE"))
+	maxShortEdge, shortEdgeErr := strconv.Atoi(os.Getenv("VIDEO_MAX_SHORT_EDGE"))
+	maxFrames, maxFramesErr := strconv.Atoi(os.Getenv("VIDEO_MAX_FRAMES"))
+	minFrames, minFramesErr := strconv.Atoi(os.Getenv("VIDEO_MIN_FRAMES"))
+	var codecs []string
+	if value := os.Getenv("VIDEO_ALLOWED_CODECS"); value != "" {
+		for _, codec := range strings.Split(value, ",") {
+			codecs = append(codecs, strings.TrimSpace(codec))
+		}
+	}
+
+	for mode, l := range limits {
+		if codecs != nil {
+			l.AllowedCodecs = codecs
+		}
+		if durationErr == nil {
+			l.MaxDuration = maxDuration
+		}
+		if longEdgeErr == nil {
+			l.MaxLongEdge = maxLongEdge
+		}
+		if shortEdgeErr == nil {
+			l.MaxShortEdge = maxShortEdge
+		}
+		if maxFramesErr == nil {
+			l.MaxFrames = maxFrames
+		}
+		if minFramesErr == nil {
+			l.MinFrames = minFrames
+		}
+		limits[mode] = l
+	}
+
+	return limits
+}
+
+// loadUploadLimits returns the default upload limits of every user tier, with the limits of a tier set in the
+// environment as UPLOAD_MAX_SIZE_<TIER> and UPLOAD_MAX_DURATION_<TIER>, i.e UPLOAD_MAX_SIZE_FREE. Unset or malformed
+// values keep the defaults.
+func loadUploadLimits() map[string]services.UploadLimits {
+	limits := services.DefaultUploadLimits()
+
+	for _, tier := range []string{broker.TierFree, broker.TierStandard, broker.TierPriority} {
+		l := limits[tier]
+		suffix := strings.ToUpper(tier)
+		if maxSize, err := strconv.ParseInt(os.Getenv("UPLOAD_MAX_SIZE_"+suffix), 10, 64); err == nil {
+			l.MaxSize = maxSize
+		}
+		if maxDuration, err := time.ParseDuration(os.Getenv("UPLOAD_MAX_DURATION_"+suffix)); err == nil {
+			l.MaxDuration = maxDuration
+		}
+		limits[tier] = l
+	}
+
+	return limits
+}
+
+// loadEstimationCoefficients returns the default training estimate coefficients of every training mode, with the
+// modes in the JSON file at ESTIMATION_COEFFICIENTS_FILE replacing them. The file maps training modes to coefficients.
+func loadEstimationCoefficients() map[string]services.EstimationCoefficients {
+	coefficients := services.DefaultEstimationCoefficients()
+
+	path := os.Getenv("ESTIMATION_COEFFICIENTS_FILE")
+	if path == "" {
+		return coefficients
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to read ESTIMATION_COEFFICIENTS_FILE: %v", err))
+	}
+	var overrides map[string]services.EstimationCoefficients
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		panic(fmt.Sprintf("Failed to parse ESTIMATION_COEFFICIENTS_FILE: %v", err))
+	}
+	for mode, c := range overrides {
+		coefficients[mode] = c
+	}
+	return coefficients
+}
+
+// loadContentScanning reads the content scanning of uploads from the environment. Uploads are not scanned unless
+// CONTENT_SCANNER is set.
+func loadContentScanning() services.ContentScanning {
+	var scanning services.ContentScanning
+
+	switch os.Getenv("CONTENT_SCANNER") {
+	case "clamav":
+		address := os.Getenv("CLAMAV_ADDRESS")
+		if address == "" {
+			address = "clamav:3310"
+		}
+		scanning.Scanner = services.NewClamAVScanner(address)
+	case "":
+	default:
+		panic(fmt.Sprintf("Unknown CONTENT_SCANNER %q", os.Getenv("CONTENT_SCANNER")))
+	}
+
+	scanning.Timeout, _ = time.ParseDuration(os.Getenv("CONTENT_SCAN_TIMEOUT")) // 0 (unset) uses the default
+	scanning.FailOpen, _ = strconv.ParseBool(os.Getenv("CONTENT_SCAN_FAIL_OPEN"))
+	scanning.QuarantineDir = os.Getenv("CONTENT_SCAN_QUARANTINE_DIR") // empty removes flagged files
+	return scanning
+}
+
+// loadEmailVerification reads the email verification of new accounts from the environment. Accounts are not verified
+// unless EMAIL_VERIFICATION is set. Without SMTP_ADDRESS, verification mails are only logged.
+func loadEmailVerification(logger *log.Logger) services.EmailVerification {
+	var verification services.EmailVerification
+	verification.Enabled, _ = strconv.ParseBool(os.Getenv("EMAIL_VERIFICATION"))
+	verification.RequireForLogin, _ = strconv.ParseBool(os.Getenv("EMAIL_VERIFICATION_REQUIRED_FOR_LOGIN"))
+	verification.TokenTTL, _ = time.ParseDuration(os.Getenv("EMAIL_VERIFICATION_TTL")) // 0 (unset) uses the default
+	verification.LinkURL = os.Getenv("EMAIL_VERIFICATION_URL")
+
+	if address := os.Getenv("SMTP_ADDRESS"); address != "" {
+		verification.Mailer = services.NewSMTPMailer(address, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_FROM"))
+	} else if verification.Enabled {
+		logger.Warn("Email verification enabled without SMTP_ADDRESS, verification mails will only be logged")
+		verification.Mailer = services.NewLogMailer(logger)
+	}
+	return verification
+}
+
+// loadOAuth reads the providers users may log in with from the environment. A provider is enabled by setting its
+// client ID.
+func loadOAuth() services.OAuthConfig {
+	config := services.OAuthConfig{
+		RedirectURL: os.Getenv("OAUTH_REDIRECT_URL"),
+		Providers:   make(map[string]services.OAuthClient),
+	}
+	config.StateTTL, _ = time.ParseDuration(os.Getenv("OAUTH_STATE_TTL")) // 0 (unset) uses the default
+
+	for _, provider := range []string{services.OAuthProviderGoogle, services.OAuthProviderGitHub, services.OAuthProviderOIDC} {
+		prefix := "OAUTH_" + strings.ToUpper(provider) + "_"
+		if clientID := os.Getenv(prefix + "CLIENT_ID"); clientID != "" {
+			config.Providers[provider] = services.OAuthClient{
+				ClientID:     clientID,
+				ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+				Issuer:       os.Getenv(prefix + "ISSUER"),
+			}
+		}
+	}
+	return config
+}
+
+// loadNotifications reads the job notifications mailed to users from the environment. Nothing is mailed unless
+// NOTIFICATIONS is set. NOTIFICATION_MAILER picks the mailer, "smtp" (the SMTP_* relay, also used for Amazon SES) or
+// "sendgrid". Without one, notifications are only logged.
+func loadNotifications(logger *log.Logger) (bool, services.NotificationConfig) {
+	var config services.NotificationConfig
+	enabled, _ := strconv.ParseBool(os.Getenv("NOTIFICATIONS"))
+	config.SceneURL = os.Getenv("NOTIFICATION_SCENE_URL")
+	config.TemplatesDir = os.Getenv("NOTIFICATION_TEMPLATES_DIR")
+
+	switch os.Getenv("NOTIFICATION_MAILER") {
+	case "smtp":
+		config.Mailer = services.NewSMTPMailer(os.Getenv("SMTP_ADDRESS"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_FROM"))
+	case "sendgrid":
+		config.Mailer = services.NewSendGridMailer(os.Getenv("SENDGRID_API_KEY"), os.Getenv("SENDGRID_FROM"))
+	case "":
+		if enabled {
+			logger.Warn("Notifications enabled without NOTIFICATION_MAILER, notifications will only be logged")
+		}
+	default:
+		panic(fmt.Sprintf("Unknown NOTIFICATION_MAILER %q", os.Getenv("NOTIFICATION_MAILER")))
+	}
+	return enabled, config
+}
+
+// loadCredentialRules reads the rules usernames and passwords must follow from the environment. Unset values use the
+// defaults of user.DefaultCredentialRules.
+func loadCredentialRules(logger *log.Logger) user.CredentialRules {
+	rules := user.DefaultCredentialRules()
+	if n, err := strconv.Atoi(os.Getenv("USERNAME_MIN_LENGTH")); err == nil {
+		rules.UsernameMinLength = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("USERNAME_MAX_LENGTH")); err == nil {
+		rules.UsernameMaxLength = n
+	}
+	if pattern := os.Getenv("USERNAME_PATTERN"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Warnf("Invalid USERNAME_PATTERN, using the default: %v", err)
+		} else {
+			rules.UsernamePattern = re
+		}
+	}
+	if n, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil {
+		rules.PasswordMinLength = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_CLASSES")); err == nil {
+		rules.PasswordMinClasses = n
+	}
+	return rules
+}
+
+// loadAdmission reads the admission control of sfm jobs from the environment, with the cap of jobs in flight of the
+// config. Jobs are not limited unless the cap is set.
+func loadAdmission(limits config.Limits) services.AdmissionConfig {
+	var admission services.AdmissionConfig
+	admission.MaxInFlightJobs = limits.MaxInFlightJobs
+	admission.RetryAfter, _ = time.ParseDuration(os.Getenv("ADMISSION_RETRY_AFTER"))         // 0 (unset) uses the default
+	admission.RejectWhenFull = os.Getenv("ADMISSION_POLICY") == "reject"
+	return admission
+}
+
+// quotaConfig returns the per-user quotas of the config limits. Unset values do not limit users.
+func quotaConfig(limits config.Limits) services.QuotaConfig {
+	return services.QuotaConfig{
+		MaxConcurrentJobs:      limits.QuotaMaxConcurrentJobs,
+		MaxStorageBytes:        limits.QuotaMaxStorageBytes,
+		MaxUploadsPerDay:       limits.QuotaMaxUploadsPerDay,
+		MaxDownloadBytesPerDay: limits.QuotaMaxDownloadBytesPerDay,
+	}
+}
+
+// loadTrainingLimits reads the bounds on training configs from the environment. Unset values use the defaults, see
+// services.TrainingLimits.
+func loadTrainingLimits() services.TrainingLimits {
+	var limits services.TrainingLimits
+	limits.MaxTotalIterations, _ = strconv.Atoi(os.Getenv("TRAINING_MAX_TOTAL_ITERATIONS")) // 0 (unset) uses the default
+	limits.MaxSaveIterations, _ = strconv.Atoi(os.Getenv("TRAINING_MAX_SAVE_ITERATIONS"))   // 0 (unset) is unlimited
+	return limits
+}
+
+// rateLimitConfig returns the per-user rate limit of authenticated requests of the config limits. Requests are not
+// limited unless the requests per second are set.
+func rateLimitConfig(limits config.Limits) web.RateLimitConfig {
+	return web.RateLimitConfig{
+		RequestsPerSecond: limits.RateLimitRPS,
+		Burst:             limits.RateLimitBurst,
+	}
+}
+
+// throttleConfig returns the bandwidth throttling of output downloads of the config limits. Downloads are not
+// throttled unless a rate is set.
+func throttleConfig(limits config.Limits) web.ThrottleConfig {
+	return web.ThrottleConfig{
+		ConnectionBytesPerSecond: limits.DownloadBytesPerSecond,
+		UserBytesPerSecond:       limits.DownloadUserBytesPerSecond,
+		ExemptNetworks:           limits.DownloadExemptNetworks,
+	}
+}
+
+// loadWebhooks reads the webhook delivery settings from the environment. Webhooks are only sent to public addresses
+// unless WEBHOOK_ALLOW_PRIVATE_NETWORKS is "true".
+func loadWebhooks() services.WebhookConfig {
+	allowPrivate, _ := strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS"))
+	return services.WebhookConfig{AllowPrivateNetworks: allowPrivate}
+}
+
+// loadReaper returns the stale job reaper config. Unset or malformed values leave the reaper disabled for the stage.
+func loadReaper() services.ReaperConfig {
+	var reaper services.ReaperConfig
+	reaper.SfmTimeout, _ = time.ParseDuration(os.Getenv("REAPER_SFM_TIMEOUT"))
+	reaper.TrainingTimeout, _ = time.ParseDuration(os.Getenv("REAPER_TRAINING_TIMEOUT"))
+	reaper.MaxRequeues, _ = strconv.Atoi(os.Getenv("REAPER_MAX_REQUEUES")) // 0 (unset) fails timed out jobs right away
+	reaper.Interval, _ = time.ParseDuration(os.Getenv("REAPER_INTERVAL"))    // 0 (unset) uses the default
+	return reaper
+}
+
+// loadRetention reads the retention policy from the environment. Retention periods are in days, and unset or
+// malformed values keep the data. The policy only runs on servers with RETENTION_SCHEDULE set to "true".
+func loadRetention() services.RetentionConfig {
+	var retention services.RetentionConfig
+	if days, err := strconv.Atoi(os.Getenv("RETENTION_RAW_VIDEO_DAYS")); err == nil {
+		retention.RawVideoRetention = time.Duration(days) * 24 * time.Hour
+	}
+	if days, err := strconv.Atoi(os.Getenv("RETENTION_FAILED_SCENE_DAYS")); err == nil {
+		retention.FailedSceneRetention = time.Duration(days) * 24 * time.Hour
+	}
+	if days, err := strconv.Atoi(os.Getenv("RETENTION_TRASH_DAYS")); err == nil {
+		retention.TrashRetention = time.Duration(days) * 24 * time.Hour
+	}
+	retention.PurgeOrphanedFiles, _ = strconv.ParseBool(os.Getenv("RETENTION_PURGE_ORPHANED_FILES"))
+	retention.DryRun, _ = strconv.ParseBool(os.Getenv("RETENTION_DRY_RUN"))
+	retention.Schedule, _ = strconv.ParseBool(os.Getenv("RETENTION_SCHEDULE"))
+	retention.Interval, _ = time.ParseDuration(os.Getenv("RETENTION_INTERVAL")) // 0 (unset) uses the default
+	return retention
+}
+
+// loadStorage reads the object store scene outputs are mirrored to from the environment. Outputs stay on disk only
+// unless STORAGE_BACKEND is set.
+func loadStorage() storage.Config {
+	return storage.Config{
+		Backend:   os.Getenv("STORAGE_BACKEND"),
+		LocalRoot: os.Getenv("STORAGE_LOCAL_ROOT"),
+		S3: storage.S3Config{
+			Endpoint:        os.Getenv("STORAGE_ENDPOINT"),
+			Region:          os.Getenv("STORAGE_REGION"),
+			Bucket:          os.Getenv("STORAGE_BUCKET"),
+			AccessKeyID:     os.Getenv("STORAGE_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("STORAGE_SECRET_ACCESS_KEY"),
+			Prefix:          os.Getenv("STORAGE_PREFIX"),
+		},
+	}
+}
+
+// newRepositories creates the repositories of scenes and users. They are stored in the PostgreSQL database at
+// POSTGRES_URL if it is set, and in MongoDB with client otherwise.
+func newRepositories(client *mongo.Client, credentialRules user.CredentialRules, jobIDPrefix string, logger *log.Logger) (scene.SceneRepository, user.UserRepository) {
+	url := os.Getenv("POSTGRES_URL")
+	if url == "" {
+		return scene.NewSceneManager(client, logger, jobIDPrefix, false), user.NewUserManager(client, logger, credentialRules, false)
+	}
+
+	db, err := docstore.OpenPostgres(context.Background(), url)
+	if err != nil {
+		logger.Fatal("Error connecting to PostgreSQL:", err)
+	}
+	scenes, err := scene.NewPostgresRepository(db, logger, jobIDPrefix)
+	if err != nil {
+		logger.Fatal("Error creating scene repository:", err)
+	}
+	users, err := user.NewPostgresRepository(db, logger, credentialRules)
+	if err != nil {
+		logger.Fatal("Error creating user repository:", err)
+	}
+	return scenes, users
+}
+
+// loadCache reads the cache of scene configs, output files, and access checks from the environment, and how long its
+// entries live. Entries are kept in process unless REDIS_URL is set.
+func loadCache() (cache.Config, time.Duration) {
+	lruSize, _ := strconv.Atoi(os.Getenv("CACHE_LRU_SIZE"))
+	ttl, err := time.ParseDuration(os.Getenv("CACHE_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = services.DefaultSceneCacheTTL
+	}
+	return cache.Config{
+		RedisURL: os.Getenv("REDIS_URL"),
+		LRUSize:  lruSize,
+	}, ttl
+}
+
+// loadBroker reads the message broker carrying the job queues from the environment. RabbitMQ is used at amqpURI unless
+// MESSAGE_BROKER is set.
+func loadBroker(amqpURI string) broker.Config {
+	var brokers []string
+	for _, address := range strings.Split(os.Getenv("KAFKA_BROKERS"), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			brokers = append(brokers, address)
+		}
+	}
+
+	return broker.Config{
+		Backend: os.Getenv("MESSAGE_BROKER"),
+		AMQP: broker.AMQPConfig{
+			URL:         amqpURI,
+			MaxPriority: scene.MaxAMQPPriority,
+		},
+		NATS: broker.NATSConfig{
+			URL:      os.Getenv("NATS_URL"),
+			Stream:   os.Getenv("NATS_STREAM"),
+			Consumer: os.Getenv("NATS_CONSUMER"),
+		},
+		Kafka: broker.KafkaConfig{
+			Brokers: brokers,
+			GroupID: os.Getenv("KAFKA_GROUP_ID"),
+		},
+	}
+}
+
+// setupTracing sets the tracer from the environment, and returns the function sending the spans still queued on
+// shutdown. Requests are not traced unless OTEL_EXPORTER_OTLP_ENDPOINT is set.
+func setupTracing(logger *log.Logger) func() {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return func() {}
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "go-web-server"
+	}
+	sampleRatio := 1.0
+	if ratio, err := strconv.ParseFloat(os.Getenv("OTEL_TRACES_SAMPLER_ARG"), 64); err == nil {
+		sampleRatio = ratio
+	}
+
+	exporter := tracing.NewExporter(endpoint, serviceName, logger)
+	tracing.SetTracer(tracing.NewTracer(sampleRatio, exporter))
+	logger.Infof("Exporting traces of %s to %s", serviceName, endpoint)
+
+	return func() {
+		tracing.SetTracer(nil)
+		exporter.Close()
 	}
 }
@@ -0,0 +1,222 @@
+// This file contains the MessageQueue interface, and the creation of a message queue from its configuration.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+var (
+	// ErrUnknownBackend is returned when the configured backend is not one of the supported brokers.
+	ErrUnknownBackend = errors.New("unknown message broker")
+	// ErrQueueDepthUnsupported is returned by brokers that cannot tell how many messages wait in a queue.
+	ErrQueueDepthUnsupported = errors.New("broker does not report queue depth")
+	// ErrClosed is returned when a message queue is used after it was closed.
+	ErrClosed = errors.New("message queue closed")
+)
+
+// Supported message brokers
+const (
+	BackendAMQP  = "amqp"
+	BackendNATS  = "nats"
+	BackendKafka = "kafka"
+)
+
+// Queues exchanged with the workers
+const (
+	QueueSfmIn       = "sfm-in"
+	QueueNerfIn      = "nerf-in"
+	QueueSfmOut      = "sfm-out"
+	QueueNerfOut     = "nerf-out"
+	QueueProgressOut = "progress-out"
+	// QueueExportIn and QueueExportOut carry the jobs and output of the conversion worker, see PublishExportJob.
+	QueueExportIn  = "export-in"
+	QueueExportOut = "export-out"
+	// QueueRenderIn and QueueRenderOut carry the jobs and output of the render worker, see PublishRenderJob.
+	QueueRenderIn  = "render-in"
+	QueueRenderOut = "render-out"
+	// QueueDeadLetter holds the messages that could not be processed after every attempt, see PublishDeadLetter.
+	QueueDeadLetter = "dead-letter"
+)
+
+// ExchangePreview carries the low resolution frames workers render while training, to every server, see
+// SubscribeBroadcast. It is a fanout exchange on RabbitMQ, a core subject on NATS, and a topic on Kafka.
+const ExchangePreview = "preview"
+
+// Job tiers, each published to job queues of their own, see TieredQueue
+const (
+	TierPriority = "priority"
+	TierStandard = "standard"
+	TierFree     = "free"
+)
+
+// Tiers are the job tiers in the order workers consume them. A worker takes a job of a tier only while the job queues
+// of the tiers before it are empty, so jobs of a lower tier never hold up those of a higher one.
+var Tiers = []string{TierPriority, TierStandard, TierFree}
+
+// JobQueues are the job queues of every tier, in the order workers consume them, i.e "sfm-in.priority", "sfm-in",
+// "sfm-in.free".
+var JobQueues = append(tieredQueues(QueueSfmIn), tieredQueues(QueueNerfIn)...)
+
+// Queues are every queue exchanged with the workers, which the brokers create on connection.
+var Queues = append(slices.Clone(JobQueues), QueueSfmOut, QueueNerfOut, QueueProgressOut, QueueExportIn, QueueExportOut,
+	QueueRenderIn, QueueRenderOut, QueueDeadLetter)
+
+// IsValidTier checks if the given job tier is one of Tiers.
+func IsValidTier(tier string) bool {
+	return slices.Contains(Tiers, tier)
+}
+
+// TieredQueue returns the job queue of a tier, i.e "sfm-in.free" for the free tier of "sfm-in". Standard jobs, and
+// jobs of an unknown tier, are published to the queue itself, so workers that do not know about tiers still consume
+// them.
+func TieredQueue(queue, tier string) string {
+	if tier == TierStandard || !IsValidTier(tier) {
+		return queue
+	}
+	return queue + "." + tier
+}
+
+// TierRank returns the position of a tier in Tiers, lower ranks are consumed first. Unknown tiers rank as standard.
+func TierRank(tier string) int {
+	if i := slices.Index(Tiers, tier); i >= 0 {
+		return i
+	}
+	return slices.Index(Tiers, TierStandard)
+}
+
+// tieredQueues returns the job queues of every tier of a queue, in the order of Tiers.
+func tieredQueues(queue string) []string {
+	queues := make([]string, len(Tiers))
+	for i, tier := range Tiers {
+		queues[i] = TieredQueue(queue, tier)
+	}
+	return queues
+}
+
+// Headers of dead-lettered messages, set alongside the headers of the original message
+const (
+	// HeaderDeadLetterQueue is the queue the message was consumed from.
+	HeaderDeadLetterQueue = "x-dead-letter-queue"
+	// HeaderDeadLetterReason is the error of the last attempt to process the message.
+	HeaderDeadLetterReason = "x-dead-letter-reason"
+	// HeaderDeadLetterAttempts is the number of attempts to process the message.
+	HeaderDeadLetterAttempts = "x-dead-letter-attempts"
+)
+
+// Job is a message published to the workers.
+type Job struct {
+	Body []byte
+	// Priority is the priority of the job between 0 and scene.MaxAMQPPriority, higher jobs are consumed first.
+	// Brokers without priorities, i.e NATS and Kafka, deliver jobs in the order they are published.
+	Priority uint8
+	// Tier selects the job queue the job is published to, see TieredQueue. Empty publishes to the standard queue.
+	Tier    string
+	Headers map[string]string
+}
+
+// Delivery is a message consumed from a queue. It must be acknowledged with Ack once processed, or rejected with Nack.
+// Only the first Ack or Nack of a delivery has an effect, so a handler may settle it early.
+type Delivery struct {
+	Body    []byte
+	Headers map[string]string
+
+	once  sync.Once
+	acked bool
+	ack   func() error
+	nack  func(requeue bool) error
+}
+
+// newDelivery creates a delivery settled with the given functions.
+func newDelivery(body []byte, headers map[string]string, ack func() error, nack func(requeue bool) error) *Delivery {
+	return &Delivery{Body: body, Headers: headers, ack: ack, nack: nack}
+}
+
+// Ack acknowledges the delivery, removing the message from its queue.
+func (d *Delivery) Ack() (err error) {
+	d.once.Do(func() {
+		d.acked = true
+		err = d.ack()
+	})
+	return err
+}
+
+// Nack rejects the delivery. The message is delivered again if requeue is set, and dropped otherwise.
+func (d *Delivery) Nack(requeue bool) (err error) {
+	d.once.Do(func() {
+		err = d.nack(requeue)
+	})
+	return err
+}
+
+// MessageQueue is a message broker carrying the job queues. Implementations must be safe for concurrent use.
+type MessageQueue interface {
+	// System names the broker, i.e "rabbitmq", for logs and traces.
+	System() string
+	// PublishSfmJob publishes a job to the "sfm-in" queue of its tier.
+	PublishSfmJob(ctx context.Context, job Job) error
+	// PublishNerfJob publishes a job to the "nerf-in" queue of its tier.
+	PublishNerfJob(ctx context.Context, job Job) error
+	// PublishExportJob publishes a job converting an output to another format to the "export-in" queue.
+	PublishExportJob(ctx context.Context, job Job) error
+	// PublishRenderJob publishes a job rendering a scene along a camera path to the "render-in" queue.
+	PublishRenderJob(ctx context.Context, job Job) error
+	// PublishDeadLetter publishes a message that could not be processed to the "dead-letter" queue. Its headers
+	// should carry the HeaderDeadLetter headers.
+	PublishDeadLetter(ctx context.Context, job Job) error
+	// Subscribe consumes the messages of a queue, calling handle for each in turn, until ctx is done or the
+	// subscription fails. A delivery that handle does not settle is rejected and requeued.
+	// Returns nil once ctx is done, or the error that ended the subscription, in which case the caller should
+	// subscribe again.
+	Subscribe(ctx context.Context, queue string, handle func(*Delivery)) error
+	// SubscribeBroadcast receives the messages published to a broadcast exchange, i.e ExchangePreview, while
+	// subscribed, calling handle for each in turn, until ctx is done or the subscription fails. Unlike a queue, every
+	// subscriber receives every message, and messages are not acknowledged, so those published while nobody is
+	// subscribed, or that a slow subscriber falls behind on, are lost.
+	// Returns nil once ctx is done, or the error that ended the subscription, in which case the caller should
+	// subscribe again.
+	SubscribeBroadcast(ctx context.Context, exchange string, handle func(body []byte, headers map[string]string)) error
+	// QueueDepth returns the number of messages waiting in a queue.
+	// Returns ErrQueueDepthUnsupported if the broker cannot tell.
+	QueueDepth(ctx context.Context, queue string) (int, error)
+	// Ping checks that the broker can be reached and the job queues exist, reconnecting if the connection was lost.
+	// It is used by readiness probes, so it should be cheap.
+	Ping(ctx context.Context) error
+	// Close closes the connection to the broker. Subscriptions end with an error.
+	Close() error
+}
+
+// Config selects and configures the message broker.
+type Config struct {
+	// one of the Backend constants, empty uses BackendAMQP
+	Backend string
+	AMQP    AMQPConfig
+	NATS    NATSConfig
+	Kafka   KafkaConfig
+}
+
+// New connects to the message broker selected by cfg, and creates the queues exchanged with the workers.
+//
+// Returns ErrUnknownBackend if the backend is not supported, or error if the broker could not be reached.
+func New(ctx context.Context, cfg Config) (MessageQueue, error) {
+	switch cfg.Backend {
+	case "", BackendAMQP:
+		return NewAMQPQueue(ctx, cfg.AMQP)
+	case BackendNATS:
+		return NewNATSQueue(ctx, cfg.NATS)
+	case BackendKafka:
+		return NewKafkaQueue(ctx, cfg.Kafka)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownBackend, cfg.Backend)
+	}
+}
+
+// settle requeues a delivery that handle left unsettled, and reports whether it was acknowledged.
+func settle(d *Delivery) bool {
+	d.Nack(true)
+	return d.acked
+}
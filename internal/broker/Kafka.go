@@ -0,0 +1,543 @@
+// This file contains KafkaQueue, the message queue of a Kafka cluster.
+//
+// Each queue is a topic of the same name, created with the brokers' default number of partitions and replication
+// factor if it does not exist. Jobs are spread over the partitions of their topic, and carry their headers as record
+// headers. The queues the server subscribes to are consumed from every partition, with the offset after each
+// acknowledged or dropped message committed for the consumer group. Kafka cannot skip a single message, so a message
+// that is rejected and requeued is delivered again after a delay, before the messages that follow it on its partition.
+//
+// The client is github.com/segmentio/kafka-go. Its Client sends each request to the broker leading the partition, or
+// coordinating the group, it concerns, and Writer produces the jobs.
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka defaults and timeouts
+const (
+	defaultKafkaGroupID = "web-server"
+	// kafkaClientID identifies the server in the brokers' logs and metrics.
+	kafkaClientID = "go-web-server"
+	// kafkaConnectTimeout is how long connecting retries before giving up, i.e while the topics are created.
+	kafkaConnectTimeout = 15 * time.Second
+	// kafkaRequestTimeout bounds a request that does not wait on the broker, i.e everything but fetches.
+	kafkaRequestTimeout = 10 * time.Second
+	// kafkaFetchWait is how long a fetch waits for a message before the broker returns an empty response.
+	kafkaFetchWait = 5 * time.Second
+	// kafkaRedeliveryDelay is how long a requeued message waits before it is delivered again.
+	kafkaRedeliveryDelay = 5 * time.Second
+	// kafkaFetchMaxBytes is the most bytes a fetch returns. A larger message is still returned on its own.
+	kafkaFetchMaxBytes = 4 * 1024 * 1024
+)
+
+// KafkaConfig is the connection of BackendKafka.
+type KafkaConfig struct {
+	// Brokers are the addresses of the brokers the cluster is discovered from, i.e ["kafka:9092"]
+	Brokers []string
+	// GroupID is the consumer group the offsets of the queues the server subscribes to are committed for, so servers
+	// sharing a group share the messages of a queue. Defaults to "web-server".
+	GroupID string
+}
+
+// KafkaQueue is the message queue of a Kafka cluster.
+type KafkaQueue struct {
+	cfg       KafkaConfig
+	transport *kafka.Transport
+	client    *kafka.Client
+	writer    *kafka.Writer
+	closed    atomic.Bool
+}
+
+// NewKafkaQueue connects to a Kafka cluster, and waits for the topics of the queues to be available.
+func NewKafkaQueue(ctx context.Context, cfg KafkaConfig) (*KafkaQueue, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("kafka requires at least one broker")
+	}
+	if cfg.GroupID == "" {
+		cfg.GroupID = defaultKafkaGroupID
+	}
+	addr := kafka.TCP(cfg.Brokers...)
+	transport := &kafka.Transport{ClientID: kafkaClientID}
+	q := &KafkaQueue{
+		cfg:       cfg,
+		transport: transport,
+		client:    &kafka.Client{Addr: addr, Timeout: kafkaRequestTimeout, Transport: transport},
+		writer: &kafka.Writer{
+			Addr:     addr,
+			Balancer: &kafka.RoundRobin{},
+			// Jobs are published one at a time, and each waits for every in sync replica to store it
+			BatchSize:    1,
+			RequiredAcks: kafka.RequireAll,
+			WriteTimeout: kafkaRequestTimeout,
+			Transport:    transport,
+		},
+	}
+
+	timeout := time.Now().Add(kafkaConnectTimeout)
+	var err error
+	for time.Now().Before(timeout) {
+		if _, err = q.topicPartitions(ctx, Queues...); err == nil {
+			return q, nil
+		}
+		select {
+		case <-ctx.Done():
+			q.Close()
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	q.Close()
+	return nil, fmt.Errorf("failed to connect to Kafka: %v", err)
+}
+
+// System names the broker.
+func (q *KafkaQueue) System() string {
+	return "kafka"
+}
+
+// topicPartitions looks up the partitions of the given topics, by topic.
+// Topics that do not exist are created, and an error is returned until they have a leader for every partition.
+func (q *KafkaQueue) topicPartitions(ctx context.Context, topics ...string) (map[string][]int, error) {
+	if q.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, kafkaRequestTimeout)
+	defer cancel()
+	metadata, err := q.client.Metadata(ctx, &kafka.MetadataRequest{Topics: topics})
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make(map[string][]int, len(topics))
+	var missing []string
+	for _, topic := range metadata.Topics {
+		if errors.Is(topic.Error, kafka.UnknownTopicOrPartition) {
+			missing = append(missing, topic.Name)
+			continue
+		}
+		if topic.Error != nil {
+			return nil, fmt.Errorf("topic %s is not available: %v", topic.Name, topic.Error)
+		}
+		for _, partition := range topic.Partitions {
+			if partition.Error != nil || partition.Leader.ID < 0 {
+				return nil, fmt.Errorf("partition %d of topic %s is not available: %v", partition.ID, topic.Name, partition.Error)
+			}
+			partitions[topic.Name] = append(partitions[topic.Name], partition.ID)
+		}
+	}
+	if len(missing) > 0 {
+		if err := q.createTopics(ctx, missing...); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("topics %v are being created", missing)
+	}
+	for _, topic := range topics {
+		if len(partitions[topic]) == 0 {
+			return nil, fmt.Errorf("topic %s has no partitions", topic)
+		}
+	}
+	return partitions, nil
+}
+
+// createTopics creates topics with the brokers' default number of partitions and replication factor.
+// A topic created by another server in the meantime is not an error.
+func (q *KafkaQueue) createTopics(ctx context.Context, topics ...string) error {
+	configs := make([]kafka.TopicConfig, len(topics))
+	for i, topic := range topics {
+		configs[i] = kafka.TopicConfig{Topic: topic, NumPartitions: -1, ReplicationFactor: -1}
+	}
+	response, err := q.client.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: configs})
+	if err != nil {
+		return fmt.Errorf("failed to create topics %v: %v", topics, err)
+	}
+	for topic, err := range response.Errors {
+		if err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			return fmt.Errorf("failed to create topic %s: %v", topic, err)
+		}
+	}
+	return nil
+}
+
+// PublishSfmJob publishes a job to the "sfm-in" topic of its tier.
+func (q *KafkaQueue) PublishSfmJob(ctx context.Context, job Job) error {
+	return q.publish(ctx, TieredQueue(QueueSfmIn, job.Tier), job)
+}
+
+// PublishNerfJob publishes a job to the "nerf-in" topic of its tier.
+func (q *KafkaQueue) PublishNerfJob(ctx context.Context, job Job) error {
+	return q.publish(ctx, TieredQueue(QueueNerfIn, job.Tier), job)
+}
+
+// PublishExportJob publishes a job to the "export-in" topic.
+func (q *KafkaQueue) PublishExportJob(ctx context.Context, job Job) error {
+	return q.publish(ctx, QueueExportIn, job)
+}
+
+// PublishRenderJob publishes a job to the "render-in" topic.
+func (q *KafkaQueue) PublishRenderJob(ctx context.Context, job Job) error {
+	return q.publish(ctx, QueueRenderIn, job)
+}
+
+// PublishDeadLetter publishes a message to the "dead-letter" topic.
+func (q *KafkaQueue) PublishDeadLetter(ctx context.Context, job Job) error {
+	return q.publish(ctx, QueueDeadLetter, job)
+}
+
+// publish produces a job to the next partition of a topic, and waits for every in sync replica to store it.
+// The job's priority is ignored, as Kafka delivers the records of a partition in the order they are stored.
+func (q *KafkaQueue) publish(ctx context.Context, topic string, job Job) error {
+	if q.closed.Load() {
+		return ErrClosed
+	}
+
+	msg := kafka.Message{Topic: topic, Value: job.Body, Headers: make([]kafka.Header, 0, len(job.Headers))}
+	for key, value := range job.Headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+	if err := q.writer.WriteMessages(ctx, msg); err != nil {
+		return fmt.Errorf("failed to produce to %s: %v", topic, err)
+	}
+	return nil
+}
+
+// Subscribe consumes every partition of a topic. Messages are handled one at a time, whichever partition they are
+// from.
+func (q *KafkaQueue) Subscribe(ctx context.Context, queue string, handle func(*Delivery)) error {
+	partitions, err := q.topicPartitions(ctx, queue)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var handleMu sync.Mutex
+	serialHandle := func(d *Delivery) {
+		handleMu.Lock()
+		defer handleMu.Unlock()
+		handle(d)
+		settle(d)
+	}
+
+	errs := make(chan error, len(partitions[queue]))
+	for _, partition := range partitions[queue] {
+		go func() {
+			errs <- q.consumePartition(ctx, queue, partition, serialHandle)
+		}()
+	}
+
+	// The first partition to fail ends the subscription, and the others with it
+	err = <-errs
+	cancel()
+	for range partitions[queue][1:] {
+		<-errs
+	}
+	if ctx.Err() != nil && err == nil {
+		return nil
+	}
+	return err
+}
+
+// consumePartition consumes a partition from the offset committed for the group, until ctx is done or a request
+// fails. Returns nil once ctx is done.
+func (q *KafkaQueue) consumePartition(ctx context.Context, topic string, partition int, handle func(*Delivery)) error {
+	offset, err := q.committedOffset(ctx, topic, partition)
+	if err != nil {
+		return err
+	}
+
+	for ctx.Err() == nil {
+		records, err := q.fetch(ctx, topic, partition, offset)
+		if errors.Is(err, kafka.OffsetOutOfRange) {
+			// The committed offset was removed by retention, so start from the oldest message left
+			if offset, err = q.listOffset(ctx, topic, partition, kafka.FirstOffset); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		for _, record := range records {
+			if record.offset < offset {
+				// Fetches return whole batches, which may start before the offset
+				continue
+			}
+			next := record.offset + 1
+			requeued := false
+			d := newDelivery(
+				record.value,
+				record.headers,
+				func() error { return q.commitOffset(ctx, topic, partition, next) },
+				func(requeue bool) error {
+					if requeue {
+						requeued = true
+						return nil
+					}
+					return q.commitOffset(ctx, topic, partition, next)
+				},
+			)
+			handle(d)
+			if requeued {
+				break
+			}
+			offset = next
+		}
+
+		// A requeued message stops its partition until it is delivered again
+		if len(records) > 0 && records[len(records)-1].offset >= offset {
+			select {
+			case <-ctx.Done():
+			case <-time.After(kafkaRedeliveryDelay):
+			}
+		}
+	}
+	return nil
+}
+
+// SubscribeBroadcast reads every partition of a topic from its latest offset, without a consumer group, so every
+// server receives every message published while it is subscribed. Messages are handled one at a time.
+func (q *KafkaQueue) SubscribeBroadcast(ctx context.Context, exchange string, handle func(body []byte, headers map[string]string)) error {
+	partitions, err := q.topicPartitions(ctx, exchange)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var handleMu sync.Mutex
+	serialHandle := func(record kafkaRecord) {
+		handleMu.Lock()
+		defer handleMu.Unlock()
+		handle(record.value, record.headers)
+	}
+
+	errs := make(chan error, len(partitions[exchange]))
+	for _, partition := range partitions[exchange] {
+		go func() {
+			errs <- q.broadcastPartition(ctx, exchange, partition, serialHandle)
+		}()
+	}
+
+	err = <-errs
+	cancel()
+	for range partitions[exchange][1:] {
+		<-errs
+	}
+	if ctx.Err() != nil && err == nil {
+		return nil
+	}
+	return err
+}
+
+// broadcastPartition reads a partition from its latest offset until ctx is done or a request fails. Returns nil once
+// ctx is done.
+func (q *KafkaQueue) broadcastPartition(ctx context.Context, topic string, partition int, handle func(kafkaRecord)) error {
+	offset, err := q.listOffset(ctx, topic, partition, kafka.LastOffset)
+	if err != nil {
+		return err
+	}
+
+	for ctx.Err() == nil {
+		records, err := q.fetch(ctx, topic, partition, offset)
+		if errors.Is(err, kafka.OffsetOutOfRange) {
+			// Fell behind retention, the messages in between are lost anyway
+			if offset, err = q.listOffset(ctx, topic, partition, kafka.LastOffset); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, record := range records {
+			if record.offset < offset {
+				continue
+			}
+			handle(record)
+			offset = record.offset + 1
+		}
+	}
+	return nil
+}
+
+// kafkaRecord is a record read from a partition.
+type kafkaRecord struct {
+	offset  int64
+	value   []byte
+	headers map[string]string
+}
+
+// fetch reads the records of a partition from an offset, waiting up to kafkaFetchWait for some to arrive.
+// Returns kafka.OffsetOutOfRange if the partition has no record at the offset.
+func (q *KafkaQueue) fetch(ctx context.Context, topic string, partition int, offset int64) ([]kafkaRecord, error) {
+	if q.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, kafkaFetchWait+kafkaRequestTimeout)
+	defer cancel()
+	response, err := q.client.Fetch(ctx, &kafka.FetchRequest{
+		Topic:     topic,
+		Partition: partition,
+		Offset:    offset,
+		MinBytes:  1,
+		MaxBytes:  kafkaFetchMaxBytes,
+		MaxWait:   kafkaFetchWait,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if errors.Is(response.Error, kafka.OffsetOutOfRange) {
+		return nil, response.Error
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("failed to fetch from %s: %w", topic, response.Error)
+	}
+
+	var records []kafkaRecord
+	for {
+		record, err := response.Records.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode records of %s: %v", topic, err)
+		}
+
+		r := kafkaRecord{offset: record.Offset, headers: make(map[string]string, len(record.Headers))}
+		if record.Value != nil {
+			if r.value, err = io.ReadAll(record.Value); err != nil {
+				return nil, err
+			}
+		}
+		for _, header := range record.Headers {
+			r.headers[header.Key] = string(header.Value)
+		}
+		records = append(records, r)
+	}
+}
+
+// listOffset returns the offset of the oldest message of a partition for kafka.FirstOffset, or the offset the next
+// message will have for kafka.LastOffset.
+func (q *KafkaQueue) listOffset(ctx context.Context, topic string, partition int, timestamp int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, kafkaRequestTimeout)
+	defer cancel()
+	response, err := q.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{topic: {{Partition: partition, Timestamp: timestamp}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, offsets := range response.Topics[topic] {
+		if offsets.Partition != partition {
+			continue
+		}
+		if offsets.Error != nil {
+			return 0, fmt.Errorf("failed to list offsets of %s: %v", topic, offsets.Error)
+		}
+		if timestamp == kafka.FirstOffset {
+			return offsets.FirstOffset, nil
+		}
+		return offsets.LastOffset, nil
+	}
+	return 0, fmt.Errorf("topic %s has no partition %d", topic, partition)
+}
+
+// committedOffset returns the offset committed for the group on a partition, or its earliest offset if none was.
+func (q *KafkaQueue) committedOffset(ctx context.Context, topic string, partition int) (int64, error) {
+	requestCtx, cancel := context.WithTimeout(ctx, kafkaRequestTimeout)
+	defer cancel()
+	response, err := q.client.OffsetFetch(requestCtx, &kafka.OffsetFetchRequest{
+		GroupID: q.cfg.GroupID,
+		Topics:  map[string][]int{topic: {partition}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if response.Error != nil {
+		return 0, fmt.Errorf("failed to fetch offset of %s: %v", topic, response.Error)
+	}
+
+	offset := int64(-1)
+	for _, committed := range response.Topics[topic] {
+		if committed.Partition != partition {
+			continue
+		}
+		if committed.Error != nil {
+			return 0, fmt.Errorf("failed to fetch offset of %s: %v", topic, committed.Error)
+		}
+		offset = committed.CommittedOffset
+	}
+	if offset < 0 {
+		return q.listOffset(ctx, topic, partition, kafka.FirstOffset)
+	}
+	return offset, nil
+}
+
+// commitOffset commits the offset of the next message to consume from a partition for the group.
+// The group has no members, so the offset is committed outside of any generation.
+func (q *KafkaQueue) commitOffset(ctx context.Context, topic string, partition int, offset int64) error {
+	if q.closed.Load() {
+		return ErrClosed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, kafkaRequestTimeout)
+	defer cancel()
+	response, err := q.client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      q.cfg.GroupID,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{topic: {{Partition: partition, Offset: offset}}},
+	})
+	if err != nil {
+		return err
+	}
+	for _, committed := range response.Topics[topic] {
+		if committed.Error != nil {
+			return fmt.Errorf("failed to commit offset of %s: %v", topic, committed.Error)
+		}
+	}
+	return nil
+}
+
+// QueueDepth is not supported, as the messages waiting in a topic depend on the group consuming it, and the workers'
+// groups are not known. Use the consumer lag reported by Kafka instead.
+func (q *KafkaQueue) QueueDepth(ctx context.Context, queue string) (int, error) {
+	return 0, ErrQueueDepthUnsupported
+}
+
+// Ping looks up the metadata of the "sfm-in" topic, which fails if no broker can be reached or the topic has a
+// partition without a leader.
+func (q *KafkaQueue) Ping(ctx context.Context) error {
+	_, err := q.topicPartitions(ctx, QueueSfmIn)
+	return err
+}
+
+// Close closes the connections to the brokers.
+func (q *KafkaQueue) Close() error {
+	if !q.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	err := q.writer.Close()
+	q.transport.CloseIdleConnections()
+	return err
+}
@@ -0,0 +1,8 @@
+// Package broker contains the message brokers jobs are exchanged with the workers through, behind the MessageQueue
+// interface. AMQPQueue uses RabbitMQ, NATSQueue uses a NATS JetStream stream, and KafkaQueue uses Kafka topics.
+// Every broker carries the same queues: "sfm-in" and "nerf-in" with the jobs published to the workers, and "sfm-out",
+// "nerf-out", and "progress-out" with their output. The job queues are split by tier, i.e "sfm-in.priority", "sfm-in",
+// and "sfm-in.free", see TieredQueue. Messages are JSON and identical across brokers, so a worker only has to change
+// how it connects to run on another broker. Besides queues, the "preview" exchange broadcasts the training previews of
+// the workers to every server.
+package broker
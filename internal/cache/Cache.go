@@ -0,0 +1,47 @@
+// This file contains the Cache interface, and the creation of a cache from its configuration.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrMiss is returned when no entry is cached under a key, or it expired.
+	ErrMiss = errors.New("cache miss")
+)
+
+// Cache is a key value cache with expiring entries. Implementations must be safe for concurrent use.
+type Cache interface {
+	// Get returns the value cached under key. Returns ErrMiss if there is none.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set caches value under key for ttl, replacing any entry cached under it.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the entries cached under keys. Deleting a missing entry is not an error.
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// DefaultLRUSize is the most entries of the in-process cache unless configured otherwise.
+const DefaultLRUSize = 10000
+
+// Config selects and configures the cache.
+type Config struct {
+	// URL of the Redis server, i.e "redis://:password@redis:6379/0", or "rediss://..." to require TLS. The in-process
+	// cache is used if empty.
+	RedisURL string
+	// most entries of the in-process cache, DefaultLRUSize if <= 0
+	LRUSize int
+}
+
+// New creates the cache selected by cfg. A Redis cache connects on first use, so an unreachable server does not
+// prevent startup.
+//
+// Returns an error if the Redis URL is malformed.
+func New(cfg Config) (Cache, error) {
+	if cfg.RedisURL == "" {
+		return NewLRUCache(cfg.LRUSize), nil
+	}
+	return NewRedisCache(cfg.RedisURL)
+}
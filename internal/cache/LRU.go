@@ -0,0 +1,99 @@
+// This file contains LRUCache, the in-process cache.
+//
+// Entries are evicted least recently used first once the cache is full, and expired entries are dropped when read.
+// The cache is not shared between servers, so an invalidation on one server does not reach the others, and their
+// entries stay until they expire.
+
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// LRUCache is an in-process least recently used cache.
+type LRUCache struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List // of *lruEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+// lruEntry is an entry of an LRUCache.
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewLRUCache creates an in-process cache of at most size entries, DefaultLRUSize if size <= 0.
+func NewLRUCache(size int) *LRUCache {
+	if size <= 0 {
+		size = DefaultLRUSize
+	}
+	return &LRUCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value cached under key. Returns ErrMiss if there is none.
+func (c *LRUCache) Get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.remove(element)
+		return nil, ErrMiss
+	}
+	c.order.MoveToFront(element)
+	return entry.value, nil
+}
+
+// Set caches value under key for ttl, evicting the least recently used entry if the cache is full.
+func (c *LRUCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(element)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+// Delete removes the entries cached under keys.
+func (c *LRUCache) Delete(_ context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+		}
+	}
+	return nil
+}
+
+// remove removes an entry. The caller must hold mu.
+func (c *LRUCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*lruEntry).key)
+}
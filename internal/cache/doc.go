@@ -0,0 +1,5 @@
+// Package cache contains the caches of values derived from the database and the disk, behind the Cache interface.
+// RedisCache keeps entries in a Redis server shared by every server, and LRUCache keeps them in process, which is
+// used when no Redis server is configured. Entries are opaque bytes with a time to live, so callers bound how stale
+// a value can get and invalidate entries explicitly when the value changes.
+package cache
@@ -0,0 +1,115 @@
+// This file contains the reloading of the config on SIGHUP.
+//
+// Only the tunables the services read on every use are reloaded: the chunk size and the Limits. The data directory,
+// the MongoDB and AMQP URIs, and the CORS origins are only read at startup, so a change to them is logged and ignored
+// until the server is restarted. A config that fails to load or validate is rejected as a whole, and the running
+// config is kept.
+//
+// The environment is not read again, and takes precedence over the file, so tunables meant to be reloaded should be
+// set in the file only.
+
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+// ApplyFunc applies the tunables of a reloaded config to the running services. prev is the running config, so values
+// that did not change can be left alone (i.e a limit an admin changed at runtime).
+type ApplyFunc func(prev, next *Config)
+
+// Reloader reloads the config from its file, see the file comment.
+type Reloader struct {
+	path   string
+	apply  ApplyFunc
+	logger *log.Logger
+
+	mu      sync.Mutex
+	current *Config
+}
+
+// NewReloader creates a Reloader of the config at path, whose running config is current. apply is called with every
+// config reloaded.
+func NewReloader(path string, current *Config, apply ApplyFunc, logger *log.Logger) *Reloader {
+	return &Reloader{
+		path:    path,
+		apply:   apply,
+		logger:  logger,
+		current: current,
+	}
+}
+
+// Current returns the running config. It must not be modified.
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload loads the config again, and applies its tunables. Values that require a restart keep their running value.
+//
+// Returns ErrInvalidConfig, keeping the running config, if the config fails to load or validate.
+func (r *Reloader) Reload() error {
+	next, err := Load(r.path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range r.current.restartRequired(next) {
+		r.logger.Warnf("Config %s changed, restart the server to apply it", name)
+	}
+	next.DataDir = r.current.DataDir
+	next.MongoURI = r.current.MongoURI
+	next.AMQPURI = r.current.AMQPURI
+	next.CORSAllowedOrigins = r.current.CORSAllowedOrigins
+
+	r.apply(r.current, next)
+	r.current = next
+	r.logger.Info("Config reloaded")
+	return nil
+}
+
+// Run reloads the config whenever SIGHUP is received, until ctx is done.
+func (r *Reloader) Run(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			if err := r.Reload(); err != nil {
+				r.logger.Errorf("Failed to reload config, keeping the running config: %v", err)
+			}
+		}
+	}
+}
+
+// restartRequired returns the names of the values that changed in next, but are only read at startup.
+func (c *Config) restartRequired(next *Config) []string {
+	var changed []string
+	if c.DataDir != next.DataDir {
+		changed = append(changed, "data_dir")
+	}
+	if c.MongoURI != next.MongoURI {
+		changed = append(changed, "mongo_uri")
+	}
+	if c.AMQPURI != next.AMQPURI {
+		changed = append(changed, "amqp_uri")
+	}
+	if !slices.Equal(c.CORSAllowedOrigins, next.CORSAllowedOrigins) {
+		changed = append(changed, "cors_allowed_origins")
+	}
+	return changed
+}
@@ -0,0 +1,4 @@
+// Package config contains the configuration of the web server, loaded from a JSON file with environment variable
+// overrides and validated at startup. The Reloader reloads it on SIGHUP, applying the tunables that do not require a
+// restart (i.e the chunk size and limits) to the running services.
+package config
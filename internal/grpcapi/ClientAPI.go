@@ -0,0 +1,229 @@
+// This file contains the methods of the ClientAPI service, see proto/vidgonerf/v1/client.proto.
+
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+)
+
+// maxDownloadChunk is the most bytes of a file sent per message, so messages stay below the default limit of gRPC
+// clients whatever chunk size is requested.
+const maxDownloadChunk = 3 * 1024 * 1024
+
+// Login handles the Login method.
+func (s *Server) Login(ctx context.Context, req *LoginRequest) (*LoginResponse, error) {
+	tokens, err := s.clientService.LoginUser(ctx, req.GetUsername(), req.GetPassword())
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    tokens.TokenType,
+		ExpiresIn:    int32(tokens.ExpiresIn),
+	}, nil
+}
+
+// UploadVideo handles the UploadVideo method, as a resumable upload that is started with the header, appended to
+// with every chunk, and completed once the client closes the stream. The upload is removed if the call fails, as it
+// cannot be resumed over gRPC.
+func (s *Server) UploadVideo(stream grpc.ClientStreamingServer[UploadVideoRequest, UploadVideoResponse]) (err error) {
+	ctx := stream.Context()
+	userID, err := s.authenticate(ctx, user.APIKeyScopeUpload)
+	if err != nil {
+		return err
+	}
+
+	req, err := stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return status.Error(codes.InvalidArgument, "missing request message")
+	}
+	if err != nil {
+		return err
+	}
+	header := req.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "the first message must carry the upload header")
+	}
+	saveIterations := make([]int, len(header.SaveIterations))
+	for i, iteration := range header.SaveIterations {
+		saveIterations[i] = int(iteration)
+	}
+
+	orgID := primitive.NilObjectID
+	if header.OrgId != "" {
+		if orgID, err = primitive.ObjectIDFromHex(header.OrgId); err != nil {
+			return status.Error(codes.InvalidArgument, "invalid organization ID")
+		}
+	}
+
+	upload, err := s.clientService.StartUpload(ctx, userID, orgID, header.FileName, header.Size, header.TrainingMode, header.OutputTypes,
+		saveIterations, int(header.TotalIterations), int(header.FrameSampleRate), int(header.TargetFrameCount), header.SceneName, header.Priority)
+	if err != nil {
+		return err
+	}
+	uploadID, err := primitive.ObjectIDFromHex(upload.UploadID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// Removed even if the call was canceled
+		if abortErr := s.clientService.AbortUpload(context.WithoutCancel(ctx), userID, uploadID); abortErr != nil {
+			s.logger.Ctx(ctx).Debugf("Failed to remove upload %s of a failed call: %v", uploadID.Hex(), abortErr)
+		}
+	}()
+
+	offset := upload.Offset
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if req.GetHeader() != nil {
+			return status.Error(codes.InvalidArgument, "only the first message may carry the upload header")
+		}
+		chunk := req.GetChunk()
+		if len(chunk) == 0 {
+			continue
+		}
+
+		progress, err := s.clientService.AppendUpload(ctx, userID, uploadID, offset, bytes.NewReader(chunk))
+		if err != nil {
+			return err
+		}
+		offset = progress.Offset
+	}
+
+	sceneID, err := s.clientService.CompleteUpload(ctx, userID, uploadID)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&UploadVideoResponse{SceneId: sceneID})
+}
+
+// GetSceneMetadata handles the GetSceneMetadata method. Resources are sorted by output type, then iteration.
+func (s *Server) GetSceneMetadata(ctx context.Context, req *GetSceneMetadataRequest) (*SceneMetadata, error) {
+	userID, err := s.authenticate(ctx, user.APIKeyScopeRead)
+	if err != nil {
+		return nil, err
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.GetSceneId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid scene ID")
+	}
+
+	metadata, err := s.clientService.GetSceneMetadata(ctx, userID, sceneID, req.GetChunkSize(), int(req.GetVersion()))
+	if err != nil {
+		return nil, err
+	}
+
+	response := &SceneMetadata{ChunkSize: metadata.ChunkSize}
+	for outputType, iterations := range metadata.Resources {
+		for iteration, info := range iterations {
+			n, err := strconv.Atoi(iteration)
+			if err != nil {
+				continue
+			}
+			response.Resources = append(response.Resources, &ResourceInfo{
+				OutputType:    outputType,
+				Iteration:     int32(n),
+				Exists:        info.Exists,
+				Size:          info.Size,
+				Chunks:        int32(info.Chunks),
+				LastChunkSize: info.LastChunkSize,
+				Sha256:        info.SHA256,
+			})
+		}
+	}
+	sort.Slice(response.Resources, func(i, j int) bool {
+		a, b := response.Resources[i], response.Resources[j]
+		if a.OutputType != b.OutputType {
+			return a.OutputType < b.OutputType
+		}
+		return a.Iteration < b.Iteration
+	})
+	return response, nil
+}
+
+// DownloadResource handles the DownloadResource method. The bytes sent are counted against the user's daily download
+// quota, including those of a download that failed part way.
+func (s *Server) DownloadResource(req *DownloadResourceRequest, stream grpc.ServerStreamingServer[ResourceChunk]) error {
+	ctx := stream.Context()
+	userID, err := s.authenticate(ctx, user.APIKeyScopeRead)
+	if err != nil {
+		return err
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.GetSceneId())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "invalid scene ID")
+	}
+
+	output, err := s.clientService.GetSceneOutput(ctx, userID, sceneID, req.GetOutputType(), req.GetIteration(), req.GetFormat(), int(req.GetVersion()))
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(output.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var sent int64
+	defer func() {
+		s.metrics.DownloadBytesTotal.WithLabelValues(req.GetOutputType()).Add(float64(sent))
+		s.clientService.RecordDownload(context.WithoutCancel(ctx), userID, sent)
+	}()
+
+	description := &ResourceDescription{
+		FileName:  output.FileName,
+		Size:      info.Size(),
+		Iteration: int32(output.Iteration),
+		Final:     output.Final,
+	}
+	buf := make([]byte, min(s.clientService.ResolveChunkSize(req.GetChunkSize()), maxDownloadChunk))
+	for {
+		n, err := io.ReadFull(file, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return err
+		}
+		// The description is sent even for an empty file
+		if n == 0 && description == nil {
+			return nil
+		}
+
+		// The server has no stats handler, so Send encodes the message before returning and buf can be reused
+		if err := stream.Send(&ResourceChunk{Description: description, Offset: sent, Data: buf[:n]}); err != nil {
+			return err
+		}
+		sent += int64(n)
+		description = nil
+		if n < len(buf) {
+			return nil
+		}
+	}
+}
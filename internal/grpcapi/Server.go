@@ -0,0 +1,177 @@
+// This file contains the gRPC server.
+//
+// Every call goes through the interceptors of the server, which trace it like an HTTP request, continuing the trace
+// of the traceparent metadata, and end it with the status its error maps to, see Status.go.
+
+package grpcapi
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// apiKeyMetadata is the metadata carrying the API key of a call, as the X-API-Key header of REST.
+const apiKeyMetadata = "x-api-key"
+
+// Server serves the ClientAPI service.
+type Server struct {
+	UnimplementedClientAPIServer
+	clientService *services.ClientService
+	metrics       *metrics.Metrics
+	logger        *log.Logger
+	server        *grpc.Server
+}
+
+// NewServer creates a new Server serving the ClientAPI service from the given client service, over TLS with the
+// given certificate and key files.
+func NewServer(clientService *services.ClientService, appMetrics *metrics.Metrics, logger *log.Logger, certFile, keyFile string) (*Server, error) {
+	logger.Debug("Creating new gRPC server instance")
+
+	creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	s := &Server{
+		clientService: clientService,
+		metrics:       appMetrics,
+		logger:        logger,
+	}
+	s.server = grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.ChainUnaryInterceptor(s.interceptUnary),
+		grpc.ChainStreamInterceptor(s.interceptStream),
+	)
+	RegisterClientAPIServer(s.server, s)
+	return s, nil
+}
+
+// Run serves the API on the given address, until it fails or Shutdown is called.
+func (s *Server) Run(ip string, port int) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("Serving gRPC on %s", listener.Addr())
+	if err := s.server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections, and waits up to timeout for in-flight calls to complete before closing the
+// remaining connections. Run returns once Shutdown was called.
+func (s *Server) Shutdown(timeout time.Duration) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-time.After(timeout):
+		s.server.Stop()
+		return fmt.Errorf("in-flight calls did not complete within %s", timeout)
+	}
+}
+
+// interceptUnary serves a call with a single request and response.
+func (s *Server) interceptUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var resp any
+	err := s.serve(ctx, info.FullMethod, func(ctx context.Context) error {
+		var err error
+		resp, err = handler(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// interceptStream serves a call streaming its requests or responses.
+func (s *Server) interceptStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return s.serve(stream.Context(), info.FullMethod, func(ctx context.Context) error {
+		return handler(srv, &serverStream{ServerStream: stream, ctx: ctx})
+	})
+}
+
+// serverStream is a stream whose context carries the span of its call.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// serve traces and logs a call served by handle, and returns the status it ends with.
+func (s *Server) serve(ctx context.Context, fullMethod string, handle func(ctx context.Context) error) error {
+	method := strings.TrimPrefix(fullMethod, "/")
+	ctx = tracing.ContextWithTraceparent(ctx, firstMetadata(ctx, tracing.TraceparentHeader))
+	ctx, span := tracing.Start(ctx, method, tracing.KindServer)
+	if span != nil {
+		traceID := span.SpanContext().TraceID
+		ctx = log.WithFields(ctx, log.FieldTraceID, hex.EncodeToString(traceID[:]))
+		grpc.SetHeader(ctx, metadata.Pairs(tracing.TraceparentHeader, span.SpanContext().Traceparent()))
+	}
+
+	err := handle(ctx)
+
+	st := statusOf(err)
+	logger := s.logger.Ctx(ctx)
+	switch st.Code() {
+	case codes.OK:
+	case codes.Internal, codes.Unavailable:
+		logger.Errorf("gRPC %s failed: %v", method, err)
+	default:
+		logger.Debugf("gRPC %s failed: %v", method, err)
+	}
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.method", method)
+	span.SetAttribute("rpc.grpc.status_code", int(st.Code()))
+	if st.Code() == codes.Internal || st.Code() == codes.Unavailable {
+		span.RecordError(err)
+	}
+	span.End()
+
+	return st.Err()
+}
+
+// firstMetadata returns the first value of a key of the metadata of a call, or "" if it has none.
+func firstMetadata(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// authenticate returns the user making a call, from the API key of the given scope in the x-api-key metadata, or
+// otherwise from the access token in the authorization metadata.
+func (s *Server) authenticate(ctx context.Context, scope string) (primitive.ObjectID, error) {
+	if key := firstMetadata(ctx, apiKeyMetadata); key != "" {
+		return s.clientService.VerifyAPIKey(ctx, key, scope)
+	}
+
+	token, ok := strings.CutPrefix(firstMetadata(ctx, "authorization"), "Bearer ")
+	if !ok || token == "" {
+		return primitive.NilObjectID, status.Error(codes.Unauthenticated, "missing authorization metadata, expected `Bearer <token>`")
+	}
+	return s.clientService.VerifyAccessToken(token)
+}
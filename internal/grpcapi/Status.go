@@ -0,0 +1,53 @@
+// This file contains the statuses calls end with, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html.
+//
+// Errors of the ClientService are mapped to a code by their kind, as the web package maps them to HTTP statuses, and
+// their client safe message is sent along.
+
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
+)
+
+// errorCodes maps the kinds of ClientService errors to status codes.
+var errorCodes = map[error]codes.Code{
+	services.ErrNotFound:      codes.NotFound,
+	services.ErrUnauthorized:  codes.Unauthenticated,
+	services.ErrForbidden:     codes.PermissionDenied,
+	services.ErrValidation:    codes.InvalidArgument,
+	services.ErrConflict:      codes.FailedPrecondition,
+	services.ErrQuotaExceeded: codes.ResourceExhausted,
+	services.ErrRateLimited:   codes.ResourceExhausted,
+	services.ErrUpstream:      codes.Unavailable,
+	services.ErrInternal:      codes.Internal,
+}
+
+// statusOf returns the status a call that returned err ends with. Statuses, returned by handlers failing for a reason
+// of their own or by the streams of a call, are kept as they are.
+func statusOf(err error) *status.Status {
+	if err == nil {
+		return status.New(codes.OK, "")
+	}
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.New(codes.DeadlineExceeded, "deadline exceeded")
+	case errors.Is(err, context.Canceled):
+		return status.New(codes.Canceled, "call canceled")
+	}
+
+	svcErr := services.AsError(err)
+	code, ok := errorCodes[svcErr.Kind]
+	if !ok {
+		code = codes.Internal
+	}
+	return status.New(code, svcErr.Message)
+}
@@ -0,0 +1,982 @@
+// The gRPC API of the web server, for native clients such as desktop capture tools. It exposes the same operations as
+// the REST routes of the same name, with the same errors, mapped to gRPC status codes.
+//
+// The Go code of the server is generated from this file into internal/grpcapi with protoc, see the go:generate
+// directive of internal/grpcapi/doc.go, and must be generated again after a change. Field numbers must never be reused.
+//
+// Authenticated methods expect either an access token in the `authorization` metadata ("Bearer <token>"), as
+// returned by Login, or an API key in the `x-api-key` metadata, with the scope noted on each method.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: vidgonerf/v1/client.proto
+
+package grpcapi
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type LoginRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Password      string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LoginRequest) Reset() {
+	*x = LoginRequest{}
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LoginRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoginRequest) ProtoMessage() {}
+
+func (x *LoginRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoginRequest.ProtoReflect.Descriptor instead.
+func (*LoginRequest) Descriptor() ([]byte, []int) {
+	return file_vidgonerf_v1_client_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *LoginRequest) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *LoginRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+type LoginResponse struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	AccessToken  string                 `protobuf:"bytes,1,opt,name=access_token,json=accessToken,proto3" json:"access_token,omitempty"`
+	RefreshToken string                 `protobuf:"bytes,2,opt,name=refresh_token,json=refreshToken,proto3" json:"refresh_token,omitempty"`
+	TokenType    string                 `protobuf:"bytes,3,opt,name=token_type,json=tokenType,proto3" json:"token_type,omitempty"`
+	// lifetime of the access token, in seconds
+	ExpiresIn     int32 `protobuf:"varint,4,opt,name=expires_in,json=expiresIn,proto3" json:"expires_in,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LoginResponse) Reset() {
+	*x = LoginResponse{}
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LoginResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoginResponse) ProtoMessage() {}
+
+func (x *LoginResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoginResponse.ProtoReflect.Descriptor instead.
+func (*LoginResponse) Descriptor() ([]byte, []int) {
+	return file_vidgonerf_v1_client_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LoginResponse) GetAccessToken() string {
+	if x != nil {
+		return x.AccessToken
+	}
+	return ""
+}
+
+func (x *LoginResponse) GetRefreshToken() string {
+	if x != nil {
+		return x.RefreshToken
+	}
+	return ""
+}
+
+func (x *LoginResponse) GetTokenType() string {
+	if x != nil {
+		return x.TokenType
+	}
+	return ""
+}
+
+func (x *LoginResponse) GetExpiresIn() int32 {
+	if x != nil {
+		return x.ExpiresIn
+	}
+	return 0
+}
+
+type UploadVideoRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*UploadVideoRequest_Header
+	//	*UploadVideoRequest_Chunk
+	Payload       isUploadVideoRequest_Payload `protobuf_oneof:"payload"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadVideoRequest) Reset() {
+	*x = UploadVideoRequest{}
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadVideoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadVideoRequest) ProtoMessage() {}
+
+func (x *UploadVideoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadVideoRequest.ProtoReflect.Descriptor instead.
+func (*UploadVideoRequest) Descriptor() ([]byte, []int) {
+	return file_vidgonerf_v1_client_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *UploadVideoRequest) GetPayload() isUploadVideoRequest_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *UploadVideoRequest) GetHeader() *UploadHeader {
+	if x != nil {
+		if x, ok := x.Payload.(*UploadVideoRequest_Header); ok {
+			return x.Header
+		}
+	}
+	return nil
+}
+
+func (x *UploadVideoRequest) GetChunk() []byte {
+	if x != nil {
+		if x, ok := x.Payload.(*UploadVideoRequest_Chunk); ok {
+			return x.Chunk
+		}
+	}
+	return nil
+}
+
+type isUploadVideoRequest_Payload interface {
+	isUploadVideoRequest_Payload()
+}
+
+type UploadVideoRequest_Header struct {
+	Header *UploadHeader `protobuf:"bytes,1,opt,name=header,proto3,oneof"`
+}
+
+type UploadVideoRequest_Chunk struct {
+	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+}
+
+func (*UploadVideoRequest_Header) isUploadVideoRequest_Payload() {}
+
+func (*UploadVideoRequest_Chunk) isUploadVideoRequest_Payload() {}
+
+// UploadHeader describes the video and its training config. Unset config values use their defaults, as in REST.
+type UploadHeader struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// name of the video file, which must end in .mp4
+	FileName string `protobuf:"bytes,1,opt,name=file_name,json=fileName,proto3" json:"file_name,omitempty"`
+	// size of the video, in bytes
+	Size             int64    `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	TrainingMode     string   `protobuf:"bytes,3,opt,name=training_mode,json=trainingMode,proto3" json:"training_mode,omitempty"`
+	OutputTypes      []string `protobuf:"bytes,4,rep,name=output_types,json=outputTypes,proto3" json:"output_types,omitempty"`
+	SaveIterations   []int32  `protobuf:"varint,5,rep,packed,name=save_iterations,json=saveIterations,proto3" json:"save_iterations,omitempty"`
+	TotalIterations  int32    `protobuf:"varint,6,opt,name=total_iterations,json=totalIterations,proto3" json:"total_iterations,omitempty"`
+	FrameSampleRate  int32    `protobuf:"varint,7,opt,name=frame_sample_rate,json=frameSampleRate,proto3" json:"frame_sample_rate,omitempty"`
+	TargetFrameCount int32    `protobuf:"varint,8,opt,name=target_frame_count,json=targetFrameCount,proto3" json:"target_frame_count,omitempty"`
+	SceneName        string   `protobuf:"bytes,9,opt,name=scene_name,json=sceneName,proto3" json:"scene_name,omitempty"`
+	Priority         string   `protobuf:"bytes,10,opt,name=priority,proto3" json:"priority,omitempty"`
+	// ID of an organization to upload the scene into the workspace of, if any
+	OrgId         string `protobuf:"bytes,11,opt,name=org_id,json=orgId,proto3" json:"org_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadHeader) Reset() {
+	*x = UploadHeader{}
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadHeader) ProtoMessage() {}
+
+func (x *UploadHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadHeader.ProtoReflect.Descriptor instead.
+func (*UploadHeader) Descriptor() ([]byte, []int) {
+	return file_vidgonerf_v1_client_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *UploadHeader) GetFileName() string {
+	if x != nil {
+		return x.FileName
+	}
+	return ""
+}
+
+func (x *UploadHeader) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *UploadHeader) GetTrainingMode() string {
+	if x != nil {
+		return x.TrainingMode
+	}
+	return ""
+}
+
+func (x *UploadHeader) GetOutputTypes() []string {
+	if x != nil {
+		return x.OutputTypes
+	}
+	return nil
+}
+
+func (x *UploadHeader) GetSaveIterations() []int32 {
+	if x != nil {
+		return x.SaveIterations
+	}
+	return nil
+}
+
+func (x *UploadHeader) GetTotalIterations() int32 {
+	if x != nil {
+		return x.TotalIterations
+	}
+	return 0
+}
+
+func (x *UploadHeader) GetFrameSampleRate() int32 {
+	if x != nil {
+		return x.FrameSampleRate
+	}
+	return 0
+}
+
+func (x *UploadHeader) GetTargetFrameCount() int32 {
+	if x != nil {
+		return x.TargetFrameCount
+	}
+	return 0
+}
+
+func (x *UploadHeader) GetSceneName() string {
+	if x != nil {
+		return x.SceneName
+	}
+	return ""
+}
+
+func (x *UploadHeader) GetPriority() string {
+	if x != nil {
+		return x.Priority
+	}
+	return ""
+}
+
+func (x *UploadHeader) GetOrgId() string {
+	if x != nil {
+		return x.OrgId
+	}
+	return ""
+}
+
+type UploadVideoResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SceneId       string                 `protobuf:"bytes,1,opt,name=scene_id,json=sceneId,proto3" json:"scene_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadVideoResponse) Reset() {
+	*x = UploadVideoResponse{}
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadVideoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadVideoResponse) ProtoMessage() {}
+
+func (x *UploadVideoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadVideoResponse.ProtoReflect.Descriptor instead.
+func (*UploadVideoResponse) Descriptor() ([]byte, []int) {
+	return file_vidgonerf_v1_client_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UploadVideoResponse) GetSceneId() string {
+	if x != nil {
+		return x.SceneId
+	}
+	return ""
+}
+
+type GetSceneMetadataRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	SceneId string                 `protobuf:"bytes,1,opt,name=scene_id,json=sceneId,proto3" json:"scene_id,omitempty"`
+	// chunk size the chunk counts are computed for, in bytes, the server default if 0
+	ChunkSize int64 `protobuf:"varint,2,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
+	// training run of the scene, the current run if 0
+	Version       int32 `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSceneMetadataRequest) Reset() {
+	*x = GetSceneMetadataRequest{}
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSceneMetadataRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSceneMetadataRequest) ProtoMessage() {}
+
+func (x *GetSceneMetadataRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSceneMetadataRequest.ProtoReflect.Descriptor instead.
+func (*GetSceneMetadataRequest) Descriptor() ([]byte, []int) {
+	return file_vidgonerf_v1_client_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetSceneMetadataRequest) GetSceneId() string {
+	if x != nil {
+		return x.SceneId
+	}
+	return ""
+}
+
+func (x *GetSceneMetadataRequest) GetChunkSize() int64 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+func (x *GetSceneMetadataRequest) GetVersion() int32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+type SceneMetadata struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ChunkSize     int64                  `protobuf:"varint,1,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
+	Resources     []*ResourceInfo        `protobuf:"bytes,2,rep,name=resources,proto3" json:"resources,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SceneMetadata) Reset() {
+	*x = SceneMetadata{}
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SceneMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SceneMetadata) ProtoMessage() {}
+
+func (x *SceneMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SceneMetadata.ProtoReflect.Descriptor instead.
+func (*SceneMetadata) Descriptor() ([]byte, []int) {
+	return file_vidgonerf_v1_client_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SceneMetadata) GetChunkSize() int64 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+func (x *SceneMetadata) GetResources() []*ResourceInfo {
+	if x != nil {
+		return x.Resources
+	}
+	return nil
+}
+
+type ResourceInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	OutputType    string                 `protobuf:"bytes,1,opt,name=output_type,json=outputType,proto3" json:"output_type,omitempty"`
+	Iteration     int32                  `protobuf:"varint,2,opt,name=iteration,proto3" json:"iteration,omitempty"`
+	Exists        bool                   `protobuf:"varint,3,opt,name=exists,proto3" json:"exists,omitempty"`
+	Size          int64                  `protobuf:"varint,4,opt,name=size,proto3" json:"size,omitempty"`
+	Chunks        int32                  `protobuf:"varint,5,opt,name=chunks,proto3" json:"chunks,omitempty"`
+	LastChunkSize int64                  `protobuf:"varint,6,opt,name=last_chunk_size,json=lastChunkSize,proto3" json:"last_chunk_size,omitempty"`
+	// SHA-256 of checkpoints, hex encoded
+	Sha256        string `protobuf:"bytes,7,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResourceInfo) Reset() {
+	*x = ResourceInfo{}
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResourceInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResourceInfo) ProtoMessage() {}
+
+func (x *ResourceInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResourceInfo.ProtoReflect.Descriptor instead.
+func (*ResourceInfo) Descriptor() ([]byte, []int) {
+	return file_vidgonerf_v1_client_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ResourceInfo) GetOutputType() string {
+	if x != nil {
+		return x.OutputType
+	}
+	return ""
+}
+
+func (x *ResourceInfo) GetIteration() int32 {
+	if x != nil {
+		return x.Iteration
+	}
+	return 0
+}
+
+func (x *ResourceInfo) GetExists() bool {
+	if x != nil {
+		return x.Exists
+	}
+	return false
+}
+
+func (x *ResourceInfo) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *ResourceInfo) GetChunks() int32 {
+	if x != nil {
+		return x.Chunks
+	}
+	return 0
+}
+
+func (x *ResourceInfo) GetLastChunkSize() int64 {
+	if x != nil {
+		return x.LastChunkSize
+	}
+	return 0
+}
+
+func (x *ResourceInfo) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+type DownloadResourceRequest struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	SceneId    string                 `protobuf:"bytes,1,opt,name=scene_id,json=sceneId,proto3" json:"scene_id,omitempty"`
+	OutputType string                 `protobuf:"bytes,2,opt,name=output_type,json=outputType,proto3" json:"output_type,omitempty"`
+	// iteration of the output, the latest completed iteration if empty
+	Iteration string `protobuf:"bytes,3,opt,name=iteration,proto3" json:"iteration,omitempty"`
+	// format to convert the output to, the stored format if empty
+	Format string `protobuf:"bytes,4,opt,name=format,proto3" json:"format,omitempty"`
+	// most bytes sent per chunk, the server default if 0
+	ChunkSize int64 `protobuf:"varint,5,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
+	// training run of the scene, the current run if 0
+	Version       int32 `protobuf:"varint,6,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DownloadResourceRequest) Reset() {
+	*x = DownloadResourceRequest{}
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DownloadResourceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DownloadResourceRequest) ProtoMessage() {}
+
+func (x *DownloadResourceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DownloadResourceRequest.ProtoReflect.Descriptor instead.
+func (*DownloadResourceRequest) Descriptor() ([]byte, []int) {
+	return file_vidgonerf_v1_client_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DownloadResourceRequest) GetSceneId() string {
+	if x != nil {
+		return x.SceneId
+	}
+	return ""
+}
+
+func (x *DownloadResourceRequest) GetOutputType() string {
+	if x != nil {
+		return x.OutputType
+	}
+	return ""
+}
+
+func (x *DownloadResourceRequest) GetIteration() string {
+	if x != nil {
+		return x.Iteration
+	}
+	return ""
+}
+
+func (x *DownloadResourceRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *DownloadResourceRequest) GetChunkSize() int64 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+func (x *DownloadResourceRequest) GetVersion() int32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+type ResourceChunk struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// set on the first chunk only
+	Description *ResourceDescription `protobuf:"bytes,1,opt,name=description,proto3" json:"description,omitempty"`
+	// offset of data in the file, in bytes
+	Offset        int64  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Data          []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResourceChunk) Reset() {
+	*x = ResourceChunk{}
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResourceChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResourceChunk) ProtoMessage() {}
+
+func (x *ResourceChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResourceChunk.ProtoReflect.Descriptor instead.
+func (*ResourceChunk) Descriptor() ([]byte, []int) {
+	return file_vidgonerf_v1_client_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ResourceChunk) GetDescription() *ResourceDescription {
+	if x != nil {
+		return x.Description
+	}
+	return nil
+}
+
+func (x *ResourceChunk) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ResourceChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type ResourceDescription struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	FileName  string                 `protobuf:"bytes,1,opt,name=file_name,json=fileName,proto3" json:"file_name,omitempty"`
+	Size      int64                  `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	Iteration int32                  `protobuf:"varint,3,opt,name=iteration,proto3" json:"iteration,omitempty"`
+	// the file will not be written to again
+	Final         bool `protobuf:"varint,4,opt,name=final,proto3" json:"final,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResourceDescription) Reset() {
+	*x = ResourceDescription{}
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResourceDescription) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResourceDescription) ProtoMessage() {}
+
+func (x *ResourceDescription) ProtoReflect() protoreflect.Message {
+	mi := &file_vidgonerf_v1_client_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResourceDescription.ProtoReflect.Descriptor instead.
+func (*ResourceDescription) Descriptor() ([]byte, []int) {
+	return file_vidgonerf_v1_client_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ResourceDescription) GetFileName() string {
+	if x != nil {
+		return x.FileName
+	}
+	return ""
+}
+
+func (x *ResourceDescription) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *ResourceDescription) GetIteration() int32 {
+	if x != nil {
+		return x.Iteration
+	}
+	return 0
+}
+
+func (x *ResourceDescription) GetFinal() bool {
+	if x != nil {
+		return x.Final
+	}
+	return false
+}
+
+var File_vidgonerf_v1_client_proto protoreflect.FileDescriptor
+
+const file_vidgonerf_v1_client_proto_rawDesc = "" +
+	"\n" +
+	"\x19vidgonerf/v1/client.proto\x12\fvidgonerf.v1\"F\n" +
+	"\fLoginRequest\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12\x1a\n" +
+	"\bpassword\x18\x02 \x01(\tR\bpassword\"\x95\x01\n" +
+	"\rLoginResponse\x12!\n" +
+	"\faccess_token\x18\x01 \x01(\tR\vaccessToken\x12#\n" +
+	"\rrefresh_token\x18\x02 \x01(\tR\frefreshToken\x12\x1d\n" +
+	"\n" +
+	"token_type\x18\x03 \x01(\tR\ttokenType\x12\x1d\n" +
+	"\n" +
+	"expires_in\x18\x04 \x01(\x05R\texpiresIn\"m\n" +
+	"\x12UploadVideoRequest\x124\n" +
+	"\x06header\x18\x01 \x01(\v2\x1a.vidgonerf.v1.UploadHeaderH\x00R\x06header\x12\x16\n" +
+	"\x05chunk\x18\x02 \x01(\fH\x00R\x05chunkB\t\n" +
+	"\apayload\"\x87\x03\n" +
+	"\fUploadHeader\x12\x1b\n" +
+	"\tfile_name\x18\x01 \x01(\tR\bfileName\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x03R\x04size\x12#\n" +
+	"\rtraining_mode\x18\x03 \x01(\tR\ftrainingMode\x12!\n" +
+	"\foutput_types\x18\x04 \x03(\tR\voutputTypes\x12'\n" +
+	"\x0fsave_iterations\x18\x05 \x03(\x05R\x0esaveIterations\x12)\n" +
+	"\x10total_iterations\x18\x06 \x01(\x05R\x0ftotalIterations\x12*\n" +
+	"\x11frame_sample_rate\x18\a \x01(\x05R\x0fframeSampleRate\x12,\n" +
+	"\x12target_frame_count\x18\b \x01(\x05R\x10targetFrameCount\x12\x1d\n" +
+	"\n" +
+	"scene_name\x18\t \x01(\tR\tsceneName\x12\x1a\n" +
+	"\bpriority\x18\n" +
+	" \x01(\tR\bpriority\x12\x15\n" +
+	"\x06org_id\x18\v \x01(\tR\x05orgId\"0\n" +
+	"\x13UploadVideoResponse\x12\x19\n" +
+	"\bscene_id\x18\x01 \x01(\tR\asceneId\"m\n" +
+	"\x17GetSceneMetadataRequest\x12\x19\n" +
+	"\bscene_id\x18\x01 \x01(\tR\asceneId\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x02 \x01(\x03R\tchunkSize\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\x05R\aversion\"h\n" +
+	"\rSceneMetadata\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x01 \x01(\x03R\tchunkSize\x128\n" +
+	"\tresources\x18\x02 \x03(\v2\x1a.vidgonerf.v1.ResourceInfoR\tresources\"\xd1\x01\n" +
+	"\fResourceInfo\x12\x1f\n" +
+	"\voutput_type\x18\x01 \x01(\tR\n" +
+	"outputType\x12\x1c\n" +
+	"\titeration\x18\x02 \x01(\x05R\titeration\x12\x16\n" +
+	"\x06exists\x18\x03 \x01(\bR\x06exists\x12\x12\n" +
+	"\x04size\x18\x04 \x01(\x03R\x04size\x12\x16\n" +
+	"\x06chunks\x18\x05 \x01(\x05R\x06chunks\x12&\n" +
+	"\x0flast_chunk_size\x18\x06 \x01(\x03R\rlastChunkSize\x12\x16\n" +
+	"\x06sha256\x18\a \x01(\tR\x06sha256\"\xc4\x01\n" +
+	"\x17DownloadResourceRequest\x12\x19\n" +
+	"\bscene_id\x18\x01 \x01(\tR\asceneId\x12\x1f\n" +
+	"\voutput_type\x18\x02 \x01(\tR\n" +
+	"outputType\x12\x1c\n" +
+	"\titeration\x18\x03 \x01(\tR\titeration\x12\x16\n" +
+	"\x06format\x18\x04 \x01(\tR\x06format\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x05 \x01(\x03R\tchunkSize\x12\x18\n" +
+	"\aversion\x18\x06 \x01(\x05R\aversion\"\x80\x01\n" +
+	"\rResourceChunk\x12C\n" +
+	"\vdescription\x18\x01 \x01(\v2!.vidgonerf.v1.ResourceDescriptionR\vdescription\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\"z\n" +
+	"\x13ResourceDescription\x12\x1b\n" +
+	"\tfile_name\x18\x01 \x01(\tR\bfileName\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x03R\x04size\x12\x1c\n" +
+	"\titeration\x18\x03 \x01(\x05R\titeration\x12\x14\n" +
+	"\x05final\x18\x04 \x01(\bR\x05final2\xd5\x02\n" +
+	"\tClientAPI\x12@\n" +
+	"\x05Login\x12\x1a.vidgonerf.v1.LoginRequest\x1a\x1b.vidgonerf.v1.LoginResponse\x12T\n" +
+	"\vUploadVideo\x12 .vidgonerf.v1.UploadVideoRequest\x1a!.vidgonerf.v1.UploadVideoResponse(\x01\x12V\n" +
+	"\x10GetSceneMetadata\x12%.vidgonerf.v1.GetSceneMetadataRequest\x1a\x1b.vidgonerf.v1.SceneMetadata\x12X\n" +
+	"\x10DownloadResource\x12%.vidgonerf.v1.DownloadResourceRequest\x1a\x1b.vidgonerf.v1.ResourceChunk0\x01B;Z9github.com/NeRF-or-Nothing/go-web-server/internal/grpcapib\x06proto3"
+
+var (
+	file_vidgonerf_v1_client_proto_rawDescOnce sync.Once
+	file_vidgonerf_v1_client_proto_rawDescData []byte
+)
+
+func file_vidgonerf_v1_client_proto_rawDescGZIP() []byte {
+	file_vidgonerf_v1_client_proto_rawDescOnce.Do(func() {
+		file_vidgonerf_v1_client_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_vidgonerf_v1_client_proto_rawDesc), len(file_vidgonerf_v1_client_proto_rawDesc)))
+	})
+	return file_vidgonerf_v1_client_proto_rawDescData
+}
+
+var file_vidgonerf_v1_client_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_vidgonerf_v1_client_proto_goTypes = []any{
+	(*LoginRequest)(nil),            // 0: vidgonerf.v1.LoginRequest
+	(*LoginResponse)(nil),           // 1: vidgonerf.v1.LoginResponse
+	(*UploadVideoRequest)(nil),      // 2: vidgonerf.v1.UploadVideoRequest
+	(*UploadHeader)(nil),            // 3: vidgonerf.v1.UploadHeader
+	(*UploadVideoResponse)(nil),     // 4: vidgonerf.v1.UploadVideoResponse
+	(*GetSceneMetadataRequest)(nil), // 5: vidgonerf.v1.GetSceneMetadataRequest
+	(*SceneMetadata)(nil),           // 6: vidgonerf.v1.SceneMetadata
+	(*ResourceInfo)(nil),            // 7: vidgonerf.v1.ResourceInfo
+	(*DownloadResourceRequest)(nil), // 8: vidgonerf.v1.DownloadResourceRequest
+	(*ResourceChunk)(nil),           // 9: vidgonerf.v1.ResourceChunk
+	(*ResourceDescription)(nil),     // 10: vidgonerf.v1.ResourceDescription
+}
+var file_vidgonerf_v1_client_proto_depIdxs = []int32{
+	3,  // 0: vidgonerf.v1.UploadVideoRequest.header:type_name -> vidgonerf.v1.UploadHeader
+	7,  // 1: vidgonerf.v1.SceneMetadata.resources:type_name -> vidgonerf.v1.ResourceInfo
+	10, // 2: vidgonerf.v1.ResourceChunk.description:type_name -> vidgonerf.v1.ResourceDescription
+	0,  // 3: vidgonerf.v1.ClientAPI.Login:input_type -> vidgonerf.v1.LoginRequest
+	2,  // 4: vidgonerf.v1.ClientAPI.UploadVideo:input_type -> vidgonerf.v1.UploadVideoRequest
+	5,  // 5: vidgonerf.v1.ClientAPI.GetSceneMetadata:input_type -> vidgonerf.v1.GetSceneMetadataRequest
+	8,  // 6: vidgonerf.v1.ClientAPI.DownloadResource:input_type -> vidgonerf.v1.DownloadResourceRequest
+	1,  // 7: vidgonerf.v1.ClientAPI.Login:output_type -> vidgonerf.v1.LoginResponse
+	4,  // 8: vidgonerf.v1.ClientAPI.UploadVideo:output_type -> vidgonerf.v1.UploadVideoResponse
+	6,  // 9: vidgonerf.v1.ClientAPI.GetSceneMetadata:output_type -> vidgonerf.v1.SceneMetadata
+	9,  // 10: vidgonerf.v1.ClientAPI.DownloadResource:output_type -> vidgonerf.v1.ResourceChunk
+	7,  // [7:11] is the sub-list for method output_type
+	3,  // [3:7] is the sub-list for method input_type
+	3,  // [3:3] is the sub-list for extension type_name
+	3,  // [3:3] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_vidgonerf_v1_client_proto_init() }
+func file_vidgonerf_v1_client_proto_init() {
+	if File_vidgonerf_v1_client_proto != nil {
+		return
+	}
+	file_vidgonerf_v1_client_proto_msgTypes[2].OneofWrappers = []any{
+		(*UploadVideoRequest_Header)(nil),
+		(*UploadVideoRequest_Chunk)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_vidgonerf_v1_client_proto_rawDesc), len(file_vidgonerf_v1_client_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_vidgonerf_v1_client_proto_goTypes,
+		DependencyIndexes: file_vidgonerf_v1_client_proto_depIdxs,
+		MessageInfos:      file_vidgonerf_v1_client_proto_msgTypes,
+	}.Build()
+	File_vidgonerf_v1_client_proto = out.File
+	file_vidgonerf_v1_client_proto_goTypes = nil
+	file_vidgonerf_v1_client_proto_depIdxs = nil
+}
@@ -0,0 +1,259 @@
+// The gRPC API of the web server, for native clients such as desktop capture tools. It exposes the same operations as
+// the REST routes of the same name, with the same errors, mapped to gRPC status codes.
+//
+// The Go code of the server is generated from this file into internal/grpcapi with protoc, see the go:generate
+// directive of internal/grpcapi/doc.go, and must be generated again after a change. Field numbers must never be reused.
+//
+// Authenticated methods expect either an access token in the `authorization` metadata ("Bearer <token>"), as
+// returned by Login, or an API key in the `x-api-key` metadata, with the scope noted on each method.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: vidgonerf/v1/client.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ClientAPI_Login_FullMethodName            = "/vidgonerf.v1.ClientAPI/Login"
+	ClientAPI_UploadVideo_FullMethodName      = "/vidgonerf.v1.ClientAPI/UploadVideo"
+	ClientAPI_GetSceneMetadata_FullMethodName = "/vidgonerf.v1.ClientAPI/GetSceneMetadata"
+	ClientAPI_DownloadResource_FullMethodName = "/vidgonerf.v1.ClientAPI/DownloadResource"
+)
+
+// ClientAPIClient is the client API for ClientAPI service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ClientAPIClient interface {
+	// Login returns the tokens of a user, as POST /user/account/login.
+	Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*LoginResponse, error)
+	// UploadVideo uploads an .mp4 video and starts training a scene from it, as the resumable upload routes
+	// /user/scene/upload. The first message must carry the header, every message after it a chunk of the video, in
+	// order. The upload is completed once the client closes the stream with exactly header.size bytes sent, and
+	// discarded if the stream fails before. API keys need the "upload" scope.
+	UploadVideo(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadVideoRequest, UploadVideoResponse], error)
+	// GetSceneMetadata returns the output files of a scene, as GET /user/scene/metadata/:scene_id. API keys need the
+	// "read" scope.
+	GetSceneMetadata(ctx context.Context, in *GetSceneMetadataRequest, opts ...grpc.CallOption) (*SceneMetadata, error)
+	// DownloadResource streams an output file of a scene, as GET /user/scene/output/:output_type/:scene_id. The first
+	// chunk also carries the description of the file. API keys need the "read" scope.
+	DownloadResource(ctx context.Context, in *DownloadResourceRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ResourceChunk], error)
+}
+
+type clientAPIClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewClientAPIClient(cc grpc.ClientConnInterface) ClientAPIClient {
+	return &clientAPIClient{cc}
+}
+
+func (c *clientAPIClient) Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*LoginResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LoginResponse)
+	err := c.cc.Invoke(ctx, ClientAPI_Login_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientAPIClient) UploadVideo(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UploadVideoRequest, UploadVideoResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ClientAPI_ServiceDesc.Streams[0], ClientAPI_UploadVideo_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UploadVideoRequest, UploadVideoResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClientAPI_UploadVideoClient = grpc.ClientStreamingClient[UploadVideoRequest, UploadVideoResponse]
+
+func (c *clientAPIClient) GetSceneMetadata(ctx context.Context, in *GetSceneMetadataRequest, opts ...grpc.CallOption) (*SceneMetadata, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SceneMetadata)
+	err := c.cc.Invoke(ctx, ClientAPI_GetSceneMetadata_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *clientAPIClient) DownloadResource(ctx context.Context, in *DownloadResourceRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ResourceChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ClientAPI_ServiceDesc.Streams[1], ClientAPI_DownloadResource_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[DownloadResourceRequest, ResourceChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClientAPI_DownloadResourceClient = grpc.ServerStreamingClient[ResourceChunk]
+
+// ClientAPIServer is the server API for ClientAPI service.
+// All implementations must embed UnimplementedClientAPIServer
+// for forward compatibility.
+type ClientAPIServer interface {
+	// Login returns the tokens of a user, as POST /user/account/login.
+	Login(context.Context, *LoginRequest) (*LoginResponse, error)
+	// UploadVideo uploads an .mp4 video and starts training a scene from it, as the resumable upload routes
+	// /user/scene/upload. The first message must carry the header, every message after it a chunk of the video, in
+	// order. The upload is completed once the client closes the stream with exactly header.size bytes sent, and
+	// discarded if the stream fails before. API keys need the "upload" scope.
+	UploadVideo(grpc.ClientStreamingServer[UploadVideoRequest, UploadVideoResponse]) error
+	// GetSceneMetadata returns the output files of a scene, as GET /user/scene/metadata/:scene_id. API keys need the
+	// "read" scope.
+	GetSceneMetadata(context.Context, *GetSceneMetadataRequest) (*SceneMetadata, error)
+	// DownloadResource streams an output file of a scene, as GET /user/scene/output/:output_type/:scene_id. The first
+	// chunk also carries the description of the file. API keys need the "read" scope.
+	DownloadResource(*DownloadResourceRequest, grpc.ServerStreamingServer[ResourceChunk]) error
+	mustEmbedUnimplementedClientAPIServer()
+}
+
+// UnimplementedClientAPIServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedClientAPIServer struct{}
+
+func (UnimplementedClientAPIServer) Login(context.Context, *LoginRequest) (*LoginResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Login not implemented")
+}
+func (UnimplementedClientAPIServer) UploadVideo(grpc.ClientStreamingServer[UploadVideoRequest, UploadVideoResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method UploadVideo not implemented")
+}
+func (UnimplementedClientAPIServer) GetSceneMetadata(context.Context, *GetSceneMetadataRequest) (*SceneMetadata, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSceneMetadata not implemented")
+}
+func (UnimplementedClientAPIServer) DownloadResource(*DownloadResourceRequest, grpc.ServerStreamingServer[ResourceChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadResource not implemented")
+}
+func (UnimplementedClientAPIServer) mustEmbedUnimplementedClientAPIServer() {}
+func (UnimplementedClientAPIServer) testEmbeddedByValue()                   {}
+
+// UnsafeClientAPIServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ClientAPIServer will
+// result in compilation errors.
+type UnsafeClientAPIServer interface {
+	mustEmbedUnimplementedClientAPIServer()
+}
+
+func RegisterClientAPIServer(s grpc.ServiceRegistrar, srv ClientAPIServer) {
+	// If the following call pancis, it indicates UnimplementedClientAPIServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ClientAPI_ServiceDesc, srv)
+}
+
+func _ClientAPI_Login_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoginRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientAPIServer).Login(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClientAPI_Login_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientAPIServer).Login(ctx, req.(*LoginRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientAPI_UploadVideo_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ClientAPIServer).UploadVideo(&grpc.GenericServerStream[UploadVideoRequest, UploadVideoResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClientAPI_UploadVideoServer = grpc.ClientStreamingServer[UploadVideoRequest, UploadVideoResponse]
+
+func _ClientAPI_GetSceneMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSceneMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClientAPIServer).GetSceneMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ClientAPI_GetSceneMetadata_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClientAPIServer).GetSceneMetadata(ctx, req.(*GetSceneMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ClientAPI_DownloadResource_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadResourceRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ClientAPIServer).DownloadResource(m, &grpc.GenericServerStream[DownloadResourceRequest, ResourceChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ClientAPI_DownloadResourceServer = grpc.ServerStreamingServer[ResourceChunk]
+
+// ClientAPI_ServiceDesc is the grpc.ServiceDesc for ClientAPI service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ClientAPI_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "vidgonerf.v1.ClientAPI",
+	HandlerType: (*ClientAPIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Login",
+			Handler:    _ClientAPI_Login_Handler,
+		},
+		{
+			MethodName: "GetSceneMetadata",
+			Handler:    _ClientAPI_GetSceneMetadata_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadVideo",
+			Handler:       _ClientAPI_UploadVideo_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "DownloadResource",
+			Handler:       _ClientAPI_DownloadResource_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "vidgonerf/v1/client.proto",
+}
@@ -0,0 +1,10 @@
+// Package grpcapi contains the gRPC API of the web server, for native clients such as desktop capture tools that
+// would rather not reimplement multipart uploads and chunked downloads over HTTP. It serves the ClientAPI service
+// defined in proto/vidgonerf/v1/client.proto by calling the ClientService, as the web package does for REST.
+//
+// The messages and service stubs of client.pb.go and client_grpc.pb.go are generated from the proto file with
+// protoc-gen-go and protoc-gen-go-grpc, and served with google.golang.org/grpc. The server requires a certificate, as
+// gRPC is only served over TLS.
+package grpcapi
+
+//go:generate protoc --proto_path=../../proto --go_out=. --go_opt=module=github.com/NeRF-or-Nothing/go-web-server/internal/grpcapi --go-grpc_out=. --go-grpc_opt=module=github.com/NeRF-or-Nothing/go-web-server/internal/grpcapi vidgonerf/v1/client.proto
@@ -0,0 +1,76 @@
+// This file contains the structured fields carried by contexts.
+//
+// Fields describing the work a context belongs to (i.e the request ID and user of a web request, or the scene and job
+// a worker message is about) are added to the context with WithFields as they become known, and every entry logged
+// with Logger.Ctx carries them. Fields use the keys declared below, so entries of the same request, scene or job can
+// be found across components.
+
+package log
+
+import (
+	"context"
+)
+
+// Declarations for structured log field keys
+const (
+	FieldRequestID    = "request_id"
+	FieldUserID       = "user_id"
+	FieldTraceID      = "trace_id"
+	FieldSceneID      = "scene_id"
+	FieldJobID        = "job_id"
+	FieldResourceType = "resource_type"
+	FieldQueue        = "queue"
+)
+
+// fieldsKey is the context key of the fields added with WithFields.
+type fieldsKey struct{}
+
+// WithFields returns a copy of ctx carrying the given alternating keys and values, in addition to those it already
+// carries. A key added again replaces its earlier value.
+func WithFields(ctx context.Context, keysAndValues ...interface{}) context.Context {
+	if len(keysAndValues) < 2 {
+		return ctx
+	}
+	existing := Fields(ctx)
+	fields := make([]interface{}, 0, len(existing)+len(keysAndValues))
+	for i := 0; i+1 < len(existing); i += 2 {
+		if !hasKey(keysAndValues, existing[i]) {
+			fields = append(fields, existing[i], existing[i+1])
+		}
+	}
+	fields = append(fields, keysAndValues...)
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// Fields returns the alternating keys and values carried by ctx.
+func Fields(ctx context.Context) []interface{} {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	return fields
+}
+
+// hasKey checks if key is one of the keys of alternating keys and values.
+func hasKey(keysAndValues []interface{}, key interface{}) bool {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if keysAndValues[i] == key {
+			return true
+		}
+	}
+	return false
+}
+
+// With returns a Logger adding the given alternating keys and values to every entry.
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	return &Logger{l.SugaredLogger.With(keysAndValues...)}
+}
+
+// Ctx returns a Logger adding the fields carried by ctx to every entry, or l itself if ctx carries none.
+func (l *Logger) Ctx(ctx context.Context) *Logger {
+	fields := Fields(ctx)
+	if len(fields) == 0 {
+		return l
+	}
+	return l.With(fields...)
+}
@@ -0,0 +1,154 @@
+// This file contains the declaration of every metric recorded by the application.
+//
+// Metric and label names are part of the monitoring contract, and should not be renamed once dashboards depend on them.
+
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Declarations for label values shared across metrics
+const (
+	StageSfm      = "sfm"
+	StageTraining = "training"
+)
+
+// Metrics holds every metric recorded by the application, and the Registry they are exposed through.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	mu sync.Mutex
+	// called before every collection, see OnCollect
+	hooks []func()
+
+	// UploadsTotal counts accepted video uploads, by training mode.
+	UploadsTotal *prometheus.CounterVec
+	// UploadBytesTotal counts bytes of accepted video uploads stored, by training mode.
+	UploadBytesTotal *prometheus.CounterVec
+	// UploadSize observes the size in bytes of accepted uploads, by training mode.
+	UploadSize *prometheus.HistogramVec
+	// UploadDuration observes the time taken to handle a video upload, by result ("success" or "error").
+	UploadDuration *prometheus.HistogramVec
+	// OutputBytesTotal counts bytes of worker output stored, by output type.
+	OutputBytesTotal *prometheus.CounterVec
+	// DownloadBytesTotal counts bytes of scene output sent to clients, by output type.
+	DownloadBytesTotal *prometheus.CounterVec
+	// RateLimitedRequestsTotal counts requests refused for exceeding the per-user rate limit.
+	RateLimitedRequestsTotal prometheus.Counter
+	// WebhookDeliveriesTotal counts attempts to send webhook deliveries, by result ("delivered", "retried" or "failed").
+	WebhookDeliveriesTotal *prometheus.CounterVec
+
+	// JobsPublishedTotal counts jobs published to workers, by stage and training mode.
+	JobsPublishedTotal *prometheus.CounterVec
+	// JobPublishDuration observes the time taken to publish a job to the message broker, by stage.
+	JobPublishDuration *prometheus.HistogramVec
+	// JobsCompletedTotal counts scenes that completed training, by training mode.
+	JobsCompletedTotal *prometheus.CounterVec
+	// JobsFailedTotal counts jobs that failed, by stage and training mode.
+	JobsFailedTotal *prometheus.CounterVec
+
+	// AMQPPublishFailuresTotal counts failed publishes to the message broker, by queue.
+	AMQPPublishFailuresTotal *prometheus.CounterVec
+	// MessagesDeadLetteredTotal counts worker output messages dead-lettered after failing every attempt, by queue.
+	MessagesDeadLetteredTotal *prometheus.CounterVec
+	// AMQPQueueDepth is the approximate number of ready messages in each broker queue, refreshed on collection.
+	AMQPQueueDepth *prometheus.GaugeVec
+	// OutboxPendingMessages is the number of jobs written to the outbox but not yet sent to the broker, refreshed on
+	// collection.
+	OutboxPendingMessages prometheus.Gauge
+	// ProcessingQueueLength is the number of scenes in each processing queue list (i.e "sfm_list"), refreshed on collection.
+	ProcessingQueueLength *prometheus.GaugeVec
+	// WorkersAvailable is the number of registered workers that sent a recent heartbeat, by kind ("sfm" or "nerf"),
+	// refreshed on collection.
+	WorkersAvailable *prometheus.GaugeVec
+
+	// MongoOperationDuration observes the time taken by MongoDB commands, by collection, command, and result
+	// ("success" or "error"). It is recorded by the command monitor of NewMongoMonitor.
+	MongoOperationDuration *prometheus.HistogramVec
+}
+
+// NewMetrics creates a new Registry and registers every application metric with it, along with the Go runtime and
+// process metrics.
+func NewMetrics() *Metrics {
+	r := prometheus.NewRegistry()
+	r.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	f := promauto.With(r)
+	return &Metrics{
+		Registry: r,
+
+		UploadsTotal: f.NewCounterVec(prometheus.CounterOpts{Name: "vidgonerf_uploads_total",
+			Help: "Number of video uploads accepted."}, []string{"training_mode"}),
+		UploadBytesTotal: f.NewCounterVec(prometheus.CounterOpts{Name: "vidgonerf_upload_bytes_total",
+			Help: "Bytes of uploaded video stored."}, []string{"training_mode"}),
+		UploadSize: f.NewHistogramVec(prometheus.HistogramOpts{Name: "vidgonerf_upload_size_bytes",
+			Help: "Size of accepted uploads.", Buckets: prometheus.ExponentialBuckets(1<<20, 2, 13)}, []string{"training_mode"}),
+		UploadDuration: f.NewHistogramVec(prometheus.HistogramOpts{Name: "vidgonerf_upload_duration_seconds",
+			Help: "Time taken to handle a video upload.", Buckets: prometheus.ExponentialBuckets(0.1, 2, 12)}, []string{"result"}),
+		OutputBytesTotal: f.NewCounterVec(prometheus.CounterOpts{Name: "vidgonerf_output_bytes_total",
+			Help: "Bytes of worker output stored."}, []string{"output_type"}),
+		DownloadBytesTotal: f.NewCounterVec(prometheus.CounterOpts{Name: "vidgonerf_download_bytes_total",
+			Help: "Bytes of scene output sent to clients."}, []string{"output_type"}),
+		RateLimitedRequestsTotal: f.NewCounter(prometheus.CounterOpts{Name: "vidgonerf_rate_limited_requests_total",
+			Help: "Number of requests refused for exceeding the per-user rate limit."}),
+		WebhookDeliveriesTotal: f.NewCounterVec(prometheus.CounterOpts{Name: "vidgonerf_webhook_deliveries_total",
+			Help: "Number of attempts to send webhook deliveries."}, []string{"result"}),
+
+		JobsPublishedTotal: f.NewCounterVec(prometheus.CounterOpts{Name: "vidgonerf_jobs_published_total",
+			Help: "Number of jobs published to workers."}, []string{"stage", "training_mode"}),
+		JobPublishDuration: f.NewHistogramVec(prometheus.HistogramOpts{Name: "vidgonerf_job_publish_duration_seconds",
+			Help: "Time taken to publish a job to the message broker.", Buckets: prometheus.ExponentialBuckets(0.001, 2, 12)}, []string{"stage"}),
+		JobsCompletedTotal: f.NewCounterVec(prometheus.CounterOpts{Name: "vidgonerf_jobs_completed_total",
+			Help: "Number of scenes that completed training."}, []string{"training_mode"}),
+		JobsFailedTotal: f.NewCounterVec(prometheus.CounterOpts{Name: "vidgonerf_jobs_failed_total",
+			Help: "Number of jobs that failed."}, []string{"stage", "training_mode"}),
+
+		AMQPPublishFailuresTotal: f.NewCounterVec(prometheus.CounterOpts{Name: "vidgonerf_amqp_publish_failures_total",
+			Help: "Number of failed publishes to the message broker."}, []string{"queue"}),
+		MessagesDeadLetteredTotal: f.NewCounterVec(prometheus.CounterOpts{Name: "vidgonerf_messages_dead_lettered_total",
+			Help: "Number of messages dead-lettered after failing every processing attempt."}, []string{"queue"}),
+		AMQPQueueDepth: f.NewGaugeVec(prometheus.GaugeOpts{Name: "vidgonerf_amqp_queue_depth",
+			Help: "Approximate number of ready messages in a message broker queue."}, []string{"queue"}),
+		OutboxPendingMessages: f.NewGauge(prometheus.GaugeOpts{Name: "vidgonerf_outbox_pending_messages",
+			Help: "Number of jobs in the outbox not yet sent to the message broker."}),
+		ProcessingQueueLength: f.NewGaugeVec(prometheus.GaugeOpts{Name: "vidgonerf_processing_queue_length",
+			Help: "Number of scenes in a processing queue list."}, []string{"queue"}),
+		WorkersAvailable: f.NewGaugeVec(prometheus.GaugeOpts{Name: "vidgonerf_workers_available",
+			Help: "Number of registered workers that sent a recent heartbeat."}, []string{"kind"}),
+
+		MongoOperationDuration: f.NewHistogramVec(prometheus.HistogramOpts{Name: "vidgonerf_mongo_operation_duration_seconds",
+			Help: "Time taken by MongoDB commands.", Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14)}, []string{"collection", "command", "result"}),
+	}
+}
+
+// OnCollect registers a function that is called before every collection, i.e, to refresh gauges that are
+// expensive to keep up to date continuously.
+func (m *Metrics) OnCollect(hook func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook)
+}
+
+// Gather runs the collection hooks, and gathers every registered metric.
+func (m *Metrics) Gather() ([]*dto.MetricFamily, error) {
+	m.mu.Lock()
+	hooks := append([]func(){}, m.hooks...)
+	m.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+	return m.Registry.Gather()
+}
+
+// Handler returns the handler serving every registered metric in the Prometheus exposition formats.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m, promhttp.HandlerOpts{})
+}
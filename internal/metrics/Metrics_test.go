@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// gatherHistogram returns the series of the named histogram with the given label values, or nil if it has none.
+func gatherHistogram(t *testing.T, m *Metrics, name string, labels map[string]string) *dto.Histogram {
+	t.Helper()
+	families, err := m.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue series
+				}
+			}
+			return metric.GetHistogram()
+		}
+	}
+	return nil
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	m := NewMetrics()
+	m.UploadSize.WithLabelValues("gaussian").Observe(3 << 20)
+	m.UploadDuration.WithLabelValues("success").Observe(0.15)
+	m.JobPublishDuration.WithLabelValues(StageSfm).Observe(0.0015)
+
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		firstBound float64
+		buckets    int
+		// number of observations in the first bucket holding the observed value
+		wantBucket int
+	}{
+		{"vidgonerf_upload_size_bytes", map[string]string{"training_mode": "gaussian"}, 1 << 20, 13, 2},
+		{"vidgonerf_upload_duration_seconds", map[string]string{"result": "success"}, 0.1, 12, 1},
+		{"vidgonerf_job_publish_duration_seconds", map[string]string{"stage": StageSfm}, 0.001, 12, 1},
+	}
+	for _, tt := range tests {
+		h := gatherHistogram(t, m, tt.name, tt.labels)
+		if h == nil {
+			t.Errorf("%s: no series with labels %v", tt.name, tt.labels)
+			continue
+		}
+		buckets := h.GetBucket()
+		if len(buckets) != tt.buckets || buckets[0].GetUpperBound() != tt.firstBound {
+			t.Errorf("%s: got %d buckets from %v, want %d from %v", tt.name, len(buckets), buckets[0].GetUpperBound(), tt.buckets, tt.firstBound)
+			continue
+		}
+		if h.GetSampleCount() != 1 || buckets[0].GetCumulativeCount() != 0 || buckets[tt.wantBucket].GetCumulativeCount() != 1 {
+			t.Errorf("%s: observation not counted in bucket %d: %v", tt.name, tt.wantBucket, buckets)
+		}
+	}
+}
+
+func TestMongoMonitor(t *testing.T) {
+	m := NewMetrics()
+	monitor := NewMongoMonitor(m)
+	ctx := context.Background()
+
+	command, err := bson.Marshal(bson.D{{Key: "find", Value: "scenes"}, {Key: "filter", Value: bson.D{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor.Started(ctx, &event.CommandStartedEvent{Command: command, CommandName: "find", RequestID: 1})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", RequestID: 1, Duration: 2 * time.Millisecond},
+	})
+	// Commands whose start was not seen are recorded without a collection
+	monitor.Failed(ctx, &event.CommandFailedEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "ping", RequestID: 2, Duration: time.Millisecond},
+	})
+
+	const name = "vidgonerf_mongo_operation_duration_seconds"
+	find := gatherHistogram(t, m, name, map[string]string{"collection": "scenes", "command": "find", "result": "success"})
+	if find == nil || find.GetSampleCount() != 1 || find.GetSampleSum() != 0.002 {
+		t.Errorf("find command: got %v, want a single 2ms observation", find)
+	}
+	ping := gatherHistogram(t, m, name, map[string]string{"collection": unknownCollection, "command": "ping", "result": "error"})
+	if ping == nil || ping.GetSampleCount() != 1 {
+		t.Errorf("ping command: got %v, want a single observation", ping)
+	}
+}
+
+func TestOnCollect(t *testing.T) {
+	m := NewMetrics()
+	collected := 0
+	m.OnCollect(func() {
+		collected++
+		m.OutboxPendingMessages.Set(float64(collected))
+	})
+
+	families, err := m.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collected != 1 {
+		t.Fatalf("hook ran %d times, want 1", collected)
+	}
+	for _, family := range families {
+		if family.GetName() == "vidgonerf_outbox_pending_messages" {
+			if got := family.GetMetric()[0].GetGauge().GetValue(); got != 1 {
+				t.Errorf("got %v pending messages, want the value set by the hook", got)
+			}
+			return
+		}
+	}
+	t.Error("vidgonerf_outbox_pending_messages was not gathered")
+}
@@ -0,0 +1,51 @@
+// This file contains the MongoDB command monitor recording the duration of every command sent by the managers.
+//
+// Monitoring at the client level covers every manager (SceneManager, UserManager, ...) without instrumenting each of
+// their methods. Commands are labelled by the collection they target, which tells the managers apart.
+
+package metrics
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// unknownCollection labels commands that do not target a collection, i.e "ping" or "endSessions".
+const unknownCollection = "none"
+
+// NewMongoMonitor returns a command monitor that records the duration of every MongoDB command in the
+// m.MongoOperationDuration histogram, by collection, command, and result. Commands whose start was not seen are
+// recorded with the collection unknownCollection. It should be set on the options of the MongoDB client with SetMonitor.
+func NewMongoMonitor(m *Metrics) *event.CommandMonitor {
+	// collections of in-flight commands, by request ID, as finished events do not carry the command
+	var collections sync.Map
+
+	observe := func(e event.CommandFinishedEvent, result string) {
+		collection := unknownCollection
+		if v, ok := collections.LoadAndDelete(e.RequestID); ok {
+			collection = v.(string)
+		}
+		m.MongoOperationDuration.WithLabelValues(collection, e.CommandName, result).Observe(e.Duration.Seconds())
+	}
+
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			// The value of a command's first element is the collection it targets, i.e {"find": "scenes", ...}
+			elements, err := e.Command.Elements()
+			if err != nil || len(elements) == 0 {
+				return
+			}
+			if collection, ok := elements[0].Value().StringValueOK(); ok {
+				collections.Store(e.RequestID, collection)
+			}
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			observe(e.CommandFinishedEvent, "success")
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			observe(e.CommandFinishedEvent, "error")
+		},
+	}
+}
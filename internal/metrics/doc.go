@@ -0,0 +1,5 @@
+// Package metrics contains the metrics recorded by the application, collected with the Prometheus client library.
+// Counters, gauges, and histograms are supported, each optionally partitioned by a fixed set of labels.
+// The Metrics struct declares every metric the application records. There should be a single instance of it,
+// injected into any services that need to record metrics, and into the web server which serves it on /metrics.
+package metrics
@@ -0,0 +1,214 @@
+// This file contains the AuditManager implementation, which is responsible for interacting with the MongoDB audit collection.
+// The AuditManager struct contains a pointer to the nerfdb.audit MongoDB collection and a logger. It provides methods to
+// insert events and to read them back by scene, by user, or a page of any filter, newest first.
+
+package audit
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+// Declarations for audited actions
+const (
+	ActionLogin        = "login"
+	ActionReadMetadata = "read_metadata"
+	ActionReadStatus   = "read_status"
+	ActionDownload     = "download"
+	ActionCancel       = "cancel"
+	ActionRetrain      = "retrain"
+	ActionRetry        = "retry"
+	ActionDelete       = "delete"
+	ActionShare        = "share"
+	ActionReadAuditLog = "read_audit_log"
+	ActionExport       = "export"
+	ActionEditMetadata = "edit_metadata"
+	ActionRestore      = "restore"
+	ActionRender       = "render"
+	ActionUpload       = "upload"
+	// changes made once a share or unshare is allowed, with the collaborator as target
+	ActionShareWith = "share_with"
+	ActionUnshare   = "unshare"
+	// revocations of the user's own sessions, see services/Sessions.go
+	ActionRevokeSession     = "revoke_session"
+	ActionRevokeAllSessions = "revoke_all_sessions"
+	// changes to the external identities the user logs in with, see services/OAuth.go
+	ActionLinkIdentity   = "link_identity"
+	ActionUnlinkIdentity = "unlink_identity"
+)
+
+// Declarations for audited admin actions, on the target user or scene
+const (
+	ActionAdminDisableUser  = "admin_disable_user"
+	ActionAdminEnableUser   = "admin_enable_user"
+	ActionAdminSetQuota     = "admin_set_quota"
+	ActionAdminSetTier      = "admin_set_tier"
+	ActionAdminRequeueJob   = "admin_requeue_job"
+	ActionAdminBumpJob      = "admin_bump_job"
+	ActionAdminCancelJob    = "admin_cancel_job"
+	ActionAdminDeleteScene  = "admin_delete_scene"
+	ActionAdminReconcile    = "admin_reconcile"
+	ActionAdminSetAdmission = "admin_set_admission"
+	ActionAdminSetThrottle  = "admin_set_throttle"
+	ActionAdminReadAuditLog = "admin_read_audit_log"
+)
+
+// Declarations for event outcomes
+const (
+	OutcomeAllowed = "allowed"
+	OutcomeDenied  = "denied"
+)
+
+// Event is a single access-controlled operation. SceneID is nil for operations on no scene, i.e a login.
+// UserID is nil for a failed login of an unknown username. TargetUserID is the user acted on, if any, i.e the
+// collaborator of a share or the user an admin disabled.
+type Event struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	SceneID      primitive.ObjectID `bson:"scene_id,omitempty" json:"scene_id,omitempty"`
+	TargetUserID primitive.ObjectID `bson:"target_user_id,omitempty" json:"target_user_id,omitempty"`
+	Action       string             `bson:"action" json:"action"`
+	Outcome      string             `bson:"outcome" json:"outcome"`
+	Time         time.Time          `bson:"time" json:"time"`
+	// address of the client making the request, unset for operations not made by a request
+	IP string `bson:"ip,omitempty" json:"ip,omitempty"`
+	// optional context, i.e the output type of a download or why access was denied
+	Detail string `bson:"detail,omitempty" json:"detail,omitempty"`
+}
+
+type AuditManager struct {
+	collection *mongo.Collection
+	logger     *log.Logger
+}
+
+// NewAuditManager creates a new AuditManager with the given MongoDB client and logger.
+func NewAuditManager(client *mongo.Client, logger *log.Logger, unittest bool) *AuditManager {
+	return &AuditManager{
+		collection: client.Database("nerfdb").Collection("audit"),
+		logger:     logger,
+	}
+}
+
+// EnsureIndexes creates the indexes used to read events by scene, by user, by target user, and of any filter, newest
+// first. Existing indexes are kept.
+func (am *AuditManager) EnsureIndexes(ctx context.Context) error {
+	_, err := am.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "scene_id", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "target_user_id", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "time", Value: -1}}},
+	})
+	return err
+}
+
+// InsertEvents inserts a batch of events. Events that fail to insert are not retried.
+func (am *AuditManager) InsertEvents(ctx context.Context, events []*Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(events))
+	for i, e := range events {
+		docs[i] = e
+	}
+	// Unordered, so one bad event does not keep the rest of the batch from being inserted
+	_, err := am.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+// GetSceneEvents returns the most recent events on a scene, newest first, at most limit of them.
+func (am *AuditManager) GetSceneEvents(ctx context.Context, sceneID primitive.ObjectID, limit int64) ([]*Event, error) {
+	return am.find(ctx, bson.M{"scene_id": sceneID}, limit)
+}
+
+// GetUserEvents returns the most recent events by a user, newest first, at most limit of them.
+func (am *AuditManager) GetUserEvents(ctx context.Context, userID primitive.ObjectID, limit int64) ([]*Event, error) {
+	return am.find(ctx, bson.M{"user_id": userID}, limit)
+}
+
+// EventFilter selects events. Zero fields do not filter.
+type EventFilter struct {
+	UserID       primitive.ObjectID
+	SceneID      primitive.ObjectID
+	TargetUserID primitive.ObjectID
+	Action       string
+	Outcome      string
+	// events at or after Since, and before Until
+	Since time.Time
+	Until time.Time
+}
+
+// bson returns the query document of the filter.
+func (f EventFilter) bson() bson.M {
+	query := bson.M{}
+	if !f.UserID.IsZero() {
+		query["user_id"] = f.UserID
+	}
+	if !f.SceneID.IsZero() {
+		query["scene_id"] = f.SceneID
+	}
+	if !f.TargetUserID.IsZero() {
+		query["target_user_id"] = f.TargetUserID
+	}
+	if f.Action != "" {
+		query["action"] = f.Action
+	}
+	if f.Outcome != "" {
+		query["outcome"] = f.Outcome
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		timeRange := bson.M{}
+		if !f.Since.IsZero() {
+			timeRange["$gte"] = f.Since
+		}
+		if !f.Until.IsZero() {
+			timeRange["$lt"] = f.Until
+		}
+		query["time"] = timeRange
+	}
+	return query
+}
+
+// ListEvents returns a page of the events matching filter, newest first, skipping the first skip of them, and the
+// total number of matching events.
+func (am *AuditManager) ListEvents(ctx context.Context, filter EventFilter, skip, limit int64) ([]*Event, int64, error) {
+	query := filter.bson()
+	total, err := am.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: -1}}).SetSkip(skip).SetLimit(limit)
+	cursor, err := am.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	events := make([]*Event, 0)
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+// find returns the events matching filter, newest first, at most limit of them.
+func (am *AuditManager) find(ctx context.Context, filter bson.M, limit int64) ([]*Event, error) {
+	cursor, err := am.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "time", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := make([]*Event, 0)
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,5 @@
+// Package audit contains the implementation of the access audit trail in the MongoDB database.
+// The AuditManager struct is responsible for interacting with the MongoDB audit collection.
+// The Event struct is used to represent a single access-controlled operation, who made it, and whether it was allowed.
+// Events are only ever inserted and read, never updated.
+package audit
@@ -0,0 +1,106 @@
+// This file contains the BatchManager implementation, which is responsible for interacting with the MongoDB batches
+// collection. The BatchManager struct contains a pointer to the collection and a logger. It provides methods to
+// create batches, retrieve them, and remove those of a user.
+
+package batch
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+var (
+	// ErrBatchNotFound is returned when a batch with the requested ID does not exist.
+	ErrBatchNotFound = errors.New("batch not found")
+)
+
+// Item is a video of a batch. SceneID is the scene created for it, or nil if the video was rejected, in which case
+// Error holds the reason.
+type Item struct {
+	// file name of the video, or of the resumable upload it was received through
+	Name     string              `bson:"name"`
+	UploadID *primitive.ObjectID `bson:"upload_id,omitempty"`
+	SceneID  *primitive.ObjectID `bson:"scene_id,omitempty"`
+	Error    string              `bson:"error,omitempty"`
+}
+
+// Batch is a batch upload of several videos, each creating a scene with the same training config. Items are in the
+// order the videos were sent.
+type Batch struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	OwnerID primitive.ObjectID `bson:"owner_id"`
+	// organization whose workspace the scenes were uploaded into, if any
+	OrgID     primitive.ObjectID `bson:"org_id,omitempty"`
+	Name      string             `bson:"name,omitempty"`
+	Items     []Item             `bson:"items"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// SceneIDs returns the IDs of the scenes created by the batch, in the order of its items.
+func (b *Batch) SceneIDs() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(b.Items))
+	for _, item := range b.Items {
+		if item.SceneID != nil {
+			ids = append(ids, *item.SceneID)
+		}
+	}
+	return ids
+}
+
+type BatchManager struct {
+	collection *mongo.Collection
+	logger     *log.Logger
+}
+
+// NewBatchManager creates a new BatchManager with the given MongoDB client and logger.
+func NewBatchManager(client *mongo.Client, logger *log.Logger, unittest bool) *BatchManager {
+	return &BatchManager{
+		collection: client.Database("nerfdb").Collection("batches"),
+		logger:     logger,
+	}
+}
+
+// EnsureIndexes creates the index the batches of a user are removed by. Existing indexes are kept.
+func (bm *BatchManager) EnsureIndexes(ctx context.Context) error {
+	_, err := bm.collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "owner_id", Value: 1}}})
+	return err
+}
+
+// CreateBatch inserts a new batch, setting its ID and creation time if they are not set.
+func (bm *BatchManager) CreateBatch(ctx context.Context, b *Batch) error {
+	if b.ID.IsZero() {
+		b.ID = primitive.NewObjectID()
+	}
+	if b.CreatedAt.IsZero() {
+		b.CreatedAt = time.Now().UTC()
+	}
+	_, err := bm.collection.InsertOne(ctx, b)
+	return err
+}
+
+// GetBatch retrieves a batch by its ID.
+// Returns ErrBatchNotFound if the batch does not exist.
+func (bm *BatchManager) GetBatch(ctx context.Context, id primitive.ObjectID) (*Batch, error) {
+	var b Batch
+	err := bm.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&b)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrBatchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// DeleteUserBatches removes every batch of a user. The scenes of the batches are not removed.
+func (bm *BatchManager) DeleteUserBatches(ctx context.Context, ownerID primitive.ObjectID) error {
+	_, err := bm.collection.DeleteMany(ctx, bson.M{"owner_id": ownerID})
+	return err
+}
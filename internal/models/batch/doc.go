@@ -0,0 +1,5 @@
+// Package batch contains the implementation of batch uploads in the MongoDB database.
+// The BatchManager struct is responsible for interacting with the MongoDB batches collection.
+// The Batch struct groups the scenes created by one batch upload of several videos, along with the videos that were
+// rejected, so the progress of a whole capture session can be followed at once.
+package batch
@@ -0,0 +1,293 @@
+// This file contains the in-memory Store, used by tests and to run the server without a database.
+//
+// Documents are kept BSON encoded, so they decode exactly as they do from MongoDB or PostgreSQL. Transactions lock the
+// whole MemoryDB until they finish, and roll back by restoring a copy of it taken when they started, so every store
+// of the database must be used with the context passed to fn within a transaction, or it blocks until it ends.
+
+package docstore
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryDB holds the collections of in-memory stores. Stores of the same MemoryDB share its transactions.
+type MemoryDB struct {
+	mu          sync.Mutex
+	collections map[string]map[primitive.ObjectID]memoryDoc
+}
+
+// memoryDoc is a stored document, and the keys it was stored with.
+type memoryDoc struct {
+	raw  []byte
+	keys []Key
+}
+
+// memoryTxKey is the context key of the MemoryDB whose transaction a context belongs to.
+type memoryTxKey struct{}
+
+// NewMemoryDB creates an empty MemoryDB.
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{collections: make(map[string]map[primitive.ObjectID]memoryDoc)}
+}
+
+// lock locks the database until the returned function is called, unless ctx belongs to a transaction of it, which
+// holds the lock already.
+func (db *MemoryDB) lock(ctx context.Context) func() {
+	if ctx.Value(memoryTxKey{}) == db {
+		return func() {}
+	}
+	db.mu.Lock()
+	return db.mu.Unlock
+}
+
+// collection returns the documents of the named collection, creating it if needed. The database must be locked.
+func (db *MemoryDB) collection(name string) map[primitive.ObjectID]memoryDoc {
+	docs, ok := db.collections[name]
+	if !ok {
+		docs = make(map[primitive.ObjectID]memoryDoc)
+		db.collections[name] = docs
+	}
+	return docs
+}
+
+// Transaction runs fn with the database locked, restoring it as it was before if fn returns an error.
+func (db *MemoryDB) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(memoryTxKey{}) == db {
+		return fn(ctx)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Stored documents are never changed in place, so copying the maps is enough
+	snapshot := make(map[string]map[primitive.ObjectID]memoryDoc, len(db.collections))
+	for name, docs := range db.collections {
+		snapshot[name] = make(map[primitive.ObjectID]memoryDoc, len(docs))
+		for id, doc := range docs {
+			snapshot[name][id] = doc
+		}
+	}
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, db)); err != nil {
+		db.collections = snapshot
+		return err
+	}
+	return nil
+}
+
+// memoryStore is a Store keeping its documents in a MemoryDB.
+type memoryStore[T any] struct {
+	db     *MemoryDB
+	schema Schema[T]
+}
+
+// NewMemory creates a Store keeping the documents of schema in db.
+func NewMemory[T any](db *MemoryDB, schema Schema[T]) Store[T] {
+	return &memoryStore[T]{db: db, schema: schema}
+}
+
+func (s *memoryStore[T]) Init(ctx context.Context) error {
+	defer s.db.lock(ctx)()
+	s.db.collection(s.schema.Name)
+	return nil
+}
+
+func (s *memoryStore[T]) Get(ctx context.Context, id primitive.ObjectID) (*T, error) {
+	defer s.db.lock(ctx)()
+	doc, ok := s.db.collection(s.schema.Name)[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return decode[T](doc.raw)
+}
+
+func (s *memoryStore[T]) Find(ctx context.Context, kind string, values ...string) ([]*T, error) {
+	return s.filter(ctx, func(doc memoryDoc) bool {
+		return slices.ContainsFunc(doc.keys, func(key Key) bool {
+			return key.Kind == kind && slices.Contains(values, key.Value)
+		})
+	})
+}
+
+func (s *memoryStore[T]) All(ctx context.Context) ([]*T, error) {
+	return s.filter(ctx, func(memoryDoc) bool { return true })
+}
+
+func (s *memoryStore[T]) Select(ctx context.Context, q Query) ([]*T, int64, error) {
+	defer s.db.lock(ctx)()
+	docs := s.db.collection(s.schema.Name)
+
+	ids := s.matching(docs, func(id primitive.ObjectID, doc memoryDoc) bool {
+		if q.IDs != nil && !slices.Contains(q.IDs, id) {
+			return false
+		}
+		if !q.After.IsZero() && bytes.Compare(id[:], q.After[:]) <= 0 {
+			return false
+		}
+		for kind, values := range q.Keys {
+			if !slices.ContainsFunc(doc.keys, func(key Key) bool { return key.Kind == kind && slices.Contains(values, key.Value) }) {
+				return false
+			}
+		}
+		return !slices.ContainsFunc(doc.keys, func(key Key) bool { return slices.Contains(q.Without, key.Kind) })
+	})
+	if q.Descending {
+		slices.Reverse(ids)
+	}
+
+	total := int64(len(ids))
+	ids = ids[min(max(q.Skip, 0), total):]
+	if q.Limit > 0 && q.Limit < int64(len(ids)) {
+		ids = ids[:q.Limit]
+	}
+	result, err := decodeAll[T](docs, ids)
+	return result, total, err
+}
+
+// filter returns the documents match returns true for, in ID order.
+func (s *memoryStore[T]) filter(ctx context.Context, match func(doc memoryDoc) bool) ([]*T, error) {
+	defer s.db.lock(ctx)()
+	docs := s.db.collection(s.schema.Name)
+	ids := s.matching(docs, func(_ primitive.ObjectID, doc memoryDoc) bool { return match(doc) })
+	return decodeAll[T](docs, ids)
+}
+
+// matching returns the IDs of the documents match returns true for, in ID order. The database must be locked.
+func (s *memoryStore[T]) matching(docs map[primitive.ObjectID]memoryDoc, match func(id primitive.ObjectID, doc memoryDoc) bool) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(docs))
+	for id, doc := range docs {
+		if match(id, doc) {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(a, b primitive.ObjectID) int { return bytes.Compare(a[:], b[:]) })
+	return ids
+}
+
+// decodeAll decodes the documents with the given IDs, in order. The database must be locked.
+func decodeAll[T any](docs map[primitive.ObjectID]memoryDoc, ids []primitive.ObjectID) ([]*T, error) {
+	result := make([]*T, 0, len(ids))
+	for _, id := range ids {
+		doc, err := decode[T](docs[id].raw)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, doc)
+	}
+	return result, nil
+}
+
+func (s *memoryStore[T]) Insert(ctx context.Context, doc *T) error {
+	stored, err := s.encode(doc)
+	if err != nil {
+		return err
+	}
+
+	defer s.db.lock(ctx)()
+	docs := s.db.collection(s.schema.Name)
+	id := s.schema.ID(doc)
+	if _, ok := docs[id]; ok {
+		return ErrExists
+	}
+	if err := s.checkUnique(docs, id, stored.keys); err != nil {
+		return err
+	}
+	docs[id] = stored
+	return nil
+}
+
+func (s *memoryStore[T]) Update(ctx context.Context, id primitive.ObjectID, fn func(doc *T) error) error {
+	return s.update(ctx, id, false, fn)
+}
+
+func (s *memoryStore[T]) Upsert(ctx context.Context, id primitive.ObjectID, fn func(doc *T) error) error {
+	return s.update(ctx, id, true, fn)
+}
+
+// update runs fn on the document with the given ID and stores the result, starting from Schema.New if upsert is set
+// and there is no such document.
+func (s *memoryStore[T]) update(ctx context.Context, id primitive.ObjectID, upsert bool, fn func(doc *T) error) error {
+	defer s.db.lock(ctx)()
+	docs := s.db.collection(s.schema.Name)
+
+	var doc *T
+	if current, ok := docs[id]; ok {
+		var err error
+		if doc, err = decode[T](current.raw); err != nil {
+			return err
+		}
+	} else if upsert {
+		doc = s.schema.New(id)
+	} else {
+		return ErrNotFound
+	}
+
+	if err := fn(doc); err != nil {
+		return err
+	}
+	stored, err := s.encode(doc)
+	if err != nil {
+		return err
+	}
+	if err := s.checkUnique(docs, id, stored.keys); err != nil {
+		return err
+	}
+	docs[id] = stored
+	return nil
+}
+
+func (s *memoryStore[T]) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer s.db.lock(ctx)()
+	docs := s.db.collection(s.schema.Name)
+	if _, ok := docs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(docs, id)
+	return nil
+}
+
+func (s *memoryStore[T]) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return s.db.Transaction(ctx, fn)
+}
+
+// encode returns the document as it is stored.
+func (s *memoryStore[T]) encode(doc *T) (memoryDoc, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return memoryDoc{}, err
+	}
+	keys, err := keysOf(&s.schema, doc)
+	if err != nil {
+		return memoryDoc{}, err
+	}
+	return memoryDoc{raw: raw, keys: keys}, nil
+}
+
+// checkUnique returns a KeyConflictError if a document other than the one with the given ID has one of the unique keys.
+func (s *memoryStore[T]) checkUnique(docs map[primitive.ObjectID]memoryDoc, id primitive.ObjectID, keys []Key) error {
+	for _, key := range keys {
+		if !s.schema.Kinds[key.Kind] {
+			continue
+		}
+		for otherID, other := range docs {
+			if otherID != id && slices.Contains(other.keys, key) {
+				return &KeyConflictError{Kind: key.Kind}
+			}
+		}
+	}
+	return nil
+}
+
+// decode decodes a stored document.
+func decode[T any](raw []byte) (*T, error) {
+	doc := new(T)
+	if err := bson.Unmarshal(raw, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
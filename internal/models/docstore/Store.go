@@ -0,0 +1,159 @@
+// This file contains the Store interface, and the Schema describing the documents of a store.
+//
+// Every write of a Store is atomic: Update reads, changes, and writes a document without other writes to it in
+// between. Writes made within Transaction with the context passed to fn commit or roll back together, across every
+// store of the same database.
+
+package docstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrNotFound is returned when no document has the requested ID.
+	ErrNotFound = errors.New("document not found")
+	// ErrExists is returned when inserting a document with the ID of an existing document.
+	ErrExists = errors.New("document already exists")
+)
+
+// KeyConflictError is returned when a write would give two documents the same unique key.
+type KeyConflictError struct {
+	Kind string
+}
+
+func (e *KeyConflictError) Error() string {
+	return fmt.Sprintf("duplicate %s key", e.Kind)
+}
+
+// IsKeyConflict checks if err is a KeyConflictError of the given kind of key.
+func IsKeyConflict(err error, kind string) bool {
+	var conflict *KeyConflictError
+	return errors.As(err, &conflict) && conflict.Kind == kind
+}
+
+// Key is a value a document is looked up by. Kind names what the value is, i.e "username", and must be one of the
+// Schema's Kinds.
+type Key struct {
+	Kind  string
+	Value string
+}
+
+// Schema describes the documents of a store.
+type Schema[T any] struct {
+	// name of the collection, i.e the PostgreSQL table. Must be a plain SQL identifier
+	Name string
+	// kinds of keys documents are looked up by, and whether documents are unique by them
+	Kinds map[string]bool
+	// ID returns the ID of a document
+	ID func(doc *T) primitive.ObjectID
+	// New returns the empty document with the given ID, which Upsert starts from
+	New func(id primitive.ObjectID) *T
+	// Keys returns the keys of a document. Empty values are skipped
+	Keys func(doc *T) []Key
+}
+
+// Query selects a page of documents by their IDs and keys, see Store.Select.
+type Query struct {
+	// only documents with one of these IDs, if not nil (an empty, non-nil slice matches nothing)
+	IDs []primitive.ObjectID
+	// only documents with a key of each of these kinds with one of its values
+	Keys map[string][]string
+	// no documents with a key of any of these kinds
+	Without []string
+	// only documents with an ID after this one, if not zero. Object IDs start with their creation time, so this also
+	// selects the documents created after it
+	After primitive.ObjectID
+	// list documents in descending ID order, instead of ascending
+	Descending bool
+	// number of matching documents skipped, and the most returned if > 0
+	Skip, Limit int64
+}
+
+// Store keeps documents of type T by ID. Every method returns a freshly decoded copy of the stored documents, which
+// callers may change freely. Implementations must be safe for concurrent use.
+type Store[T any] interface {
+	// Init prepares the storage of the documents, and is called once at startup.
+	Init(ctx context.Context) error
+	// Get returns the document with the given ID, or ErrNotFound.
+	Get(ctx context.Context, id primitive.ObjectID) (*T, error)
+	// Find returns the documents with a key of the given kind with one of the values, in ID order.
+	Find(ctx context.Context, kind string, values ...string) ([]*T, error)
+	// All returns every document, in ID order.
+	All(ctx context.Context) ([]*T, error)
+	// Select returns the page of the documents matching q, in ID order, and the number of matching documents.
+	Select(ctx context.Context, q Query) ([]*T, int64, error)
+	// Insert stores a new document. Returns ErrExists if a document has its ID, or a KeyConflictError.
+	Insert(ctx context.Context, doc *T) error
+	// Update runs fn on the document with the given ID and stores the result. Nothing is stored if fn returns an
+	// error, which Update returns. Returns ErrNotFound if no document has the ID, or a KeyConflictError.
+	Update(ctx context.Context, id primitive.ObjectID, fn func(doc *T) error) error
+	// Upsert is Update, starting from Schema.New if no document has the ID.
+	Upsert(ctx context.Context, id primitive.ObjectID, fn func(doc *T) error) error
+	// Delete removes the document with the given ID. Returns ErrNotFound if no document has the ID.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// Transaction runs fn so the writes made with the context passed to it commit if it returns nil, and roll back
+	// otherwise. A Transaction within a transaction joins it.
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// keysOf returns the non-empty keys of a document, checking their kinds are in the schema.
+func keysOf[T any](schema *Schema[T], doc *T) ([]Key, error) {
+	if schema.Keys == nil {
+		return nil, nil
+	}
+	keys := make([]Key, 0)
+	for _, key := range schema.Keys(doc) {
+		if key.Value == "" {
+			continue
+		}
+		if _, ok := schema.Kinds[key.Kind]; !ok {
+			return nil, fmt.Errorf("key kind %q is not in the %s schema", key.Kind, schema.Name)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SetFields sets the fields of doc that fields encodes, as a MongoDB $set of fields does: fields that fields leaves
+// out, i.e by omitempty, keep their value, and the others are replaced whole.
+func SetFields[T any](doc *T, fields any) error {
+	var current, set bson.D
+	if err := remarshal(doc, &current); err != nil {
+		return err
+	}
+	if err := remarshal(fields, &set); err != nil {
+		return err
+	}
+
+	for _, field := range set {
+		i := slices.IndexFunc(current, func(e bson.E) bool { return e.Key == field.Key })
+		if i < 0 {
+			current = append(current, field)
+		} else {
+			current[i] = field
+		}
+	}
+
+	var merged T
+	if err := remarshal(current, &merged); err != nil {
+		return err
+	}
+	*doc = merged
+	return nil
+}
+
+// remarshal decodes the BSON encoding of in into out.
+func remarshal(in, out any) error {
+	raw, err := bson.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, out)
+}
@@ -0,0 +1,397 @@
+package docstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// thing is the document of the stores under test.
+type thing struct {
+	ID    primitive.ObjectID `bson:"_id"`
+	Name  string             `bson:"name"`
+	Tags  []string           `bson:"tags,omitempty"`
+	Count int                `bson:"count"`
+}
+
+var thingSchema = Schema[thing]{
+	Name:  "docstore_test_things",
+	Kinds: map[string]bool{"name": true, "tag": false},
+	ID:    func(th *thing) primitive.ObjectID { return th.ID },
+	New:   func(id primitive.ObjectID) *thing { return &thing{ID: id} },
+	Keys: func(th *thing) []Key {
+		keys := []Key{{Kind: "name", Value: th.Name}}
+		for _, tag := range th.Tags {
+			keys = append(keys, Key{Kind: "tag", Value: tag})
+		}
+		return keys
+	},
+}
+
+// forEachStore runs test against an empty memory store, and an empty PostgreSQL store if POSTGRES_TEST_URL is set.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store[thing])) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemory(NewMemoryDB(), thingSchema))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv("POSTGRES_TEST_URL")
+		if url == "" {
+			t.Skip("POSTGRES_TEST_URL is not set")
+		}
+		ctx := context.Background()
+		db, err := OpenPostgres(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS docstore_test_things_keys, docstore_test_things"); err != nil {
+			t.Fatal(err)
+		}
+		store, err := NewPostgres(db, thingSchema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
+		test(t, store)
+	})
+}
+
+func mustInsert(t *testing.T, store Store[thing], th *thing) {
+	t.Helper()
+	if err := store.Insert(context.Background(), th); err != nil {
+		t.Fatalf("insert %s: %v", th.Name, err)
+	}
+}
+
+func names(things []*thing) []string {
+	result := make([]string, 0, len(things))
+	for _, th := range things {
+		result = append(result, th.Name)
+	}
+	return result
+}
+
+func TestInsertAndGet(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store[thing]) {
+		ctx := context.Background()
+		th := &thing{ID: primitive.NewObjectID(), Name: "a", Tags: []string{"x"}, Count: 3}
+		mustInsert(t, store, th)
+
+		got, err := store.Get(ctx, th.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "a" || got.Count != 3 || len(got.Tags) != 1 || got.Tags[0] != "x" {
+			t.Errorf("got %+v, want %+v", got, th)
+		}
+
+		if err := store.Insert(ctx, &thing{ID: th.ID, Name: "b"}); !errors.Is(err, ErrExists) {
+			t.Errorf("insert of existing ID: got %v, want ErrExists", err)
+		}
+		if _, err := store.Get(ctx, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+			t.Errorf("get of missing ID: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestReturnsCopies(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store[thing]) {
+		ctx := context.Background()
+		th := &thing{ID: primitive.NewObjectID(), Name: "a"}
+		mustInsert(t, store, th)
+		th.Name = "changed"
+
+		got, err := store.Get(ctx, th.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.Count = 10
+		again, err := store.Get(ctx, th.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.Name != "a" || again.Count != 0 {
+			t.Errorf("stored document changed through a returned copy: %+v", again)
+		}
+	})
+}
+
+func TestUniqueKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store[thing]) {
+		ctx := context.Background()
+		a := &thing{ID: primitive.NewObjectID(), Name: "a", Tags: []string{"shared"}}
+		b := &thing{ID: primitive.NewObjectID(), Name: "b", Tags: []string{"shared"}}
+		mustInsert(t, store, a)
+		mustInsert(t, store, b)
+
+		err := store.Insert(ctx, &thing{ID: primitive.NewObjectID(), Name: "a"})
+		if !IsKeyConflict(err, "name") {
+			t.Errorf("insert of duplicate name: got %v, want a name conflict", err)
+		}
+		if _, err := store.Get(ctx, a.ID); err != nil {
+			t.Errorf("conflicting insert affected the existing document: %v", err)
+		}
+
+		err = store.Update(ctx, b.ID, func(th *thing) error {
+			th.Name = "a"
+			return nil
+		})
+		if !IsKeyConflict(err, "name") {
+			t.Errorf("update to duplicate name: got %v, want a name conflict", err)
+		}
+		got, err := store.Get(ctx, b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "b" {
+			t.Errorf("conflicting update was stored: name is %q", got.Name)
+		}
+
+		// A document keeps its own unique keys when updated
+		err = store.Update(ctx, a.ID, func(th *thing) error {
+			th.Count++
+			return nil
+		})
+		if err != nil {
+			t.Errorf("update keeping the name: %v", err)
+		}
+	})
+}
+
+func TestFind(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store[thing]) {
+		ctx := context.Background()
+		mustInsert(t, store, &thing{ID: primitive.NewObjectID(), Name: "a", Tags: []string{"x", "y"}})
+		mustInsert(t, store, &thing{ID: primitive.NewObjectID(), Name: "b", Tags: []string{"y"}})
+		mustInsert(t, store, &thing{ID: primitive.NewObjectID(), Name: "c", Tags: []string{"z"}})
+
+		tests := []struct {
+			values []string
+			want   []string
+		}{
+			{[]string{"x"}, []string{"a"}},
+			{[]string{"y"}, []string{"a", "b"}},
+			{[]string{"x", "y", "z"}, []string{"a", "b", "c"}},
+			{[]string{"missing"}, []string{}},
+			{nil, []string{}},
+		}
+		for _, tt := range tests {
+			found, err := store.Find(ctx, "tag", tt.values...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(found); !slices.Equal(got, tt.want) {
+				t.Errorf("Find(tag, %v) = %v, want %v", tt.values, got, tt.want)
+			}
+		}
+
+		all, err := store.All(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(all); !slices.Equal(got, []string{"a", "b", "c"}) {
+			t.Errorf("All() = %v, want every document in ID order", got)
+		}
+	})
+}
+
+func TestSelect(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store[thing]) {
+		ctx := context.Background()
+		things := []*thing{
+			{ID: primitive.NewObjectID(), Name: "a", Tags: []string{"x", "y"}},
+			{ID: primitive.NewObjectID(), Name: "b", Tags: []string{"y"}},
+			{ID: primitive.NewObjectID(), Name: "c", Tags: []string{"z"}},
+			{ID: primitive.NewObjectID(), Name: "d"},
+		}
+		for _, th := range things {
+			mustInsert(t, store, th)
+		}
+
+		tests := []struct {
+			name      string
+			query     Query
+			want      []string
+			wantTotal int64
+		}{
+			{"all", Query{}, []string{"a", "b", "c", "d"}, 4},
+			{"descending page", Query{Descending: true, Skip: 1, Limit: 2}, []string{"c", "b"}, 4},
+			{"skip past the end", Query{Skip: 10}, []string{}, 4},
+			{"keys", Query{Keys: map[string][]string{"tag": {"y", "z"}}}, []string{"a", "b", "c"}, 3},
+			{"every kind of keys", Query{Keys: map[string][]string{"tag": {"y"}, "name": {"b", "c"}}}, []string{"b"}, 1},
+			{"without", Query{Without: []string{"tag"}}, []string{"d"}, 1},
+			{"ids", Query{IDs: []primitive.ObjectID{things[3].ID, things[0].ID}}, []string{"a", "d"}, 2},
+			{"no ids", Query{IDs: []primitive.ObjectID{}}, []string{}, 0},
+			{"after", Query{After: things[1].ID, Limit: 1}, []string{"c"}, 2},
+		}
+		for _, tt := range tests {
+			got, total, err := store.Select(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if !slices.Equal(names(got), tt.want) || total != tt.wantTotal {
+				t.Errorf("%s: got %v of %d, want %v of %d", tt.name, names(got), total, tt.want, tt.wantTotal)
+			}
+		}
+	})
+}
+
+func TestUpdateAndUpsert(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store[thing]) {
+		ctx := context.Background()
+		id := primitive.NewObjectID()
+		increment := func(th *thing) error {
+			th.Count++
+			return nil
+		}
+
+		if err := store.Update(ctx, id, increment); !errors.Is(err, ErrNotFound) {
+			t.Errorf("update of missing document: got %v, want ErrNotFound", err)
+		}
+		if err := store.Upsert(ctx, id, increment); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Upsert(ctx, id, increment); err != nil {
+			t.Fatal(err)
+		}
+
+		failure := errors.New("failure")
+		err := store.Update(ctx, id, func(th *thing) error {
+			th.Count = 100
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Errorf("update with failing fn: got %v, want its error", err)
+		}
+
+		got, err := store.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Count != 2 {
+			t.Errorf("count is %d, want 2", got.Count)
+		}
+	})
+}
+
+func TestDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store[thing]) {
+		ctx := context.Background()
+		th := &thing{ID: primitive.NewObjectID(), Name: "a", Tags: []string{"x"}}
+		mustInsert(t, store, th)
+
+		if err := store.Delete(ctx, th.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete(ctx, th.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("second delete: got %v, want ErrNotFound", err)
+		}
+		found, err := store.Find(ctx, "tag", "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 0 {
+			t.Errorf("deleted document is still found by its keys")
+		}
+		// The keys of a deleted document are free again
+		mustInsert(t, store, &thing{ID: primitive.NewObjectID(), Name: "a"})
+	})
+}
+
+func TestTransaction(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store[thing]) {
+		ctx := context.Background()
+		kept := &thing{ID: primitive.NewObjectID(), Name: "kept"}
+		mustInsert(t, store, kept)
+
+		failure := errors.New("failure")
+		err := store.Transaction(ctx, func(ctx context.Context) error {
+			if err := store.Insert(ctx, &thing{ID: primitive.NewObjectID(), Name: "rolled back"}); err != nil {
+				return err
+			}
+			if err := store.Delete(ctx, kept.ID); err != nil {
+				return err
+			}
+			// A nested transaction joins the outer one
+			return store.Transaction(ctx, func(ctx context.Context) error { return failure })
+		})
+		if !errors.Is(err, failure) {
+			t.Fatalf("got %v, want the error of fn", err)
+		}
+		all, err := store.All(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(all); !slices.Equal(got, []string{"kept"}) {
+			t.Errorf("after rollback: %v, want [kept]", got)
+		}
+
+		err = store.Transaction(ctx, func(ctx context.Context) error {
+			// A failed write does not abort the transaction
+			if err := store.Insert(ctx, &thing{ID: primitive.NewObjectID(), Name: "kept"}); !IsKeyConflict(err, "name") {
+				t.Errorf("conflicting insert in transaction: got %v, want a name conflict", err)
+			}
+			return store.Insert(ctx, &thing{ID: primitive.NewObjectID(), Name: "committed"})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		all, err = store.All(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(all); !slices.Equal(got, []string{"kept", "committed"}) {
+			t.Errorf("after commit: %v, want [kept committed]", got)
+		}
+	})
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store[thing]) {
+		ctx := context.Background()
+		id := primitive.NewObjectID()
+		const updates = 20
+
+		var wg sync.WaitGroup
+		for range updates {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := store.Upsert(ctx, id, func(th *thing) error {
+					th.Count++
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		got, err := store.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Count != updates {
+			t.Errorf("count is %d after %d concurrent increments", got.Count, updates)
+		}
+	})
+}
+
+func TestSetFields(t *testing.T) {
+	doc := &thing{ID: primitive.NewObjectID(), Name: "a", Tags: []string{"x"}, Count: 3}
+	if err := SetFields(doc, &thing{ID: doc.ID, Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	// Tags are left out when empty, so they are kept, and the other fields are replaced
+	if doc.Name != "b" || doc.Count != 0 || len(doc.Tags) != 1 {
+		t.Errorf("got %+v, want name b, count 0, and the tags kept", doc)
+	}
+}
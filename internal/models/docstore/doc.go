@@ -0,0 +1,6 @@
+// Package docstore contains document stores for the repositories of the model packages that are not backed by
+// MongoDB: a PostgreSQL store, and an in-memory store used in tests and for running the server without a database.
+// A Store keeps documents by ID, encoded as BSON so they round-trip exactly as they do through MongoDB, and looks
+// them up by the keys its Schema derives from them, or selects pages of them by keys and IDs. The repositories built
+// on it, i.e scene.DocumentRepository, implement their other queries and updates in Go on top of it.
+package docstore
@@ -0,0 +1,135 @@
+// This file contains the IdempotencyManager implementation, which is responsible for interacting with the MongoDB
+// idempotency_keys collection. The IdempotencyManager struct contains a pointer to the collection and a logger. It
+// provides methods to reserve a key before its request is performed, store the response once it is, and release the
+// key of a request that failed so it can be retried.
+// Expired keys are removed by a TTL index.
+
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+var (
+	// ErrKeyExists is returned when reserving a key the user already used, along with the existing record.
+	ErrKeyExists = errors.New("idempotency key already used")
+)
+
+// Response is the response stored for a completed request.
+type Response struct {
+	StatusCode  int    `bson:"status_code"`
+	ContentType string `bson:"content_type,omitempty"`
+	Body        []byte `bson:"body,omitempty"`
+}
+
+// Record is an idempotency key of a user. Response is nil while the request is still being performed.
+type Record struct {
+	ID     primitive.ObjectID `bson:"_id"`
+	UserID primitive.ObjectID `bson:"user_id"`
+	Key    string             `bson:"key"`
+	// hash of the request the key was first sent with, so the key cannot be reused for another request
+	Fingerprint string    `bson:"fingerprint"`
+	Response    *Response `bson:"response,omitempty"`
+	CreatedAt   time.Time `bson:"created_at"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+type IdempotencyManager struct {
+	collection *mongo.Collection
+	logger     *log.Logger
+}
+
+// NewIdempotencyManager creates a new IdempotencyManager with the given MongoDB client and logger.
+func NewIdempotencyManager(client *mongo.Client, logger *log.Logger, unittest bool) *IdempotencyManager {
+	return &IdempotencyManager{
+		collection: client.Database("nerfdb").Collection("idempotency_keys"),
+		logger:     logger,
+	}
+}
+
+// EnsureIndexes creates the unique index keys are reserved by, and the TTL index removing expired keys. Existing
+// indexes are kept.
+func (im *IdempotencyManager) EnsureIndexes(ctx context.Context) error {
+	_, err := im.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// Reserve inserts a record for the key of a user, expiring after ttl. Only one of concurrent calls for the same key
+// succeeds.
+//
+// Returns ErrKeyExists, along with the existing record, if the user already used the key. Records that expired but
+// were not removed yet (the TTL index only runs about once a minute), and records still without a response after
+// pendingTimeout, i.e as the server performing the request stopped, are replaced instead.
+func (im *IdempotencyManager) Reserve(ctx context.Context, userID primitive.ObjectID, key, fingerprint string, ttl, pendingTimeout time.Duration) (*Record, error) {
+	now := time.Now().UTC()
+	record := &Record{
+		ID:          primitive.NewObjectID(),
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+
+	for {
+		_, err := im.collection.InsertOne(ctx, record)
+		if err == nil {
+			return record, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		var existing Record
+		err = im.collection.FindOne(ctx, bson.M{"user_id": userID, "key": key}).Decode(&existing)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Released or removed since the insert failed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		abandoned := existing.Response == nil && existing.CreatedAt.Add(pendingTimeout).Before(now)
+		if existing.ExpiresAt.After(now) && !abandoned {
+			return &existing, ErrKeyExists
+		}
+		filter := bson.M{"_id": existing.ID}
+		if abandoned {
+			// Kept if its request completed since it was read
+			filter["response"] = nil
+		}
+		if _, err := im.collection.DeleteOne(ctx, filter); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Complete stores the response of the request the record was reserved for.
+func (im *IdempotencyManager) Complete(ctx context.Context, id primitive.ObjectID, response Response) error {
+	_, err := im.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"response": response}})
+	return err
+}
+
+// Release removes the record, so the key can be used again, i.e after its request failed.
+func (im *IdempotencyManager) Release(ctx context.Context, id primitive.ObjectID) error {
+	_, err := im.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,5 @@
+// Package idempotency contains the implementation of idempotency keys in the MongoDB database.
+// The IdempotencyManager struct is responsible for interacting with the MongoDB idempotency_keys collection.
+// The Record struct is used to represent the key a client sent with a mutating request, along with the response it
+// got, so a retry of the request is answered with that response instead of being performed again.
+package idempotency
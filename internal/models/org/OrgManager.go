@@ -0,0 +1,384 @@
+// This file contains the OrgManager implementation, which is responsible for interacting with the MongoDB
+// organizations collection. The OrgManager struct contains a pointer to the nerfdb.organizations collection and a
+// logger. It provides methods to create, list and delete organizations, invite users, and manage members and their
+// roles.
+
+package org
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+var (
+	// ErrOrgNotFound is returned when an organization does not exist.
+	ErrOrgNotFound = errors.New("organization not found")
+	// ErrInvalidMemberRole is returned when a member is given a role that is not one of the member roles.
+	ErrInvalidMemberRole = errors.New("invalid member role")
+	// ErrMemberNotFound is returned when a user is not a member of an organization.
+	ErrMemberNotFound = errors.New("user is not a member of this organization")
+	// ErrAlreadyMember is returned when a user who is already a member is invited to an organization.
+	ErrAlreadyMember = errors.New("user is already a member of this organization")
+	// ErrInvitationNotFound is returned when a user has not been invited to an organization.
+	ErrInvitationNotFound = errors.New("invitation not found")
+	// ErrLastAdmin is returned when the last admin of an organization would leave it, or stop being an admin.
+	ErrLastAdmin = errors.New("an organization must keep at least one admin")
+)
+
+// Declarations for valid member roles
+const (
+	// may manage the organization and its members, and every scene of its workspace
+	RoleAdmin = "admin"
+	// may upload scenes into the workspace, and train and edit its scenes
+	RoleMember = "member"
+	// may read the scenes of the workspace and download their outputs
+	RoleViewer = "viewer"
+)
+
+// IsValidMemberRole checks if the given member role is valid
+func IsValidMemberRole(role string) bool {
+	return role == RoleAdmin || role == RoleMember || role == RoleViewer
+}
+
+// Organization is a team workspace. Members and Invitations hold at most one entry per user, and a user is never in
+// both.
+type Organization struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Name        string             `bson:"name" json:"name"`
+	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	Members     []Member           `bson:"members" json:"members"`
+	Invitations []Invitation       `bson:"invitations,omitempty" json:"invitations,omitempty"`
+}
+
+// Member is a user of an organization, and their role in it.
+type Member struct {
+	UserID   primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Role     string             `bson:"role" json:"role"`
+	JoinedAt time.Time          `bson:"joined_at" json:"joined_at"`
+}
+
+// Invitation is a pending invitation of a user to join an organization in a role.
+type Invitation struct {
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Role      string             `bson:"role" json:"role"`
+	InvitedBy primitive.ObjectID `bson:"invited_by" json:"invited_by"`
+	InvitedAt time.Time          `bson:"invited_at" json:"invited_at"`
+}
+
+// MemberRole returns the role of the given user in the organization, and whether they are a member.
+func (o *Organization) MemberRole(userID primitive.ObjectID) (string, bool) {
+	for _, member := range o.Members {
+		if member.UserID == userID {
+			return member.Role, true
+		}
+	}
+	return "", false
+}
+
+type OrgManager struct {
+	collection *mongo.Collection
+	logger     *log.Logger
+}
+
+// NewOrgManager creates a new OrgManager with the given MongoDB client and logger.
+func NewOrgManager(client *mongo.Client, logger *log.Logger, unittest bool) *OrgManager {
+	return &OrgManager{
+		collection: client.Database("nerfdb").Collection("organizations"),
+		logger:     logger,
+	}
+}
+
+// EnsureIndexes creates the indexes organizations are found by their members and invited users with. Existing indexes
+// are kept.
+func (om *OrgManager) EnsureIndexes(ctx context.Context) error {
+	_, err := om.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "members.user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "invitations.user_id", Value: 1}}},
+	})
+	return err
+}
+
+// CreateOrg inserts a new organization, with its creator as its only member, an admin.
+func (om *OrgManager) CreateOrg(ctx context.Context, name string, creatorID primitive.ObjectID) (*Organization, error) {
+	now := time.Now().UTC()
+	organization := &Organization{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		CreatedBy: creatorID,
+		CreatedAt: now,
+		Members:   []Member{{UserID: creatorID, Role: RoleAdmin, JoinedAt: now}},
+	}
+	if _, err := om.collection.InsertOne(ctx, organization); err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+// GetOrg retrieves an organization by its ID.
+// Returns ErrOrgNotFound if it does not exist.
+func (om *OrgManager) GetOrg(ctx context.Context, id primitive.ObjectID) (*Organization, error) {
+	var organization Organization
+	err := om.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&organization)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrOrgNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+// ListUserOrgs retrieves the organizations the given user is a member of or invited to, oldest first.
+func (om *OrgManager) ListUserOrgs(ctx context.Context, userID primitive.ObjectID) ([]*Organization, error) {
+	cursor, err := om.collection.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"members.user_id": userID},
+		bson.M{"invitations.user_id": userID},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	organizations := make([]*Organization, 0)
+	if err := cursor.All(ctx, &organizations); err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+// GetMemberRole returns the role of the given user in the organization.
+// Returns ErrMemberNotFound if the user is not a member, or ErrOrgNotFound if the organization does not exist.
+func (om *OrgManager) GetMemberRole(ctx context.Context, id, userID primitive.ObjectID) (string, error) {
+	organization, err := om.GetOrg(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	role, ok := organization.MemberRole(userID)
+	if !ok {
+		return "", ErrMemberNotFound
+	}
+	return role, nil
+}
+
+// DeleteOrg removes an organization.
+// Returns ErrOrgNotFound if it does not exist.
+func (om *OrgManager) DeleteOrg(ctx context.Context, id primitive.ObjectID) error {
+	result, err := om.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrOrgNotFound
+	}
+	return nil
+}
+
+// SetInvitation invites the user in the invitation to the organization, replacing the role of a pending invitation.
+// Returns ErrAlreadyMember if the user is a member, or ErrOrgNotFound if the organization does not exist.
+func (om *OrgManager) SetInvitation(ctx context.Context, id primitive.ObjectID, invitation Invitation) error {
+	if !IsValidMemberRole(invitation.Role) {
+		return ErrInvalidMemberRole
+	}
+
+	// Replace the role of an existing invitation in place, keeping who first invited the user and when
+	result, err := om.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "invitations.user_id": invitation.UserID},
+		bson.M{"$set": bson.M{"invitations.$.role": invitation.Role}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	result, err = om.collection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":                 id,
+			"members.user_id":     bson.M{"$ne": invitation.UserID},
+			"invitations.user_id": bson.M{"$ne": invitation.UserID},
+		},
+		bson.M{"$push": bson.M{"invitations": invitation}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		// Either the organization does not exist, the user is a member, or a concurrent invitation came first
+		organization, err := om.GetOrg(ctx, id)
+		if err != nil {
+			return err
+		}
+		if _, ok := organization.MemberRole(invitation.UserID); ok {
+			return ErrAlreadyMember
+		}
+		return om.SetInvitation(ctx, id, invitation)
+	}
+	return nil
+}
+
+// RemoveInvitation withdraws the invitation of the given user to the organization.
+// Returns ErrInvitationNotFound if the user is not invited, or ErrOrgNotFound if the organization does not exist.
+func (om *OrgManager) RemoveInvitation(ctx context.Context, id, userID primitive.ObjectID) error {
+	result, err := om.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$pull": bson.M{"invitations": bson.M{"user_id": userID}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrOrgNotFound
+	}
+	if result.ModifiedCount == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// AcceptInvitation makes the given user a member of the organization, in the role they were invited in. Returns the
+// role. The invitation is removed in the same update, so it is accepted at most once.
+// Returns ErrInvitationNotFound if the user is not invited, or ErrOrgNotFound if the organization does not exist.
+func (om *OrgManager) AcceptInvitation(ctx context.Context, id, userID primitive.ObjectID) (string, error) {
+	organization, err := om.GetOrg(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	var invitation *Invitation
+	for i := range organization.Invitations {
+		if organization.Invitations[i].UserID == userID {
+			invitation = &organization.Invitations[i]
+		}
+	}
+	if invitation == nil {
+		return "", ErrInvitationNotFound
+	}
+
+	member := Member{UserID: userID, Role: invitation.Role, JoinedAt: time.Now().UTC()}
+	result, err := om.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "invitations": bson.M{"$elemMatch": bson.M{"user_id": userID, "role": invitation.Role}}},
+		bson.M{
+			"$pull": bson.M{"invitations": bson.M{"user_id": userID}},
+			"$push": bson.M{"members": member},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	if result.MatchedCount == 0 {
+		// The invitation was withdrawn, accepted, or its role changed meanwhile
+		return om.AcceptInvitation(ctx, id, userID)
+	}
+	return member.Role, nil
+}
+
+// SetMemberRole changes the role of a member of the organization.
+// Returns ErrMemberNotFound if the user is not a member, ErrLastAdmin if they are its last admin and would stop being
+// one, or ErrOrgNotFound if the organization does not exist.
+func (om *OrgManager) SetMemberRole(ctx context.Context, id, userID primitive.ObjectID, role string) error {
+	if !IsValidMemberRole(role) {
+		return ErrInvalidMemberRole
+	}
+	filter, err := om.memberFilter(ctx, id, userID, role != RoleAdmin)
+	if err != nil {
+		return err
+	}
+	// The member is picked by an array filter, as the positional operator is ambiguous with the other admin matched
+	result, err := om.collection.UpdateOne(
+		ctx,
+		filter,
+		bson.M{"$set": bson.M{"members.$[member].role": role}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"member.user_id": userID}}}),
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return om.SetMemberRole(ctx, id, userID, role)
+	}
+	return nil
+}
+
+// RemoveMember removes a member from the organization.
+// Returns ErrMemberNotFound if the user is not a member, ErrLastAdmin if they are its last admin, or ErrOrgNotFound if
+// the organization does not exist.
+func (om *OrgManager) RemoveMember(ctx context.Context, id, userID primitive.ObjectID) error {
+	filter, err := om.memberFilter(ctx, id, userID, true)
+	if err != nil {
+		return err
+	}
+	result, err := om.collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"members": bson.M{"user_id": userID}}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return om.RemoveMember(ctx, id, userID)
+	}
+	return nil
+}
+
+// memberFilter returns the filter updating a member of the organization in their current role. If the member is an
+// admin and demoted is set, the filter also requires another admin, so the organization keeps one. An update matching
+// nothing means the organization changed meanwhile, and is retried with a new filter.
+func (om *OrgManager) memberFilter(ctx context.Context, id, userID primitive.ObjectID, demoted bool) (bson.M, error) {
+	organization, err := om.GetOrg(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	role, ok := organization.MemberRole(userID)
+	if !ok {
+		return nil, ErrMemberNotFound
+	}
+
+	memberMatch := bson.M{"$elemMatch": bson.M{"user_id": userID, "role": role}}
+	if role != RoleAdmin || !demoted {
+		return bson.M{"_id": id, "members": memberMatch}, nil
+	}
+	admins := 0
+	for _, member := range organization.Members {
+		if member.Role == RoleAdmin {
+			admins++
+		}
+	}
+	if admins < 2 {
+		return nil, ErrLastAdmin
+	}
+	return bson.M{
+		"_id": id,
+		"$and": bson.A{
+			bson.M{"members": memberMatch},
+			bson.M{"members": bson.M{"$elemMatch": bson.M{"user_id": bson.M{"$ne": userID}, "role": RoleAdmin}}},
+		},
+	}, nil
+}
+
+// RemoveUserFromAll removes the given user from every organization they are a member of or invited to, i.e once their
+// account is deleted. Organizations left without a member are deleted.
+func (om *OrgManager) RemoveUserFromAll(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := om.collection.UpdateMany(
+		ctx,
+		bson.M{"$or": bson.A{bson.M{"members.user_id": userID}, bson.M{"invitations.user_id": userID}}},
+		bson.M{"$pull": bson.M{
+			"members":     bson.M{"user_id": userID},
+			"invitations": bson.M{"user_id": userID},
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = om.collection.DeleteMany(ctx, bson.M{"members": bson.M{"$size": 0}})
+	return err
+}
@@ -0,0 +1,5 @@
+// Package org contains the implementation of organizations in the MongoDB database.
+// The OrgManager struct is responsible for interacting with the MongoDB organizations collection.
+// The Organization struct is a team workspace, with its members and the users invited to join it. Scenes uploaded into
+// a workspace record the organization they belong to, see scene.Scene.OrgID, so the organization does not list them.
+package org
@@ -0,0 +1,138 @@
+// This file contains the OutboxManager implementation, which is responsible for interacting with the MongoDB outbox collection.
+// The OutboxManager struct contains a pointer to the nerfdb.outbox MongoDB collection and a logger. It provides methods to
+// add messages, list the messages due to be sent, and record the outcome of sending them.
+// Sent messages are removed by a TTL index after sentRetention.
+
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+// sentRetention is how long sent messages are kept, i.e to check when the job of a scene was published.
+const sentRetention = 24 * time.Hour
+
+// Message is a job waiting to be published, or published, to a queue of the message broker.
+type Message struct {
+	ID primitive.ObjectID `bson:"_id"`
+	// queue the job is published to, i.e "sfm-in"
+	Queue    string            `bson:"queue"`
+	Body     []byte            `bson:"body"`
+	Priority uint8             `bson:"priority"`
+	Headers  map[string]string `bson:"headers,omitempty"`
+	// tier of the job queue the job is published to, see broker.TieredQueue
+	Tier string `bson:"tier,omitempty"`
+	// scene the job is for, and the labels of its metrics
+	SceneID      primitive.ObjectID `bson:"scene_id"`
+	Stage        string             `bson:"stage"`
+	TrainingMode string             `bson:"training_mode"`
+	CreatedAt    time.Time          `bson:"created_at"`
+	// failed attempts to publish the message, the error of the last one, and when to try again
+	Attempts      int       `bson:"attempts"`
+	LastError     string    `bson:"last_error,omitempty"`
+	NextAttemptAt time.Time `bson:"next_attempt_at"`
+	// when the broker accepted the message, nil until then
+	SentAt *time.Time `bson:"sent_at,omitempty"`
+}
+
+type OutboxManager struct {
+	collection *mongo.Collection
+	logger     *log.Logger
+}
+
+// NewOutboxManager creates a new OutboxManager with the given MongoDB client and logger.
+func NewOutboxManager(client *mongo.Client, logger *log.Logger, unittest bool) *OutboxManager {
+	return &OutboxManager{
+		collection: client.Database("nerfdb").Collection("outbox"),
+		logger:     logger,
+	}
+}
+
+// EnsureIndexes creates the index pending messages are listed by, and the TTL index removing sent messages.
+// Existing indexes are kept.
+func (om *OutboxManager) EnsureIndexes(ctx context.Context) error {
+	_, err := om.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "sent_at", Value: 1}, {Key: "next_attempt_at", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "scene_id", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "sent_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(sentRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+// AddMessage inserts a message to be sent, due right away. It should be called with the context of the transaction
+// making the changes the message is published for, see scene.SceneManager.WithTransaction.
+func (om *OutboxManager) AddMessage(ctx context.Context, message *Message) error {
+	if message.ID.IsZero() {
+		message.ID = primitive.NewObjectID()
+	}
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+	if message.NextAttemptAt.IsZero() {
+		message.NextAttemptAt = message.CreatedAt
+	}
+	_, err := om.collection.InsertOne(ctx, message)
+	return err
+}
+
+// GetDueMessages retrieves up to limit unsent messages due to be sent by now, oldest first.
+func (om *OutboxManager) GetDueMessages(ctx context.Context, now time.Time, limit int64) ([]*Message, error) {
+	cursor, err := om.collection.Find(
+		ctx,
+		bson.M{"sent_at": nil, "next_attempt_at": bson.M{"$lte": now}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// HasPendingMessage checks if a scene has a message that was not sent yet.
+func (om *OutboxManager) HasPendingMessage(ctx context.Context, sceneID primitive.ObjectID) (bool, error) {
+	count, err := om.collection.CountDocuments(ctx, bson.M{"scene_id": sceneID, "sent_at": nil}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CountPending returns the number of messages that were not sent yet.
+func (om *OutboxManager) CountPending(ctx context.Context) (int64, error) {
+	return om.collection.CountDocuments(ctx, bson.M{"sent_at": nil})
+}
+
+// MarkSent records that the broker accepted a message, so it is not sent again.
+func (om *OutboxManager) MarkSent(ctx context.Context, id primitive.ObjectID) error {
+	_, err := om.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"sent_at": time.Now()}})
+	return err
+}
+
+// MarkFailed records a failed attempt to send a message, and when to try again.
+func (om *OutboxManager) MarkFailed(ctx context.Context, id primitive.ObjectID, sendErr error, next time.Time) error {
+	_, err := om.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$inc": bson.M{"attempts": 1},
+			"$set": bson.M{"last_error": sendErr.Error(), "next_attempt_at": next},
+		},
+	)
+	return err
+}
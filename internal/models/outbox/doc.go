@@ -0,0 +1,6 @@
+// Package outbox contains the implementation of the job outbox in the MongoDB database.
+// The OutboxManager struct is responsible for interacting with the MongoDB outbox collection.
+// The Message struct represents a job to publish to the message broker, which is written in the same transaction as
+// the scene changes it is published for, and marked as sent by the dispatcher once the broker accepted it. Sent
+// messages are removed by a TTL index.
+package outbox
@@ -5,6 +5,14 @@ package queue
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// IDs of the processing queues. A scene is in QueueListID from the moment its first job is published until it
+// completes, and in SfmListID or NerfListID while the job of that stage is in flight.
+const (
+	QueueListID = "queue_list"
+	SfmListID   = "sfm_list"
+	NerfListID  = "nerf_list"
+)
+
 // QueueList represents a list of items in a queue.
 // Used for reporting job processing progress.
 type QueueList struct {
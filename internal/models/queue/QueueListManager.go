@@ -48,7 +48,7 @@ func NewQueueListManager(client *mongo.Client, logger *log.Logger, unittest bool
 	db := client.Database("nerfdb")
 	return &QueueListManager{
 		collection: db.Collection("queues"),
-		queueNames: []string{"queue_list", "sfm_list", "nerf_list"},
+		queueNames: []string{QueueListID, SfmListID, NerfListID},
 		logger:     logger,
 	}
 }
@@ -0,0 +1,159 @@
+// This file contains the collaborators of a scene, which are users other than its owner that the scene is shared with.
+//
+// Collaborators are stored on the scene document rather than on their user document, so a scene's shares are removed
+// along with it. Each collaborator has a role, which decides what they may do with the scene, see ClientService.ShareScene.
+
+package scene
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrInvalidCollaboratorRole is returned when a scene is shared with a role that is not one of the collaborator roles.
+	ErrInvalidCollaboratorRole = errors.New("invalid collaborator role")
+	// ErrCollaboratorNotFound is returned when a scene is not shared with the given user.
+	ErrCollaboratorNotFound = errors.New("scene is not shared with this user")
+)
+
+// Declarations for valid collaborator roles
+const (
+	CollaboratorRoleViewer = "viewer"
+	CollaboratorRoleEditor = "editor"
+)
+
+// IsValidCollaboratorRole checks if the given collaborator role is valid
+func IsValidCollaboratorRole(role string) bool {
+	return role == CollaboratorRoleViewer || role == CollaboratorRoleEditor
+}
+
+// Collaborator is a user a scene is shared with, and their role on it.
+type Collaborator struct {
+	UserID   primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Role     string             `bson:"role" json:"role"`
+	SharedAt time.Time          `bson:"shared_at" json:"shared_at"`
+}
+
+// SetCollaborator shares the scene with the given user in the given role, replacing the role of a user it is already
+// shared with.
+func (sm *SceneManager) SetCollaborator(ctx context.Context, id, userID primitive.ObjectID, role string) error {
+	if !IsValidCollaboratorRole(role) {
+		return ErrInvalidCollaboratorRole
+	}
+
+	// Update the role of an existing collaborator in place, keeping when the scene was first shared with them
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "collaborators.user_id": userID},
+		bson.M{"$set": bson.M{"collaborators.$.role": role}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	collaborator := Collaborator{UserID: userID, Role: role, SharedAt: time.Now().UTC()}
+	result, err = sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "collaborators.user_id": bson.M{"$ne": userID}},
+		bson.M{"$push": bson.M{"collaborators": collaborator}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		// Either the scene does not exist, or a concurrent share added the user first
+		if _, err := sm.GetCollaboratorRole(ctx, id, userID); err != nil {
+			return err
+		}
+		return sm.SetCollaborator(ctx, id, userID, role)
+	}
+	return nil
+}
+
+// RemoveCollaborator stops sharing the scene with the given user.
+// Returns ErrCollaboratorNotFound if the scene is not shared with the user.
+func (sm *SceneManager) RemoveCollaborator(ctx context.Context, id, userID primitive.ObjectID) error {
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$pull": bson.M{"collaborators": bson.M{"user_id": userID}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSceneNotFound
+	}
+	if result.ModifiedCount == 0 {
+		return ErrCollaboratorNotFound
+	}
+	return nil
+}
+
+// RemoveCollaboratorFromAll stops sharing every scene with the given user, i.e once their account is deleted.
+func (sm *SceneManager) RemoveCollaboratorFromAll(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := sm.collection.UpdateMany(
+		ctx,
+		bson.M{"collaborators.user_id": userID},
+		bson.M{"$pull": bson.M{"collaborators": bson.M{"user_id": userID}}},
+	)
+	return err
+}
+
+// GetCollaboratorRole returns the role of the given user on the scene.
+// Returns ErrCollaboratorNotFound if the scene is not shared with the user, or ErrSceneNotFound if it does not exist.
+func (sm *SceneManager) GetCollaboratorRole(ctx context.Context, id, userID primitive.ObjectID) (string, error) {
+	var result struct {
+		Collaborators []Collaborator `bson:"collaborators"`
+	}
+	err := sm.collection.FindOne(
+		ctx,
+		bson.M{"_id": id},
+		options.FindOne().SetProjection(bson.M{"collaborators": 1}),
+	).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", ErrSceneNotFound
+		}
+		return "", err
+	}
+
+	for _, c := range result.Collaborators {
+		if c.UserID == userID {
+			return c.Role, nil
+		}
+	}
+	return "", ErrCollaboratorNotFound
+}
+
+// GetCollaborators returns every user the scene is shared with, in the order it was shared with them.
+func (sm *SceneManager) GetCollaborators(ctx context.Context, id primitive.ObjectID) ([]Collaborator, error) {
+	var result struct {
+		Collaborators []Collaborator `bson:"collaborators"`
+	}
+	err := sm.collection.FindOne(
+		ctx,
+		bson.M{"_id": id},
+		options.FindOne().SetProjection(bson.M{"collaborators": 1}),
+	).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSceneNotFound
+		}
+		return nil, err
+	}
+	if result.Collaborators == nil {
+		return make([]Collaborator, 0), nil
+	}
+	return result.Collaborators, nil
+}
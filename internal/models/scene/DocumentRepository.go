@@ -0,0 +1,1085 @@
+// This file contains the DocumentRepository, the SceneRepository of the databases other than MongoDB: PostgreSQL, and
+// memory for tests and running without a database. Scenes are stored whole in a docstore.Store, and every query and
+// update of the SceneManager is implemented in Go on top of it, with the same behavior and errors.
+//
+// Updates of a scene read, change and write it atomically, see docstore.Store.Update, which replaces the update
+// filters SceneManager checks states with. Scenes are looked up by their state, organization, collaborators and
+// whether they are in the trash, which listings filter and page by in the store, see ListScenes; every other query
+// filters the scenes in Go, so they are meant for the scene counts of small deployments and tests rather than large
+// ones. Searches match whole words without stemming, see textScore.
+
+package scene
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/docstore"
+)
+
+// Kinds of keys scenes are looked up by, see sceneSchema
+const (
+	keyState        = "state"
+	keyOrg          = "org"
+	keyCollaborator = "collaborator"
+	keyTrash        = "trash"
+)
+
+// sceneSchema describes scene documents to a docstore.Store.
+var sceneSchema = docstore.Schema[Scene]{
+	Name:  "scenes",
+	Kinds: map[string]bool{keyState: false, keyOrg: false, keyCollaborator: false, keyTrash: false},
+	ID:    func(sc *Scene) primitive.ObjectID { return sc.ID },
+	New:   func(id primitive.ObjectID) *Scene { return &Scene{ID: id} },
+	Keys: func(sc *Scene) []docstore.Key {
+		keys := make([]docstore.Key, 0, 3+len(sc.Collaborators))
+		if sc.Status != nil {
+			keys = append(keys, docstore.Key{Kind: keyState, Value: string(sc.Status.State)})
+		}
+		if !sc.OrgID.IsZero() {
+			keys = append(keys, docstore.Key{Kind: keyOrg, Value: sc.OrgID.Hex()})
+		}
+		if sc.TrashedAt != nil {
+			keys = append(keys, docstore.Key{Kind: keyTrash, Value: keyTrash})
+		}
+		for _, c := range sc.Collaborators {
+			keys = append(keys, docstore.Key{Kind: keyCollaborator, Value: c.UserID.Hex()})
+		}
+		return keys
+	},
+}
+
+// DocumentRepository is the SceneRepository storing scenes in a docstore.Store.
+type DocumentRepository struct {
+	layout
+	store  docstore.Store[Scene]
+	logger *log.Logger
+}
+
+// DocumentRepository is a SceneRepository.
+var _ SceneRepository = (*DocumentRepository)(nil)
+
+// NewPostgresRepository creates a DocumentRepository storing scenes in db. Its transactions include the writes of the
+// other repositories of db, i.e the user.DocumentRepository. See NewSceneManager for jobIDPrefix.
+func NewPostgresRepository(db *sql.DB, logger *log.Logger, jobIDPrefix string) (*DocumentRepository, error) {
+	store, err := docstore.NewPostgres(db, sceneSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &DocumentRepository{layout: layout{jobIDPrefix: jobIDPrefix}, store: store, logger: logger}, nil
+}
+
+// NewMemoryRepository creates a DocumentRepository keeping scenes in db. See NewSceneManager for jobIDPrefix.
+func NewMemoryRepository(db *docstore.MemoryDB, logger *log.Logger, jobIDPrefix string) *DocumentRepository {
+	return &DocumentRepository{
+		layout: layout{jobIDPrefix: jobIDPrefix},
+		store:  docstore.NewMemory(db, sceneSchema),
+		logger: logger,
+	}
+}
+
+// get retrieves the scene with the given ID.
+// Returns ErrSceneNotFound if the scene does not exist.
+func (r *DocumentRepository) get(ctx context.Context, id primitive.ObjectID) (*Scene, error) {
+	sc, err := r.store.Get(ctx, id)
+	if errors.Is(err, docstore.ErrNotFound) {
+		return nil, ErrSceneNotFound
+	}
+	return sc, err
+}
+
+// update runs fn on the scene with the given ID and stores the result, unless fn returns an error.
+// Returns ErrSceneNotFound if the scene does not exist.
+func (r *DocumentRepository) update(ctx context.Context, id primitive.ObjectID, fn func(sc *Scene) error) error {
+	err := r.store.Update(ctx, id, fn)
+	if errors.Is(err, docstore.ErrNotFound) {
+		return ErrSceneNotFound
+	}
+	return err
+}
+
+// upsert is update, creating the scene if it does not exist, as the upserting setters of SceneManager do.
+func (r *DocumentRepository) upsert(ctx context.Context, id primitive.ObjectID, fn func(sc *Scene)) error {
+	return r.store.Upsert(ctx, id, func(sc *Scene) error {
+		fn(sc)
+		return nil
+	})
+}
+
+// updateAll runs fn on every scene with a key of the given kind and value, in a transaction.
+func (r *DocumentRepository) updateAll(ctx context.Context, kind, value string, fn func(sc *Scene)) error {
+	return r.store.Transaction(ctx, func(ctx context.Context) error {
+		scenes, err := r.store.Find(ctx, kind, value)
+		if err != nil {
+			return err
+		}
+		for _, sc := range scenes {
+			err := r.update(ctx, sc.ID, func(sc *Scene) error {
+				fn(sc)
+				return nil
+			})
+			if err != nil && !errors.Is(err, ErrSceneNotFound) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// findInStates retrieves every scene in one of the given states.
+func (r *DocumentRepository) findInStates(ctx context.Context, states ...State) ([]*Scene, error) {
+	values := make([]string, 0, len(states))
+	for _, state := range states {
+		values = append(values, string(state))
+	}
+	return r.store.Find(ctx, keyState, values...)
+}
+
+// EnsureIndexes prepares the storage of scenes.
+func (r *DocumentRepository) EnsureIndexes(ctx context.Context) error {
+	return r.store.Init(ctx)
+}
+
+// CreateScene inserts a new scene, see SceneManager.CreateScene.
+func (r *DocumentRepository) CreateScene(ctx context.Context, newScene *Scene) error {
+	if newScene.ID.IsZero() {
+		return errors.New("scene has no ID")
+	}
+	err := r.store.Insert(ctx, newScene)
+	if errors.Is(err, docstore.ErrExists) {
+		return ErrSceneAlreadyExists
+	}
+	return err
+}
+
+// SetScene sets the fields of scene that are not empty on the scene with the given ID, creating it if needed, as a
+// MongoDB $set of the scene does.
+func (r *DocumentRepository) SetScene(ctx context.Context, id primitive.ObjectID, scene *Scene) error {
+	return r.store.Upsert(ctx, id, func(sc *Scene) error {
+		if err := docstore.SetFields(sc, scene); err != nil {
+			return err
+		}
+		sc.ID = id
+		return nil
+	})
+}
+
+// GetScene retrieves the scene with the given ID.
+func (r *DocumentRepository) GetScene(ctx context.Context, id primitive.ObjectID) (*Scene, error) {
+	return r.get(ctx, id)
+}
+
+// DeleteScene deletes the scene with the given ID.
+func (r *DocumentRepository) DeleteScene(ctx context.Context, id primitive.ObjectID) error {
+	err := r.store.Delete(ctx, id)
+	if errors.Is(err, docstore.ErrNotFound) {
+		return ErrSceneNotFound
+	}
+	return err
+}
+
+// SetSceneName sets the name of the scene with the given ID, creating it if needed.
+func (r *DocumentRepository) SetSceneName(ctx context.Context, id primitive.ObjectID, name string) error {
+	return r.upsert(ctx, id, func(sc *Scene) { sc.Name = name })
+}
+
+// GetSceneName retrieves the name of the scene with the given ID.
+func (r *DocumentRepository) GetSceneName(ctx context.Context, id primitive.ObjectID) (string, error) {
+	sc, err := r.get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return sc.Name, nil
+}
+
+// UpdateSceneMetadata sets the fields of update on the scene, see SceneManager.UpdateSceneMetadata.
+func (r *DocumentRepository) UpdateSceneMetadata(ctx context.Context, id primitive.ObjectID, update SceneMetadataUpdate) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		if update.Name != nil {
+			sc.Name = *update.Name
+		}
+		if update.Description != nil {
+			sc.Description = *update.Description
+		}
+		if update.Tags != nil {
+			sc.Tags = update.Tags
+		}
+		return nil
+	})
+}
+
+// GetSceneOrgID retrieves the organization whose workspace the scene is in, nil if it is in none.
+func (r *DocumentRepository) GetSceneOrgID(ctx context.Context, id primitive.ObjectID) (primitive.ObjectID, error) {
+	sc, err := r.get(ctx, id)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return sc.OrgID, nil
+}
+
+// RemoveOrgFromAll takes every scene out of the workspace of the given organization.
+func (r *DocumentRepository) RemoveOrgFromAll(ctx context.Context, orgID primitive.ObjectID) error {
+	return r.updateAll(ctx, keyOrg, orgID.Hex(), func(sc *Scene) { sc.OrgID = primitive.NilObjectID })
+}
+
+// SetTrainingConfig sets the training config of the scene with the given ID, creating it if needed.
+func (r *DocumentRepository) SetTrainingConfig(ctx context.Context, id primitive.ObjectID, config *TrainingConfig) error {
+	return r.upsert(ctx, id, func(sc *Scene) { sc.Config = config })
+}
+
+// GetTrainingConfig retrieves the training config of the scene with the given ID.
+func (r *DocumentRepository) GetTrainingConfig(ctx context.Context, id primitive.ObjectID) (*TrainingConfig, error) {
+	sc, err := r.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sc.Config == nil {
+		return nil, ErrTrainingConfigNotFound
+	}
+	return sc.Config, nil
+}
+
+// SetVideo sets the video of the scene with the given ID, creating it if needed.
+func (r *DocumentRepository) SetVideo(ctx context.Context, id primitive.ObjectID, vid *Video) error {
+	return r.upsert(ctx, id, func(sc *Scene) { sc.Video = vid })
+}
+
+// GetVideo retrieves the video of the scene with the given ID.
+func (r *DocumentRepository) GetVideo(ctx context.Context, id primitive.ObjectID) (*Video, error) {
+	sc, err := r.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sc.Video == nil {
+		return nil, ErrVideoNotFound
+	}
+	return sc.Video, nil
+}
+
+// SetSfm sets the sfm output of the scene with the given ID, creating it if needed.
+func (r *DocumentRepository) SetSfm(ctx context.Context, id primitive.ObjectID, sfm *Sfm) error {
+	return r.upsert(ctx, id, func(sc *Scene) { sc.Sfm = sfm })
+}
+
+// GetSfm retrieves the sfm output of the scene with the given ID.
+func (r *DocumentRepository) GetSfm(ctx context.Context, id primitive.ObjectID) (*Sfm, error) {
+	sc, err := r.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sc.Sfm == nil {
+		return nil, ErrSfmNotFound
+	}
+	return sc.Sfm, nil
+}
+
+// SetNerf sets the nerf output of the scene with the given ID, creating it if needed.
+func (r *DocumentRepository) SetNerf(ctx context.Context, id primitive.ObjectID, nerf *Nerf) error {
+	return r.upsert(ctx, id, func(sc *Scene) { sc.Nerf = nerf })
+}
+
+// GetNerf retrieves the nerf output of the scene with the given ID.
+func (r *DocumentRepository) GetNerf(ctx context.Context, id primitive.ObjectID) (*Nerf, error) {
+	sc, err := r.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sc.Nerf == nil {
+		return nil, ErrNerfNotFound
+	}
+	return sc.Nerf, nil
+}
+
+// SetOutputFile records the file path of a single output type and iteration, see SceneManager.SetOutputFile.
+func (r *DocumentRepository) SetOutputFile(ctx context.Context, id primitive.ObjectID, outputType string, iteration int, path, checksum string) error {
+	if _, err := outputPathsField(outputType); err != nil {
+		return err
+	}
+	return r.update(ctx, id, func(sc *Scene) error {
+		if sc.Nerf == nil {
+			sc.Nerf = &Nerf{}
+		}
+		paths := outputPaths(sc.Nerf, outputType)
+		if *paths == nil {
+			*paths = make(map[int]string)
+		}
+		(*paths)[iteration] = path
+
+		if outputType == OutputTypeCheckpoint {
+			if sc.Nerf.CheckpointChecksums == nil {
+				sc.Nerf.CheckpointChecksums = make(map[int]string)
+			}
+			sc.Nerf.CheckpointChecksums[iteration] = checksum
+		}
+		return nil
+	})
+}
+
+// outputPaths returns the file paths of a valid output type, for setting them. Export paths are added to the nerf
+// output if it has none of the type yet.
+func outputPaths(n *Nerf, outputType string) *map[int]string {
+	switch outputType {
+	case "model":
+		return &n.ModelFilePathsMap
+	case "splat_cloud":
+		return &n.SplatCloudFilePathsMap
+	case "point_cloud":
+		return &n.PointCloudFilePathsMap
+	case "video":
+		return &n.VideoFilePathsMap
+	case OutputTypeCheckpoint:
+		return &n.CheckpointFilePathsMap
+	}
+
+	if n.ExportFilePathsMap == nil {
+		n.ExportFilePathsMap = make(map[string]map[int]string)
+	}
+	if n.ExportFilePathsMap[outputType] == nil {
+		n.ExportFilePathsMap[outputType] = make(map[int]string)
+	}
+	paths := n.ExportFilePathsMap[outputType]
+	return &paths
+}
+
+// GetCompletedIterations returns the iterations of the given output type, see SceneManager.GetCompletedIterations.
+func (r *DocumentRepository) GetCompletedIterations(ctx context.Context, id primitive.ObjectID, outputType string) ([]int, error) {
+	nerf, err := r.GetNerf(ctx, id)
+	if errors.Is(err, ErrNerfNotFound) {
+		return make([]int, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nerf.CompletedIterations(outputType)
+}
+
+// SetExport records the state of an export of a scene with nerf output, see SceneManager.SetExport.
+func (r *DocumentRepository) SetExport(ctx context.Context, id primitive.ObjectID, format string, export *Export) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		if sc.Nerf == nil {
+			return ErrNerfNotFound
+		}
+		if sc.Nerf.Exports == nil {
+			sc.Nerf.Exports = make(map[string]*Export)
+		}
+		sc.Nerf.Exports[format] = export
+		return nil
+	})
+}
+
+// SetRender records the state of a render of a scene with nerf output, see SceneManager.SetRender.
+func (r *DocumentRepository) SetRender(ctx context.Context, id primitive.ObjectID, render *Render) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		if sc.Nerf == nil {
+			return ErrNerfNotFound
+		}
+		if sc.Nerf.Renders == nil {
+			sc.Nerf.Renders = make(map[string]*Render)
+		}
+		sc.Nerf.Renders[render.ID.Hex()] = render
+		return nil
+	})
+}
+
+// GetRender retrieves a render of a scene, see SceneManager.GetRender.
+func (r *DocumentRepository) GetRender(ctx context.Context, id, renderID primitive.ObjectID) (*Render, error) {
+	nerf, err := r.GetNerf(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	render, ok := nerf.Renders[renderID.Hex()]
+	if !ok {
+		return nil, ErrRenderNotFound
+	}
+	return render, nil
+}
+
+// GetStatus retrieves the status of the scene with the given ID.
+func (r *DocumentRepository) GetStatus(ctx context.Context, id primitive.ObjectID) (*SceneStatus, error) {
+	sc, err := r.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sc.Status == nil {
+		return nil, ErrStatusNotFound
+	}
+	return sc.Status, nil
+}
+
+// TransitionStatus moves the scene to the given state, see SceneManager.TransitionStatus.
+func (r *DocumentRepository) TransitionStatus(ctx context.Context, id primitive.ObjectID, state State, errMsg string) error {
+	return r.transitionStatus(ctx, id, state, errMsg, nil)
+}
+
+// FailJob moves the scene to StateFailed and records the failure, see SceneManager.FailJob.
+func (r *DocumentRepository) FailJob(ctx context.Context, id primitive.ObjectID, failure *JobFailure) error {
+	return r.transitionStatus(ctx, id, StateFailed, failure.Reason, failure)
+}
+
+// transitionStatus moves the scene to the given state, see TransitionStatus, recording the failure if not nil.
+func (r *DocumentRepository) transitionStatus(ctx context.Context, id primitive.ObjectID, state State, errMsg string, failure *JobFailure) error {
+	if !state.IsValid() {
+		return ErrInvalidState
+	}
+
+	return r.update(ctx, id, func(sc *Scene) error {
+		if sc.Status == nil {
+			return ErrStatusNotFound
+		}
+		if !sc.Status.State.CanTransitionTo(state) {
+			r.logger.Warnf("Rejected status transition for scene %s: %s -> %s", id.Hex(), sc.Status.State, state)
+			return ErrInvalidStatusTransition
+		}
+
+		now := time.Now()
+		sc.Status.State = state
+		sc.Status.Error = errMsg
+		sc.Status.UpdatedAt = now
+		if sc.Status.EnteredAt == nil {
+			sc.Status.EnteredAt = make(map[State]time.Time)
+		}
+		sc.Status.EnteredAt[state] = now
+		if failure != nil {
+			sc.Status.Failure = failure
+		}
+		return nil
+	})
+}
+
+// RestartScene starts processing a scene in a terminal state again, see SceneManager.RestartScene.
+func (r *DocumentRepository) RestartScene(ctx context.Context, id primitive.ObjectID, config *TrainingConfig, state State) error {
+	if state != StateQueued && state != StateSfmDone {
+		return ErrInvalidState
+	}
+
+	return r.update(ctx, id, func(sc *Scene) error {
+		if sc.Status == nil {
+			return ErrStatusNotFound
+		}
+		if !sc.Status.State.IsTerminal() {
+			r.logger.Warnf("Rejected restart of scene %s: scene is %s", id.Hex(), sc.Status.State)
+			return ErrInvalidStatusTransition
+		}
+		restart(sc, config, state)
+		return nil
+	})
+}
+
+// RestartSceneVersion starts processing a completed scene again as a new training run, see
+// SceneManager.RestartSceneVersion. The scene is changed in a single update, so it cannot change meanwhile.
+func (r *DocumentRepository) RestartSceneVersion(ctx context.Context, id primitive.ObjectID, config *TrainingConfig, state State) (int, error) {
+	if state != StateQueued && state != StateSfmDone {
+		return 0, ErrInvalidState
+	}
+
+	var version int
+	err := r.update(ctx, id, func(sc *Scene) error {
+		if sc.Status == nil || sc.Status.State != StateCompleted || sc.Nerf == nil {
+			return ErrNoRunToVersion
+		}
+		run := TrainingRun{Version: sc.CurrentVersion(), Config: sc.Config, Status: sc.Status, Nerf: sc.Nerf}
+		version = run.Version + 1
+		sc.Version = version
+		sc.Runs = append(sc.Runs, run)
+		restart(sc, config, state)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// restart resets the scene to start processing from the given state with config, clearing the outputs the restart
+// computes again.
+func restart(sc *Scene, config *TrainingConfig, state State) {
+	now := time.Now()
+	sc.Config = config
+	sc.Status = &SceneStatus{State: state, UpdatedAt: now, EnteredAt: map[State]time.Time{state: now}}
+	sc.Nerf = nil
+	if state == StateQueued {
+		sc.Sfm = nil
+	}
+}
+
+// MarkTimeoutRequeue counts a requeue of a timed out scene still in the given state, see SceneManager.MarkTimeoutRequeue.
+func (r *DocumentRepository) MarkTimeoutRequeue(ctx context.Context, id primitive.ObjectID, state State) error {
+	err := r.update(ctx, id, func(sc *Scene) error {
+		if sc.Status == nil || sc.Status.State != state {
+			return ErrInvalidStatusTransition
+		}
+		now := time.Now()
+		sc.Status.TimeoutRequeues++
+		sc.Status.UpdatedAt = now
+		sc.Status.ProgressAt = now
+		return nil
+	})
+	if errors.Is(err, ErrSceneNotFound) {
+		return ErrInvalidStatusTransition
+	}
+	return err
+}
+
+// SetLatestIteration records the latest training iteration that produced output, see SceneManager.SetLatestIteration.
+func (r *DocumentRepository) SetLatestIteration(ctx context.Context, id primitive.ObjectID, iteration int) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		if sc.Status == nil {
+			sc.Status = &SceneStatus{}
+		}
+		sc.Status.LatestIteration = max(sc.Status.LatestIteration, iteration)
+		sc.Status.ProgressAt = time.Now()
+		return nil
+	})
+}
+
+// SetLatestProgress records the latest progress reported for a processing scene, see SceneManager.SetLatestProgress.
+func (r *DocumentRepository) SetLatestProgress(ctx context.Context, id primitive.ObjectID, progress *Progress) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		if sc.Status == nil {
+			sc.Status = &SceneStatus{}
+		}
+		if sc.Status.State.IsTerminal() {
+			return ErrSceneNotFound
+		}
+		progress.Smooth(sc.Status.Progress)
+		sc.Status.Progress = progress
+		sc.Status.ProgressAt = progress.ReportedAt
+		return nil
+	})
+}
+
+// SetProcessingDurations records the measured sfm and training durations of a completed scene.
+func (r *DocumentRepository) SetProcessingDurations(ctx context.Context, id primitive.ObjectID, sfm, training time.Duration) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		if sc.Status == nil {
+			sc.Status = &SceneStatus{}
+		}
+		sc.Status.SfmSeconds = sfm.Seconds()
+		sc.Status.TrainingSeconds = training.Seconds()
+		return nil
+	})
+}
+
+// GetScenesByState retrieves every scene currently in the given state.
+func (r *DocumentRepository) GetScenesByState(ctx context.Context, state State) ([]*Scene, error) {
+	return r.findInStates(ctx, state)
+}
+
+// GetStaleScenes retrieves the scenes in the given state without activity since before, see SceneManager.GetStaleScenes.
+func (r *DocumentRepository) GetStaleScenes(ctx context.Context, state State, before time.Time) ([]*Scene, error) {
+	scenes, err := r.findInStates(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(scenes, func(sc *Scene) bool {
+		return !sc.Status.UpdatedAt.Before(before) || (!sc.Status.ProgressAt.IsZero() && !sc.Status.ProgressAt.Before(before))
+	}), nil
+}
+
+// GetRecentlyCompletedScenes retrieves up to limit completed scenes with recorded processing durations, most recent
+// first. A limit of 0 retrieves every such scene.
+func (r *DocumentRepository) GetRecentlyCompletedScenes(ctx context.Context, limit int64) ([]*Scene, error) {
+	scenes, err := r.findInStates(ctx, StateCompleted)
+	if err != nil {
+		return nil, err
+	}
+	scenes = slices.DeleteFunc(scenes, func(sc *Scene) bool { return sc.Status.TrainingSeconds <= 0 })
+	slices.SortStableFunc(scenes, func(a, b *Scene) int { return b.Status.UpdatedAt.Compare(a.Status.UpdatedAt) })
+	return page(scenes, 0, limit), nil
+}
+
+// ListScenes retrieves a page of scenes matching filter, and the number of matching scenes, see SceneManager.ListScenes.
+// The store selects the scenes by state, organization, ID and trash, and pages them, unless a text or tag filter
+// needs the matching scenes filtered and sorted in Go first.
+func (r *DocumentRepository) ListScenes(ctx context.Context, filter SceneListFilter, skip, limit int64) ([]*Scene, int64, error) {
+	query := docstore.Query{IDs: filter.IDs, Keys: make(map[string][]string), Descending: !filter.OldestFirst}
+	if filter.State != "" {
+		query.Keys[keyState] = []string{string(filter.State)}
+	} else if len(filter.States) > 0 {
+		states := make([]string, 0, len(filter.States))
+		for _, state := range filter.States {
+			states = append(states, string(state))
+		}
+		query.Keys[keyState] = states
+	}
+	if !filter.OrgID.IsZero() {
+		query.Keys[keyOrg] = []string{filter.OrgID.Hex()}
+	}
+	switch filter.Trash {
+	case TrashExcluded:
+		query.Without = []string{keyTrash}
+	case TrashOnly:
+		query.Keys[keyTrash] = []string{keyTrash}
+	}
+
+	inGo := filter.Text != "" || len(filter.Tags) > 0
+	if !inGo {
+		query.Skip, query.Limit = skip, limit
+	}
+	scenes, total, err := r.store.Select(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if inGo {
+		scores := make(map[primitive.ObjectID]float64)
+		scenes = slices.DeleteFunc(scenes, func(sc *Scene) bool {
+			if len(filter.Tags) > 0 && slices.ContainsFunc(filter.Tags, func(tag string) bool { return !slices.Contains(sc.Tags, tag) }) {
+				return true
+			}
+			if filter.Text != "" {
+				score := textScore(sc, filter.Text)
+				if score == 0 {
+					return true
+				}
+				scores[sc.ID] = score
+			}
+			return false
+		})
+		// The store listed the scenes by ID, i.e creation time, which the stable sort keeps between equal scores
+		slices.SortStableFunc(scenes, func(a, b *Scene) int { return compareFloat(scores[b.ID], scores[a.ID]) })
+		total = int64(len(scenes))
+		scenes = page(scenes, skip, limit)
+	}
+
+	for _, sc := range scenes {
+		sc.Sfm = nil
+		sc.Nerf = nil
+		for i := range sc.Runs {
+			sc.Runs[i].Nerf = nil
+		}
+	}
+	return scenes, total, nil
+}
+
+// FindScenesWithVideo retrieves the scenes with one of the given IDs, the video with the given SHA-256, and sfm output,
+// newest first, see SceneManager.FindScenesWithVideo.
+func (r *DocumentRepository) FindScenesWithVideo(ctx context.Context, ids []primitive.ObjectID, sha256 string) ([]*Scene, error) {
+	scenes := make([]*Scene, 0)
+	if len(ids) == 0 || sha256 == "" {
+		return scenes, nil
+	}
+	for _, id := range ids {
+		sc, err := r.get(ctx, id)
+		if errors.Is(err, ErrSceneNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if sc.Video == nil || sc.Video.SHA256 != sha256 || sc.Sfm == nil || len(sc.Sfm.Frames) == 0 {
+			continue
+		}
+		sc.Nerf = nil
+		scenes = append(scenes, sc)
+	}
+	slices.SortFunc(scenes, func(a, b *Scene) int { return bytes.Compare(b.ID[:], a.ID[:]) })
+	return scenes, nil
+}
+
+// CountProcessingWithPriority counts the processing scenes with the given job priority, see
+// SceneManager.CountProcessingWithPriority.
+func (r *DocumentRepository) CountProcessingWithPriority(ctx context.Context, priority string) (int64, error) {
+	scenes, err := r.findInStates(ctx, processingStates()...)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, sc := range scenes {
+		scenePriority := ""
+		if sc.Config != nil {
+			scenePriority = sc.Config.Priority
+		}
+		if scenePriority == priority || (priority == PriorityNormal && scenePriority == "") {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountInStates counts the scenes in any of the given states.
+func (r *DocumentRepository) CountInStates(ctx context.Context, states ...State) (int64, error) {
+	scenes, err := r.findInStates(ctx, states...)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(scenes)), nil
+}
+
+// CountProcessingAmong counts the scenes with one of the given IDs that are still processing.
+func (r *DocumentRepository) CountProcessingAmong(ctx context.Context, ids []primitive.ObjectID) (int64, error) {
+	var count int64
+	for _, id := range ids {
+		sc, err := r.get(ctx, id)
+		if errors.Is(err, ErrSceneNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if sc.Status == nil || !sc.Status.State.IsTerminal() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetScenesWithRawVideoCompletedBefore retrieves the scenes that completed before the given time and still have their
+// raw video, oldest first.
+func (r *DocumentRepository) GetScenesWithRawVideoCompletedBefore(ctx context.Context, before time.Time) ([]*Scene, error) {
+	scenes, err := r.findInStates(ctx, StateCompleted)
+	if err != nil {
+		return nil, err
+	}
+	scenes = slices.DeleteFunc(scenes, func(sc *Scene) bool {
+		return !sc.Status.UpdatedAt.Before(before) || sc.Video == nil || sc.Video.FilePath == "" || sc.Video.DeletedAt != nil
+	})
+	return sortByUpdatedAt(scenes), nil
+}
+
+// GetScenesFinishedBefore retrieves the scenes in the given terminal state that finished before the given time,
+// oldest first.
+func (r *DocumentRepository) GetScenesFinishedBefore(ctx context.Context, state State, before time.Time) ([]*Scene, error) {
+	if !state.IsTerminal() {
+		return nil, ErrInvalidState
+	}
+	scenes, err := r.findInStates(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	scenes = slices.DeleteFunc(scenes, func(sc *Scene) bool { return !sc.Status.UpdatedAt.Before(before) })
+	return sortByUpdatedAt(scenes), nil
+}
+
+// sortByUpdatedAt sorts scenes by when their status last changed, oldest first, and leaves out their sfm output.
+func sortByUpdatedAt(scenes []*Scene) []*Scene {
+	slices.SortStableFunc(scenes, func(a, b *Scene) int { return a.Status.UpdatedAt.Compare(b.Status.UpdatedAt) })
+	for _, sc := range scenes {
+		sc.Sfm = nil
+	}
+	return scenes
+}
+
+// MarkRawVideoDeleted records that the raw video of a completed scene was removed, see SceneManager.MarkRawVideoDeleted.
+func (r *DocumentRepository) MarkRawVideoDeleted(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		if sc.Status == nil || sc.Status.State != StateCompleted {
+			return ErrInvalidStatusTransition
+		}
+		if sc.Video == nil || sc.Video.DeletedAt != nil {
+			return ErrVideoNotFound
+		}
+		sc.Video.DeletedAt = &at
+		return nil
+	})
+}
+
+// TrashScene moves a scene to the trash at the given time, see SceneManager.TrashScene.
+func (r *DocumentRepository) TrashScene(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		if sc.TrashedAt != nil {
+			return ErrSceneInTrash
+		}
+		sc.TrashedAt = &at
+		return nil
+	})
+}
+
+// RestoreScene takes a scene out of the trash, see SceneManager.RestoreScene.
+func (r *DocumentRepository) RestoreScene(ctx context.Context, id primitive.ObjectID) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		if sc.TrashedAt == nil {
+			return ErrSceneNotInTrash
+		}
+		sc.TrashedAt = nil
+		return nil
+	})
+}
+
+// GetTrashedAt retrieves when a scene was moved to the trash, nil if it is not in the trash.
+func (r *DocumentRepository) GetTrashedAt(ctx context.Context, id primitive.ObjectID) (*time.Time, error) {
+	sc, err := r.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return sc.TrashedAt, nil
+}
+
+// GetScenesTrashedBefore retrieves the scenes moved to the trash before the given time, oldest first.
+func (r *DocumentRepository) GetScenesTrashedBefore(ctx context.Context, before time.Time) ([]*Scene, error) {
+	scenes, err := r.store.Find(ctx, keyTrash, keyTrash)
+	if err != nil {
+		return nil, err
+	}
+	scenes = slices.DeleteFunc(scenes, func(sc *Scene) bool { return !sc.TrashedAt.Before(before) })
+	slices.SortStableFunc(scenes, func(a, b *Scene) int { return a.TrashedAt.Compare(*b.TrashedAt) })
+	for _, sc := range scenes {
+		sc.Sfm = nil
+	}
+	return scenes, nil
+}
+
+// SetCollaborator shares the scene with the given user in the given role, see SceneManager.SetCollaborator.
+func (r *DocumentRepository) SetCollaborator(ctx context.Context, id, userID primitive.ObjectID, role string) error {
+	if !IsValidCollaboratorRole(role) {
+		return ErrInvalidCollaboratorRole
+	}
+	return r.update(ctx, id, func(sc *Scene) error {
+		i := slices.IndexFunc(sc.Collaborators, func(c Collaborator) bool { return c.UserID == userID })
+		if i >= 0 {
+			sc.Collaborators[i].Role = role
+			return nil
+		}
+		sc.Collaborators = append(sc.Collaborators, Collaborator{UserID: userID, Role: role, SharedAt: time.Now().UTC()})
+		return nil
+	})
+}
+
+// RemoveCollaborator stops sharing the scene with the given user.
+// Returns ErrCollaboratorNotFound if the scene is not shared with the user.
+func (r *DocumentRepository) RemoveCollaborator(ctx context.Context, id, userID primitive.ObjectID) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		i := slices.IndexFunc(sc.Collaborators, func(c Collaborator) bool { return c.UserID == userID })
+		if i < 0 {
+			return ErrCollaboratorNotFound
+		}
+		sc.Collaborators = slices.Delete(sc.Collaborators, i, i+1)
+		return nil
+	})
+}
+
+// RemoveCollaboratorFromAll stops sharing every scene with the given user.
+func (r *DocumentRepository) RemoveCollaboratorFromAll(ctx context.Context, userID primitive.ObjectID) error {
+	return r.updateAll(ctx, keyCollaborator, userID.Hex(), func(sc *Scene) {
+		sc.Collaborators = slices.DeleteFunc(sc.Collaborators, func(c Collaborator) bool { return c.UserID == userID })
+	})
+}
+
+// GetCollaboratorRole returns the role of the given user on the scene, see SceneManager.GetCollaboratorRole.
+func (r *DocumentRepository) GetCollaboratorRole(ctx context.Context, id, userID primitive.ObjectID) (string, error) {
+	collaborators, err := r.GetCollaborators(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range collaborators {
+		if c.UserID == userID {
+			return c.Role, nil
+		}
+	}
+	return "", ErrCollaboratorNotFound
+}
+
+// GetCollaborators returns every user the scene is shared with, in the order it was shared with them.
+func (r *DocumentRepository) GetCollaborators(ctx context.Context, id primitive.ObjectID) ([]Collaborator, error) {
+	sc, err := r.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sc.Collaborators == nil {
+		return make([]Collaborator, 0), nil
+	}
+	return sc.Collaborators, nil
+}
+
+// AddShareLink adds a share link to the scene.
+func (r *DocumentRepository) AddShareLink(ctx context.Context, id primitive.ObjectID, link ShareLink) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		sc.ShareLinks = append(sc.ShareLinks, link)
+		return nil
+	})
+}
+
+// RemoveShareLink removes a share link from the scene, revoking it.
+// Returns ErrShareLinkNotFound if the scene has no share link with the given ID.
+func (r *DocumentRepository) RemoveShareLink(ctx context.Context, id, linkID primitive.ObjectID) error {
+	return r.update(ctx, id, func(sc *Scene) error {
+		i := slices.IndexFunc(sc.ShareLinks, func(link ShareLink) bool { return link.ID == linkID })
+		if i < 0 {
+			return ErrShareLinkNotFound
+		}
+		sc.ShareLinks = slices.Delete(sc.ShareLinks, i, i+1)
+		return nil
+	})
+}
+
+// GetShareLinks returns every share link of the scene, oldest first.
+func (r *DocumentRepository) GetShareLinks(ctx context.Context, id primitive.ObjectID) ([]ShareLink, error) {
+	sc, err := r.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sc.ShareLinks == nil {
+		return make([]ShareLink, 0), nil
+	}
+	return sc.ShareLinks, nil
+}
+
+// GetShareLink returns the share link of the scene with the given ID, see SceneManager.GetShareLink.
+func (r *DocumentRepository) GetShareLink(ctx context.Context, id, linkID primitive.ObjectID) (*ShareLink, error) {
+	links, err := r.GetShareLinks(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range links {
+		if links[i].ID == linkID {
+			return &links[i], nil
+		}
+	}
+	return nil, ErrShareLinkNotFound
+}
+
+// WithTransaction runs fn in a transaction of the store, see SceneManager.WithTransaction.
+func (r *DocumentRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.store.Transaction(ctx, fn)
+}
+
+// Reconcile cross-references scene documents with the files on disk, see SceneManager.Reconcile.
+func (r *DocumentRepository) Reconcile(ctx context.Context, opts ReconcileOptions) (*ReconcileReport, error) {
+	return r.maintenance().reconcile(ctx, opts)
+}
+
+// MigrateStorageLayout moves the files of scenes stored in the previous layout, see SceneManager.MigrateStorageLayout.
+func (r *DocumentRepository) MigrateStorageLayout(ctx context.Context) (*StorageMigrationReport, error) {
+	return r.maintenance().migrateStorageLayout(ctx)
+}
+
+// maintenance returns the maintenance of the stored scenes.
+func (r *DocumentRepository) maintenance() maintenance {
+	return maintenance{layout: r.layout, docs: r, logger: r.logger}
+}
+
+// existingSceneIDs returns which of the given scene IDs have a scene document, see sceneDocuments.
+func (r *DocumentRepository) existingSceneIDs(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	existing := make(map[primitive.ObjectID]bool)
+	for _, id := range ids {
+		_, err := r.get(ctx, id)
+		if errors.Is(err, ErrSceneNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		existing[id] = true
+	}
+	return existing, nil
+}
+
+// eachSceneBatch is how many scenes eachScene loads at a time.
+const eachSceneBatch = 100
+
+// eachScene calls fn with every scene document, see sceneDocuments. Every field is loaded regardless of projection.
+// Scenes are loaded in batches by ID, so scenes created while it runs may be included.
+func (r *DocumentRepository) eachScene(ctx context.Context, projection bson.M, fn func(sc *Scene, err error)) error {
+	var after primitive.ObjectID
+	for {
+		scenes, _, err := r.store.Select(ctx, docstore.Query{After: after, Limit: eachSceneBatch})
+		if err != nil {
+			return err
+		}
+		for _, sc := range scenes {
+			fn(sc, nil)
+		}
+		if len(scenes) < eachSceneBatch {
+			return nil
+		}
+		after = scenes[len(scenes)-1].ID
+	}
+}
+
+// setMigratedPaths stores the paths migrateScene moved the files of sc to, see sceneDocuments. The frames and nerf
+// output paths were changed in sc itself, and the video path is in update.
+func (r *DocumentRepository) setMigratedPaths(ctx context.Context, sc *Scene, update bson.M) error {
+	return r.update(ctx, sc.ID, func(stored *Scene) error {
+		if path, ok := update["video.file_path"].(string); ok && stored.Video != nil {
+			stored.Video.FilePath = path
+		}
+		if _, ok := update["sfm.frames"]; ok && stored.Sfm != nil && sc.Sfm != nil {
+			stored.Sfm.Frames = sc.Sfm.Frames
+		}
+		if stored.Nerf != nil && sc.Nerf != nil {
+			for _, outputType := range []string{"model", "splat_cloud", "point_cloud", "video", OutputTypeCheckpoint} {
+				field, _ := outputPathsField(outputType)
+				if _, ok := update[field]; ok {
+					*outputPaths(stored.Nerf, outputType) = *outputPaths(sc.Nerf, outputType)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// page returns the scenes after skipping skip, up to limit of them if limit > 0.
+func page(scenes []*Scene, skip, limit int64) []*Scene {
+	if skip >= int64(len(scenes)) {
+		return make([]*Scene, 0)
+	}
+	scenes = scenes[max(skip, 0):]
+	if limit > 0 && limit < int64(len(scenes)) {
+		scenes = scenes[:limit]
+	}
+	return scenes
+}
+
+// compareFloat compares two floats for sorting.
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// phrasePattern matches the phrases in double quotes of a text search.
+var phrasePattern = regexp.MustCompile(`"([^"]*)"`)
+
+// textScore scores how well the name, tags and description of a scene match a text search, 0 if they do not match.
+// It follows the MongoDB text index of Search.go: a scene matches if it has any of the words, ignoring case, each
+// match weighted by the field it is in, and phrases in double quotes must appear as is. Unlike MongoDB, words are not
+// stemmed and stop words are not ignored.
+func textScore(sc *Scene, text string) float64 {
+	fields := []weightedText{{sc.Name, 10}, {strings.Join(sc.Tags, " "), 5}, {sc.Description, 1}}
+
+	for _, phrase := range phrasePattern.FindAllStringSubmatch(text, -1) {
+		wanted := strings.ToLower(phrase[1])
+		if !slices.ContainsFunc(fields, func(f weightedText) bool { return strings.Contains(strings.ToLower(f.text), wanted) }) {
+			return 0
+		}
+	}
+
+	words := textWords(text)
+	var score float64
+	for _, f := range fields {
+		for _, word := range textWords(f.text) {
+			if slices.Contains(words, word) {
+				score += f.weight
+			}
+		}
+	}
+	return score
+}
+
+// weightedText is the text of a searched field, and the weight of its matches.
+type weightedText struct {
+	text   string
+	weight float64
+}
+
+// textWords splits text into lowercase words.
+func textWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
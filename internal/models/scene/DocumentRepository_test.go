@@ -0,0 +1,492 @@
+package scene
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/docstore"
+)
+
+// forEachRepository runs test against an empty in-memory DocumentRepository, and an empty PostgreSQL one if
+// POSTGRES_TEST_URL is set.
+func forEachRepository(t *testing.T, test func(t *testing.T, r *DocumentRepository)) {
+	logger := &log.Logger{SugaredLogger: zap.NewNop().Sugar()}
+	t.Run("memory", func(t *testing.T) {
+		r := NewMemoryRepository(docstore.NewMemoryDB(), logger, "test")
+		if err := r.EnsureIndexes(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		test(t, r)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv("POSTGRES_TEST_URL")
+		if url == "" {
+			t.Skip("POSTGRES_TEST_URL is not set")
+		}
+		ctx := context.Background()
+		db, err := docstore.OpenPostgres(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS scenes_keys, scenes"); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewPostgresRepository(db, logger, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.EnsureIndexes(ctx); err != nil {
+			t.Fatal(err)
+		}
+		test(t, r)
+	})
+}
+
+// createScene creates a scene in the given state with the given name.
+func createScene(t *testing.T, r *DocumentRepository, state State, name string) *Scene {
+	t.Helper()
+	sc := &Scene{
+		ID:     primitive.NewObjectID(),
+		Name:   name,
+		Status: &SceneStatus{State: state, UpdatedAt: time.Now()},
+	}
+	if err := r.CreateScene(context.Background(), sc); err != nil {
+		t.Fatalf("create scene %s: %v", name, err)
+	}
+	return sc
+}
+
+func sceneNames(scenes []*Scene) []string {
+	names := make([]string, 0, len(scenes))
+	for _, sc := range scenes {
+		names = append(names, sc.Name)
+	}
+	return names
+}
+
+func TestCreateAndGetScene(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		sc := createScene(t, r, StateQueued, "a")
+
+		got, err := r.GetScene(ctx, sc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "a" || got.Status.State != StateQueued {
+			t.Errorf("got %+v", got)
+		}
+
+		if err := r.CreateScene(ctx, sc); !errors.Is(err, ErrSceneAlreadyExists) {
+			t.Errorf("create of existing scene: got %v, want ErrSceneAlreadyExists", err)
+		}
+		if err := r.CreateScene(ctx, &Scene{Name: "no ID"}); err == nil {
+			t.Error("create of scene without ID succeeded")
+		}
+		if _, err := r.GetScene(ctx, primitive.NewObjectID()); !errors.Is(err, ErrSceneNotFound) {
+			t.Errorf("get of missing scene: got %v, want ErrSceneNotFound", err)
+		}
+		if _, err := r.GetVideo(ctx, sc.ID); !errors.Is(err, ErrVideoNotFound) {
+			t.Errorf("get of missing video: got %v, want ErrVideoNotFound", err)
+		}
+		if err := r.DeleteScene(ctx, sc.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.DeleteScene(ctx, sc.ID); !errors.Is(err, ErrSceneNotFound) {
+			t.Errorf("second delete: got %v, want ErrSceneNotFound", err)
+		}
+	})
+}
+
+func TestSetSceneKeepsOmittedFields(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		sc := createScene(t, r, StateQueued, "a")
+		if err := r.UpdateSceneMetadata(ctx, sc.ID, SceneMetadataUpdate{Tags: []string{"kept"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.SetScene(ctx, sc.ID, &Scene{Name: "b", Video: &Video{FilePath: "video.mp4"}}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.GetScene(ctx, sc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "b" || got.Video == nil || got.Video.FilePath != "video.mp4" {
+			t.Errorf("fields of SetScene not set: %+v", got)
+		}
+		if !slices.Equal(got.Tags, []string{"kept"}) || got.Status == nil {
+			t.Errorf("fields left out by SetScene not kept: %+v", got)
+		}
+
+		// Setters create the scene if it does not exist
+		id := primitive.NewObjectID()
+		if err := r.SetSceneName(ctx, id, "new"); err != nil {
+			t.Fatal(err)
+		}
+		if name, err := r.GetSceneName(ctx, id); err != nil || name != "new" {
+			t.Errorf("GetSceneName() = %q, %v", name, err)
+		}
+	})
+}
+
+func TestTransitionStatus(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		sc := createScene(t, r, StateQueued, "a")
+
+		if err := r.TransitionStatus(ctx, sc.ID, StateSfmRunning, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.TransitionStatus(ctx, sc.ID, StateCompleted, ""); !errors.Is(err, ErrInvalidStatusTransition) {
+			t.Errorf("sfm_running -> completed: got %v, want ErrInvalidStatusTransition", err)
+		}
+		if err := r.TransitionStatus(ctx, sc.ID, "unknown", ""); !errors.Is(err, ErrInvalidState) {
+			t.Errorf("transition to unknown state: got %v, want ErrInvalidState", err)
+		}
+		if err := r.TransitionStatus(ctx, primitive.NewObjectID(), StateFailed, ""); !errors.Is(err, ErrSceneNotFound) {
+			t.Errorf("transition of missing scene: got %v, want ErrSceneNotFound", err)
+		}
+
+		failure := &JobFailure{Stage: "sfm", Reason: "no features", Attempts: 2, FailedAt: time.Now()}
+		if err := r.FailJob(ctx, sc.ID, failure); err != nil {
+			t.Fatal(err)
+		}
+		status, err := r.GetStatus(ctx, sc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State != StateFailed || status.Error != "no features" || status.Failure == nil || status.Failure.Attempts != 2 {
+			t.Errorf("status after FailJob: %+v", status)
+		}
+		if _, ok := status.EnteredAt[StateSfmRunning]; !ok {
+			t.Errorf("entered_at of sfm_running not recorded: %v", status.EnteredAt)
+		}
+		if err := r.FailJob(ctx, sc.ID, failure); !errors.Is(err, ErrInvalidStatusTransition) {
+			t.Errorf("failing a failed scene: got %v, want ErrInvalidStatusTransition", err)
+		}
+	})
+}
+
+func TestRestartSceneVersion(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		sc := createScene(t, r, StateTraining, "a")
+		config := &TrainingConfig{Priority: PriorityNormal}
+
+		if _, err := r.RestartSceneVersion(ctx, sc.ID, config, StateSfmDone); !errors.Is(err, ErrNoRunToVersion) {
+			t.Errorf("versioning an unfinished scene: got %v, want ErrNoRunToVersion", err)
+		}
+
+		if err := r.SetNerf(ctx, sc.ID, &Nerf{ModelFilePathsMap: map[int]string{100: "model.ply"}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.TransitionStatus(ctx, sc.ID, StateCompleted, ""); err != nil {
+			t.Fatal(err)
+		}
+		version, err := r.RestartSceneVersion(ctx, sc.ID, config, StateSfmDone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 2 {
+			t.Errorf("new version is %d, want 2", version)
+		}
+
+		got, err := r.GetScene(ctx, sc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Nerf != nil || got.Status.State != StateSfmDone || got.Config.Priority != PriorityNormal {
+			t.Errorf("scene was not reset for the new run: %+v", got)
+		}
+		if len(got.Runs) != 1 || got.Runs[0].Version != 1 || got.Runs[0].Nerf.ModelFilePathsMap[100] != "model.ply" {
+			t.Errorf("previous run not kept: %+v", got.Runs)
+		}
+
+		if err := r.RestartScene(ctx, sc.ID, config, StateQueued); !errors.Is(err, ErrInvalidStatusTransition) {
+			t.Errorf("restarting a processing scene: got %v, want ErrInvalidStatusTransition", err)
+		}
+	})
+}
+
+func TestSetOutputFile(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		sc := createScene(t, r, StateTraining, "a")
+
+		if err := r.SetOutputFile(ctx, sc.ID, "model", 100, "model.ply", ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetOutputFile(ctx, sc.ID, OutputTypeCheckpoint, 100, "ckpt.pt", "sum"); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetOutputFile(ctx, sc.ID, OutputTypePLY, 200, "export.ply", ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetOutputFile(ctx, sc.ID, "unknown", 100, "x", ""); !errors.Is(err, ErrInvalidOutputType) {
+			t.Errorf("unknown output type: got %v, want ErrInvalidOutputType", err)
+		}
+		if err := r.SetOutputFile(ctx, primitive.NewObjectID(), "model", 100, "x", ""); !errors.Is(err, ErrSceneNotFound) {
+			t.Errorf("output of missing scene: got %v, want ErrSceneNotFound", err)
+		}
+
+		nerf, err := r.GetNerf(ctx, sc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nerf.ModelFilePathsMap[100] != "model.ply" || nerf.CheckpointFilePathsMap[100] != "ckpt.pt" ||
+			nerf.CheckpointChecksums[100] != "sum" || nerf.ExportFilePathsMap[OutputTypePLY][200] != "export.ply" {
+			t.Errorf("output paths not recorded: %+v", nerf)
+		}
+	})
+}
+
+func TestListScenes(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		garden := createScene(t, r, StateCompleted, "garden")
+		createScene(t, r, StateQueued, "kitchen")
+		statue := createScene(t, r, StateCompleted, "statue")
+		trashed := createScene(t, r, StateFailed, "trashed garden")
+		if err := r.UpdateSceneMetadata(ctx, statue.ID, SceneMetadataUpdate{Tags: []string{"garden", "stone"}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetNerf(ctx, garden.ID, &Nerf{Flag: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.TrashScene(ctx, trashed.ID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name   string
+			filter SceneListFilter
+			want   []string
+		}{
+			{"newest first", SceneListFilter{}, []string{"statue", "kitchen", "garden"}},
+			{"oldest first", SceneListFilter{OldestFirst: true}, []string{"garden", "kitchen", "statue"}},
+			{"state", SceneListFilter{State: StateCompleted}, []string{"statue", "garden"}},
+			{"states", SceneListFilter{States: []State{StateQueued, StateFailed}}, []string{"kitchen"}},
+			{"IDs", SceneListFilter{IDs: []primitive.ObjectID{garden.ID}}, []string{"garden"}},
+			{"no IDs", SceneListFilter{IDs: []primitive.ObjectID{}}, []string{}},
+			{"tags", SceneListFilter{Tags: []string{"garden", "stone"}}, []string{"statue"}},
+			// a match in the name weighs more than one in the tags
+			{"text", SceneListFilter{Text: "Garden"}, []string{"garden", "statue"}},
+			{"phrase", SceneListFilter{Text: `"trashed garden"`, Trash: TrashIncluded}, []string{"trashed garden"}},
+			{"trash only", SceneListFilter{Trash: TrashOnly}, []string{"trashed garden"}},
+			{"trash included", SceneListFilter{Trash: TrashIncluded, State: StateFailed}, []string{"trashed garden"}},
+		}
+		for _, tt := range tests {
+			scenes, total, err := r.ListScenes(ctx, tt.filter, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sceneNames(scenes); !slices.Equal(got, tt.want) {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+			if total != int64(len(tt.want)) {
+				t.Errorf("%s: total is %d, want %d", tt.name, total, len(tt.want))
+			}
+		}
+
+		scenes, total, err := r.ListScenes(ctx, SceneListFilter{}, 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sceneNames(scenes); !slices.Equal(got, []string{"kitchen"}) || total != 3 {
+			t.Errorf("second page of one: got %v of %d, want [kitchen] of 3", got, total)
+		}
+
+		scenes, _, err = r.ListScenes(ctx, SceneListFilter{IDs: []primitive.ObjectID{garden.ID}}, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scenes[0].Nerf != nil {
+			t.Error("listed scenes include their nerf output")
+		}
+	})
+}
+
+func TestQueriesByState(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		stale := createScene(t, r, StateTraining, "stale")
+		active := createScene(t, r, StateTraining, "active")
+		createScene(t, r, StateQueued, "queued")
+		done := createScene(t, r, StateCompleted, "done")
+		if err := r.SetLatestIteration(ctx, active.ID, 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetTrainingConfig(ctx, stale.ID, &TrainingConfig{Priority: PriorityHigh}); err != nil {
+			t.Fatal(err)
+		}
+
+		scenes, err := r.GetStaleScenes(ctx, StateTraining, time.Now().Add(-time.Second/2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(scenes) != 0 {
+			t.Errorf("scenes updated just now are stale: %v", sceneNames(scenes))
+		}
+		scenes, err = r.GetStaleScenes(ctx, StateTraining, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sceneNames(scenes); !slices.Equal(got, []string{"stale", "active"}) {
+			t.Errorf("GetStaleScenes() = %v", got)
+		}
+
+		if count, err := r.CountInStates(ctx, StateTraining, StateQueued); err != nil || count != 3 {
+			t.Errorf("CountInStates() = %d, %v, want 3", count, err)
+		}
+		if count, err := r.CountProcessingWithPriority(ctx, PriorityHigh); err != nil || count != 1 {
+			t.Errorf("CountProcessingWithPriority(high) = %d, %v, want 1", count, err)
+		}
+		if count, err := r.CountProcessingWithPriority(ctx, PriorityNormal); err != nil || count != 2 {
+			t.Errorf("CountProcessingWithPriority(normal) = %d, %v, want 2", count, err)
+		}
+		if count, err := r.CountProcessingAmong(ctx, []primitive.ObjectID{stale.ID, done.ID}); err != nil || count != 1 {
+			t.Errorf("CountProcessingAmong() = %d, %v, want 1", count, err)
+		}
+		if _, err := r.GetScenesFinishedBefore(ctx, StateTraining, time.Now()); !errors.Is(err, ErrInvalidState) {
+			t.Errorf("GetScenesFinishedBefore(training): got %v, want ErrInvalidState", err)
+		}
+		scenes, err = r.GetScenesFinishedBefore(ctx, StateCompleted, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sceneNames(scenes); !slices.Equal(got, []string{"done"}) {
+			t.Errorf("GetScenesFinishedBefore() = %v", got)
+		}
+
+		progress := &Progress{Stage: "training", Iteration: 5, ReportedAt: time.Now()}
+		if err := r.SetLatestProgress(ctx, done.ID, progress); !errors.Is(err, ErrSceneNotFound) {
+			t.Errorf("progress of a finished scene: got %v, want ErrSceneNotFound", err)
+		}
+		if err := r.MarkTimeoutRequeue(ctx, active.ID, StateQueued); !errors.Is(err, ErrInvalidStatusTransition) {
+			t.Errorf("requeue in another state: got %v, want ErrInvalidStatusTransition", err)
+		}
+	})
+}
+
+func TestTrash(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		sc := createScene(t, r, StateCompleted, "a")
+		trashedAt := time.Now().Add(-time.Hour)
+
+		if err := r.RestoreScene(ctx, sc.ID); !errors.Is(err, ErrSceneNotInTrash) {
+			t.Errorf("restore of scene not in trash: got %v, want ErrSceneNotInTrash", err)
+		}
+		if err := r.TrashScene(ctx, sc.ID, trashedAt); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.TrashScene(ctx, sc.ID, trashedAt); !errors.Is(err, ErrSceneInTrash) {
+			t.Errorf("second trash: got %v, want ErrSceneInTrash", err)
+		}
+
+		scenes, err := r.GetScenesTrashedBefore(ctx, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sceneNames(scenes); !slices.Equal(got, []string{"a"}) {
+			t.Errorf("GetScenesTrashedBefore() = %v", got)
+		}
+
+		if err := r.RestoreScene(ctx, sc.ID); err != nil {
+			t.Fatal(err)
+		}
+		if at, err := r.GetTrashedAt(ctx, sc.ID); err != nil || at != nil {
+			t.Errorf("GetTrashedAt() after restore = %v, %v", at, err)
+		}
+		scenes, err = r.GetScenesTrashedBefore(ctx, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(scenes) != 0 {
+			t.Errorf("restored scene is still in the trash")
+		}
+	})
+}
+
+func TestCollaborators(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		a := createScene(t, r, StateCompleted, "a")
+		b := createScene(t, r, StateCompleted, "b")
+		userID := primitive.NewObjectID()
+
+		collaborators, err := r.GetCollaborators(ctx, a.ID)
+		if err != nil || collaborators == nil || len(collaborators) != 0 {
+			t.Errorf("GetCollaborators() of unshared scene = %v, %v, want an empty slice", collaborators, err)
+		}
+		if err := r.SetCollaborator(ctx, a.ID, userID, "owner"); !errors.Is(err, ErrInvalidCollaboratorRole) {
+			t.Errorf("invalid role: got %v, want ErrInvalidCollaboratorRole", err)
+		}
+		for _, id := range []primitive.ObjectID{a.ID, b.ID} {
+			if err := r.SetCollaborator(ctx, id, userID, CollaboratorRoleViewer); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.SetCollaborator(ctx, a.ID, userID, CollaboratorRoleEditor); err != nil {
+			t.Fatal(err)
+		}
+		if role, err := r.GetCollaboratorRole(ctx, a.ID, userID); err != nil || role != CollaboratorRoleEditor {
+			t.Errorf("GetCollaboratorRole() = %q, %v, want the changed role", role, err)
+		}
+		if collaborators, _ := r.GetCollaborators(ctx, a.ID); len(collaborators) != 1 {
+			t.Errorf("sharing again added a collaborator: %v", collaborators)
+		}
+
+		if err := r.RemoveCollaboratorFromAll(ctx, userID); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []primitive.ObjectID{a.ID, b.ID} {
+			if _, err := r.GetCollaboratorRole(ctx, id, userID); !errors.Is(err, ErrCollaboratorNotFound) {
+				t.Errorf("collaborator not removed from every scene: %v", err)
+			}
+		}
+		if err := r.RemoveCollaborator(ctx, a.ID, userID); !errors.Is(err, ErrCollaboratorNotFound) {
+			t.Errorf("removing a removed collaborator: got %v, want ErrCollaboratorNotFound", err)
+		}
+	})
+}
+
+func TestWithTransaction(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		sc := createScene(t, r, StateCompleted, "a")
+
+		failure := errors.New("failure")
+		err := r.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := r.SetSceneName(ctx, sc.ID, "renamed"); err != nil {
+				return err
+			}
+			if err := r.CreateScene(ctx, &Scene{ID: primitive.NewObjectID(), Name: "b"}); err != nil {
+				return err
+			}
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Fatalf("got %v, want the error of fn", err)
+		}
+
+		scenes, _, err := r.ListScenes(ctx, SceneListFilter{}, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sceneNames(scenes); !slices.Equal(got, []string{"a"}) {
+			t.Errorf("after rollback: %v, want [a]", got)
+		}
+	})
+}
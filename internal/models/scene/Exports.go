@@ -0,0 +1,100 @@
+// This file contains exports of a scene's nerf output to interchange formats.
+//
+// An export converts the latest iteration of a native output type (i.e a splat cloud) to another format, and is done
+// by the conversion worker. Each export format is an output type of its own once converted, so exported files are
+// listed and served like any other output. Exports are part of the nerf output, so training a scene again clears them.
+
+package scene
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Declarations for valid export formats, which are the output types of exported files
+const (
+	OutputTypePLY   = "ply"
+	OutputTypeGLTF  = "gltf"
+	OutputTypeOBJ   = "obj"
+	OutputTypeSplat = "splat"
+)
+
+// ExportSources maps export formats to the output types they can be converted from, preferred first.
+var ExportSources = map[string][]string{
+	OutputTypePLY:   {"splat_cloud", "point_cloud"},
+	OutputTypeGLTF:  {"splat_cloud", "point_cloud"},
+	OutputTypeOBJ:   {"point_cloud"},
+	OutputTypeSplat: {"splat_cloud"},
+}
+
+// IsExportOutputType checks if the given output type is an export format, see ExportSources.
+func IsExportOutputType(outputType string) bool {
+	_, ok := ExportSources[outputType]
+	return ok
+}
+
+// ExportFormats returns the export formats, sorted.
+func ExportFormats() []string {
+	formats := make([]string, 0, len(ExportSources))
+	for format := range ExportSources {
+		formats = append(formats, format)
+	}
+	slices.Sort(formats)
+	return formats
+}
+
+// Declarations for valid export states
+const (
+	ExportStatePending = "pending"
+	ExportStateDone    = "done"
+	ExportStateFailed  = "failed"
+)
+
+// Export is the state of an export of a scene to one format.
+type Export struct {
+	State string `bson:"state" json:"state"`
+	// output type and iteration converted
+	SourceType  string    `bson:"source_type" json:"source_type"`
+	Iteration   int       `bson:"iteration" json:"iteration"`
+	RequestedAt time.Time `bson:"requested_at" json:"requested_at"`
+	CompletedAt time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	Error       string    `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// ExportTypes returns the export formats the nerf output has files of, sorted.
+func (n *Nerf) ExportTypes() []string {
+	types := make([]string, 0, len(n.ExportFilePathsMap))
+	for outputType, paths := range n.ExportFilePathsMap {
+		if len(paths) > 0 {
+			types = append(types, outputType)
+		}
+	}
+	slices.Sort(types)
+	return types
+}
+
+// SetExport records the state of the export of a scene to format, replacing any previous export to it. The scene
+// must have nerf output.
+//
+// Returns ErrSceneNotFound if the scene does not exist, or ErrNerfNotFound if it has no nerf output.
+func (sm *SceneManager) SetExport(ctx context.Context, id primitive.ObjectID, format string, export *Export) error {
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "nerf": bson.M{"$type": "object"}},
+		bson.M{"$set": bson.M{"nerf.exports." + format: export}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := sm.GetNerf(ctx, id); err != nil {
+			return err
+		}
+		return ErrNerfNotFound
+	}
+	return nil
+}
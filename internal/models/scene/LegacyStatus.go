@@ -0,0 +1,134 @@
+// This file contains the decoding and migration of scenes stored with the legacy integer status.
+//
+// Scenes created before SceneStatus stored an integer under the "status" key, which was never set and so is always 0.
+// Decoding such a document into a Scene derives its SceneStatus from the scene's output instead, see legacyStatus,
+// and MigrateLegacyStatus rewrites the stored documents so queries on the status fields match them as well.
+
+package scene
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// legacyStatusFilter matches scene documents whose status is stored as a legacy integer.
+var legacyStatusFilter = bson.M{"status": bson.M{"$type": "number"}}
+
+// UnmarshalBSON decodes a scene document, deriving the status of scenes stored with the legacy integer status.
+func (sc *Scene) UnmarshalBSON(data []byte) error {
+	// plain has the fields of Scene without its methods, so decoding into it does not call UnmarshalBSON again
+	type plain Scene
+
+	status, err := bson.Raw(data).LookupErr("status")
+	if err != nil || !isLegacyStatus(status.Type) {
+		return bson.Unmarshal(data, (*plain)(sc))
+	}
+
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	for i, elem := range doc {
+		if elem.Key == "status" {
+			doc = append(doc[:i], doc[i+1:]...)
+			break
+		}
+	}
+	stripped, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := bson.Unmarshal(stripped, (*plain)(sc)); err != nil {
+		return err
+	}
+	sc.Status = legacyStatus(sc)
+	return nil
+}
+
+// isLegacyStatus returns whether a status of the given type is a legacy integer status.
+func isLegacyStatus(t bsontype.Type) bool {
+	return t == bson.TypeInt32 || t == bson.TypeInt64 || t == bson.TypeDouble
+}
+
+// legacyStatus derives the status of a scene stored with the legacy integer status from its output: scenes with nerf
+// output are completed, scenes with sfm output are sfm_done, and every other scene is queued.
+func legacyStatus(sc *Scene) *SceneStatus {
+	state := StateQueued
+	switch {
+	case sc.Nerf != nil && hasNerfOutput(sc.Nerf):
+		state = StateCompleted
+	case sc.Sfm != nil:
+		state = StateSfmDone
+	}
+
+	updatedAt := sc.ID.Timestamp()
+	if sc.ID.IsZero() {
+		updatedAt = time.Time{}
+	}
+	status := &SceneStatus{
+		State:     state,
+		UpdatedAt: updatedAt,
+		EnteredAt: map[State]time.Time{state: updatedAt},
+	}
+	if state == StateCompleted {
+		status.LatestIteration = latestNerfIteration(sc.Nerf)
+	}
+	return status
+}
+
+// hasNerfOutput returns whether any training output of nerf was stored.
+func hasNerfOutput(nerf *Nerf) bool {
+	return len(nerf.ModelFilePathsMap) > 0 || len(nerf.SplatCloudFilePathsMap) > 0 ||
+		len(nerf.PointCloudFilePathsMap) > 0 || len(nerf.VideoFilePathsMap) > 0
+}
+
+// latestNerfIteration returns the farthest iteration of any training output of nerf.
+func latestNerfIteration(nerf *Nerf) int {
+	latest := 0
+	for _, paths := range []map[int]string{nerf.ModelFilePathsMap, nerf.SplatCloudFilePathsMap, nerf.PointCloudFilePathsMap, nerf.VideoFilePathsMap} {
+		for iteration := range paths {
+			latest = max(latest, iteration)
+		}
+	}
+	return latest
+}
+
+// MigrateLegacyStatus replaces the legacy integer status of stored scenes with their derived SceneStatus, see
+// legacyStatus, and returns the number of scenes migrated. Scenes already migrated are skipped, so it is safe to run
+// more than once.
+func (sm *SceneManager) MigrateLegacyStatus(ctx context.Context) (int, error) {
+	projection := bson.M{"_id": 1, "sfm": 1, "nerf": 1, "status": 1}
+	cursor, err := sm.collection.Find(ctx, legacyStatusFilter, options.Find().SetProjection(projection))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var sc Scene
+		if err := cursor.Decode(&sc); err != nil {
+			return migrated, err
+		}
+		// Only replace a status that is still legacy, so a status set since the scene was read is kept
+		result, err := sm.collection.UpdateOne(ctx,
+			bson.M{"_id": sc.ID, "status": legacyStatusFilter["status"]},
+			bson.M{"$set": bson.M{"status": sc.Status}},
+		)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += int(result.ModifiedCount)
+	}
+	return migrated, cursor.Err()
+}
+
+// MigrateLegacyStatus does nothing, as scenes stored in a document store always have a SceneStatus. Documents with
+// the legacy integer status still decode, see Scene.UnmarshalBSON.
+func (r *DocumentRepository) MigrateLegacyStatus(ctx context.Context) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,112 @@
+package scene
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUnmarshalLegacyStatus(t *testing.T) {
+	id := primitive.NewObjectIDFromTimestamp(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name          string
+		doc           bson.D
+		wantState     State
+		wantIteration int
+	}{
+		{
+			name: "uploaded",
+			doc: bson.D{
+				{Key: "_id", Value: id},
+				{Key: "video", Value: bson.D{{Key: "file_path", Value: "data/raw/videos/x.mp4"}, {Key: "width", Value: int32(1920)}}},
+				{Key: "name", Value: "garden"},
+				{Key: "status", Value: int32(0)},
+			},
+			wantState: StateQueued,
+		},
+		{
+			name: "sfm done",
+			doc: bson.D{
+				{Key: "_id", Value: id},
+				{Key: "sfm", Value: bson.D{{Key: "white_background", Value: false}}},
+				{Key: "name", Value: "garden"},
+				{Key: "status", Value: int64(0)},
+			},
+			wantState: StateSfmDone,
+		},
+		{
+			name: "trained",
+			doc: bson.D{
+				{Key: "_id", Value: id},
+				{Key: "sfm", Value: bson.D{{Key: "white_background", Value: false}}},
+				{Key: "nerf", Value: bson.D{
+					{Key: "splat_cloud_file_paths", Value: bson.D{{Key: "7000", Value: "a.ply"}, {Key: "30000", Value: "b.ply"}}},
+					{Key: "flag", Value: int32(0)},
+				}},
+				{Key: "name", Value: "garden"},
+				{Key: "status", Value: float64(0)},
+			},
+			wantState:     StateCompleted,
+			wantIteration: 30000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sc Scene
+			if err := bson.Unmarshal(raw, &sc); err != nil {
+				t.Fatalf("decoding legacy scene: %v", err)
+			}
+			if sc.ID != id || sc.Name != "garden" {
+				t.Errorf("got scene %s %q, want %s %q", sc.ID.Hex(), sc.Name, id.Hex(), "garden")
+			}
+			if sc.Status == nil {
+				t.Fatal("got no status")
+			}
+			if sc.Status.State != tt.wantState || sc.Status.LatestIteration != tt.wantIteration {
+				t.Errorf("got status %s at iteration %d, want %s at iteration %d",
+					sc.Status.State, sc.Status.LatestIteration, tt.wantState, tt.wantIteration)
+			}
+			if !sc.Status.UpdatedAt.Equal(id.Timestamp()) {
+				t.Errorf("got updated at %v, want %v", sc.Status.UpdatedAt, id.Timestamp())
+			}
+		})
+	}
+}
+
+func TestUnmarshalSceneStatus(t *testing.T) {
+	want := &SceneStatus{State: StateTraining, LatestIteration: 7000, UpdatedAt: time.Now().UTC().Truncate(time.Millisecond)}
+	raw, err := bson.Marshal(&Scene{ID: primitive.NewObjectID(), Name: "garden", Status: want})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sc Scene
+	if err := bson.Unmarshal(raw, &sc); err != nil {
+		t.Fatal(err)
+	}
+	if sc.Status == nil || sc.Status.State != want.State || sc.Status.LatestIteration != want.LatestIteration ||
+		!sc.Status.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Errorf("got status %+v, want %+v", sc.Status, want)
+	}
+
+	// Scenes without a status keep having none
+	raw, err = bson.Marshal(bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "name", Value: "garden"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc = Scene{}
+	if err := bson.Unmarshal(raw, &sc); err != nil {
+		t.Fatal(err)
+	}
+	if sc.Status != nil {
+		t.Errorf("got status %+v, want none", sc.Status)
+	}
+}
@@ -0,0 +1,321 @@
+// This file contains the SceneManager maintenance routine that reconciles scene documents with files on disk.
+//
+// After a crash, raw videos and output directories can be left behind without a scene document, and scene documents
+// can be left referencing files that no longer exist. Reconcile finds both, and optionally removes them.
+//
+// Reconcile is safe to run while the server is live: anything modified within ReconcileOptions.MinAge is skipped,
+// so files of in-flight uploads and jobs, and scenes that are still processing, are never touched.
+
+package scene
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+// DefaultReconcileMinAge is used when ReconcileOptions.MinAge is not set.
+const DefaultReconcileMinAge = 10 * time.Minute
+
+// Directories scanned for files belonging to scenes. Raw videos of the previous layout are files named <job id>.mp4,
+// the scene directories contain a directory named <job id> per scene. See Storage.go for the layout.
+var (
+	rawVideoDir     = filepath.Join(dataDir, "raw", "videos")
+	sceneOutputDirs = []string{ScenesDir, legacySfmDir, legacyNerfDir}
+)
+
+// ReconcileOptions configures a Reconcile run.
+type ReconcileOptions struct {
+	// only report orphans, without removing anything
+	DryRun bool
+	// files and scenes modified more recently than this are skipped
+	MinAge time.Duration
+	// only look for orphaned files, leaving scene documents whose files are missing alone
+	FilesOnly bool
+}
+
+// OrphanedFile is a file or directory on disk that belongs to no scene document.
+type OrphanedFile struct {
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"mod_time"`
+	Removed bool      `json:"removed"`
+}
+
+// OrphanedScene is a scene document that references files which no longer exist.
+//
+// A scene is only removed when every file it references is missing, as a scene with some outputs left is still
+// useful to its owner.
+type OrphanedScene struct {
+	SceneID      primitive.ObjectID `json:"scene_id"`
+	MissingPaths []string           `json:"missing_paths"`
+	Removed      bool               `json:"removed"`
+}
+
+// ReconcileReport is the result of a Reconcile run.
+type ReconcileReport struct {
+	DryRun         bool            `json:"dry_run"`
+	OrphanedFiles  []OrphanedFile  `json:"orphaned_files"`
+	OrphanedScenes []OrphanedScene `json:"orphaned_scenes"`
+	// number of files and scenes skipped for being modified too recently
+	Skipped int `json:"skipped"`
+	// non fatal errors, i.e a file that could not be removed
+	Errors []string `json:"errors"`
+}
+
+// Reconcile cross-references scene documents with the raw video and output directories, and reports orphans in
+// both directions: files with no scene document, and scene documents whose files are missing.
+// Unless opts.DryRun is set, orphaned files are removed, and scene documents whose files are all missing are deleted.
+//
+// Deleted scene documents are not removed from their owners. Callers should do so using the returned report.
+func (sm *SceneManager) Reconcile(ctx context.Context, opts ReconcileOptions) (*ReconcileReport, error) {
+	return sm.maintenance().reconcile(ctx, opts)
+}
+
+// sceneDocuments is the access to scene documents that Reconcile and MigrateStorageLayout need, so every
+// SceneRepository shares their implementation, see maintenance.
+type sceneDocuments interface {
+	// existingSceneIDs returns which of the given scene IDs have a scene document.
+	existingSceneIDs(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error)
+	// eachScene calls fn with every scene document, or the error decoding it. Fields outside of projection may be unset.
+	eachScene(ctx context.Context, projection bson.M, fn func(sc *Scene, err error)) error
+	// setMigratedPaths stores the paths migrateScene moved the files of sc to, the fields of update.
+	setMigratedPaths(ctx context.Context, sc *Scene, update bson.M) error
+	DeleteScene(ctx context.Context, id primitive.ObjectID) error
+}
+
+// maintenance runs Reconcile and MigrateStorageLayout on the scene documents of a SceneRepository.
+type maintenance struct {
+	layout
+	docs   sceneDocuments
+	logger *log.Logger
+}
+
+// maintenance returns the maintenance of the scene collection.
+func (sm *SceneManager) maintenance() maintenance {
+	return maintenance{layout: sm.layout, docs: sm, logger: sm.logger}
+}
+
+// reconcile is Reconcile.
+func (m maintenance) reconcile(ctx context.Context, opts ReconcileOptions) (*ReconcileReport, error) {
+	if opts.MinAge <= 0 {
+		opts.MinAge = DefaultReconcileMinAge
+	}
+	cutoff := time.Now().Add(-opts.MinAge)
+
+	report := &ReconcileReport{
+		DryRun:         opts.DryRun,
+		OrphanedFiles:  make([]OrphanedFile, 0),
+		OrphanedScenes: make([]OrphanedScene, 0),
+		Errors:         make([]string, 0),
+	}
+
+	if err := m.reconcileFiles(ctx, opts, cutoff, report); err != nil {
+		return nil, err
+	}
+	if !opts.FilesOnly {
+		if err := m.reconcileScenes(ctx, opts, cutoff, report); err != nil {
+			return nil, err
+		}
+	}
+
+	m.logger.Infof("Reconcile found %d orphaned files and %d orphaned scenes (dry run: %t)",
+		len(report.OrphanedFiles), len(report.OrphanedScenes), opts.DryRun)
+	return report, nil
+}
+
+// reconcileFiles finds, and unless dry running removes, files on disk that belong to no scene document.
+func (m maintenance) reconcileFiles(ctx context.Context, opts ReconcileOptions, cutoff time.Time, report *ReconcileReport) error {
+	type candidate struct {
+		path    string
+		sceneID primitive.ObjectID
+		modTime time.Time
+	}
+	candidates := make([]candidate, 0)
+
+	scan := func(dir string, jobIDOf func(name string) (string, bool)) error {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			jobID, ok := jobIDOf(entry.Name())
+			if !ok {
+				continue
+			}
+			// Names that are not job IDs of this environment are not ours to judge
+			sceneID, err := m.ParseJobID(jobID)
+			if err != nil {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			candidates = append(candidates, candidate{filepath.Join(dir, entry.Name()), sceneID, info.ModTime()})
+		}
+		return nil
+	}
+
+	err := scan(rawVideoDir, func(name string) (string, bool) {
+		return strings.CutSuffix(name, ".mp4")
+	})
+	if err != nil {
+		return err
+	}
+	for _, dir := range sceneOutputDirs {
+		err := scan(dir, func(name string) (string, bool) { return name, true })
+		if err != nil {
+			return err
+		}
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(candidates))
+	for _, c := range candidates {
+		ids = append(ids, c.sceneID)
+	}
+	existing, err := m.docs.existingSceneIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range candidates {
+		if existing[c.sceneID] {
+			continue
+		}
+		if c.modTime.After(cutoff) {
+			report.Skipped++
+			continue
+		}
+
+		orphan := OrphanedFile{Path: c.path, ModTime: c.modTime}
+		if !opts.DryRun {
+			if err := os.RemoveAll(c.path); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			} else {
+				orphan.Removed = true
+			}
+		}
+		report.OrphanedFiles = append(report.OrphanedFiles, orphan)
+	}
+	return nil
+}
+
+// reconcileScenes finds scene documents referencing missing files, and unless dry running deletes those whose
+// files are all missing.
+func (m maintenance) reconcileScenes(ctx context.Context, opts ReconcileOptions, cutoff time.Time, report *ReconcileReport) error {
+	return m.docs.eachScene(ctx, bson.M{"sfm": 0}, func(sc *Scene, err error) {
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			return
+		}
+
+		// Processing scenes, and scenes that changed recently, may still be getting their files written
+		if sc.Status != nil && (!sc.Status.State.IsTerminal() || sc.Status.UpdatedAt.After(cutoff)) {
+			report.Skipped++
+			return
+		}
+
+		referenced := sceneFilePaths(sc)
+		missing := make([]string, 0)
+		for _, path := range referenced {
+			if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+				missing = append(missing, path)
+			}
+		}
+		if len(missing) == 0 {
+			return
+		}
+
+		orphan := OrphanedScene{SceneID: sc.ID, MissingPaths: missing}
+		if !opts.DryRun && len(missing) == len(referenced) {
+			if err := m.docs.DeleteScene(ctx, sc.ID); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			} else {
+				orphan.Removed = true
+			}
+		}
+		report.OrphanedScenes = append(report.OrphanedScenes, orphan)
+	})
+}
+
+// eachScene calls fn with every scene document, see sceneDocuments.
+func (sm *SceneManager) eachScene(ctx context.Context, projection bson.M, fn func(sc *Scene, err error)) error {
+	cursor, err := sm.collection.Find(ctx, bson.M{}, options.Find().SetProjection(projection))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var sc Scene
+		if err := cursor.Decode(&sc); err != nil {
+			fn(nil, err)
+			continue
+		}
+		fn(&sc, nil)
+	}
+	return cursor.Err()
+}
+
+// existingSceneIDs returns which of the given scene IDs have a scene document.
+func (sm *SceneManager) existingSceneIDs(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	existing := make(map[primitive.ObjectID]bool)
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	cursor, err := sm.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		existing[doc.ID] = true
+	}
+	return existing, cursor.Err()
+}
+
+// sceneFilePaths returns every file path a scene document references: its raw video or images and all nerf outputs.
+// A raw video removed by the retention policy is no longer referenced.
+func sceneFilePaths(sc *Scene) []string {
+	paths := make([]string, 0)
+	if sc.Video != nil && sc.Video.FilePath != "" && sc.Video.DeletedAt == nil {
+		paths = append(paths, sc.Video.FilePath)
+	}
+	if sc.Images != nil {
+		paths = append(paths, sc.Images.FilePaths...)
+	}
+	if sc.Nerf != nil {
+		for _, filePaths := range []map[int]string{
+			sc.Nerf.ModelFilePathsMap,
+			sc.Nerf.SplatCloudFilePathsMap,
+			sc.Nerf.PointCloudFilePathsMap,
+			sc.Nerf.VideoFilePathsMap,
+			sc.Nerf.CheckpointFilePathsMap,
+		} {
+			for _, path := range filePaths {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
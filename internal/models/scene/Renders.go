@@ -0,0 +1,128 @@
+// This file contains renders of a trained scene along a camera path, i.e flythrough videos.
+//
+// A render is requested with a camera path, either a pose per frame or a spline through keyframe poses, and is done by
+// the render worker from an output of the scene's nerf. Rendered videos are stored with the nerf output of the
+// training run they were rendered from, see RenderPath, and like exports are cleared when the scene is trained again.
+
+package scene
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrInvalidCameraPath is returned when a camera path cannot be rendered.
+	ErrInvalidCameraPath = errors.New("invalid camera path")
+	// ErrRenderNotFound is returned when a render does not exist.
+	ErrRenderNotFound = errors.New("render not found")
+)
+
+// RenderSources are the output types a render can be rendered from, preferred first.
+var RenderSources = []string{"splat_cloud", "model", OutputTypeCheckpoint}
+
+// Declarations for valid camera spline interpolations
+const (
+	InterpolationLinear     = "linear"
+	InterpolationCatmullRom = "catmull_rom"
+)
+
+// Declarations for valid render states
+const (
+	RenderStatePending = "pending"
+	RenderStateDone    = "done"
+	RenderStateFailed  = "failed"
+)
+
+// CameraPose is a camera position and orientation in the world coordinates of the scene's sfm output.
+type CameraPose struct {
+	Position [3]float64 `bson:"position" json:"position"`
+	// orientation as a unit quaternion, w x y z
+	Rotation [4]float64 `bson:"rotation" json:"rotation"`
+	// vertical field of view in degrees, the renderer's default if 0
+	FOV float64 `bson:"fov,omitempty" json:"fov,omitempty"`
+}
+
+// CameraSpline is a camera path interpolated through keyframe poses, evenly spaced over its duration.
+type CameraSpline struct {
+	Keyframes     []CameraPose `bson:"keyframes" json:"keyframes"`
+	Interpolation string       `bson:"interpolation" json:"interpolation"`
+	// length of the video, in seconds
+	Duration float64 `bson:"duration" json:"duration"`
+	// interpolate from the last keyframe back to the first
+	Loop bool `bson:"loop,omitempty" json:"loop,omitempty"`
+}
+
+// CameraPath is the path a render follows: either a pose per frame, or a spline. Exactly one must be set.
+type CameraPath struct {
+	Poses  []CameraPose  `bson:"poses,omitempty" json:"poses,omitempty"`
+	Spline *CameraSpline `bson:"spline,omitempty" json:"spline,omitempty"`
+	FPS    int           `bson:"fps" json:"fps"`
+	Width  int           `bson:"width" json:"width"`
+	Height int           `bson:"height" json:"height"`
+}
+
+// FrameCount returns the number of frames rendered along the path.
+func (p *CameraPath) FrameCount() int {
+	if p.Spline != nil {
+		return int(math.Ceil(p.Spline.Duration * float64(p.FPS)))
+	}
+	return len(p.Poses)
+}
+
+// Render is a render of a scene along a camera path.
+type Render struct {
+	ID    primitive.ObjectID `bson:"id" json:"id"`
+	State string             `bson:"state" json:"state"`
+	// output type and iteration rendered from
+	SourceType  string     `bson:"source_type" json:"source_type"`
+	Iteration   int        `bson:"iteration" json:"iteration"`
+	Path        CameraPath `bson:"path" json:"path"`
+	RequestedAt time.Time  `bson:"requested_at" json:"requested_at"`
+	CompletedAt time.Time  `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	FilePath    string     `bson:"file_path,omitempty" json:"-"`
+	Size        int64      `bson:"size,omitempty" json:"size,omitempty"`
+	Error       string     `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// SetRender records the state of a render of a scene, replacing any previous state of it. The scene must have nerf
+// output.
+//
+// Returns ErrSceneNotFound if the scene does not exist, or ErrNerfNotFound if it has no nerf output.
+func (sm *SceneManager) SetRender(ctx context.Context, id primitive.ObjectID, render *Render) error {
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "nerf": bson.M{"$type": "object"}},
+		bson.M{"$set": bson.M{"nerf.renders." + render.ID.Hex(): render}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := sm.GetNerf(ctx, id); err != nil {
+			return err
+		}
+		return ErrNerfNotFound
+	}
+	return nil
+}
+
+// GetRender retrieves a render of a scene.
+//
+// Returns ErrRenderNotFound if the scene has no such render, or the errors of GetNerf.
+func (sm *SceneManager) GetRender(ctx context.Context, id, renderID primitive.ObjectID) (*Render, error) {
+	nerf, err := sm.GetNerf(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	render, ok := nerf.Renders[renderID.Hex()]
+	if !ok {
+		return nil, ErrRenderNotFound
+	}
+	return render, nil
+}
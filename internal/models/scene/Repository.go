@@ -0,0 +1,117 @@
+// This file contains the SceneRepository interface, which services depend on instead of the MongoDB SceneManager.
+//
+// The interface uses only the types of this package, context, and primitive.ObjectID as an opaque 12 byte ID, so a
+// repository can be backed by any database. SceneManager documents the behavior and errors each method must have.
+
+package scene
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SceneRepository stores scenes, and lays out their files on disk. Implementations must be safe for concurrent use,
+// and return the errors of this package (i.e ErrSceneNotFound) as SceneManager does.
+type SceneRepository interface {
+	// EnsureIndexes prepares the storage of scenes, and is called once at startup.
+	EnsureIndexes(ctx context.Context) error
+
+	// Scene documents
+	CreateScene(ctx context.Context, newScene *Scene) error
+	SetScene(ctx context.Context, id primitive.ObjectID, scene *Scene) error
+	GetScene(ctx context.Context, id primitive.ObjectID) (*Scene, error)
+	DeleteScene(ctx context.Context, id primitive.ObjectID) error
+	SetSceneName(ctx context.Context, id primitive.ObjectID, name string) error
+	GetSceneName(ctx context.Context, id primitive.ObjectID) (string, error)
+	UpdateSceneMetadata(ctx context.Context, id primitive.ObjectID, update SceneMetadataUpdate) error
+	GetSceneOrgID(ctx context.Context, id primitive.ObjectID) (primitive.ObjectID, error)
+	RemoveOrgFromAll(ctx context.Context, orgID primitive.ObjectID) error
+	SetTrainingConfig(ctx context.Context, id primitive.ObjectID, config *TrainingConfig) error
+	GetTrainingConfig(ctx context.Context, id primitive.ObjectID) (*TrainingConfig, error)
+	SetVideo(ctx context.Context, id primitive.ObjectID, vid *Video) error
+	GetVideo(ctx context.Context, id primitive.ObjectID) (*Video, error)
+	SetSfm(ctx context.Context, id primitive.ObjectID, sfm *Sfm) error
+	GetSfm(ctx context.Context, id primitive.ObjectID) (*Sfm, error)
+	SetNerf(ctx context.Context, id primitive.ObjectID, nerf *Nerf) error
+	GetNerf(ctx context.Context, id primitive.ObjectID) (*Nerf, error)
+	SetOutputFile(ctx context.Context, id primitive.ObjectID, outputType string, iteration int, path, checksum string) error
+	GetCompletedIterations(ctx context.Context, id primitive.ObjectID, outputType string) ([]int, error)
+	SetExport(ctx context.Context, id primitive.ObjectID, format string, export *Export) error
+	SetRender(ctx context.Context, id primitive.ObjectID, render *Render) error
+	GetRender(ctx context.Context, id, renderID primitive.ObjectID) (*Render, error)
+
+	// Processing status
+	GetStatus(ctx context.Context, id primitive.ObjectID) (*SceneStatus, error)
+	TransitionStatus(ctx context.Context, id primitive.ObjectID, state State, errMsg string) error
+	FailJob(ctx context.Context, id primitive.ObjectID, failure *JobFailure) error
+	RestartScene(ctx context.Context, id primitive.ObjectID, config *TrainingConfig, state State) error
+	RestartSceneVersion(ctx context.Context, id primitive.ObjectID, config *TrainingConfig, state State) (int, error)
+	MarkTimeoutRequeue(ctx context.Context, id primitive.ObjectID, state State) error
+	SetLatestIteration(ctx context.Context, id primitive.ObjectID, iteration int) error
+	SetLatestProgress(ctx context.Context, id primitive.ObjectID, progress *Progress) error
+	SetProcessingDurations(ctx context.Context, id primitive.ObjectID, sfm, training time.Duration) error
+
+	// Queries across scenes
+	GetScenesByState(ctx context.Context, state State) ([]*Scene, error)
+	GetStaleScenes(ctx context.Context, state State, before time.Time) ([]*Scene, error)
+	GetRecentlyCompletedScenes(ctx context.Context, limit int64) ([]*Scene, error)
+	ListScenes(ctx context.Context, filter SceneListFilter, skip, limit int64) ([]*Scene, int64, error)
+	FindScenesWithVideo(ctx context.Context, ids []primitive.ObjectID, sha256 string) ([]*Scene, error)
+	CountProcessingWithPriority(ctx context.Context, priority string) (int64, error)
+	CountInStates(ctx context.Context, states ...State) (int64, error)
+	CountProcessingAmong(ctx context.Context, ids []primitive.ObjectID) (int64, error)
+	GetScenesWithRawVideoCompletedBefore(ctx context.Context, before time.Time) ([]*Scene, error)
+	GetScenesFinishedBefore(ctx context.Context, state State, before time.Time) ([]*Scene, error)
+	MarkRawVideoDeleted(ctx context.Context, id primitive.ObjectID, at time.Time) error
+
+	// Trash
+	TrashScene(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	RestoreScene(ctx context.Context, id primitive.ObjectID) error
+	GetTrashedAt(ctx context.Context, id primitive.ObjectID) (*time.Time, error)
+	GetScenesTrashedBefore(ctx context.Context, before time.Time) ([]*Scene, error)
+
+	// Collaborators and share links
+	SetCollaborator(ctx context.Context, id, userID primitive.ObjectID, role string) error
+	RemoveCollaborator(ctx context.Context, id, userID primitive.ObjectID) error
+	RemoveCollaboratorFromAll(ctx context.Context, userID primitive.ObjectID) error
+	GetCollaboratorRole(ctx context.Context, id, userID primitive.ObjectID) (string, error)
+	GetCollaborators(ctx context.Context, id primitive.ObjectID) ([]Collaborator, error)
+	AddShareLink(ctx context.Context, id primitive.ObjectID, link ShareLink) error
+	RemoveShareLink(ctx context.Context, id, linkID primitive.ObjectID) error
+	GetShareLinks(ctx context.Context, id primitive.ObjectID) ([]ShareLink, error)
+	GetShareLink(ctx context.Context, id, linkID primitive.ObjectID) (*ShareLink, error)
+
+	// WithTransaction runs fn so its writes, including those of other repositories made with the context passed to
+	// it, commit or roll back together. Repositories without transactions may run fn without one.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+
+	// Maintenance
+	Reconcile(ctx context.Context, opts ReconcileOptions) (*ReconcileReport, error)
+	MigrateStorageLayout(ctx context.Context) (*StorageMigrationReport, error)
+	MigrateLegacyStatus(ctx context.Context) (int, error)
+
+	// Job IDs and the layout of scene files, which do not depend on the database
+	JobID(id primitive.ObjectID) string
+	ParseJobID(jobID string) (primitive.ObjectID, error)
+	SceneDir(id primitive.ObjectID) string
+	ScenePath(id primitive.ObjectID, elem ...string) (string, error)
+	RawVideoPath(id primitive.ObjectID) string
+	RawPosesPath(id primitive.ObjectID) string
+	RawImagesDir(id primitive.ObjectID) string
+	RawImagePath(id primitive.ObjectID, fileName string) (string, error)
+	ThumbnailPath(id primitive.ObjectID) string
+	SfmDir(id primitive.ObjectID) string
+	SfmFramePath(id primitive.ObjectID, fileName string) (string, error)
+	OutputsDir(id primitive.ObjectID) string
+	RunOutputsDir(id primitive.ObjectID, version int) string
+	OutputPath(id primitive.ObjectID, version int, outputType string, iteration int, fileName string) (string, error)
+	RenderPath(id primitive.ObjectID, version int, renderID primitive.ObjectID) string
+	SceneKeyPrefix(id primitive.ObjectID) string
+	ObjectKey(id primitive.ObjectID, filePath string) (string, error)
+	LegacyStoragePaths(id primitive.ObjectID) (sfmDir, nerfDir string)
+}
+
+// SceneManager is the MongoDB SceneRepository.
+var _ SceneRepository = (*SceneManager)(nil)
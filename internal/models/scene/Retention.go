@@ -0,0 +1,84 @@
+// This file contains the SceneManager queries of the retention policy, which removes raw videos and failed scenes
+// once they are old enough. The policy itself, and the removal of files, is implemented by the services.
+//
+// Terminal scenes no longer change state, so the time their status was last updated is when they finished.
+
+package scene
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetScenesWithRawVideoCompletedBefore retrieves the scenes that completed before the given time and still have their
+// raw video, oldest first.
+func (sm *SceneManager) GetScenesWithRawVideoCompletedBefore(ctx context.Context, before time.Time) ([]*Scene, error) {
+	cursor, err := sm.collection.Find(ctx, bson.M{
+		"status.state":      StateCompleted,
+		"status.updated_at": bson.M{"$lt": before},
+		"video.file_path":   bson.M{"$nin": bson.A{nil, ""}},
+		"video.deleted_at":  bson.M{"$exists": false},
+	}, options.Find().SetProjection(bson.M{"sfm": 0}).SetSort(bson.D{{Key: "status.updated_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	scenes := make([]*Scene, 0)
+	if err := cursor.All(ctx, &scenes); err != nil {
+		return nil, err
+	}
+	return scenes, nil
+}
+
+// GetScenesFinishedBefore retrieves the scenes in the given terminal state that finished before the given time,
+// oldest first.
+func (sm *SceneManager) GetScenesFinishedBefore(ctx context.Context, state State, before time.Time) ([]*Scene, error) {
+	if !state.IsTerminal() {
+		return nil, ErrInvalidState
+	}
+	cursor, err := sm.collection.Find(ctx, bson.M{
+		"status.state":      state,
+		"status.updated_at": bson.M{"$lt": before},
+	}, options.Find().SetProjection(bson.M{"sfm": 0}).SetSort(bson.D{{Key: "status.updated_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	scenes := make([]*Scene, 0)
+	if err := cursor.All(ctx, &scenes); err != nil {
+		return nil, err
+	}
+	return scenes, nil
+}
+
+// MarkRawVideoDeleted records that the raw video of a completed scene was removed by the retention policy. The state
+// is checked in the update filter, so a scene that was retrained meanwhile keeps its video.
+//
+// Returns ErrInvalidStatusTransition if the scene is no longer completed, or ErrVideoNotFound if its video was
+// already removed.
+func (sm *SceneManager) MarkRawVideoDeleted(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	result, err := sm.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "status.state": StateCompleted, "video.file_path": bson.M{"$exists": true}, "video.deleted_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"video.deleted_at": at}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		sc, err := sm.GetScene(ctx, id)
+		if err != nil {
+			return err
+		}
+		if sc.Status == nil || sc.Status.State != StateCompleted {
+			return ErrInvalidStatusTransition
+		}
+		return ErrVideoNotFound
+	}
+	return nil
+}
@@ -12,8 +12,16 @@ package scene
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
 )
 
 // Custom errors
@@ -22,20 +30,61 @@ var (
 	ErrInvalidOutputType = errors.New("invalid output type")
 	// ErrNoOutputPaths is returned when no output paths are found for a given output type.
 	ErrNoOutputPaths = errors.New("no output path found")
+	// ErrCorruptCheckpoint is returned when a checkpoint file no longer matches the checksum recorded when it was saved.
+	ErrCorruptCheckpoint = errors.New("checkpoint file failed integrity check")
+	// ErrInvalidTrainingConfig is returned when a training config has invalid or inconsistent values.
+	ErrInvalidTrainingConfig = errors.New("invalid training config")
 	// ErrInvalidOpOnProcessingScene is returned when an invalid operation is attempted on a processing scene.
 	//(I.e, trying to delete a scene that nerf-worker is actively training)
 	ErrInvalidOpOnProcessingScene = errors.New("invalid operation on processing scene")
 )
 
 // Scene represents a scene and its components
+//
+// InputType is what the user uploaded for the scene, and is empty for scenes uploaded before image sets existed, which
+// are videos. Scenes uploaded as an image set have their images in Images, and Video only holds the properties of the
+// images, as it does for scenes uploaded with pre-computed sfm output.
+//
+// Collaborators are the users other than the owner the scene is shared with, see Collaborators.go. ShareLinks give
+// anyone holding one read-only access to the scene's outputs, see ShareLinks.go.
+//
+// Description and Tags are set by the owner after upload along with the name, see UpdateSceneMetadata.
+//
+// OrgID is the organization whose workspace the scene was uploaded into, if any. Its members have access to the scene
+// by their role in it, while the uploader remains its owner and is charged for its storage.
+//
+// Traceparent is the trace context of the upload that created the scene, so the jobs published for it without a
+// request to trace, i.e after its grace period, continue the upload's trace.
+//
+// TrashedAt is when the scene was moved to the trash, if it is in the trash, see Trash.go.
 type Scene struct {
-	Video  *Video             `bson:"video,omitempty" json:"video,omitempty"`
-    Sfm    *Sfm               `bson:"sfm,omitempty" json:"sfm,omitempty"`
-    Config *TrainingConfig    `bson:"config,omitempty" json:"config,omitempty"`
-    Nerf   *Nerf              `bson:"nerf,omitempty" json:"nerf,omitempty"`
-    ID     primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-    Status int                `bson:"status" json:"status"`
-	Name   string             `bson:"name" json:"name"`
+	InputType string             `bson:"input_type,omitempty" json:"input_type,omitempty"`
+	Images    *ImageSet          `bson:"images,omitempty" json:"images,omitempty"`
+	Video     *Video             `bson:"video,omitempty" json:"video,omitempty"`
+	Sfm       *Sfm               `bson:"sfm,omitempty" json:"sfm,omitempty"`
+	Config    *TrainingConfig    `bson:"config,omitempty" json:"config,omitempty"`
+	Nerf      *Nerf              `bson:"nerf,omitempty" json:"nerf,omitempty"`
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Status    *SceneStatus       `bson:"status,omitempty" json:"status,omitempty"`
+	Name      string             `bson:"name" json:"name"`
+
+	Description string   `bson:"description,omitempty" json:"description,omitempty"`
+	Tags        []string `bson:"tags,omitempty" json:"tags,omitempty"`
+
+	OrgID primitive.ObjectID `bson:"org_id,omitempty" json:"org_id,omitempty"`
+
+	Collaborators []Collaborator `bson:"collaborators,omitempty" json:"collaborators,omitempty"`
+	ShareLinks    []ShareLink    `bson:"share_links,omitempty" json:"-"`
+	Traceparent   string         `bson:"traceparent,omitempty" json:"-"`
+
+	// verdict of the content scan of the uploaded files, unset if uploads were not scanned
+	ContentScan *ContentScan `bson:"content_scan,omitempty" json:"content_scan,omitempty"`
+
+	TrashedAt *time.Time `bson:"trashed_at,omitempty" json:"trashed_at,omitempty"`
+
+	// the current training run, unset until the scene is retrained as a new run, see CurrentVersion
+	Version int           `bson:"version,omitempty" json:"version,omitempty"`
+	Runs    []TrainingRun `bson:"runs,omitempty" json:"runs,omitempty"`
 }
 
 // Video represents video metadata
@@ -46,6 +95,43 @@ type Video struct {
     FPS        int    `bson:"fps" json:"fps"`
     Duration   int    `bson:"duration" json:"duration"`
     FrameCount int    `bson:"frame_count" json:"frame_count"`
+    FileSize   int64  `bson:"file_size" json:"file_size"`
+    // hex SHA-256 of the uploaded video, unset for scenes uploaded before checksums were computed
+    SHA256     string `bson:"sha256,omitempty" json:"sha256,omitempty"`
+    // when the file was removed by the retention policy, nil while it is kept
+    DeletedAt  *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+}
+
+// Declarations for valid content scan verdicts. Flagged uploads are rejected, so no scene records them.
+const (
+	ScanVerdictClean = "clean"
+	// the scanner was unavailable, and the upload was accepted as scanning fails open
+	ScanVerdictUnscanned = "unscanned"
+)
+
+// ContentScan is the verdict of the content scan of the files uploaded for a scene.
+type ContentScan struct {
+	Verdict   string    `bson:"verdict" json:"verdict"`
+	ScannedAt time.Time `bson:"scanned_at" json:"scanned_at"`
+}
+
+// Declarations for valid scene input types
+const (
+	InputTypeVideo  = "video"
+	InputTypeImages = "images"
+)
+
+// UploadedInputType returns what the user uploaded for the scene, see Scene.InputType.
+func (sc *Scene) UploadedInputType() string {
+	if sc.InputType == "" {
+		return InputTypeVideo
+	}
+	return sc.InputType
+}
+
+// ImageSet represents the images of a scene uploaded as an image set, in the order they are passed to the sfm-worker.
+type ImageSet struct {
+	FilePaths []string `bson:"file_paths" json:"file_paths"`
 }
 
 // Frame represents a single frame in the SfM process
@@ -55,25 +141,105 @@ type Frame struct {
 }
 
 // Sfm represents the Structure from Motion data from Colmap worker.
+//
+// Precomputed is set when the camera poses were uploaded by the user rather than computed by the sfm-worker, in which
+// case the scene never ran the sfm stage. It is also set when the sfm output was copied from another scene of the same
+// video, which ReusedFrom is the ID of.
 type Sfm struct {
-    IntrinsicMatrix [][]float64 `bson:"intrinsic_matrix" json:"intrinsic_matrix"`
-    Frames          []Frame     `bson:"frames" json:"frames"`
-    WhiteBackground bool        `bson:"white_background" json:"white_background"`
+    IntrinsicMatrix [][]float64         `bson:"intrinsic_matrix" json:"intrinsic_matrix"`
+    Frames          []Frame             `bson:"frames" json:"frames"`
+    WhiteBackground bool                `bson:"white_background" json:"white_background"`
+    Precomputed     bool                `bson:"precomputed,omitempty" json:"precomputed,omitempty"`
+    ReusedFrom      *primitive.ObjectID `bson:"reused_from,omitempty" json:"reused_from,omitempty"`
 }
 
 
 // TrainingConfig represents the configuration for training
+//
+// Priority is the priority the scene's jobs were published with. Scenes created before priorities existed have none,
+// and are treated as PriorityNormal.
+//
+// Tier is the broker.Tiers tier whose job queues the scene's jobs are published to. Scenes created before tiers
+// existed have none, and are treated as broker.TierStandard.
 type TrainingConfig struct {
 	SfmTrainingConfig  *SfmTrainingConfig  `bson:"sfm_training_config,omitempty" json:"sfm_training_config,omitempty"`
 	NerfTrainingConfig *NerfTrainingConfig `bson:"nerf_training_config,omitempty" json:"nerf_training_config,omitempty"`
+	Priority           string              `bson:"priority,omitempty" json:"priority,omitempty"`
+	Tier               string              `bson:"tier,omitempty" json:"tier,omitempty"`
+}
+
+// Declarations for valid job priorities
+const (
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+
+	// MaxAMQPPriority is the x-max-priority the job queues are declared with.
+	MaxAMQPPriority = 10
+)
+
+// ValidPriorities maps job priorities to the AMQP message priority their jobs are published with.
+// Higher values are consumed first.
+var ValidPriorities = map[string]uint8{
+	PriorityNormal: 1,
+	PriorityHigh:   5,
+}
+
+// IsValidPriority checks if the given job priority is valid
+func IsValidPriority(priority string) bool {
+	_, ok := ValidPriorities[priority]
+	return ok
+}
+
+// AMQPPriority returns the AMQP message priority jobs of this configuration are published with.
+func (c *TrainingConfig) AMQPPriority() uint8 {
+	if c == nil {
+		return ValidPriorities[PriorityNormal]
+	}
+	if p, ok := ValidPriorities[c.Priority]; ok {
+		return p
+	}
+	return ValidPriorities[PriorityNormal]
+}
+
+// JobTier returns the tier jobs of this configuration are published to.
+func (c *TrainingConfig) JobTier() string {
+	if c == nil || !broker.IsValidTier(c.Tier) {
+		return broker.TierStandard
+	}
+	return c.Tier
 }
 
 // NerfTrainingConfig represents the configuration for NeRF training
+//
+// FrameSampleRate and TargetFrameCount control how many frames the sfm-worker extracts from the video, either every
+// Nth frame or about a target number of frames spread evenly over the video. At most one may be set. When neither is
+// set, the sfm-worker uses its own default.
 type NerfTrainingConfig struct {
-	TrainingMode    string   `bson:"training_mode" json:"training_mode"`
-	OutputTypes     []string `bson:"output_types" json:"output_types"`
-	SaveIterations  []int    `bson:"save_iterations" json:"save_iterations"`
-	TotalIterations int      `bson:"total_iterations" json:"total_iterations"`
+	TrainingMode     string   `bson:"training_mode" json:"training_mode"`
+	OutputTypes      []string `bson:"output_types" json:"output_types"`
+	SaveIterations   []int    `bson:"save_iterations" json:"save_iterations"`
+	TotalIterations  int      `bson:"total_iterations" json:"total_iterations"`
+	FrameSampleRate  int      `bson:"frame_sample_rate,omitempty" json:"frame_sample_rate,omitempty"`
+	TargetFrameCount int      `bson:"target_frame_count,omitempty" json:"target_frame_count,omitempty"`
+}
+
+// HasFrameSampling checks if the config overrides the sfm-worker's default frame sampling
+func (c *NerfTrainingConfig) HasFrameSampling() bool {
+	return c != nil && (c.FrameSampleRate > 0 || c.TargetFrameCount > 0)
+}
+
+// SampledFrameCount returns how many frames are extracted from a video of frameCount frames with the config's frame
+// sampling. Returns frameCount if the config does not override frame sampling.
+func (c *NerfTrainingConfig) SampledFrameCount(frameCount int) int {
+	switch {
+	case c == nil:
+		return frameCount
+	case c.FrameSampleRate > 0:
+		return (frameCount + c.FrameSampleRate - 1) / c.FrameSampleRate
+	case c.TargetFrameCount > 0:
+		return min(c.TargetFrameCount, frameCount)
+	}
+	return frameCount
 }
 
 // SfmTrainingConfig represents the configuration for SfM training
@@ -84,25 +250,47 @@ type SfmTrainingConfig struct {
 // Nerf represents the finished nerf training. 
 //
 // Int Keys should be strictly greater than 0.
+// CheckpointChecksums holds the hex encoded SHA-256 of each checkpoint file, recorded when the checkpoint was saved.
+// ExportFilePathsMap holds the exported files by export format, and Exports the state of the export to each format,
+// see Exports.go.
 type Nerf struct {
-    ModelFilePathsMap      map[int]string `bson:"model_file_paths,omitempty" json:"model_file_paths,omitempty"`
-    SplatCloudFilePathsMap map[int]string `bson:"splat_cloud_file_paths,omitempty" json:"splat_cloud_file_paths,omitempty"`
-    PointCloudFilePathsMap map[int]string `bson:"point_cloud_file_paths,omitempty" json:"point_cloud_file_paths,omitempty"`
-    VideoFilePathsMap      map[int]string `bson:"video_file_paths,omitempty" json:"video_file_paths,omitempty"`
-    Flag                   int            `bson:"flag" json:"flag"`
+    ModelFilePathsMap      map[int]string            `bson:"model_file_paths,omitempty" json:"model_file_paths,omitempty"`
+    SplatCloudFilePathsMap map[int]string            `bson:"splat_cloud_file_paths,omitempty" json:"splat_cloud_file_paths,omitempty"`
+    PointCloudFilePathsMap map[int]string            `bson:"point_cloud_file_paths,omitempty" json:"point_cloud_file_paths,omitempty"`
+    VideoFilePathsMap      map[int]string            `bson:"video_file_paths,omitempty" json:"video_file_paths,omitempty"`
+    CheckpointFilePathsMap map[int]string            `bson:"checkpoint_file_paths,omitempty" json:"checkpoint_file_paths,omitempty"`
+    CheckpointChecksums    map[int]string            `bson:"checkpoint_checksums,omitempty" json:"checkpoint_checksums,omitempty"`
+    ExportFilePathsMap     map[string]map[int]string `bson:"export_file_paths,omitempty" json:"export_file_paths,omitempty"`
+    Exports                map[string]*Export        `bson:"exports,omitempty" json:"exports,omitempty"`
+    Renders                map[string]*Render        `bson:"renders,omitempty" json:"renders,omitempty"`
+    Flag                   int                       `bson:"flag" json:"flag"`
 }
 
 // Declarations for valid training modes and output types
 const (
 	TrainingModeGaussian = "gaussian"
 	TrainingModeTensorf  = "tensorf"
+
+	// OutputTypeCheckpoint is the raw model checkpoint of an iteration, which can be used to continue training elsewhere.
+	OutputTypeCheckpoint = "checkpoint"
+
+	// MaxTotalIterations is the most training iterations a scene can be trained for.
+	MaxTotalIterations = 30000
 )
 var (
 	ValidTrainingModes = []string{TrainingModeGaussian, TrainingModeTensorf}
 	ValidOutputTypes   = map[string][]string{
-		TrainingModeGaussian: {"splat_cloud", "point_cloud", "video"},
-		TrainingModeTensorf:  {"model", "video"},
+		TrainingModeGaussian: {"splat_cloud", "point_cloud", "video", OutputTypeCheckpoint},
+		TrainingModeTensorf:  {"model", "video", OutputTypeCheckpoint},
+	}
+	// OutputConversions maps output types to the formats their stored files can be converted to when served.
+	// Output types not listed are only served in the format the nerf-worker stored them in.
+	OutputConversions = map[string][]string{
+		"video": {"mp4", "webm", "gif"},
 	}
+	// ViewableFormats are the output formats browsers can display directly. Outputs in any other format (i.e point
+	// clouds and checkpoints) are meant to be downloaded.
+	ViewableFormats = []string{"mp4", "webm", "gif", "png", "jpg"}
 )	
 
 // IsValidTrainingMode checks if the given training mode is valid
@@ -129,6 +317,67 @@ func (Nerf) IsValidOutputType(trainingMode, outputType string) bool {
 	return false
 }
 
+// Validate checks that the config only uses valid training modes and output types, that every save iteration is
+// within the total iterations, and that at most one positive frame sampling option is set.
+//
+// Returns ErrInvalidTrainingConfig, stating the offending value, otherwise.
+func (c *NerfTrainingConfig) Validate() error {
+	if c == nil {
+		return fmt.Errorf("%w: missing nerf training config", ErrInvalidTrainingConfig)
+	}
+	if !(Nerf{}).IsValidTrainingMode(c.TrainingMode) {
+		return fmt.Errorf("%w: training mode %s", ErrInvalidTrainingConfig, c.TrainingMode)
+	}
+	if len(c.OutputTypes) == 0 {
+		return fmt.Errorf("%w: no output types", ErrInvalidTrainingConfig)
+	}
+	for _, outputType := range c.OutputTypes {
+		if !(Nerf{}).IsValidOutputType(c.TrainingMode, outputType) {
+			return fmt.Errorf("%w: output type %s for training mode %s", ErrInvalidTrainingConfig, outputType, c.TrainingMode)
+		}
+	}
+	if c.TotalIterations < 1 || c.TotalIterations > MaxTotalIterations {
+		return fmt.Errorf("%w: total iterations must be between 1 and %d", ErrInvalidTrainingConfig, MaxTotalIterations)
+	}
+	for _, iteration := range c.SaveIterations {
+		if iteration < 1 || iteration > c.TotalIterations {
+			return fmt.Errorf("%w: save iteration %d outside of 1 to %d", ErrInvalidTrainingConfig, iteration, c.TotalIterations)
+		}
+	}
+	return c.ValidateFrameSampling()
+}
+
+// ValidateFrameSampling checks that at most one frame sampling option is set, and that it is positive.
+//
+// Returns ErrInvalidTrainingConfig, stating the offending value, otherwise.
+func (c *NerfTrainingConfig) ValidateFrameSampling() error {
+	if c.FrameSampleRate < 0 || c.TargetFrameCount < 0 {
+		return fmt.Errorf("%w: frame sampling must be positive", ErrInvalidTrainingConfig)
+	}
+	if c.FrameSampleRate > 0 && c.TargetFrameCount > 0 {
+		return fmt.Errorf("%w: only one of frame sample rate and target frame count may be set", ErrInvalidTrainingConfig)
+	}
+	return nil
+}
+
+// AvailableFormats returns the formats an output file stored at storedPath can be served in: its stored format
+// (the file extension) first, followed by any formats it can be converted to.
+func AvailableFormats(outputType, storedPath string) []string {
+	stored := strings.TrimPrefix(filepath.Ext(storedPath), ".")
+	formats := []string{stored}
+	for _, format := range OutputConversions[outputType] {
+		if format != stored {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// IsViewableFormat checks if browsers can display an output in the given format directly
+func IsViewableFormat(format string) bool {
+	return slices.Contains(ViewableFormats, format)
+}
+
 // GetFilePathsForOutputType returns a map of iteration to file path for a given output type.
 //
 // Returns (nil, ErrInvalidOutputType) if the output type is invalid.
@@ -142,11 +391,57 @@ func (n *Nerf) GetFilePathsForType(outputType string) (map[int]string, error) {
 		return n.PointCloudFilePathsMap, nil
 	case "video":
 		return n.VideoFilePathsMap, nil
+	case OutputTypeCheckpoint:
+		return n.CheckpointFilePathsMap, nil
 	default:
+		if IsExportOutputType(outputType) {
+			return n.ExportFilePathsMap[outputType], nil
+		}
 		return nil, ErrInvalidOutputType
 	}
 }
 
+// outputPathsField returns the document field holding the file paths of the given output type.
+//
+// Returns ("", ErrInvalidOutputType) if the output type is invalid.
+func outputPathsField(outputType string) (string, error) {
+	switch outputType {
+	case "model":
+		return "nerf.model_file_paths", nil
+	case "splat_cloud":
+		return "nerf.splat_cloud_file_paths", nil
+	case "point_cloud":
+		return "nerf.point_cloud_file_paths", nil
+	case "video":
+		return "nerf.video_file_paths", nil
+	case OutputTypeCheckpoint:
+		return "nerf.checkpoint_file_paths", nil
+	default:
+		if IsExportOutputType(outputType) {
+			return "nerf.export_file_paths." + outputType, nil
+		}
+		return "", ErrInvalidOutputType
+	}
+}
+
+// CompletedIterations returns the iterations of the given output type whose files exist on disk, sorted ascending.
+// Returns ErrInvalidOutputType if the output type is invalid.
+func (n *Nerf) CompletedIterations(outputType string) ([]int, error) {
+	filePaths, err := n.GetFilePathsForType(outputType)
+	if err != nil {
+		return nil, err
+	}
+
+	iterations := make([]int, 0)
+	for iteration, path := range filePaths {
+		if _, err := os.Stat(path); err == nil {
+			iterations = append(iterations, iteration)
+		}
+	}
+	slices.Sort(iterations)
+	return iterations, nil
+}
+
 // GetFilePathsForTypeAndIter returns the file path for a single given output type and iteration.
 //
 // Iteration is the key in the file paths map, and should be > 0, unless iteration is -1,
@@ -163,8 +458,13 @@ func (n *Nerf) GetFilePathForTypeAndIter(outputType string, iteration int) (stri
 		filePathsMap = n.PointCloudFilePathsMap
 	case "video":
 		filePathsMap = n.VideoFilePathsMap
+	case OutputTypeCheckpoint:
+		filePathsMap = n.CheckpointFilePathsMap
 	default:
-		return "", ErrInvalidOutputType
+		if !IsExportOutputType(outputType) {
+			return "", ErrInvalidOutputType
+		}
+		filePathsMap = n.ExportFilePathsMap[outputType]
 	}
 
 	if iteration == -1 {
@@ -7,6 +7,10 @@ package scene
 import (
 	"context"
 	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -28,21 +32,61 @@ var (
 	ErrNerfNotFound = errors.New("nerf not found")
 	// ErrTrainingConfigNotFound is returned when a requested training config is not found in the database.
 	ErrTrainingConfigNotFound = errors.New("training config not found")
+	// ErrStatusNotFound is returned when a requested scene status is not found in the database.
+	ErrStatusNotFound = errors.New("scene status not found")
+	// ErrForeignJobID is returned when a job ID does not carry this environment's prefix.
+	ErrForeignJobID = errors.New("job ID belongs to another environment")
+	// ErrSceneAlreadyExists is returned when creating a scene with the ID of an existing scene.
+	ErrSceneAlreadyExists = errors.New("scene already exists")
 )
 
 type SceneManager struct {
+	layout
+	client     *mongo.Client
 	collection *mongo.Collection
 	logger     *log.Logger
+	// whether the deployment supports transactions, see WithTransaction
+	transactionsMu      sync.Mutex
+	transactionsChecked bool
+	transactions        bool
 }
 
 // NewSceneManager creates a new SceneManager with the given MongoDB client and logger.
-func NewSceneManager(client *mongo.Client, logger *log.Logger, unittest bool) *SceneManager {
+//
+// jobIDPrefix is prepended to the job IDs used for worker messages and file storage, so environments that share
+// storage or a broker never collide. It should be empty unless multiple environments are deployed side by side.
+func NewSceneManager(client *mongo.Client, logger *log.Logger, jobIDPrefix string, unittest bool) *SceneManager {
 	return &SceneManager{
+		layout:     layout{jobIDPrefix: jobIDPrefix},
+		client:     client,
 		collection: client.Database("nerfdb").Collection("scenes"),
 		logger:     logger,
 	}
 }
 
+// JobID returns the environment-qualified job ID of a scene, i.e `dev-<objectid>`, or just `<objectid>` if no prefix is configured.
+// This is the form that should be used for storage paths, worker messages, and logs.
+func (l layout) JobID(id primitive.ObjectID) string {
+	if l.jobIDPrefix == "" {
+		return id.Hex()
+	}
+	return l.jobIDPrefix + "-" + id.Hex()
+}
+
+// ParseJobID converts a job ID produced by JobID back into the scene ID.
+//
+// Returns ErrForeignJobID if the job ID does not carry this environment's prefix.
+func (l layout) ParseJobID(jobID string) (primitive.ObjectID, error) {
+	if l.jobIDPrefix != "" {
+		hex, ok := strings.CutPrefix(jobID, l.jobIDPrefix+"-")
+		if !ok {
+			return primitive.NilObjectID, ErrForeignJobID
+		}
+		jobID = hex
+	}
+	return primitive.ObjectIDFromHex(jobID)
+}
+
 // SetTrainingConfig sets the TrainingConfig data in the database by the scene ID.
 func (sm *SceneManager) SetTrainingConfig(ctx context.Context, id primitive.ObjectID, config *TrainingConfig) error {
 	result, err := sm.collection.UpdateOne(
@@ -60,6 +104,21 @@ func (sm *SceneManager) SetTrainingConfig(ctx context.Context, id primitive.Obje
 	return nil
 }
 
+// CreateScene inserts a new scene as one complete document, so its video or images, name, config, and status are
+// written at once. Unlike SetScene, an existing scene is never modified.
+//
+// Returns ErrSceneAlreadyExists if a scene with the same ID exists, or error if the scene has no ID.
+func (sm *SceneManager) CreateScene(ctx context.Context, newScene *Scene) error {
+	if newScene.ID.IsZero() {
+		return errors.New("scene has no ID")
+	}
+	_, err := sm.collection.InsertOne(ctx, newScene)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrSceneAlreadyExists
+	}
+	return err
+}
+
 // SetScene sets the Scene data in the database by the scene ID.
 func (sm *SceneManager) SetScene(ctx context.Context, id primitive.ObjectID, scene *Scene) error {
 	result, err := sm.collection.UpdateOne(
@@ -128,6 +187,31 @@ func (sm *SceneManager) SetNerf(ctx context.Context, id primitive.ObjectID, nerf
 	return nil
 }
 
+// SetOutputFile records the file path of a single output type and iteration in the Nerf data of a scene, leaving every
+// other output in place. checksum is the SHA-256 of the file, and is only stored for checkpoints.
+//
+// Returns ErrInvalidOutputType if the output type is invalid, or ErrSceneNotFound if the scene does not exist.
+func (sm *SceneManager) SetOutputFile(ctx context.Context, id primitive.ObjectID, outputType string, iteration int, path, checksum string) error {
+	field, err := outputPathsField(outputType)
+	if err != nil {
+		return err
+	}
+
+	iterationKey := strconv.Itoa(iteration)
+	set := bson.M{field + "." + iterationKey: path}
+	if outputType == OutputTypeCheckpoint {
+		set["nerf.checkpoint_checksums."+iterationKey] = checksum
+	}
+	result, err := sm.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSceneNotFound
+	}
+	return nil
+}
+
 // SetSceneName sets the name of the scene in the database by its ID.
 func (sm *SceneManager) SetSceneName(ctx context.Context, id primitive.ObjectID, name string) error {
 	result, err := sm.collection.UpdateOne(
@@ -160,6 +244,64 @@ func (sm *SceneManager) GetSceneName(ctx context.Context, id primitive.ObjectID)
 	return result.Name, nil
 }
 
+// GetSceneOrgID retrieves the organization whose workspace the scene is in by its ID, nil if it is in none.
+func (sm *SceneManager) GetSceneOrgID(ctx context.Context, id primitive.ObjectID) (primitive.ObjectID, error) {
+	var result struct {
+		OrgID primitive.ObjectID `bson:"org_id"`
+	}
+	err := sm.collection.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"org_id": 1})).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return primitive.NilObjectID, ErrSceneNotFound
+		}
+		return primitive.NilObjectID, err
+	}
+	return result.OrgID, nil
+}
+
+// RemoveOrgFromAll takes every scene out of the workspace of the given organization, i.e once it is deleted. The
+// scenes stay with their owners.
+func (sm *SceneManager) RemoveOrgFromAll(ctx context.Context, orgID primitive.ObjectID) error {
+	_, err := sm.collection.UpdateMany(ctx, bson.M{"org_id": orgID}, bson.M{"$unset": bson.M{"org_id": ""}})
+	return err
+}
+
+// SceneMetadataUpdate is a change to the user editable metadata of a scene. Nil fields are left unchanged, and an
+// empty, non-nil Tags removes every tag.
+type SceneMetadataUpdate struct {
+	Name        *string
+	Description *string
+	Tags        []string
+}
+
+// UpdateSceneMetadata sets the fields of update on the scene in the database by its ID. An update changing nothing
+// only checks that the scene exists.
+func (sm *SceneManager) UpdateSceneMetadata(ctx context.Context, id primitive.ObjectID, update SceneMetadataUpdate) error {
+	set := bson.M{}
+	if update.Name != nil {
+		set["name"] = *update.Name
+	}
+	if update.Description != nil {
+		set["description"] = *update.Description
+	}
+	if update.Tags != nil {
+		set["tags"] = update.Tags
+	}
+	if len(set) == 0 {
+		_, err := sm.GetSceneName(ctx, id)
+		return err
+	}
+
+	result, err := sm.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSceneNotFound
+	}
+	return nil
+}
+
 // GetTrainingConfig retrieves the TrainingConfig data from the database by its ID.
 func (sm *SceneManager) GetTrainingConfig(ctx context.Context, id primitive.ObjectID) (*TrainingConfig, error) {
 	var result struct {
@@ -191,6 +333,207 @@ func (sm *SceneManager) GetScene(ctx context.Context, id primitive.ObjectID) (*S
 	return &scene, nil
 }
 
+// GetScenesByState retrieves every scene currently in the given state.
+func (sm *SceneManager) GetScenesByState(ctx context.Context, state State) ([]*Scene, error) {
+	cursor, err := sm.collection.Find(ctx, bson.M{"status.state": state})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	scenes := make([]*Scene, 0)
+	if err := cursor.All(ctx, &scenes); err != nil {
+		return nil, err
+	}
+	return scenes, nil
+}
+
+// GetStaleScenes retrieves the scenes in the given state that have neither changed state nor reported progress since
+// before, see SceneStatus.LastActivity.
+func (sm *SceneManager) GetStaleScenes(ctx context.Context, state State, before time.Time) ([]*Scene, error) {
+	cursor, err := sm.collection.Find(ctx, bson.M{
+		"status.state":      state,
+		"status.updated_at": bson.M{"$lt": before},
+		"$or": bson.A{
+			bson.M{"status.progress_at": bson.M{"$exists": false}},
+			bson.M{"status.progress_at": bson.M{"$lt": before}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	scenes := make([]*Scene, 0)
+	if err := cursor.All(ctx, &scenes); err != nil {
+		return nil, err
+	}
+	return scenes, nil
+}
+
+// MarkTimeoutRequeue counts a requeue of a timed out scene still in the given state, and resets its activity so it
+// gets a full timeout again. The state is checked in the update filter, so a scene that moved on meanwhile is not touched.
+// Returns ErrInvalidStatusTransition if the scene is no longer in the given state.
+func (sm *SceneManager) MarkTimeoutRequeue(ctx context.Context, id primitive.ObjectID, state State) error {
+	now := time.Now()
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status.state": state},
+		bson.M{
+			"$inc": bson.M{"status.timeout_requeues": 1},
+			"$set": bson.M{"status.updated_at": now, "status.progress_at": now},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidStatusTransition
+	}
+	return nil
+}
+
+// CountProcessingWithPriority counts the scenes with the given job priority that are not in a terminal state.
+// Scenes without a priority are counted as PriorityNormal.
+func (sm *SceneManager) CountProcessingWithPriority(ctx context.Context, priority string) (int64, error) {
+	priorities := bson.A{priority}
+	if priority == PriorityNormal {
+		priorities = append(priorities, "", nil)
+	}
+	return sm.collection.CountDocuments(ctx, bson.M{
+		"config.priority": bson.M{"$in": priorities},
+		"status.state":    bson.M{"$nin": bson.A{StateCompleted, StateFailed, StateCancelled}},
+	})
+}
+
+// CountInStates counts the scenes in any of the given states.
+func (sm *SceneManager) CountInStates(ctx context.Context, states ...State) (int64, error) {
+	return sm.collection.CountDocuments(ctx, bson.M{"status.state": bson.M{"$in": states}})
+}
+
+// CountProcessingAmong counts the scenes with one of the given IDs that are still processing, i.e not in a terminal state.
+func (sm *SceneManager) CountProcessingAmong(ctx context.Context, ids []primitive.ObjectID) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return sm.collection.CountDocuments(ctx, bson.M{
+		"_id":          bson.M{"$in": ids},
+		"status.state": bson.M{"$nin": terminalStates()},
+	})
+}
+
+// SceneListFilter narrows the scenes returned by ListScenes. Zero fields do not filter.
+type SceneListFilter struct {
+	State State
+	// only scenes in one of these states, if non-empty. Ignored if State is set
+	States []State
+	// only scenes with one of these IDs, if non-nil (an empty, non-nil slice matches nothing)
+	IDs []primitive.ObjectID
+	// only scenes whose name, description, or tags match these words, see Search.go. Matches are listed best first
+	Text string
+	// only scenes with every one of these tags
+	Tags []string
+	// only scenes in the workspace of this organization, if not nil
+	OrgID primitive.ObjectID
+	// list the oldest scenes first, instead of the newest
+	OldestFirst bool
+	// whether scenes in the trash are listed, none by default
+	Trash TrashFilter
+}
+
+// ListScenes retrieves a page of scenes matching filter, along with the total number of matching scenes. Scenes are
+// listed best match first if filter.Text is set, then newest first unless filter.OldestFirst is set. Sfm and nerf data,
+// including that of previous training runs, is not loaded, as listings only need the scene summary.
+func (sm *SceneManager) ListScenes(ctx context.Context, filter SceneListFilter, skip, limit int64) ([]*Scene, int64, error) {
+	query := bson.M{}
+	if filter.State != "" {
+		query["status.state"] = filter.State
+	} else if len(filter.States) > 0 {
+		query["status.state"] = bson.M{"$in": filter.States}
+	}
+	if filter.IDs != nil {
+		query["_id"] = bson.M{"$in": filter.IDs}
+	}
+	if filter.Text != "" {
+		query["$text"] = bson.M{"$search": filter.Text}
+	}
+	if len(filter.Tags) > 0 {
+		query["tags"] = bson.M{"$all": filter.Tags}
+	}
+	if !filter.OrgID.IsZero() {
+		query["org_id"] = filter.OrgID
+	}
+	switch filter.Trash {
+	case TrashExcluded:
+		query["trashed_at"] = bson.M{"$exists": false}
+	case TrashOnly:
+		query["trashed_at"] = bson.M{"$exists": true}
+	}
+
+	total, err := sm.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Object IDs start with their creation time, so sorting by ID sorts by creation time
+	sortOrder := -1
+	if filter.OldestFirst {
+		sortOrder = 1
+	}
+	sort := bson.D{{Key: "_id", Value: sortOrder}}
+	projection := bson.M{"sfm": 0, "nerf": 0, "runs.nerf": 0}
+	if filter.Text != "" {
+		sort = append(bson.D{{Key: textScoreField, Value: bson.M{"$meta": "textScore"}}}, sort...)
+		projection[textScoreField] = bson.M{"$meta": "textScore"}
+	}
+	cursor, err := sm.collection.Find(
+		ctx,
+		query,
+		options.Find().
+			SetSort(sort).
+			SetSkip(skip).
+			SetLimit(limit).
+			SetProjection(projection),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	scenes := make([]*Scene, 0)
+	if err := cursor.All(ctx, &scenes); err != nil {
+		return nil, 0, err
+	}
+	return scenes, total, nil
+}
+
+// FindScenesWithVideo retrieves the scenes with one of the given IDs whose video has the given SHA-256 and that have
+// sfm output, newest first. Scenes whose sfm output is being computed, or was never computed, are not returned.
+func (sm *SceneManager) FindScenesWithVideo(ctx context.Context, ids []primitive.ObjectID, sha256 string) ([]*Scene, error) {
+	if len(ids) == 0 || sha256 == "" {
+		return []*Scene{}, nil
+	}
+	cursor, err := sm.collection.Find(
+		ctx,
+		bson.M{
+			"_id":          bson.M{"$in": ids},
+			"video.sha256": sha256,
+			"sfm.frames.0": bson.M{"$exists": true},
+		},
+		options.Find().SetSort(bson.M{"_id": -1}).SetProjection(bson.M{"nerf": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	scenes := make([]*Scene, 0)
+	if err := cursor.All(ctx, &scenes); err != nil {
+		return nil, err
+	}
+	return scenes, nil
+}
+
 // GetVideo retrieves the Video data from the database by its ID.
 func (sm *SceneManager) GetVideo(ctx context.Context, id primitive.ObjectID) (*Video, error) {
 	var result struct {
@@ -245,6 +588,226 @@ func (sm *SceneManager) GetNerf(ctx context.Context, id primitive.ObjectID) (*Ne
 	return result.Nerf, nil
 }
 
+// GetStatus retrieves the SceneStatus data from the database by its ID.
+func (sm *SceneManager) GetStatus(ctx context.Context, id primitive.ObjectID) (*SceneStatus, error) {
+	// Decoded as a Scene, whose output the status of scenes stored with the legacy integer status is derived from
+	var result Scene
+	projection := bson.M{"_id": 1, "sfm": 1, "nerf": 1, "status": 1}
+	err := sm.collection.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(projection)).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSceneNotFound
+		}
+		return nil, err
+	}
+	if result.Status == nil {
+		return nil, ErrStatusNotFound
+	}
+	return result.Status, nil
+}
+
+// GetCompletedIterations returns the iterations of the given output type whose files exist on disk, sorted ascending.
+//
+// Returns an empty slice if no iteration is ready yet, including when the scene has no nerf output at all.
+// Returns ErrInvalidOutputType if the output type is invalid, or ErrSceneNotFound if the scene does not exist.
+func (sm *SceneManager) GetCompletedIterations(ctx context.Context, id primitive.ObjectID, outputType string) ([]int, error) {
+	nerf, err := sm.GetNerf(ctx, id)
+	if errors.Is(err, ErrNerfNotFound) {
+		return make([]int, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nerf.CompletedIterations(outputType)
+}
+
+// TransitionStatus moves the scene to the given state, if the transition is legal from its current state.
+// errMsg is stored alongside the state, and should be empty unless the scene is moving to StateFailed.
+//
+// The current state is checked in the update filter, so concurrent transitions cannot race past the state machine.
+// Returns ErrInvalidStatusTransition (and logs the attempt) if the transition is not legal.
+func (sm *SceneManager) TransitionStatus(ctx context.Context, id primitive.ObjectID, state State, errMsg string) error {
+	return sm.transitionStatus(ctx, id, state, errMsg, nil)
+}
+
+// FailJob moves the scene to StateFailed with the reason of the failure as its error, and records the failure.
+//
+// Returns ErrInvalidStatusTransition (and logs the attempt) if the scene has already finished.
+func (sm *SceneManager) FailJob(ctx context.Context, id primitive.ObjectID, failure *JobFailure) error {
+	return sm.transitionStatus(ctx, id, StateFailed, failure.Reason, failure)
+}
+
+// transitionStatus moves the scene to the given state, see TransitionStatus, recording the failure if not nil.
+func (sm *SceneManager) transitionStatus(ctx context.Context, id primitive.ObjectID, state State, errMsg string, failure *JobFailure) error {
+	if !state.IsValid() {
+		return ErrInvalidState
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"status.state":                       state,
+		"status.error":                       errMsg,
+		"status.updated_at":                  now,
+		"status.entered_at." + string(state): now,
+	}
+	if failure != nil {
+		set["status.failure"] = failure
+	}
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status.state": bson.M{"$in": sourceStates(state)}},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		current, err := sm.GetStatus(ctx, id)
+		if err != nil {
+			return err
+		}
+		sm.logger.Warnf("Rejected status transition for scene %s: %s -> %s", id.Hex(), current.State, state)
+		return ErrInvalidStatusTransition
+	}
+	return nil
+}
+
+// RestartScene starts processing a scene in a terminal state again, from the given state, with a new training config.
+// The previous nerf output, and when restarting from before sfm also the sfm output, are cleared from the document,
+// and the status is reset so durations and iterations of the previous run are not carried over.
+//
+// The terminal state is checked in the update filter, so a scene cannot be restarted while it is processing.
+// Returns ErrInvalidStatusTransition if the scene is not in a terminal state.
+func (sm *SceneManager) RestartScene(ctx context.Context, id primitive.ObjectID, config *TrainingConfig, state State) error {
+	if state != StateQueued && state != StateSfmDone {
+		return ErrInvalidState
+	}
+
+	now := time.Now()
+	unset := bson.M{"nerf": ""}
+	if state == StateQueued {
+		unset["sfm"] = ""
+	}
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status.state": bson.M{"$in": terminalStates()}},
+		bson.M{
+			"$set": bson.M{
+				"config": config,
+				"status": &SceneStatus{State: state, UpdatedAt: now, EnteredAt: map[State]time.Time{state: now}},
+			},
+			"$unset": unset,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		current, err := sm.GetStatus(ctx, id)
+		if err != nil {
+			return err
+		}
+		sm.logger.Warnf("Rejected restart of scene %s: scene is %s", id.Hex(), current.State)
+		return ErrInvalidStatusTransition
+	}
+	return nil
+}
+
+// SetLatestIteration records the latest training iteration that produced output for the scene, and that the worker
+// made progress. The stored value only ever increases, so redelivered or out-of-order worker output cannot move it backwards.
+func (sm *SceneManager) SetLatestIteration(ctx context.Context, id primitive.ObjectID, iteration int) error {
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$max": bson.M{"status.latest_iteration": iteration},
+			"$set": bson.M{"status.progress_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSceneNotFound
+	}
+	return nil
+}
+
+// SetLatestProgress records the latest progress reported by a worker for a scene that is still processing, and counts
+// it as activity of the scene, see SceneStatus.LastActivity. Progress reported after the scene finished is dropped.
+// The throughput of progress is averaged with the progress recorded before, see Progress.Smooth.
+//
+// Returns ErrSceneNotFound if no processing scene has the given ID.
+func (sm *SceneManager) SetLatestProgress(ctx context.Context, id primitive.ObjectID, progress *Progress) error {
+	var current struct {
+		Status struct {
+			Progress *Progress `bson:"progress"`
+		} `bson:"status"`
+	}
+	err := sm.collection.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"status.progress": 1})).Decode(&current)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrSceneNotFound
+	}
+	if err != nil {
+		return err
+	}
+	progress.Smooth(current.Status.Progress)
+
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status.state": bson.M{"$nin": terminalStates()}},
+		bson.M{"$set": bson.M{
+			"status.progress":    progress,
+			"status.progress_at": progress.ReportedAt,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSceneNotFound
+	}
+	return nil
+}
+
+// SetProcessingDurations records the measured sfm and training durations of a completed scene.
+func (sm *SceneManager) SetProcessingDurations(ctx context.Context, id primitive.ObjectID, sfm, training time.Duration) error {
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"status.sfm_seconds":      sfm.Seconds(),
+			"status.training_seconds": training.Seconds(),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSceneNotFound
+	}
+	return nil
+}
+
+// GetRecentlyCompletedScenes retrieves up to limit completed scenes with recorded processing durations, most recent first.
+func (sm *SceneManager) GetRecentlyCompletedScenes(ctx context.Context, limit int64) ([]*Scene, error) {
+	cursor, err := sm.collection.Find(
+		ctx,
+		bson.M{"status.state": StateCompleted, "status.training_seconds": bson.M{"$gt": 0}},
+		options.Find().SetSort(bson.M{"status.updated_at": -1}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	scenes := make([]*Scene, 0)
+	if err := cursor.All(ctx, &scenes); err != nil {
+		return nil, err
+	}
+	return scenes, nil
+}
+
 // DeleteScene deletes a scene from the database by its ID.
 func (sm *SceneManager) DeleteScene(ctx context.Context, id primitive.ObjectID) error {
 	result, err := sm.collection.DeleteOne(ctx, bson.M{"_id": id})
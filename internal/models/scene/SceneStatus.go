@@ -0,0 +1,203 @@
+// This file contains the SceneStatus struct and the scene processing state machine.
+//
+// Every state a scene can be in, and every legal transition between states, is declared here. The SceneManager
+// refuses to persist a transition that is not listed in stateTransitions, so the AMPQ consumers cannot move a scene
+// backwards (i.e, completed -> training) when a message is redelivered.
+//
+// The state machine (terminal states have no outgoing transitions):
+//
+//	[grace_period ->] [pending_admission ->] queued -> sfm_running -> sfm_done -> training -> completed
+//	   queued -> pending_admission
+//	   any non-terminal state -> failed | cancelled
+//
+// grace_period is only used when a delay is configured between scene creation and publishing the sfm job.
+// pending_admission is only used when the number of jobs in flight is capped, and holds scenes whose sfm job is
+// waiting for a free slot. A queued scene moves there if it was not admitted before its job was published.
+// Scenes uploaded with pre-computed sfm output start in sfm_done, as they never run the sfm stage.
+// A scene in a terminal state can only be processed again by restarting it with SceneManager.RestartScene.
+
+package scene
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var (
+	// ErrInvalidStatusTransition is returned when a scene is moved to a state that is not reachable from its current state.
+	ErrInvalidStatusTransition = errors.New("invalid scene status transition")
+	// ErrInvalidState is returned when an unknown state is used.
+	ErrInvalidState = errors.New("invalid scene state")
+)
+
+// State is the processing state of a scene.
+type State string
+
+// Declarations for valid scene states
+const (
+	StateGracePeriod      State = "grace_period"
+	StatePendingAdmission State = "pending_admission"
+	StateQueued           State = "queued"
+	StateSfmRunning       State = "sfm_running"
+	StateSfmDone          State = "sfm_done"
+	StateTraining         State = "training"
+	StateCompleted        State = "completed"
+	StateFailed           State = "failed"
+	StateCancelled        State = "cancelled"
+)
+
+// stateTransitions maps each state to the states it may legally transition to.
+var stateTransitions = map[State][]State{
+	StateGracePeriod:      {StatePendingAdmission, StateQueued, StateFailed, StateCancelled},
+	StatePendingAdmission: {StateQueued, StateFailed, StateCancelled},
+	StateQueued:           {StatePendingAdmission, StateSfmRunning, StateFailed, StateCancelled},
+	StateSfmRunning:       {StateSfmDone, StateFailed, StateCancelled},
+	StateSfmDone:          {StateTraining, StateFailed, StateCancelled},
+	StateTraining:         {StateCompleted, StateFailed, StateCancelled},
+	StateCompleted:        {},
+	StateFailed:           {},
+	StateCancelled:        {},
+}
+
+// SceneStatus represents the persisted processing status of a scene.
+//
+// LatestIteration is the farthest training iteration that has produced output, and is 0 until training output is received.
+// Error is only set when State is StateFailed. EnteredAt records when the scene last entered each state it has been in.
+// SfmSeconds and TrainingSeconds are the measured stage durations, and are only set once the scene has completed.
+// ProgressAt is when a worker last reported progress (i.e training output) without changing the state, and
+// TimeoutRequeues counts how often the scene's job was requeued after timing out, see LastActivity.
+// Progress is the latest progress reported by a worker while the scene is processing, and is kept once it finished.
+// Failure details why the job of a failed scene failed, and is only set for jobs failed by a worker or the pipeline,
+// i.e dead-lettered or timed out, rather than rejected before processing.
+type SceneStatus struct {
+	State           State               `bson:"state" json:"state"`
+	LatestIteration int                 `bson:"latest_iteration" json:"latest_iteration"`
+	Error           string              `bson:"error,omitempty" json:"error,omitempty"`
+	UpdatedAt       time.Time           `bson:"updated_at" json:"updated_at"`
+	EnteredAt       map[State]time.Time `bson:"entered_at,omitempty" json:"entered_at,omitempty"`
+	SfmSeconds      float64             `bson:"sfm_seconds,omitempty" json:"sfm_seconds,omitempty"`
+	TrainingSeconds float64             `bson:"training_seconds,omitempty" json:"training_seconds,omitempty"`
+	ProgressAt      time.Time           `bson:"progress_at,omitempty" json:"progress_at,omitempty"`
+	TimeoutRequeues int                 `bson:"timeout_requeues,omitempty" json:"timeout_requeues,omitempty"`
+	Progress        *Progress           `bson:"progress,omitempty" json:"progress,omitempty"`
+	Failure         *JobFailure         `bson:"failure,omitempty" json:"failure,omitempty"`
+}
+
+// JobFailure is why the job of a scene failed. Stage is the pipeline stage that failed, i.e "sfm" or "training", and
+// Queue the message queue the failing message was consumed from, if any. Attempts counts how often the stage was tried.
+type JobFailure struct {
+	Stage    string    `bson:"stage" json:"stage"`
+	Queue    string    `bson:"queue,omitempty" json:"queue,omitempty"`
+	Reason   string    `bson:"reason" json:"reason"`
+	Attempts int       `bson:"attempts" json:"attempts"`
+	FailedAt time.Time `bson:"failed_at" json:"failed_at"`
+}
+
+// Progress is a progress report of the worker processing a scene. Iteration and TotalIterations are only reported by
+// the training stage. ETASeconds is the worker's estimate of the time left in the stage, and 0 if it gave none.
+// IterationsPerSecond is the moving average of the training throughput over the reports of the stage, see Smooth.
+type Progress struct {
+	Stage               string    `bson:"stage" json:"stage"`
+	Iteration           int       `bson:"iteration,omitempty" json:"iteration,omitempty"`
+	TotalIterations     int       `bson:"total_iterations,omitempty" json:"total_iterations,omitempty"`
+	ETASeconds          float64   `bson:"eta_seconds,omitempty" json:"eta_seconds,omitempty"`
+	IterationsPerSecond float64   `bson:"iterations_per_second,omitempty" json:"iterations_per_second,omitempty"`
+	ReportedAt          time.Time `bson:"reported_at" json:"reported_at"`
+}
+
+// throughputSmoothing is the weight of the latest throughput in the moving average of Progress.IterationsPerSecond.
+// Lower values react slower to changes of throughput, but give steadier estimates.
+const throughputSmoothing = 0.3
+
+// Smooth sets the throughput of the report from the previous report of the same stage, as an exponential moving
+// average of the throughput between consecutive reports. The previous throughput is kept when the iteration did not
+// advance, and the average starts over when the stage changed or went backwards (i.e, the job was requeued).
+func (p *Progress) Smooth(prev *Progress) {
+	if prev == nil || prev.Stage != p.Stage || p.Iteration < prev.Iteration || !p.ReportedAt.After(prev.ReportedAt) {
+		return
+	}
+	if p.Iteration == prev.Iteration {
+		p.IterationsPerSecond = prev.IterationsPerSecond
+		return
+	}
+
+	rate := float64(p.Iteration-prev.Iteration) / p.ReportedAt.Sub(prev.ReportedAt).Seconds()
+	if prev.IterationsPerSecond <= 0 {
+		p.IterationsPerSecond = rate
+		return
+	}
+	p.IterationsPerSecond = throughputSmoothing*rate + (1-throughputSmoothing)*prev.IterationsPerSecond
+}
+
+// LastActivity returns when the scene last changed state or reported progress.
+func (st *SceneStatus) LastActivity() time.Time {
+	if st.ProgressAt.After(st.UpdatedAt) {
+		return st.ProgressAt
+	}
+	return st.UpdatedAt
+}
+
+// StageDuration returns the time between entering state from and entering state to.
+// Returns false if the scene has not entered both states.
+func (st *SceneStatus) StageDuration(from, to State) (time.Duration, bool) {
+	start, ok := st.EnteredAt[from]
+	if !ok {
+		return 0, false
+	}
+	end, ok := st.EnteredAt[to]
+	if !ok || end.Before(start) {
+		return 0, false
+	}
+	return end.Sub(start), true
+}
+
+// IsValid checks if the state is a known scene state.
+func (s State) IsValid() bool {
+	_, ok := stateTransitions[s]
+	return ok
+}
+
+// IsTerminal checks if no further transitions are possible from the state.
+func (s State) IsTerminal() bool {
+	return len(stateTransitions[s]) == 0
+}
+
+// terminalStates returns every state from which no further transitions are possible.
+func terminalStates() []State {
+	terminal := make([]State, 0)
+	for s := range stateTransitions {
+		if s.IsTerminal() {
+			terminal = append(terminal, s)
+		}
+	}
+	return terminal
+}
+
+// processingStates returns every state that is not terminal.
+func processingStates() []State {
+	processing := make([]State, 0)
+	for s := range stateTransitions {
+		if !s.IsTerminal() {
+			processing = append(processing, s)
+		}
+	}
+	return processing
+}
+
+// CanTransitionTo checks if moving from state s to next is a legal transition.
+func (s State) CanTransitionTo(next State) bool {
+	return slices.Contains(stateTransitions[s], next)
+}
+
+// sourceStates returns every state that may legally transition to next.
+// Internally used to build an atomic filter for status updates.
+func sourceStates(next State) []State {
+	sources := make([]State, 0)
+	for state, targets := range stateTransitions {
+		if slices.Contains(targets, next) {
+			sources = append(sources, state)
+		}
+	}
+	return sources
+}
@@ -0,0 +1,41 @@
+// This file contains the indexes scenes are searched by, see SceneListFilter.Text and SceneListFilter.Tags.
+//
+// Names, descriptions, and tags are covered by a single MongoDB text index, which matches whole words after stemming,
+// ignoring case and diacritics. A name match outranks a tag match, which outranks a description match. Words are not
+// matched by prefix, and a phrase in double quotes must appear as is. Tags are also indexed on their own, as they are
+// filtered by exactly.
+
+package scene
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// textScoreField is the field the text search score of a scene is projected to, which scenes are sorted by.
+const textScoreField = "score"
+
+// EnsureIndexes creates the text index scenes are searched by, and the index of their tags. Existing indexes are kept.
+func (sm *SceneManager) EnsureIndexes(ctx context.Context) error {
+	_, err := sm.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "name", Value: "text"},
+				{Key: "tags", Value: "text"},
+				{Key: "description", Value: "text"},
+			},
+			Options: options.Index().
+				SetName("scene_search").
+				SetWeights(bson.D{
+					{Key: "name", Value: 10},
+					{Key: "tags", Value: 5},
+					{Key: "description", Value: 1},
+				}),
+		},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+	})
+	return err
+}
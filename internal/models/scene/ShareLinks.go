@@ -0,0 +1,103 @@
+// This file contains the share links of a scene, which give anyone holding the link read-only access to its outputs.
+//
+// Only the ID and expiry of a link are stored on the scene document. The token handed out is signed by the services
+// layer, and is only valid while its link is still stored here, so deleting a link revokes every copy of its token.
+
+package scene
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrShareLinkNotFound is returned when a scene has no share link with the given ID, i.e it was revoked.
+	ErrShareLinkNotFound = errors.New("share link not found")
+)
+
+// ShareLink is a share link of a scene. ExpiresAt is zero for links that never expire.
+type ShareLink struct {
+	ID        primitive.ObjectID `bson:"id" json:"id"`
+	CreatedBy primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+// AddShareLink adds a share link to the scene.
+func (sm *SceneManager) AddShareLink(ctx context.Context, id primitive.ObjectID, link ShareLink) error {
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$push": bson.M{"share_links": link}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSceneNotFound
+	}
+	return nil
+}
+
+// RemoveShareLink removes a share link from the scene, revoking it.
+// Returns ErrShareLinkNotFound if the scene has no share link with the given ID.
+func (sm *SceneManager) RemoveShareLink(ctx context.Context, id, linkID primitive.ObjectID) error {
+	result, err := sm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$pull": bson.M{"share_links": bson.M{"id": linkID}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSceneNotFound
+	}
+	if result.ModifiedCount == 0 {
+		return ErrShareLinkNotFound
+	}
+	return nil
+}
+
+// GetShareLinks returns every share link of the scene, oldest first.
+func (sm *SceneManager) GetShareLinks(ctx context.Context, id primitive.ObjectID) ([]ShareLink, error) {
+	var result struct {
+		ShareLinks []ShareLink `bson:"share_links"`
+	}
+	err := sm.collection.FindOne(
+		ctx,
+		bson.M{"_id": id},
+		options.FindOne().SetProjection(bson.M{"share_links": 1}),
+	).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSceneNotFound
+		}
+		return nil, err
+	}
+	if result.ShareLinks == nil {
+		return make([]ShareLink, 0), nil
+	}
+	return result.ShareLinks, nil
+}
+
+// GetShareLink returns the share link of the scene with the given ID.
+// Returns ErrShareLinkNotFound if the scene has no such link, or ErrSceneNotFound if it does not exist.
+func (sm *SceneManager) GetShareLink(ctx context.Context, id, linkID primitive.ObjectID) (*ShareLink, error) {
+	links, err := sm.GetShareLinks(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range links {
+		if links[i].ID == linkID {
+			return &links[i], nil
+		}
+	}
+	return nil, ErrShareLinkNotFound
+}
@@ -0,0 +1,357 @@
+// This file contains the on-disk layout of scene files, and the migration of scenes stored in the previous layout.
+//
+// Every file of a scene lives in its own directory, named after the scene's job ID:
+//
+//	data/scenes/<job id>/raw/video.mp4                                 uploaded video
+//	data/scenes/<job id>/raw/transforms.json                           uploaded camera poses, instead of a video
+//	data/scenes/<job id>/raw/images/<image>                            uploaded image set, instead of a video
+//	data/scenes/<job id>/thumbnail.jpg                                 thumbnail, see ClientService.GetSceneThumbnailPath
+//	data/scenes/<job id>/sfm/<frame>                                   sfm frames
+//	data/scenes/<job id>/outputs/<output type>/iteration_<n>/<file>    nerf output of the first training run
+//	data/scenes/<job id>/runs/<v>/<output type>/iteration_<n>/<file>   nerf output of training run v > 1, see Versions.go
+//	data/scenes/<job id>/outputs/video/iteration_<n>/preview.mp4       preview clip of the frames rendered at iteration n
+//	data/scenes/<job id>/outputs/renders/<render id>.mp4               renders, under runs/<v> for training run v > 1
+//
+// Deleting or sizing a scene is a single directory operation, and scenes can never collide on file names. All paths
+// should be resolved with the SceneManager helpers below, which reject components that would escape the scene's
+// directory, as some components (i.e file names) come from worker supplied URLs.
+//
+// When an object store is configured, the files of a scene are mirrored to it under the same layout, with keys
+// relative to ScenesDir's parent (i.e "scenes/<job id>/outputs/..."), see ObjectKey.
+//
+// Scenes created before this layout kept their raw video in data/raw/videos, their frames in data/sfm/<job id>, and
+// their nerf output in data/nerf/<job id>. MigrateStorageLayout moves them into the layout above.
+
+package scene
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrInvalidPathComponent is returned when a path component would resolve outside of a scene's directory.
+	ErrInvalidPathComponent = errors.New("invalid path component")
+)
+
+// dataDir is the directory holding every scene file, see SetDataDir.
+var dataDir = "data"
+
+// ScenesDir is the directory holding a directory per scene.
+var ScenesDir = filepath.Join(dataDir, "scenes")
+
+// Names within a scene's directory
+const (
+	rawDirName     = "raw"
+	sfmDirName     = "sfm"
+	outputsDirName = "outputs"
+	runsDirName    = "runs"
+	rendersDirName = "renders"
+	rawVideoName   = "video.mp4"
+	rawPosesName   = "transforms.json"
+	rawImagesName  = "images"
+	thumbnailName  = "thumbnail.jpg"
+)
+
+// Directories of the previous layout, see MigrateStorageLayout
+var (
+	legacySfmDir  = filepath.Join(dataDir, "sfm")
+	legacyNerfDir = filepath.Join(dataDir, "nerf")
+)
+
+// SetDataDir moves the directory holding every scene file, "data" by default, i.e to a mounted volume. It must be
+// called before any scene file is accessed, as the files in the previous directory are not moved.
+func SetDataDir(dir string) {
+	dataDir = dir
+	ScenesDir = filepath.Join(dir, "scenes")
+	legacySfmDir = filepath.Join(dir, "sfm")
+	legacyNerfDir = filepath.Join(dir, "nerf")
+	rawVideoDir = filepath.Join(dir, "raw", "videos")
+	sceneOutputDirs = []string{ScenesDir, legacySfmDir, legacyNerfDir}
+}
+
+// layout resolves the job IDs of scenes and the paths of their files, which do not depend on the database. Every
+// SceneRepository embeds it.
+type layout struct {
+	jobIDPrefix string
+}
+
+// DataDir returns the directory holding every scene file, see SetDataDir.
+func DataDir() string {
+	return dataDir
+}
+
+// SceneDir returns the directory holding every file of a scene.
+func (l layout) SceneDir(id primitive.ObjectID) string {
+	return filepath.Join(ScenesDir, l.JobID(id))
+}
+
+// ScenePath joins the given components onto the directory of a scene.
+//
+// Returns ErrInvalidPathComponent if a component is empty, is "." or "..", contains a path separator, or would
+// otherwise resolve outside of the scene's directory.
+func (l layout) ScenePath(id primitive.ObjectID, elem ...string) (string, error) {
+	dir := l.SceneDir(id)
+	for _, e := range elem {
+		if e == "" || e == "." || e == ".." || strings.ContainsAny(e, `/\`+"\x00") {
+			return "", fmt.Errorf("%w: %q", ErrInvalidPathComponent, e)
+		}
+	}
+
+	path := filepath.Join(append([]string{dir}, elem...)...)
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPathComponent, filepath.Join(elem...))
+	}
+	return path, nil
+}
+
+// RawVideoPath returns the path of a scene's uploaded video.
+func (l layout) RawVideoPath(id primitive.ObjectID) string {
+	return filepath.Join(l.SceneDir(id), rawDirName, rawVideoName)
+}
+
+// RawPosesPath returns the path of a scene's uploaded camera poses, for scenes uploaded with pre-computed sfm output.
+func (l layout) RawPosesPath(id primitive.ObjectID) string {
+	return filepath.Join(l.SceneDir(id), rawDirName, rawPosesName)
+}
+
+// RawImagesDir returns the directory holding a scene's uploaded image set.
+func (l layout) RawImagesDir(id primitive.ObjectID) string {
+	return filepath.Join(l.SceneDir(id), rawDirName, rawImagesName)
+}
+
+// RawImagePath returns the path of an image of a scene's uploaded image set, stored under fileName.
+// Returns ErrInvalidPathComponent if fileName is not a plain file name.
+func (l layout) RawImagePath(id primitive.ObjectID, fileName string) (string, error) {
+	return l.ScenePath(id, rawDirName, rawImagesName, fileName)
+}
+
+// ThumbnailPath returns the path of a scene's thumbnail.
+func (l layout) ThumbnailPath(id primitive.ObjectID) string {
+	return filepath.Join(l.SceneDir(id), thumbnailName)
+}
+
+// SfmDir returns the directory holding a scene's sfm frames.
+func (l layout) SfmDir(id primitive.ObjectID) string {
+	return filepath.Join(l.SceneDir(id), sfmDirName)
+}
+
+// SfmFramePath returns the path of a sfm frame of a scene, stored under fileName.
+// Returns ErrInvalidPathComponent if fileName is not a plain file name.
+func (l layout) SfmFramePath(id primitive.ObjectID, fileName string) (string, error) {
+	return l.ScenePath(id, sfmDirName, fileName)
+}
+
+// OutputsDir returns the directory holding a scene's nerf output.
+func (l layout) OutputsDir(id primitive.ObjectID) string {
+	return filepath.Join(l.SceneDir(id), outputsDirName)
+}
+
+// RunOutputsDir returns the directory holding the nerf output of a training run of a scene.
+func (l layout) RunOutputsDir(id primitive.ObjectID, version int) string {
+	return filepath.Join(l.SceneDir(id), filepath.Join(runOutputsElems(version)...))
+}
+
+// OutputPath returns the path of a nerf output file of a training run of a scene, stored under fileName.
+// Returns ErrInvalidPathComponent if the output type or fileName is not a plain file name.
+func (l layout) OutputPath(id primitive.ObjectID, version int, outputType string, iteration int, fileName string) (string, error) {
+	return l.ScenePath(id, append(runOutputsElems(version), outputType, fmt.Sprintf("iteration_%d", iteration), fileName)...)
+}
+
+// RenderPath returns the path of the video of a render of a training run of a scene.
+func (l layout) RenderPath(id primitive.ObjectID, version int, renderID primitive.ObjectID) string {
+	elems := append(runOutputsElems(version), rendersDirName, renderID.Hex()+".mp4")
+	return filepath.Join(l.SceneDir(id), filepath.Join(elems...))
+}
+
+// runOutputsElems returns the path components of the directory holding the nerf output of a training run, relative
+// to the scene's directory.
+func runOutputsElems(version int) []string {
+	if version <= 1 {
+		return []string{outputsDirName}
+	}
+	return []string{runsDirName, strconv.Itoa(version)}
+}
+
+// SceneKeyPrefix returns the prefix of the object store keys of every file of a scene, ending with a "/".
+func (l layout) SceneKeyPrefix(id primitive.ObjectID) string {
+	return path.Join(filepath.Base(ScenesDir), l.JobID(id)) + "/"
+}
+
+// ObjectKey returns the object store key of a file in a scene's directory, which mirrors its path within the directory.
+// Returns ErrInvalidPathComponent if the file is not within the scene's directory.
+func (l layout) ObjectKey(id primitive.ObjectID, filePath string) (string, error) {
+	rel, err := filepath.Rel(l.SceneDir(id), filePath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPathComponent, filePath)
+	}
+	return l.SceneKeyPrefix(id) + filepath.ToSlash(rel), nil
+}
+
+// StorageMigrationReport is the result of a MigrateStorageLayout run.
+type StorageMigrationReport struct {
+	// scenes whose files were moved into the current layout
+	Migrated int `json:"migrated"`
+	// scenes skipped for still processing, to be migrated by a later run
+	Skipped int `json:"skipped"`
+	// non fatal errors, i.e a scene whose files could not be moved
+	Errors []string `json:"errors"`
+}
+
+// MigrateStorageLayout moves the files of scenes stored in the previous layout into their scene directory, and
+// updates the paths stored in their scene documents. Scenes already in the current layout are left as is, so it is
+// safe to run more than once.
+//
+// Processing scenes are skipped, as workers may still be reading or writing their files. Workers should be idle
+// while migrating, as jobs handed out before the migration reference the previous paths.
+func (sm *SceneManager) MigrateStorageLayout(ctx context.Context) (*StorageMigrationReport, error) {
+	return sm.maintenance().migrateStorageLayout(ctx)
+}
+
+// migrateStorageLayout is MigrateStorageLayout.
+func (m maintenance) migrateStorageLayout(ctx context.Context) (*StorageMigrationReport, error) {
+	report := &StorageMigrationReport{Errors: make([]string, 0)}
+
+	projection := bson.M{"_id": 1, "video": 1, "sfm": 1, "nerf": 1, "status": 1}
+	err := m.docs.eachScene(ctx, projection, func(sc *Scene, err error) {
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			return
+		}
+		if sc.Status != nil && !sc.Status.State.IsTerminal() {
+			report.Skipped++
+			return
+		}
+
+		migrated, err := m.migrateScene(ctx, sc)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("scene %s: %v", sc.ID.Hex(), err))
+			return
+		}
+		if migrated {
+			report.Migrated++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.Infof("Storage layout migration moved %d scenes, skipped %d, with %d errors",
+		report.Migrated, report.Skipped, len(report.Errors))
+	return report, nil
+}
+
+// migrateScene moves the files of a single scene into its scene directory and updates its document.
+// If the document cannot be updated, the files are moved back. Reports whether anything was moved.
+func (m maintenance) migrateScene(ctx context.Context, sc *Scene) (bool, error) {
+	jobID := m.JobID(sc.ID)
+	update := bson.M{}
+
+	type move struct{ from, to string }
+	moves := make([]move, 0, 3)
+
+	// A video that is already missing is left to Reconcile
+	if sc.Video != nil && sc.Video.FilePath != "" && !strings.HasPrefix(sc.Video.FilePath, m.SceneDir(sc.ID)+string(filepath.Separator)) {
+		if _, err := os.Stat(sc.Video.FilePath); err == nil {
+			moves = append(moves, move{sc.Video.FilePath, m.RawVideoPath(sc.ID)})
+			update["video.file_path"] = m.RawVideoPath(sc.ID)
+		}
+	}
+
+	oldSfmDir := filepath.Join(legacySfmDir, jobID)
+	if _, err := os.Stat(oldSfmDir); err == nil {
+		moves = append(moves, move{oldSfmDir, m.SfmDir(sc.ID)})
+		if sc.Sfm != nil {
+			// Frames are stored as worker-data URLs, whose path ends with the local path of the frame
+			oldPrefix := filepath.ToSlash(oldSfmDir) + "/"
+			newPrefix := filepath.ToSlash(m.SfmDir(sc.ID)) + "/"
+			for i, frame := range sc.Sfm.Frames {
+				sc.Sfm.Frames[i].FilePath = strings.Replace(frame.FilePath, oldPrefix, newPrefix, 1)
+			}
+			update["sfm.frames"] = sc.Sfm.Frames
+		}
+	}
+
+	oldNerfDir := filepath.Join(legacyNerfDir, jobID)
+	if _, err := os.Stat(oldNerfDir); err == nil {
+		moves = append(moves, move{oldNerfDir, m.OutputsDir(sc.ID)})
+		if sc.Nerf != nil {
+			for field, filePaths := range map[string]map[int]string{
+				"nerf.model_file_paths":       sc.Nerf.ModelFilePathsMap,
+				"nerf.splat_cloud_file_paths": sc.Nerf.SplatCloudFilePathsMap,
+				"nerf.point_cloud_file_paths": sc.Nerf.PointCloudFilePathsMap,
+				"nerf.video_file_paths":       sc.Nerf.VideoFilePathsMap,
+				"nerf.checkpoint_file_paths":  sc.Nerf.CheckpointFilePathsMap,
+			} {
+				if len(filePaths) == 0 {
+					continue
+				}
+				for iteration, path := range filePaths {
+					if rel, err := filepath.Rel(oldNerfDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+						filePaths[iteration] = filepath.Join(m.OutputsDir(sc.ID), rel)
+					}
+				}
+				update[field] = filePaths
+			}
+		}
+	}
+
+	if len(moves) == 0 {
+		return false, nil
+	}
+
+	done := make([]move, 0, len(moves))
+	undo := func() {
+		for _, mv := range done {
+			if err := os.Rename(mv.to, mv.from); err != nil {
+				m.logger.Errorf("Failed to move %s back to %s: %v", mv.to, mv.from, err)
+			}
+		}
+	}
+	for _, mv := range moves {
+		if _, err := os.Stat(mv.to); err == nil {
+			undo()
+			return false, fmt.Errorf("%s already exists", mv.to)
+		}
+		if err := os.MkdirAll(filepath.Dir(mv.to), os.ModePerm); err != nil {
+			undo()
+			return false, err
+		}
+		if err := os.Rename(mv.from, mv.to); err != nil {
+			undo()
+			return false, err
+		}
+		done = append(done, mv)
+	}
+
+	if len(update) == 0 {
+		return true, nil
+	}
+	if err := m.docs.setMigratedPaths(ctx, sc, update); err != nil {
+		undo()
+		return false, err
+	}
+	return true, nil
+}
+
+// setMigratedPaths stores the paths migrateScene moved the files of sc to, see sceneDocuments.
+func (sm *SceneManager) setMigratedPaths(ctx context.Context, sc *Scene, update bson.M) error {
+	_, err := sm.collection.UpdateOne(ctx, bson.M{"_id": sc.ID}, bson.M{"$set": update})
+	return err
+}
+
+// LegacyStoragePaths returns the directories a scene's files were stored in by the previous layout. Scenes that were
+// processing during MigrateStorageLayout may still have files there until the next migration. The paths may not exist.
+func (l layout) LegacyStoragePaths(id primitive.ObjectID) (sfmDir, nerfDir string) {
+	jobID := l.JobID(id)
+	return filepath.Join(legacySfmDir, jobID), filepath.Join(legacyNerfDir, jobID)
+}
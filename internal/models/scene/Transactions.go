@@ -0,0 +1,60 @@
+// This file contains multi-document transactions, for writes that must not be left half done.
+//
+// A transaction spans every collection of the database, so writes of other managers (i.e the UserManager) made with
+// the transaction's context commit or roll back with the scene writes. Transactions need MongoDB to run as a replica
+// set or sharded cluster. On a standalone server, which cannot run them, the writes are made one after another instead.
+
+package scene
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// WithTransaction runs fn in a transaction, committing its writes if it returns nil and rolling them back otherwise.
+// Every write in fn must use the context passed to it to be part of the transaction.
+//
+// fn may be run more than once if the transaction hits a transient error, so it must not have side effects outside
+// of the database. Work that must only happen once the writes are committed, i.e publishing a job, belongs after
+// WithTransaction returns.
+func (sm *SceneManager) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !sm.transactionsSupported(ctx) {
+		return fn(ctx)
+	}
+
+	return sm.client.UseSession(ctx, func(sessionCtx mongo.SessionContext) error {
+		_, err := sessionCtx.WithTransaction(sessionCtx, func(txCtx mongo.SessionContext) (interface{}, error) {
+			return nil, fn(txCtx)
+		})
+		return err
+	})
+}
+
+// transactionsSupported reports whether the deployment can run transactions, which is checked once with the hello
+// command. A failed check is retried on the next call.
+func (sm *SceneManager) transactionsSupported(ctx context.Context) bool {
+	sm.transactionsMu.Lock()
+	defer sm.transactionsMu.Unlock()
+	if sm.transactionsChecked {
+		return sm.transactions
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := sm.client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		sm.logger.Errorf("Failed to check for transaction support: %v", err)
+		return false
+	}
+
+	sm.transactionsChecked = true
+	sm.transactions = hello.SetName != "" || hello.Msg == "isdbgrid"
+	if !sm.transactions {
+		sm.logger.Warn("MongoDB is a standalone server, multi-document writes are not transactional")
+	}
+	return sm.transactions
+}
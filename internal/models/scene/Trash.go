@@ -0,0 +1,107 @@
+// This file contains the SceneManager methods of the trash. A deleted scene is first moved to the trash, where it is
+// hidden from listings and its resources cannot be accessed, but its documents and files are kept so it can be
+// restored. The services permanently delete scenes from the trash, see services.ClientService.DeleteScene.
+
+package scene
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrSceneInTrash is returned when accessing or trashing a scene that is in the trash.
+	ErrSceneInTrash = errors.New("scene is in the trash")
+	// ErrSceneNotInTrash is returned when restoring a scene that is not in the trash.
+	ErrSceneNotInTrash = errors.New("scene is not in the trash")
+)
+
+// TrashFilter selects scenes by whether they are in the trash, see SceneListFilter.Trash.
+type TrashFilter string
+
+// Trash filters
+const (
+	TrashExcluded TrashFilter = ""
+	TrashOnly     TrashFilter = "only"
+	TrashIncluded TrashFilter = "included"
+)
+
+// TrashScene moves a scene to the trash at the given time.
+//
+// Returns ErrSceneInTrash if the scene is already in the trash, or ErrSceneNotFound if it does not exist.
+func (sm *SceneManager) TrashScene(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	result, err := sm.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "trashed_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"trashed_at": at}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := sm.GetTrashedAt(ctx, id); err != nil {
+			return err
+		}
+		return ErrSceneInTrash
+	}
+	return nil
+}
+
+// RestoreScene takes a scene out of the trash.
+//
+// Returns ErrSceneNotInTrash if the scene is not in the trash, or ErrSceneNotFound if it does not exist.
+func (sm *SceneManager) RestoreScene(ctx context.Context, id primitive.ObjectID) error {
+	result, err := sm.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "trashed_at": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"trashed_at": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := sm.GetTrashedAt(ctx, id); err != nil {
+			return err
+		}
+		return ErrSceneNotInTrash
+	}
+	return nil
+}
+
+// GetTrashedAt retrieves when a scene was moved to the trash, nil if it is not in the trash.
+//
+// Returns ErrSceneNotFound if the scene does not exist.
+func (sm *SceneManager) GetTrashedAt(ctx context.Context, id primitive.ObjectID) (*time.Time, error) {
+	var result struct {
+		TrashedAt *time.Time `bson:"trashed_at"`
+	}
+	err := sm.collection.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"trashed_at": 1})).Decode(&result)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSceneNotFound
+		}
+		return nil, err
+	}
+	return result.TrashedAt, nil
+}
+
+// GetScenesTrashedBefore retrieves the scenes moved to the trash before the given time, oldest first.
+func (sm *SceneManager) GetScenesTrashedBefore(ctx context.Context, before time.Time) ([]*Scene, error) {
+	cursor, err := sm.collection.Find(ctx, bson.M{
+		"trashed_at": bson.M{"$lt": before},
+	}, options.Find().SetProjection(bson.M{"sfm": 0}).SetSort(bson.D{{Key: "trashed_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	scenes := make([]*Scene, 0)
+	if err := cursor.All(ctx, &scenes); err != nil {
+		return nil, err
+	}
+	return scenes, nil
+}
@@ -0,0 +1,102 @@
+// This file contains the training runs of a scene. Retraining a completed scene starts a new run, numbered one above
+// the previous one, and keeps the config, status and nerf output of the previous run in Scene.Runs so its outputs
+// remain available. The first run is version 1, and scenes never retrained have no version stored.
+//
+// Each run saves its nerf output in its own directory, see RunOutputsDir, so runs never overwrite each other's files.
+
+package scene
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrNoRunToVersion is returned when starting a new run of a scene that has no completed run to keep.
+	ErrNoRunToVersion = errors.New("scene has no completed training run to keep")
+	// ErrRunNotFound is returned when requesting a training run version a scene does not have.
+	ErrRunNotFound = errors.New("training run not found")
+)
+
+// TrainingRun is a training run of a scene. Previous runs are kept in Scene.Runs, see Scene.Run.
+type TrainingRun struct {
+	Version int             `bson:"version" json:"version"`
+	Config  *TrainingConfig `bson:"config,omitempty" json:"config,omitempty"`
+	Status  *SceneStatus    `bson:"status,omitempty" json:"status,omitempty"`
+	Nerf    *Nerf           `bson:"nerf,omitempty" json:"nerf,omitempty"`
+}
+
+// CurrentVersion returns the version of the scene's current training run.
+func (sc *Scene) CurrentVersion() int {
+	if sc.Version < 1 {
+		return 1
+	}
+	return sc.Version
+}
+
+// Run returns the training run of the scene with the given version, the current run included.
+// Returns ErrRunNotFound if the scene has no such run.
+func (sc *Scene) Run(version int) (*TrainingRun, error) {
+	if version == sc.CurrentVersion() {
+		return &TrainingRun{Version: version, Config: sc.Config, Status: sc.Status, Nerf: sc.Nerf}, nil
+	}
+	for i := range sc.Runs {
+		if sc.Runs[i].Version == version {
+			return &sc.Runs[i], nil
+		}
+	}
+	return nil, ErrRunNotFound
+}
+
+// RestartSceneVersion starts processing a completed scene again as a new training run, from the given state, with a
+// new training config. The config, status and nerf output of the current run are kept in Scene.Runs, the rest is as
+// with RestartScene.
+//
+// The current version is checked in the update filter, so concurrent restarts cannot both keep the same run.
+// Returns the version of the new run, ErrNoRunToVersion if the scene did not complete with nerf output, or
+// ErrInvalidStatusTransition if it changed meanwhile.
+func (sm *SceneManager) RestartSceneVersion(ctx context.Context, id primitive.ObjectID, config *TrainingConfig, state State) (int, error) {
+	if state != StateQueued && state != StateSfmDone {
+		return 0, ErrInvalidState
+	}
+
+	current, err := sm.GetScene(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if current.Status == nil || current.Status.State != StateCompleted || current.Nerf == nil {
+		return 0, ErrNoRunToVersion
+	}
+	run := TrainingRun{Version: current.CurrentVersion(), Config: current.Config, Status: current.Status, Nerf: current.Nerf}
+
+	filter := bson.M{"_id": id, "status.state": StateCompleted, "version": current.Version}
+	if current.Version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{nil, 0}}
+	}
+	now := time.Now()
+	unset := bson.M{"nerf": ""}
+	if state == StateQueued {
+		unset["sfm"] = ""
+	}
+	result, err := sm.collection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"version": run.Version + 1,
+			"config":  config,
+			"status":  &SceneStatus{State: state, UpdatedAt: now, EnteredAt: map[State]time.Time{state: now}},
+		},
+		"$push":  bson.M{"runs": run},
+		"$unset": unset,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if result.MatchedCount == 0 {
+		sm.logger.Warnf("Rejected new training run of scene %s: scene changed meanwhile", id.Hex())
+		return 0, ErrInvalidStatusTransition
+	}
+	return run.Version + 1, nil
+}
@@ -0,0 +1,200 @@
+// This file contains the DocumentRepository, the TokenRepository keeping refresh tokens in a docstore.Store, used by
+// tests and to run the server without MongoDB. Every method of the TokenManager is implemented in Go on top of it,
+// with the same behavior and errors.
+//
+// Token hashes are a unique key of the store, in place of the unique index of TokenManager. Each token is consumed or
+// revoked with a single Update, so only one of concurrent calls consuming the same token succeeds.
+
+package token
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/docstore"
+)
+
+// Kinds of keys tokens are looked up by, see tokenSchema
+const (
+	keyTokenHash = "token_hash"
+	keyFamily    = "family"
+	keyUser      = "user"
+)
+
+// errTokenUsed stops an Update of a token that is already used, so nothing is stored.
+var errTokenUsed = errors.New("token used")
+
+// tokenSchema describes refresh token documents to a docstore.Store.
+var tokenSchema = docstore.Schema[RefreshToken]{
+	Name: "refresh_tokens",
+	Kinds: map[string]bool{
+		keyTokenHash: true,
+		keyFamily:    false,
+		keyUser:      false,
+	},
+	ID:  func(t *RefreshToken) primitive.ObjectID { return t.ID },
+	New: func(id primitive.ObjectID) *RefreshToken { return &RefreshToken{ID: id} },
+	Keys: func(t *RefreshToken) []docstore.Key {
+		return []docstore.Key{
+			{Kind: keyTokenHash, Value: t.TokenHash},
+			{Kind: keyFamily, Value: t.FamilyID.Hex()},
+			{Kind: keyUser, Value: t.UserID.Hex()},
+		}
+	},
+}
+
+// DocumentRepository is the TokenRepository storing refresh tokens in a docstore.Store.
+type DocumentRepository struct {
+	store  docstore.Store[RefreshToken]
+	logger *log.Logger
+}
+
+// DocumentRepository is a TokenRepository.
+var _ TokenRepository = (*DocumentRepository)(nil)
+
+// NewMemoryRepository creates a DocumentRepository keeping refresh tokens in db.
+func NewMemoryRepository(db *docstore.MemoryDB, logger *log.Logger) *DocumentRepository {
+	return &DocumentRepository{store: docstore.NewMemory(db, tokenSchema), logger: logger}
+}
+
+// EnsureIndexes prepares the storage of tokens. Expired tokens are not removed, as they are never accepted.
+func (r *DocumentRepository) EnsureIndexes(ctx context.Context) error {
+	return r.store.Init(ctx)
+}
+
+// CreateToken inserts a new refresh token.
+func (r *DocumentRepository) CreateToken(ctx context.Context, token *RefreshToken) error {
+	return r.store.Insert(ctx, token)
+}
+
+// ConsumeToken marks the refresh token with the given hash as used, see TokenManager.ConsumeToken.
+func (r *DocumentRepository) ConsumeToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	tokens, err := r.store.Find(ctx, keyTokenHash, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, ErrTokenNotFound
+	}
+
+	var before RefreshToken
+	err = r.store.Update(ctx, tokens[0].ID, func(token *RefreshToken) error {
+		before = *token
+		if token.UsedAt != nil {
+			return errTokenUsed
+		}
+		now := time.Now()
+		token.UsedAt = &now
+		return nil
+	})
+	if errors.Is(err, errTokenUsed) {
+		return &before, ErrTokenAlreadyUsed
+	}
+	if errors.Is(err, docstore.ErrNotFound) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &before, nil
+}
+
+// ListActiveTokens returns the unused and unexpired tokens of a user, most recently issued first.
+func (r *DocumentRepository) ListActiveTokens(ctx context.Context, userID primitive.ObjectID) ([]*RefreshToken, error) {
+	tokens, err := r.store.Find(ctx, keyUser, userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	active := make([]*RefreshToken, 0, len(tokens))
+	for _, token := range tokens {
+		if token.UsedAt == nil && token.ExpiresAt.After(now) {
+			active = append(active, token)
+		}
+	}
+	slices.SortStableFunc(active, func(a, b *RefreshToken) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return active, nil
+}
+
+// FamilyActive returns whether a family has an unused and unexpired token.
+func (r *DocumentRepository) FamilyActive(ctx context.Context, familyID primitive.ObjectID) (bool, error) {
+	tokens, err := r.store.Find(ctx, keyFamily, familyID.Hex())
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	return slices.ContainsFunc(tokens, func(token *RefreshToken) bool {
+		return token.UsedAt == nil && token.ExpiresAt.After(now)
+	}), nil
+}
+
+// revoke marks every unused token with a key of the given kind and value for which keep returns false as used, and
+// returns how many were revoked.
+func (r *DocumentRepository) revoke(ctx context.Context, kind, value string, keep func(token *RefreshToken) bool) (int64, error) {
+	tokens, err := r.store.Find(ctx, kind, value)
+	if err != nil {
+		return 0, err
+	}
+	var revoked int64
+	for _, token := range tokens {
+		if token.UsedAt != nil || keep(token) {
+			continue
+		}
+		err := r.store.Update(ctx, token.ID, func(token *RefreshToken) error {
+			if token.UsedAt != nil {
+				return errTokenUsed
+			}
+			now := time.Now()
+			token.UsedAt = &now
+			return nil
+		})
+		if errors.Is(err, errTokenUsed) || errors.Is(err, docstore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// RevokeFamily marks every unused token of a family as used, and returns how many were revoked.
+func (r *DocumentRepository) RevokeFamily(ctx context.Context, familyID primitive.ObjectID) (int64, error) {
+	return r.revoke(ctx, keyFamily, familyID.Hex(), func(*RefreshToken) bool { return false })
+}
+
+// RevokeUserFamily marks every unused token of a family of the given user as used, and returns how many were revoked.
+func (r *DocumentRepository) RevokeUserFamily(ctx context.Context, userID, familyID primitive.ObjectID) (int64, error) {
+	return r.revoke(ctx, keyFamily, familyID.Hex(), func(token *RefreshToken) bool { return token.UserID != userID })
+}
+
+// RevokeUserExcept marks every unused token of a user as used except those of the given family, and returns how many
+// were revoked.
+func (r *DocumentRepository) RevokeUserExcept(ctx context.Context, userID, familyID primitive.ObjectID) (int64, error) {
+	return r.revoke(ctx, keyUser, userID.Hex(), func(token *RefreshToken) bool { return token.FamilyID == familyID })
+}
+
+// RevokeUser marks every unused token of a user as used, and returns how many were revoked.
+func (r *DocumentRepository) RevokeUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return r.revoke(ctx, keyUser, userID.Hex(), func(*RefreshToken) bool { return false })
+}
+
+// DeleteUserTokens removes every token of a user, used or not.
+func (r *DocumentRepository) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	tokens, err := r.store.Find(ctx, keyUser, userID.Hex())
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := r.store.Delete(ctx, token.ID); err != nil && !errors.Is(err, docstore.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
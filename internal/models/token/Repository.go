@@ -0,0 +1,33 @@
+// This file contains the TokenRepository interface, which services depend on instead of the MongoDB TokenManager.
+//
+// TokenManager documents the behavior and errors each method must have.
+
+package token
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TokenRepository stores refresh tokens. Implementations must be safe for concurrent use, and return the errors of
+// this package (i.e ErrTokenAlreadyUsed) as TokenManager does.
+type TokenRepository interface {
+	// EnsureIndexes prepares the storage of tokens, and is called once at startup.
+	EnsureIndexes(ctx context.Context) error
+
+	CreateToken(ctx context.Context, token *RefreshToken) error
+	ConsumeToken(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	ListActiveTokens(ctx context.Context, userID primitive.ObjectID) ([]*RefreshToken, error)
+	FamilyActive(ctx context.Context, familyID primitive.ObjectID) (bool, error)
+
+	// Revocation
+	RevokeFamily(ctx context.Context, familyID primitive.ObjectID) (int64, error)
+	RevokeUserFamily(ctx context.Context, userID, familyID primitive.ObjectID) (int64, error)
+	RevokeUserExcept(ctx context.Context, userID, familyID primitive.ObjectID) (int64, error)
+	RevokeUser(ctx context.Context, userID primitive.ObjectID) (int64, error)
+	DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// TokenManager is a TokenRepository.
+var _ TokenRepository = (*TokenManager)(nil)
@@ -0,0 +1,197 @@
+// This file contains the TokenManager implementation, which is responsible for interacting with the MongoDB refresh_tokens collection.
+// The TokenManager struct contains a pointer to the nerfdb.refresh_tokens MongoDB collection and a logger. It provides methods to
+// create refresh tokens, consume them exactly once, list the sessions of a user, and revoke them by family or by user.
+// Expired tokens are removed by a TTL index.
+
+package token
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+var (
+	// ErrTokenNotFound is returned when no refresh token has the given hash.
+	ErrTokenNotFound = errors.New("refresh token not found")
+	// ErrTokenAlreadyUsed is returned when consuming a refresh token that was already consumed or revoked.
+	ErrTokenAlreadyUsed = errors.New("refresh token already used")
+)
+
+// RefreshToken is a single use token exchanged for a new access token and a new refresh token of the same family.
+type RefreshToken struct {
+	ID     primitive.ObjectID `bson:"_id"`
+	UserID primitive.ObjectID `bson:"user_id"`
+	// ID shared by every token rotated from the same login
+	FamilyID  primitive.ObjectID `bson:"family_id"`
+	TokenHash string             `bson:"token_hash"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	// when the token was exchanged or revoked, nil while it can still be used
+	UsedAt *time.Time `bson:"used_at,omitempty"`
+	// when the login of the family was made, carried over on rotation. Zero for tokens issued before it was recorded
+	LoginAt time.Time `bson:"login_at,omitempty"`
+	// client the token was issued to
+	UserAgent string `bson:"user_agent,omitempty"`
+	IP        string `bson:"ip,omitempty"`
+}
+
+type TokenManager struct {
+	collection *mongo.Collection
+	logger     *log.Logger
+}
+
+// NewTokenManager creates a new TokenManager with the given MongoDB client and logger.
+func NewTokenManager(client *mongo.Client, logger *log.Logger, unittest bool) *TokenManager {
+	return &TokenManager{
+		collection: client.Database("nerfdb").Collection("refresh_tokens"),
+		logger:     logger,
+	}
+}
+
+// EnsureIndexes creates the unique index tokens are looked up by, the indexes used to revoke tokens, and the TTL index
+// removing expired tokens. Existing indexes are kept.
+func (tm *TokenManager) EnsureIndexes(ctx context.Context) error {
+	_, err := tm.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "family_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// CreateToken inserts a new refresh token.
+func (tm *TokenManager) CreateToken(ctx context.Context, token *RefreshToken) error {
+	_, err := tm.collection.InsertOne(ctx, token)
+	return err
+}
+
+// ConsumeToken marks the refresh token with the given hash as used, and returns it as it was before. Only one of
+// concurrent calls for the same token succeeds.
+//
+// Returns ErrTokenAlreadyUsed, along with the token, if it was already consumed or revoked, or ErrTokenNotFound if no
+// token has the given hash. Expiry is not checked.
+func (tm *TokenManager) ConsumeToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	err := tm.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"token_hash": tokenHash, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&token)
+	if err == nil {
+		return &token, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	err = tm.collection.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, ErrTokenAlreadyUsed
+}
+
+// RevokeFamily marks every unused token of a family as used, and returns how many were revoked.
+func (tm *TokenManager) RevokeFamily(ctx context.Context, familyID primitive.ObjectID) (int64, error) {
+	result, err := tm.collection.UpdateMany(ctx,
+		bson.M{"family_id": familyID, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// ListActiveTokens returns the unused and unexpired tokens of a user, most recently issued first. As tokens rotate,
+// each is the current token of a different family, i.e a session.
+func (tm *TokenManager) ListActiveTokens(ctx context.Context, userID primitive.ObjectID) ([]*RefreshToken, error) {
+	cursor, err := tm.collection.Find(ctx,
+		bson.M{"user_id": userID, "used_at": nil, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]*RefreshToken, 0)
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// FamilyActive returns whether a family has an unused and unexpired token, i.e its login was neither logged out nor
+// revoked, and has not expired.
+func (tm *TokenManager) FamilyActive(ctx context.Context, familyID primitive.ObjectID) (bool, error) {
+	count, err := tm.collection.CountDocuments(ctx,
+		bson.M{"family_id": familyID, "used_at": nil, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Count().SetLimit(1),
+	)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RevokeUserFamily marks every unused token of a family of the given user as used, and returns how many were revoked.
+// Unlike RevokeFamily, the tokens of other users are never revoked.
+func (tm *TokenManager) RevokeUserFamily(ctx context.Context, userID, familyID primitive.ObjectID) (int64, error) {
+	result, err := tm.collection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "family_id": familyID, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// RevokeUserExcept marks every unused token of a user as used except those of the given family, i.e to log out every
+// other session, and returns how many were revoked.
+func (tm *TokenManager) RevokeUserExcept(ctx context.Context, userID, familyID primitive.ObjectID) (int64, error) {
+	result, err := tm.collection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "family_id": bson.M{"$ne": familyID}, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// RevokeUser marks every unused token of a user as used, i.e after a password change, and returns how many were revoked.
+func (tm *TokenManager) RevokeUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	result, err := tm.collection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// DeleteUserTokens removes every token of a user, used or not, i.e when the user is deleted.
+func (tm *TokenManager) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := tm.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
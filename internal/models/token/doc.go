@@ -0,0 +1,7 @@
+// Package token contains the implementation of refresh tokens in the MongoDB database.
+// The TokenManager struct is responsible for interacting with the MongoDB refresh_tokens collection.
+// The DocumentRepository keeps refresh tokens in a docstore.Store instead, i.e in memory for tests.
+// The RefreshToken struct is used to represent a single use token that is exchanged for a new access token.
+// Only the SHA-256 of a token is stored. Tokens rotated from the same login share a family, so a reused token can
+// revoke every token descended from that login.
+package token
@@ -0,0 +1,137 @@
+// This file contains the UploadManager implementation, which is responsible for interacting with the MongoDB uploads collection.
+// The UploadManager struct contains a pointer to the nerfdb.uploads MongoDB collection and a logger. It provides methods to
+// create, read, extend, and delete upload sessions, and to find sessions that expired.
+
+package upload
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+var (
+	// ErrUploadNotFound is returned when a requested upload session is not found in the database.
+	ErrUploadNotFound = errors.New("upload not found")
+)
+
+// Session is a resumable video upload. The training config fields are those of a single request upload, and are
+// applied when the upload is completed.
+type Session struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	FileName  string             `bson:"file_name"`
+	Size      int64              `bson:"size"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+
+	TrainingMode     string   `bson:"training_mode,omitempty"`
+	OutputTypes      []string `bson:"output_types,omitempty"`
+	SaveIterations   []int    `bson:"save_iterations,omitempty"`
+	TotalIterations  int      `bson:"total_iterations,omitempty"`
+	FrameSampleRate  int      `bson:"frame_sample_rate,omitempty"`
+	TargetFrameCount int      `bson:"target_frame_count,omitempty"`
+	SceneName        string   `bson:"scene_name,omitempty"`
+	Priority         string   `bson:"priority,omitempty"`
+	// organization whose workspace the scene is uploaded into, if any
+	OrgID primitive.ObjectID `bson:"org_id,omitempty"`
+}
+
+type UploadManager struct {
+	collection *mongo.Collection
+	logger     *log.Logger
+}
+
+// NewUploadManager creates a new UploadManager with the given MongoDB client and logger.
+func NewUploadManager(client *mongo.Client, logger *log.Logger, unittest bool) *UploadManager {
+	return &UploadManager{
+		collection: client.Database("nerfdb").Collection("uploads"),
+		logger:     logger,
+	}
+}
+
+// EnsureIndexes creates the indexes used to find expired sessions and the sessions of a user. Existing indexes are kept.
+func (um *UploadManager) EnsureIndexes(ctx context.Context) error {
+	_, err := um.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	return err
+}
+
+// CreateSession inserts a new upload session.
+func (um *UploadManager) CreateSession(ctx context.Context, session *Session) error {
+	_, err := um.collection.InsertOne(ctx, session)
+	return err
+}
+
+// GetSession retrieves an upload session by ID. Expired sessions are still returned until they are deleted.
+// Returns ErrUploadNotFound if no session has the given ID.
+func (um *UploadManager) GetSession(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	var session Session
+	err := um.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&session)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ExtendSession moves the expiry of an upload session.
+// Returns ErrUploadNotFound if no session has the given ID.
+func (um *UploadManager) ExtendSession(ctx context.Context, id primitive.ObjectID, expiresAt time.Time) error {
+	result, err := um.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"expires_at": expiresAt}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUploadNotFound
+	}
+	return nil
+}
+
+// DeleteSession removes an upload session. Removing its staged file is the responsibility of the caller.
+// Returns ErrUploadNotFound if no session has the given ID.
+func (um *UploadManager) DeleteSession(ctx context.Context, id primitive.ObjectID) error {
+	result, err := um.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrUploadNotFound
+	}
+	return nil
+}
+
+// GetExpiredSessions returns every upload session that expired before the given time.
+func (um *UploadManager) GetExpiredSessions(ctx context.Context, before time.Time) ([]*Session, error) {
+	return um.find(ctx, bson.M{"expires_at": bson.M{"$lt": before}})
+}
+
+// GetUserSessions returns every upload session of the given user, expired or not.
+func (um *UploadManager) GetUserSessions(ctx context.Context, userID primitive.ObjectID) ([]*Session, error) {
+	return um.find(ctx, bson.M{"user_id": userID})
+}
+
+// find returns the upload sessions matching filter.
+func (um *UploadManager) find(ctx context.Context, filter bson.M) ([]*Session, error) {
+	cursor, err := um.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := make([]*Session, 0)
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
@@ -0,0 +1,5 @@
+// Package upload contains the implementation of resumable upload sessions in the MongoDB database.
+// The UploadManager struct is responsible for interacting with the MongoDB uploads collection.
+// The Session struct is used to represent a video upload sent in chunks, and the training config of the scene it creates.
+// How many bytes a session has received is not stored, as the staged file on disk is the only reliable record of it.
+package upload
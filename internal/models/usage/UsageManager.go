@@ -0,0 +1,109 @@
+// This file contains the UsageManager implementation, which is responsible for interacting with the MongoDB usage collection.
+// The UsageManager struct contains a pointer to the nerfdb.usage MongoDB collection and a logger. It provides methods to
+// read the usage of a user on a day, and to count uploads and downloaded bytes towards it.
+// Each user has one document per day, keyed by user and day, so counting is a single atomic upsert.
+
+package usage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+// retention is how long the counters of a day are kept after the day ends.
+const retention = 48 * time.Hour
+
+// dayLayout is the layout days are keyed by.
+const dayLayout = "2006-01-02"
+
+// Usage is what a user used on one day.
+type Usage struct {
+	ID            string             `bson:"_id"`
+	UserID        primitive.ObjectID `bson:"user_id"`
+	Day           string             `bson:"day"`
+	Uploads       int64              `bson:"uploads"`
+	DownloadBytes int64              `bson:"download_bytes"`
+	// when the document is removed, retention after the day ends
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Day returns the UTC day t falls on, and when that day ends.
+func Day(t time.Time) (string, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return start.Format(dayLayout), start.AddDate(0, 0, 1)
+}
+
+type UsageManager struct {
+	collection *mongo.Collection
+	logger     *log.Logger
+}
+
+// NewUsageManager creates a new UsageManager with the given MongoDB client and logger.
+func NewUsageManager(client *mongo.Client, logger *log.Logger, unittest bool) *UsageManager {
+	return &UsageManager{
+		collection: client.Database("nerfdb").Collection("usage"),
+		logger:     logger,
+	}
+}
+
+// EnsureIndexes creates the TTL index removing the counters of past days. Existing indexes are kept.
+func (um *UsageManager) EnsureIndexes(ctx context.Context) error {
+	_, err := um.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// usageID returns the ID of the usage document of a user on a day.
+func usageID(userID primitive.ObjectID, day string) string {
+	return userID.Hex() + ":" + day
+}
+
+// GetUsage returns the usage of a user on the day now falls on. A user that used nothing that day has zero usage.
+func (um *UsageManager) GetUsage(ctx context.Context, userID primitive.ObjectID, now time.Time) (*Usage, error) {
+	day, end := Day(now)
+	var usage Usage
+	err := um.collection.FindOne(ctx, bson.M{"_id": usageID(userID, day)}).Decode(&usage)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &Usage{ID: usageID(userID, day), UserID: userID, Day: day, ExpiresAt: end.Add(retention)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// AddUploads counts uploads of a user on the day now falls on.
+func (um *UsageManager) AddUploads(ctx context.Context, userID primitive.ObjectID, now time.Time, uploads int64) error {
+	return um.add(ctx, userID, now, "uploads", uploads)
+}
+
+// AddDownloadBytes counts bytes downloaded by a user on the day now falls on.
+func (um *UsageManager) AddDownloadBytes(ctx context.Context, userID primitive.ObjectID, now time.Time, bytes int64) error {
+	return um.add(ctx, userID, now, "download_bytes", bytes)
+}
+
+// add increments a counter of a user on the day now falls on, creating the day's document if needed.
+func (um *UsageManager) add(ctx context.Context, userID primitive.ObjectID, now time.Time, field string, delta int64) error {
+	day, end := Day(now)
+	_, err := um.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": usageID(userID, day)},
+		bson.M{
+			"$inc":         bson.M{field: delta},
+			"$setOnInsert": bson.M{"user_id": userID, "day": day, "expires_at": end.Add(retention)},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
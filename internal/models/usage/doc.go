@@ -0,0 +1,5 @@
+// Package usage contains the implementation of the daily usage counters of users in the MongoDB database.
+// The UsageManager struct is responsible for interacting with the MongoDB usage collection.
+// The Usage struct holds what a user used on one day (UTC), i.e how many uploads they made, and is counted against
+// the daily quotas of the user. Counters of past days are removed by a TTL index.
+package usage
@@ -0,0 +1,140 @@
+// This file contains the API keys users authenticate scripts with, in place of logging in.
+// Keys are stored in the user document they belong to. Only the SHA-256 of a key is stored, along with a short prefix
+// of it so users can tell their keys apart. Each key is limited to the scopes it was created with.
+
+package user
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrInvalidAPIKey is returned when an API key matches no user.
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	// ErrAPIKeyNotFound is returned when a user has no API key with the requested ID.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrAPIKeyScope is returned when an API key is used for a request outside its scopes.
+	ErrAPIKeyScope = errors.New("API key does not have the required scope")
+)
+
+// Declarations for valid API key scopes
+const (
+	// upload scenes and submit their jobs
+	APIKeyScopeUpload = "upload"
+	// read scenes and their outputs
+	APIKeyScopeRead = "read"
+	// everything the user may do, including admin operations if the user is an admin
+	APIKeyScopeAdmin = "admin"
+)
+
+// ValidAPIKeyScopes lists the scopes an API key may have.
+var ValidAPIKeyScopes = []string{APIKeyScopeUpload, APIKeyScopeRead, APIKeyScopeAdmin}
+
+// IsValidAPIKeyScope checks if scope is one of ValidAPIKeyScopes.
+func IsValidAPIKeyScope(scope string) bool {
+	return slices.Contains(ValidAPIKeyScopes, scope)
+}
+
+// APIKey is an API key of a user. Hash is the SHA-256 of the key, which is never stored.
+// LastUsedAt is zero until the key is first used.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	Hash       string             `bson:"hash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt time.Time          `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+}
+
+// HasScope checks if the key may be used for requests of the given scope. Admin keys may be used for every scope.
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, APIKeyScopeAdmin)
+}
+
+// ensureAPIKeyIndex creates the unique index API keys are looked up by.
+func (um *UserManager) ensureAPIKeyIndex(ctx context.Context) error {
+	_, err := um.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "api_keys.hash", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"api_keys.hash": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+// AddAPIKey adds an API key to the user with the given ID.
+// Returns ErrUserNotFound if the user does not exist.
+func (um *UserManager) AddAPIKey(ctx context.Context, userID primitive.ObjectID, key *APIKey) error {
+	result, err := um.collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$push": bson.M{"api_keys": key}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// GetAPIKeys returns the API keys of the user with the given ID.
+// Returns ErrUserNotFound if the user does not exist.
+func (um *UserManager) GetAPIKeys(ctx context.Context, userID primitive.ObjectID) ([]APIKey, error) {
+	user, err := um.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.APIKeys == nil {
+		return []APIKey{}, nil
+	}
+	return user.APIKeys, nil
+}
+
+// RevokeAPIKey removes an API key from the user with the given ID. The key stops working immediately.
+// Returns ErrAPIKeyNotFound if the user has no key with the given ID.
+func (um *UserManager) RevokeAPIKey(ctx context.Context, userID, keyID primitive.ObjectID) error {
+	result, err := um.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "api_keys._id": keyID},
+		bson.M{"$pull": bson.M{"api_keys": bson.M{"_id": keyID}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the user holding the API key whose SHA-256 is keyHash, and the key, recording that the
+// key was used.
+//
+// Returns ErrInvalidAPIKey if the hash matches no key.
+func (um *UserManager) AuthenticateAPIKey(ctx context.Context, keyHash string) (*User, *APIKey, error) {
+	var user User
+	err := um.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"api_keys.hash": keyHash},
+		bson.M{"$set": bson.M{"api_keys.$.last_used_at": time.Now()}},
+	).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	i := slices.IndexFunc(user.APIKeys, func(k APIKey) bool { return k.Hash == keyHash })
+	if i < 0 {
+		// Revoked between the lookup and the update
+		return nil, nil, ErrInvalidAPIKey
+	}
+	return &user, &user.APIKeys[i], nil
+}
@@ -0,0 +1,108 @@
+// This file contains the UserManager methods used by admins to manage accounts: listing users, disabling accounts,
+// overriding the quotas of a user, and setting their job tier.
+
+package user
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+)
+
+var (
+	// ErrAccountDisabled is returned when a disabled account logs in or uses a session or API key.
+	ErrAccountDisabled = errors.New("account is disabled")
+)
+
+// QuotaOverrides replace the server's quotas for one user. A nil field keeps the server's limit, and a limit <= 0
+// does not limit the user.
+type QuotaOverrides struct {
+	MaxConcurrentJobs      *int64 `bson:"max_concurrent_jobs,omitempty" json:"max_concurrent_jobs,omitempty"`
+	MaxStorageBytes        *int64 `bson:"max_storage_bytes,omitempty" json:"max_storage_bytes,omitempty"`
+	MaxUploadsPerDay       *int64 `bson:"max_uploads_per_day,omitempty" json:"max_uploads_per_day,omitempty"`
+	MaxDownloadBytesPerDay *int64 `bson:"max_download_bytes_per_day,omitempty" json:"max_download_bytes_per_day,omitempty"`
+}
+
+// IsEmpty checks if no quota is overridden
+func (q *QuotaOverrides) IsEmpty() bool {
+	return q == nil || (q.MaxConcurrentJobs == nil && q.MaxStorageBytes == nil && q.MaxUploadsPerDay == nil &&
+		q.MaxDownloadBytesPerDay == nil)
+}
+
+// ListUsers returns up to limit users after skipping skip, newest first, and the number of users in total.
+func (um *UserManager) ListUsers(ctx context.Context, skip, limit int64) ([]*User, int64, error) {
+	total, err := um.collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Object IDs start with their creation time, so sorting by ID sorts by creation time
+	cursor, err := um.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": -1}).SetSkip(skip).SetLimit(limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	users := make([]*User, 0)
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// SetDisabled disables or re-enables the account of a user.
+// Returns ErrUserNotFound if the user does not exist.
+func (um *UserManager) SetDisabled(ctx context.Context, userID primitive.ObjectID, disabled bool) error {
+	update := bson.M{"$set": bson.M{"disabled": true}}
+	if !disabled {
+		update = bson.M{"$unset": bson.M{"disabled": ""}}
+	}
+	result, err := um.collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetQuotaOverrides replaces the quota overrides of a user. Empty overrides are removed, so the user falls back to the
+// server's quotas.
+// Returns ErrUserNotFound if the user does not exist.
+func (um *UserManager) SetQuotaOverrides(ctx context.Context, userID primitive.ObjectID, overrides *QuotaOverrides) error {
+	update := bson.M{"$set": bson.M{"quota_overrides": overrides}}
+	if overrides.IsEmpty() {
+		update = bson.M{"$unset": bson.M{"quota_overrides": ""}}
+	}
+	result, err := um.collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetTier sets the job tier of a user. The standard tier is removed, as users without a tier are standard.
+// Returns ErrUserNotFound if the user does not exist.
+func (um *UserManager) SetTier(ctx context.Context, userID primitive.ObjectID, tier string) error {
+	update := bson.M{"$set": bson.M{"tier": tier}}
+	if tier == broker.TierStandard {
+		update = bson.M{"$unset": bson.M{"tier": ""}}
+	}
+	result, err := um.collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// This file contains the rules usernames and passwords must follow, and the normalization of usernames.
+// Usernames are trimmed before they are stored, and are unique by their case-folded form (UsernameKey), so usernames
+// differing only by case or surrounding whitespace are the same user. The username keeps the case it was entered
+// with for display. Passwords are checked by the same CredentialRules wherever a password is set.
+
+package user
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidUsername is returned when a username does not match the configured format.
+	ErrInvalidUsername = errors.New("invalid username")
+	// ErrWeakPassword is returned when a password does not meet the configured requirements.
+	ErrWeakPassword = errors.New("password does not meet the requirements")
+)
+
+// MaxPasswordLength is the longest password accepted, in bytes. bcrypt does not accept longer passwords.
+const MaxPasswordLength = 72
+
+// DefaultUsernamePattern allows the characters of email addresses, as usernames double as emails for verification.
+var DefaultUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9._%+@-]+$`)
+
+// CredentialRules are the rules usernames and passwords must follow.
+type CredentialRules struct {
+	// shortest and longest usernames, in characters, after trimming
+	UsernameMinLength int
+	UsernameMaxLength int
+	// characters a username may have, nil allows any
+	UsernamePattern *regexp.Regexp
+	// shortest password, in characters. Passwords are always at most MaxPasswordLength bytes
+	PasswordMinLength int
+	// how many of lowercase letters, uppercase letters, digits, and other characters a password must have
+	PasswordMinClasses int
+}
+
+// DefaultCredentialRules returns the rules used when none are configured.
+func DefaultCredentialRules() CredentialRules {
+	return CredentialRules{
+		UsernameMinLength:  3,
+		UsernameMaxLength:  254,
+		UsernamePattern:    DefaultUsernamePattern,
+		PasswordMinLength:  8,
+		PasswordMinClasses: 2,
+	}
+}
+
+// NormalizeUsername returns the username as it is stored and displayed, with surrounding whitespace removed.
+func NormalizeUsername(username string) string {
+	return strings.TrimSpace(username)
+}
+
+// UsernameKey returns the form usernames are unique and looked up by: normalized and case-folded.
+func UsernameKey(username string) string {
+	return strings.ToLower(NormalizeUsername(username))
+}
+
+// ValidateUsername checks a normalized username against the rules.
+//
+// Returns ErrInvalidUsername, wrapped with what is wrong, if the username does not follow them.
+func (r CredentialRules) ValidateUsername(username string) error {
+	length := utf8.RuneCountInString(username)
+	if length < r.UsernameMinLength || (r.UsernameMaxLength > 0 && length > r.UsernameMaxLength) {
+		return fmt.Errorf("%w: must be between %d and %d characters", ErrInvalidUsername, r.UsernameMinLength, r.UsernameMaxLength)
+	}
+	if r.UsernamePattern != nil && !r.UsernamePattern.MatchString(username) {
+		return fmt.Errorf("%w: contains characters that are not allowed", ErrInvalidUsername)
+	}
+	return nil
+}
+
+// ValidatePassword checks a password against the rules. The password may not be the username.
+//
+// Returns ErrWeakPassword, wrapped with what is wrong, if the password does not follow them.
+func (r CredentialRules) ValidatePassword(password, username string) error {
+	if utf8.RuneCountInString(password) < r.PasswordMinLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrWeakPassword, r.PasswordMinLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("%w: must be at most %d bytes", ErrWeakPassword, MaxPasswordLength)
+	}
+	if UsernameKey(password) == UsernameKey(username) {
+		return fmt.Errorf("%w: must not be the username", ErrWeakPassword)
+	}
+
+	var lower, upper, digit, other bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, other} {
+		if has {
+			classes++
+		}
+	}
+	if classes < r.PasswordMinClasses {
+		return fmt.Errorf("%w: must mix at least %d of lowercase letters, uppercase letters, digits, and symbols",
+			ErrWeakPassword, r.PasswordMinClasses)
+	}
+	return nil
+}
@@ -0,0 +1,548 @@
+// This file contains the DocumentRepository, the UserRepository of the databases other than MongoDB: PostgreSQL, and
+// memory for tests and running without a database. Users are stored whole in a docstore.Store, and every method of the
+// UserManager is implemented in Go on top of it, with the same behavior and errors.
+//
+// Usernames, identities and API keys are unique keys of the store, in place of the unique indexes of UserManager, so
+// concurrent registrations of the same username still cannot both succeed.
+
+package user
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/docstore"
+)
+
+// Kinds of keys users are looked up by, see userSchema
+const (
+	keyUsername     = "username"
+	keyIdentity     = "identity"
+	keyAPIKey       = "api_key"
+	keyVerification = "verification"
+	keyScene        = "scene"
+)
+
+// userSchema describes user documents to a docstore.Store.
+var userSchema = docstore.Schema[User]{
+	Name: "users",
+	Kinds: map[string]bool{
+		keyUsername:     true,
+		keyIdentity:     true,
+		keyAPIKey:       true,
+		keyVerification: false,
+		keyScene:        false,
+	},
+	ID:  func(u *User) primitive.ObjectID { return u.ID },
+	New: func(id primitive.ObjectID) *User { return &User{ID: id} },
+	Keys: func(u *User) []docstore.Key {
+		keys := []docstore.Key{
+			{Kind: keyUsername, Value: u.UsernameKey},
+			{Kind: keyVerification, Value: u.VerificationTokenHash},
+		}
+		for _, identity := range u.Identities {
+			keys = append(keys, docstore.Key{Kind: keyIdentity, Value: identity.Key})
+		}
+		for _, key := range u.APIKeys {
+			keys = append(keys, docstore.Key{Kind: keyAPIKey, Value: key.Hash})
+		}
+		for _, sceneID := range u.SceneIDs {
+			keys = append(keys, docstore.Key{Kind: keyScene, Value: sceneID.Hex()})
+		}
+		return keys
+	},
+}
+
+// DocumentRepository is the UserRepository storing users in a docstore.Store.
+type DocumentRepository struct {
+	store  docstore.Store[User]
+	rules  CredentialRules
+	logger *log.Logger
+}
+
+// DocumentRepository is a UserRepository.
+var _ UserRepository = (*DocumentRepository)(nil)
+
+// NewPostgresRepository creates a DocumentRepository storing users in db, which checks new usernames and passwords
+// against rules. Its transactions include the writes of the other repositories of db, i.e the scene.DocumentRepository.
+func NewPostgresRepository(db *sql.DB, logger *log.Logger, rules CredentialRules) (*DocumentRepository, error) {
+	store, err := docstore.NewPostgres(db, userSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &DocumentRepository{store: store, rules: rules, logger: logger}, nil
+}
+
+// NewMemoryRepository creates a DocumentRepository keeping users in db, which checks new usernames and passwords
+// against rules.
+func NewMemoryRepository(db *docstore.MemoryDB, logger *log.Logger, rules CredentialRules) *DocumentRepository {
+	return &DocumentRepository{store: docstore.NewMemory(db, userSchema), rules: rules, logger: logger}
+}
+
+// update runs fn on the user with the given ID and stores the result, unless fn returns an error.
+// Returns ErrUserNotFound if the user does not exist.
+func (r *DocumentRepository) update(ctx context.Context, userID primitive.ObjectID, fn func(user *User) error) error {
+	err := r.store.Update(ctx, userID, fn)
+	if errors.Is(err, docstore.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// findOne retrieves the first user with a key of the given kind and value.
+// Returns ErrUserNotFound if no user has the key.
+func (r *DocumentRepository) findOne(ctx context.Context, kind, value string) (*User, error) {
+	users, err := r.store.Find(ctx, kind, value)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrUserNotFound
+	}
+	return users[0], nil
+}
+
+// EnsureIndexes prepares the storage of users.
+func (r *DocumentRepository) EnsureIndexes(ctx context.Context) error {
+	return r.store.Init(ctx)
+}
+
+// SetUser sets the fields of user that are not empty on the user with its ID, creating it if needed, as a MongoDB $set
+// of the user does.
+func (r *DocumentRepository) SetUser(ctx context.Context, user *User) error {
+	return r.setUser(ctx, user, true)
+}
+
+// UpdateUser sets the fields of user that are not empty on the existing user with its ID.
+func (r *DocumentRepository) UpdateUser(ctx context.Context, user *User) error {
+	return r.setUser(ctx, user, false)
+}
+
+// setUser is SetUser, or UpdateUser unless upsert is set.
+func (r *DocumentRepository) setUser(ctx context.Context, user *User, upsert bool) error {
+	set := func(stored *User) error {
+		if err := docstore.SetFields(stored, user); err != nil {
+			return err
+		}
+		stored.ID = user.ID
+		return nil
+	}
+	if upsert {
+		return r.store.Upsert(ctx, user.ID, set)
+	}
+	return r.update(ctx, user.ID, set)
+}
+
+// GenerateUser generates and inserts a new user, see UserManager.GenerateUser.
+func (r *DocumentRepository) GenerateUser(ctx context.Context, username, password string) (*User, error) {
+	return r.generateUser(ctx, username, password, func(*User) {})
+}
+
+// GenerateUnverifiedUser is GenerateUser for an account whose email awaits verification, see
+// UserManager.GenerateUnverifiedUser.
+func (r *DocumentRepository) GenerateUnverifiedUser(ctx context.Context, username, password, tokenHash string, expiresAt time.Time) (*User, error) {
+	return r.generateUser(ctx, username, password, func(user *User) {
+		user.Unverified = true
+		user.VerificationTokenHash = tokenHash
+		user.VerificationExpiresAt = expiresAt
+		user.VerificationSentAt = time.Now()
+	})
+}
+
+// generateUser generates and inserts a new user, letting init set any fields beyond the defaults.
+func (r *DocumentRepository) generateUser(ctx context.Context, username, password string, init func(*User)) (*User, error) {
+	username = NormalizeUsername(username)
+	if err := r.rules.ValidateUsername(username); err != nil {
+		return nil, err
+	}
+	if err := r.rules.ValidatePassword(password, username); err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		ID:          primitive.NewObjectID(),
+		Username:    username,
+		UsernameKey: UsernameKey(username),
+		Role:        RoleUser,
+	}
+	init(user)
+	if err := user.SetPassword(password); err != nil {
+		return nil, err
+	}
+
+	// The unique username key settles concurrent registrations of the same username
+	if err := r.store.Insert(ctx, user); err != nil {
+		if docstore.IsKeyConflict(err, keyUsername) {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetVerificationToken marks the user as unverified, see UserManager.SetVerificationToken.
+func (r *DocumentRepository) SetVerificationToken(ctx context.Context, userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error {
+	return r.update(ctx, userID, func(user *User) error {
+		user.Unverified = true
+		user.VerificationTokenHash = tokenHash
+		user.VerificationExpiresAt = expiresAt
+		user.VerificationSentAt = time.Now()
+		return nil
+	})
+}
+
+// VerifyEmail marks the account awaiting verification with the token as verified, see UserManager.VerifyEmail.
+func (r *DocumentRepository) VerifyEmail(ctx context.Context, tokenHash string) (*User, error) {
+	var verified *User
+	err := r.store.Transaction(ctx, func(ctx context.Context) error {
+		found, err := r.findOne(ctx, keyVerification, tokenHash)
+		if errors.Is(err, ErrUserNotFound) {
+			return ErrInvalidVerificationToken
+		}
+		if err != nil {
+			return err
+		}
+		if !found.VerificationExpiresAt.After(time.Now()) {
+			return ErrVerificationTokenExpired
+		}
+
+		return r.update(ctx, found.ID, func(user *User) error {
+			user.Unverified = false
+			user.VerificationTokenHash = ""
+			user.VerificationExpiresAt = time.Time{}
+			user.VerificationSentAt = time.Time{}
+			verified = user
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return verified, nil
+}
+
+// UpdatePassword updates the user's password, see UserManager.UpdatePassword.
+func (r *DocumentRepository) UpdatePassword(ctx context.Context, userID primitive.ObjectID, oldPassword, newPassword string) error {
+	return r.update(ctx, userID, func(user *User) error {
+		if user.HasPassword() {
+			if err := user.CheckPassword(oldPassword); err != nil {
+				return err
+			}
+		}
+		if err := r.rules.ValidatePassword(newPassword, user.Username); err != nil {
+			return err
+		}
+		return user.SetPassword(newPassword)
+	})
+}
+
+// UpdateUsername updates the user's username, see UserManager.UpdateUsername.
+func (r *DocumentRepository) UpdateUsername(ctx context.Context, userID primitive.ObjectID, userPassword, newUsername string) error {
+	newUsername = NormalizeUsername(newUsername)
+	if err := r.rules.ValidateUsername(newUsername); err != nil {
+		return err
+	}
+	existing, err := r.GetUserByUsername(ctx, newUsername)
+	if err == nil && existing.ID != userID {
+		return ErrUsernameTaken
+	}
+
+	err = r.update(ctx, userID, func(user *User) error {
+		if err := user.CheckPassword(userPassword); err != nil {
+			return err
+		}
+		user.Username = newUsername
+		user.UsernameKey = UsernameKey(newUsername)
+		return nil
+	})
+	if docstore.IsKeyConflict(err, keyUsername) {
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+// DeleteUser removes the user with the given ID. Cleanup of the user's scenes is the responsibility of the caller.
+func (r *DocumentRepository) DeleteUser(ctx context.Context, userID primitive.ObjectID) error {
+	err := r.store.Delete(ctx, userID)
+	if errors.Is(err, docstore.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// GetUserByID retrieves the user with the given ID.
+func (r *DocumentRepository) GetUserByID(ctx context.Context, userID primitive.ObjectID) (*User, error) {
+	user, err := r.store.Get(ctx, userID)
+	if errors.Is(err, docstore.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// GetUserByUsername retrieves the user with the given username, ignoring case and surrounding whitespace.
+func (r *DocumentRepository) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	return r.findOne(ctx, keyUsername, UsernameKey(username))
+}
+
+// GetUserBySceneID retrieves the user that owns the given scene.
+func (r *DocumentRepository) GetUserBySceneID(ctx context.Context, sceneID primitive.ObjectID) (*User, error) {
+	return r.findOne(ctx, keyScene, sceneID.Hex())
+}
+
+// GetOwnersOfScenes retrieves the owners of the given scenes, keyed by scene ID. Scenes without an owner are omitted.
+func (r *DocumentRepository) GetOwnersOfScenes(ctx context.Context, sceneIDs []primitive.ObjectID) (map[primitive.ObjectID]*User, error) {
+	owners := make(map[primitive.ObjectID]*User)
+	if len(sceneIDs) == 0 {
+		return owners, nil
+	}
+	values := make([]string, 0, len(sceneIDs))
+	for _, id := range sceneIDs {
+		values = append(values, id.Hex())
+	}
+
+	users, err := r.store.Find(ctx, keyScene, values...)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		for _, sceneID := range u.SceneIDs {
+			owners[sceneID] = u
+		}
+	}
+	return owners, nil
+}
+
+// GetAllUsers retrieves every user.
+func (r *DocumentRepository) GetAllUsers(ctx context.Context) ([]*User, error) {
+	return r.store.All(ctx)
+}
+
+// UserHasJobAccess checks if the user owns the scene of the job.
+func (r *DocumentRepository) UserHasJobAccess(ctx context.Context, userID, jobID primitive.ObjectID) (bool, error) {
+	user, err := r.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(user.SceneIDs, jobID), nil
+}
+
+// RemoveSceneFromUser removes a scene ID from the user's list of scenes.
+// Returns ErrSceneIDNotFound if the scene ID is not in the user's scene list, or the user does not exist.
+func (r *DocumentRepository) RemoveSceneFromUser(ctx context.Context, userID, sceneID primitive.ObjectID) error {
+	err := r.update(ctx, userID, func(user *User) error { return user.RemoveScene(sceneID) })
+	if errors.Is(err, ErrUserNotFound) {
+		return ErrSceneIDNotFound
+	}
+	return err
+}
+
+// IncrementStorageUsed adds delta bytes to the user's storage counter. delta may be negative.
+func (r *DocumentRepository) IncrementStorageUsed(ctx context.Context, userID primitive.ObjectID, delta int64) error {
+	return r.update(ctx, userID, func(user *User) error {
+		user.StorageUsed += delta
+		return nil
+	})
+}
+
+// SetStorageUsed overwrites the user's storage counter, see UserManager.SetStorageUsed.
+func (r *DocumentRepository) SetStorageUsed(ctx context.Context, userID primitive.ObjectID, used int64) error {
+	return r.update(ctx, userID, func(user *User) error {
+		user.StorageUsed = used
+		return nil
+	})
+}
+
+// AddAPIKey adds an API key to the user with the given ID.
+func (r *DocumentRepository) AddAPIKey(ctx context.Context, userID primitive.ObjectID, key *APIKey) error {
+	return r.update(ctx, userID, func(user *User) error {
+		user.APIKeys = append(user.APIKeys, *key)
+		return nil
+	})
+}
+
+// GetAPIKeys returns the API keys of the user with the given ID.
+func (r *DocumentRepository) GetAPIKeys(ctx context.Context, userID primitive.ObjectID) ([]APIKey, error) {
+	user, err := r.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.APIKeys == nil {
+		return []APIKey{}, nil
+	}
+	return user.APIKeys, nil
+}
+
+// RevokeAPIKey removes an API key from the user with the given ID.
+// Returns ErrAPIKeyNotFound if the user has no key with the given ID, or does not exist.
+func (r *DocumentRepository) RevokeAPIKey(ctx context.Context, userID, keyID primitive.ObjectID) error {
+	err := r.update(ctx, userID, func(user *User) error {
+		i := slices.IndexFunc(user.APIKeys, func(k APIKey) bool { return k.ID == keyID })
+		if i < 0 {
+			return ErrAPIKeyNotFound
+		}
+		user.APIKeys = slices.Delete(user.APIKeys, i, i+1)
+		return nil
+	})
+	if errors.Is(err, ErrUserNotFound) {
+		return ErrAPIKeyNotFound
+	}
+	return err
+}
+
+// AuthenticateAPIKey returns the user holding the API key whose SHA-256 is keyHash, and the key, recording that the
+// key was used. Returns ErrInvalidAPIKey if the hash matches no key.
+func (r *DocumentRepository) AuthenticateAPIKey(ctx context.Context, keyHash string) (*User, *APIKey, error) {
+	var authenticated *User
+	err := r.store.Transaction(ctx, func(ctx context.Context) error {
+		found, err := r.findOne(ctx, keyAPIKey, keyHash)
+		if err != nil {
+			return err
+		}
+		return r.update(ctx, found.ID, func(user *User) error {
+			i := slices.IndexFunc(user.APIKeys, func(k APIKey) bool { return k.Hash == keyHash })
+			if i < 0 {
+				return ErrUserNotFound
+			}
+			user.APIKeys[i].LastUsedAt = time.Now()
+			authenticated = user
+			return nil
+		})
+	})
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	i := slices.IndexFunc(authenticated.APIKeys, func(k APIKey) bool { return k.Hash == keyHash })
+	return authenticated, &authenticated.APIKeys[i], nil
+}
+
+// GenerateExternalUser generates and inserts a new user without a password, see UserManager.GenerateExternalUser.
+func (r *DocumentRepository) GenerateExternalUser(ctx context.Context, username string, identity *Identity) (*User, error) {
+	username = NormalizeUsername(username)
+	if err := r.rules.ValidateUsername(username); err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		ID:          primitive.NewObjectID(),
+		Username:    username,
+		UsernameKey: UsernameKey(username),
+		Role:        RoleUser,
+		Identities:  []Identity{*identity},
+	}
+	if err := r.store.Insert(ctx, user); err != nil {
+		if docstore.IsKeyConflict(err, keyIdentity) {
+			return nil, ErrIdentityTaken
+		}
+		if docstore.IsKeyConflict(err, keyUsername) {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUserByIdentity retrieves the user with the given identity.
+func (r *DocumentRepository) GetUserByIdentity(ctx context.Context, provider, subject string) (*User, error) {
+	return r.findOne(ctx, keyIdentity, IdentityKey(provider, subject))
+}
+
+// AddIdentity links an identity to the user with the given ID, see UserManager.AddIdentity.
+func (r *DocumentRepository) AddIdentity(ctx context.Context, userID primitive.ObjectID, identity *Identity) error {
+	err := r.update(ctx, userID, func(user *User) error {
+		if slices.ContainsFunc(user.Identities, func(i Identity) bool { return i.Provider == identity.Provider }) {
+			return ErrIdentityExists
+		}
+		user.Identities = append(user.Identities, *identity)
+		return nil
+	})
+	if docstore.IsKeyConflict(err, keyIdentity) {
+		return ErrIdentityTaken
+	}
+	return err
+}
+
+// RemoveIdentity unlinks the identity of a provider from the user with the given ID, see UserManager.RemoveIdentity.
+func (r *DocumentRepository) RemoveIdentity(ctx context.Context, userID primitive.ObjectID, provider string) error {
+	return r.update(ctx, userID, func(user *User) error {
+		i := slices.IndexFunc(user.Identities, func(i Identity) bool { return i.Provider == provider })
+		if i < 0 {
+			return ErrIdentityNotFound
+		}
+		if !user.HasPassword() && len(user.Identities) == 1 {
+			return ErrLastLoginMethod
+		}
+		user.Identities = slices.Delete(user.Identities, i, i+1)
+		return nil
+	})
+}
+
+// ListUsers returns up to limit users after skipping skip, newest first, and the number of users in total. A limit of
+// 0 returns every user after skip.
+func (r *DocumentRepository) ListUsers(ctx context.Context, skip, limit int64) ([]*User, int64, error) {
+	users, err := r.store.All(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := int64(len(users))
+
+	// Object IDs start with their creation time, so sorting by ID sorts by creation time
+	slices.SortFunc(users, func(a, b *User) int { return bytes.Compare(b.ID[:], a.ID[:]) })
+	if skip >= total {
+		return make([]*User, 0), total, nil
+	}
+	users = users[max(skip, 0):]
+	if limit > 0 && limit < int64(len(users)) {
+		users = users[:limit]
+	}
+	return users, total, nil
+}
+
+// SetDisabled disables or re-enables the account of a user.
+func (r *DocumentRepository) SetDisabled(ctx context.Context, userID primitive.ObjectID, disabled bool) error {
+	return r.update(ctx, userID, func(user *User) error {
+		user.Disabled = disabled
+		return nil
+	})
+}
+
+// SetQuotaOverrides replaces the quota overrides of a user, see UserManager.SetQuotaOverrides.
+func (r *DocumentRepository) SetQuotaOverrides(ctx context.Context, userID primitive.ObjectID, overrides *QuotaOverrides) error {
+	return r.update(ctx, userID, func(user *User) error {
+		user.QuotaOverrides = overrides
+		if overrides.IsEmpty() {
+			user.QuotaOverrides = nil
+		}
+		return nil
+	})
+}
+
+// SetTier sets the job tier of a user, see UserManager.SetTier.
+func (r *DocumentRepository) SetTier(ctx context.Context, userID primitive.ObjectID, tier string) error {
+	return r.update(ctx, userID, func(user *User) error {
+		user.Tier = tier
+		if tier == broker.TierStandard {
+			user.Tier = ""
+		}
+		return nil
+	})
+}
+
+// SetNotificationPreferences replaces the notification preferences of a user.
+func (r *DocumentRepository) SetNotificationPreferences(ctx context.Context, userID primitive.ObjectID, preferences NotificationPreferences) error {
+	return r.update(ctx, userID, func(user *User) error {
+		user.Notifications = &preferences
+		return nil
+	})
+}
@@ -0,0 +1,380 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/docstore"
+)
+
+const testPassword = "correct horse 1"
+
+// forEachRepository runs test against an empty in-memory DocumentRepository, and an empty PostgreSQL one if
+// POSTGRES_TEST_URL is set.
+func forEachRepository(t *testing.T, test func(t *testing.T, r *DocumentRepository)) {
+	logger := &log.Logger{SugaredLogger: zap.NewNop().Sugar()}
+	t.Run("memory", func(t *testing.T) {
+		r := NewMemoryRepository(docstore.NewMemoryDB(), logger, DefaultCredentialRules())
+		if err := r.EnsureIndexes(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		test(t, r)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv("POSTGRES_TEST_URL")
+		if url == "" {
+			t.Skip("POSTGRES_TEST_URL is not set")
+		}
+		ctx := context.Background()
+		db, err := docstore.OpenPostgres(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS users_keys, users"); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewPostgresRepository(db, logger, DefaultCredentialRules())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.EnsureIndexes(ctx); err != nil {
+			t.Fatal(err)
+		}
+		test(t, r)
+	})
+}
+
+func generateUser(t *testing.T, r *DocumentRepository, username string) *User {
+	t.Helper()
+	u, err := r.GenerateUser(context.Background(), username, testPassword)
+	if err != nil {
+		t.Fatalf("generate user %s: %v", username, err)
+	}
+	return u
+}
+
+func TestGenerateUser(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		u := generateUser(t, r, "  Alice@example.com ")
+		if u.Username != "Alice@example.com" || u.Role != RoleUser {
+			t.Errorf("generated user: %+v", u)
+		}
+
+		got, err := r.GetUserByUsername(ctx, "alice@EXAMPLE.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != u.ID || got.CheckPassword(testPassword) != nil {
+			t.Errorf("user found by username differs from the generated one: %+v", got)
+		}
+
+		if _, err := r.GenerateUser(ctx, "ALICE@example.com", testPassword); !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("username differing by case: got %v, want ErrUsernameTaken", err)
+		}
+		if _, err := r.GenerateUser(ctx, "bob", "short"); !errors.Is(err, ErrWeakPassword) {
+			t.Errorf("weak password: got %v, want ErrWeakPassword", err)
+		}
+		if _, err := r.GetUserByUsername(ctx, "nobody"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("missing username: got %v, want ErrUserNotFound", err)
+		}
+		if _, err := r.GetUserByID(ctx, primitive.NewObjectID()); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("missing ID: got %v, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestUpdateCredentials(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		alice := generateUser(t, r, "alice")
+		generateUser(t, r, "bob")
+
+		if err := r.UpdateUsername(ctx, alice.ID, testPassword, "BOB"); !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("rename to a taken username: got %v, want ErrUsernameTaken", err)
+		}
+		if err := r.UpdateUsername(ctx, alice.ID, "wrong password", "carol"); err == nil {
+			t.Error("rename with a wrong password succeeded")
+		}
+		if err := r.UpdateUsername(ctx, alice.ID, testPassword, "Alice"); err != nil {
+			t.Errorf("changing the case of the own username: %v", err)
+		}
+		if err := r.UpdateUsername(ctx, alice.ID, testPassword, "carol"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.GetUserByUsername(ctx, "alice"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("old username still finds the user: %v", err)
+		}
+		// The old username is free again
+		generateUser(t, r, "alice")
+
+		if err := r.UpdatePassword(ctx, alice.ID, testPassword, "new password 2"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.GetUserByID(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.CheckPassword("new password 2") != nil {
+			t.Error("new password does not check")
+		}
+		if err := r.UpdatePassword(ctx, alice.ID, testPassword, "other password 3"); err == nil {
+			t.Error("password change with the old password succeeded")
+		}
+	})
+}
+
+func TestVerifyEmail(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		u, err := r.GenerateUnverifiedUser(ctx, "alice", testPassword, "hash", time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.IsVerified() {
+			t.Error("unverified user is verified")
+		}
+
+		if _, err := r.VerifyEmail(ctx, "other"); !errors.Is(err, ErrInvalidVerificationToken) {
+			t.Errorf("unknown token: got %v, want ErrInvalidVerificationToken", err)
+		}
+		verified, err := r.VerifyEmail(ctx, "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verified.ID != u.ID || !verified.IsVerified() || verified.VerificationTokenHash != "" {
+			t.Errorf("verified user: %+v", verified)
+		}
+		if _, err := r.VerifyEmail(ctx, "hash"); !errors.Is(err, ErrInvalidVerificationToken) {
+			t.Errorf("used token: got %v, want ErrInvalidVerificationToken", err)
+		}
+
+		if err := r.SetVerificationToken(ctx, u.ID, "expired", time.Now().Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.VerifyEmail(ctx, "expired"); !errors.Is(err, ErrVerificationTokenExpired) {
+			t.Errorf("expired token: got %v, want ErrVerificationTokenExpired", err)
+		}
+	})
+}
+
+func TestScenesOfUsers(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		alice := generateUser(t, r, "alice")
+		bob := generateUser(t, r, "bob")
+		first, second, unowned := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+		if err := alice.AddScene(first); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetUser(ctx, alice); err != nil {
+			t.Fatal(err)
+		}
+		if err := bob.AddScene(second); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.UpdateUser(ctx, bob); err != nil {
+			t.Fatal(err)
+		}
+
+		owner, err := r.GetUserBySceneID(ctx, first)
+		if err != nil || owner.ID != alice.ID {
+			t.Errorf("GetUserBySceneID() = %v, %v, want alice", owner, err)
+		}
+		owners, err := r.GetOwnersOfScenes(ctx, []primitive.ObjectID{first, second, unowned})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(owners) != 2 || owners[first].ID != alice.ID || owners[second].ID != bob.ID {
+			t.Errorf("GetOwnersOfScenes() = %v", owners)
+		}
+		if access, err := r.UserHasJobAccess(ctx, bob.ID, first); err != nil || access {
+			t.Errorf("UserHasJobAccess() of another user's scene = %v, %v", access, err)
+		}
+
+		if err := r.RemoveSceneFromUser(ctx, alice.ID, first); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.RemoveSceneFromUser(ctx, alice.ID, first); !errors.Is(err, ErrSceneIDNotFound) {
+			t.Errorf("removing a removed scene: got %v, want ErrSceneIDNotFound", err)
+		}
+		if _, err := r.GetUserBySceneID(ctx, first); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("owner of a removed scene: got %v, want ErrUserNotFound", err)
+		}
+
+		if err := r.IncrementStorageUsed(ctx, bob.ID, 100); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.IncrementStorageUsed(ctx, bob.ID, -30); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := r.GetUserByID(ctx, bob.ID); got.StorageUsed != 70 {
+			t.Errorf("storage used is %d, want 70", got.StorageUsed)
+		}
+		if err := r.UpdateUser(ctx, &User{ID: primitive.NewObjectID()}); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("update of missing user: got %v, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		alice := generateUser(t, r, "alice")
+		bob := generateUser(t, r, "bob")
+		key := &APIKey{ID: primitive.NewObjectID(), Name: "ci", Hash: "hash", Scopes: []string{APIKeyScopeRead}}
+
+		if keys, err := r.GetAPIKeys(ctx, alice.ID); err != nil || keys == nil || len(keys) != 0 {
+			t.Errorf("GetAPIKeys() without keys = %v, %v, want an empty slice", keys, err)
+		}
+		if err := r.AddAPIKey(ctx, alice.ID, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.AddAPIKey(ctx, bob.ID, &APIKey{ID: primitive.NewObjectID(), Hash: "hash"}); err == nil {
+			t.Error("adding a key with the hash of another user's key succeeded")
+		}
+
+		u, authenticated, err := r.AuthenticateAPIKey(ctx, "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.ID != alice.ID || authenticated.ID != key.ID || authenticated.LastUsedAt.IsZero() {
+			t.Errorf("AuthenticateAPIKey() = %+v, %+v", u, authenticated)
+		}
+		if _, _, err := r.AuthenticateAPIKey(ctx, "unknown"); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("unknown key: got %v, want ErrInvalidAPIKey", err)
+		}
+
+		if err := r.RevokeAPIKey(ctx, bob.ID, key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("revoking another user's key: got %v, want ErrAPIKeyNotFound", err)
+		}
+		if err := r.RevokeAPIKey(ctx, alice.ID, key.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := r.AuthenticateAPIKey(ctx, "hash"); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("revoked key: got %v, want ErrInvalidAPIKey", err)
+		}
+	})
+}
+
+func TestIdentities(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		external, err := r.GenerateExternalUser(ctx, "alice", NewIdentity("github", "1", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if external.HasPassword() {
+			t.Error("external user has a password")
+		}
+		if _, err := r.GenerateExternalUser(ctx, "bob", NewIdentity("github", "1", "")); !errors.Is(err, ErrIdentityTaken) {
+			t.Errorf("taken identity: got %v, want ErrIdentityTaken", err)
+		}
+		if _, err := r.GenerateExternalUser(ctx, "ALICE", NewIdentity("github", "2", "")); !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("taken username: got %v, want ErrUsernameTaken", err)
+		}
+
+		got, err := r.GetUserByIdentity(ctx, "github", "1")
+		if err != nil || got.ID != external.ID {
+			t.Errorf("GetUserByIdentity() = %v, %v", got, err)
+		}
+		if err := r.RemoveIdentity(ctx, external.ID, "github"); !errors.Is(err, ErrLastLoginMethod) {
+			t.Errorf("removing the only login: got %v, want ErrLastLoginMethod", err)
+		}
+
+		if err := r.AddIdentity(ctx, external.ID, NewIdentity("github", "3", "")); !errors.Is(err, ErrIdentityExists) {
+			t.Errorf("second identity of a provider: got %v, want ErrIdentityExists", err)
+		}
+		bob := generateUser(t, r, "bob")
+		if err := r.AddIdentity(ctx, bob.ID, NewIdentity("github", "1", "")); !errors.Is(err, ErrIdentityTaken) {
+			t.Errorf("identity of another user: got %v, want ErrIdentityTaken", err)
+		}
+		if err := r.AddIdentity(ctx, primitive.NewObjectID(), NewIdentity("google", "1", "")); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("identity of missing user: got %v, want ErrUserNotFound", err)
+		}
+		if err := r.AddIdentity(ctx, external.ID, NewIdentity("google", "1", "")); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.RemoveIdentity(ctx, external.ID, "github"); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.RemoveIdentity(ctx, external.ID, "github"); !errors.Is(err, ErrIdentityNotFound) {
+			t.Errorf("removing a removed identity: got %v, want ErrIdentityNotFound", err)
+		}
+		// The removed identity may be linked to another user
+		if err := r.AddIdentity(ctx, bob.ID, NewIdentity("github", "1", "")); err != nil {
+			t.Errorf("linking a freed identity: %v", err)
+		}
+	})
+}
+
+func TestAdministration(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, r *DocumentRepository) {
+		ctx := context.Background()
+		for _, name := range []string{"alice", "bob", "carol"} {
+			generateUser(t, r, name)
+		}
+
+		users, total, err := r.ListUsers(ctx, 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 3 || len(users) != 1 || users[0].Username != "bob" {
+			t.Errorf("second page of one: %v of %d, want [bob] of 3", users, total)
+		}
+		all, _, err := r.ListUsers(ctx, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(all))
+		for _, u := range all {
+			names = append(names, u.Username)
+		}
+		if !slices.Equal(names, []string{"carol", "bob", "alice"}) {
+			t.Errorf("ListUsers() = %v, want newest first", names)
+		}
+
+		carol := all[0]
+		limit := int64(5)
+		if err := r.SetQuotaOverrides(ctx, carol.ID, &QuotaOverrides{MaxConcurrentJobs: &limit}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetDisabled(ctx, carol.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.GetUserByID(ctx, carol.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Disabled || got.QuotaOverrides == nil || *got.QuotaOverrides.MaxConcurrentJobs != 5 {
+			t.Errorf("admin settings not stored: %+v", got)
+		}
+
+		if err := r.SetQuotaOverrides(ctx, carol.ID, &QuotaOverrides{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetDisabled(ctx, carol.ID, false); err != nil {
+			t.Fatal(err)
+		}
+		got, err = r.GetUserByID(ctx, carol.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Disabled || got.QuotaOverrides != nil {
+			t.Errorf("admin settings not cleared: %+v", got)
+		}
+		if err := r.SetDisabled(ctx, primitive.NewObjectID(), true); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("disabling a missing user: got %v, want ErrUserNotFound", err)
+		}
+	})
+}
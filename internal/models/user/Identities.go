@@ -0,0 +1,181 @@
+// This file contains the external identities users log in with, in place of or alongside a password, i.e a Google or
+// GitHub account. Identities are stored in the user document they belong to, keyed by the provider and the subject the
+// provider identifies the account with, which never changes, unlike the email of the account. An identity belongs to
+// at most one user, and a user has at most one identity of each provider.
+//
+// Users created from an identity have no password until they set one, so they can only log in through a provider.
+
+package user
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrIdentityNotFound is returned when a user has no identity of the requested provider.
+	ErrIdentityNotFound = errors.New("no identity of this provider is linked")
+	// ErrIdentityTaken is returned when linking an identity that is linked to another user.
+	ErrIdentityTaken = errors.New("identity is linked to another account")
+	// ErrIdentityExists is returned when linking an identity of a provider the user already has an identity of.
+	ErrIdentityExists = errors.New("an identity of this provider is already linked, unlink it first")
+	// ErrLastLoginMethod is returned when unlinking the only identity of a user without a password.
+	ErrLastLoginMethod = errors.New("cannot unlink the only way to log in, set a password first")
+)
+
+// Identity is an external account of a user. Key is the provider and subject, which identities are unique by.
+type Identity struct {
+	Key      string    `bson:"key" json:"-"`
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"-"`
+	Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+// NewIdentity returns the identity with the given subject at provider.
+func NewIdentity(provider, subject, email string) *Identity {
+	return &Identity{
+		Key:      IdentityKey(provider, subject),
+		Provider: provider,
+		Subject:  subject,
+		Email:    email,
+		LinkedAt: time.Now(),
+	}
+}
+
+// IdentityKey returns the key identities are unique and looked up by.
+func IdentityKey(provider, subject string) string {
+	return provider + ":" + subject
+}
+
+// HasPassword checks if the user can log in with a password, rather than only through their identities.
+func (u *User) HasPassword() bool {
+	return u.EncryptedPassword != ""
+}
+
+// ensureIdentityIndex creates the unique index identities are looked up by.
+func (um *UserManager) ensureIdentityIndex(ctx context.Context) error {
+	_, err := um.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "identities.key", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"identities.key": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+// isIdentityKeyError checks if err is a duplicate key error of the identity index, rather than of the username index.
+func isIdentityKeyError(err error) bool {
+	return mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "identities.key")
+}
+
+// GenerateExternalUser generates a new user document without a password, logging in with identity, and inserts it
+// into the database. The username is normalized first, see NormalizeUsername.
+//
+// Returns nil, ErrInvalidUsername if the username does not follow the rules, ErrUsernameTaken if it is already taken,
+// or ErrIdentityTaken if the identity is linked to another user.
+func (um *UserManager) GenerateExternalUser(ctx context.Context, username string, identity *Identity) (*User, error) {
+	username = NormalizeUsername(username)
+	if err := um.rules.ValidateUsername(username); err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		ID:          primitive.NewObjectID(),
+		Username:    username,
+		UsernameKey: UsernameKey(username),
+		Role:        RoleUser,
+		Identities:  []Identity{*identity},
+	}
+	if _, err := um.collection.InsertOne(ctx, user); err != nil {
+		if isIdentityKeyError(err) {
+			return nil, ErrIdentityTaken
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUserByIdentity retrieves the user with the given identity.
+//
+// Returns ErrUserNotFound if no user has the identity.
+func (um *UserManager) GetUserByIdentity(ctx context.Context, provider, subject string) (*User, error) {
+	var user User
+	err := um.collection.FindOne(ctx, bson.M{"identities.key": IdentityKey(provider, subject)}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// AddIdentity links an identity to the user with the given ID.
+//
+// Returns ErrUserNotFound if the user does not exist, ErrIdentityExists if the user already has an identity of the
+// provider, or ErrIdentityTaken if the identity is linked to another user.
+func (um *UserManager) AddIdentity(ctx context.Context, userID primitive.ObjectID, identity *Identity) error {
+	result, err := um.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "identities.provider": bson.M{"$ne": identity.Provider}},
+		bson.M{"$push": bson.M{"identities": identity}},
+	)
+	if isIdentityKeyError(err) {
+		return ErrIdentityTaken
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if _, err := um.GetUserByID(ctx, userID); err != nil {
+			return err
+		}
+		return ErrIdentityExists
+	}
+	return nil
+}
+
+// RemoveIdentity unlinks the identity of a provider from the user with the given ID. A user without a password keeps
+// at least one identity, so they can still log in.
+//
+// Returns ErrIdentityNotFound if the user has no identity of the provider, or ErrLastLoginMethod if it is the only way
+// the user can log in.
+func (um *UserManager) RemoveIdentity(ctx context.Context, userID primitive.ObjectID, provider string) error {
+	result, err := um.collection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":                 userID,
+			"identities.provider": provider,
+			"$or": bson.A{
+				bson.M{"encrypted_password": bson.M{"$nin": bson.A{"", nil}}},
+				bson.M{"identities.1": bson.M{"$exists": true}},
+			},
+		},
+		bson.M{"$pull": bson.M{"identities": bson.M{"provider": provider}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		user, err := um.GetUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(user.Identities, func(i Identity) bool { return i.Provider == provider }) {
+			return ErrIdentityNotFound
+		}
+		return ErrLastLoginMethod
+	}
+	return nil
+}
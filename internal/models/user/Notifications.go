@@ -0,0 +1,42 @@
+// This file contains the notification preferences of a user, which choose the mails sent to them about their jobs.
+
+package user
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NotificationPreferences choose which job notifications are mailed to a user.
+type NotificationPreferences struct {
+	// mail when the training of a scene completes
+	TrainingComplete bool `bson:"training_complete" json:"training_complete"`
+	// mail when the job of a scene fails
+	JobFailed bool `bson:"job_failed" json:"job_failed"`
+}
+
+// DefaultNotificationPreferences are the preferences of users that never set theirs.
+var DefaultNotificationPreferences = NotificationPreferences{TrainingComplete: true, JobFailed: true}
+
+// NotificationPreferences returns the notification preferences of the user, DefaultNotificationPreferences if unset
+func (u *User) NotificationPreferences() NotificationPreferences {
+	if u.Notifications == nil {
+		return DefaultNotificationPreferences
+	}
+	return *u.Notifications
+}
+
+// SetNotificationPreferences replaces the notification preferences of a user.
+// Returns ErrUserNotFound if the user does not exist.
+func (um *UserManager) SetNotificationPreferences(ctx context.Context, userID primitive.ObjectID, preferences NotificationPreferences) error {
+	result, err := um.collection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"notifications": preferences}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
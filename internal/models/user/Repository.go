@@ -0,0 +1,68 @@
+// This file contains the UserRepository interface, which services depend on instead of the MongoDB UserManager.
+//
+// The interface uses only the types of this package, context, and primitive.ObjectID as an opaque 12 byte ID, so a
+// repository can be backed by any database. UserManager documents the behavior and errors each method must have.
+
+package user
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserRepository stores user accounts. Implementations must be safe for concurrent use, and return the errors of this
+// package (i.e ErrUserNotFound) as UserManager does.
+type UserRepository interface {
+	// EnsureIndexes prepares the storage of users, and is called once at startup.
+	EnsureIndexes(ctx context.Context) error
+
+	// Accounts
+	GenerateUser(ctx context.Context, username, password string) (*User, error)
+	GenerateUnverifiedUser(ctx context.Context, username, password, tokenHash string, expiresAt time.Time) (*User, error)
+	SetVerificationToken(ctx context.Context, userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error
+	VerifyEmail(ctx context.Context, tokenHash string) (*User, error)
+	SetUser(ctx context.Context, user *User) error
+	UpdateUser(ctx context.Context, user *User) error
+	UpdatePassword(ctx context.Context, userID primitive.ObjectID, oldPassword, newPassword string) error
+	UpdateUsername(ctx context.Context, userID primitive.ObjectID, userPassword, newUsername string) error
+	DeleteUser(ctx context.Context, userID primitive.ObjectID) error
+
+	// Lookups
+	GetUserByID(ctx context.Context, userID primitive.ObjectID) (*User, error)
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetUserBySceneID(ctx context.Context, sceneID primitive.ObjectID) (*User, error)
+	GetOwnersOfScenes(ctx context.Context, sceneIDs []primitive.ObjectID) (map[primitive.ObjectID]*User, error)
+	GetAllUsers(ctx context.Context) ([]*User, error)
+	UserHasJobAccess(ctx context.Context, userID, jobID primitive.ObjectID) (bool, error)
+
+	// Scenes and storage
+	RemoveSceneFromUser(ctx context.Context, userID, sceneID primitive.ObjectID) error
+	IncrementStorageUsed(ctx context.Context, userID primitive.ObjectID, delta int64) error
+	SetStorageUsed(ctx context.Context, userID primitive.ObjectID, used int64) error
+
+	// API keys
+	AddAPIKey(ctx context.Context, userID primitive.ObjectID, key *APIKey) error
+	GetAPIKeys(ctx context.Context, userID primitive.ObjectID) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, keyID primitive.ObjectID) error
+	AuthenticateAPIKey(ctx context.Context, keyHash string) (*User, *APIKey, error)
+
+	// External identities
+	GenerateExternalUser(ctx context.Context, username string, identity *Identity) (*User, error)
+	GetUserByIdentity(ctx context.Context, provider, subject string) (*User, error)
+	AddIdentity(ctx context.Context, userID primitive.ObjectID, identity *Identity) error
+	RemoveIdentity(ctx context.Context, userID primitive.ObjectID, provider string) error
+
+	// Administration
+	ListUsers(ctx context.Context, skip, limit int64) ([]*User, int64, error)
+	SetDisabled(ctx context.Context, userID primitive.ObjectID, disabled bool) error
+	SetQuotaOverrides(ctx context.Context, userID primitive.ObjectID, overrides *QuotaOverrides) error
+	SetTier(ctx context.Context, userID primitive.ObjectID, tier string) error
+
+	// Notifications
+	SetNotificationPreferences(ctx context.Context, userID primitive.ObjectID, preferences NotificationPreferences) error
+}
+
+// UserManager is the MongoDB UserRepository.
+var _ UserRepository = (*UserManager)(nil)
@@ -9,9 +9,13 @@ package user
 import (
 	"errors"
 	"slices"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
 )
 
 var (
@@ -21,12 +25,83 @@ var (
 	ErrSceneIDAlreadyExists = errors.New("scene ID already exists in user scene list")
 )
 
+// Declarations for valid user roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // User represents a user in the system
+//
+// MaxPriority is the highest job priority the user may request, see scene.ValidPriorities.
+// Unset allows only normal priority. Admins, and users in the priority tier, may request any priority.
+//
+// Tier is the broker.Tiers tier whose job queues the user's scenes are published to, set by admins. Unset is
+// broker.TierStandard.
+//
+// StorageUsed is a running counter of the bytes stored on behalf of the user. It is only ever
+// changed atomically through UserManager, and may drift from actual usage after a crash.
+//
+// Username is stored as entered, less surrounding whitespace. UsernameKey is its case-folded form, which usernames are
+// unique and looked up by, see UsernameKey.
+//
+// Unverified is set while the user's email (username) awaits verification. Accounts created before email
+// verification existed do not have it, and count as verified. Only the SHA-256 of the verification token is stored.
+//
+// APIKeys are the keys scripts of the user authenticate with, see APIKeys.go.
+//
+// Identities are the external accounts the user logs in with, see Identities.go. Users created from one have no
+// EncryptedPassword until they set a password.
+//
+// Disabled accounts are set by admins, and cannot log in or use their sessions and API keys, see Admin.go.
+// QuotaOverrides replace the server's quotas for the user, if set by an admin.
+//
+// Notifications choose the job notifications mailed to the user, see NotificationPreferences.
 type User struct {
-	ID                primitive.ObjectID   `bson:"_id,omitempty"`
-	Username          string               `bson:"username"`
-	EncryptedPassword string               `bson:"encrypted_password"`
-	SceneIDs          []primitive.ObjectID `bson:"scene_ids"`
+	ID                    primitive.ObjectID       `bson:"_id,omitempty"`
+	Username              string                   `bson:"username"`
+	UsernameKey           string                   `bson:"username_key,omitempty"`
+	EncryptedPassword     string                   `bson:"encrypted_password"`
+	SceneIDs              []primitive.ObjectID     `bson:"scene_ids"`
+	Role                  string                   `bson:"role,omitempty"`
+	MaxPriority           string                   `bson:"max_priority,omitempty"`
+	Tier                  string                   `bson:"tier,omitempty"`
+	StorageUsed           int64                    `bson:"storage_used"`
+	Unverified            bool                     `bson:"unverified,omitempty"`
+	VerificationTokenHash string                   `bson:"verification_token_hash,omitempty"`
+	VerificationExpiresAt time.Time                `bson:"verification_expires_at,omitempty"`
+	VerificationSentAt    time.Time                `bson:"verification_sent_at,omitempty"`
+	APIKeys               []APIKey                 `bson:"api_keys,omitempty"`
+	Identities            []Identity               `bson:"identities,omitempty"`
+	Disabled              bool                     `bson:"disabled,omitempty"`
+	QuotaOverrides        *QuotaOverrides          `bson:"quota_overrides,omitempty"`
+	Notifications         *NotificationPreferences `bson:"notifications,omitempty"`
+}
+
+// IsAdmin checks if the user has the admin role
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// IsVerified checks if the user's email has been verified, or never needed to be
+func (u *User) IsVerified() bool {
+	return !u.Unverified
+}
+
+// CanRequestPriority checks if the user may publish jobs with the given priority
+func (u *User) CanRequestPriority(priority string) bool {
+	if u.IsAdmin() || priority == scene.PriorityNormal {
+		return true
+	}
+	return priority == scene.PriorityHigh && (u.MaxPriority == scene.PriorityHigh || u.JobTier() == broker.TierPriority)
+}
+
+// JobTier returns the tier the user's jobs are published to
+func (u *User) JobTier() string {
+	if !broker.IsValidTier(u.Tier) {
+		return broker.TierStandard
+	}
+	return u.Tier
 }
 
 // AddScene adds a scene ID to the user's list of scenes
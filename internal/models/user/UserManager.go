@@ -2,6 +2,7 @@
 // The UserManager struct contains a pointer to the nerfdb.users MongoDB collection and a logger. It provides methods to set, get
 // and update user data in the database. Interaction with users is almost always by ID, as the ID will (almost always) be unique.
 // There is limited functionality for updating user data, as the only fields that can be updated are the username and password.
+// Usernames and passwords are checked against the manager's CredentialRules whenever they are set, see Credentials.go.
 
 package user
 
@@ -9,6 +10,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -25,23 +27,66 @@ var (
 	ErrUsernameTaken = errors.New("username is already taken")
 	// ErrUserNoAccess is returned when a user does not have access to a scene (i.e, scene ID not found in user's scene list).
 	ErrUserNoAccess = errors.New("user does not have access to this scene")
+	// ErrInvalidVerificationToken is returned when a verification token matches no account.
+	ErrInvalidVerificationToken = errors.New("invalid verification token")
+	// ErrVerificationTokenExpired is returned when a verification token is used after it expired.
+	ErrVerificationTokenExpired = errors.New("verification token expired, request a new one")
 )
 
 
 type UserManager struct {
 	collection *mongo.Collection
+	rules      CredentialRules
 	logger     *log.Logger
 }
 
-// NewUserManager creates a new instance of UserManager.
-func NewUserManager(client *mongo.Client, logger *log.Logger, unittest bool) *UserManager {
+// NewUserManager creates a new instance of UserManager, which checks new usernames and passwords against rules.
+func NewUserManager(client *mongo.Client, logger *log.Logger, rules CredentialRules, unittest bool) *UserManager {
 	db := client.Database("nerfdb")
 	return &UserManager{
 		collection: db.Collection("users"),
+		rules:      rules,
 		logger:     logger,
 	}
 }
 
+// EnsureIndexes sets the username key of users created before usernames were normalized, and creates the unique
+// index on it, which keeps concurrent registrations of the same username from both succeeding.
+//
+// Creating the index fails if existing users have usernames differing only by case or whitespace. Those users can
+// still log in, but must be renamed by hand before the index can be created.
+//
+// The unique index API keys are looked up by is created as well.
+func (um *UserManager) EnsureIndexes(ctx context.Context) error {
+	cursor, err := um.collection.Find(ctx, bson.M{"username_key": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	users := make([]*User, 0)
+	if err := cursor.All(ctx, &users); err != nil {
+		return err
+	}
+	for _, u := range users {
+		_, err := um.collection.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{"$set": bson.M{"username_key": UsernameKey(u.Username)}})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = um.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "username_key", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"username_key": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+	if err := um.ensureAPIKeyIndex(ctx); err != nil {
+		return err
+	}
+	return um.ensureIdentityIndex(ctx)
+}
+
 // SetUser updates or inserts a user document in the database.
 // Returns nil if successful, or an error if an error occurred while updating the user.
 func (um *UserManager) SetUser(ctx context.Context, user *User) error {
@@ -71,10 +116,35 @@ func (um *UserManager) UpdateUser(ctx context.Context, user *User) error {
 }
 
 // GenerateUser generates a new user document with the given username and password,
-// and inserts it into the database. Returns the User, nil if successful.
-// Returns nil, error if the username is already taken or an error occurred while inserting the user.
+// and inserts it into the database. The username is normalized first, see NormalizeUsername. Returns the User, nil if successful.
+// Returns nil, ErrInvalidUsername or ErrWeakPassword if the credentials do not follow the rules, ErrUsernameTaken if the
+// username is already taken, or error if an error occurred while inserting the user.
 func (um *UserManager) GenerateUser(ctx context.Context, username, password string) (*User, error) {
-	// Check if username is already taken
+	return um.generateUser(ctx, username, password, func(*User) {})
+}
+
+// GenerateUnverifiedUser is GenerateUser for an account whose email awaits verification with the token whose
+// SHA-256 is tokenHash. The account is unverified from the moment it is inserted.
+func (um *UserManager) GenerateUnverifiedUser(ctx context.Context, username, password, tokenHash string, expiresAt time.Time) (*User, error) {
+	return um.generateUser(ctx, username, password, func(user *User) {
+		user.Unverified = true
+		user.VerificationTokenHash = tokenHash
+		user.VerificationExpiresAt = expiresAt
+		user.VerificationSentAt = time.Now()
+	})
+}
+
+// generateUser generates and inserts a new user document, letting init set any fields beyond the defaults.
+func (um *UserManager) generateUser(ctx context.Context, username, password string, init func(*User)) (*User, error) {
+	username = NormalizeUsername(username)
+	if err := um.rules.ValidateUsername(username); err != nil {
+		return nil, err
+	}
+	if err := um.rules.ValidatePassword(password, username); err != nil {
+		return nil, err
+	}
+
+	// Check if username is already taken. The unique index settles concurrent registrations of the same username.
 	_, err := um.GetUserByUsername(ctx, username)
 	if err != nil {
 		if !errors.Is(err, ErrUserNotFound) {
@@ -86,21 +156,110 @@ func (um *UserManager) GenerateUser(ctx context.Context, username, password stri
 
 	id := primitive.NewObjectID()
 	user := &User{
-		ID:       id,
-		Username: username,
+		ID:          id,
+		Username:    username,
+		UsernameKey: UsernameKey(username),
+		Role:        RoleUser,
 	}
+	init(user)
 
 	if err := user.SetPassword(password); err != nil {
 		return nil, err
 	}
 
-	if err := um.SetUser(ctx, user); err != nil {
+	if _, err := um.collection.InsertOne(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrUsernameTaken
+		}
 		return nil, err
 	}
 
 	return user, nil
 }
 
+// SetVerificationToken marks the user as unverified, awaiting verification with the token whose SHA-256 is
+// tokenHash. Any previous token of the user stops working.
+func (um *UserManager) SetVerificationToken(ctx context.Context, userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error {
+	result, err := um.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{
+			"unverified":              true,
+			"verification_token_hash": tokenHash,
+			"verification_expires_at": expiresAt,
+			"verification_sent_at":    time.Now(),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// VerifyEmail marks the account awaiting verification with the token whose SHA-256 is tokenHash as verified, and
+// clears the token so it cannot be used again. Returns the verified user.
+//
+// Returns ErrVerificationTokenExpired if the token expired, or ErrInvalidVerificationToken if it matches no account.
+func (um *UserManager) VerifyEmail(ctx context.Context, tokenHash string) (*User, error) {
+	var user User
+	err := um.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"verification_token_hash": tokenHash, "verification_expires_at": bson.M{"$gt": time.Now()}},
+		bson.M{"$unset": bson.M{
+			"unverified":              "",
+			"verification_token_hash": "",
+			"verification_expires_at": "",
+			"verification_sent_at":    "",
+		}},
+	).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Tell an expired token apart from one that never existed, so the user knows to request a new one
+		count, countErr := um.collection.CountDocuments(ctx, bson.M{"verification_token_hash": tokenHash})
+		if countErr == nil && count > 0 {
+			return nil, ErrVerificationTokenExpired
+		}
+		return nil, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteUser removes the user document with the given ID from the database.
+// Cleanup of the user's scenes is the responsibility of the caller.
+func (um *UserManager) DeleteUser(ctx context.Context, userID primitive.ObjectID) error {
+	result, err := um.collection.DeleteOne(ctx, bson.M{"_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// RemoveSceneFromUser atomically removes a scene ID from the user's list of scenes, so concurrent removals from the same
+// user do not overwrite each other.
+// Returns ErrSceneIDNotFound if the scene ID is not in the user's scene list, or the user does not exist.
+func (um *UserManager) RemoveSceneFromUser(ctx context.Context, userID, sceneID primitive.ObjectID) error {
+	result, err := um.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "scene_ids": sceneID},
+		bson.M{"$pull": bson.M{"scene_ids": sceneID}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrSceneIDNotFound
+	}
+	return nil
+}
+
 // GetUserByID retrieves a user from the database based on the given ID.
 func (um *UserManager) GetUserByID(ctx context.Context, userID primitive.ObjectID) (*User, error) {
 	var user User
@@ -114,11 +273,11 @@ func (um *UserManager) GetUserByID(ctx context.Context, userID primitive.ObjectI
 	return &user, nil
 }
 
-// GetUserByUsername retrieves a user from the database based on the given username.
+// GetUserByUsername retrieves a user from the database based on the given username, ignoring case and surrounding whitespace.
 // Returns the User, nil if successful. Returns nil, error if the user is not found.
 func (um *UserManager) GetUserByUsername(ctx context.Context, username string) (*User, error) {
 	var user User
-	err := um.collection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
+	err := um.collection.FindOne(ctx, bson.M{"username_key": UsernameKey(username)}).Decode(&user)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			fmt.Println("User not found")
@@ -144,27 +303,126 @@ func (um *UserManager) UserHasJobAccess(ctx context.Context, userID, jobID primi
 	return false, nil
 }
 
-// UpdatePassword updates the user's password. Verifies the old password before setting the new password.
-// Returns nil if successful, or an error if the old password is incorrect or an error occurred while updating the password.
+// GetUserBySceneID retrieves the user that owns the given scene.
+// Returns the User, nil if successful. Returns nil, ErrUserNotFound if no user owns the scene.
+func (um *UserManager) GetUserBySceneID(ctx context.Context, sceneID primitive.ObjectID) (*User, error) {
+	var user User
+	err := um.collection.FindOne(ctx, bson.M{"scene_ids": sceneID}).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetOwnersOfScenes retrieves the owners of the given scenes, keyed by scene ID. Scenes without an owner are omitted.
+func (um *UserManager) GetOwnersOfScenes(ctx context.Context, sceneIDs []primitive.ObjectID) (map[primitive.ObjectID]*User, error) {
+	cursor, err := um.collection.Find(ctx, bson.M{"scene_ids": bson.M{"$in": sceneIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := make([]*User, 0)
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	owners := make(map[primitive.ObjectID]*User)
+	for _, u := range users {
+		for _, sceneID := range u.SceneIDs {
+			owners[sceneID] = u
+		}
+	}
+	return owners, nil
+}
+
+// GetAllUsers retrieves every user in the database.
+func (um *UserManager) GetAllUsers(ctx context.Context) ([]*User, error) {
+	cursor, err := um.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := make([]*User, 0)
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// IncrementStorageUsed atomically adds delta bytes to the user's storage counter. delta may be negative.
+func (um *UserManager) IncrementStorageUsed(ctx context.Context, userID primitive.ObjectID, delta int64) error {
+	result, err := um.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID},
+		bson.M{"$inc": bson.M{"storage_used": delta}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetStorageUsed overwrites the user's storage counter. Intended for reconciliation against actual usage only;
+// normal accounting should go through IncrementStorageUsed.
+func (um *UserManager) SetStorageUsed(ctx context.Context, userID primitive.ObjectID, used int64) error {
+	result, err := um.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"storage_used": used}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdatePassword updates the user's password. Verifies the old password before setting the new password, unless the
+// user has no password yet, i.e was created from an identity, see Identities.go.
+// Returns nil if successful, ErrWeakPassword if the new password does not follow the rules, or an error if the old
+// password is incorrect or an error occurred while updating the password.
 func (um *UserManager) UpdatePassword(ctx context.Context, userID primitive.ObjectID, oldPassword, newPassword string) error {
 	user, err := um.GetUserByID(ctx, userID)
 	if err != nil {
 		return err
 	}
 	
-	err = user.CheckPassword(oldPassword)
-	if err != nil {
+	if user.HasPassword() {
+		if err := user.CheckPassword(oldPassword); err != nil {
+			return err
+		}
+	}
+	if err := um.rules.ValidatePassword(newPassword, user.Username); err != nil {
+		return err
+	}
+	if err := user.SetPassword(newPassword); err != nil {
 		return err
 	}
-	return user.SetPassword(newPassword)
+	return um.UpdateUser(ctx, user)
 }
 
-// UpdateUsername updates the user's username. Checks if the new username is already taken.
+// UpdateUsername updates the user's username. The username is normalized first, see NormalizeUsername. Checks if the
+// new username is already taken by another user, so a user may change the case of their own username.
 // Requires the user's password to verify the change.
-// Returns nil if successful, or an error if the new username is already taken or an error occurred while updating the username.
+// Returns nil if successful, ErrInvalidUsername if the new username does not follow the rules, ErrUsernameTaken if it
+// is already taken, or an error if an error occurred while updating the username.
 func (um *UserManager) UpdateUsername(ctx context.Context, userID primitive.ObjectID, userPassword, newUsername string) error {
-	_, err := um.GetUserByUsername(ctx, newUsername)
-	if err == nil {
+	newUsername = NormalizeUsername(newUsername)
+	if err := um.rules.ValidateUsername(newUsername); err != nil {
+		return err
+	}
+	existing, err := um.GetUserByUsername(ctx, newUsername)
+	if err == nil && existing.ID != userID {
 		return ErrUsernameTaken
 	}
 	
@@ -179,5 +437,12 @@ func (um *UserManager) UpdateUsername(ctx context.Context, userID primitive.Obje
 	}
 
 	user.Username = newUsername
-	return um.UpdateUser(ctx, user)
+	user.UsernameKey = UsernameKey(newUsername)
+	if err := um.UpdateUser(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrUsernameTaken
+		}
+		return err
+	}
+	return nil
 }
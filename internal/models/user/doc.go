@@ -1,5 +1,6 @@
 // Package user contains the implementation of interacting with the MongoDB user collection. 
 // The UserManager struct is responsible for interacting with the MongoDB users collection. It is CRUD for the user collection.
+// Services depend on the UserRepository interface it implements, rather than on MongoDB.
 // The User struct is used to represent a user and their scenes.
 // Interaction is primarily by ID, as the ID will (almost always) be unique. BSON is used to interact with the database.
 package user
\ No newline at end of file
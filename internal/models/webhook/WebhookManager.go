@@ -0,0 +1,263 @@
+// This file contains the WebhookManager implementation, which is responsible for interacting with the MongoDB webhooks
+// and webhook_deliveries collections. The WebhookManager struct contains pointers to both collections and a logger. It
+// provides methods to register, list and remove webhooks, find the webhooks an event is sent to, and queue, list and
+// record the outcome of deliveries.
+
+package webhook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+var (
+	// ErrWebhookNotFound is returned when a user has no webhook with the requested ID.
+	ErrWebhookNotFound = errors.New("webhook not found")
+)
+
+// deliveryRetention is how long finished deliveries are kept for inspection.
+const deliveryRetention = 7 * 24 * time.Hour
+
+// Declarations for valid delivery statuses
+const (
+	// waiting for its first or next attempt
+	DeliveryPending = "pending"
+	// accepted by the webhook with a 2xx response
+	DeliveryDelivered = "delivered"
+	// given up on after its last attempt
+	DeliveryFailed = "failed"
+)
+
+// Webhook is a callback URL of a user. A webhook without a SceneID receives the events of every scene the user owns,
+// otherwise only those of the scene. Events lists the event types it receives, all of them if empty.
+// Secret is the HMAC key deliveries are signed with.
+type Webhook struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	SceneID   primitive.ObjectID `bson:"scene_id,omitempty" json:"scene_id,omitempty"`
+	URL       string             `bson:"url" json:"url"`
+	Events    []string           `bson:"events,omitempty" json:"events,omitempty"`
+	Secret    string             `bson:"secret" json:"-"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Delivery is an event sent, or to be sent, to a webhook. Payload is the JSON body POSTed to it.
+type Delivery struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	WebhookID primitive.ObjectID `bson:"webhook_id" json:"webhook_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	SceneID   primitive.ObjectID `bson:"scene_id" json:"scene_id"`
+	Event     string             `bson:"event" json:"event"`
+	Payload   []byte             `bson:"payload" json:"-"`
+	Status    string             `bson:"status" json:"status"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	// attempts so far, and the outcome of the last one. StatusCode is zero if no response was received
+	Attempts      int       `bson:"attempts" json:"attempts"`
+	LastError     string    `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	StatusCode    int       `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	LastAttemptAt time.Time `bson:"last_attempt_at,omitempty" json:"last_attempt_at,omitempty"`
+	// when a pending delivery is attempted next
+	NextAttemptAt time.Time `bson:"next_attempt_at" json:"next_attempt_at,omitempty"`
+	// when a finished delivery is removed, deliveryRetention after it finished
+	ExpiresAt *time.Time `bson:"expires_at,omitempty" json:"-"`
+}
+
+type WebhookManager struct {
+	webhooks   *mongo.Collection
+	deliveries *mongo.Collection
+	logger     *log.Logger
+}
+
+// NewWebhookManager creates a new WebhookManager with the given MongoDB client and logger.
+func NewWebhookManager(client *mongo.Client, logger *log.Logger, unittest bool) *WebhookManager {
+	db := client.Database("nerfdb")
+	return &WebhookManager{
+		webhooks:   db.Collection("webhooks"),
+		deliveries: db.Collection("webhook_deliveries"),
+		logger:     logger,
+	}
+}
+
+// EnsureIndexes creates the indexes webhooks are found by, the index due deliveries are listed by, and the TTL index
+// removing finished deliveries. Existing indexes are kept.
+func (wm *WebhookManager) EnsureIndexes(ctx context.Context) error {
+	_, err := wm.webhooks.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "scene_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = wm.deliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// CreateWebhook inserts a new webhook.
+func (wm *WebhookManager) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	_, err := wm.webhooks.InsertOne(ctx, webhook)
+	return err
+}
+
+// GetWebhook retrieves a webhook of a user.
+// Returns ErrWebhookNotFound if the user has no webhook with the given ID.
+func (wm *WebhookManager) GetWebhook(ctx context.Context, userID, webhookID primitive.ObjectID) (*Webhook, error) {
+	var webhook Webhook
+	err := wm.webhooks.FindOne(ctx, bson.M{"_id": webhookID, "user_id": userID}).Decode(&webhook)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// GetUserWebhooks returns the webhooks of a user, oldest first.
+func (wm *WebhookManager) GetUserWebhooks(ctx context.Context, userID primitive.ObjectID) ([]*Webhook, error) {
+	return wm.findWebhooks(ctx, bson.M{"user_id": userID})
+}
+
+// CountUserWebhooks counts the webhooks of a user.
+func (wm *WebhookManager) CountUserWebhooks(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return wm.webhooks.CountDocuments(ctx, bson.M{"user_id": userID})
+}
+
+// GetSubscribedWebhooks returns the webhooks an event of a scene is sent to: the account webhooks of the scene's owner,
+// and the webhooks registered for the scene itself, that receive the event.
+func (wm *WebhookManager) GetSubscribedWebhooks(ctx context.Context, ownerID, sceneID primitive.ObjectID, event string) ([]*Webhook, error) {
+	return wm.findWebhooks(ctx, bson.M{
+		"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"user_id": ownerID, "scene_id": bson.M{"$exists": false}},
+				bson.M{"scene_id": sceneID},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"events": bson.M{"$exists": false}},
+				bson.M{"events": event},
+			}},
+		},
+	})
+}
+
+// findWebhooks returns the webhooks matching filter, oldest first.
+func (wm *WebhookManager) findWebhooks(ctx context.Context, filter bson.M) ([]*Webhook, error) {
+	cursor, err := wm.webhooks.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	webhooks := make([]*Webhook, 0)
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook of a user, along with its deliveries.
+// Returns ErrWebhookNotFound if the user has no webhook with the given ID.
+func (wm *WebhookManager) DeleteWebhook(ctx context.Context, userID, webhookID primitive.ObjectID) error {
+	result, err := wm.webhooks.DeleteOne(ctx, bson.M{"_id": webhookID, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrWebhookNotFound
+	}
+	_, err = wm.deliveries.DeleteMany(ctx, bson.M{"webhook_id": webhookID})
+	return err
+}
+
+// DeleteUserWebhooks removes every webhook of a user, along with their deliveries.
+func (wm *WebhookManager) DeleteUserWebhooks(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := wm.webhooks.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	_, err := wm.deliveries.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// DeleteSceneWebhooks removes the webhooks registered for a scene, along with their deliveries.
+func (wm *WebhookManager) DeleteSceneWebhooks(ctx context.Context, sceneID primitive.ObjectID) error {
+	if _, err := wm.webhooks.DeleteMany(ctx, bson.M{"scene_id": sceneID}); err != nil {
+		return err
+	}
+	_, err := wm.deliveries.DeleteMany(ctx, bson.M{"scene_id": sceneID})
+	return err
+}
+
+// AddDeliveries inserts deliveries to be sent.
+func (wm *WebhookManager) AddDeliveries(ctx context.Context, deliveries []*Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(deliveries))
+	for i, d := range deliveries {
+		docs[i] = d
+	}
+	_, err := wm.deliveries.InsertMany(ctx, docs)
+	return err
+}
+
+// GetDueDeliveries returns up to limit pending deliveries due at now, oldest first.
+func (wm *WebhookManager) GetDueDeliveries(ctx context.Context, now time.Time, limit int64) ([]*Delivery, error) {
+	return wm.findDeliveries(ctx, bson.M{"status": DeliveryPending, "next_attempt_at": bson.M{"$lte": now}},
+		options.Find().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetLimit(limit))
+}
+
+// GetWebhookDeliveries returns up to limit deliveries of a webhook, newest first.
+func (wm *WebhookManager) GetWebhookDeliveries(ctx context.Context, webhookID primitive.ObjectID, limit int64) ([]*Delivery, error) {
+	return wm.findDeliveries(ctx, bson.M{"webhook_id": webhookID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit))
+}
+
+// findDeliveries returns the deliveries matching filter.
+func (wm *WebhookManager) findDeliveries(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]*Delivery, error) {
+	cursor, err := wm.deliveries.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := make([]*Delivery, 0)
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt records the outcome of an attempt to send a delivery. A delivery that is not finished is attempted
+// again at next. Finished deliveries are removed after deliveryRetention.
+func (wm *WebhookManager) RecordAttempt(ctx context.Context, id primitive.ObjectID, status string, statusCode int, attemptErr error, next time.Time) error {
+	now := time.Now()
+	set := bson.M{
+		"status":          status,
+		"status_code":     statusCode,
+		"last_attempt_at": now,
+		"next_attempt_at": next,
+		"last_error":      "",
+	}
+	if attemptErr != nil {
+		set["last_error"] = attemptErr.Error()
+	}
+	if status != DeliveryPending {
+		set["expires_at"] = now.Add(deliveryRetention)
+	}
+	_, err := wm.deliveries.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set, "$inc": bson.M{"attempts": 1}})
+	return err
+}
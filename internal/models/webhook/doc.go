@@ -0,0 +1,6 @@
+// Package webhook contains the implementation of the webhooks of users in the MongoDB database.
+// The WebhookManager struct is responsible for interacting with the MongoDB webhooks and webhook_deliveries collections.
+// The Webhook struct is a callback URL registered by a user, for the jobs of all of their scenes or of a single scene.
+// The Delivery struct is one event sent, or to be sent, to a webhook, along with the outcome of each attempt. Finished
+// deliveries are removed by a TTL index.
+package webhook
@@ -0,0 +1,162 @@
+// This file contains the WorkerManager implementation, which is responsible for interacting with the MongoDB workers
+// collection. The WorkerManager struct contains a pointer to the collection and a logger. It provides methods to
+// register and deregister workers, record their heartbeats, and list them.
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+var (
+	// ErrWorkerNotFound is returned when no worker is registered with the requested ID.
+	ErrWorkerNotFound = errors.New("worker not found")
+	// ErrInvalidWorkerKind is returned when a worker registers with a kind that is not one of the Kind constants.
+	ErrInvalidWorkerKind = errors.New("invalid worker kind")
+)
+
+// Declarations for valid worker kinds, the pipeline stage a worker runs jobs of
+const (
+	KindSfm  = "sfm"
+	KindNerf = "nerf"
+)
+
+// IsValidKind checks if kind is one of the worker kinds.
+func IsValidKind(kind string) bool {
+	return kind == KindSfm || kind == KindNerf
+}
+
+// GPU is a GPU of a worker, as reported in its last heartbeat. Memory is in bytes, and Utilization between 0 and 1.
+type GPU struct {
+	Name        string  `bson:"name" json:"name"`
+	MemoryTotal int64   `bson:"memory_total" json:"memory_total"`
+	MemoryUsed  int64   `bson:"memory_used" json:"memory_used"`
+	Utilization float64 `bson:"utilization" json:"utilization"`
+}
+
+// Capacity is the capacity a worker reported in its last heartbeat. Slots is the number of jobs it runs at once, of
+// which ActiveJobs are running.
+type Capacity struct {
+	Slots      int   `bson:"slots" json:"slots"`
+	ActiveJobs int   `bson:"active_jobs" json:"active_jobs"`
+	GPUs       []GPU `bson:"gpus,omitempty" json:"gpus,omitempty"`
+}
+
+// Worker is a registered worker. The ID is chosen by the worker, i.e its hostname, so a restarted worker registers
+// again under the same ID.
+type Worker struct {
+	ID            string    `bson:"_id" json:"id"`
+	Kind          string    `bson:"kind" json:"kind"`
+	Hostname      string    `bson:"hostname,omitempty" json:"hostname,omitempty"`
+	Version       string    `bson:"version,omitempty" json:"version,omitempty"`
+	RegisteredAt  time.Time `bson:"registered_at" json:"registered_at"`
+	LastHeartbeat time.Time `bson:"last_heartbeat" json:"last_heartbeat"`
+	Capacity      Capacity  `bson:"capacity" json:"capacity"`
+	// when the registration is removed, unless a heartbeat extends it
+	ExpiresAt time.Time `bson:"expires_at" json:"-"`
+}
+
+type WorkerManager struct {
+	collection *mongo.Collection
+	logger     *log.Logger
+}
+
+// NewWorkerManager creates a new WorkerManager with the given MongoDB client and logger.
+func NewWorkerManager(client *mongo.Client, logger *log.Logger, unittest bool) *WorkerManager {
+	return &WorkerManager{
+		collection: client.Database("nerfdb").Collection("workers"),
+		logger:     logger,
+	}
+}
+
+// EnsureIndexes creates the index live workers are counted by, and the TTL index removing expired registrations.
+// Existing indexes are kept.
+func (wm *WorkerManager) EnsureIndexes(ctx context.Context) error {
+	_, err := wm.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "last_heartbeat", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// RegisterWorker inserts a worker, replacing any registration with the same ID.
+func (wm *WorkerManager) RegisterWorker(ctx context.Context, w *Worker) error {
+	_, err := wm.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": w.ID},
+		bson.M{"$set": bson.M{
+			"kind":           w.Kind,
+			"hostname":       w.Hostname,
+			"version":        w.Version,
+			"registered_at":  w.RegisteredAt,
+			"last_heartbeat": w.LastHeartbeat,
+			"capacity":       w.Capacity,
+			"expires_at":     w.ExpiresAt,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// RecordHeartbeat records a heartbeat of a worker at now with the capacity it reported, and extends its registration
+// until expiresAt.
+// Returns ErrWorkerNotFound if no worker is registered with the given ID, i.e because its registration expired.
+func (wm *WorkerManager) RecordHeartbeat(ctx context.Context, id string, capacity Capacity, now, expiresAt time.Time) error {
+	result, err := wm.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"capacity":       capacity,
+		"last_heartbeat": now,
+		"expires_at":     expiresAt,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrWorkerNotFound
+	}
+	return nil
+}
+
+// DeregisterWorker removes a worker.
+// Returns ErrWorkerNotFound if no worker is registered with the given ID.
+func (wm *WorkerManager) DeregisterWorker(ctx context.Context, id string) error {
+	result, err := wm.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrWorkerNotFound
+	}
+	return nil
+}
+
+// ListWorkers returns every registered worker, ordered by kind and ID.
+func (wm *WorkerManager) ListWorkers(ctx context.Context) ([]*Worker, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "kind", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := wm.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	workers := make([]*Worker, 0)
+	if err := cursor.All(ctx, &workers); err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
+
+// CountLiveWorkers counts the workers of a kind whose last heartbeat was at or after since.
+func (wm *WorkerManager) CountLiveWorkers(ctx context.Context, kind string, since time.Time) (int64, error) {
+	return wm.collection.CountDocuments(ctx, bson.M{"kind": kind, "last_heartbeat": bson.M{"$gte": since}})
+}
@@ -0,0 +1,5 @@
+// Package worker contains the implementation of the registry of GPU workers in the MongoDB database.
+// The WorkerManager struct is responsible for interacting with the MongoDB workers collection.
+// The Worker struct is a registered sfm or nerf worker, with the capacity it reported in its last heartbeat. Workers
+// that stop sending heartbeats are removed by a TTL index once their registration expires.
+package worker
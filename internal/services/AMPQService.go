@@ -2,19 +2,24 @@
 // Between the web server and an AMPQ message broker, and thus workers. The service is the main handler for the training pipeline
 // and is responsible for sending and receiving messages to and from the workers, as well as updating the database with the results.
 //
-// This service expects a rabbitMQ AMPQ 0.9.1 broker to be running on the specified domain. The service connects to the broker and
-// creates the necessary queues for communication. The service then starts consumers for the 'sfm-out' and 'nerf-out' queues, which
-// are responsible for processing the output of the workers.
+// Despite its name, the service is not tied to AMQP: it exchanges messages through a broker.MessageQueue, which may be a
+// RabbitMQ, NATS JetStream or Kafka broker, see the broker package. The queue is connected, and the queues created, before
+// the service is started. The service then starts consumers for the 'sfm-out', 'nerf-out', 'progress-out', 'export-out'
+// and 'render-out' queues, which are responsible for processing the output of the workers, and relays the live training
+// previews of the 'preview' exchange to the viewers connected to this server, see PreviewStream.go.
 //
 // A go channel and waitgroup are used to manage the consumers, and the service can be gracefully shutdown by closing the stopChan.
-// The consumers should *hopefully* be tolerant to connection failures, and will attempt to reconnect every 5 seconds if the connection
-// is lost.
+// The consumers should *hopefully* be tolerant to connection failures, and will attempt to resubscribe every 5 seconds if the
+// subscription fails.
 
 package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -22,224 +27,542 @@ import (
 	"path/filepath"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	amqp "github.com/rabbitmq/amqp091-go"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/outbox"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/models/queue"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/storage"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
 )
 
 type AMPQService struct {
 	baseURL             string
-	messageBrokerDomain string
-	sceneManager        *scene.SceneManager
+	mq                  broker.MessageQueue
+	sceneManager        scene.SceneRepository
 	queueManager        *queue.QueueListManager
-	connection          *amqp.Connection
-	channel             *amqp.Channel
+	userManager         user.UserRepository
+	outboxManager       *outbox.OutboxManager
+	sfmGracePeriod      time.Duration
+	admission           AdmissionConfig
+	reaper              ReaperConfig
+	maxDeliveryAttempts int
+	store               storage.Store
+	sceneCache          *SceneCache
+	metrics             *metrics.Metrics
 	logger              *log.Logger
 	// used for reconnection and graceful shutdown
 	stopChan chan struct{}
 	wg       sync.WaitGroup
+	// admission control, see Admission.go
+	maxInFlightJobs atomic.Int64
+	admissionMu     sync.Mutex
+	slotFreed       chan struct{}
+	// wakes the outbox dispatcher, see Outbox.go
+	outboxReady chan struct{}
+	startedAt   time.Time
+	// when the job of each deferred scene first failed to publish, see DeferSFMJob and DeferTrainingJob
+	publishFailures sync.Map
+	// progress reports not yet written, see ProgressTracker.go
+	progress progressTracker
+	// subscribers to status events, see StatusStream.go
+	statusEvents statusBroker
+	// viewers of live training previews, see PreviewStream.go
+	previews previewBroker
+	// failed attempts to process each message, see DeadLetter.go
+	attempts deliveryAttempts
 }
 
 // Starts a new AMPQService instance as goroutine
-func NewAMPQService(messageBrokerDomain string, sceneManager *scene.SceneManager, queueManager *queue.QueueListManager, logger *log.Logger) (*AMPQService, error) {
+//
+// mq is the connected message queue jobs are published to and worker output is consumed from, see broker.New.
+// The service closes it on Shutdown.
+//
+// sfmGracePeriod is how long a new scene waits before its sfm job is published, giving the user a chance to cancel
+// before any compute starts. Zero publishes immediately.
+//
+// admission caps the number of jobs in flight, see Admission.go. The cap can be changed later with SetMaxInFlightJobs.
+//
+// reaper fails or requeues jobs whose worker stopped reporting, see StaleJobReaper.go.
+//
+// maxDeliveryAttempts is how often worker output is processed before it is dead-lettered, see DeadLetter.go.
+// Values <= 0 use DefaultMaxDeliveryAttempts.
+//
+// outboxManager holds the jobs waiting to be sent to the broker, see Outbox.go.
+//
+// store is the object store nerf outputs are mirrored to once saved, see ObjectStorage.go. If nil, they stay on disk only.
+//
+// sceneCache has the cached output files of a scene dropped whenever its outputs are saved, see SceneCache.go.
+//
+// webhooks is handed every status event published to status streams, see Webhooks.go. If nil, no webhooks are sent.
+// notifications is handed them too, see Notifications.go. If nil, no notifications are mailed.
+//
+// The service records job and broker metrics, and refreshes the broker queue depth gauge whenever metrics are collected.
+func NewAMPQService(mq broker.MessageQueue, sceneManager scene.SceneRepository, queueManager *queue.QueueListManager, userManager user.UserRepository, outboxManager *outbox.OutboxManager, sfmGracePeriod time.Duration, admission AdmissionConfig, reaper ReaperConfig, maxDeliveryAttempts int, store storage.Store, sceneCache *SceneCache, webhooks *Webhooks, notifications *Notifications, appMetrics *metrics.Metrics, logger *log.Logger) (*AMPQService, error) {
 	service := &AMPQService{
-		messageBrokerDomain: messageBrokerDomain,
+		mq:                  mq,
 		queueManager:        queueManager,
 		sceneManager:        sceneManager,
+		userManager:         userManager,
+		outboxManager:       outboxManager,
+		sfmGracePeriod:      sfmGracePeriod,
+		admission:           admission,
+		reaper:              reaper,
+		maxDeliveryAttempts: maxDeliveryAttempts,
+		store:               store,
+		sceneCache:          sceneCache,
+		statusEvents:        statusBroker{notify: notifyStatus(webhooks, notifications)},
+		metrics:             appMetrics,
 		baseURL:             "http://web-server:5000/",
 		logger:              logger,
 		stopChan:            make(chan struct{}),
+		slotFreed:           make(chan struct{}, 1),
+		outboxReady:         make(chan struct{}, 1),
+		startedAt:           time.Now(),
 	}
-
-	err := service.connect()
-	if err != nil {
-		return nil, err
+	service.maxInFlightJobs.Store(admission.MaxInFlightJobs)
+	if service.maxDeliveryAttempts <= 0 {
+		service.maxDeliveryAttempts = DefaultMaxDeliveryAttempts
 	}
 
-	go service.startConsumers()
+	service.startConsumers()
+	go service.resumeGracePeriodJobs()
+	go service.resumeQueuedJobs()
+	service.wg.Add(1)
+	go service.runOutboxDispatcher()
+	go service.runAdmission()
+	go service.runReaper()
+	go service.runProgressWriter()
+
+	appMetrics.OnCollect(service.collectQueueDepth)
+	appMetrics.OnCollect(service.collectQueueLengths)
+	appMetrics.OnCollect(service.collectOutboxPending)
 
 	return service, nil
 }
 
-// connect establishes a connection to the AMPQ message broker and creates the necessary queues
-func (s *AMPQService) connect() error {
-	fmt.Println("AMPQService.connect")
-	timeout := time.Now().Add(time.Minute / 4)
-	var err error
+// startConsumers starts the consumers for the worker output queues.
+//
+// consumers are started as goroutines, and Shutdown waits for them to finish using a WaitGroup.
+func (s *AMPQService) startConsumers() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.stopChan
+		cancel()
+	}()
+
+	consumers := map[string]func(*broker.Delivery) error{
+		broker.QueueSfmOut:      s.processSFMJob,
+		broker.QueueNerfOut:     s.processNERFJob,
+		broker.QueueProgressOut: s.processProgress,
+		broker.QueueExportOut:   s.processExportJob,
+		broker.QueueRenderOut:   s.processRenderJob,
+		broker.QueueDeadLetter:  s.processDeadLetter,
+	}
+	for queueName, processFunc := range consumers {
+		s.wg.Add(1)
+		go s.runConsumer(ctx, queueName, processFunc)
+	}
+	s.wg.Add(1)
+	go s.runPreviewRelay(ctx)
+}
+
+// runConsumer runs a consumer for the specified queue and consumption handler, subscribing again whenever the
+// subscription fails, until ctx is done.
+func (s *AMPQService) runConsumer(ctx context.Context, queueName string, processFunc func(*broker.Delivery) error) {
+	defer s.wg.Done()
 
-	for time.Now().Before(timeout) {
-		s.connection, err = amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:5672/",
-			os.Getenv("RABBITMQ_DEFAULT_USER"),
-			os.Getenv("RABBITMQ_DEFAULT_PASS"),
-			s.messageBrokerDomain))
-		if err == nil {
-			break
+	for {
+		s.logger.Ctx(ctx).Infof("Started consuming from %s", queueName)
+		err := s.mq.Subscribe(ctx, queueName, func(d *broker.Delivery) {
+			s.handleDelivery(ctx, queueName, d, processFunc)
+		})
+		if err == nil || ctx.Err() != nil {
+			s.logger.Ctx(ctx).Infof("Stopping %s consumer", queueName)
+			return
 		}
-		time.Sleep(time.Second)
-	}
 
-	if err != nil {
-		return fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+		s.logger.Ctx(ctx).Errorf("Error in %s consumer: %v. Reconnecting in 5 seconds...", queueName, err)
+		select {
+		case <-ctx.Done():
+			s.logger.Ctx(ctx).Infof("Stopping %s consumer", queueName)
+			return
+		case <-time.After(5 * time.Second):
+		}
 	}
+}
 
-	s.channel, err = s.connection.Channel()
-	if err != nil {
-		return fmt.Errorf("failed to open a channel: %v", err)
+// Shutdown shuts down the AMPQ service, waiting for the consumers to stop and the outbox to be flushed before closing
+// the message queue.
+func (s *AMPQService) Shutdown() {
+	s.logger.Info("Shutting down AMQP service...")
+	close(s.stopChan)
+	s.wg.Wait()
+	if err := s.mq.Close(); err != nil {
+		s.logger.Warnf("Failed to close message queue: %v", err)
 	}
+	s.logger.Info("AMQP service shut down")
+}
+
+// toAPIUrl converts a file path to an API URL
+func (s *AMPQService) toAPIUrl(filePath string) string {
+	return s.baseURL + "worker-data/" + filePath
+}
+
+// PublishSFMJob publishes a new SFM job to the AMPQ message broker.
+//
+// The job is published to the 'sfm-in' queue of the scene's tier, and the scene ID is appended to the 'sfm_list' and 'queue_list' queues.
+// The job is written to the outbox in the same transaction as the queue and status changes, and sent to the broker
+// by the outbox dispatcher, see Outbox.go.
+// Scenes uploaded as a video send its URL in "file_path", and scenes uploaded as an image set send the URLs of their
+// images in "image_paths" instead, as told by "input_type".
+// The job is published with the priority of the scene's training config, so higher priority jobs are consumed first.
+// The job carries the traceparent of its publish span, see traceJob.
+//
+// Returns an error if the job could not be written to the outbox.
+func (s *AMPQService) PublishSFMJob(ctx context.Context, currentScene *scene.Scene) (err error) {
+	ctx, span := tracing.Start(publishContext(ctx, currentScene), "publish sfm-in", tracing.KindProducer)
+	defer span.EndWithError(&err)
+	span.SetAttribute("messaging.system", s.mq.System())
+	span.SetAttribute("messaging.destination", "sfm-in")
+	span.SetAttribute("scene.id", currentScene.ID.Hex())
+	ctx = log.WithFields(ctx, log.FieldSceneID, currentScene.ID.Hex(), log.FieldJobID, s.sceneManager.JobID(currentScene.ID))
 
-	// Declare queues with 1 hour consumer timeout
-	queues := []string{"sfm-in", "nerf-in", "sfm-out", "nerf-out"}
-	for _, queue := range queues {
-		args := amqp.Table{
-			"x-consumer-timeout": int64(time.Hour.Milliseconds()),
+	job := map[string]interface{}{
+		"id":         s.sceneManager.JobID(currentScene.ID),
+		"input_type": currentScene.UploadedInputType(),
+	}
+	if currentScene.UploadedInputType() == scene.InputTypeImages {
+		// The images are the frames, so the sfm-worker skips frame extraction
+		imageURLs := make([]string, len(currentScene.Images.FilePaths))
+		for i, imagePath := range currentScene.Images.FilePaths {
+			imageURLs[i] = s.toAPIUrl(imagePath)
 		}
-		_, err = s.channel.QueueDeclare(queue, false, false, false, false, args)
-		if err != nil {
-			return fmt.Errorf("failed to declare queue %s: %v", queue, err)
+		job["image_paths"] = imageURLs
+	} else {
+		job["file_path"] = s.toAPIUrl(currentScene.Video.FilePath)
+	}
+	// Frame sampling is only sent when overridden, so the sfm-worker keeps its default otherwise
+	if config := currentScene.Config; config != nil && config.NerfTrainingConfig.HasFrameSampling() {
+		if config.NerfTrainingConfig.FrameSampleRate > 0 {
+			job["frame_sample_rate"] = config.NerfTrainingConfig.FrameSampleRate
+		}
+		if config.NerfTrainingConfig.TargetFrameCount > 0 {
+			job["target_frame_count"] = config.NerfTrainingConfig.TargetFrameCount
 		}
 	}
 
+	headers := traceJob(span, job)
+
+	jsonJob, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal SFM job: %v", err)
+	}
+
+	err = s.enqueueJob(ctx, &outbox.Message{
+		Queue:        broker.QueueSfmIn,
+		Body:         jsonJob,
+		Priority:     currentScene.Config.AMQPPriority(),
+		Tier:         currentScene.Config.JobTier(),
+		Headers:      headers,
+		SceneID:      currentScene.ID,
+		Stage:        metrics.StageSfm,
+		TrainingMode: trainingModeOf(currentScene),
+	}, []string{queue.SfmListID, queue.QueueListID}, scene.StateSfmRunning)
+	if err != nil {
+		return fmt.Errorf("failed to publish SFM job: %v", err)
+	}
+
+	s.logger.Ctx(ctx).Infof("SFM Job Published with ID %s", s.sceneManager.JobID(currentScene.ID))
 	return nil
 }
 
-// startConsumers starts the consumers for the AMPQ queues.
+// transitionStatus moves the scene to the given state, logging instead of failing if the transition is rejected.
+// A scene reaching a terminal state frees its admission slot.
 //
-// consumers are started as goroutines, and the function waits for them to finish using a WaitGroup.
-func (s *AMPQService) startConsumers() {
-	go s.runConsumer("sfm-out", s.processSFMJob)
-	go s.runConsumer("nerf-out", s.processNERFJob)
+// Consumers can receive redelivered messages, so a rejected transition is expected and must not cause a requeue.
+func (s *AMPQService) transitionStatus(ctx context.Context, sceneID primitive.ObjectID, state scene.State, errMsg string) {
+	err := s.sceneManager.TransitionStatus(ctx, sceneID, state, errMsg)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to move scene %s to state %s: %v", sceneID.Hex(), state, err)
+		return
+	}
+	s.statusEvents.publish(sceneID, JobStatusEvent{Type: StatusEventStatus, State: state, Error: errMsg, Time: time.Now()})
+	if state.IsTerminal() {
+		s.NotifySlotFreed()
+	}
 }
 
-// runConsumer runs a consumer for the specified queue and consumption handler
-func (s *AMPQService) runConsumer(queueName string, processFunc func(amqp.Delivery) error) {
-	defer s.wg.Done()
+// recordProcessingDurations stores the sfm and training durations of a completed scene, measured from the times
+// it entered each state. These feed the processing time statistics used for job estimates.
+func (s *AMPQService) recordProcessingDurations(ctx context.Context, sceneID primitive.ObjectID) {
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil || status.State != scene.StateCompleted {
+		return
+	}
 
-	for {
-		select {
-		case <-s.stopChan:
-			s.logger.Infof("Stopping %s consumer", queueName)
+	sfm, sfmOk := status.StageDuration(scene.StateSfmRunning, scene.StateSfmDone)
+	training, trainingOk := status.StageDuration(scene.StateTraining, scene.StateCompleted)
+	if !sfmOk || !trainingOk {
+		s.logger.Ctx(ctx).Warnf("Scene %s completed without recorded stage timestamps, skipping duration tracking", sceneID.Hex())
+		return
+	}
+
+	err = s.sceneManager.SetProcessingDurations(ctx, sceneID, sfm, training)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to record processing durations of scene %s: %v", sceneID.Hex(), err)
+	}
+}
+
+// collectQueueDepth refreshes the broker queue depth gauge. It is called whenever metrics are collected.
+// Brokers that cannot tell the depth of a queue, i.e Kafka, leave the gauge unset.
+func (s *AMPQService) collectQueueDepth() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, queueName := range append(slices.Clone(broker.JobQueues), broker.QueueSfmOut, broker.QueueNerfOut) {
+		depth, err := s.mq.QueueDepth(ctx, queueName)
+		if errors.Is(err, broker.ErrQueueDepthUnsupported) {
+			return
+		}
+		if err != nil {
+			s.logger.Warnf("Failed to inspect queue %s for metrics: %v", queueName, err)
 			return
-		default:
-			if err := s.consume(queueName, processFunc); err != nil {
-				s.logger.Errorf("Error in %s consumer: %v. Reconnecting in 5 seconds...", queueName, err)
-				time.Sleep(5 * time.Second)
-			}
 		}
+		s.metrics.AMQPQueueDepth.WithLabelValues(queueName).Set(float64(depth))
 	}
 }
 
-// consume consumes messages from the specified queue and processes them using the provided function
-func (s *AMPQService) consume(queueName string, processFunc func(amqp.Delivery) error) error {
-	if err := s.ensureConnection(); err != nil {
-		return fmt.Errorf("failed to ensure connection: %v", err)
+// collectQueueLengths refreshes the processing queue length gauge. It is called whenever metrics are collected.
+func (s *AMPQService) collectQueueLengths() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, queueName := range s.queueManager.GetQueueNames() {
+		size, err := s.queueManager.GetQueueSize(ctx, queueName)
+		if err != nil {
+			s.logger.Warnf("Failed to get length of queue %s for metrics: %v", queueName, err)
+			continue
+		}
+		s.metrics.ProcessingQueueLength.WithLabelValues(queueName).Set(float64(size))
 	}
+}
 
-	ch, err := s.connection.Channel()
-	if err != nil {
-		return fmt.Errorf("failed to open a channel: %v", err)
+// QueueStatus is a snapshot of the job queues, as returned by AMPQService.QueueStatus.
+type QueueStatus struct {
+	// ready messages in each broker queue, omitted if the broker cannot tell, i.e Kafka
+	Broker map[string]int `json:"broker,omitempty"`
+	// scenes in each processing queue list (i.e "sfm_list")
+	Processing map[string]int `json:"processing"`
+	// jobs written to the outbox but not yet sent to the broker
+	OutboxPending int64            `json:"outbox_pending"`
+	Admission     *AdmissionStatus `json:"admission"`
+}
+
+// QueueStatus returns the depth of every broker queue and processing queue list, the jobs waiting in the outbox, and
+// the state of admission control.
+func (s *AMPQService) QueueStatus(ctx context.Context) (*QueueStatus, error) {
+	status := &QueueStatus{Processing: make(map[string]int)}
+
+	for _, queueName := range append(slices.Clone(broker.JobQueues), broker.QueueSfmOut, broker.QueueNerfOut) {
+		depth, err := s.mq.QueueDepth(ctx, queueName)
+		if errors.Is(err, broker.ErrQueueDepthUnsupported) {
+			status.Broker = nil
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if status.Broker == nil {
+			status.Broker = make(map[string]int)
+		}
+		status.Broker[queueName] = depth
+	}
+
+	for _, queueName := range s.queueManager.GetQueueNames() {
+		size, err := s.queueManager.GetQueueSize(ctx, queueName)
+		if err != nil {
+			return nil, err
+		}
+		status.Processing[queueName] = size
 	}
-	defer ch.Close()
 
-	messages, err := ch.Consume(
-		queueName, "", false, false, false, false, nil,
-	)
+	pending, err := s.outboxManager.CountPending(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to register a consumer: %v", err)
+		return nil, err
 	}
+	status.OutboxPending = pending
 
-	s.logger.Infof("Started consuming from %s", queueName)
+	if status.Admission, err = s.AdmissionStatus(ctx); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
 
-	for msg := range messages {
-		if err := processFunc(msg); err != nil {
-			s.logger.Errorf("Error processing message from %s: %v", queueName, err)
-			msg.Nack(false, true) // Negative acknowledge and requeue
-		} else {
-			msg.Ack(false)
-		}
+// trainingModeOf returns the training mode of a scene for use as a metric label, or "unknown" if it is not configured.
+func trainingModeOf(sc *scene.Scene) string {
+	if sc == nil || sc.Config == nil || sc.Config.NerfTrainingConfig == nil {
+		return "unknown"
+	}
+	return sc.Config.NerfTrainingConfig.TrainingMode
+}
+
+// isAbandoned checks if a scene has been deleted or cancelled, in which case worker output for it should be dropped.
+func (s *AMPQService) isAbandoned(ctx context.Context, sceneID primitive.ObjectID) bool {
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if errors.Is(err, scene.ErrSceneNotFound) {
+		return true
 	}
+	return err == nil && status.State == scene.StateCancelled
+}
 
-	return fmt.Errorf("consumer channel closed")
+// addStorageUsage charges the given number of bytes to the storage counter of the user that owns the scene.
+//
+// Accounting failures are logged rather than returned, as the files are already saved and
+// ReconcileAllQuotas can correct any drift.
+func (s *AMPQService) addStorageUsage(ctx context.Context, sceneID primitive.ObjectID, bytes int64) {
+	owner, err := s.userManager.GetUserBySceneID(ctx, sceneID)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to find owner of scene %s for storage accounting: %v", sceneID.Hex(), err)
+		return
+	}
+	err = s.userManager.IncrementStorageUsed(ctx, owner.ID, bytes)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to add %d bytes to storage of user %s: %v", bytes, owner.ID.Hex(), err)
+	}
 }
 
-// ensureConnection ensures that the AMPQ connection is established
-func (s *AMPQService) ensureConnection() error {
-	if s.connection != nil && !s.connection.IsClosed() {
+// chargeStorageUsage charges the given number of bytes to the storage counter of the user that owns the scene, as
+// addStorageUsage does, but returns accounting failures so the caller's transaction is rolled back. A scene without an
+// owner is not charged.
+func (s *AMPQService) chargeStorageUsage(ctx context.Context, sceneID primitive.ObjectID, bytes int64) error {
+	owner, err := s.userManager.GetUserBySceneID(ctx, sceneID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		s.logger.Ctx(ctx).Warnf("Scene %s has no owner to charge %d bytes of storage to", sceneID.Hex(), bytes)
 		return nil
 	}
+	if err != nil {
+		return fmt.Errorf("failed to find owner of scene: %v", err)
+	}
+	if err := s.userManager.IncrementStorageUsed(ctx, owner.ID, bytes); err != nil {
+		return fmt.Errorf("failed to add %d bytes to storage of user %s: %v", bytes, owner.ID.Hex(), err)
+	}
+	return nil
+}
 
-	s.logger.Info("Reconnecting to RabbitMQ...")
-	return s.connect()
+// SFMGracePeriod returns the delay between scene creation and publishing its sfm job.
+func (s *AMPQService) SFMGracePeriod() time.Duration {
+	return s.sfmGracePeriod
 }
 
-// Shutdown shuts down the AMPQ service
-func (s *AMPQService) Shutdown() {
-	s.logger.Info("Shutting down AMQP service...")
-	close(s.stopChan)
-	s.wg.Wait()
-	if s.connection != nil {
-		s.connection.Close()
+// SubmitSFMJob hands a newly created scene to the pipeline.
+//
+// Without a grace period the sfm job is submitted for admission immediately. Otherwise the scene must be in
+// scene.StateGracePeriod, and the job is submitted once the grace period has elapsed, unless the scene was cancelled
+// in the meantime. Jobs that are not admitted right away wait in scene.StatePendingAdmission, see admitSFMJob.
+func (s *AMPQService) SubmitSFMJob(ctx context.Context, newScene *scene.Scene) error {
+	if s.sfmGracePeriod <= 0 {
+		return s.admitSFMJob(ctx, newScene.ID)
 	}
-	s.logger.Info("AMQP service shut down")
-}
 
-// toAPIUrl converts a file path to an API URL
-func (s *AMPQService) toAPIUrl(filePath string) string {
-	return s.baseURL + "worker-data/" + filePath
+	s.logger.Ctx(ctx).Infof("SFM job for %s scheduled in %s", s.sceneManager.JobID(newScene.ID), s.sfmGracePeriod)
+	s.scheduleSFMJob(newScene.ID, s.sfmGracePeriod)
+	return nil
 }
 
-// PublishSFMJob publishes a new SFM job to the AMPQ message broker.
+// scheduleSFMJob submits the sfm job of a scene in the grace period for admission after the given delay.
 //
-// The job is published to the 'sfm-in' queue, and the scene ID is appended to the 'sfm_list' and 'queue_list' queues.
+// The scene is moved out of scene.StateGracePeriod before publishing. That transition is atomic, so if the scene was
+// cancelled first the transition is rejected and the job never reaches a worker.
+func (s *AMPQService) scheduleSFMJob(sceneID primitive.ObjectID, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		ctx := context.Background()
+
+		if err := s.admitSFMJob(ctx, sceneID); err != nil {
+			if errors.Is(err, scene.ErrInvalidStatusTransition) || errors.Is(err, scene.ErrSceneNotFound) {
+				s.logger.Infof("Not publishing SFM job for scene %s after grace period: %v", sceneID.Hex(), err)
+				return
+			}
+			s.logger.Errorf("Failed to publish SFM job for scene %s after grace period, retrying: %v", sceneID.Hex(), err)
+			s.DeferSFMJob(ctx, sceneID)
+		}
+	})
+}
+
+// RequeueJob republishes the job for the current stage of a processing scene, i.e when a worker lost the message.
+// A scene in its grace period or pending admission is published immediately, bypassing admission control.
 //
-// Returns an error if the job could not be published.
-func (s *AMPQService) PublishSFMJob(ctx context.Context, scene *scene.Scene) error {
-	job := map[string]interface{}{
-		"id":        scene.ID.Hex(),
-		"file_path": s.toAPIUrl(scene.Video.FilePath),
+// Returns scene.ErrInvalidStatusTransition if the scene is not processing.
+func (s *AMPQService) RequeueJob(ctx context.Context, sceneID primitive.ObjectID) error {
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil {
+		return err
 	}
 
-	jsonJob, err := json.Marshal(job)
-	if err != nil {
-		return fmt.Errorf("failed to marshal SFM job: %v", err)
+	if status.State == scene.StateGracePeriod || status.State == scene.StatePendingAdmission {
+		if err := s.sceneManager.TransitionStatus(ctx, sceneID, scene.StateQueued, ""); err != nil {
+			return err
+		}
+		status.State = scene.StateQueued
 	}
 
-	err = s.channel.PublishWithContext(ctx, "", "sfm-in", false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        jsonJob,
-	})
+	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
 	if err != nil {
-		return fmt.Errorf("failed to publish SFM job: %v", err)
+		return err
 	}
 
-	err = s.queueManager.AppendToQueue(ctx, "sfm_list", scene.ID)
-	if err != nil {
-		return fmt.Errorf("failed to append to sfm_list: %v", err)
+	// Queue entries are removed first, so republishing does not add duplicates
+	switch status.State {
+	case scene.StateQueued, scene.StateSfmRunning:
+		if err := removeFromQueues(ctx, s.queueManager, sceneID, queue.SfmListID, queue.QueueListID); err != nil {
+			return err
+		}
+		return s.PublishSFMJob(ctx, currentScene)
+	case scene.StateSfmDone, scene.StateTraining:
+		if err := removeFromQueues(ctx, s.queueManager, sceneID, queue.NerfListID); err != nil {
+			return err
+		}
+		return s.PublishNERFJob(ctx, currentScene)
+	default:
+		return fmt.Errorf("%w: scene is %s", scene.ErrInvalidStatusTransition, status.State)
 	}
+}
 
-	err = s.queueManager.AppendToQueue(ctx, "queue_list", scene.ID)
+// resumeGracePeriodJobs reschedules scenes left in the grace period, i.e by a restart, for the remainder of their delay.
+func (s *AMPQService) resumeGracePeriodJobs() {
+	scenes, err := s.sceneManager.GetScenesByState(context.Background(), scene.StateGracePeriod)
 	if err != nil {
-		return fmt.Errorf("failed to append to queue_list: %v", err)
+		s.logger.Errorf("Failed to resume grace period jobs: %v", err)
+		return
 	}
 
-	s.logger.Infof("SFM Job Published with ID %s", scene.ID.Hex())
-	return nil
+	for _, pending := range scenes {
+		remaining := time.Until(pending.Status.UpdatedAt.Add(s.sfmGracePeriod))
+		s.scheduleSFMJob(pending.ID, max(remaining, 0))
+	}
+	if len(scenes) > 0 {
+		s.logger.Infof("Resumed %d grace period jobs", len(scenes))
+	}
 }
 
 // processSFMJob processes a message from the 'sfm-out' queue.
 //
 // The message is expected to contain the output of the SFM worker, which is then processed and saved to the database.
 // Upon successful processing, the scene is removed from the 'sfm_list' queue and a new NERF job is published.
+// If the worker reports a non-zero flag, the scene is marked as failed and removed from all queues instead.
 //
 // This function TRUSTS the output of the SFM worker, and does not perform any validation on the message.
 // The expected message format is:
 //
 //	{
-//  	"id": string (primitive.ObjectID.Hex()),
+//  	"id": string (SceneManager.JobID),
 //  	"vid_width": int,
 //  	"vid_height": int,
 //  	"sfm": {
@@ -255,74 +578,111 @@ func (s *AMPQService) PublishSFMJob(ctx context.Context, scene *scene.Scene) err
 //  	},
 //  	"flag": someInt
 //	}
-func (s *AMPQService) processSFMJob(d amqp.Delivery) error {
+func (s *AMPQService) processSFMJob(d *broker.Delivery) (err error) {
 	type SfmWorkerData struct {
-		SceneID   string    `json:"id"`
-		VidWidth  int       `json:"vid_width"`
-		VidHeight int       `json:"vid_height"`
-		Sfm       scene.Sfm `json:"sfm"`
-		Flag      int       `json:"flag"`
+		SceneID     string    `json:"id"`
+		VidWidth    int       `json:"vid_width"`
+		VidHeight   int       `json:"vid_height"`
+		Sfm         scene.Sfm `json:"sfm"`
+		Flag        int       `json:"flag"`
+		Traceparent string    `json:"traceparent"`
 	}
 
 	var data SfmWorkerData
 
 	// Decode sfm-worker output
-	err := json.Unmarshal(d.Body, &data)
+	err = json.Unmarshal(d.Body, &data)
 	if err != nil {
 		s.logger.Errorf("Error unmarshalling SFM data: %v", err)
-		d.Nack(false, true)
 		return err
 	}
 
 	s.logger.Debug("Processing SFM job: ", data)
 
-	sceneID, err := primitive.ObjectIDFromHex(data.SceneID)
+	sceneID, err := s.sceneManager.ParseJobID(data.SceneID)
 	if err != nil {
 		s.logger.Errorf("Invalid ID format: %v", err)
-		d.Nack(false, true)
 		return err
 	}
 
-	ctx := context.Background()
+	ctx, span := tracing.Start(consumeContext(d, data.Traceparent), "process sfm-out", tracing.KindConsumer)
+	defer span.EndWithError(&err)
+	span.SetAttribute("messaging.system", s.mq.System())
+	span.SetAttribute("messaging.source", "sfm-out")
+	span.SetAttribute("scene.id", sceneID.Hex())
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex(), log.FieldJobID, data.SceneID, log.FieldQueue, broker.QueueSfmOut)
+
+	if s.isAbandoned(ctx, sceneID) {
+		s.logger.Ctx(ctx).Infof("Dropping SFM output for deleted or cancelled scene %s", sceneID.Hex())
+		return nil
+	}
+
+	// A non-zero flag means the sfm-worker could not recover camera poses from the video
+	if data.Flag != 0 {
+		s.logger.Ctx(ctx).Errorf("SFM worker failed scene %s with flag %d", sceneID.Hex(), data.Flag)
+
+		failedScene, err := s.sceneManager.GetScene(ctx, sceneID)
+		if err != nil {
+			s.logger.Ctx(ctx).Errorf("Error getting scene: %v", err)
+			return err
+		}
+		err = s.failJob(ctx, failedScene, &scene.JobFailure{
+			Stage:    metrics.StageSfm,
+			Queue:    broker.QueueSfmOut,
+			Reason:   fmt.Sprintf("sfm worker failed with flag %d", data.Flag),
+			Attempts: 1,
+			FailedAt: time.Now(),
+		})
+		if err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to fail scene %s: %v", sceneID.Hex(), err)
+		}
+		return nil
+	}
 
 	// Create sfm output directory
-	saveDir := filepath.Join("data", "sfm", sceneID.Hex())
+	saveDir := s.sceneManager.SfmDir(sceneID)
 	err = os.MkdirAll(saveDir, os.ModePerm)
 	if err != nil {
-		s.logger.Errorf("Error creating directory: %v", err)
+		s.logger.Ctx(ctx).Errorf("Error creating directory: %v", err)
 		return fmt.Errorf("error creating directory: %v", err)
 	}
 
 	// Process the frames: download and save the files
+	var savedBytes int64
 	for i, frame := range data.Sfm.Frames {
 		url := frame.FilePath
-		s.logger.Debugf("Downloading image from %s", url)
+		s.logger.Ctx(ctx).Debugf("Downloading image from %s", url)
 
 		resp, err := http.Get(url)
 		if err != nil {
-			s.logger.Errorf("Error downloading image: %v", err)
+			s.logger.Ctx(ctx).Errorf("Error downloading image: %v", err)
 			return fmt.Errorf("error downloading image: %v", err)
 		}
 		defer resp.Body.Close()
 
 		// Download and save the file
 		fileName := filepath.Base(url)
-		filePath := filepath.Join(saveDir, fileName)
+		filePath, err := s.sceneManager.SfmFramePath(sceneID, fileName)
+		if err != nil {
+			s.logger.Ctx(ctx).Errorf("Invalid frame file name: %v", err)
+			return fmt.Errorf("invalid frame file name: %v", err)
+		}
 
 		file, err := os.Create(filePath)
 		if err != nil {
-			s.logger.Errorf("Error creating file: %v", err)
+			s.logger.Ctx(ctx).Errorf("Error creating file: %v", err)
 			return fmt.Errorf("error creating file: %v", err)
 		}
 		defer file.Close()
 
-		_, err = io.Copy(file, resp.Body)
+		written, err := io.Copy(file, resp.Body)
 		if err != nil {
-			s.logger.Errorf("Error saving file: %v", err)
+			s.logger.Ctx(ctx).Errorf("Error saving file: %v", err)
 			return fmt.Errorf("error saving file: %v", err)
 		}
+		savedBytes += written
 
-		s.logger.Infof("File saved at %s", filePath)
+		s.logger.Ctx(ctx).Infof("File saved at %s", filePath)
 
 		data.Sfm.Frames[i].FilePath = s.toAPIUrl(filePath)
 	}
@@ -330,8 +690,7 @@ func (s *AMPQService) processSFMJob(d amqp.Delivery) error {
 	// Update the scene with the new SFM Worker data
 	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
 	if err != nil {
-		s.logger.Errorf("Error getting scene: %v", err)
-		d.Nack(false, true)
+		s.logger.Ctx(ctx).Errorf("Error getting scene: %v", err)
 		return err
 	}
 
@@ -342,45 +701,60 @@ func (s *AMPQService) processSFMJob(d amqp.Delivery) error {
 
 	err = s.sceneManager.SetScene(ctx, sceneID, currentScene)
 	if err != nil {
-		s.logger.Errorf("Error setting scene data: %v", err)
-		d.Nack(false, true)
+		s.logger.Ctx(ctx).Errorf("Error setting scene data: %v", err)
 		return err
 	}
 
+	s.addStorageUsage(ctx, sceneID, savedBytes)
+	s.transitionStatus(ctx, sceneID, scene.StateSfmDone, "")
+
 	// Remove from sfm_list queue
-	err = s.queueManager.DeleteFromQueue(ctx, "sfm_list", sceneID)
+	err = s.queueManager.DeleteFromQueue(ctx, queue.SfmListID, sceneID)
 	if err != nil {
-		s.logger.Errorf("Error popping from sfm_list queue: %v", err)
+		s.logger.Ctx(ctx).Errorf("Error popping from sfm_list queue: %v", err)
 	}
 
-	s.logger.Debug("Saved finished SFM job")
+	s.logger.Ctx(ctx).Debug("Saved finished SFM job")
 
 	// Publish new job to nerf-in
 	err = s.PublishNERFJob(ctx, currentScene)
 	if err != nil {
-		s.logger.Errorf("Error publishing NERF job: %v", err)
-		d.Nack(false, true)
+		s.logger.Ctx(ctx).Errorf("Error publishing NERF job: %v", err)
 		return err
 	}
 
-	d.Ack(false)
 	return nil
 }
 
 // PublishNERFJob publishes a new NERF job to the AMPQ message broker.
 // The job is published to the 'nerf-in' queue, and the scene ID is appended to the 'nerf_list' queue.
+// Like sfm jobs, it is published with the priority of the scene's training config, and carries the traceparent of its
+// publish span. It is sent through the outbox as well.
 //
-// Returns an error if the job could not be published.
-func (s *AMPQService) PublishNERFJob(ctx context.Context, scene *scene.Scene) error {
+// Returns an error if the job could not be written to the outbox.
+func (s *AMPQService) PublishNERFJob(ctx context.Context, currentScene *scene.Scene) error {
+	return s.publishNERFJob(ctx, currentScene, []string{queue.NerfListID})
+}
+
+// publishNERFJob publishes the NERF job of a scene as PublishNERFJob describes, appending the scene to the given
+// processing queues in the same transaction.
+func (s *AMPQService) publishNERFJob(ctx context.Context, currentScene *scene.Scene, queueNames []string) (err error) {
+	ctx, span := tracing.Start(publishContext(ctx, currentScene), "publish nerf-in", tracing.KindProducer)
+	defer span.EndWithError(&err)
+	span.SetAttribute("messaging.system", s.mq.System())
+	span.SetAttribute("messaging.destination", "nerf-in")
+	span.SetAttribute("scene.id", currentScene.ID.Hex())
+	ctx = log.WithFields(ctx, log.FieldSceneID, currentScene.ID.Hex(), log.FieldJobID, s.sceneManager.JobID(currentScene.ID))
+
 	// Extract data from scene
-	sceneID := scene.ID
-	vid := scene.Video
-	sfm := scene.Sfm
-	config := scene.Config
+	sceneID := currentScene.ID
+	vid := currentScene.Video
+	sfm := currentScene.Sfm
+	config := currentScene.Config
 
 	// Construct job
 	jobMap := map[string]interface{}{
-		"id":               sceneID.Hex(),
+		"id":               s.sceneManager.JobID(sceneID),
 		"vid_width":        vid.Width,
 		"vid_height":       vid.Height,
 		"frames":           sfm.Frames,
@@ -392,38 +766,83 @@ func (s *AMPQService) PublishNERFJob(ctx context.Context, scene *scene.Scene) er
 		"total_iterations": config.NerfTrainingConfig.TotalIterations,
 	}
 
+	headers := traceJob(span, jobMap)
+
 	jobJson, err := json.Marshal(jobMap)
 	if err != nil {
-		s.logger.Errorf("Failed to marshal NERF job: %v", err)
+		s.logger.Ctx(ctx).Errorf("Failed to marshal NERF job: %v", err)
 		return fmt.Errorf("failed to marshal NERF job: %v", err)
 	}
 
-	s.logger.Debugf("Job JSON: %s", jobJson)
-
-	// Publish job
-	err = s.channel.PublishWithContext(ctx, "", "nerf-in", false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        jobJson,
-	})
+	s.logger.Ctx(ctx).Debugf("Job JSON: %s", jobJson)
+
+	// Publish job through the outbox, appending to the processing queues
+	err = s.enqueueJob(ctx, &outbox.Message{
+		Queue:        broker.QueueNerfIn,
+		Body:         jobJson,
+		Priority:     config.AMQPPriority(),
+		Tier:         config.JobTier(),
+		Headers:      headers,
+		SceneID:      sceneID,
+		Stage:        metrics.StageTraining,
+		TrainingMode: trainingModeOf(currentScene),
+	}, queueNames, scene.StateTraining)
 	if err != nil {
-		s.logger.Errorf("Failed to publish NERF job: %v", err)
+		s.logger.Ctx(ctx).Errorf("Failed to publish NERF job: %v", err)
 		return fmt.Errorf("failed to publish NERF job: %v", err)
 	}
 
-	// Append to nerf_list queue
-	err = s.queueManager.AppendToQueue(ctx, "nerf_list", sceneID)
-	if err != nil {
-		return fmt.Errorf("failed to append to nerf_list: %v", err)
+	s.logger.Ctx(ctx).Debug("NERF Job Published with ID ", s.sceneManager.JobID(sceneID))
+	return nil
+}
+
+// publishContext returns the context to publish the job of a scene with: ctx if it carries a span, i.e of the request
+// or the worker output the job is published for, or else the trace of the upload that created the scene.
+func publishContext(ctx context.Context, currentScene *scene.Scene) context.Context {
+	if _, ok := tracing.SpanContextFromContext(ctx); ok {
+		return ctx
 	}
+	return tracing.ContextWithTraceparent(ctx, currentScene.Traceparent)
+}
 
-	s.logger.Debug("NERF Job Published with ID ", sceneID.Hex())
-	return nil
+// traceJob adds the traceparent of the publish span of a job to the job, and returns the message headers carrying it,
+// so workers can continue the trace whether they read the headers or only the job. Returns nil if tracing is disabled.
+func traceJob(span *tracing.Span, job map[string]interface{}) map[string]string {
+	traceparent := span.SpanContext().Traceparent()
+	if traceparent == "" {
+		return nil
+	}
+	job[tracing.TraceparentHeader] = traceparent
+	return map[string]string{tracing.TraceparentHeader: traceparent}
+}
+
+// consumeContext returns the context to process a worker's output message with, continuing the trace of the job it
+// is the output of. The traceparent is read from the message headers, or else from the message body.
+func consumeContext(d *broker.Delivery, bodyTraceparent string) context.Context {
+	traceparent := bodyTraceparent
+	if header, ok := d.Headers[tracing.TraceparentHeader]; ok && header != "" {
+		traceparent = header
+	}
+	return tracing.ContextWithTraceparent(context.Background(), traceparent)
+}
+
+// PublishTrainingJob publishes the NERF job of a scene uploaded with pre-computed sfm output, which skips the sfm stage.
+// The job is published as PublishNERFJob describes, and the scene is also appended to the 'queue_list' queue, as
+// PublishSFMJob would have, in the same transaction.
+//
+// Returns an error if the scene has no pre-computed sfm output, or the job could not be written to the outbox.
+func (s *AMPQService) PublishTrainingJob(ctx context.Context, currentScene *scene.Scene) error {
+	if currentScene.Sfm == nil || !currentScene.Sfm.Precomputed {
+		return fmt.Errorf("scene %s has no pre-computed sfm output", currentScene.ID.Hex())
+	}
+	return s.publishNERFJob(ctx, currentScene, []string{queue.QueueListID, queue.NerfListID})
 }
 
 // processNERFJob processes a message from the 'nerf-out' queue
 //
 // The message is expected to contain the output of the NERF worker, which is then processed and saved to the file system & database.
-// Upon successful processing, the scene is removed from the 'nerf_list' and 'queue_list' queues.
+// Upon successful processing, the scene is marked completed, charged for the downloaded outputs, and removed from the
+// 'nerf_list' and 'queue_list' queues, in one transaction, so output that is redelivered is never charged twice.
 //
 // This function TRUSTS the output of the nerf worker, and only validates the output types
 // and iterations against the scene config.
@@ -431,10 +850,10 @@ func (s *AMPQService) PublishNERFJob(ctx context.Context, scene *scene.Scene) er
 // The expected message format is:
 //
 //	{
-//	    "id": string (primitive.ObjectID.Hex()),
+//	    "id": string (SceneManager.JobID),
 //	    "file_paths": {
 //	        "typeA": {
-//	            int (iteration): string (url),
+//	            int (iteration): string (url, empty if uploaded through the worker API),
 //				 ...
 //	        },
 //	        "typeB": {
@@ -443,28 +862,39 @@ func (s *AMPQService) PublishNERFJob(ctx context.Context, scene *scene.Scene) er
 //	        ...
 //		}
 //	}
-func (s *AMPQService) processNERFJob(msg amqp.Delivery) error {
+func (s *AMPQService) processNERFJob(msg *broker.Delivery) (err error) {
 	type IterationPaths map[int]string
 	type FilePaths map[string]IterationPaths
 	type NerfWorkerData struct {
-		SceneID   string    `json:"id"`
-		FilePaths FilePaths `json:"file_paths"`
+		SceneID     string    `json:"id"`
+		FilePaths   FilePaths `json:"file_paths"`
+		Traceparent string    `json:"traceparent"`
 	}
 
 	var data NerfWorkerData
-	err := json.Unmarshal(msg.Body, &data)
+	err = json.Unmarshal(msg.Body, &data)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal NERF worker data: %w", err)
 	}
 
 	s.logger.Debug("Processing NERF job: ", data)
 
-	sceneID, err := primitive.ObjectIDFromHex(data.SceneID)
+	sceneID, err := s.sceneManager.ParseJobID(data.SceneID)
 	if err != nil {
 		return fmt.Errorf("invalid ID format: %v", err)
 	}
 
-	ctx := context.Background()
+	ctx, span := tracing.Start(consumeContext(msg, data.Traceparent), "process nerf-out", tracing.KindConsumer)
+	defer span.EndWithError(&err)
+	span.SetAttribute("messaging.system", s.mq.System())
+	span.SetAttribute("messaging.source", "nerf-out")
+	span.SetAttribute("scene.id", sceneID.Hex())
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex(), log.FieldJobID, data.SceneID, log.FieldQueue, broker.QueueNerfOut)
+
+	if s.isAbandoned(ctx, sceneID) {
+		s.logger.Ctx(ctx).Infof("Dropping NERF output for deleted or cancelled scene %s", sceneID.Hex())
+		return nil
+	}
 
 	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
 	if err != nil {
@@ -472,65 +902,44 @@ func (s *AMPQService) processNERFJob(msg amqp.Delivery) error {
 	}
 
 	nerf := &scene.Nerf{}
-	s.logger.Debug("Current Nerf: ", nerf)
+	s.logger.Ctx(ctx).Debug("Current Nerf: ", nerf)
 	config := currentScene.Config
-	s.logger.Debug("Current Config: ", config)
+	s.logger.Ctx(ctx).Debug("Current Config: ", config)
 	outputTypes := config.NerfTrainingConfig.OutputTypes
-	s.logger.Debug("Output Types: ", outputTypes)
+	s.logger.Ctx(ctx).Debug("Output Types: ", outputTypes)
 	saveIterations := config.NerfTrainingConfig.SaveIterations
-	s.logger.Debug("Save Iterations: ", saveIterations)
+	s.logger.Ctx(ctx).Debug("Save Iterations: ", saveIterations)
 
-	saveDir := filepath.Join("data", "nerf", sceneID.Hex())
-	// Create the save directory if it doesn't exist
-	err = os.MkdirAll(saveDir, os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("failed to create save directory: %v", err)
-	}
+	latestIteration := 0
+	var savedBytes int64
 
 	for outputType, outputTypeURLs := range data.FilePaths {
 
-		// Create the type save directory if it doesn't exist
-		typeSaveDir := filepath.Join(saveDir, outputType)
-		err = os.MkdirAll(typeSaveDir, os.ModePerm)
-		if err != nil {
-			return fmt.Errorf("failed to create save directory for type %s: %v", outputType, err)
-		}
-
 		if !slices.Contains(outputTypes, outputType) {
 			return fmt.Errorf("output type unwanted by config: %s", outputType)
 		}
 
 		for iteration, URL := range outputTypeURLs {
 
-			// Create the iteration save directory if it doesn't exist
-			iterSaveDir := filepath.Join(typeSaveDir, fmt.Sprintf("iteration_%d", iteration))
-			err = os.MkdirAll(iterSaveDir, os.ModePerm)
-			if err != nil {
-				return fmt.Errorf("failed to create save directory for output/iteration %d: %v", iteration, err)
-			}
-
 			if !slices.Contains(saveIterations, iteration) {
 				return fmt.Errorf("iteration unwanted by config: %d", iteration)
 			}
 
-			// Download and save the file
-			resp, err := http.Get(URL)
-			if err != nil {
-				return fmt.Errorf("error downloading file: %v", err)
-			}
-			defer resp.Body.Close()
-
-			fileName := filepath.Base(URL)
-			filePath := filepath.Join(iterSaveDir, fileName)
-			file, err := os.Create(filePath)
-			if err != nil {
-				return fmt.Errorf("error creating file: %v", err)
-			}
-			defer file.Close()
-
-			_, err = io.Copy(file, resp.Body)
-			if err != nil {
-				return fmt.Errorf("error saving file: %v", err)
+			var filePath, checksum string
+			if URL == "" {
+				// Uploaded through the worker API, see WorkerService
+				filePath, checksum, err = uploadedOutput(currentScene.Nerf, outputType, iteration)
+				if err != nil {
+					return err
+				}
+			} else {
+				var written int64
+				filePath, checksum, written, err = s.downloadOutput(sceneID, currentScene.CurrentVersion(), outputType, iteration, URL)
+				if err != nil {
+					return err
+				}
+				savedBytes += written
+				s.metrics.OutputBytesTotal.WithLabelValues(outputType).Add(float64(written))
 			}
 
 			switch outputType {
@@ -554,28 +963,123 @@ func (s *AMPQService) processNERFJob(msg amqp.Delivery) error {
 					nerf.ModelFilePathsMap = make(map[int]string)
 				}
 				nerf.ModelFilePathsMap[iteration] = filePath
+			case scene.OutputTypeCheckpoint:
+				if nerf.CheckpointFilePathsMap == nil {
+					nerf.CheckpointFilePathsMap = make(map[int]string)
+					nerf.CheckpointChecksums = make(map[int]string)
+				}
+				nerf.CheckpointFilePathsMap[iteration] = filePath
+				nerf.CheckpointChecksums[iteration] = checksum
 			default:
-				s.logger.Errorf("Unexpected output type: %v. Orphaned file now in system", outputType)
+				s.logger.Ctx(ctx).Errorf("Unexpected output type: %v. Orphaned file now in system", outputType)
 			}
 
-			s.logger.Debug("File saved at ", filePath)
+			if iteration > latestIteration {
+				latestIteration = iteration
+			}
+
+			s.logger.Ctx(ctx).Debug("File saved at ", filePath)
+		}
+	}
+
+	// The outputs, the storage charge, the completion, and the queue removals are committed together. A redelivered
+	// message for a scene that already completed fails the transition, so its outputs are never charged twice.
+	err = s.sceneManager.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.sceneManager.SetNerf(ctx, sceneID, nerf); err != nil {
+			return fmt.Errorf("failed to set Nerf: %v", err)
+		}
+		if err := s.sceneManager.SetLatestIteration(ctx, sceneID, latestIteration); err != nil {
+			return fmt.Errorf("failed to set latest iteration: %v", err)
+		}
+		if err := s.sceneManager.TransitionStatus(ctx, sceneID, scene.StateCompleted, ""); err != nil {
+			return err
+		}
+		if err := s.chargeStorageUsage(ctx, sceneID, savedBytes); err != nil {
+			return err
 		}
+		return removeFromQueues(ctx, s.queueManager, sceneID, queue.NerfListID, queue.QueueListID)
+	})
+	if errors.Is(err, scene.ErrInvalidStatusTransition) {
+		s.logger.Ctx(ctx).Infof("Dropping NERF output for scene %s, which is no longer training", sceneID.Hex())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.sceneCache.InvalidateOutputs(ctx, sceneID)
+	s.storeOutputs(ctx, sceneID, nerf)
+
+	s.statusEvents.publish(sceneID, JobStatusEvent{Type: StatusEventStatus, State: scene.StateCompleted, Time: time.Now()})
+	s.NotifySlotFreed()
+	s.recordProcessingDurations(ctx, sceneID)
+	s.metrics.JobsCompletedTotal.WithLabelValues(trainingModeOf(currentScene)).Inc()
+
+	return nil
+}
+
+// downloadOutput downloads an output file of a training run of a scene from the nerf worker, and saves it at its
+// output path.
+//
+// Returns the path of the saved file, its hex encoded SHA-256, and its size.
+func (s *AMPQService) downloadOutput(sceneID primitive.ObjectID, version int, outputType string, iteration int, URL string) (string, string, int64, error) {
+	filePath, err := s.sceneManager.OutputPath(sceneID, version, outputType, iteration, filepath.Base(URL))
+	if err != nil {
+		return "", "", 0, fmt.Errorf("invalid output path: %v", err)
+	}
+	checksum, written, err := downloadFile(filePath, URL)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to download output/iteration %d: %v", iteration, err)
 	}
+	return filePath, checksum, written, nil
+}
 
-	err = s.sceneManager.SetNerf(ctx, sceneID, nerf)
+// downloadFile downloads the file at URL to filePath, creating its directory if it doesn't exist.
+// Returns the hex SHA-256 of the file, and the number of bytes written. A response that is not 2xx is returned as an
+// error before anything is written, so an error page is never saved as the file.
+func downloadFile(filePath, URL string) (string, int64, error) {
+	// Create the save directory if it doesn't exist
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
 	if err != nil {
-		return fmt.Errorf("failed to set Nerf: %v", err)
+		return "", 0, fmt.Errorf("failed to create save directory: %v", err)
 	}
 
-	err = s.queueManager.DeleteFromQueue(ctx, "nerf_list", sceneID)
+	// Download and save the file
+	resp, err := http.Get(URL)
 	if err != nil {
-		return fmt.Errorf("failed to pop from nerf_list: %v", err)
+		return "", 0, fmt.Errorf("error downloading file: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", 0, fmt.Errorf("error downloading file: worker responded with status %d: %s", resp.StatusCode, msg)
 	}
 
-	err = s.queueManager.DeleteFromQueue(ctx, "queue_list", sceneID)
+	file, err := os.Create(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to pop from queue_list: %v", err)
+		return "", 0, fmt.Errorf("error creating file: %v", err)
 	}
+	defer file.Close()
 
-	return nil
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hasher), resp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("error saving file: %v", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), written, nil
+}
+
+// uploadedOutput returns the path and checksum recorded for an output a worker uploaded through the worker API.
+// Its size was already charged to the scene's owner when the upload completed.
+func uploadedOutput(nerf *scene.Nerf, outputType string, iteration int) (string, string, error) {
+	if nerf == nil {
+		return "", "", fmt.Errorf("output %s/%d was not uploaded", outputType, iteration)
+	}
+	filePath, err := nerf.GetFilePathForTypeAndIter(outputType, iteration)
+	if err != nil || filePath == "" {
+		return "", "", fmt.Errorf("output %s/%d was not uploaded", outputType, iteration)
+	}
+	if outputType != scene.OutputTypeCheckpoint {
+		return filePath, "", nil
+	}
+	return filePath, nerf.CheckpointChecksums[iteration], nil
 }
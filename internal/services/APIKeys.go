@@ -0,0 +1,154 @@
+// This file contains the API keys scripts authenticate with in the X-API-Key header, in place of logging in.
+//
+// A key is only shown once, when it is created. Keys are random, so unlike passwords they are hashed with a single
+// SHA-256 rather than bcrypt, which lets them be looked up by hash. Each key is limited to its scopes, see
+// user.APIKey.HasScope, and keys cannot manage the account or other keys, which always requires logging in.
+
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrTooManyAPIKeys is returned when creating an API key for a user that has maxAPIKeysPerUser keys.
+	ErrTooManyAPIKeys = errors.New("too many API keys, revoke one first")
+)
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to recognize.
+const apiKeyPrefix = "vgn_"
+
+// apiKeyDisplayLength is the length of the start of a key that is stored, to tell keys apart.
+const apiKeyDisplayLength = len(apiKeyPrefix) + 8
+
+// maxAPIKeysPerUser is the most API keys a user may have at once.
+const maxAPIKeysPerUser = 20
+
+// CreatedAPIKey is a newly created API key. Key is the only copy of the key, and cannot be retrieved again.
+type CreatedAPIKey struct {
+	user.APIKey
+	Key string `json:"key"`
+}
+
+// hashAPIKey returns the hex SHA-256 of an API key, as stored in the user document.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey creates an API key with the given name and scopes for the user with the given ID.
+//
+// Returns (nil, ErrValidation) if a scope is not valid or none is given, (nil, ErrTooManyAPIKeys) if the user has
+// too many keys, or (nil, error) if an error occurred.
+func (s *ClientService) CreateAPIKey(ctx context.Context, userID primitive.ObjectID, name string, scopes []string) (_ *CreatedAPIKey, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.CreateAPIKey", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Create API key request received")
+
+	if len(scopes) == 0 {
+		return nil, NewValidationError("no scopes given", map[string]string{"scopes": "must not be empty"}, nil)
+	}
+	for _, scope := range scopes {
+		if !user.IsValidAPIKeyScope(scope) {
+			return nil, NewValidationError(
+				"invalid API key scope",
+				map[string]string{"scopes": "must be " + strings.Join(user.ValidAPIKeyScopes, ", ")},
+				nil,
+			)
+		}
+	}
+
+	keys, err := s.userManager.GetAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) >= maxAPIKeysPerUser {
+		return nil, ErrTooManyAPIKeys
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(b)
+	created := &CreatedAPIKey{
+		APIKey: user.APIKey{
+			ID:        primitive.NewObjectID(),
+			Name:      strings.TrimSpace(name),
+			Prefix:    key[:apiKeyDisplayLength],
+			Hash:      hashAPIKey(key),
+			Scopes:    scopes,
+			CreatedAt: time.Now(),
+		},
+		Key: key,
+	}
+	if err := s.userManager.AddAPIKey(ctx, userID, &created.APIKey); err != nil {
+		return nil, err
+	}
+
+	s.logger.Ctx(ctx).Infof("Created API key %s for user %s with scopes %v", created.ID.Hex(), userID.Hex(), scopes)
+	return created, nil
+}
+
+// ListAPIKeys returns the API keys of the user with the given ID. The keys themselves are not included.
+func (s *ClientService) ListAPIKeys(ctx context.Context, userID primitive.ObjectID) (_ []user.APIKey, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.ListAPIKeys", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	return s.userManager.GetAPIKeys(ctx, userID)
+}
+
+// RevokeAPIKey revokes an API key of the user with the given ID. Requests using it are refused from then on.
+//
+// Returns user.ErrAPIKeyNotFound if the user has no key with the given ID.
+func (s *ClientService) RevokeAPIKey(ctx context.Context, userID, keyID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RevokeAPIKey", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.userManager.RevokeAPIKey(ctx, userID, keyID); err != nil {
+		return err
+	}
+	s.logger.Ctx(ctx).Infof("Revoked API key %s of user %s", keyID.Hex(), userID.Hex())
+	return nil
+}
+
+// VerifyAPIKey checks that an API key is valid and has the given scope, and returns the ID of the user it belongs to.
+//
+// Returns user.ErrInvalidAPIKey if the key matches no user, user.ErrAPIKeyScope if the key does not have the scope,
+// user.ErrAccountDisabled if the account is disabled, or ErrEmailNotVerified if the user must verify their email before
+// logging in.
+func (s *ClientService) VerifyAPIKey(ctx context.Context, key, scope string) (_ primitive.ObjectID, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.VerifyAPIKey", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return primitive.NilObjectID, user.ErrInvalidAPIKey
+	}
+	u, apiKey, err := s.userManager.AuthenticateAPIKey(ctx, hashAPIKey(key))
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if u.Disabled {
+		return primitive.NilObjectID, user.ErrAccountDisabled
+	}
+	if !apiKey.HasScope(scope) {
+		return primitive.NilObjectID, user.ErrAPIKeyScope
+	}
+	if s.verification.RequireForLogin && !u.IsVerified() {
+		return primitive.NilObjectID, ErrEmailNotVerified
+	}
+	return u.ID, nil
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+)
+
+func TestAPIKeys(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := newTestUser(t, s, "alice")
+	bob := newTestUser(t, s, "bob")
+
+	if _, err := s.CreateAPIKey(ctx, alice.ID, "ci", nil); !errors.Is(err, ErrValidation) {
+		t.Errorf("no scopes: got %v, want ErrValidation", err)
+	}
+	if _, err := s.CreateAPIKey(ctx, alice.ID, "ci", []string{"everything"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("invalid scope: got %v, want ErrValidation", err)
+	}
+
+	created, err := s.CreateAPIKey(ctx, alice.ID, " ci ", []string{user.APIKeyScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Name != "ci" || !strings.HasPrefix(created.Key, created.Prefix) || created.Hash == created.Key {
+		t.Errorf("created key: %+v", created)
+	}
+	keys, err := s.ListAPIKeys(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].ID != created.ID {
+		t.Errorf("ListAPIKeys() = %+v, want the created key", keys)
+	}
+
+	userID, err := s.VerifyAPIKey(ctx, created.Key, user.APIKeyScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != alice.ID {
+		t.Errorf("key verified for user %s, want %s", userID.Hex(), alice.ID.Hex())
+	}
+	if _, err := s.VerifyAPIKey(ctx, created.Key, user.APIKeyScopeUpload); !errors.Is(err, ErrForbidden) {
+		t.Errorf("scope the key does not have: got %v, want ErrForbidden", err)
+	}
+	if _, err := s.VerifyAPIKey(ctx, created.Key+"0", user.APIKeyScopeRead); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("unknown key: got %v, want ErrUnauthorized", err)
+	}
+
+	if err := s.RevokeAPIKey(ctx, bob.ID, created.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoking another user's key: got %v, want ErrNotFound", err)
+	}
+	if err := s.RevokeAPIKey(ctx, alice.ID, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.VerifyAPIKey(ctx, created.Key, user.APIKeyScopeRead); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("revoked key: got %v, want ErrUnauthorized", err)
+	}
+}
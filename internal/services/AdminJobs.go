@@ -0,0 +1,314 @@
+// This file contains the admin-only job management methods of the ClientService.
+//
+// Every method here checks the admin role first, and returns user.ErrUserNoAccess to anyone else. Unlike their user
+// counterparts, these work on any scene regardless of owner.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Page size limits for admin scene listings
+const (
+	DefaultAdminPageSize = 20
+	MaxAdminPageSize     = 100
+)
+
+// AdminSceneQuery selects a page of scenes for an admin listing. Zero filter fields do not filter.
+// Page is 1-indexed.
+type AdminSceneQuery struct {
+	State    scene.State
+	Owner    string
+	Page     int
+	PageSize int
+}
+
+// AdminSceneSummary is a single scene in an admin listing.
+type AdminSceneSummary struct {
+	ID            string             `json:"id"`
+	Name          string             `json:"name"`
+	TrainingMode  string             `json:"training_mode"`
+	Priority      string             `json:"priority"`
+	Tier          string             `json:"tier"`
+	Status        *scene.SceneStatus `json:"status,omitempty"`
+	OwnerID       string             `json:"owner_id,omitempty"`
+	OwnerUsername string             `json:"owner_username,omitempty"`
+	TrashedAt     *time.Time         `json:"trashed_at,omitempty"`
+}
+
+// AdminScenePage is a page of an admin scene listing.
+type AdminScenePage struct {
+	Scenes   []AdminSceneSummary `json:"scenes"`
+	Page     int                 `json:"page"`
+	PageSize int                 `json:"page_size"`
+	Total    int64               `json:"total"`
+}
+
+// AdminListScenes returns a page of scenes across all users, newest first, with their status and owner.
+// Scenes can be filtered by state and by owner username. Scenes in the trash are listed too.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin, or user.ErrUserNotFound if the owner filter matches no user.
+func (s *ClientService) AdminListScenes(ctx context.Context, adminUserID primitive.ObjectID, query AdminSceneQuery) (_ *AdminScenePage, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminListScenes", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return nil, err
+	}
+
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PageSize < 1 {
+		query.PageSize = DefaultAdminPageSize
+	}
+	query.PageSize = min(query.PageSize, MaxAdminPageSize)
+
+	filter := scene.SceneListFilter{State: query.State, Trash: scene.TrashIncluded}
+	if query.Owner != "" {
+		owner, err := s.userManager.GetUserByUsername(ctx, query.Owner)
+		if err != nil {
+			return nil, err
+		}
+		filter.IDs = append(make([]primitive.ObjectID, 0), owner.SceneIDs...)
+	}
+
+	skip := int64(query.Page-1) * int64(query.PageSize)
+	scenes, total, err := s.sceneManager.ListScenes(ctx, filter, skip, int64(query.PageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	sceneIDs := make([]primitive.ObjectID, 0, len(scenes))
+	for _, sc := range scenes {
+		sceneIDs = append(sceneIDs, sc.ID)
+	}
+	owners, err := s.userManager.GetOwnersOfScenes(ctx, sceneIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &AdminScenePage{
+		Scenes:   make([]AdminSceneSummary, 0, len(scenes)),
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	}
+	for _, sc := range scenes {
+		summary := AdminSceneSummary{
+			ID:           sc.ID.Hex(),
+			Name:         sc.Name,
+			TrainingMode: trainingModeOf(sc),
+			Priority:     scene.PriorityNormal,
+			Tier:         sc.Config.JobTier(),
+			Status:       sc.Status,
+			TrashedAt:    sc.TrashedAt,
+		}
+		if sc.Config != nil && sc.Config.Priority != "" {
+			summary.Priority = sc.Config.Priority
+		}
+		if owner, ok := owners[sc.ID]; ok {
+			summary.OwnerID = owner.ID.Hex()
+			summary.OwnerUsername = owner.Username
+		}
+		page.Scenes = append(page.Scenes, summary)
+	}
+
+	return page, nil
+}
+
+// AdminRequeueJob republishes the job for the current stage of any processing scene.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin, or scene.ErrInvalidStatusTransition if the scene is not processing.
+func (s *ClientService) AdminRequeueJob(ctx context.Context, adminUserID, sceneID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminRequeueJob", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return err
+	}
+
+	if err := s.mqService.RequeueJob(ctx, sceneID); err != nil {
+		s.logger.Ctx(ctx).Info("Failed to requeue job:", err.Error())
+		return err
+	}
+
+	s.audit.Record(ctx, adminUserID, sceneID, audit.ActionAdminRequeueJob, audit.OutcomeAllowed, "")
+	s.logger.Ctx(ctx).Infof("Admin %s requeued job for scene %s", adminUserID.Hex(), sceneID.Hex())
+	return nil
+}
+
+// AdminBumpJob moves any processing scene to the priority tier at high priority, and republishes the job for its
+// current stage there, i.e when it is stuck behind a backlog of a lower tier. The high priority limit does not apply.
+// Like AdminRequeueJob, the job already published is left in its queue.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin, or scene.ErrInvalidStatusTransition if the scene is not processing.
+func (s *ClientService) AdminBumpJob(ctx context.Context, adminUserID, sceneID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminBumpJob", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return err
+	}
+
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil {
+		return err
+	}
+	if status.State.IsTerminal() {
+		return fmt.Errorf("%w: scene is %s", scene.ErrInvalidStatusTransition, status.State)
+	}
+
+	config, err := s.sceneManager.GetTrainingConfig(ctx, sceneID)
+	if err != nil {
+		return err
+	}
+	config.Priority = scene.PriorityHigh
+	config.Tier = broker.TierPriority
+	if err := s.sceneManager.SetTrainingConfig(ctx, sceneID, config); err != nil {
+		return err
+	}
+	s.sceneCache.InvalidateScene(ctx, sceneID)
+
+	if err := s.mqService.RequeueJob(ctx, sceneID); err != nil {
+		s.logger.Ctx(ctx).Info("Failed to bump job:", err.Error())
+		return err
+	}
+
+	s.audit.Record(ctx, adminUserID, sceneID, audit.ActionAdminBumpJob, audit.OutcomeAllowed, "")
+	s.logger.Ctx(ctx).Infof("Admin %s bumped job for scene %s to the priority tier", adminUserID.Hex(), sceneID.Hex())
+	return nil
+}
+
+// AdminCancelJob cancels the processing of any scene.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin, or scene.ErrInvalidStatusTransition if the scene is not processing.
+func (s *ClientService) AdminCancelJob(ctx context.Context, adminUserID, sceneID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminCancelJob", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return err
+	}
+
+	if err := s.cancelScene(ctx, sceneID); err != nil {
+		s.logger.Ctx(ctx).Info("Failed to cancel job:", err.Error())
+		return err
+	}
+
+	s.audit.Record(ctx, adminUserID, sceneID, audit.ActionAdminCancelJob, audit.OutcomeAllowed, "")
+	s.logger.Ctx(ctx).Infof("Admin %s cancelled job for scene %s", adminUserID.Hex(), sceneID.Hex())
+	return nil
+}
+
+// AdminDeleteScene permanently deletes any scene. Unlike DeleteScene, a processing scene is cancelled first.
+//
+// Returns the number of bytes reclaimed. Returns user.ErrUserNoAccess if the user is not an admin.
+func (s *ClientService) AdminDeleteScene(ctx context.Context, adminUserID, sceneID primitive.ObjectID) (_ int64, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminDeleteScene", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return 0, err
+	}
+
+	if _, err := s.sceneManager.GetStatus(ctx, sceneID); errors.Is(err, scene.ErrSceneNotFound) {
+		return 0, err
+	}
+
+	bytes, err := s.deleteOwnedScene(ctx, sceneID, true)
+	if err != nil {
+		return 0, err
+	}
+
+	s.audit.Record(ctx, adminUserID, sceneID, audit.ActionAdminDeleteScene, audit.OutcomeAllowed, "")
+	s.logger.Ctx(ctx).Infof("Admin %s deleted scene %s", adminUserID.Hex(), sceneID.Hex())
+	return bytes, nil
+}
+
+// AdminReconcileStorage finds files with no scene document and scene documents whose files are missing, and unless
+// dryRun is set removes them. Removed scenes are also removed from their owners.
+// Storage counters are not adjusted, ReconcileAllQuotas should be run afterwards to correct them.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin.
+func (s *ClientService) AdminReconcileStorage(ctx context.Context, adminUserID primitive.ObjectID, dryRun bool) (_ *scene.ReconcileReport, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminReconcileStorage", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return nil, err
+	}
+
+	report, err := s.sceneManager.Reconcile(ctx, scene.ReconcileOptions{DryRun: dryRun})
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to reconcile storage: %v", err)
+		return nil, err
+	}
+
+	s.audit.Record(ctx, adminUserID, primitive.NilObjectID, audit.ActionAdminReconcile, audit.OutcomeAllowed, fmt.Sprintf("storage, dry run: %t", dryRun))
+
+	for _, orphan := range report.OrphanedScenes {
+		if !orphan.Removed {
+			continue
+		}
+		owner, err := s.userManager.GetUserBySceneID(ctx, orphan.SceneID)
+		if err != nil {
+			continue
+		}
+		if err := owner.RemoveScene(orphan.SceneID); err == nil {
+			if err := s.userManager.UpdateUser(ctx, owner); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// AdminGetAdmission returns the admission cap, and the number of jobs in flight and scenes pending admission.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin.
+func (s *ClientService) AdminGetAdmission(ctx context.Context, adminUserID primitive.ObjectID) (_ *AdmissionStatus, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminGetAdmission", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return nil, err
+	}
+
+	return s.mqService.AdmissionStatus(ctx)
+}
+
+// AdminSetMaxInFlightJobs changes the most jobs in flight at once, without a restart. A value <= 0 does not limit
+// them. The change is not persisted, and a restart reverts to the configured cap.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin.
+func (s *ClientService) AdminSetMaxInFlightJobs(ctx context.Context, adminUserID primitive.ObjectID, limit int64) (_ *AdmissionStatus, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminSetMaxInFlightJobs", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return nil, err
+	}
+
+	s.mqService.SetMaxInFlightJobs(limit)
+	s.audit.Record(ctx, adminUserID, primitive.NilObjectID, audit.ActionAdminSetAdmission, audit.OutcomeAllowed, fmt.Sprintf("max in flight jobs: %d", limit))
+	s.logger.Ctx(ctx).Infof("Admin %s set max in flight jobs to %d", adminUserID.Hex(), limit)
+	return s.mqService.AdmissionStatus(ctx)
+}
@@ -0,0 +1,218 @@
+// This file contains the admin-only account management methods of the ClientService, and the queue overview.
+//
+// Like those in AdminJobs.go, every method here checks the admin role first, and returns user.ErrUserNoAccess to
+// anyone else.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrCannotDisableSelf is returned when an admin disables their own account, which would lock them out.
+	ErrCannotDisableSelf = errors.New("cannot disable your own account")
+)
+
+// AdminUserQuery selects a page of users for an admin listing. Page is 1-indexed.
+type AdminUserQuery struct {
+	Page     int
+	PageSize int
+}
+
+// AdminUserSummary is a single user in an admin listing.
+type AdminUserSummary struct {
+	ID             string               `json:"id"`
+	Username       string               `json:"username"`
+	Role           string               `json:"role"`
+	Tier           string               `json:"tier"`
+	Verified       bool                 `json:"verified"`
+	Disabled       bool                 `json:"disabled"`
+	SceneCount     int                  `json:"scene_count"`
+	StorageUsed    int64                `json:"storage_used"`
+	APIKeyCount    int                  `json:"api_key_count"`
+	QuotaOverrides *user.QuotaOverrides `json:"quota_overrides,omitempty"`
+}
+
+// AdminUserPage is a page of an admin user listing.
+type AdminUserPage struct {
+	Users    []AdminUserSummary `json:"users"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"page_size"`
+	Total    int64              `json:"total"`
+}
+
+// RequireAdmin checks that the user with the given ID is an admin whose account is not disabled.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin, or error if an error occurred.
+func (s *ClientService) RequireAdmin(ctx context.Context, userID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	return s.verifyAdmin(ctx, userID)
+}
+
+// AdminListUsers returns a page of users, newest first.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin.
+func (s *ClientService) AdminListUsers(ctx context.Context, adminUserID primitive.ObjectID, query AdminUserQuery) (_ *AdminUserPage, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminListUsers", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return nil, err
+	}
+
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PageSize < 1 {
+		query.PageSize = DefaultAdminPageSize
+	}
+	query.PageSize = min(query.PageSize, MaxAdminPageSize)
+
+	skip := int64(query.Page-1) * int64(query.PageSize)
+	users, total, err := s.userManager.ListUsers(ctx, skip, int64(query.PageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	page := &AdminUserPage{
+		Users:    make([]AdminUserSummary, 0, len(users)),
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Total:    total,
+	}
+	for _, u := range users {
+		role := u.Role
+		if role == "" {
+			role = user.RoleUser
+		}
+		page.Users = append(page.Users, AdminUserSummary{
+			ID:             u.ID.Hex(),
+			Username:       u.Username,
+			Role:           role,
+			Tier:           u.JobTier(),
+			Verified:       u.IsVerified(),
+			Disabled:       u.Disabled,
+			SceneCount:     len(u.SceneIDs),
+			StorageUsed:    u.StorageUsed,
+			APIKeyCount:    len(u.APIKeys),
+			QuotaOverrides: u.QuotaOverrides,
+		})
+	}
+	return page, nil
+}
+
+// AdminSetUserDisabled disables or re-enables the account of any user. A disabled account cannot log in, and its
+// refresh tokens are revoked. Its access tokens, API keys and upload tokens are refused right away, or within the TTL
+// of the SceneCache on other servers, see VerifySession. Scenes of the account are kept, and its jobs keep processing.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin, ErrCannotDisableSelf if the admin disables their own
+// account, or user.ErrUserNotFound if the user does not exist.
+func (s *ClientService) AdminSetUserDisabled(ctx context.Context, adminUserID, userID primitive.ObjectID, disabled bool) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminSetUserDisabled", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return err
+	}
+	if disabled && userID == adminUserID {
+		return ErrCannotDisableSelf
+	}
+
+	if err := s.userManager.SetDisabled(ctx, userID, disabled); err != nil {
+		return err
+	}
+	if disabled {
+		s.sceneCache.delete(ctx, accountActiveKey(userID))
+		if err := s.tokenManager.DeleteUserTokens(ctx, userID); err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to delete refresh tokens of disabled user %s: %v", userID.Hex(), err)
+			return err
+		}
+	}
+
+	action := audit.ActionAdminEnableUser
+	if disabled {
+		action = audit.ActionAdminDisableUser
+	}
+	s.audit.RecordOnUser(ctx, adminUserID, userID, action, audit.OutcomeAllowed, "")
+	s.logger.Ctx(ctx).Infof("Admin %s set user %s disabled: %t", adminUserID.Hex(), userID.Hex(), disabled)
+	return nil
+}
+
+// AdminSetUserQuota replaces the quota overrides of any user, and returns the user's resulting quota. Nil fields keep
+// the server's limits, so empty overrides reset the user to them.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin, or user.ErrUserNotFound if the user does not exist.
+func (s *ClientService) AdminSetUserQuota(ctx context.Context, adminUserID, userID primitive.ObjectID, overrides *user.QuotaOverrides) (_ *Quota, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminSetUserQuota", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return nil, err
+	}
+
+	if err := s.userManager.SetQuotaOverrides(ctx, userID, overrides); err != nil {
+		return nil, err
+	}
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.RecordOnUser(ctx, adminUserID, userID, audit.ActionAdminSetQuota, audit.OutcomeAllowed, "")
+	s.logger.Ctx(ctx).Infof("Admin %s set quota overrides of user %s", adminUserID.Hex(), userID.Hex())
+	return s.quotaOf(ctx, u)
+}
+
+// AdminSetUserTier sets the job tier of any user, which the jobs of their new scenes are published to. Scenes already
+// processing keep their tier, AdminBumpJob moves one to the priority tier.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin, a validation error if the tier is not one of broker.Tiers,
+// or user.ErrUserNotFound if the user does not exist.
+func (s *ClientService) AdminSetUserTier(ctx context.Context, adminUserID, userID primitive.ObjectID, tier string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminSetUserTier", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return err
+	}
+	if !broker.IsValidTier(tier) {
+		return NewValidationError(
+			"invalid job tier",
+			map[string]string{"tier": "must be one of " + strings.Join(broker.Tiers, ", ")},
+			nil,
+		)
+	}
+
+	if err := s.userManager.SetTier(ctx, userID, tier); err != nil {
+		return err
+	}
+
+	s.audit.RecordOnUser(ctx, adminUserID, userID, audit.ActionAdminSetTier, audit.OutcomeAllowed, tier)
+	s.logger.Ctx(ctx).Infof("Admin %s set tier of user %s to %s", adminUserID.Hex(), userID.Hex(), tier)
+	return nil
+}
+
+// AdminGetQueues returns the depth of the broker queues and processing queue lists, the jobs waiting in the outbox,
+// and the state of admission control.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin.
+func (s *ClientService) AdminGetQueues(ctx context.Context, adminUserID primitive.ObjectID) (_ *QueueStatus, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AdminGetQueues", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return nil, err
+	}
+
+	return s.mqService.QueueStatus(ctx)
+}
@@ -0,0 +1,298 @@
+// This file contains admission control of sfm jobs, which caps the number of jobs in flight on the worker fleet.
+//
+// A job is in flight from the moment it is published until its scene reaches a terminal state, which is tracked
+// through the scene status (sfm_running, sfm_done, and training). When at capacity, new scenes wait in
+// scene.StatePendingAdmission and are published, highest priority then oldest first, as slots free up. Slots are
+// freed when the AMPQService sees a scene reach a terminal state, and are also rechecked every admissionInterval so
+// transitions made elsewhere (i.e a cancellation) are never missed. With AdmissionConfig.RejectWhenFull set, uploads
+// are rejected up front instead of waiting.
+//
+// The cap can be changed at runtime with SetMaxInFlightJobs. Admission assumes a single web server publishes jobs.
+//
+// Admission control also retries jobs that failed to publish, i.e while MongoDB is unreachable. Such scenes are
+// deferred to scene.StatePendingAdmission, and only marked as failed if publishing keeps failing for
+// publishRetryWindow. Failures are tracked in memory, so a restart gives deferred scenes a new window. Jobs the broker
+// does not accept are retried by the outbox dispatcher instead, see Outbox.go. Scenes with pre-computed sfm output
+// skip admission, and the training jobs of those that failed to publish are retried on their own, see
+// DeferTrainingJob.
+
+package services
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+var (
+	// ErrAtCapacity is returned when an upload is rejected because the most jobs allowed are in flight.
+	ErrAtCapacity = errors.New("processing capacity reached, try again later")
+)
+
+// DefaultAdmissionRetryAfter is used when AdmissionConfig.RetryAfter is not set.
+const DefaultAdmissionRetryAfter = time.Minute
+
+// admissionInterval is how often pending scenes are rechecked for a free slot, besides when a job finishes.
+const admissionInterval = 15 * time.Second
+
+// publishRetryWindow is how long the job of a deferred scene is retried before the scene is marked as failed.
+const publishRetryWindow = 10 * time.Minute
+
+// trainingRetryDelay is how long a deferred training job waits before it is published again, see DeferTrainingJob.
+const trainingRetryDelay = 15 * time.Second
+
+// inFlightStates are the states of scenes whose job has been published but has not finished.
+var inFlightStates = []scene.State{scene.StateSfmRunning, scene.StateSfmDone, scene.StateTraining}
+
+// AdmissionConfig configures admission control of sfm jobs.
+type AdmissionConfig struct {
+	// most jobs in flight at once, <= 0 does not limit them
+	MaxInFlightJobs int64
+	// reject uploads while at capacity, instead of holding them until a slot frees
+	RejectWhenFull bool
+	// how long clients are told to wait before retrying a rejected upload
+	RetryAfter time.Duration
+}
+
+// AdmissionStatus is the current state of admission control, for the admin view.
+type AdmissionStatus struct {
+	MaxInFlightJobs int64 `json:"max_in_flight_jobs"`
+	RejectWhenFull  bool  `json:"reject_when_full"`
+	InFlight        int64 `json:"in_flight"`
+	Pending         int64 `json:"pending"`
+}
+
+// SetMaxInFlightJobs changes the most jobs in flight at once. A value <= 0 does not limit them.
+// Raising the cap admits pending scenes right away.
+func (s *AMPQService) SetMaxInFlightJobs(limit int64) {
+	s.maxInFlightJobs.Store(limit)
+	s.logger.Infof("Max in flight jobs set to %d", limit)
+	s.NotifySlotFreed()
+}
+
+// NotifySlotFreed wakes admission control to admit pending scenes, i.e after a scene was cancelled.
+func (s *AMPQService) NotifySlotFreed() {
+	select {
+	case s.slotFreed <- struct{}{}:
+	default:
+	}
+}
+
+// AdmissionStatus returns the admission cap, and the number of jobs in flight and scenes pending admission.
+func (s *AMPQService) AdmissionStatus(ctx context.Context) (*AdmissionStatus, error) {
+	inFlight, err := s.sceneManager.CountInStates(ctx, inFlightStates...)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.sceneManager.CountInStates(ctx, scene.StatePendingAdmission)
+	if err != nil {
+		return nil, err
+	}
+	return &AdmissionStatus{
+		MaxInFlightJobs: s.maxInFlightJobs.Load(),
+		RejectWhenFull:  s.admission.RejectWhenFull,
+		InFlight:        inFlight,
+		Pending:         pending,
+	}, nil
+}
+
+// CheckAdmission returns ErrAtCapacity, with the time to wait before retrying, if uploads are rejected while at
+// capacity and the most jobs allowed are in flight.
+func (s *AMPQService) CheckAdmission(ctx context.Context) error {
+	if !s.admission.RejectWhenFull {
+		return nil
+	}
+	full, err := s.atCapacity(ctx)
+	if err != nil || !full {
+		return err
+	}
+
+	retryAfter := s.admission.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = DefaultAdmissionRetryAfter
+	}
+	e := newError(ErrUpstream, ErrAtCapacity.Error(), ErrAtCapacity)
+	e.RetryAfter = retryAfter
+	return e
+}
+
+// atCapacity reports whether the most jobs allowed are in flight.
+func (s *AMPQService) atCapacity(ctx context.Context) (bool, error) {
+	limit := s.maxInFlightJobs.Load()
+	if limit <= 0 {
+		return false, nil
+	}
+	inFlight, err := s.sceneManager.CountInStates(ctx, inFlightStates...)
+	if err != nil {
+		return false, err
+	}
+	return inFlight >= limit, nil
+}
+
+// admitSFMJob publishes the sfm job of a scene in the grace period or queued, if a slot is free. Otherwise the scene
+// is moved to scene.StatePendingAdmission, to be published by admitPending once a slot frees.
+func (s *AMPQService) admitSFMJob(ctx context.Context, sceneID primitive.ObjectID) error {
+	s.admissionMu.Lock()
+	defer s.admissionMu.Unlock()
+
+	full, err := s.atCapacity(ctx)
+	if err != nil {
+		return err
+	}
+	if full {
+		if err := s.sceneManager.TransitionStatus(ctx, sceneID, scene.StatePendingAdmission, ""); err != nil {
+			return err
+		}
+		s.logger.Ctx(ctx).Infof("At capacity, SFM job for scene %s pending admission", sceneID.Hex())
+		return nil
+	}
+	return s.publishAdmitted(ctx, sceneID)
+}
+
+// publishAdmitted moves a scene to scene.StateQueued and publishes its sfm job. The caller must hold admissionMu.
+//
+// The transition to queued is atomic, so a scene cancelled while waiting is rejected and never reaches a worker.
+func (s *AMPQService) publishAdmitted(ctx context.Context, sceneID primitive.ObjectID) error {
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil {
+		return err
+	}
+	if status.State != scene.StateQueued {
+		if err := s.sceneManager.TransitionStatus(ctx, sceneID, scene.StateQueued, ""); err != nil {
+			return err
+		}
+	}
+
+	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return err
+	}
+	return s.PublishSFMJob(ctx, currentScene)
+}
+
+// DeferSFMJob moves a scene whose sfm job failed to publish to scene.StatePendingAdmission, so admission control
+// publishes it again on a later pass rather than leaving it stuck. The retry window starts at the first failure.
+func (s *AMPQService) DeferSFMJob(ctx context.Context, sceneID primitive.ObjectID) {
+	s.publishFailures.LoadOrStore(sceneID, time.Now())
+	s.transitionStatus(ctx, sceneID, scene.StatePendingAdmission, "")
+}
+
+// DeferTrainingJob publishes the training job of a scene with pre-computed sfm output again after trainingRetryDelay,
+// as the job failed to publish, see PublishTrainingJob. It is retried until it publishes, the scene leaves
+// scene.StateSfmDone (i.e is cancelled), or publishRetryWindow has passed since the first failure, after which the
+// scene is marked as failed.
+func (s *AMPQService) DeferTrainingJob(ctx context.Context, sceneID primitive.ObjectID) {
+	first, _ := s.publishFailures.LoadOrStore(sceneID, time.Now())
+	time.AfterFunc(trainingRetryDelay, func() {
+		ctx := context.Background()
+
+		currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
+		if errors.Is(err, scene.ErrSceneNotFound) {
+			s.publishFailures.Delete(sceneID)
+			return
+		}
+		if err == nil {
+			if currentScene.Status == nil || currentScene.Status.State != scene.StateSfmDone {
+				s.publishFailures.Delete(sceneID)
+				return
+			}
+			err = s.PublishTrainingJob(ctx, currentScene)
+		}
+		if err == nil {
+			s.publishFailures.Delete(sceneID)
+			s.logger.Infof("Published deferred training job for scene %s", sceneID.Hex())
+			return
+		}
+		if time.Since(first.(time.Time)) < publishRetryWindow {
+			s.logger.Errorf("Failed to publish training job for scene %s, retrying: %v", sceneID.Hex(), err)
+			s.DeferTrainingJob(ctx, sceneID)
+			return
+		}
+		s.publishFailures.Delete(sceneID)
+		s.logger.Errorf("Failed to publish training job for scene %s: %v", sceneID.Hex(), err)
+		s.metrics.JobsFailedTotal.WithLabelValues(metrics.StageTraining, trainingModeOf(currentScene)).Inc()
+		s.transitionStatus(ctx, sceneID, scene.StateFailed, fmt.Sprintf("failed to publish job: %v", err))
+	})
+}
+
+// runAdmission admits pending scenes whenever a slot may have freed, until the service is shut down.
+// Scenes left pending by a restart are admitted on the first pass.
+func (s *AMPQService) runAdmission() {
+	ticker := time.NewTicker(admissionInterval)
+	defer ticker.Stop()
+
+	for {
+		s.admitPending(context.Background())
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		case <-s.slotFreed:
+		}
+	}
+}
+
+// admitPending publishes the sfm jobs of pending scenes while slots are free, highest tier, then highest priority, then
+// oldest first.
+// Scenes whose job fails to publish are deferred to the next pass, and marked as failed once publishRetryWindow has
+// passed since their first failure. Either way the scenes behind them are still admitted.
+func (s *AMPQService) admitPending(ctx context.Context) {
+	s.admissionMu.Lock()
+	defer s.admissionMu.Unlock()
+
+	pending, err := s.sceneManager.GetScenesByState(ctx, scene.StatePendingAdmission)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to get scenes pending admission: %v", err)
+		return
+	}
+	slices.SortFunc(pending, func(a, b *scene.Scene) int {
+		if c := cmp.Compare(broker.TierRank(a.Config.JobTier()), broker.TierRank(b.Config.JobTier())); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Config.AMQPPriority(), a.Config.AMQPPriority()); c != 0 {
+			return c
+		}
+		return a.ID.Timestamp().Compare(b.ID.Timestamp())
+	})
+
+	for _, sc := range pending {
+		full, err := s.atCapacity(ctx)
+		if err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to count jobs in flight: %v", err)
+			return
+		}
+		if full {
+			return
+		}
+
+		err = s.publishAdmitted(ctx, sc.ID)
+		if errors.Is(err, scene.ErrInvalidStatusTransition) {
+			// Cancelled since it was listed
+			s.publishFailures.Delete(sc.ID)
+			continue
+		}
+		if err != nil {
+			first, _ := s.publishFailures.LoadOrStore(sc.ID, time.Now())
+			if time.Since(first.(time.Time)) < publishRetryWindow {
+				s.logger.Ctx(ctx).Errorf("Failed to publish SFM job for scene %s on admission, retrying: %v", sc.ID.Hex(), err)
+				s.transitionStatus(ctx, sc.ID, scene.StatePendingAdmission, "")
+				continue
+			}
+			s.publishFailures.Delete(sc.ID)
+			s.logger.Ctx(ctx).Errorf("Failed to publish SFM job for scene %s on admission: %v", sc.ID.Hex(), err)
+			s.transitionStatus(ctx, sc.ID, scene.StateFailed, fmt.Sprintf("failed to publish job: %v", err))
+			continue
+		}
+		s.publishFailures.Delete(sc.ID)
+		s.logger.Ctx(ctx).Infof("Admitted SFM job for scene %s", sc.ID.Hex())
+	}
+}
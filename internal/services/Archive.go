@@ -0,0 +1,222 @@
+// This file contains archives of every output of a scene, for downloading them all at once.
+//
+// An archive is never stored. GetSceneArchive resolves the output files on disk, and SceneArchive.Write generates the
+// ZIP or tar.gz from them as it is sent, so memory use does not grow with the size of the outputs. Files are laid out
+// as <output type>/<file name>, named as outputFileName names single downloads.
+
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Declarations for valid archive formats
+const (
+	ArchiveFormatZip   = "zip"
+	ArchiveFormatTarGz = "tar.gz"
+)
+
+// archiveContentTypes maps archive formats to their content types.
+var archiveContentTypes = map[string]string{
+	ArchiveFormatZip:   "application/zip",
+	ArchiveFormatTarGz: "application/gzip",
+}
+
+var (
+	// ErrUnsupportedArchiveFormat is returned when requesting an archive in a format that is not an archive format.
+	ErrUnsupportedArchiveFormat = errors.New("unsupported archive format")
+)
+
+// ArchiveEntry is a file of a SceneArchive.
+type ArchiveEntry struct {
+	// path of the file on disk
+	Path string
+	// path of the file in the archive
+	Name string
+}
+
+// SceneArchive is an archive of the outputs of a scene, see Write.
+type SceneArchive struct {
+	Format string
+	// human readable name to save the archive as
+	FileName    string
+	ContentType string
+	Entries     []ArchiveEntry
+}
+
+// GetSceneArchive returns the archive of every completed output of the given scene, across output types and
+// iterations. format is ArchiveFormatZip or ArchiveFormatTarGz, and defaults to ArchiveFormatZip.
+//
+// Returns ErrValidation if format is not an archive format, scene.ErrNoOutputPaths if the scene has no output on disk,
+// or ErrDailyDownloadLimit if the user downloaded the most bytes allowed for the day. The bytes sent should be counted
+// with RecordDownload.
+func (s *ClientService) GetSceneArchive(ctx context.Context, userID, sceneID primitive.ObjectID, format string) (_ *SceneArchive, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetSceneArchive", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+
+	if format == "" {
+		format = ArchiveFormatZip
+	}
+	contentType, ok := archiveContentTypes[format]
+	if !ok {
+		return nil, NewValidationError(
+			ErrUnsupportedArchiveFormat.Error(),
+			map[string]string{"format": "must be one of " + ArchiveFormatZip + ", " + ArchiveFormatTarGz},
+			ErrUnsupportedArchiveFormat,
+		)
+	}
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionDownload); err != nil {
+		return nil, err
+	}
+	if err := s.checkDownloadQuota(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	nerf, err := s.sceneManager.GetNerf(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	config, err := s.trainingConfig(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	sceneName, err := s.sceneManager.GetSceneName(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &SceneArchive{
+		Format:      format,
+		FileName:    archiveFileName(sceneName, sceneID, format),
+		ContentType: contentType,
+	}
+	for _, outputType := range sceneOutputTypes(config, nerf) {
+		if err := s.restoreOutputs(ctx, sceneID, nerf, outputType); err != nil {
+			return nil, err
+		}
+		completed, err := s.sceneManager.GetCompletedIterations(ctx, sceneID, outputType)
+		if err != nil {
+			return nil, err
+		}
+		for _, iteration := range completed {
+			path, err := nerf.GetFilePathForTypeAndIter(outputType, iteration)
+			if err != nil {
+				return nil, err
+			}
+			ext := strings.TrimPrefix(filepath.Ext(path), ".")
+			archive.Entries = append(archive.Entries, ArchiveEntry{
+				Path: path,
+				Name: outputType + "/" + outputFileName(sceneName, sceneID, outputType, iteration, ext),
+			})
+		}
+	}
+	if len(archive.Entries) == 0 {
+		return nil, scene.ErrNoOutputPaths
+	}
+
+	s.logger.Ctx(ctx).Debugf("Archiving %d outputs of scene %s as %s", len(archive.Entries), sceneID.Hex(), format)
+	return archive, nil
+}
+
+// archiveFileName returns the name an archive of a scene is saved as, <scene name>_outputs.<format>.
+func archiveFileName(sceneName string, sceneID primitive.ObjectID, format string) string {
+	// outputFileName sanitizes the scene name, the iteration suffix it adds is not wanted here
+	name := outputFileName(sceneName, sceneID, "outputs", 0, "")
+	return strings.TrimSuffix(name, "_iter0") + "." + format
+}
+
+// Write writes the archive to w, reading each file as it is added. Files are compressed, so the size of the archive
+// is not known before it is written.
+//
+// Returns an error if a file could not be read, or w could not be written to.
+func (a *SceneArchive) Write(w io.Writer) error {
+	switch a.Format {
+	case ArchiveFormatTarGz:
+		return a.writeTarGz(w)
+	default:
+		return a.writeZip(w)
+	}
+}
+
+// writeZip writes the archive to w as a ZIP.
+func (a *SceneArchive) writeZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for _, entry := range a.Entries {
+		if err := addArchiveFile(entry, func(info os.FileInfo) (io.Writer, error) {
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return nil, err
+			}
+			header.Name = entry.Name
+			header.Method = zip.Deflate
+			return zw.CreateHeader(header)
+		}); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// writeTarGz writes the archive to w as a gzip compressed tar.
+func (a *SceneArchive) writeTarGz(w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, entry := range a.Entries {
+		if err := addArchiveFile(entry, func(info os.FileInfo) (io.Writer, error) {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return nil, err
+			}
+			header.Name = entry.Name
+			return tw, tw.WriteHeader(header)
+		}); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// addArchiveFile copies the file of entry to the writer create returns for its header.
+func addArchiveFile(entry ArchiveEntry, create func(info os.FileInfo) (io.Writer, error)) error {
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", entry.Name, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", entry.Name, err)
+	}
+	dst, err := create(info)
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", entry.Name, err)
+	}
+	// The tar header holds the size of the file, which must not change while it is copied
+	if _, err := io.CopyN(dst, f, info.Size()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", entry.Name, err)
+	}
+	return nil
+}
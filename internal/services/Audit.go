@@ -0,0 +1,285 @@
+// This file contains the audit trail, which records every access-controlled operation on a scene, logins, uploads,
+// changes to sharing, and admin actions, with the client address of the request that made them, see WithClientIP.
+// Events are only ever inserted, so the trail cannot be changed through the server. Admins can read all of it, see
+// GetAuditLog, and users the events they made, see GetMyActivity.
+//
+// Events are recorded best effort: they are queued in a bounded buffer and inserted in batches by a background
+// goroutine, so recording never blocks or fails a request. When the buffer is full, events are dropped and counted,
+// and the count is logged as a warning. Events of a batch that fails to insert are lost, and also logged.
+
+package services
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Buffering of audit events
+const (
+	// auditBufferSize is the most events queued for insertion, further events are dropped.
+	auditBufferSize = 1024
+	// auditBatchSize is the most events inserted at once.
+	auditBatchSize = 100
+	// auditFlushInterval is the longest an event waits in the queue before it is inserted.
+	auditFlushInterval = time.Second
+	// auditInsertTimeout bounds a single batch insert.
+	auditInsertTimeout = 10 * time.Second
+	// maxAuditLogEvents is the most events returned by GetSceneAuditLog.
+	maxAuditLogEvents = 500
+)
+
+// clientIPKey is the context key of the client address recorded with audit events.
+type clientIPKey struct{}
+
+// WithClientIP returns a copy of ctx carrying the address of the client making a request, which the audit events
+// recorded with it are stamped with.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// clientIP returns the client address carried by ctx, or "" if there is none.
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// AuditQuery selects a page of audit events. Zero filter fields do not filter. Page is 1-indexed.
+type AuditQuery struct {
+	UserID       primitive.ObjectID
+	SceneID      primitive.ObjectID
+	TargetUserID primitive.ObjectID
+	Action       string
+	Outcome      string
+	Since        time.Time
+	Until        time.Time
+	Page         int
+	PageSize     int
+}
+
+// AuditPage is a page of audit events, newest first.
+type AuditPage struct {
+	Events   []*audit.Event `json:"events"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	Total    int64          `json:"total"`
+}
+
+// AuditLog records audit events in the background, see Record.
+type AuditLog struct {
+	manager *audit.AuditManager
+	logger  *log.Logger
+	events  chan *audit.Event
+	// events dropped since the last warning
+	dropped   atomic.Int64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAuditLog creates an AuditLog inserting events with manager, and starts its background writer.
+// Close must be called to insert the events still queued on shutdown.
+func NewAuditLog(manager *audit.AuditManager, logger *log.Logger) *AuditLog {
+	a := &AuditLog{
+		manager: manager,
+		logger:  logger,
+		events:  make(chan *audit.Event, auditBufferSize),
+		done:    make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Record queues an event of the given user on a scene for insertion, with the client address carried by ctx. It never
+// blocks, the event is dropped if the queue is full. A nil AuditLog records nothing.
+func (a *AuditLog) Record(ctx context.Context, userID, sceneID primitive.ObjectID, action, outcome, detail string) {
+	a.record(ctx, &audit.Event{UserID: userID, SceneID: sceneID, Action: action, Outcome: outcome, Detail: detail})
+}
+
+// RecordOnUser queues an event of the given user acting on targetUserID, as Record does.
+func (a *AuditLog) RecordOnUser(ctx context.Context, userID, targetUserID primitive.ObjectID, action, outcome, detail string) {
+	a.record(ctx, &audit.Event{UserID: userID, TargetUserID: targetUserID, Action: action, Outcome: outcome, Detail: detail})
+}
+
+// record stamps an event with the current time and the client address carried by ctx, and queues it for insertion.
+func (a *AuditLog) record(ctx context.Context, event *audit.Event) {
+	if a == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	event.IP = clientIP(ctx)
+	select {
+	case a.events <- event:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// Close stops recording, and waits for the queued events to be inserted.
+func (a *AuditLog) Close() {
+	if a == nil {
+		return
+	}
+	a.closeOnce.Do(func() { close(a.events) })
+	<-a.done
+}
+
+// run inserts queued events in batches, until the queue is closed.
+func (a *AuditLog) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*audit.Event, 0, auditBatchSize)
+	for {
+		select {
+		case event, ok := <-a.events:
+			if !ok {
+				a.flush(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= auditBatchSize {
+				a.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			a.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush inserts a batch of events, and warns about events lost to a failed insert or dropped since the last flush.
+func (a *AuditLog) flush(batch []*audit.Event) {
+	if dropped := a.dropped.Swap(0); dropped > 0 {
+		a.logger.Warnf("Dropped %d audit events, the audit buffer is full", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), auditInsertTimeout)
+	defer cancel()
+	if err := a.manager.InsertEvents(ctx, batch); err != nil {
+		a.logger.Warnf("Failed to insert %d audit events: %v", len(batch), err)
+	}
+}
+
+// auditOutcome returns the outcome recorded for an access check that returned err.
+func auditOutcome(err error) string {
+	if err != nil {
+		return audit.OutcomeDenied
+	}
+	return audit.OutcomeAllowed
+}
+
+// authorize checks that the given user may perform the given action on the given scene with verifyUserAccess, and
+// records the attempted action and whether it was allowed in the audit log. Scenes in the trash only allow the actions
+// of trashActions.
+func (s *ClientService) authorize(ctx context.Context, userID, sceneID primitive.ObjectID, action string) error {
+	err := s.verifyUserAccess(ctx, userID, sceneID, action)
+	if err == nil && !slices.Contains(trashActions, action) {
+		err = s.verifyNotTrashed(ctx, sceneID)
+	}
+	detail := ""
+	if err != nil {
+		detail = err.Error()
+	}
+	s.audit.Record(ctx, userID, sceneID, action, auditOutcome(err), detail)
+	return err
+}
+
+// GetSceneAuditLog returns the most recent access events on a scene, newest first. Only admins and the scene's owner
+// may read it, and owners only see the client addresses of their own events. Reading the audit log is itself recorded.
+//
+// Returns user.ErrUserNoAccess if the user is neither an admin nor the owner, or error if an error occurred.
+func (s *ClientService) GetSceneAuditLog(ctx context.Context, userID, sceneID primitive.ObjectID) (_ []*audit.Event, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetSceneAuditLog", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadAuditLog); err != nil {
+		return nil, err
+	}
+	events, err := s.audit.manager.GetSceneEvents(ctx, sceneID, maxAuditLogEvents)
+	if err != nil {
+		return nil, err
+	}
+	if s.verifyAdmin(ctx, userID) != nil {
+		for _, event := range events {
+			if event.UserID != userID {
+				event.IP = ""
+			}
+		}
+	}
+	return events, nil
+}
+
+// GetAuditLog returns a page of the audit events matching query, newest first. Only admins may read it. Reading the
+// audit log is itself recorded.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin, or error if an error occurred.
+func (s *ClientService) GetAuditLog(ctx context.Context, adminUserID primitive.ObjectID, query AuditQuery) (_ *AuditPage, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetAuditLog", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Get audit log request received")
+
+	err = s.verifyAdmin(ctx, adminUserID)
+	s.audit.Record(ctx, adminUserID, query.SceneID, audit.ActionAdminReadAuditLog, auditOutcome(err), "")
+	if err != nil {
+		return nil, err
+	}
+	return s.auditPage(ctx, audit.EventFilter{
+		UserID:       query.UserID,
+		SceneID:      query.SceneID,
+		TargetUserID: query.TargetUserID,
+		Action:       query.Action,
+		Outcome:      query.Outcome,
+		Since:        query.Since,
+		Until:        query.Until,
+	}, query.Page, query.PageSize)
+}
+
+// GetMyActivity returns a page of the audit events made by the user with the given ID, newest first, i.e their logins
+// and their operations on scenes.
+func (s *ClientService) GetMyActivity(ctx context.Context, userID primitive.ObjectID, page, pageSize int) (_ *AuditPage, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetMyActivity", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Get my activity request received")
+
+	return s.auditPage(ctx, audit.EventFilter{UserID: userID}, page, pageSize)
+}
+
+// auditPage returns a page of the audit events matching filter, newest first, with page sizes bound as admin
+// listings are.
+func (s *ClientService) auditPage(ctx context.Context, filter audit.EventFilter, page, pageSize int) (*AuditPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = DefaultAdminPageSize
+	}
+	pageSize = min(pageSize, MaxAdminPageSize)
+
+	events, total, err := s.audit.manager.ListEvents(ctx, filter, int64(page-1)*int64(pageSize), int64(pageSize))
+	if err != nil {
+		return nil, err
+	}
+	return &AuditPage{Events: events, Page: page, PageSize: pageSize, Total: total}, nil
+}
+
+// RecordAdminAction records an admin action taken outside the ClientService, i.e a change to the download throttle
+// of the web server, in the audit log.
+func (s *ClientService) RecordAdminAction(ctx context.Context, adminUserID primitive.ObjectID, action, detail string) {
+	s.audit.Record(ctx, adminUserID, primitive.NilObjectID, action, audit.OutcomeAllowed, detail)
+}
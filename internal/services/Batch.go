@@ -0,0 +1,251 @@
+// This file contains batch uploads, which create a scene for each of several videos with one training config, i.e the
+// dozens of captures a scanning rig produces in a session.
+//
+// Videos are sent in the batch request itself, or referenced by the IDs of resumable uploads that received every byte,
+// see ResumableUpload.go. Each video is handled like a single upload, so a rejected video (i.e one that is too short,
+// or over the user's quota) does not reject the others. The batch records the scene created for each video, or why it
+// was rejected, and its aggregate status is polled with GetBatch.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/batch"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/upload"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrBatchEmpty is returned when a batch upload has no videos.
+	ErrBatchEmpty = errors.New("batch has no videos")
+	// ErrBatchTooLarge is returned when a batch upload has more than MaxBatchSize videos.
+	ErrBatchTooLarge = errors.New("batch has too many videos")
+)
+
+// MaxBatchSize is the most videos of one batch upload.
+const MaxBatchSize = 50
+
+// BatchUpload is a batch upload of videos, sent as Files, or as the IDs of complete resumable uploads. Every scene of
+// the batch is created with the training config, which replaces the one uploads were started with. Scenes are named
+// after their video, prefixed by Name if set.
+type BatchUpload struct {
+	Name             string
+	Files            []*multipart.FileHeader
+	UploadIDs        []primitive.ObjectID
+	TrainingMode     string
+	OutputTypes      []string
+	SaveIterations   []int
+	TotalIterations  int
+	FrameSampleRate  int
+	TargetFrameCount int
+	Priority         string
+	ReuseSfm         bool
+}
+
+// BatchItemStatus is a video of a batch, and the state of its scene. Videos that were rejected have no scene, and
+// Error holds the reason. Error is also set for scenes that failed, or were deleted since.
+type BatchItemStatus struct {
+	Name     string      `json:"name"`
+	UploadID string      `json:"upload_id,omitempty"`
+	SceneID  string      `json:"scene_id,omitempty"`
+	State    scene.State `json:"state,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// BatchStatus is the aggregate status of a batch. States counts the scenes of the batch by state, and Done is set once
+// every scene reached a terminal state.
+type BatchStatus struct {
+	ID        string              `json:"id"`
+	Name      string              `json:"name,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+	Total     int                 `json:"total"`
+	Rejected  int                 `json:"rejected"`
+	States    map[scene.State]int `json:"states"`
+	Done      bool                `json:"done"`
+	Items     []BatchItemStatus   `json:"items"`
+}
+
+// HandleIncomingBatch creates a scene for each video of a batch upload and starts processing it, as HandleIncomingVideo
+// and CompleteUpload describe, and returns the status of the batch.
+//
+// A video that is rejected does not reject the batch, its item holds the client message of the error instead. If every
+// video is rejected no batch is created, and the error of the first is returned.
+//
+// If orgID is not nil, the scenes are uploaded into the workspace of that organization, which the user must be an
+// admin or member of, see checkOrgUpload.
+//
+// Returns ErrBatchEmpty if there are no videos, ErrBatchTooLarge if there are more than MaxBatchSize, or
+// scene.ErrInvalidTrainingConfig if the training config is outside TrainingLimits.
+func (s *ClientService) HandleIncomingBatch(ctx context.Context, userID, orgID primitive.ObjectID, req BatchUpload) (_ *BatchStatus, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.HandleIncomingBatch", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	size := len(req.Files) + len(req.UploadIDs)
+	if size == 0 {
+		return nil, NewValidationError(ErrBatchEmpty.Error(), map[string]string{"files": "required unless upload_ids are set"}, ErrBatchEmpty)
+	}
+	if size > MaxBatchSize {
+		return nil, NewValidationError(
+			ErrBatchTooLarge.Error(),
+			map[string]string{"files": fmt.Sprintf("at most %d videos, including upload_ids", MaxBatchSize)},
+			ErrBatchTooLarge,
+		)
+	}
+	// The training config is shared, so an invalid one rejects the batch before any video is stored
+	sceneName := ""
+	applyUploadDefaults(&sceneName, &req.TrainingMode, &req.OutputTypes, &req.SaveIterations, &req.Priority)
+	err = s.trainingLimits.Check(&scene.NerfTrainingConfig{
+		TrainingMode:     req.TrainingMode,
+		OutputTypes:      req.OutputTypes,
+		SaveIterations:   req.SaveIterations,
+		TotalIterations:  req.TotalIterations,
+		FrameSampleRate:  req.FrameSampleRate,
+		TargetFrameCount: req.TargetFrameCount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkOrgUpload(ctx, userID, orgID); err != nil {
+		return nil, err
+	}
+
+	b := &batch.Batch{
+		ID:      primitive.NewObjectID(),
+		OwnerID: userID,
+		OrgID:   orgID,
+		Name:    req.Name,
+		Items:   make([]batch.Item, 0, size),
+	}
+	var firstErr error
+	addItem := func(item batch.Item, sceneID string, err error) {
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			item.Error = AsError(err).Message
+			s.logger.Ctx(ctx).Infof("Rejected video %s of batch %s: %v", item.Name, b.ID.Hex(), err)
+		} else if id, err := primitive.ObjectIDFromHex(sceneID); err == nil {
+			item.SceneID = &id
+		}
+		b.Items = append(b.Items, item)
+	}
+
+	for _, file := range req.Files {
+		sceneID, err := s.HandleIncomingVideo(ctx, userID, orgID, file, req.TrainingMode, req.OutputTypes, req.SaveIterations,
+			req.TotalIterations, req.FrameSampleRate, req.TargetFrameCount, batchSceneName(req.Name, file.Filename),
+			req.Priority, "", req.ReuseSfm)
+		addItem(batch.Item{Name: file.Filename}, sceneID, err)
+	}
+	for _, uploadID := range req.UploadIDs {
+		item := batch.Item{Name: uploadID.Hex(), UploadID: &uploadID}
+		sceneID, err := s.completeUpload(ctx, userID, uploadID, func(session *upload.Session) {
+			item.Name = session.FileName
+			session.OrgID = orgID
+			session.TrainingMode = req.TrainingMode
+			session.OutputTypes = req.OutputTypes
+			session.SaveIterations = req.SaveIterations
+			session.TotalIterations = req.TotalIterations
+			session.FrameSampleRate = req.FrameSampleRate
+			session.TargetFrameCount = req.TargetFrameCount
+			session.SceneName = batchSceneName(req.Name, session.FileName)
+			session.Priority = req.Priority
+		})
+		addItem(item, sceneID, err)
+	}
+
+	if len(b.SceneIDs()) == 0 {
+		return nil, firstErr
+	}
+	if err := s.batchManager.CreateBatch(ctx, b); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to store batch %s, its scenes are processing: %v", b.ID.Hex(), err)
+		return nil, err
+	}
+
+	s.logger.Ctx(ctx).Infof("Batch %s created %d of %d scenes", b.ID.Hex(), len(b.SceneIDs()), size)
+	return s.batchStatus(ctx, b)
+}
+
+// GetBatch returns the status of a batch of the user, and of each of its scenes.
+//
+// Returns batch.ErrBatchNotFound if the batch does not exist, or belongs to another user.
+func (s *ClientService) GetBatch(ctx context.Context, userID, batchID primitive.ObjectID) (_ *BatchStatus, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetBatch", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	b, err := s.batchManager.GetBatch(ctx, batchID)
+	if err != nil {
+		return nil, err
+	}
+	// Other users' batches are reported as missing, so batch IDs cannot be probed
+	if b.OwnerID != userID {
+		return nil, batch.ErrBatchNotFound
+	}
+	return s.batchStatus(ctx, b)
+}
+
+// batchStatus sums up the states of the scenes of a batch. Scenes deleted since the upload count as done.
+func (s *ClientService) batchStatus(ctx context.Context, b *batch.Batch) (*BatchStatus, error) {
+	sceneIDs := b.SceneIDs()
+	scenes, _, err := s.sceneManager.ListScenes(ctx, scene.SceneListFilter{IDs: sceneIDs, Trash: scene.TrashIncluded}, 0, int64(len(sceneIDs)))
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[primitive.ObjectID]*scene.SceneStatus, len(scenes))
+	for _, sc := range scenes {
+		statuses[sc.ID] = sc.Status
+	}
+
+	status := &BatchStatus{
+		ID:        b.ID.Hex(),
+		Name:      b.Name,
+		CreatedAt: b.CreatedAt,
+		Total:     len(b.Items),
+		States:    make(map[scene.State]int),
+		Done:      true,
+		Items:     make([]BatchItemStatus, 0, len(b.Items)),
+	}
+	for _, item := range b.Items {
+		itemStatus := BatchItemStatus{Name: item.Name, Error: item.Error}
+		if item.UploadID != nil {
+			itemStatus.UploadID = item.UploadID.Hex()
+		}
+		if item.SceneID == nil {
+			status.Rejected++
+			status.Items = append(status.Items, itemStatus)
+			continue
+		}
+
+		itemStatus.SceneID = item.SceneID.Hex()
+		if sceneStatus, ok := statuses[*item.SceneID]; !ok || sceneStatus == nil {
+			itemStatus.Error = "scene was deleted"
+		} else {
+			itemStatus.State = sceneStatus.State
+			itemStatus.Error = sceneStatus.Error
+			status.States[sceneStatus.State]++
+			status.Done = status.Done && sceneStatus.State.IsTerminal()
+		}
+		status.Items = append(status.Items, itemStatus)
+	}
+	return status, nil
+}
+
+// batchSceneName names the scene of a video of a batch after its file, prefixed by the name of the batch if set.
+func batchSceneName(batchName, fileName string) string {
+	name := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	if batchName == "" {
+		return name
+	}
+	return batchName + " - " + name
+}
@@ -0,0 +1,105 @@
+// This file contains retrieval of scene metadata for many scenes in a single request, i.e for a gallery view.
+//
+// A failure for a single scene (no access, not found) is reported inline in that scene's result, rather than failing
+// the whole batch. Scenes are looked up concurrently, at most batchMetadataConcurrency at a time, so a large batch
+// does not take as many times longer as it has scenes.
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// MaxBatchMetadataScenes is the most scenes a single GetBatchSceneMetadata request may ask for.
+const MaxBatchMetadataScenes = 50
+
+// batchMetadataConcurrency is the maximum number of scenes whose metadata is looked up at once.
+const batchMetadataConcurrency = 8
+
+// BatchSceneMetadata is the result for a single scene of a GetBatchSceneMetadata request.
+// Exactly one of Metadata and Error is set. ErrorKind is the error kind (i.e, "not found") when Error is set.
+type BatchSceneMetadata struct {
+	Metadata  *SceneMetadata `json:"metadata,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	ErrorKind string         `json:"error_kind,omitempty"`
+}
+
+// GetBatchSceneMetadata returns the metadata of each of the given scenes, keyed by scene ID hex.
+// If outputType is not empty, only resources of that output type are included. Duplicate scene IDs are looked up once.
+//
+// Scenes the user does not have access to, or that do not exist, have their error set in the result.
+// Returns ErrValidation if no scene IDs, or more than MaxBatchMetadataScenes, are given.
+func (s *ClientService) GetBatchSceneMetadata(ctx context.Context, userID primitive.ObjectID, sceneIDs []primitive.ObjectID, outputType string, chunkSize int64) (_ map[string]*BatchSceneMetadata, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetBatchSceneMetadata", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	unique := make([]primitive.ObjectID, 0, len(sceneIDs))
+	seen := make(map[primitive.ObjectID]bool, len(sceneIDs))
+	for _, sceneID := range sceneIDs {
+		if !seen[sceneID] {
+			seen[sceneID] = true
+			unique = append(unique, sceneID)
+		}
+	}
+	sceneIDs = unique
+	if len(sceneIDs) == 0 || len(sceneIDs) > MaxBatchMetadataScenes {
+		return nil, NewValidationError(
+			fmt.Sprintf("between 1 and %d scene IDs must be given", MaxBatchMetadataScenes),
+			map[string]string{"scene_ids": fmt.Sprintf("got %d unique scene IDs", len(sceneIDs))},
+			nil,
+		)
+	}
+
+	// Access is checked against the user's scene list once, instead of once per scene
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize = s.ResolveChunkSize(chunkSize)
+	results := make(map[string]*BatchSceneMetadata, len(sceneIDs))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchMetadataConcurrency)
+
+	for _, sceneID := range sceneIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(sceneID primitive.ObjectID) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := &BatchSceneMetadata{}
+			var err error
+			if !u.IsAdmin() && !slices.Contains(u.SceneIDs, sceneID) {
+				err = user.ErrUserNoAccess
+			} else {
+				result.Metadata, err = s.sceneMetadata(ctx, sceneID, 0, chunkSize, outputType)
+			}
+			if err != nil {
+				svcErr := AsError(err)
+				if svcErr.Kind == ErrInternal {
+					s.logger.Ctx(ctx).Errorf("Failed to get metadata of scene %s: %v", sceneID.Hex(), err)
+				}
+				result = &BatchSceneMetadata{Error: svcErr.Message, ErrorKind: svcErr.Kind.Error()}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[sceneID.Hex()] = result
+		}(sceneID)
+	}
+	wg.Wait()
+
+	return results, nil
+}
@@ -0,0 +1,155 @@
+// This file contains deletion of a user's old scenes in a single request, so users can free space without deleting
+// their scenes one at a time.
+//
+// Only scenes in the targeted user's scene list are considered, so the scenes of other users are never touched, not
+// even for an admin, unless the admin targets that user explicitly. Each scene is deleted with the same cleanup as
+// DeleteScene. Scenes that are still processing are skipped and reported rather than failing the request, as is any
+// scene that fails to delete. Scenes are deleted concurrently, at most bulkDeleteConcurrency at a time. Running the
+// same request again only deletes what is left, so a request that failed part way through can simply be retried.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// bulkDeleteConcurrency is the maximum number of scenes deleted at once.
+const bulkDeleteConcurrency = 4
+
+// BulkDeleteOptions narrows down the scenes deleted by DeleteScenesOlderThan.
+type BulkDeleteOptions struct {
+	// only delete scenes in this state, one of completed, failed, or cancelled. Empty deletes scenes in any of them
+	State scene.State
+	// delete the scenes of this user instead of the caller's. Only admins may target another user
+	OwnerID primitive.ObjectID
+}
+
+// BulkDeletionSummary reports what was deleted by DeleteScenesOlderThan. Skipped and Failed are keyed by scene ID hex.
+type BulkDeletionSummary struct {
+	ScenesDeleted  int   `json:"scenes_deleted"`
+	BytesReclaimed int64 `json:"bytes_reclaimed"`
+	// scenes left in place, with the reason (i.e still processing)
+	Skipped map[string]string `json:"skipped"`
+	// scenes that failed to delete, with the error
+	Failed map[string]string `json:"failed"`
+}
+
+// DeleteScenesOlderThan deletes the scenes of a user that were created more than age ago, and returns what was
+// deleted. If opts.State is set, only scenes in that state are deleted. If opts.OwnerID is set, the scenes of that user
+// are deleted instead of the caller's.
+//
+// Scenes that are still processing are skipped, and scenes that fail to delete are reported in the summary without
+// failing the request.
+//
+// Returns ErrValidation if age is not positive or opts.State is not a finished state, user.ErrUserNoAccess if the
+// caller targets another user without being an admin, or user.ErrUserNotFound if the targeted user does not exist.
+func (s *ClientService) DeleteScenesOlderThan(ctx context.Context, userID primitive.ObjectID, age time.Duration, opts BulkDeleteOptions) (_ *BulkDeletionSummary, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.DeleteScenesOlderThan", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Bulk delete scenes request received")
+
+	if age <= 0 {
+		return nil, NewValidationError("age must be positive", map[string]string{"older_than": "must be positive"}, nil)
+	}
+	if opts.State != "" && (!opts.State.IsValid() || !opts.State.IsTerminal()) {
+		return nil, NewValidationError(
+			fmt.Sprintf("invalid state %q, only finished scenes can be deleted", opts.State),
+			map[string]string{"state": "must be one of completed, failed, cancelled"},
+			nil,
+		)
+	}
+
+	ownerID := userID
+	if !opts.OwnerID.IsZero() && opts.OwnerID != userID {
+		if err := s.verifyAdmin(ctx, userID); err != nil {
+			return nil, err
+		}
+		ownerID = opts.OwnerID
+	}
+	owner, err := s.userManager.GetUserByID(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-age)
+	summary := &BulkDeletionSummary{
+		Skipped: make(map[string]string),
+		Failed:  make(map[string]string),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, bulkDeleteConcurrency)
+
+	for _, sceneID := range owner.SceneIDs {
+		if !sceneID.Timestamp().Before(cutoff) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(sceneID primitive.ObjectID) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			deleted, bytes, err := s.deleteOldScene(ctx, sceneID, opts.State)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, scene.ErrInvalidOpOnProcessingScene):
+				summary.Skipped[sceneID.Hex()] = "still processing"
+			case err != nil:
+				svcErr := AsError(err)
+				if svcErr.Kind == ErrInternal {
+					s.logger.Ctx(ctx).Errorf("Failed to delete scene %s: %v", sceneID.Hex(), err)
+				}
+				summary.Failed[sceneID.Hex()] = svcErr.Message
+			case deleted:
+				s.audit.Record(ctx, userID, sceneID, audit.ActionDelete, audit.OutcomeAllowed, "bulk delete")
+				summary.ScenesDeleted++
+				summary.BytesReclaimed += bytes
+			}
+		}(sceneID)
+	}
+	wg.Wait()
+
+	s.logger.Ctx(ctx).Infof("Bulk deleted %d scenes of user %s, %d bytes reclaimed, %d skipped, %d failed",
+		summary.ScenesDeleted, ownerID.Hex(), summary.BytesReclaimed, len(summary.Skipped), len(summary.Failed))
+	return summary, nil
+}
+
+// deleteOldScene deletes a scene with deleteOwnedScene, unless state is set and the scene is in another state.
+// Scenes that are still processing are never cancelled.
+//
+// Returns whether the scene was deleted, and the number of bytes reclaimed.
+func (s *ClientService) deleteOldScene(ctx context.Context, sceneID primitive.ObjectID, state scene.State) (bool, int64, error) {
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil && !errors.Is(err, scene.ErrSceneNotFound) && !errors.Is(err, scene.ErrStatusNotFound) {
+		return false, 0, err
+	}
+	if state != "" && (status == nil || status.State != state) {
+		return false, 0, nil
+	}
+
+	bytes, err := s.deleteOwnedScene(ctx, sceneID, false)
+	if errors.Is(err, user.ErrSceneIDNotFound) {
+		// Removed from the owner by a concurrent deletion, which accounted for it
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return true, bytes, nil
+}
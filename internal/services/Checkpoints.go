@@ -0,0 +1,84 @@
+// This file contains integrity checking of model checkpoint files.
+//
+// The checksum of a checkpoint is recorded by the AMPQService when the file is saved, and the file is checked against
+// it before being served, so a user never continues training from a truncated or corrupted checkpoint.
+// Hashing a large checkpoint is expensive, so a successful check is remembered until the file's size or
+// modification time changes (i.e, across the many requests of a chunked download).
+
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"time"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+// verifiedFile identifies the version of a file that has last passed an integrity check.
+type verifiedFile struct {
+	size     int64
+	modTime  time.Time
+	checksum string
+}
+
+// fileChecksum returns the hex encoded SHA-256 of the file at path.
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// verifyCheckpoint checks that the checkpoint file at path still matches the checksum recorded when it was saved.
+//
+// Returns scene.ErrCorruptCheckpoint if no checksum was recorded or the file does not match it.
+func (s *ClientService) verifyCheckpoint(path, expected string) error {
+	if expected == "" {
+		return scene.ErrCorruptCheckpoint
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if cached, ok := s.verifiedCheckpoints.Load(path); ok {
+		v := cached.(verifiedFile)
+		if v.size == stat.Size() && v.modTime.Equal(stat.ModTime()) && v.checksum == expected {
+			return nil
+		}
+	}
+
+	actual, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		s.verifiedCheckpoints.Delete(path)
+		s.logger.Errorf("Checkpoint %s does not match its recorded checksum", path)
+		return scene.ErrCorruptCheckpoint
+	}
+
+	s.verifiedCheckpoints.Store(path, verifiedFile{size: stat.Size(), modTime: stat.ModTime(), checksum: expected})
+	return nil
+}
+
+// checkpointChecksum returns the recorded checksum of the checkpoint stored at path, or "" if none was recorded.
+func checkpointChecksum(nerf *scene.Nerf, path string) string {
+	for iteration, checkpointPath := range nerf.CheckpointFilePathsMap {
+		if checkpointPath == path {
+			return nerf.CheckpointChecksums[iteration]
+		}
+	}
+	return ""
+}
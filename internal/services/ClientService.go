@@ -10,166 +10,766 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math"
 	"mime/multipart"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/batch"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/org"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/models/queue"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/token"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/upload"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/usage"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/worker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/storage"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrDeletionInProgress is returned when an account deletion is requested for a user whose deletion is already running.
+	ErrDeletionInProgress = errors.New("account deletion already in progress")
+	// ErrPasswordNotSet is returned when deleting an account without a password, which has nothing to re-authenticate with.
+	ErrPasswordNotSet = errors.New("account has no password, set one before deleting the account")
 )
 
 type ClientService struct {
-	mqService    *AMPQService
-	sceneManager *scene.SceneManager
-	userManager  *user.UserManager
-	queueManager *queue.QueueListManager
-	logger       *log.Logger
+	mqService     *AMPQService
+	sceneManager  scene.SceneRepository
+	userManager   user.UserRepository
+	queueManager  *queue.QueueListManager
+	uploadManager *upload.UploadManager
+	tokenManager  token.TokenRepository
+	usageManager  *usage.UsageManager
+	store         storage.Store
+	metrics       *metrics.Metrics
+	logger        *log.Logger
+	// cached scene values, see SceneCache.go
+	sceneCache *SceneCache
+	// default chunk size in bytes for chunked resource downloads, see SetChunkSize
+	chunkSize atomic.Int64
+	// upload limits by training mode
+	videoLimits map[string]VideoLimits
+	// upload limits by user tier, see UploadLimits.go
+	uploadLimits map[string]UploadLimits
+	// bounds on training configs, see TrainingLimits
+	trainingLimits TrainingLimits
+	// most high priority scenes processing at once, see resolvePriority
+	maxHighPriorityJobs atomic.Int64
+	// per-user limits, see Quotas.go
+	quotas atomic.Pointer[QuotaConfig]
+	// retention policy of old data, see Retention.go
+	retention retentionPolicy
+	// content scanning of uploads, see scanUpload
+	scanning ContentScanning
+	// HMAC key of signed resource URLs, see GenerateResourceURL
+	resourceURLKey []byte
+	// session tokens, see Tokens.go
+	tokens TokenConfig
+	// external login providers, nil if none are enabled, see OAuth.go
+	oauth atomic.Pointer[oauthLogin]
+	// email verification of new accounts, see EmailVerification
+	verification EmailVerification
+	// training estimate coefficients by training mode, see EstimateTraining
+	estimation map[string]EstimationCoefficients
+	// access audit trail, see authorize
+	audit *AuditLog
+	// webhooks of users, see Webhooks.go
+	webhooks *Webhooks
+	// organizations whose members share the scenes of their workspace, see Organizations.go
+	orgManager *org.OrgManager
+	// registered workers, nil if workers cannot register, see WorkerRegistry.go
+	workerManager *worker.WorkerManager
+	// batch uploads of several videos, see Batch.go
+	batchManager *batch.BatchManager
+	// per-scene locks serializing preview generation
+	previewLocks sync.Map
+	// per-scene locks serializing thumbnail generation, see refreshThumbnail
+	thumbnailLocks sync.Map
+	// IDs of users whose account deletion is running
+	deletingUsers sync.Map
+	// checkpoint paths that passed an integrity check, see verifyCheckpoint
+	verifiedCheckpoints sync.Map
+	// per-file locks serializing output conversion
+	transcodeLocks sync.Map
+	// per-file locks serializing chunk checksumming, see chunkChecksums
+	manifestLocks sync.Map
+	// IDs of resumable uploads in use by a request, see lockUpload
+	uploadLocks sync.Map
+	// how long resumable uploads live without receiving a chunk, see SetUploadSessionTTL
+	uploadSessionTTL atomic.Int64
+	// per-file locks serializing restores from object storage, see restoreFile
+	restoreLocks sync.Map
+}
+
+// ClientServiceConfig holds the dependencies and settings of a ClientService, see NewClientService. Dependencies
+// documented as optional may be left nil.
+type ClientServiceConfig struct {
+	MQService     *AMPQService
+	SceneManager  scene.SceneRepository
+	UserManager   user.UserRepository
+	QueueManager  *queue.QueueListManager
+	UploadManager *upload.UploadManager
+	TokenManager  token.TokenRepository
+	// stores the per-user usage quotas are counted in
+	UsageManager *usage.UsageManager
+	// object store scene outputs are mirrored to, see ObjectStorage.go. If nil, outputs only live on disk.
+	Store storage.Store
+	// caches configs, output files, and access checks of scenes, see SceneCache.go
+	SceneCache *SceneCache
+	// records access to scenes, see NewAuditLog
+	AuditLog *AuditLog
+	// sends job events to the webhooks users register, see NewWebhooks
+	Webhooks *Webhooks
+	// stores organizations, whose members share the scenes uploaded into their workspace, see Organizations.go
+	OrgManager *org.OrgManager
+	// stores the workers that register, see WorkerRegistry.go. If nil, as when the worker API is disabled, uploads
+	// are accepted without warning when no worker is available.
+	WorkerManager *worker.WorkerManager
+	// stores batch uploads, see Batch.go
+	BatchManager *batch.BatchManager
+	Metrics      *metrics.Metrics
+	Logger       *log.Logger
+
+	// default chunk size in bytes for chunked resource downloads. A value <= 0 uses DefaultChunkSize.
+	ChunkSize int64
+	// upload limits by training mode, see DefaultVideoLimits
+	VideoLimits map[string]VideoLimits
+	// upload limits by user tier, see DefaultUploadLimits
+	UploadLimits map[string]UploadLimits
+	// bounds on the training configs of uploads and retraining, see TrainingLimits
+	TrainingLimits TrainingLimits
+	// most high priority scenes processing at once. A value <= 0 does not limit them.
+	MaxHighPriorityJobs int64
+	// per-user limits, counted in the usage collection of UsageManager. A zero value does not limit users.
+	Quotas QuotaConfig
+	// content scan of uploads. A zero value does not scan.
+	Scanning ContentScanning
+	// HMAC key of signed resource URLs. If empty, signed resource URLs are disabled.
+	ResourceURLKey []byte
+	// access and refresh tokens of sessions, see Tokens.go
+	Tokens TokenConfig
+	// email verification of new accounts. A zero value does not verify.
+	Verification EmailVerification
+	// training estimate coefficients by training mode, see DefaultEstimationCoefficients
+	Estimation map[string]EstimationCoefficients
+}
+
+// NewClientService creates a new ClientService with the dependencies and settings of config.
+//
+// Expired resumable uploads are removed in the background, see ResumableUpload.go.
+func NewClientService(config ClientServiceConfig) *ClientService {
+	s := &ClientService{
+		mqService:      config.MQService,
+		sceneManager:   config.SceneManager,
+		userManager:    config.UserManager,
+		queueManager:   config.QueueManager,
+		uploadManager:  config.UploadManager,
+		tokenManager:   config.TokenManager,
+		usageManager:   config.UsageManager,
+		store:          config.Store,
+		sceneCache:     config.SceneCache,
+		videoLimits:    config.VideoLimits,
+		uploadLimits:   config.UploadLimits,
+		trainingLimits: config.TrainingLimits,
+		scanning:       config.Scanning,
+		resourceURLKey: config.ResourceURLKey,
+		tokens:         config.Tokens,
+		verification:   config.Verification,
+		estimation:     config.Estimation,
+		audit:          config.AuditLog,
+		webhooks:       config.Webhooks,
+		orgManager:     config.OrgManager,
+		workerManager:  config.WorkerManager,
+		batchManager:   config.BatchManager,
+		metrics:        config.Metrics,
+		logger:         config.Logger,
+	}
+	s.SetChunkSize(config.ChunkSize)
+	s.SetMaxHighPriorityJobs(config.MaxHighPriorityJobs)
+	s.SetQuotas(config.Quotas)
+	go s.runUploadCleanup()
+	go s.runRetention()
+	return s
+}
+
+// Chunk size limits for chunked resource downloads
+const (
+	// DefaultChunkSize is used when neither the server nor the request specifies a chunk size.
+	DefaultChunkSize int64 = 1024 * 1024
+	// MaxChunkSize is the largest chunk size a client may request.
+	MaxChunkSize int64 = 64 * 1024 * 1024
+)
+
+// ResolveChunkSize returns the chunk size to use for a request. A requested size <= 0 falls back to the
+// configured default, and sizes above MaxChunkSize are clamped.
+func (s *ClientService) ResolveChunkSize(requested int64) int64 {
+	if requested <= 0 {
+		requested = s.chunkSize.Load()
+	}
+	if requested <= 0 {
+		requested = DefaultChunkSize
+	}
+	return min(requested, MaxChunkSize)
+}
+
+// SetChunkSize changes the default chunk size in bytes for chunked resource downloads. A value <= 0 uses
+// DefaultChunkSize. Clients that already fetched metadata keep using the chunk size it was computed for.
+func (s *ClientService) SetChunkSize(chunkSize int64) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	s.chunkSize.Store(min(chunkSize, MaxChunkSize))
 }
 
-// NewClientService creates a new ClientService. Dependencies are injected via the constructor.
-func NewClientService(mqs *AMPQService, sm *scene.SceneManager, um *user.UserManager, qlm *queue.QueueListManager, logger *log.Logger) *ClientService {
-	return &ClientService{
-		mqService:    mqs,
-		sceneManager: sm,
-		userManager:  um,
-		queueManager: qlm,
-		logger:       logger,
+// ChunkLayout returns how a file of the given size splits into chunks of chunkSize bytes, DefaultChunkSize if <= 0.
+// Every chunk but the last is exactly chunkSize bytes. An empty file has no chunks, and a last chunk size of 0.
+func ChunkLayout(fileSize, chunkSize int64) (chunks int, lastChunkSize int64) {
+	if fileSize <= 0 {
+		return 0, 0
 	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	chunks = int((fileSize + chunkSize - 1) / chunkSize)
+	lastChunkSize = fileSize - int64(chunks-1)*chunkSize
+	return chunks, lastChunkSize
 }
 
-// verifyUserAccess checks if the given user has access to the given scene.
+// ChunkRange returns the inclusive byte range [start, end] of chunk index of a file, consistent with ChunkLayout.
+//
+// Returns error if the index is out of range for the file.
+func ChunkRange(fileSize, chunkSize int64, index int) (start, end int64, err error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	chunks, _ := ChunkLayout(fileSize, chunkSize)
+	if index < 0 || index >= chunks {
+		return 0, 0, fmt.Errorf("chunk %d out of range, file has %d chunks", index, chunks)
+	}
+	start = int64(index) * chunkSize
+	end = min(start+chunkSize, fileSize) - 1
+	return start, end, nil
+}
+
+// verifyUserAccess checks if the given user may perform the given audited action on the given scene. Owners and
+// admins may perform every action, collaborators only those their role allows, see Sharing.go, and members of the
+// organization whose workspace the scene is in those their member role allows, see Organizations.go.
 //
 // Returns nil if the user has access, error if the user does not have access or an error occurred.
-func (s *ClientService) verifyUserAccess(ctx context.Context, userID, sceneID primitive.ObjectID) error {
+func (s *ClientService) verifyUserAccess(ctx context.Context, userID, sceneID primitive.ObjectID, action string) error {
+	// Roles are cached, admin access is not, as admins are few and an admin may be demoted at any time
+	if role, ok := s.cachedAccessRole(ctx, sceneID, userID); ok {
+		if role == accessRoleOwner || collaboratorMayPerform(role, action) || orgAccessRoleMayPerform(role, action) {
+			return nil
+		}
+		return s.verifyAdmin(ctx, userID)
+	}
+
 	authorized, err := s.userManager.UserHasJobAccess(ctx, userID, sceneID)
 	if err != nil {
 		return err
 	}
-	if !authorized {
+	if authorized {
+		s.cacheAccessRole(ctx, sceneID, userID, accessRoleOwner)
+		return nil
+	}
+	err = s.verifyCollaboratorAccess(ctx, userID, sceneID, action)
+	if !errors.Is(err, user.ErrUserNoAccess) {
+		return err
+	}
+	err = s.verifyOrgAccess(ctx, userID, sceneID, action)
+	if !errors.Is(err, user.ErrUserNoAccess) {
+		return err
+	}
+	return s.verifyAdmin(ctx, userID)
+}
+
+// verifyAdmin checks if the given user has the admin role.
+//
+// Returns nil if the user is an admin, user.ErrUserNoAccess if not, or error if an error occurred.
+func (s *ClientService) verifyAdmin(ctx context.Context, userID primitive.ObjectID) error {
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !u.IsAdmin() || u.Disabled {
 		return user.ErrUserNoAccess
 	}
 	return nil
 }
 
-// LoginUser checks if the given username and password are correct and returns the user's ID, nil if successful.
+// LoginUser checks if the given username and password are correct and starts a session, returning its access and
+// refresh tokens, see Tokens.go.
 //
-// Returns "", error if the username or password is incorrect.
-func (s *ClientService) LoginUser(ctx context.Context, username, password string) (string, error) {
-	user, err := s.userManager.GetUserByUsername(ctx, username)
+// Returns nil, ErrUnauthorized if the username or password is incorrect. Which of the two is not revealed.
+// Returns nil, ErrEmailNotVerified if the account is unverified and verification is required for login.
+// Returns nil, user.ErrAccountDisabled if an admin disabled the account.
+func (s *ClientService) LoginUser(ctx context.Context, username, password string) (_ *TokenPair, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.LoginUser", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	u, err := s.userManager.GetUserByUsername(ctx, username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		s.audit.Record(ctx, primitive.NilObjectID, primitive.NilObjectID, audit.ActionLogin, audit.OutcomeDenied, "unknown username")
+		return nil, newError(ErrUnauthorized, "invalid username or password", err)
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	err = user.CheckPassword(password)
+	err = u.CheckPassword(password)
 	if err != nil {
-		return "", err
+		s.audit.Record(ctx, u.ID, primitive.NilObjectID, audit.ActionLogin, audit.OutcomeDenied, "invalid password")
+		return nil, newError(ErrUnauthorized, "invalid username or password", err)
+	}
+	if u.Disabled {
+		s.audit.Record(ctx, u.ID, primitive.NilObjectID, audit.ActionLogin, audit.OutcomeDenied, user.ErrAccountDisabled.Error())
+		return nil, user.ErrAccountDisabled
+	}
+	if s.verification.Enabled && s.verification.RequireForLogin && !u.IsVerified() {
+		s.audit.Record(ctx, u.ID, primitive.NilObjectID, audit.ActionLogin, audit.OutcomeDenied, ErrEmailNotVerified.Error())
+		return nil, ErrEmailNotVerified
 	}
 
-	return user.ID.Hex(), nil
+	tokens, err := s.issueTokens(ctx, u.ID, primitive.NewObjectID(), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, u.ID, primitive.NilObjectID, audit.ActionLogin, audit.OutcomeAllowed, "")
+	return tokens, nil
 }
 
 // RegisterUser generates a new user document with the given username and password, and inserts it into the database.
 //
-// Returns nil if successful, error if the username is already taken or an error occurred while inserting the user.
-func (s *ClientService) RegisterUser(ctx context.Context, username, password string) error {
-	_, err := s.userManager.GenerateUser(ctx, username, password)
+// If email verification is enabled, the user is created unverified and mailed a verification token. Failing to send
+// the mail does not fail the registration, as the user can request another with ResendVerification.
+//
+// Returns nil if successful, ErrValidation if the username or password does not follow the configured rules, ErrConflict
+// if the username is already taken, or error if an error occurred while inserting the user.
+func (s *ClientService) RegisterUser(ctx context.Context, username, password string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RegisterUser", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if !s.verification.Enabled {
+		_, err = s.userManager.GenerateUser(ctx, username, password)
+		return err
+	}
+
+	token, hash, err := newVerificationToken()
 	if err != nil {
 		return err
 	}
+	u, err := s.userManager.GenerateUnverifiedUser(ctx, username, password, hash, time.Now().Add(s.verificationTTL()))
+	if err != nil {
+		return err
+	}
+	if err := s.sendVerification(ctx, u.Username, token); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to send verification mail to user %s: %v", u.ID.Hex(), err)
+	}
 
 	return nil
 }
 
 // UpdateUserUsername updates the username of the user with the given ID.
+// If email verification is enabled, the new username must be verified again.
 //
 // Returns nil if successful, error if the user does not exist or an error occurred.
-func (s *ClientService) UpdateUserUsername(ctx context.Context, userID primitive.ObjectID, password, newUsername string) error {
-	return s.userManager.UpdateUsername(ctx, userID, password, newUsername)
+func (s *ClientService) UpdateUserUsername(ctx context.Context, userID primitive.ObjectID, password, newUsername string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.UpdateUserUsername", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.userManager.UpdateUsername(ctx, userID, password, newUsername); err != nil {
+		return err
+	}
+	if s.verification.Enabled {
+		return s.reissueVerification(ctx, userID, user.NormalizeUsername(newUsername))
+	}
+	return nil
 }
 
-// UpdateUserPassword updates the password of the user with the given ID.
+// UpdateUserPassword updates the password of the user with the given ID, and revokes the refresh tokens of every
+// session of the user, so sessions started with the old password end once their access token expires.
 //
 // Returns nil if successful, error if the user does not exist or an error occurred.
-func (s *ClientService) UpdateUserPassword(ctx context.Context, userID primitive.ObjectID, oldPassword, newPassword string) error {
-	return s.userManager.UpdatePassword(ctx, userID, oldPassword, newPassword)
+func (s *ClientService) UpdateUserPassword(ctx context.Context, userID primitive.ObjectID, oldPassword, newPassword string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.UpdateUserPassword", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.userManager.UpdatePassword(ctx, userID, oldPassword, newPassword); err != nil {
+		return err
+	}
+	revoked, err := s.tokenManager.RevokeUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	s.logger.Ctx(ctx).Infof("Revoked %d refresh tokens of user %s after a password change", revoked, userID.Hex())
+	return nil
 }
 
-// GetSceneMetadata returns metadata about the resources available for the given scene.
+// AccountDeletionSummary reports what was reclaimed by DeleteUser.
+type AccountDeletionSummary struct {
+	ScenesDeleted  int   `json:"scenes_deleted"`
+	BytesReclaimed int64 `json:"bytes_reclaimed"`
+}
+
+// DeleteUser permanently deletes the user with the given ID, after re-authenticating with their password.
 //
-// Returns error if the user does not have access to the scene or an error occurred.
-// For each available output file type, it returns a map of iteration numbers to file information.
-// Specifically, it returns whether the file exists, its size, number of (1 MB) chunks, and size of the last chunk.
-func (s *ClientService) GetSceneMetadata(ctx context.Context, userID, sceneID primitive.ObjectID) (interface{}, error) {
-	// Information about a single resource available for a scene.
-	type ResourceInfo struct {
-		Exists        bool  `json:"exists"`
-		Size          int64 `json:"size,omitempty"`
-		Chunks        int   `json:"chunks,omitempty"`
-		LastChunkSize int64 `json:"last_chunk_size,omitempty"`
-	}
-	// Metadata about all resources available for a scene.
-	type SceneMetadata struct {
-		Resources map[string]map[string]ResourceInfo `json:"resources"`
+// Every scene the user owns is deleted with the same cleanup as DeleteScene, except scenes that are still processing
+// are cancelled first rather than refused. Unfinished resumable uploads of the user are removed along with their
+// staged bytes, webhooks of the user are removed, scenes shared with the user are unshared, and every session of the
+// user is ended. The user document is removed last, so a failure part way through leaves the account in place and
+// deletion can simply be retried.
+//
+// Returns (nil, ErrDeletionInProgress) if a deletion for the same user is already running, (nil, ErrPasswordNotSet)
+// if the user only logs in through linked identities, or (nil, error) if the password is incorrect or an error occurred.
+func (s *ClientService) DeleteUser(ctx context.Context, userID primitive.ObjectID, password string) (_ *AccountDeletionSummary, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.DeleteUser", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Delete user request received")
+
+	if _, running := s.deletingUsers.LoadOrStore(userID, struct{}{}); running {
+		s.logger.Ctx(ctx).Info("Rejected concurrent deletion of user ", userID.Hex())
+		return nil, ErrDeletionInProgress
 	}
+	defer s.deletingUsers.Delete(userID)
 
-	if err := s.verifyUserAccess(ctx, userID, sceneID); err != nil {
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		s.logger.Ctx(ctx).Info("Failed to get user:", err.Error())
+		return nil, err
+	}
+	if !u.HasPassword() {
+		s.logger.Ctx(ctx).Info("Rejected deletion of user without a password ", userID.Hex())
+		return nil, ErrPasswordNotSet
+	}
+	if err := u.CheckPassword(password); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid password for account deletion")
 		return nil, err
 	}
 
-	nerf, err := s.sceneManager.GetNerf(ctx, sceneID)
+	summary := &AccountDeletionSummary{}
+	for _, sceneID := range u.SceneIDs {
+		bytes, err := s.deleteSceneData(ctx, sceneID, true)
+		if err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to delete scene %s of user %s: %v", sceneID.Hex(), userID.Hex(), err)
+			return nil, err
+		}
+		summary.ScenesDeleted++
+		summary.BytesReclaimed += bytes
+	}
+
+	bytes, err := s.removeUserUploads(ctx, userID)
 	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to remove uploads of user %s: %v", userID.Hex(), err)
+		return nil, err
+	}
+	summary.BytesReclaimed += bytes
+
+	if err := s.webhooks.manager.DeleteUserWebhooks(ctx, userID); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to delete webhooks of user %s: %v", userID.Hex(), err)
+		return nil, err
+	}
+	if err := s.sceneManager.RemoveCollaboratorFromAll(ctx, userID); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to unshare scenes with user %s: %v", userID.Hex(), err)
+		return nil, err
+	}
+	if err := s.orgManager.RemoveUserFromAll(ctx, userID); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to remove user %s from organizations: %v", userID.Hex(), err)
+		return nil, err
+	}
+	if err := s.batchManager.DeleteUserBatches(ctx, userID); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to delete batches of user %s: %v", userID.Hex(), err)
+		return nil, err
+	}
+	if err := s.tokenManager.DeleteUserTokens(ctx, userID); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to delete refresh tokens of user %s: %v", userID.Hex(), err)
+		return nil, err
+	}
+	if err := s.userManager.DeleteUser(ctx, userID); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to delete user %s: %v", userID.Hex(), err)
+		return nil, err
+	}
+
+	s.logger.Ctx(ctx).Infof("Deleted user %s: %d scenes, %d bytes reclaimed", userID.Hex(), summary.ScenesDeleted, summary.BytesReclaimed)
+	return summary, nil
+}
+
+// DeleteScene moves a scene the user has access to to the trash, see Trash.go. It is deleted permanently instead,
+// including its database document and all files on disk, if permanent is set or it is already in the trash.
+// Scenes that are still processing are cancelled first if cancelProcessing is set, removing their job from the
+// processing queues, and cannot be deleted otherwise.
+//
+// Returns whether the scene was trashed or the number of bytes reclaimed. Returns
+// scene.ErrInvalidOpOnProcessingScene if the scene is processing and cancelProcessing is not set, or error if the user
+// does not have access to the scene or an error occurred.
+func (s *ClientService) DeleteScene(ctx context.Context, userID, sceneID primitive.ObjectID, cancelProcessing, permanent bool) (_ *SceneDeletion, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.DeleteScene", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Delete scene request received")
+
+	// Verify user access to scene
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionDelete); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
 		return nil, err
 	}
 
-	config, err := s.sceneManager.GetTrainingConfig(ctx, sceneID)
+	if !permanent {
+		deletion, err := s.trashScene(ctx, sceneID, cancelProcessing)
+		if !errors.Is(err, scene.ErrSceneInTrash) {
+			return deletion, err
+		}
+	}
+
+	bytes, err := s.deleteOwnedScene(ctx, sceneID, cancelProcessing)
 	if err != nil {
 		return nil, err
 	}
+	return &SceneDeletion{BytesReclaimed: bytes}, nil
+}
 
-	metadata := &SceneMetadata{
-		Resources: make(map[string]map[string]ResourceInfo),
+// deleteOwnedScene deletes a scene with deleteSceneData, then removes it from its owner and releases the owner's storage.
+// The owner is looked up rather than assumed, as admins may delete scenes they do not own.
+//
+// Returns the number of bytes reclaimed.
+func (s *ClientService) deleteOwnedScene(ctx context.Context, sceneID primitive.ObjectID, cancelProcessing bool) (int64, error) {
+	owner, err := s.userManager.GetUserBySceneID(ctx, sceneID)
+	if err != nil && !errors.Is(err, user.ErrUserNotFound) {
+		return 0, err
 	}
 
-	for _, ot := range config.NerfTrainingConfig.OutputTypes {
+	bytes, err := s.deleteSceneData(ctx, sceneID, cancelProcessing)
+	if err != nil {
+		s.logger.Ctx(ctx).Info("Failed to delete scene:", err.Error())
+		return 0, err
+	}
 
-		s.logger.Debug("Getting file paths for output type:", ot)
+	if owner != nil {
+		if err := s.userManager.RemoveSceneFromUser(ctx, owner.ID, sceneID); err != nil {
+			return 0, err
+		}
+		if err := s.userManager.IncrementStorageUsed(ctx, owner.ID, -bytes); err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to remove %d bytes from storage of user %s: %v", bytes, owner.ID.Hex(), err)
+		}
+	}
 
-		metadata.Resources[ot] = make(map[string]ResourceInfo)
+	s.logger.Ctx(ctx).Infof("Deleted scene %s, %d bytes reclaimed", sceneID.Hex(), bytes)
+	return bytes, nil
+}
 
-		iterFilePaths, err := nerf.GetFilePathsForType(ot)
+// addOwnerStorage adds delta bytes to the storage of the owner of a scene. Scenes without an owner are not charged.
+func (s *ClientService) addOwnerStorage(ctx context.Context, sceneID primitive.ObjectID, delta int64) error {
+	owner, err := s.userManager.GetUserBySceneID(ctx, sceneID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.userManager.IncrementStorageUsed(ctx, owner.ID, delta)
+}
+
+// deleteSceneData removes a scene's files from disk and its document from the database. It does not touch the owning user.
+//
+// If the scene is still processing, it is cancelled first when cancelProcessing is set, and otherwise
+// scene.ErrInvalidOpOnProcessingScene is returned. A scene whose document is already gone only has its files removed.
+//
+// Returns the number of bytes reclaimed.
+func (s *ClientService) deleteSceneData(ctx context.Context, sceneID primitive.ObjectID, cancelProcessing bool) (int64, error) {
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil && !errors.Is(err, scene.ErrSceneNotFound) && !errors.Is(err, scene.ErrStatusNotFound) {
+		return 0, err
+	}
+	if status != nil && !status.State.IsTerminal() {
+		if !cancelProcessing {
+			return 0, scene.ErrInvalidOpOnProcessingScene
+		}
+		if err := s.cancelScene(ctx, sceneID); err != nil {
+			return 0, err
+		}
+	}
+
+	paths, err := s.sceneStoragePaths(ctx, sceneID)
+	if err != nil {
+		return 0, err
+	}
+
+	var reclaimed int64
+	for _, path := range paths {
+		size, err := pathSize(path)
 		if err != nil {
-			return nil, err
+			return reclaimed, err
 		}
+		if err := os.RemoveAll(path); err != nil {
+			return reclaimed, err
+		}
+		reclaimed += size
+	}
+	if err := s.deleteStoredScene(ctx, sceneID); err != nil {
+		return reclaimed, err
+	}
+	if err := s.webhooks.manager.DeleteSceneWebhooks(ctx, sceneID); err != nil {
+		return reclaimed, err
+	}
 
-		for iteration, path := range iterFilePaths {
+	err = s.sceneManager.DeleteScene(ctx, sceneID)
+	if err != nil && !errors.Is(err, scene.ErrSceneNotFound) {
+		return reclaimed, err
+	}
+	s.sceneCache.InvalidateScene(ctx, sceneID)
+	return reclaimed, nil
+}
 
-			s.logger.Debug("Getting file info for iteration:", iteration)
+// CancelJob cancels the processing of a scene the user owns. A scene still in its grace period is cancelled before
+// its job is ever published to a worker.
+//
+// Returns scene.ErrInvalidStatusTransition if the scene has already finished processing,
+// or error if the user does not have access to the scene or an error occurred.
+func (s *ClientService) CancelJob(ctx context.Context, userID, sceneID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.CancelJob", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Cancel job request received")
 
-			info := ResourceInfo{Exists: false}
+	// Verify user access to scene
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionCancel); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return err
+	}
+
+	if err := s.cancelScene(ctx, sceneID); err != nil {
+		s.logger.Ctx(ctx).Info("Failed to cancel job:", err.Error())
+		return err
+	}
 
-			if fileInfo, err := os.Stat(path); err == nil {
+	s.logger.Ctx(ctx).Infof("Cancelled job for scene %s", sceneID.Hex())
+	return nil
+}
 
-				fileSize := fileInfo.Size()
-				chunks := (fileSize + 1024*1024 - 1) / (1024 * 1024)
-				lastChunkSize := fileSize % (1024 * 1024)
-				if lastChunkSize == 0 {
-					lastChunkSize = 1024 * 1024
-				}
+// cancelScene marks a processing scene as cancelled and removes it from every processing queue, freeing its
+// admission slot. AMPQService drops any worker output that later arrives for a cancelled scene.
+func (s *ClientService) cancelScene(ctx context.Context, sceneID primitive.ObjectID) error {
+	err := s.sceneManager.TransitionStatus(ctx, sceneID, scene.StateCancelled, "")
+	if err != nil {
+		return err
+	}
+	s.mqService.NotifySlotFreed()
+
+	return removeFromQueues(ctx, s.queueManager, sceneID, s.queueManager.GetQueueNames()...)
+}
+
+// removeFromQueues removes a scene from each of the given processing queues, ignoring queues it is not in.
+func removeFromQueues(ctx context.Context, qlm *queue.QueueListManager, sceneID primitive.ObjectID, queueNames ...string) error {
+	for _, queueName := range queueNames {
+		err := qlm.DeleteFromQueue(ctx, queueName, sceneID)
+		if err != nil && !errors.Is(err, queue.ErrIDNotFoundInQueue) && !errors.Is(err, queue.ErrInvalidOpOnEmptyQueue) && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResourceInfo is information about a single output file of a scene.
+type ResourceInfo struct {
+	Exists        bool   `json:"exists"`
+	Size          int64  `json:"size,omitempty"`
+	Chunks        int    `json:"chunks,omitempty"`
+	LastChunkSize int64  `json:"last_chunk_size,omitempty"`
+	SHA256        string `json:"sha256,omitempty"`
+}
+
+// SceneMetadata is information about all output files of a scene, by output type and iteration.
+type SceneMetadata struct {
+	ChunkSize int64                              `json:"chunk_size"`
+	Resources map[string]map[string]ResourceInfo `json:"resources"`
+	// latest modification time of the output files, zero if there are none
+	ModifiedAt time.Time `json:"-"`
+}
+
+// GetSceneMetadata returns metadata about the resources available for the given scene.
+//
+// Returns error if the user does not have access to the scene or an error occurred.
+// For each available output file type, it returns a map of iteration numbers to file information.
+// Specifically, it returns whether the file exists, its size, number of chunks, and size of the last chunk.
+// Checkpoints additionally include their SHA-256, so clients can verify the downloaded file.
+// chunkSize is resolved with ResolveChunkSize, and the size used is echoed back so it can be passed to chunked downloads.
+// version selects a training run of the scene, the current run if 0, see SceneVersions.go. Returns
+// scene.ErrRunNotFound if the scene has no such run.
+func (s *ClientService) GetSceneMetadata(ctx context.Context, userID, sceneID primitive.ObjectID, chunkSize int64, version int) (_ *SceneMetadata, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetSceneMetadata", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadMetadata); err != nil {
+		return nil, err
+	}
+
+	return s.sceneMetadata(ctx, sceneID, version, s.ResolveChunkSize(chunkSize), "")
+}
+
+// sceneMetadata builds the SceneMetadata of a training run of a scene, the current run if version is 0, without
+// checking access. chunkSize must already be resolved. If outputType is not empty, only resources of that output type
+// are included.
+func (s *ClientService) sceneMetadata(ctx context.Context, sceneID primitive.ObjectID, version int, chunkSize int64, outputType string) (*SceneMetadata, error) {
+	resources, err := s.runResources(ctx, sceneID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &SceneMetadata{
+		ChunkSize: chunkSize,
+		Resources: make(map[string]map[string]ResourceInfo),
+	}
+
+	for ot, iterations := range resources {
+		if outputType != "" && ot != outputType {
+			continue
+		}
+
+		metadata.Resources[ot] = make(map[string]ResourceInfo)
+
+		for iteration, stat := range iterations {
+			info := ResourceInfo{Exists: false}
+
+			if stat.Exists {
+				chunks, lastChunkSize := ChunkLayout(stat.Size, chunkSize)
 
 				info = ResourceInfo{
 					Exists:        true,
-					Size:          fileSize,
-					Chunks:        int(chunks),
+					Size:          stat.Size,
+					Chunks:        chunks,
 					LastChunkSize: lastChunkSize,
+					SHA256:        stat.SHA256,
+				}
+				if stat.ModTime.After(metadata.ModifiedAt) {
+					metadata.ModifiedAt = stat.ModTime
 				}
 			}
 
@@ -179,45 +779,152 @@ func (s *ClientService) GetSceneMetadata(ctx context.Context, userID, sceneID pr
 	return metadata, nil
 }
 
-// HandleIncomingVideo processes the video file uploaded by the user and starts the processing pipeline.
+// applyUploadDefaults replaces the configuration values not provided with an upload by their defaults.
+func applyUploadDefaults(sceneName, trainingMode *string, outputTypes *[]string, saveIterations *[]int, priority *string) {
+	if *sceneName == "" {
+		*sceneName = "Untitled Scene"
+	}
+	if *trainingMode == "" {
+		*trainingMode = "gaussian"
+	}
+	if len(*outputTypes) == 0 {
+		*outputTypes = []string{"video"}
+	}
+	if len(*saveIterations) == 0 {
+		*saveIterations = []int{1000, 7000, 30000}
+	}
+	if *priority == "" {
+		*priority = scene.PriorityNormal
+	}
+}
+
+// HandleIncomingVideo processes the video file uploaded by the user and starts the processing pipeline. A .zip file
+// is handled as an image set by HandleIncomingImageSet instead, which frame sampling does not apply to.
 //
 // If a training config value is not provided, a default value is used.
 //
-// Returns the scene ID if successful, error otherwise.
+// Returns the scene ID if successful, error otherwise. Returns ErrBadVideoContent if the file content is not an MP4,
+// ErrVideoProbeFailed if it has no readable video stream, and one of the ErrVideoToo* errors if it is outside
+// the limits of the training mode. Returns scene.ErrInvalidTrainingConfig or ErrTooFewSampledFrames if the frame
+// sampling is invalid for the video, see VideoLimits.CheckSampling, or if the config is outside TrainingLimits. Returns ErrInvalidPriority or ErrPriorityNotAllowed if the priority is rejected.
+// Returns ErrContentRejected if the content scanner flags the file, or ErrScannerUnavailable if it could not be
+// scanned and scanning fails closed. Returns ErrAtCapacity if uploads are rejected while the most jobs allowed are
+// in flight, see AdmissionConfig, and the errors of checkUploadQuota if the user is out of quota. Returns
+// ErrUploadSizeLimit or ErrUploadDurationLimit if the video is over the upload limits of the user's tier, see
+// UploadLimits.go. The size is enforced as the video is copied, so a larger body than declared is not stored in full.
+//
+// The SHA-256 of the video is stored with the scene. If checksum is provided, ErrChecksumMismatch is returned unless
+// it is the video's hex SHA-256. If reuseSfm is set and the user has a scene of the same video with sfm output, that
+// output is reused and only the training job is published, see reuseSfm. Neither applies to image sets.
+//
+// If orgID is not nil, the scene is uploaded into the workspace of that organization, which the user must be an admin
+// or member of, see checkOrgUpload.
+//
+// The scene and the user's scene list are written in one transaction, and the sfm job is only submitted once it
+// commits. A job that fails to publish does not fail the upload, see AMPQService.DeferSFMJob and
+// AMPQService.DeferTrainingJob.
 func (s *ClientService) HandleIncomingVideo(
 	ctx context.Context,
 	userID primitive.ObjectID,
+	orgID primitive.ObjectID,
 	file *multipart.FileHeader,
 	trainingMode string,
 	outputTypes []string,
 	saveIterations []int,
 	totalIterations int,
+	frameSampleRate int,
+	targetFrameCount int,
 	sceneName string,
-) (string, error) {
+	priority string,
+	checksum string,
+	reuseSfm bool,
+) (_ string, err error) {
+	// Image sets are accepted in place of a video, see HandleIncomingImageSet
+	if file != nil && filepath.Ext(file.Filename) == ".zip" {
+		if frameSampleRate > 0 || targetFrameCount > 0 {
+			return "", NewValidationError(
+				"frame sampling does not apply to image sets",
+				map[string]string{"frame_sample_rate": "not allowed for image sets", "target_frame_count": "not allowed for image sets"},
+				scene.ErrInvalidTrainingConfig,
+			)
+		}
+		if checksum != "" || reuseSfm {
+			return "", NewValidationError(
+				"checksums and sfm reuse do not apply to image sets",
+				map[string]string{"sha256": "not allowed for image sets", "reuse_sfm": "not allowed for image sets"},
+				nil,
+			)
+		}
+		return s.HandleIncomingImageSet(ctx, userID, orgID, file, trainingMode, outputTypes, saveIterations, totalIterations, sceneName, priority)
+	}
+
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.HandleIncomingVideo", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	start := time.Now()
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		s.metrics.UploadDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	}()
+
 	// Validate video file
 	if file == nil {
-		return "", fmt.Errorf("file not received")
+		return "", NewValidationError("file not received", map[string]string{"file": "required"}, nil)
 	}
 
 	fileName := file.Filename
 	if fileName == "" {
-		return "", fmt.Errorf("file not received")
+		return "", NewValidationError("file not received", map[string]string{"file": "required"}, nil)
 	}
 
 	fileExt := filepath.Ext(fileName)
 	if fileExt != ".mp4" {
-		return "", fmt.Errorf("improper file extension")
+		return "", NewValidationError("improper file extension", map[string]string{"file": "must be an .mp4 or .zip file"}, nil)
+	}
+
+	// Reject invalid configs before the video is stored
+	applyUploadDefaults(&sceneName, &trainingMode, &outputTypes, &saveIterations, &priority)
+	err = s.trainingLimits.Check(&scene.NerfTrainingConfig{
+		TrainingMode:     trainingMode,
+		OutputTypes:      outputTypes,
+		SaveIterations:   saveIterations,
+		TotalIterations:  totalIterations,
+		FrameSampleRate:  frameSampleRate,
+		TargetFrameCount: targetFrameCount,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Uploads are rejected before being stored if the user is out of quota, or no job can be admitted when configured to
+	if err := s.checkOrgUpload(ctx, userID, orgID); err != nil {
+		return "", err
+	}
+	if err := s.checkUploadQuota(ctx, userID, file.Size); err != nil {
+		return "", err
+	}
+	tier, limits, err := s.uploadLimitsFor(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if limits.MaxSize > 0 && file.Size > limits.MaxSize {
+		return "", uploadSizeError(tier, limits.MaxSize, file.Size)
+	}
+	if err := s.mqService.CheckAdmission(ctx); err != nil {
+		return "", err
 	}
 
 	sceneID := primitive.NewObjectID()
 
-	// Save video to file storage
-	videoName := sceneID.Hex() + ".mp4"
-	videosFolder := "data/raw/videos"
-	if err := os.MkdirAll(videosFolder, os.ModePerm); err != nil {
+	// Save video to file storage. A rejected upload removes the whole scene directory, as nothing else is in it yet.
+	sceneDir := s.sceneManager.SceneDir(sceneID)
+	videoFilePath := s.sceneManager.RawVideoPath(sceneID)
+	if err := os.MkdirAll(filepath.Dir(videoFilePath), os.ModePerm); err != nil {
 		return "", err
 	}
-	videoFilePath := filepath.Join(videosFolder, videoName)
 
 	dst, err := os.Create(videoFilePath)
 	if err != nil {
@@ -231,66 +938,192 @@ func (s *ClientService) HandleIncomingVideo(
 	}
 	defer src.Close()
 
-	if _, err = io.Copy(dst, src); err != nil {
+	// The container signature is checked as the first bytes are copied, so a file that is clearly
+	// not a video is rejected without being written to storage in full. Likewise the copy is cut off once the size limit
+	// of the user's tier is crossed. The checksum is computed along the way.
+	hash := sha256.New()
+	videoSize, err := io.Copy(io.MultiWriter(dst, hash), limitUploadSize(newMP4SniffReader(src), tier, limits))
+	if err != nil {
+		dst.Close()
+		os.RemoveAll(sceneDir)
+		if errors.Is(err, ErrBadVideoContent) || errors.Is(err, ErrUploadSizeLimit) {
+			s.logger.Infof("Rejected upload %s: %v", fileName, err)
+		}
 		return "", err
 	}
 
+	dst.Close()
+
+	videoSHA256 := hex.EncodeToString(hash.Sum(nil))
+	if checksum != "" && !strings.EqualFold(checksum, videoSHA256) {
+		os.RemoveAll(sceneDir)
+		s.logger.Ctx(ctx).Infof("Rejected upload %s: SHA-256 %s does not match checksum %s", fileName, videoSHA256, checksum)
+		return "", ErrChecksumMismatch
+	}
+
+	return s.createVideoScene(ctx, userID, orgID, sceneID, fileName, videoSize, videoSHA256, trainingMode, outputTypes,
+		saveIterations, totalIterations, frameSampleRate, targetFrameCount, sceneName, priority, reuseSfm)
+}
+
+// createVideoScene validates a video stored at the raw video path of sceneID, and creates the scene for it and submits
+// its sfm job, as HandleIncomingVideo describes. A rejected video has the scene's directory removed.
+//
+// Returns the scene ID if successful, error otherwise.
+func (s *ClientService) createVideoScene(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	orgID primitive.ObjectID,
+	sceneID primitive.ObjectID,
+	fileName string,
+	videoSize int64,
+	videoSHA256 string,
+	trainingMode string,
+	outputTypes []string,
+	saveIterations []int,
+	totalIterations int,
+	frameSampleRate int,
+	targetFrameCount int,
+	sceneName string,
+	priority string,
+	reuseSfm bool,
+) (string, error) {
+	sceneDir := s.sceneManager.SceneDir(sceneID)
+	videoFilePath := s.sceneManager.RawVideoPath(sceneID)
+
 	// Handle non-provided configuration values
-	if sceneName == "" {
-		sceneName = "Untitled Scene"
+	applyUploadDefaults(&sceneName, &trainingMode, &outputTypes, &saveIterations, &priority)
+
+	priority, tier, err := s.resolvePriority(ctx, userID, priority)
+	if err != nil {
+		os.RemoveAll(sceneDir)
+		return "", err
 	}
-	if trainingMode == "" {
-		trainingMode = "gaussian"
+
+	nerfConfig := &scene.NerfTrainingConfig{
+		TrainingMode:     trainingMode,
+		OutputTypes:      outputTypes,
+		SaveIterations:   saveIterations,
+		TotalIterations:  totalIterations,
+		FrameSampleRate:  frameSampleRate,
+		TargetFrameCount: targetFrameCount,
+	}
+
+	// Reject videos outside the limits of the training mode or the user's tier, or that the frame sampling would
+	// leave too few frames of, before any compute is spent on them
+	probe, err := probeVideo(ctx, videoFilePath)
+	if err == nil {
+		err = s.videoLimits[trainingMode].Check(probe)
 	}
-	if len(outputTypes) == 0 {
-		outputTypes = []string{"video"}
+	if err == nil {
+		err = s.videoLimits[trainingMode].CheckSampling(nerfConfig, probe.FrameCount)
 	}
-	if len(saveIterations) == 0 {
-		saveIterations = []int{1000, 7000, 30000}
+	if err == nil {
+		err = s.checkUploadDuration(ctx, userID, probe.Duration)
+	}
+	if err != nil {
+		os.RemoveAll(sceneDir)
+		s.logger.Infof("Rejected upload %s: %v", fileName, err)
+		return "", err
+	}
+
+	// Scan the saved file before any scene exists for it, so a flagged upload leaves nothing behind but its quarantined
+	// file
+	contentScan, err := s.scanUpload(ctx, videoFilePath)
+	if err != nil {
+		os.RemoveAll(sceneDir)
+		s.logger.Infof("Rejected upload %s: %v", fileName, err)
+		return "", err
 	}
 
+	// Scenes reusing the sfm output of the same video skip straight to training. Others wait in the grace period
+	// before their job is published, if one is configured.
+	var reusedSfm *scene.Sfm
+	var reusedSize int64
+	if reuseSfm {
+		reusedSfm, reusedSize = s.reuseSfm(ctx, userID, sceneID, videoSHA256, nerfConfig)
+	}
+	initialState := scene.StateQueued
+	if reusedSfm != nil {
+		initialState = scene.StateSfmDone
+	} else if s.mqService.SFMGracePeriod() > 0 {
+		initialState = scene.StateGracePeriod
+	}
 
 	// Partially Initialize new scene
+	now := time.Now()
 	newScene := &scene.Scene{
 		ID: sceneID,
 		Video: &scene.Video{
-			FilePath: videoFilePath,
+			FilePath:   videoFilePath,
+			FileSize:   videoSize,
+			Width:      probe.Width,
+			Height:     probe.Height,
+			FPS:        int(math.Round(probe.FPS)),
+			Duration:   int(probe.Duration.Seconds()),
+			FrameCount: probe.FrameCount,
+			SHA256:     videoSHA256,
 		},
+		Sfm: reusedSfm,
 		Config: &scene.TrainingConfig{
-			NerfTrainingConfig: &scene.NerfTrainingConfig{
-				TrainingMode:    trainingMode,
-				OutputTypes:     outputTypes,
-				SaveIterations:  saveIterations,
-				TotalIterations: totalIterations,
-			},
-		},
-		Name: sceneName,
-	}
-
-	// Insert scene into database
-	if err := s.sceneManager.SetScene(ctx, sceneID, newScene); err != nil {
-		s.logger.Errorf("Failed to insert new scene into database: %v", err)
-		return "", err
-	}
-
-	// Start pipeline
-	if err := s.mqService.PublishSFMJob(ctx, newScene); err != nil {
-		s.logger.Errorf("Failed to publish SFM job: %v", err)
-		return "", err
+			NerfTrainingConfig: nerfConfig,
+			Priority:           priority,
+			Tier:               tier,
+		},
+		Status: &scene.SceneStatus{
+			State:     initialState,
+			UpdatedAt: now,
+			EnteredAt: map[scene.State]time.Time{initialState: now},
+		},
+		Name:        sceneName,
+		OrgID:       orgID,
+		Traceparent: tracing.Traceparent(ctx),
+		ContentScan: contentScan,
 	}
 
-	// Update user with new scene
-	user, err := s.userManager.GetUserByID(ctx, userID)
+	// Insert the scene and add it to the user in one transaction, so a failure part way never leaves a scene without
+	// an owner, or a user listing a scene that does not exist
+	err = s.sceneManager.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.sceneManager.CreateScene(ctx, newScene); err != nil {
+			return err
+		}
+		owner, err := s.userManager.GetUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := owner.AddScene(sceneID); err != nil {
+			return err
+		}
+		if err := s.userManager.UpdateUser(ctx, owner); err != nil {
+			return err
+		}
+		return s.userManager.IncrementStorageUsed(ctx, userID, videoSize+reusedSize)
+	})
 	if err != nil {
+		s.logger.Errorf("Failed to insert new scene into database: %v", err)
+		os.RemoveAll(sceneDir)
 		return "", err
 	}
-	if err := user.AddScene(sceneID); err != nil {
-		return "", err
-	}
-	if err := s.userManager.UpdateUser(ctx, user); err != nil {
-		return "", err
+
+	// Start pipeline, only once the scene is committed. A job that fails to publish is retried rather than failing the
+	// upload, as the scene already exists: by admission control for sfm jobs, and by DeferTrainingJob for training jobs.
+	if reusedSfm != nil {
+		s.logger.Ctx(ctx).Infof("Scene %s reuses the sfm output of scene %s", sceneID.Hex(), reusedSfm.ReusedFrom.Hex())
+		if err := s.mqService.PublishTrainingJob(ctx, newScene); err != nil {
+			s.logger.Errorf("Failed to publish training job for scene %s, retrying: %v", sceneID.Hex(), err)
+			s.mqService.DeferTrainingJob(ctx, sceneID)
+		}
+	} else if err := s.mqService.SubmitSFMJob(ctx, newScene); err != nil {
+		s.logger.Errorf("Failed to publish SFM job for scene %s, retrying: %v", sceneID.Hex(), err)
+		s.mqService.DeferSFMJob(ctx, sceneID)
 	}
 
+	s.createThumbnail(ctx, userID, newScene)
+	s.recordUpload(ctx, userID, sceneID, scene.InputTypeVideo)
+
+	s.metrics.UploadsTotal.WithLabelValues(trainingMode).Inc()
+	s.metrics.UploadBytesTotal.WithLabelValues(trainingMode).Add(float64(videoSize))
+	s.metrics.UploadSize.WithLabelValues(trainingMode).Observe(float64(videoSize))
+
 	return sceneID.Hex(), nil
 }
 
@@ -298,12 +1131,15 @@ func (s *ClientService) HandleIncomingVideo(
 // It is tolerant of scenes that have been deleted / not finished processing by ignoring them.
 //
 // Returns a list of primitive.ObjectID's. Returns error if the user does not exist or non scene-existence errors occur.
-func (s *ClientService) GetUserSceneHistory(ctx context.Context, userID primitive.ObjectID) ([]string, error) {
-	s.logger.Debug("Get user history request received")
+func (s *ClientService) GetUserSceneHistory(ctx context.Context, userID primitive.ObjectID) (_ []string, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetUserSceneHistory", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Get user history request received")
 
 	user, err := s.userManager.GetUserByID(ctx, userID)
 	if err != nil {
-		s.logger.Info("Failed to get user history:", err.Error())
+		s.logger.Ctx(ctx).Info("Failed to get user history:", err.Error())
 		return nil, err
 	}
 
@@ -315,57 +1151,89 @@ func (s *ClientService) GetUserSceneHistory(ctx context.Context, userID primitiv
 		if err == scene.ErrSceneNotFound || err == scene.ErrNerfNotFound {
 			continue
 		}
+		if err == nil {
+			err = s.verifyNotTrashed(ctx, sceneID)
+		}
+		// Ignore scenes in the trash
+		if errors.Is(err, scene.ErrSceneInTrash) {
+			continue
+		}
 		if err != nil {
-			s.logger.Info("Failed to get user history:", err.Error())
+			s.logger.Ctx(ctx).Info("Failed to get user history:", err.Error())
 			return nil, err
 		}
 
 		resources = append(resources, sceneID.Hex())
 	}
 
-	s.logger.Info("User history retrieved successfully")
+	s.logger.Ctx(ctx).Info("User history retrieved successfully")
 	return resources, nil
 }
 
 // GetSceneThumbnailPath returns the path to the thumbnail image for the given scene.
 // Paths are relative to the main *.go executable.
 //
-// Internally, sfm frame data is used to determine the thumbnail path. THese are stored as http endpoints.
-// So, a little bit of string manipulation is required.
+// Thumbnails are JPEGs extracted from the latest rendered video of the scene, or its uploaded input until a video is
+// rendered, see Thumbnail.go. Scenes without either, i.e those uploaded with pre-computed camera poses, use their first
+// sfm frame instead. These are stored as http endpoints, so a little bit of string manipulation is required.
 //
 // Returns ("", error) if the user does not have access to the scene or an error occurred.
-func (s *ClientService) GetSceneThumbnailPath(ctx context.Context, userID, sceneID primitive.ObjectID) (string, error) {
-	s.logger.Debug("Get scene thumbnail request received")
+func (s *ClientService) GetSceneThumbnailPath(ctx context.Context, userID, sceneID primitive.ObjectID) (_ string, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetSceneThumbnailPath", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get scene thumbnail request received")
 
 	// Verify user access to scene
-	if err := s.verifyUserAccess(ctx, userID, sceneID); err != nil {
-		s.logger.Info("Invalid user ID access:", err.Error())
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionDownload); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
 		return "", err
 	}
 
-	sfm, err := s.sceneManager.GetSfm(ctx, sceneID)
+	sc, err := s.sceneManager.GetScene(ctx, sceneID)
 	if err != nil {
-		s.logger.Info("Invalid scene ID:", err.Error())
+		s.logger.Ctx(ctx).Info("Invalid scene ID:", err.Error())
 		return "", err
 	}
 
-	if len(sfm.Frames) == 0 {
-		s.logger.Info("No frames found in SFM data")
-		return "", fmt.Errorf("no frames found in SFM data")
+	thumbnailPath, err := s.refreshThumbnail(ctx, sc)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to generate thumbnail for scene %s: %v", sceneID.Hex(), err)
+	}
+	if thumbnailPath != "" {
+		s.logger.Ctx(ctx).Info("Thumbnail retrieved successfully")
+		return thumbnailPath, nil
+	}
+
+	if sc.Sfm == nil || len(sc.Sfm.Frames) == 0 {
+		s.logger.Ctx(ctx).Info("No frames found in SFM data")
+		return "", newError(ErrNotFound, "no frames found in SFM data", nil)
 	}
 
 	// Use the first frame as the thumbnail
-	thumbnailPath := sfm.Frames[0].FilePath
+	framePath := sc.Sfm.Frames[0].FilePath
 
-	if filepath.Ext(thumbnailPath) != ".png" {
-		s.logger.Info("First frame is not a PNG file")
+	if filepath.Ext(framePath) != ".png" {
+		s.logger.Ctx(ctx).Info("First frame is not a PNG file")
 		return "", fmt.Errorf("first frame is not a PNG file")
 	}
 
-	// Convert API endpoint path to local file system path
-	u, err := url.Parse(thumbnailPath)
+	localPath, err := frameLocalPath(framePath)
+	if err != nil {
+		s.logger.Ctx(ctx).Info("Invalid frame path:", err.Error())
+		return "", err
+	}
+
+	s.logger.Ctx(ctx).Info("Thumbnail retrieved successfully")
+	return localPath, nil
+}
+
+// frameLocalPath converts the API endpoint of a sfm frame into its local file system path.
+// Paths are relative to the main *.go executable.
+func frameLocalPath(frameURL string) (string, error) {
+	u, err := url.Parse(frameURL)
 	if err != nil {
-		s.logger.Info("Invalid URL:", err.Error())
 		return "", fmt.Errorf("invalid URL: %v", err)
 	}
 
@@ -374,72 +1242,473 @@ func (s *ClientService) GetSceneThumbnailPath(ctx context.Context, userID, scene
 
 	// Ensure the path starts with "/data"
 	if !strings.HasPrefix(localPath, "data") {
-		s.logger.Info("Invalid path: does not start with data")
 		return "", fmt.Errorf("invalid path: does not start with data")
 	}
 
-	s.logger.Info("Thumbnail retrieved successfully")
 	return localPath, nil
 }
+
+// Preview generation settings
+const (
+	// previewOutputType is the output type of the rendered frames preview clips are built from.
+	previewOutputType = "video"
+	// minPreviewFrames is the fewest rendered frames needed to build a preview clip. With fewer, the thumbnail is used instead.
+	minPreviewFrames = 8
+	// maxPreviewFrames caps the frames stitched into a preview clip, evenly sampled from all rendered frames.
+	maxPreviewFrames = 60
+	// previewFPS is the frame rate of the preview clip.
+	previewFPS = 12
+	// previewFileName is the name of the cached preview clip, stored alongside the rendered video it was built from.
+	previewFileName = "preview.mp4"
+)
+
+// GetScenePreviewPath returns the path to a short looping MP4 preview of the given scene.
+// Paths are relative to the main *.go executable.
+//
+// On first request, the clip is stitched with ffmpeg from the frames rendered at the scene's latest completed
+// iteration, and cached alongside them, so a later iteration gets a new clip. Frames play forwards then backwards, so
+// the clip loops seamlessly. Concurrent requests for the same scene wait for a single generation rather than each
+// invoking ffmpeg. If there are not yet enough rendered frames, i.e training has not rendered any, the thumbnail path
+// is returned instead.
+//
+// Returns ("", error) if the user does not have access to the scene or an error occurred.
+func (s *ClientService) GetScenePreviewPath(ctx context.Context, userID, sceneID primitive.ObjectID) (_ string, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetScenePreviewPath", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get scene preview request received")
+
+	// Verify user access to scene
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionDownload); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return "", err
+	}
+
+	nerf, err := s.sceneManager.GetNerf(ctx, sceneID)
+	if errors.Is(err, scene.ErrNerfNotFound) {
+		s.logger.Ctx(ctx).Debug("No rendered frames yet, falling back to thumbnail")
+		return s.GetSceneThumbnailPath(ctx, userID, sceneID)
+	}
+	if err != nil {
+		s.logger.Ctx(ctx).Info("Invalid scene ID:", err.Error())
+		return "", err
+	}
+	if err := s.restoreOutputs(ctx, sceneID, nerf, previewOutputType); err != nil {
+		return "", err
+	}
+	completed, err := nerf.CompletedIterations(previewOutputType)
+	if err != nil {
+		return "", err
+	}
+	if len(completed) == 0 {
+		s.logger.Ctx(ctx).Debug("No rendered frames yet, falling back to thumbnail")
+		return s.GetSceneThumbnailPath(ctx, userID, sceneID)
+	}
+	iteration := completed[len(completed)-1]
+	renderPath, err := nerf.GetFilePathForTypeAndIter(previewOutputType, iteration)
+	if err != nil {
+		return "", err
+	}
+	previewPath := filepath.Join(filepath.Dir(renderPath), previewFileName)
+
+	if _, err := os.Stat(previewPath); err == nil {
+		s.logger.Ctx(ctx).Debug("Serving cached preview")
+		return previewPath, nil
+	}
+
+	probe, err := probeVideo(ctx, renderPath)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to probe rendered frames of iteration %d: %v", iteration, err)
+		return "", err
+	}
+	if probe.FrameCount < minPreviewFrames {
+		s.logger.Ctx(ctx).Debugf("Only %d frames rendered, falling back to thumbnail", probe.FrameCount)
+		return s.GetSceneThumbnailPath(ctx, userID, sceneID)
+	}
+
+	// Serialize generation per scene
+	lock, _ := s.previewLocks.LoadOrStore(sceneID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, err := os.Stat(previewPath); err == nil {
+		s.logger.Ctx(ctx).Debug("Serving preview generated while waiting")
+		return previewPath, nil
+	}
+
+	framesDir, err := os.MkdirTemp(filepath.Dir(previewPath), "preview-frames-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(framesDir)
+
+	frames, err := extractRenderedFrames(ctx, renderPath, probe.FrameCount, framesDir, maxPreviewFrames)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to extract rendered frames of scene %s: %v", sceneID.Hex(), err)
+		return "", err
+	}
+	size, err := generatePreviewClip(ctx, frames, previewPath)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to generate preview for scene %s: %v", sceneID.Hex(), err)
+		return "", err
+	}
+	// Charged to the owner rather than the requesting user, who may be a collaborator
+	if err := s.addOwnerStorage(ctx, sceneID, size); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to add preview size to storage of the owner of scene %s: %v", sceneID.Hex(), err)
+	}
+
+	s.logger.Ctx(ctx).Infof("Preview generated successfully from iteration %d", iteration)
+	return previewPath, nil
+}
+
+// extractRenderedFrames extracts at most maxFrames frames, evenly spaced across the frameCount frames of the rendered
+// video at renderPath, into dir as JPEG images with ffmpeg, and returns their paths in order.
+func extractRenderedFrames(ctx context.Context, renderPath string, frameCount int, dir string, maxFrames int) ([]string, error) {
+	step := max(1, (frameCount+maxFrames-1)/maxFrames)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y", "-loglevel", "error",
+		"-i", renderPath,
+		"-vf", fmt.Sprintf("select=not(mod(n\\,%d))", step),
+		"-vsync", "vfr", "-frames:v", fmt.Sprint(maxFrames), "-q:v", "3",
+		filepath.Join(dir, "frame_%04d.jpg"),
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, output)
+	}
+
+	frames, err := filepath.Glob(filepath.Join(dir, "frame_*.jpg"))
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("ffmpeg extracted no frames")
+	}
+	slices.Sort(frames)
+	return frames, nil
+}
+
+// generatePreviewClip stitches the given frames into a looping (forwards, then backwards) MP4 at outPath using ffmpeg.
+// The clip is written to a temporary file first, so a failed or interrupted run never leaves a partial preview behind.
+//
+// Returns the size in bytes of the generated clip.
+func generatePreviewClip(ctx context.Context, frames []string, outPath string) (int64, error) {
+	// Ping-pong the frames, without repeating the first and last frames at the turnaround points
+	sequence := slices.Clone(frames)
+	for i := len(frames) - 2; i > 0; i-- {
+		sequence = append(sequence, frames[i])
+	}
+
+	listFile, err := os.CreateTemp(filepath.Dir(outPath), "preview-*.txt")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(listFile.Name())
+
+	frameDuration := 1.0 / previewFPS
+	for _, frame := range sequence {
+		absPath, err := filepath.Abs(frame)
+		if err != nil {
+			listFile.Close()
+			return 0, err
+		}
+		fmt.Fprintf(listFile, "file '%s'\nduration %f\n", absPath, frameDuration)
+	}
+	// The concat demuxer ignores the duration of the last entry unless it is repeated
+	absLast, _ := filepath.Abs(sequence[len(sequence)-1])
+	fmt.Fprintf(listFile, "file '%s'\n", absLast)
+	if err := listFile.Close(); err != nil {
+		return 0, err
+	}
+
+	tmpPath := outPath + ".tmp.mp4"
+	defer os.Remove(tmpPath)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y", "-loglevel", "error",
+		"-f", "concat", "-safe", "0", "-i", listFile.Name(),
+		"-vf", fmt.Sprintf("fps=%d,scale=trunc(iw/2)*2:trunc(ih/2)*2", previewFPS),
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", "-movflags", "+faststart",
+		tmpPath,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("ffmpeg failed: %v: %s", err, output)
+	}
+
+	if err := os.Rename(tmpPath, outPath); err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(outPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
 // GetSceneName returns the name of the scene with the given ID.
 //
 // Returns (string) if scene valid. Returns ("", error) if the user does not have access to the scene or an error occurred.
-func (s *ClientService) GetSceneName(ctx context.Context, userID, sceneID primitive.ObjectID) (string, error) {
-	s.logger.Debug("Get scene name request received")
+func (s *ClientService) GetSceneName(ctx context.Context, userID, sceneID primitive.ObjectID) (_ string, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetSceneName", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get scene name request received")
 
 	// Verify user access to scene
-	if err := s.verifyUserAccess(ctx, userID, sceneID); err != nil {
-		s.logger.Info("Invalid user ID access:", err.Error())
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadMetadata); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
 		return "", err
 	}
 
 	sceneName, err := s.sceneManager.GetSceneName(ctx, sceneID)
 	if err != nil {
-		s.logger.Info("Error getting scene name:", err.Error())
+		s.logger.Ctx(ctx).Info("Error getting scene name:", err.Error())
 		return "", err
 	}
 
-	s.logger.Info("Scene name retrieved successfully")
+	s.logger.Ctx(ctx).Info("Scene name retrieved successfully")
 	return sceneName, nil
 }
 
-// GetSceneOutputPath returns the relative path to the output file for the given scene.
+// SceneOutput is an output file of a scene, as resolved by GetSceneOutput.
+type SceneOutput struct {
+	// relative path to the file, see GetSceneOutput
+	Path string
+	// iteration of the output, resolved to the latest completed iteration if none was requested
+	Iteration int
+	// the file will not be written to again, see outputFinal
+	Final bool
+	// human readable name to save the file as, see outputFileName
+	FileName string
+	// browsers can display the file directly, rather than downloading it
+	Viewable bool
+}
+
+// GetSceneOutput returns the output file for the given scene.
 // Paths are relative to the main *.go executable.
 //
-// Returns (string) if successful. Returns ("", error) if the user does not have access to the scene or an error occurred.
-func (s *ClientService) GetSceneOutputPath(ctx context.Context, userID, sceneID primitive.ObjectID, outputType, iteration string) (string, error) {
-	s.logger.Debug("Get scene output request received")
+// format optionally selects a format other than the stored one, in which case a converted copy is served.
+// See scene.OutputConversions for the supported conversions.
+//
+// Returns (*SceneOutput) if successful. Returns (nil, error) if the user does not have access to the scene or an error occurred.
+// Returns ErrUnsupportedFormat if the output cannot be served in the requested format, or ErrDailyDownloadLimit if the
+// user downloaded the most bytes allowed for the day. The bytes sent should be counted with RecordDownload.
+//
+// version selects a training run of the scene, the current run if 0, see SceneVersions.go. Returns
+// scene.ErrRunNotFound if the scene has no such run.
+func (s *ClientService) GetSceneOutput(ctx context.Context, userID, sceneID primitive.ObjectID, outputType, iteration, format string, version int) (_ *SceneOutput, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetSceneOutput", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex(), log.FieldResourceType, outputType)
+	s.logger.Ctx(ctx).Debug("Get scene output request received")
 
 	// Verify user access to scene
-	if err := s.verifyUserAccess(ctx, userID, sceneID); err != nil {
-		s.logger.Info("Invalid user ID access:", err.Error())
-		return "", err
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionDownload); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+	if err := s.checkDownloadQuota(ctx, userID); err != nil {
+		return nil, err
 	}
 
-	nerf, err := s.sceneManager.GetNerf(ctx, sceneID)
+	return s.sceneOutput(ctx, sceneID, outputType, iteration, format, version)
+}
+
+// sceneOutput resolves an output file of a scene as GetSceneOutput does, without checking access.
+func (s *ClientService) sceneOutput(ctx context.Context, sceneID primitive.ObjectID, outputType, iteration, format string, version int) (*SceneOutput, error) {
+	run, err := s.previousRun(ctx, sceneID, version)
 	if err != nil {
-		s.logger.Info("Invalid scene ID:", err.Error())
-		return "", err
+		return nil, err
+	}
+	var nerf *scene.Nerf
+	if run != nil {
+		nerf = run.Nerf
+	} else {
+		nerf, err = s.sceneManager.GetNerf(ctx, sceneID)
+		if err != nil {
+			s.logger.Ctx(ctx).Info("Invalid scene ID:", err.Error())
+			return nil, err
+		}
 	}
 
+	if err := s.restoreOutputs(ctx, sceneID, nerf, outputType); err != nil {
+		return nil, err
+	}
+
+	completed, err := nerf.CompletedIterations(outputType)
+	if err != nil {
+		s.logger.Ctx(ctx).Info("Error getting completed iterations:", err.Error())
+		return nil, err
+	}
+
+	// Without an iteration, the latest iteration whose file is actually on disk is given
 	intIteration := -1
 	if iteration == "" {
-		intIteration = -1
+		if len(completed) == 0 {
+			return nil, scene.ErrNoOutputPaths
+		}
+		intIteration = completed[len(completed)-1]
 	} else {
 		intIteration, err = strconv.Atoi(iteration)
 		if err != nil {
-			s.logger.Info("Invalid iteration:", err.Error())
-			return "", err
+			s.logger.Ctx(ctx).Info("Invalid iteration:", err.Error())
+			return nil, err
 		}
 	}
 
 	outputPath, err := nerf.GetFilePathForTypeAndIter(outputType, intIteration)
 	if err != nil {
-		s.logger.Info("Error getting output file:", err.Error())
-		return "", err
+		s.logger.Ctx(ctx).Info("Error getting output file:", err.Error())
+		return nil, err
+	}
+
+	if outputType == scene.OutputTypeCheckpoint {
+		if err := s.verifyCheckpoint(outputPath, checkpointChecksum(nerf, outputPath)); err != nil {
+			return nil, err
+		}
+	}
+
+	final := true
+	if run == nil {
+		final, err = s.outputFinal(ctx, sceneID, intIteration, completed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	outputPath, err = s.convertOutput(ctx, sceneID, outputType, outputPath, format)
+	if err != nil {
+		return nil, err
+	}
+
+	sceneName, err := s.sceneManager.GetSceneName(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	if run != nil {
+		sceneName = fmt.Sprintf("%s v%d", sceneName, run.Version)
+	}
+	ext := strings.TrimPrefix(filepath.Ext(outputPath), ".")
+	return &SceneOutput{
+		Path:      outputPath,
+		Iteration: intIteration,
+		Final:     final,
+		FileName:  outputFileName(sceneName, sceneID, outputType, intIteration, ext),
+		Viewable:  scene.IsViewableFormat(ext),
+	}, nil
+}
+
+// maxFileNameSceneLength is the most characters of a scene name used in output file names.
+const maxFileNameSceneLength = 64
+
+// outputFileName returns the name an output file is saved as, <scene name>_<output type>_iter<iteration>.<ext>.
+//
+// The scene name is reduced to letters, digits, '-' and '.', with every other run of characters replaced by '_', so it
+// is safe in headers and on every file system. Scenes without a usable name are named after their ID.
+func outputFileName(sceneName string, sceneID primitive.ObjectID, outputType string, iteration int, ext string) string {
+	var b strings.Builder
+	separated := true
+	for _, r := range sceneName {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '.' {
+			b.WriteRune(r)
+			separated = false
+		} else if !separated {
+			b.WriteByte('_')
+			separated = true
+		}
+	}
+
+	name := []rune(strings.Trim(b.String(), "._-"))
+	if len(name) > maxFileNameSceneLength {
+		name = []rune(strings.TrimRight(string(name[:maxFileNameSceneLength]), "._-"))
+	}
+	if len(name) == 0 {
+		name = []rune("scene_" + sceneID.Hex())
+	}
+
+	fileName := fmt.Sprintf("%s_%s_iter%d", string(name), outputType, iteration)
+	if ext != "" {
+		fileName += "." + ext
+	}
+	return fileName
+}
+
+// outputFinal reports whether the output file of the given iteration will not be written to again. completed are
+// the completed iterations of the output type, sorted ascending.
+//
+// Outputs of a scene that is no longer processing are final. While the scene is processing, only iterations before
+// the latest completed one are, as the latest file may still be being written by the worker.
+func (s *ClientService) outputFinal(ctx context.Context, sceneID primitive.ObjectID, iteration int, completed []int) (bool, error) {
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil {
+		return false, err
+	}
+	if status.State.IsTerminal() {
+		return true, nil
+	}
+	return len(completed) > 0 && iteration < completed[len(completed)-1], nil
+}
+
+// SceneStatusReport is the persisted status of a scene, along with the iterations that can be downloaded for each
+// of its configured output types. SfmSkipped is set for scenes uploaded with pre-computed sfm output, which never
+// run the sfm stage.
+type SceneStatusReport struct {
+	*scene.SceneStatus
+	ReadyIterations map[string][]int `json:"ready_iterations"`
+	SfmSkipped      bool             `json:"sfm_skipped"`
+}
+
+// GetSceneStatus returns the persisted processing status of the given scene.
+// The status is transitioned by AMPQService as the scene moves through the pipeline, so no queue lookups are needed.
+// Its progress is the latest reported by a worker, even if not yet persisted, see AMPQService.LatestProgress.
+//
+// Returns (nil, error) if the user does not have access to the scene or an error occurred.
+func (s *ClientService) GetSceneStatus(ctx context.Context, userID, sceneID primitive.ObjectID) (_ *SceneStatusReport, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetSceneStatus", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get scene status request received")
+
+	// Verify user access to scene
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadStatus); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil {
+		s.logger.Ctx(ctx).Info("Error getting scene status:", err.Error())
+		return nil, err
+	}
+
+	config, err := s.trainingConfig(ctx, sceneID)
+	if err != nil {
+		s.logger.Ctx(ctx).Info("Error getting scene config:", err.Error())
+		return nil, err
+	}
+
+	if progress := s.mqService.pendingProgress(sceneID); progress != nil {
+		status.Progress = progress
+	}
+
+	report := &SceneStatusReport{SceneStatus: status, ReadyIterations: make(map[string][]int)}
+	sfm, err := s.sceneManager.GetSfm(ctx, sceneID)
+	if err != nil && !errors.Is(err, scene.ErrSfmNotFound) {
+		return nil, err
+	}
+	report.SfmSkipped = sfm != nil && sfm.Precomputed
+	for _, outputType := range config.NerfTrainingConfig.OutputTypes {
+		report.ReadyIterations[outputType], err = s.sceneManager.GetCompletedIterations(ctx, sceneID, outputType)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return outputPath, nil
+	return report, nil
 }
 
 // GetSceneProgress returns the progress of the scene processing pipeline for the given scene.
@@ -456,12 +1725,16 @@ func (s *ClientService) GetSceneOutputPath(ctx context.Context, userID, sceneID
 //	    "stage_position": int,
 //	    "stage_size": int,
 //	}
-func (s *ClientService) GetSceneProgress(ctx context.Context, userID, sceneID primitive.ObjectID) (map[string]interface{}, error) {
-	s.logger.Debug("Get scene progress handler")
+func (s *ClientService) GetSceneProgress(ctx context.Context, userID, sceneID primitive.ObjectID) (_ map[string]interface{}, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetSceneProgress", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get scene progress handler")
 
 	// Verify user access to scene
-	if err := s.verifyUserAccess(ctx, userID, sceneID); err != nil {
-		s.logger.Info("Invalid user ID access:", err.Error())
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadStatus); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
 		return nil, err
 	}
 
@@ -471,10 +1744,9 @@ func (s *ClientService) GetSceneProgress(ctx context.Context, userID, sceneID pr
 	var stagePosition = -1
 	var stageSize = -1
 	stageIdx := -1
-	var err error
 
 	queueNames := s.queueManager.GetQueueNames()
-	s.logger.Debugf("Queue names: %v", queueNames)
+	s.logger.Ctx(ctx).Debugf("Queue names: %v", queueNames)
 
 	for idx, queueName := range queueNames {
 
@@ -482,7 +1754,7 @@ func (s *ClientService) GetSceneProgress(ctx context.Context, userID, sceneID pr
 		if idx == 0 {
 			overallPosition, overallSize, err = s.queueManager.GetQueuePosition(ctx, queueName, sceneID)
 			if err != nil && err != queue.ErrIDNotFoundInQueue {
-				s.logger.Info("Error getting overall queue position:", err.Error())
+				s.logger.Ctx(ctx).Info("Error getting overall queue position:", err.Error())
 				return nil, err
 			}
 			if err == queue.ErrIDNotFoundInQueue {
@@ -499,7 +1771,7 @@ func (s *ClientService) GetSceneProgress(ctx context.Context, userID, sceneID pr
 		// Training stages (sfm_list, nerf_list)
 		size, position, err := s.queueManager.GetQueuePosition(ctx, queueName, sceneID)
 		if err != nil && err != queue.ErrIDNotFoundInQueue {
-			s.logger.Info("Error getting stage queue position:", err.Error())
+			s.logger.Ctx(ctx).Info("Error getting stage queue position:", err.Error())
 			return nil, err
 		}
 		// ID found in stage
@@ -511,7 +1783,7 @@ func (s *ClientService) GetSceneProgress(ctx context.Context, userID, sceneID pr
 		}
 	}
 
-	s.logger.Debugf("Processing: %v, Overall position: %d, Overall size: %d, Stage Idx: %d, Stage position: %d, Stage size: %d", processing, overallPosition, overallSize, stageIdx, stagePosition, stageSize)
+	s.logger.Ctx(ctx).Debugf("Processing: %v, Overall position: %d, Overall size: %d, Stage Idx: %d, Stage position: %d, Stage size: %d", processing, overallPosition, overallSize, stageIdx, stagePosition, stageSize)
 
 	if !processing{
 		return map[string]interface{}{
@@ -528,3 +1800,178 @@ func (s *ClientService) GetSceneProgress(ctx context.Context, userID, sceneID pr
 		"stage_size":       stageSize,
 	}, nil
 }
+
+// reconcileConcurrency is the maximum number of users whose storage is walked at once by ReconcileAllQuotas.
+const reconcileConcurrency = 4
+
+// QuotaDiscrepancy describes a user whose stored storage counter does not match their actual usage.
+type QuotaDiscrepancy struct {
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Stored    int64  `json:"stored"`
+	Actual    int64  `json:"actual"`
+	Drift     int64  `json:"drift"`
+	Corrected bool   `json:"corrected"`
+	Error     string `json:"error,omitempty"`
+}
+
+// QuotaReconciliationReport is the result of a ReconcileAllQuotas run.
+type QuotaReconciliationReport struct {
+	DryRun        bool               `json:"dry_run"`
+	UsersChecked  int                `json:"users_checked"`
+	TotalStored   int64              `json:"total_stored"`
+	TotalActual   int64              `json:"total_actual"`
+	Discrepancies []QuotaDiscrepancy `json:"discrepancies"`
+}
+
+// ReconcileAllQuotas compares every user's stored storage counter against the bytes actually on disk for their scenes.
+// It is an admin only operation.
+//
+// With dryRun, discrepancies are only reported. Otherwise, each drifting counter is overwritten with the actual usage.
+// Users are walked concurrently, at most reconcileConcurrency at a time. A failure for a single user is recorded in the
+// report rather than aborting the run.
+//
+// Returns (nil, error) if the caller is not an admin or the users could not be listed.
+func (s *ClientService) ReconcileAllQuotas(ctx context.Context, adminUserID primitive.ObjectID, dryRun bool) (_ *QuotaReconciliationReport, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.ReconcileAllQuotas", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Reconcile quotas request received")
+
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid admin access:", err.Error())
+		return nil, err
+	}
+
+	s.audit.Record(ctx, adminUserID, primitive.NilObjectID, audit.ActionAdminReconcile, audit.OutcomeAllowed, fmt.Sprintf("quotas, dry run: %t", dryRun))
+
+	users, err := s.userManager.GetAllUsers(ctx)
+	if err != nil {
+		s.logger.Ctx(ctx).Info("Failed to list users:", err.Error())
+		return nil, err
+	}
+
+	report := &QuotaReconciliationReport{
+		DryRun:        dryRun,
+		UsersChecked:  len(users),
+		Discrepancies: make([]QuotaDiscrepancy, 0),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, reconcileConcurrency)
+
+	for _, u := range users {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(u *user.User) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			actual, err := s.userStorageUsage(ctx, u)
+			drifted := err != nil || actual != u.StorageUsed
+
+			discrepancy := QuotaDiscrepancy{
+				UserID:   u.ID.Hex(),
+				Username: u.Username,
+				Stored:   u.StorageUsed,
+				Actual:   actual,
+				Drift:    u.StorageUsed - actual,
+			}
+			if err != nil {
+				discrepancy.Error = err.Error()
+			} else if drifted && !dryRun {
+				if err := s.userManager.SetStorageUsed(ctx, u.ID, actual); err != nil {
+					discrepancy.Error = err.Error()
+				} else {
+					discrepancy.Corrected = true
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.TotalStored += u.StorageUsed
+			report.TotalActual += actual
+			if drifted {
+				report.Discrepancies = append(report.Discrepancies, discrepancy)
+			}
+		}(u)
+	}
+	wg.Wait()
+
+	s.logger.Ctx(ctx).Infof("Reconciled storage of %d users, %d discrepancies (dry run: %v)", len(users), len(report.Discrepancies), dryRun)
+	return report, nil
+}
+
+// userStorageUsage returns the bytes actually on disk for all of the given user's scenes.
+func (s *ClientService) userStorageUsage(ctx context.Context, u *user.User) (int64, error) {
+	var total int64
+	for _, sceneID := range u.SceneIDs {
+		size, err := s.sceneStorageUsage(ctx, sceneID)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// sceneStorageUsage returns the bytes on disk for a single scene: its raw video, sfm frames, and nerf output.
+// Files that do not exist (i.e, a scene that has not finished processing) count as 0 bytes.
+func (s *ClientService) sceneStorageUsage(ctx context.Context, sceneID primitive.ObjectID) (int64, error) {
+	paths, err := s.sceneStoragePaths(ctx, sceneID)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, path := range paths {
+		size, err := pathSize(path)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// sceneStoragePaths returns every file or directory on disk that belongs to a scene: its scene directory, and any
+// files still in the previous storage layout. The paths may not exist yet.
+func (s *ClientService) sceneStoragePaths(ctx context.Context, sceneID primitive.ObjectID) ([]string, error) {
+	sceneDir := s.sceneManager.SceneDir(sceneID)
+	legacySfm, legacyNerf := s.sceneManager.LegacyStoragePaths(sceneID)
+	paths := []string{sceneDir, legacySfm, legacyNerf}
+
+	video, err := s.sceneManager.GetVideo(ctx, sceneID)
+	if err != nil && !errors.Is(err, scene.ErrSceneNotFound) && !errors.Is(err, scene.ErrVideoNotFound) {
+		return nil, err
+	}
+	if video != nil && video.FilePath != "" && !strings.HasPrefix(video.FilePath, sceneDir+string(filepath.Separator)) {
+		paths = append(paths, video.FilePath)
+	}
+	return paths, nil
+}
+
+// pathSize returns the total size in bytes of a file, or of every file under a directory.
+// A path that does not exist has size 0.
+func pathSize(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	return total, err
+}
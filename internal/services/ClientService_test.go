@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/cache"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/docstore"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/token"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+)
+
+// testPassword is the password of the users newTestUser creates.
+const testPassword = "correct horse 1"
+
+// newTestService creates a ClientService keeping scenes, users and refresh tokens in memory, without a queue, object
+// store, or any other dependency the tests do not need.
+func newTestService(t *testing.T) *ClientService {
+	t.Helper()
+	logger := &log.Logger{SugaredLogger: zap.NewNop().Sugar()}
+	db := docstore.NewMemoryDB()
+	scenes := scene.NewMemoryRepository(db, logger, "test")
+	users := user.NewMemoryRepository(db, logger, user.DefaultCredentialRules())
+	if err := scenes.EnsureIndexes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.EnsureIndexes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tokens := token.NewMemoryRepository(db, logger)
+	if err := tokens.EnsureIndexes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return NewClientService(ClientServiceConfig{
+		SceneManager: scenes,
+		UserManager:  users,
+		TokenManager: tokens,
+		SceneCache:   NewSceneCache(cache.NewLRUCache(100), time.Minute, logger),
+		Logger:       logger,
+		Tokens:       TokenConfig{Secret: []byte("test secret")},
+		// signs share links, see ShareLinks.go
+		ResourceURLKey: []byte("test resource key"),
+	})
+}
+
+// newTestUser registers a user with the given username, and returns it.
+func newTestUser(t *testing.T, s *ClientService, username string) *user.User {
+	t.Helper()
+	ctx := context.Background()
+	if err := s.RegisterUser(ctx, username, testPassword); err != nil {
+		t.Fatalf("register %s: %v", username, err)
+	}
+	u, err := s.userManager.GetUserByUsername(ctx, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// newTestScene creates a finished scene with the given name, owned by owner.
+func newTestScene(t *testing.T, s *ClientService, owner *user.User, name string) *scene.Scene {
+	t.Helper()
+	ctx := context.Background()
+	sc := &scene.Scene{
+		ID:     primitive.NewObjectID(),
+		Name:   name,
+		Status: &scene.SceneStatus{State: scene.StateCompleted, UpdatedAt: time.Now()},
+	}
+	if err := s.sceneManager.CreateScene(ctx, sc); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.AddScene(sc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.userManager.UpdateUser(ctx, owner); err != nil {
+		t.Fatal(err)
+	}
+	return sc
+}
+
+func TestRegisterUser(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	newTestUser(t, s, "alice")
+
+	if err := s.RegisterUser(ctx, "Alice", testPassword); !errors.Is(err, ErrConflict) || !errors.Is(err, user.ErrUsernameTaken) {
+		t.Errorf("taken username: got %v, want ErrConflict wrapping user.ErrUsernameTaken", err)
+	}
+	if err := s.RegisterUser(ctx, "bob", "short"); !errors.Is(err, ErrValidation) {
+		t.Errorf("weak password: got %v, want ErrValidation", err)
+	}
+}
+
+func TestChunkLayout(t *testing.T) {
+	const cs = 1000
+	tests := []struct {
+		name       string
+		fileSize   int64
+		chunkSize  int64
+		wantChunks int
+		wantLast   int64
+	}{
+		{"empty", 0, cs, 0, 0},
+		{"one byte", 1, cs, 1, 1},
+		{"one byte short of a chunk", cs - 1, cs, 1, cs - 1},
+		{"one chunk", cs, cs, 1, cs},
+		{"one byte over a chunk", cs + 1, cs, 2, 1},
+		{"whole chunks", 3 * cs, cs, 3, cs},
+		{"default chunk size", DefaultChunkSize + 1, 0, 2, 1},
+		{"negative chunk size", DefaultChunkSize, -1, 1, DefaultChunkSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, last := ChunkLayout(tt.fileSize, tt.chunkSize)
+			if chunks != tt.wantChunks || last != tt.wantLast {
+				t.Fatalf("ChunkLayout(%d, %d) = %d, %d, want %d, %d", tt.fileSize, tt.chunkSize, chunks, last, tt.wantChunks, tt.wantLast)
+			}
+
+			// The ranges of the chunks cover the file without gaps, and the indices around them are out of range
+			var next int64
+			for i := range chunks {
+				start, end, err := ChunkRange(tt.fileSize, tt.chunkSize, i)
+				if err != nil || start != next || end < start {
+					t.Fatalf("ChunkRange(%d) = %d, %d, %v, want a range from %d", i, start, end, err, next)
+				}
+				next = end + 1
+			}
+			if next != tt.fileSize {
+				t.Errorf("chunks end at byte %d, want %d", next, tt.fileSize)
+			}
+			for _, i := range []int{-1, chunks} {
+				if _, _, err := ChunkRange(tt.fileSize, tt.chunkSize, i); err == nil {
+					t.Errorf("ChunkRange(%d) of %d chunks: got nil error", i, chunks)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteUserWithoutPassword(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	external, err := s.userManager.GenerateExternalUser(ctx, "github-alice", user.NewIdentity("github", "1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{"", testPassword} {
+		if _, err := s.DeleteUser(ctx, external.ID, password); !errors.Is(err, ErrValidation) || !errors.Is(err, ErrPasswordNotSet) {
+			t.Errorf("password %q: got %v, want ErrValidation wrapping ErrPasswordNotSet", password, err)
+		}
+	}
+	if _, err := s.userManager.GetUserByID(ctx, external.ID); err != nil {
+		t.Errorf("user was deleted: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+// This file contains the content scanning hook run on uploaded files before they are processed.
+//
+// Uploads are scanned by a ContentScanner once saved to disk, before a scene is created for them, so nothing is served
+// from or published for a file before it passed. The default scanner accepts every file, and ClamAVScanner scans with
+// a clamd daemon. Every scan is bounded by a timeout, and ContentScanning.FailOpen decides whether uploads are accepted
+// or rejected while the scanner is unavailable.
+//
+// Flagged files are moved to ContentScanning.QuarantineDir, if set, next to a record of the finding, rather than
+// removed with the rest of the upload. The verdict of accepted uploads is recorded on their scene, see
+// scene.ContentScan.
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+var (
+	// ErrContentRejected is returned when the content scanner flags an uploaded file.
+	ErrContentRejected = errors.New("uploaded file rejected by content scan")
+	// ErrScannerUnavailable is returned when an uploaded file could not be scanned, and scanning fails closed.
+	ErrScannerUnavailable = errors.New("content scanner unavailable, try again later")
+)
+
+// DefaultScanTimeout is used when ContentScanning.Timeout is not set.
+const DefaultScanTimeout = 30 * time.Second
+
+// ContentScanner checks uploaded files for malicious content.
+type ContentScanner interface {
+	// Scan checks the file at path. Returns an error wrapping ErrContentRejected, stating the finding, if the file is
+	// flagged. Any other error means the file could not be scanned.
+	Scan(ctx context.Context, path string) error
+}
+
+// ContentScanning configures how the ClientService scans uploads.
+type ContentScanning struct {
+	// scanner used for uploads, nil accepts every file
+	Scanner ContentScanner
+	// longest a single scan may take
+	Timeout time.Duration
+	// accept uploads that could not be scanned, instead of rejecting them
+	FailOpen bool
+	// directory flagged files are moved to, empty removes them
+	QuarantineDir string
+}
+
+// NoopScanner is a ContentScanner that accepts every file.
+type NoopScanner struct{}
+
+// Scan accepts every file.
+func (NoopScanner) Scan(ctx context.Context, path string) error {
+	return nil
+}
+
+// clamdChunkSize is the size of each chunk streamed to clamd.
+const clamdChunkSize = 64 * 1024
+
+// ClamAVScanner is a ContentScanner that streams files to a clamd daemon over TCP, using its INSTREAM command.
+//
+// The file is streamed rather than passed by path, so clamd does not need access to the server's file system.
+// Files larger than clamd's StreamMaxLength are reported as unscannable by clamd.
+type ClamAVScanner struct {
+	// clamd address, i.e "clamav:3310"
+	Address string
+}
+
+// NewClamAVScanner creates a ClamAVScanner for the clamd daemon at address.
+func NewClamAVScanner(address string) *ClamAVScanner {
+	return &ClamAVScanner{Address: address}
+}
+
+// Scan streams the file at path to clamd and interprets its verdict.
+func (c *ClamAVScanner) Scan(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to clamd: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return fmt.Errorf("failed to send clamd command: %v", err)
+	}
+
+	// Each chunk is prefixed with its length, and a zero length chunk ends the stream
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(append(size, buf[:n]...)); err != nil {
+				return fmt.Errorf("failed to stream file to clamd: %v", err)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("failed to end clamd stream: %v", err)
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return fmt.Errorf("failed to read clamd reply: %v", err)
+	}
+	verdict := strings.TrimSpace(string(bytes.TrimRight(reply, "\x00")))
+
+	// Replies are "stream: OK", "stream: <signature> FOUND", or "<reason> ERROR"
+	switch {
+	case strings.HasSuffix(verdict, " OK"):
+		return nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(verdict, "stream: "), " FOUND")
+		return fmt.Errorf("%w: %s", ErrContentRejected, signature)
+	default:
+		return fmt.Errorf("clamd failed to scan file: %s", verdict)
+	}
+}
+
+// quarantineRecord is the record of a quarantined file, stored next to it.
+type quarantineRecord struct {
+	OriginalPath  string    `json:"original_path"`
+	Finding       string    `json:"finding"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// scanUpload scans an uploaded file with the configured scanner, within the configured timeout, and returns the
+// verdict to record on its scene, or nil if uploads are not scanned.
+//
+// Returns an error wrapping ErrContentRejected if the file is flagged, in which case it is quarantined. If the file
+// could not be scanned, returns ErrScannerUnavailable when failing closed, and the ScanVerdictUnscanned verdict when
+// failing open.
+func (s *ClientService) scanUpload(ctx context.Context, path string) (*scene.ContentScan, error) {
+	if s.scanning.Scanner == nil {
+		return nil, nil
+	}
+
+	timeout := s.scanning.Timeout
+	if timeout <= 0 {
+		timeout = DefaultScanTimeout
+	}
+	scanCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := s.scanning.Scanner.Scan(scanCtx, path)
+	switch {
+	case err == nil:
+		return &scene.ContentScan{Verdict: scene.ScanVerdictClean, ScannedAt: time.Now()}, nil
+	case errors.Is(err, ErrContentRejected):
+		s.quarantine(ctx, path, err)
+		return nil, err
+	case s.scanning.FailOpen:
+		s.logger.Ctx(ctx).Errorf("Content scan of %s failed, accepting upload (fail open): %v", path, err)
+		return &scene.ContentScan{Verdict: scene.ScanVerdictUnscanned, ScannedAt: time.Now()}, nil
+	default:
+		s.logger.Ctx(ctx).Errorf("Content scan of %s failed, rejecting upload (fail closed): %v", path, err)
+		// The cause is kept out of the client message, as it holds internal addresses
+		return nil, newError(ErrUpstream, ErrScannerUnavailable.Error(), fmt.Errorf("%w: %v", ErrScannerUnavailable, err))
+	}
+}
+
+// quarantine moves a flagged file to the quarantine directory, next to a record of the finding, if one is configured.
+// Failures are logged, and leave the file to be removed with the rest of the upload.
+func (s *ClientService) quarantine(ctx context.Context, path string, finding error) {
+	if s.scanning.QuarantineDir == "" {
+		return
+	}
+	logger := s.logger.Ctx(ctx)
+	if err := os.MkdirAll(s.scanning.QuarantineDir, os.ModePerm); err != nil {
+		logger.Errorf("Failed to quarantine flagged file %s: %v", path, err)
+		return
+	}
+
+	now := time.Now()
+	quarantinePath := filepath.Join(s.scanning.QuarantineDir, fmt.Sprintf("%d-%s", now.UnixNano(), filepath.Base(path)))
+	if err := moveFile(path, quarantinePath); err != nil {
+		logger.Errorf("Failed to quarantine flagged file %s: %v", path, err)
+		return
+	}
+	record, err := json.Marshal(quarantineRecord{OriginalPath: path, Finding: finding.Error(), QuarantinedAt: now})
+	if err == nil {
+		err = os.WriteFile(quarantinePath+".json", record, 0o644)
+	}
+	if err != nil {
+		logger.Errorf("Failed to write quarantine record of %s: %v", quarantinePath, err)
+	}
+	logger.Warnf("Quarantined flagged file %s as %s: %v", path, quarantinePath, finding)
+}
+
+// moveFile moves the file at from to to, copying it if they are on different file systems.
+func moveFile(from, to string) error {
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+	return os.Remove(from)
+}
+
+// mergeContentScans returns the verdict of an upload of several files from the verdicts of two of them: unscanned if
+// either is, or the later one.
+func mergeContentScans(a, b *scene.ContentScan) *scene.ContentScan {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.Verdict == scene.ScanVerdictUnscanned && b.Verdict != scene.ScanVerdictUnscanned:
+		return a
+	default:
+		return b
+	}
+}
@@ -0,0 +1,255 @@
+// This file contains the dead-letter handling of worker output that cannot be processed, and the reporting of why a
+// job failed.
+//
+// A message whose processing fails is requeued and delivered again, up to maxDeliveryAttempts times. The attempts of
+// each message are counted in memory by queue and body, so they start over when the server restarts. Once its attempts
+// are exhausted, the message is published to the "dead-letter" queue with the error of its last attempt, and
+// acknowledged. The dead-letter consumer then fails the scene the message was for and records why, so the failure is
+// reported by GetJobError and GetUserHistory instead of the message being retried forever.
+//
+// Workers may dead-letter jobs they cannot process the same way, by publishing them to the "dead-letter" queue with
+// the broker.HeaderDeadLetter headers.
+
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// DefaultMaxDeliveryAttempts is used when the service is created without a maximum number of delivery attempts.
+const DefaultMaxDeliveryAttempts = 5
+
+var (
+	// ErrNoJobError is returned when getting the job error of a scene that has not failed.
+	ErrNoJobError = errors.New("scene has not failed")
+)
+
+// deliveryAttempts counts the failed attempts to process each message, by deliveryKey.
+type deliveryAttempts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// deliveryKey identifies a message across redeliveries by its queue and body.
+func deliveryKey(queueName string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return queueName + ":" + hex.EncodeToString(sum[:])
+}
+
+// failed counts a failed attempt to process a message, and returns its failed attempts so far.
+func (a *deliveryAttempts) failed(key string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.counts == nil {
+		a.counts = make(map[string]int)
+	}
+	a.counts[key]++
+	return a.counts[key]
+}
+
+// forget stops counting the attempts of a message once it was processed or dead-lettered.
+func (a *deliveryAttempts) forget(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.counts, key)
+}
+
+// handleDelivery processes a message with processFunc, and acknowledges it once processed. A message that fails is
+// requeued, or dead-lettered once it failed maxDeliveryAttempts times. Dead-lettered messages are retried forever,
+// as there is nowhere left to send them.
+func (s *AMPQService) handleDelivery(ctx context.Context, queueName string, d *broker.Delivery, processFunc func(*broker.Delivery) error) {
+	key := deliveryKey(queueName, d.Body)
+	err := processFunc(d)
+	if err == nil {
+		s.attempts.forget(key)
+		d.Ack()
+		return
+	}
+
+	attempts := s.attempts.failed(key)
+	if queueName == broker.QueueDeadLetter || attempts < s.maxDeliveryAttempts {
+		s.logger.Ctx(ctx).Errorf("Error processing message from %s (attempt %d of %d): %v", queueName, attempts, s.maxDeliveryAttempts, err)
+		d.Nack(true) // Negative acknowledge and requeue
+		return
+	}
+
+	s.logger.Ctx(ctx).Errorf("Error processing message from %s, dead-lettering it after %d attempts: %v", queueName, attempts, err)
+	if dlErr := s.deadLetter(ctx, queueName, d, attempts, err); dlErr != nil {
+		// Counted attempts are kept, so the message is dead-lettered again on its next failure
+		s.logger.Ctx(ctx).Errorf("Failed to dead-letter message from %s: %v", queueName, dlErr)
+		d.Nack(true)
+		return
+	}
+	s.attempts.forget(key)
+	s.metrics.MessagesDeadLetteredTotal.WithLabelValues(queueName).Inc()
+	d.Ack()
+}
+
+// deadLetter publishes a message to the "dead-letter" queue, along with the queue it was consumed from and the error
+// of its last attempt.
+func (s *AMPQService) deadLetter(ctx context.Context, queueName string, d *broker.Delivery, attempts int, cause error) error {
+	headers := make(map[string]string, len(d.Headers)+3)
+	maps.Copy(headers, d.Headers)
+	headers[broker.HeaderDeadLetterQueue] = queueName
+	headers[broker.HeaderDeadLetterReason] = cause.Error()
+	headers[broker.HeaderDeadLetterAttempts] = strconv.Itoa(attempts)
+
+	return s.mq.PublishDeadLetter(ctx, broker.Job{Body: d.Body, Headers: headers})
+}
+
+// stageOfQueue returns the pipeline stage the messages of a job queue belong to, or an empty string for other queues.
+func stageOfQueue(queueName string) string {
+	switch queueName {
+	case broker.QueueSfmIn, broker.QueueSfmOut:
+		return metrics.StageSfm
+	case broker.QueueNerfIn, broker.QueueNerfOut:
+		return metrics.StageTraining
+	default:
+		return ""
+	}
+}
+
+// processDeadLetter processes a message from the 'dead-letter' queue, failing the scene it was for.
+//
+// The message is the original job or worker output, which is only expected to carry the scene's job ID in "id".
+// The queue it was consumed from, the reason it failed, and its attempts are read from the broker.HeaderDeadLetter
+// headers. Messages that name no scene, or a scene that was deleted, cancelled or already finished, are dropped.
+func (s *AMPQService) processDeadLetter(d *broker.Delivery) (err error) {
+	var data struct {
+		SceneID     string `json:"id"`
+		Traceparent string `json:"traceparent"`
+	}
+	if err := json.Unmarshal(d.Body, &data); err != nil {
+		s.logger.Warnf("Dropping malformed dead-lettered message: %v", err)
+		return nil
+	}
+	sceneID, err := s.sceneManager.ParseJobID(data.SceneID)
+	if err != nil {
+		s.logger.Warnf("Dropping dead-lettered message with invalid ID %q: %v", data.SceneID, err)
+		return nil
+	}
+	queueName := d.Headers[broker.HeaderDeadLetterQueue]
+	if queueName == broker.QueueExportOut {
+		// A failed export does not fail its scene, which is already trained
+		return s.failDeadLetteredExport(context.Background(), sceneID, d.Body, d.Headers[broker.HeaderDeadLetterReason])
+	}
+	if queueName == broker.QueueRenderOut {
+		// Likewise for a failed render
+		return s.failDeadLetteredRender(context.Background(), sceneID, d.Body, d.Headers[broker.HeaderDeadLetterReason])
+	}
+	stage := stageOfQueue(queueName)
+	if stage == "" {
+		s.logger.Warnf("Dropping dead-lettered message of scene %s from unknown queue %q", sceneID.Hex(), queueName)
+		return nil
+	}
+
+	ctx, span := tracing.Start(consumeContext(d, data.Traceparent), "process dead-letter", tracing.KindConsumer)
+	defer span.EndWithError(&err)
+	span.SetAttribute("messaging.system", s.mq.System())
+	span.SetAttribute("messaging.source", broker.QueueDeadLetter)
+	span.SetAttribute("scene.id", sceneID.Hex())
+
+	if s.isAbandoned(ctx, sceneID) {
+		s.logger.Infof("Dropping dead-lettered message for deleted or cancelled scene %s", sceneID.Hex())
+		return nil
+	}
+	sc, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return fmt.Errorf("failed to get dead-lettered scene: %v", err)
+	}
+
+	attempts, _ := strconv.Atoi(d.Headers[broker.HeaderDeadLetterAttempts])
+	reason := d.Headers[broker.HeaderDeadLetterReason]
+	if reason == "" {
+		reason = "unknown error"
+	}
+	failure := &scene.JobFailure{
+		Stage:    stage,
+		Queue:    queueName,
+		Reason:   fmt.Sprintf("%s failed after %d attempts: %s", stage, attempts, reason),
+		Attempts: attempts,
+		FailedAt: time.Now(),
+	}
+	err = s.failJob(ctx, sc, failure)
+	if errors.Is(err, scene.ErrInvalidStatusTransition) {
+		s.logger.Infof("Dropping dead-lettered message for finished scene %s", sceneID.Hex())
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fail dead-lettered scene: %v", err)
+	}
+
+	s.logger.Warnf("Failed scene %s from dead-lettered %s message: %s", sceneID.Hex(), queueName, reason)
+	return nil
+}
+
+// failJob fails the job of a scene, recording why, and removes the scene from the processing queues. The scene's
+// admission slot is freed, and status streams are notified.
+//
+// Returns scene.ErrInvalidStatusTransition if the scene already finished.
+func (s *AMPQService) failJob(ctx context.Context, sc *scene.Scene, failure *scene.JobFailure) error {
+	if err := s.sceneManager.FailJob(ctx, sc.ID, failure); err != nil {
+		return err
+	}
+	s.statusEvents.publish(sc.ID, JobStatusEvent{Type: StatusEventStatus, State: scene.StateFailed, Error: failure.Reason, Time: failure.FailedAt})
+	s.NotifySlotFreed()
+	s.metrics.JobsFailedTotal.WithLabelValues(failure.Stage, trainingModeOf(sc)).Inc()
+
+	if err := removeFromQueues(ctx, s.queueManager, sc.ID, s.queueManager.GetQueueNames()...); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to remove failed scene %s from queues: %v", sc.ID.Hex(), err)
+	}
+	return nil
+}
+
+// JobError is why the job of a failed scene failed. Failure is only set for jobs failed by a worker or the pipeline,
+// see scene.SceneStatus.
+type JobError struct {
+	State   scene.State       `json:"state"`
+	Error   string            `json:"error"`
+	Failure *scene.JobFailure `json:"failure,omitempty"`
+}
+
+// GetJobError returns why the job of a scene failed.
+//
+// Returns ErrNoJobError if the scene has not failed, or (nil, error) if the user does not have access to the scene
+// or an error occurred.
+func (s *ClientService) GetJobError(ctx context.Context, userID, sceneID primitive.ObjectID) (_ *JobError, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetJobError", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get job error request received")
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadStatus); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	if status.State != scene.StateFailed {
+		return nil, ErrNoJobError
+	}
+
+	return &JobError{State: status.State, Error: status.Error, Failure: status.Failure}, nil
+}
@@ -0,0 +1,227 @@
+// This file contains optional email verification of new accounts, where the username is the user's email address.
+//
+// When enabled, accounts are created unverified and a single use token is mailed to the username by a Mailer. Only the
+// SHA-256 of the token is stored, so a leaked database cannot be used to verify accounts. With
+// EmailVerification.RequireForLogin set, unverified accounts cannot log in until verified. Accounts created before
+// verification was enabled count as verified.
+//
+// The default LogMailer only logs mails, and is meant for development. SMTPMailer sends them through an SMTP relay.
+
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrEmailNotVerified is returned when an unverified account logs in while verification is required for login.
+	ErrEmailNotVerified = errors.New("email address not verified")
+	// ErrEmailVerificationDisabled is returned when verifying an account while email verification is disabled.
+	ErrEmailVerificationDisabled = errors.New("email verification is disabled")
+)
+
+// DefaultVerificationTTL is used when EmailVerification.TokenTTL is not set.
+const DefaultVerificationTTL = 24 * time.Hour
+
+// verificationResendInterval is the least time between two verification mails to the same account.
+const verificationResendInterval = time.Minute
+
+// Mailer sends mails to users.
+type Mailer interface {
+	// Send sends a plain text mail to the given address.
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// EmailVerification configures how the ClientService verifies the email address of new accounts.
+type EmailVerification struct {
+	// create accounts unverified and mail them a verification token
+	Enabled bool
+	// refuse logins of unverified accounts
+	RequireForLogin bool
+	// mailer used for verification mails, nil logs them
+	Mailer Mailer
+	// how long a verification token is valid for
+	TokenTTL time.Duration
+	// link mailed to the user, with the token appended as the "token" query parameter. If empty, the token is mailed as is
+	LinkURL string
+}
+
+// LogMailer is a Mailer that only logs mails. Logged mails contain the verification token, so it is not meant for production.
+type LogMailer struct {
+	logger *log.Logger
+}
+
+// NewLogMailer creates a LogMailer logging to logger.
+func NewLogMailer(logger *log.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+// Send logs the mail.
+func (m *LogMailer) Send(ctx context.Context, to, subject, body string) error {
+	m.logger.Infof("Mail to %s, %q: %s", to, subject, body)
+	return nil
+}
+
+// SMTPMailer is a Mailer that sends mails through an SMTP relay, authenticating with PLAIN auth if a username is set.
+type SMTPMailer struct {
+	// relay address, i.e "smtp.example.com:587"
+	Address  string
+	Username string
+	Password string
+	// sender address
+	From string
+}
+
+// NewSMTPMailer creates an SMTPMailer for the relay at address.
+func NewSMTPMailer(address, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{Address: address, Username: username, Password: password, From: from}
+}
+
+// Send sends the mail through the relay. The context is not observed, as net/smtp does not support it.
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return errors.New("mail header contains a line break")
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Address)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		m.From, to, subject, body)
+	return smtp.SendMail(m.Address, auth, m.From, []string{to}, []byte(msg))
+}
+
+// newVerificationToken returns a random verification token, and the SHA-256 of it to store.
+func newVerificationToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashVerificationToken(token), nil
+}
+
+// hashVerificationToken returns the hex SHA-256 of a verification token, as stored in the user document.
+func hashVerificationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// EmailVerificationEnabled reports whether new accounts must verify their email address.
+func (s *ClientService) EmailVerificationEnabled() bool {
+	return s.verification.Enabled
+}
+
+// verificationTTL returns how long new verification tokens are valid for.
+func (s *ClientService) verificationTTL() time.Duration {
+	if s.verification.TokenTTL <= 0 {
+		return DefaultVerificationTTL
+	}
+	return s.verification.TokenTTL
+}
+
+// sendVerification mails a verification token to the given address.
+func (s *ClientService) sendVerification(ctx context.Context, to, token string) error {
+	body := "Your verification code is " + token
+	if s.verification.LinkURL != "" {
+		link, err := url.Parse(s.verification.LinkURL)
+		if err != nil {
+			return err
+		}
+		query := link.Query()
+		query.Set("token", token)
+		link.RawQuery = query.Encode()
+		body = "Verify your email address by opening " + link.String()
+	}
+	body += fmt.Sprintf("\n\nThis expires in %s. If you did not create an account, ignore this mail.", s.verificationTTL())
+
+	mailer := s.verification.Mailer
+	if mailer == nil {
+		mailer = NewLogMailer(s.logger)
+	}
+	return mailer.Send(ctx, to, "Verify your email address", body)
+}
+
+// reissueVerification replaces the verification token of a user and mails the new one.
+func (s *ClientService) reissueVerification(ctx context.Context, userID primitive.ObjectID, to string) error {
+	token, hash, err := newVerificationToken()
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.SetVerificationToken(ctx, userID, hash, time.Now().Add(s.verificationTTL())); err != nil {
+		return err
+	}
+	return s.sendVerification(ctx, to, token)
+}
+
+// VerifyEmail marks the account holding the given verification token as verified.
+//
+// Returns ErrEmailVerificationDisabled if verification is disabled, or user.ErrInvalidVerificationToken or
+// user.ErrVerificationTokenExpired if the token cannot be used.
+func (s *ClientService) VerifyEmail(ctx context.Context, token string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.VerifyEmail", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if !s.verification.Enabled {
+		return ErrEmailVerificationDisabled
+	}
+
+	u, err := s.userManager.VerifyEmail(ctx, hashVerificationToken(token))
+	if err != nil {
+		return err
+	}
+	s.logger.Ctx(ctx).Infof("Verified email of user %s", u.ID.Hex())
+	return nil
+}
+
+// ResendVerification mails a new verification token to the account with the given username, replacing the previous one.
+//
+// Returns nil whether or not the account exists or is already verified, so usernames cannot be enumerated. Mails to
+// the same account are sent at most once per verificationResendInterval.
+func (s *ClientService) ResendVerification(ctx context.Context, username string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.ResendVerification", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if !s.verification.Enabled {
+		return ErrEmailVerificationDisabled
+	}
+
+	u, err := s.userManager.GetUserByUsername(ctx, username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if u.IsVerified() || time.Since(u.VerificationSentAt) < verificationResendInterval {
+		return nil
+	}
+
+	if err := s.reissueVerification(ctx, u.ID, u.Username); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to resend verification mail to user %s: %v", u.ID.Hex(), err)
+		return err
+	}
+	return nil
+}
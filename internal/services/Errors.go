@@ -0,0 +1,279 @@
+// This file contains the error taxonomy returned by the public ClientService methods.
+//
+// Every error a ClientService method returns is an *Error, whose Kind is one of the kind errors below. The kind tells
+// callers what went wrong in general terms (i.e, so the web layer can pick a status code), the message is safe to show
+// to clients, and the cause is kept for logging. Both the kind and the cause can be matched with errors.Is, so checks
+// against specific sentinel errors (i.e, scene.ErrSceneNotFound) keep working.
+//
+// Sentinel errors of the managers and services are classified into kinds by errorKinds. Errors that are not classified
+// are internal, and their message is replaced so internal details never reach clients.
+
+package services
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/batch"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/org"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/upload"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/webhook"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/worker"
+)
+
+// Error kinds
+var (
+	// ErrNotFound is the kind of errors caused by a resource that does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is the kind of errors caused by missing or incorrect credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is the kind of errors caused by a user accessing something they are not allowed to.
+	ErrForbidden = errors.New("forbidden")
+	// ErrValidation is the kind of errors caused by invalid input.
+	ErrValidation = errors.New("validation failed")
+	// ErrConflict is the kind of errors caused by a request that conflicts with the current state of a resource.
+	ErrConflict = errors.New("conflict")
+	// ErrQuotaExceeded is the kind of errors caused by a user exceeding a usage limit.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrTooLarge is the kind of errors caused by an upload over a size or duration limit.
+	ErrTooLarge = errors.New("payload too large")
+	// ErrRateLimited is the kind of errors caused by a user making requests faster than allowed.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUpstream is the kind of errors caused by a dependency (i.e, ffmpeg or the message broker) failing or timing out.
+	ErrUpstream = errors.New("upstream service unavailable")
+	// ErrInternal is the kind of every error that is not classified otherwise.
+	ErrInternal = errors.New("internal error")
+)
+
+// internalErrorMessage replaces the message of unclassified errors.
+const internalErrorMessage = "internal server error"
+
+// Error is an error returned by a public ClientService method.
+//
+// Message is safe to show to clients. Fields optionally holds per field problems of a validation error, keyed by
+// field name. RetryAfter optionally tells clients how long to wait before retrying. Limit optionally holds the limit
+// that was exceeded, i.e of ErrTooLarge errors. Err is the underlying cause, which may hold internal details and should
+// only be logged.
+type Error struct {
+	Kind       error
+	Message    string
+	Fields     map[string]string
+	RetryAfter time.Duration
+	Limit      map[string]any
+	Err        error
+}
+
+// Error returns the client message followed by the cause.
+func (e *Error) Error() string {
+	if e.Err == nil || e.Err.Error() == e.Message {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+// Unwrap returns both the kind and the cause, so either can be matched with errors.Is.
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// newError creates an Error of the given kind with a client message and an internal cause.
+func newError(kind error, message string, cause error) *Error {
+	return &Error{Kind: kind, Message: message, Err: cause}
+}
+
+// NewValidationError creates an ErrValidation Error. fields optionally holds per field problems, keyed by field name.
+func NewValidationError(message string, fields map[string]string, cause error) *Error {
+	return &Error{Kind: ErrValidation, Message: message, Fields: fields, Err: cause}
+}
+
+// errorKinds classifies sentinel errors into error kinds. The first matching entry is used.
+// The client message is the error text, unless message is set to replace text that is not meant for clients.
+var errorKinds = []struct {
+	target  error
+	kind    error
+	message string
+}{
+	{scene.ErrSceneNotFound, ErrNotFound, ""},
+	{scene.ErrVideoNotFound, ErrNotFound, ""},
+	{scene.ErrSfmNotFound, ErrNotFound, ""},
+	{scene.ErrNerfNotFound, ErrNotFound, ""},
+	{scene.ErrRunNotFound, ErrNotFound, ""},
+	{scene.ErrTrainingConfigNotFound, ErrNotFound, ""},
+	{scene.ErrStatusNotFound, ErrNotFound, ""},
+	{scene.ErrSceneInTrash, ErrNotFound, ""},
+	{ErrTrashExpired, ErrNotFound, ""},
+	{scene.ErrNoOutputPaths, ErrNotFound, ""},
+	{user.ErrUserNotFound, ErrNotFound, ""},
+	{user.ErrSceneIDNotFound, ErrNotFound, ""},
+	{scene.ErrCollaboratorNotFound, ErrNotFound, ""},
+	{scene.ErrShareLinkNotFound, ErrNotFound, ""},
+	{upload.ErrUploadNotFound, ErrNotFound, ""},
+	{mongo.ErrNoDocuments, ErrNotFound, "resource not found"},
+	{ErrResourceChanged, ErrNotFound, ""},
+	{ErrEmailVerificationDisabled, ErrNotFound, ""},
+	{ErrWorkerAPIDisabled, ErrNotFound, ""},
+	{ErrNoJobError, ErrNotFound, ""},
+	{user.ErrAPIKeyNotFound, ErrNotFound, ""},
+	{user.ErrIdentityNotFound, ErrNotFound, ""},
+	{user.ErrIdentityTaken, ErrConflict, ""},
+	{user.ErrIdentityExists, ErrConflict, ""},
+	{user.ErrLastLoginMethod, ErrConflict, ""},
+	{webhook.ErrWebhookNotFound, ErrNotFound, ""},
+	{org.ErrOrgNotFound, ErrNotFound, ""},
+	{org.ErrMemberNotFound, ErrNotFound, ""},
+	{org.ErrInvitationNotFound, ErrNotFound, ""},
+	{worker.ErrWorkerNotFound, ErrNotFound, ""},
+	{batch.ErrBatchNotFound, ErrNotFound, ""},
+	{fs.ErrNotExist, ErrNotFound, "file not found"},
+
+	{bcrypt.ErrMismatchedHashAndPassword, ErrUnauthorized, "incorrect password"},
+	{ErrInvalidUploadToken, ErrUnauthorized, ""},
+	{ErrUploadTokenExpired, ErrUnauthorized, ""},
+	{ErrInvalidAccessToken, ErrUnauthorized, ""},
+	{ErrInvalidRefreshToken, ErrUnauthorized, ""},
+	{ErrRefreshTokenReused, ErrUnauthorized, ""},
+	{ErrSessionRevoked, ErrUnauthorized, ""},
+	{ErrSessionNotFound, ErrNotFound, ""},
+	{ErrOAuthProviderNotEnabled, ErrNotFound, ""},
+	{ErrInvalidOAuthState, ErrUnauthorized, ""},
+	{ErrOAuthFailed, ErrUnauthorized, ""},
+	{ErrOAuthProviderUnavailable, ErrUpstream, ErrOAuthProviderUnavailable.Error()},
+	{ErrOAuthEmailTaken, ErrConflict, ""},
+	{ErrInvalidWorkerKey, ErrUnauthorized, ""},
+	{user.ErrInvalidAPIKey, ErrUnauthorized, ""},
+
+	{user.ErrUserNoAccess, ErrForbidden, ""},
+	{ErrPriorityNotAllowed, ErrForbidden, ""},
+	{ErrInvalidResourceSignature, ErrForbidden, ""},
+	{ErrResourceURLExpired, ErrForbidden, ""},
+	{ErrInvalidShareLink, ErrForbidden, ""},
+	{ErrShareLinkExpired, ErrForbidden, ""},
+	{ErrEmailNotVerified, ErrForbidden, ""},
+	{user.ErrAPIKeyScope, ErrForbidden, ""},
+	{user.ErrAccountDisabled, ErrForbidden, ""},
+
+	{scene.ErrInvalidOutputType, ErrValidation, ""},
+	{scene.ErrInvalidTrainingConfig, ErrValidation, ""},
+	{scene.ErrInvalidState, ErrValidation, ""},
+	{scene.ErrInvalidCollaboratorRole, ErrValidation, ""},
+	{ErrCannotShareWithOwner, ErrValidation, ""},
+	{ErrCannotDisableSelf, ErrValidation, ""},
+	{ErrInvalidPriority, ErrValidation, ""},
+	{ErrBadVideoContent, ErrValidation, ""},
+	{ErrChecksumMismatch, ErrValidation, ""},
+	{ErrUnknownTrainingPreset, ErrValidation, ""},
+	{ErrUnsupportedExportFormat, ErrValidation, ""},
+	{ErrUnsupportedArchiveFormat, ErrValidation, ""},
+	{ErrVideoProbeFailed, ErrValidation, ""},
+	{ErrUnsupportedVideoCodec, ErrValidation, ""},
+	{ErrVideoTooLong, ErrValidation, ""},
+	{ErrVideoResolutionTooHigh, ErrValidation, ""},
+	{ErrVideoTooManyFrames, ErrValidation, ""},
+	{ErrVideoTooFewFrames, ErrValidation, ""},
+	{ErrTooFewSampledFrames, ErrValidation, ""},
+	{ErrUnsupportedFormat, ErrValidation, ""},
+	{ErrContentRejected, ErrValidation, ""},
+	{ErrMalformedPoses, ErrValidation, ""},
+	{ErrMissingPoseField, ErrValidation, ""},
+	{ErrInvalidPoseField, ErrValidation, ""},
+	{ErrBundleImageMismatch, ErrValidation, ""},
+	{ErrBadBundleImage, ErrValidation, ""},
+	{ErrBadImageArchive, ErrValidation, ""},
+	{ErrImageSetTooLarge, ErrValidation, ""},
+	{ErrImageSizeMismatch, ErrValidation, ""},
+	{ErrUploadTooLarge, ErrValidation, ""},
+	{ErrUploadExtensionNotAllowed, ErrValidation, ""},
+	{ErrUploadExceedsSize, ErrValidation, ""},
+	{ErrOutputNotExpected, ErrValidation, ""},
+	{ErrOutputTooLarge, ErrValidation, ""},
+	{user.ErrInvalidUsername, ErrValidation, ""},
+	{user.ErrWeakPassword, ErrValidation, ""},
+	{ErrPasswordNotSet, ErrValidation, ""},
+	{user.ErrInvalidVerificationToken, ErrValidation, ""},
+	{user.ErrVerificationTokenExpired, ErrValidation, ""},
+	{ErrInvalidWebhookURL, ErrValidation, ""},
+	{ErrInvalidWebhookEvent, ErrValidation, ""},
+	{org.ErrInvalidMemberRole, ErrValidation, ""},
+	{worker.ErrInvalidWorkerKind, ErrValidation, ""},
+	{ErrInvalidIdempotencyKey, ErrValidation, ""},
+	{ErrIdempotencyKeyReused, ErrValidation, ""},
+	{ErrIterationNotSaved, ErrValidation, ""},
+	{ErrSameIteration, ErrValidation, ""},
+
+	{scene.ErrInvalidStatusTransition, ErrConflict, ""},
+	{scene.ErrNoRunToVersion, ErrConflict, ""},
+	{scene.ErrInvalidOpOnProcessingScene, ErrConflict, ""},
+	{scene.ErrSceneNotInTrash, ErrConflict, ""},
+	{ErrComparisonFrameUnavailable, ErrConflict, ""},
+	{ErrNothingToExport, ErrConflict, ""},
+	{ErrNothingToRender, ErrConflict, ""},
+	{ErrRenderNotReady, ErrConflict, ""},
+	{scene.ErrInvalidCameraPath, ErrValidation, ""},
+	{scene.ErrRenderNotFound, ErrNotFound, ""},
+	{user.ErrUsernameTaken, ErrConflict, ""},
+	{user.ErrSceneIDAlreadyExists, ErrConflict, ""},
+	{scene.ErrSceneAlreadyExists, ErrConflict, ""},
+	{ErrDeletionInProgress, ErrConflict, ""},
+	{ErrUploadOffsetMismatch, ErrConflict, ""},
+	{ErrUploadIncomplete, ErrConflict, ""},
+	{ErrUploadInUse, ErrConflict, ""},
+	{ErrSceneNotTraining, ErrConflict, ""},
+	{ErrSfmFailed, ErrConflict, ""},
+	{ErrResourceNotFinal, ErrConflict, ""},
+	{org.ErrAlreadyMember, ErrConflict, ""},
+	{org.ErrLastAdmin, ErrConflict, ""},
+
+	{ErrTooManyAPIKeys, ErrQuotaExceeded, ""},
+	{ErrTooManyWebhooks, ErrQuotaExceeded, ""},
+	{ErrTooManyRenders, ErrQuotaExceeded, ""},
+	{ErrConcurrentJobLimit, ErrQuotaExceeded, ""},
+	{ErrStorageQuotaExceeded, ErrQuotaExceeded, ""},
+
+	{ErrUploadSizeLimit, ErrTooLarge, ""},
+	{ErrUploadDurationLimit, ErrTooLarge, ""},
+
+	{ErrTranscodeTimeout, ErrUpstream, ""},
+	{ErrScannerUnavailable, ErrUpstream, ""},
+	{ErrAtCapacity, ErrUpstream, ""},
+
+	// Internal, but the message is safe and tells the user what happened
+	{scene.ErrCorruptCheckpoint, ErrInternal, ""},
+}
+
+// AsError returns err as an *Error, classifying it by errorKinds if it is not one already.
+// Unclassified errors become ErrInternal with a generic message. Returns nil if err is nil.
+func AsError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	for _, k := range errorKinds {
+		if errors.Is(err, k.target) {
+			message := k.message
+			if message == "" {
+				message = err.Error()
+			}
+			return newError(k.kind, message, err)
+		}
+	}
+	return newError(ErrInternal, internalErrorMessage, err)
+}
+
+// classifyError replaces *err with its classified *Error. Public ClientService methods defer it on their named error
+// result, so every error they return is an *Error.
+func classifyError(err *error) {
+	if *err != nil {
+		*err = AsError(*err)
+	}
+}
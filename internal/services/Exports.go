@@ -0,0 +1,285 @@
+// This file contains exports of trained scenes to interchange formats, see scene.ExportSources.
+//
+// An export is requested with RequestExport, which publishes a job to the conversion worker on the 'export-in' queue
+// and records the export as pending. The worker downloads the latest iteration of the source output, converts it, and
+// reports the converted file on the 'export-out' queue, after which it is saved like nerf worker output and served
+// through the resource endpoints under the export format's output type. Requesting an export that is pending or done
+// for the latest iteration returns it rather than converting again, while a failed export can be requested again.
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrUnsupportedExportFormat is returned when exporting a scene to a format that is not an export format.
+	ErrUnsupportedExportFormat = errors.New("unsupported export format")
+	// ErrNothingToExport is returned when a scene has no output the requested export format can be converted from.
+	ErrNothingToExport = errors.New("scene has no output that can be exported to this format")
+)
+
+// RequestExport exports the latest iteration of a trained scene to format, converting the first output type of
+// scene.ExportSources[format] the scene has. The export is done by the conversion worker, and its file is served as
+// the format's output type once done.
+//
+// Returns the export, which is pending until the worker reports the converted file. Returns ErrValidation if format
+// is not an export format, scene.ErrInvalidOpOnProcessingScene if the scene is still processing, or ErrNothingToExport
+// if it has no output format can be converted from.
+func (s *ClientService) RequestExport(ctx context.Context, userID, sceneID primitive.ObjectID, format string) (_ *scene.Export, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RequestExport", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex(), log.FieldResourceType, format)
+
+	if !scene.IsExportOutputType(format) {
+		return nil, NewValidationError(
+			ErrUnsupportedExportFormat.Error(),
+			map[string]string{"format": "must be one of " + strings.Join(scene.ExportFormats(), ", ")},
+			ErrUnsupportedExportFormat,
+		)
+	}
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionExport); err != nil {
+		return nil, err
+	}
+
+	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	if currentScene.Status != nil && !currentScene.Status.State.IsTerminal() {
+		return nil, scene.ErrInvalidOpOnProcessingScene
+	}
+	if currentScene.Nerf == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNothingToExport, format)
+	}
+
+	// Convert the first source output type with a completed iteration
+	sourceType, iteration := "", 0
+	for _, outputType := range scene.ExportSources[format] {
+		completed, err := s.sceneManager.GetCompletedIterations(ctx, sceneID, outputType)
+		if err != nil {
+			return nil, err
+		}
+		if len(completed) > 0 {
+			sourceType, iteration = outputType, completed[len(completed)-1]
+			break
+		}
+	}
+	if sourceType == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNothingToExport, format)
+	}
+
+	if existing := currentScene.Nerf.Exports[format]; existing != nil && existing.State != scene.ExportStateFailed &&
+		existing.SourceType == sourceType && existing.Iteration == iteration {
+		return existing, nil
+	}
+
+	sourcePath, err := currentScene.Nerf.GetFilePathForTypeAndIter(sourceType, iteration)
+	if err != nil {
+		return nil, err
+	}
+	// The worker downloads the source from this server, so it must be on disk
+	if err := s.restoreOutputs(ctx, sceneID, currentScene.Nerf, sourceType); err != nil {
+		return nil, err
+	}
+
+	export := &scene.Export{
+		State:       scene.ExportStatePending,
+		SourceType:  sourceType,
+		Iteration:   iteration,
+		RequestedAt: time.Now(),
+	}
+	if err := s.sceneManager.SetExport(ctx, sceneID, format, export); err != nil {
+		return nil, err
+	}
+	if err := s.mqService.PublishExportJob(ctx, sceneID, format, export, sourcePath); err != nil {
+		export.State = scene.ExportStateFailed
+		export.Error = err.Error()
+		export.CompletedAt = time.Now()
+		if err := s.sceneManager.SetExport(ctx, sceneID, format, export); err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to mark export of scene %s as failed: %v", sceneID.Hex(), err)
+		}
+		return nil, err
+	}
+
+	s.logger.Ctx(ctx).Infof("Exporting %s iteration %d of scene %s as %s", sourceType, iteration, sceneID.Hex(), format)
+	return export, nil
+}
+
+// PublishExportJob publishes the job converting the source output of an export of a scene to format, to the
+// 'export-in' queue. Unlike training jobs, export jobs are published directly rather than through the outbox, as
+// a failed export is requested again by the user.
+//
+// Returns an error if the job could not be published.
+func (s *AMPQService) PublishExportJob(ctx context.Context, sceneID primitive.ObjectID, format string, export *scene.Export, sourcePath string) (err error) {
+	ctx, span := tracing.Start(ctx, "publish export-in", tracing.KindProducer)
+	defer span.EndWithError(&err)
+	span.SetAttribute("messaging.system", s.mq.System())
+	span.SetAttribute("messaging.destination", broker.QueueExportIn)
+	span.SetAttribute("scene.id", sceneID.Hex())
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex(), log.FieldJobID, s.sceneManager.JobID(sceneID))
+
+	jobMap := map[string]interface{}{
+		"id":          s.sceneManager.JobID(sceneID),
+		"format":      format,
+		"source_type": export.SourceType,
+		"iteration":   export.Iteration,
+		"file_path":   s.toAPIUrl(sourcePath),
+	}
+	headers := traceJob(span, jobMap)
+
+	jobJson, err := json.Marshal(jobMap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal export job: %v", err)
+	}
+	if err := s.mq.PublishExportJob(ctx, broker.Job{Body: jobJson, Headers: headers}); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to publish export job: %v", err)
+		return fmt.Errorf("failed to publish export job: %v", err)
+	}
+	return nil
+}
+
+// processExportJob processes a message from the 'export-out' queue, saving the converted file of an export, or
+// recording why the conversion failed.
+//
+// Output for an export that is no longer pending, i.e because the scene was trained again since, is dropped.
+// The expected message format is:
+//
+//	{
+//	    "id": string (SceneManager.JobID),
+//	    "format": string,
+//	    "iteration": int,
+//	    "file_path": string (url, empty if the conversion failed),
+//	    "error": string (optional, why the conversion failed)
+//	}
+func (s *AMPQService) processExportJob(d *broker.Delivery) (err error) {
+	var data struct {
+		SceneID     string `json:"id"`
+		Format      string `json:"format"`
+		Iteration   int    `json:"iteration"`
+		FilePath    string `json:"file_path"`
+		Error       string `json:"error"`
+		Traceparent string `json:"traceparent"`
+	}
+	if err := json.Unmarshal(d.Body, &data); err != nil {
+		return fmt.Errorf("failed to unmarshal export worker data: %w", err)
+	}
+	sceneID, err := s.sceneManager.ParseJobID(data.SceneID)
+	if err != nil {
+		return fmt.Errorf("invalid ID format: %v", err)
+	}
+
+	ctx, span := tracing.Start(consumeContext(d, data.Traceparent), "process export-out", tracing.KindConsumer)
+	defer span.EndWithError(&err)
+	span.SetAttribute("messaging.system", s.mq.System())
+	span.SetAttribute("messaging.source", broker.QueueExportOut)
+	span.SetAttribute("scene.id", sceneID.Hex())
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex(), log.FieldJobID, data.SceneID, log.FieldQueue, broker.QueueExportOut,
+		log.FieldResourceType, data.Format)
+
+	if !scene.IsExportOutputType(data.Format) {
+		return fmt.Errorf("unknown export format: %s", data.Format)
+	}
+	if s.isAbandoned(ctx, sceneID) {
+		s.logger.Ctx(ctx).Infof("Dropping export output for deleted or cancelled scene %s", sceneID.Hex())
+		return nil
+	}
+
+	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return fmt.Errorf("failed to get scene: %v", err)
+	}
+	nerf := currentScene.Nerf
+	if nerf == nil {
+		s.logger.Ctx(ctx).Infof("Dropping export output for scene %s without nerf output", sceneID.Hex())
+		return nil
+	}
+	export := nerf.Exports[data.Format]
+	if export == nil || export.State != scene.ExportStatePending || export.Iteration != data.Iteration {
+		s.logger.Ctx(ctx).Infof("Dropping stale %s export output for scene %s", data.Format, sceneID.Hex())
+		return nil
+	}
+
+	export.CompletedAt = time.Now()
+	if data.Error != "" || data.FilePath == "" {
+		export.State = scene.ExportStateFailed
+		export.Error = data.Error
+		if export.Error == "" {
+			export.Error = "conversion worker returned no file"
+		}
+		s.logger.Ctx(ctx).Warnf("Export of scene %s as %s failed: %s", sceneID.Hex(), data.Format, export.Error)
+		return s.sceneManager.SetExport(ctx, sceneID, data.Format, export)
+	}
+
+	filePath, _, written, err := s.downloadOutput(sceneID, currentScene.CurrentVersion(), data.Format, data.Iteration, data.FilePath)
+	if err != nil {
+		return err
+	}
+	s.metrics.OutputBytesTotal.WithLabelValues(data.Format).Add(float64(written))
+	if err := s.sceneManager.SetOutputFile(ctx, sceneID, data.Format, data.Iteration, filePath, ""); err != nil {
+		return fmt.Errorf("failed to set export file: %v", err)
+	}
+	s.sceneCache.InvalidateOutputs(ctx, sceneID)
+	export.State = scene.ExportStateDone
+	if err := s.sceneManager.SetExport(ctx, sceneID, data.Format, export); err != nil {
+		return fmt.Errorf("failed to set export: %v", err)
+	}
+	s.addStorageUsage(ctx, sceneID, written)
+	if s.store != nil {
+		if err := storeFile(ctx, s.store, s.sceneManager, sceneID, filePath); err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to store export %s of scene %s: %v", filePath, sceneID.Hex(), err)
+		}
+	}
+
+	s.logger.Ctx(ctx).Infof("Exported scene %s as %s", sceneID.Hex(), data.Format)
+	return nil
+}
+
+// failDeadLetteredExport fails the export a dead-lettered 'export-out' message was for, if it is still pending.
+func (s *AMPQService) failDeadLetteredExport(ctx context.Context, sceneID primitive.ObjectID, body []byte, reason string) error {
+	var data struct {
+		Format    string `json:"format"`
+		Iteration int    `json:"iteration"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil || !scene.IsExportOutputType(data.Format) {
+		s.logger.Ctx(ctx).Warnf("Dropping dead-lettered export message of scene %s without a valid format", sceneID.Hex())
+		return nil
+	}
+	nerf, err := s.sceneManager.GetNerf(ctx, sceneID)
+	if errors.Is(err, scene.ErrNerfNotFound) || errors.Is(err, scene.ErrSceneNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get nerf: %v", err)
+	}
+	export := nerf.Exports[data.Format]
+	if export == nil || export.State != scene.ExportStatePending || export.Iteration != data.Iteration {
+		return nil
+	}
+	export.State = scene.ExportStateFailed
+	export.Error = reason
+	export.CompletedAt = time.Now()
+	return s.sceneManager.SetExport(ctx, sceneID, data.Format, export)
+}
+
+// sceneOutputTypes returns the output types a scene has files of: those of its training config, followed by the
+// export formats it was exported to.
+func sceneOutputTypes(config *scene.TrainingConfig, nerf *scene.Nerf) []string {
+	outputTypes := slices.Clone(config.NerfTrainingConfig.OutputTypes)
+	return append(outputTypes, nerf.ExportTypes()...)
+}
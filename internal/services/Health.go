@@ -0,0 +1,195 @@
+// This file contains the health checks of the dependencies of the server, for liveness and readiness probes.
+//
+// Each check exercises its dependency rather than trusting a cached state: MongoDB is pinged on the primary, the
+// message broker is pinged (see broker.MessageQueue.Ping), and a probe file is written to and removed from the data
+// directory, and from the object store if one is configured. Checks run concurrently, each bounded by
+// healthCheckTimeout, so a hung dependency cannot hang the probe.
+//
+// Liveness only fails on the checks a restart can fix, i.e a stale mount of the data directory. MongoDB, the broker,
+// and the object store are shared by every server, so restarting every server while they are unreachable would only
+// add to the outage. Readiness fails on any check, so traffic is routed away from a server that cannot serve requests.
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/storage"
+)
+
+// healthCheckTimeout bounds each health check.
+const healthCheckTimeout = 3 * time.Second
+
+// Names of the health checks
+const (
+	HealthCheckMongo         = "mongo"
+	HealthCheckBroker        = "broker"
+	HealthCheckDisk          = "disk"
+	HealthCheckObjectStorage = "object_storage"
+)
+
+// Statuses of health checks and reports
+const (
+	HealthStatusOK      = "ok"
+	HealthStatusFailing = "failing"
+)
+
+// HealthCheck is the result of checking one dependency. Error is a coarse reason, as probes are not authenticated,
+// and the error itself is logged.
+type HealthCheck struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// HealthReport is the result of a probe. Status is HealthStatusFailing if a check the probe depends on failed.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// OK reports whether the probe passed.
+func (r *HealthReport) OK() bool {
+	return r.Status == HealthStatusOK
+}
+
+// HealthService checks the dependencies of the server, see the file comment.
+type HealthService struct {
+	client *mongo.Client
+	mq     broker.MessageQueue
+	store  storage.Store
+	logger *log.Logger
+}
+
+// NewHealthService creates a new HealthService checking the MongoDB server of client, and the broker mq. store is
+// the object store scene outputs are mirrored to, and is not checked if nil.
+func NewHealthService(client *mongo.Client, mq broker.MessageQueue, store storage.Store, logger *log.Logger) *HealthService {
+	return &HealthService{
+		client: client,
+		mq:     mq,
+		store:  store,
+		logger: logger,
+	}
+}
+
+// Liveness checks every dependency, but only fails if the data directory is not writable, see the file comment.
+func (s *HealthService) Liveness(ctx context.Context) *HealthReport {
+	return s.report(ctx, HealthCheckDisk)
+}
+
+// Readiness checks every dependency, and fails if any check failed.
+func (s *HealthService) Readiness(ctx context.Context) *HealthReport {
+	checks := []string{HealthCheckMongo, HealthCheckBroker, HealthCheckDisk}
+	if s.store != nil {
+		checks = append(checks, HealthCheckObjectStorage)
+	}
+	return s.report(ctx, checks...)
+}
+
+// report runs every check concurrently, and fails if one of the required checks failed.
+func (s *HealthService) report(ctx context.Context, required ...string) *HealthReport {
+	checks := map[string]func(context.Context) error{
+		HealthCheckMongo: func(ctx context.Context) error {
+			return s.client.Ping(ctx, readpref.Primary())
+		},
+		HealthCheckBroker: s.mq.Ping,
+		HealthCheckDisk:   checkDataDir,
+	}
+	if s.store != nil {
+		checks[HealthCheckObjectStorage] = s.checkObjectStorage
+	}
+
+	report := &HealthReport{Status: HealthStatusOK, Checks: make(map[string]HealthCheck, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := s.runCheck(ctx, name, check)
+			mu.Lock()
+			report.Checks[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for _, name := range required {
+		if report.Checks[name].Status != HealthStatusOK {
+			report.Status = HealthStatusFailing
+		}
+	}
+	return report
+}
+
+// runCheck runs a check within healthCheckTimeout. A check that does not return in time, i.e a write to a hung mount,
+// is reported as timed out and left to finish in the background.
+func (s *HealthService) runCheck(ctx context.Context, name string, check func(context.Context) error) HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := HealthCheck{Status: HealthStatusOK, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		s.logger.Warnf("Health check %s failed: %v", name, err)
+		result.Status = HealthStatusFailing
+		result.Error = "unavailable"
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Error = "timed out"
+		}
+	}
+	return result
+}
+
+// checkDataDir writes a probe file to the data directory, and removes it.
+func checkDataDir(ctx context.Context) error {
+	name := filepath.Join(scene.DataDir(), ".health-"+healthProbeID())
+	if err := os.WriteFile(name, []byte("ok"), 0o644); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// checkObjectStorage writes a probe object to the object store, and removes it.
+func (s *HealthService) checkObjectStorage(ctx context.Context) error {
+	key := path.Join("health", healthProbeID())
+	if err := s.store.Put(ctx, key, bytes.NewReader([]byte("ok")), 2, "text/plain"); err != nil {
+		return err
+	}
+	return s.store.Delete(ctx, key)
+}
+
+// healthProbeID returns a random name for a probe file, so concurrent probes and servers sharing a directory do not
+// remove each other's files.
+func healthProbeID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,116 @@
+// This file contains Idempotency, which answers retries of mutating requests with the response of the first attempt.
+//
+// A client sends an idempotency key of its choosing with a request, and the same key when retrying it, i.e after a
+// timeout. The first request reserves the key before it is performed, and stores its response once it completes.
+// Retries while it is performed are refused with ErrIdempotencyKeyInUse, and retries after it completed get the stored
+// response, so a retried upload does not create a second scene and a second sfm job.
+//
+// Keys are scoped to the user, and bound to the request they were first sent with: reusing a key for another request
+// is refused with ErrIdempotencyKeyReused. Keys expire after the configured TTL. Requests that fail with a server
+// error release their key, as they may not have been performed and should be retried for real.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/idempotency"
+)
+
+// DefaultIdempotencyTTL is how long idempotency keys are kept unless configured otherwise.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// MaxIdempotencyKeyLength is the longest idempotency key accepted.
+const MaxIdempotencyKeyLength = 255
+
+// idempotencyPendingTimeout is how long a key may stay reserved without a response before it is considered abandoned,
+// i.e as the server performing its request stopped, and may be reserved again.
+const idempotencyPendingTimeout = 10 * time.Minute
+
+// idempotencyRetryAfter is how long clients are told to wait before retrying a request whose key is in use.
+const idempotencyRetryAfter = time.Second
+
+var (
+	// ErrInvalidIdempotencyKey is returned when an idempotency key is empty or too long.
+	ErrInvalidIdempotencyKey = errors.New("idempotency key must be between 1 and 255 characters")
+	// ErrIdempotencyKeyReused is returned when an idempotency key is sent with a different request than it was first
+	// sent with.
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+	// ErrIdempotencyKeyInUse is returned when an idempotency key is sent while the request it was first sent with is
+	// still being performed.
+	ErrIdempotencyKeyInUse = errors.New("a request with this idempotency key is still in progress")
+)
+
+// Idempotency stores the responses of requests by idempotency key, see the file comment.
+type Idempotency struct {
+	keys   *idempotency.IdempotencyManager
+	ttl    time.Duration
+	logger *log.Logger
+}
+
+// NewIdempotency creates an Idempotency keeping keys in keys for ttl, DefaultIdempotencyTTL if ttl <= 0.
+func NewIdempotency(keys *idempotency.IdempotencyManager, ttl time.Duration, logger *log.Logger) *Idempotency {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return &Idempotency{keys: keys, ttl: ttl, logger: logger}
+}
+
+// IdempotentRequest is a request whose idempotency key was reserved by Idempotency.Begin. Exactly one of Complete and
+// Release must be called once it was performed.
+type IdempotentRequest struct {
+	idempotency *Idempotency
+	id          primitive.ObjectID
+}
+
+// Begin reserves key for a request of a user, identified by fingerprint (i.e, a hash of its method, path and body).
+//
+// If the request was already performed with key, its stored response is returned and the request must not be
+// performed again. Otherwise the returned IdempotentRequest must be completed once the request was performed.
+//
+// Returns ErrInvalidIdempotencyKey if key is malformed, ErrIdempotencyKeyReused if key was sent with another
+// fingerprint, or ErrIdempotencyKeyInUse if the request with key is still being performed.
+func (i *Idempotency) Begin(ctx context.Context, userID primitive.ObjectID, key, fingerprint string) (request *IdempotentRequest, replay *idempotency.Response, err error) {
+	defer classifyError(&err)
+
+	if key == "" || len(key) > MaxIdempotencyKeyLength {
+		return nil, nil, ErrInvalidIdempotencyKey
+	}
+
+	record, err := i.keys.Reserve(ctx, userID, key, fingerprint, i.ttl, idempotencyPendingTimeout)
+	if errors.Is(err, idempotency.ErrKeyExists) {
+		if record.Fingerprint != fingerprint {
+			return nil, nil, ErrIdempotencyKeyReused
+		}
+		if record.Response == nil {
+			return nil, nil, &Error{Kind: ErrConflict, Message: ErrIdempotencyKeyInUse.Error(), RetryAfter: idempotencyRetryAfter, Err: ErrIdempotencyKeyInUse}
+		}
+		i.logger.Ctx(ctx).Debugf("Replaying response of idempotency key %q", key)
+		return nil, record.Response, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &IdempotentRequest{idempotency: i, id: record.ID}, nil, nil
+}
+
+// Complete stores the response of the request, returned to later requests with the same key. A failure to store it
+// is only logged, as the request itself succeeded, and releases the key instead.
+func (r *IdempotentRequest) Complete(ctx context.Context, response idempotency.Response) {
+	if err := r.idempotency.keys.Complete(ctx, r.id, response); err != nil {
+		r.idempotency.logger.Ctx(ctx).Warnf("Failed to store idempotent response: %v", err)
+		r.Release(ctx)
+	}
+}
+
+// Release frees the key of the request, so a retry performs it again, i.e as it failed with a server error.
+func (r *IdempotentRequest) Release(ctx context.Context) {
+	if err := r.idempotency.keys.Release(ctx, r.id); err != nil {
+		r.idempotency.logger.Ctx(ctx).Warnf("Failed to release idempotency key: %v", err)
+	}
+}
@@ -0,0 +1,372 @@
+// This file contains uploads of image sets, for users who captured a scene as photos rather than a video.
+//
+// An image set is a zip archive of PNG or JPEG images, i.e a photo set or the images folder of a COLMAP project. The
+// archive is validated in full before anything is extracted: every image must be readable and of the same size, and
+// the set must be within the limits of the training mode as if its images were the frames of a video. Files that are
+// not images (i.e COLMAP's sparse reconstruction, or __MACOSX metadata) are ignored, and images are flattened into a
+// single directory, so their file names must be unique within the archive.
+//
+// The images are extracted into the scene's layout, and the scene's sfm job passes their URLs to the sfm-worker instead
+// of a video URL, so frame extraction is skipped and frame sampling does not apply. Otherwise image set scenes are
+// processed like video scenes.
+
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrBadImageArchive is returned when an uploaded image set is not a readable zip archive, or has no images.
+	ErrBadImageArchive = errors.New("image set is not a valid zip archive of images")
+	// ErrImageSetTooLarge is returned when the images of an uploaded image set would extract to more than MaxImageSetSize.
+	ErrImageSetTooLarge = errors.New("image set is too large")
+	// ErrImageSizeMismatch is returned when the images of an image set are not all the same size.
+	ErrImageSizeMismatch = errors.New("images are not all the same size")
+)
+
+// MaxImageSetSize is the most bytes the images of an image set may extract to.
+const MaxImageSetSize int64 = 4 * 1024 * 1024 * 1024
+
+// imageSetEntries returns the image files of an image set archive, sorted by name. Directories, hidden files, and
+// files that are not images are skipped.
+//
+// Returns ErrBadImageArchive if the archive has no images or two images share a file name, or ErrImageSetTooLarge if
+// the images would extract to more than MaxImageSetSize.
+func imageSetEntries(archive *zip.Reader) ([]*zip.File, error) {
+	entries := make([]*zip.File, 0, len(archive.File))
+	names := make(map[string]bool, len(archive.File))
+	var total uint64
+	for _, f := range archive.File {
+		name := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		if !slices.Contains(bundleImageExts, strings.ToLower(path.Ext(name))) {
+			continue
+		}
+		if names[name] {
+			return nil, fmt.Errorf("%w: image %s appears more than once", ErrBadImageArchive, name)
+		}
+		names[name] = true
+
+		total += f.UncompressedSize64
+		if total > uint64(MaxImageSetSize) {
+			return nil, fmt.Errorf("%w: limit is %d bytes", ErrImageSetTooLarge, MaxImageSetSize)
+		}
+		entries = append(entries, f)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: no .png, .jpg, or .jpeg images found", ErrBadImageArchive)
+	}
+
+	slices.SortFunc(entries, func(a, b *zip.File) int {
+		return strings.Compare(path.Base(a.Name), path.Base(b.Name))
+	})
+	return entries, nil
+}
+
+// imageSetSize checks that every image of an image set is a readable PNG or JPEG image of the same size, reading only
+// their headers, and returns that size.
+//
+// Returns ErrBadBundleImage if an image cannot be read, or ErrImageSizeMismatch if the sizes differ.
+func imageSetSize(entries []*zip.File) (width, height int, err error) {
+	for i, f := range entries {
+		src, err := f.Open()
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: %v", ErrBadImageArchive, err)
+		}
+		config, _, err := image.DecodeConfig(src)
+		src.Close()
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: %s", ErrBadBundleImage, f.Name)
+		}
+
+		if i == 0 {
+			width, height = config.Width, config.Height
+		} else if config.Width != width || config.Height != height {
+			return 0, 0, fmt.Errorf("%w: %s is %dx%d, %s is %dx%d",
+				ErrImageSizeMismatch, f.Name, config.Width, config.Height, entries[0].Name, width, height)
+		}
+	}
+	return width, height, nil
+}
+
+// extractImage extracts an image of an image set to path, and returns the number of bytes written. Images are never
+// extracted past the size the archive declares for them.
+func extractImage(f *zip.File, path string) (int64, error) {
+	src, err := f.Open()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrBadImageArchive, err)
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(dst, io.LimitReader(src, int64(f.UncompressedSize64)))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrBadImageArchive, err)
+	}
+	return written, nil
+}
+
+// HandleIncomingImageSet processes a zip archive of images uploaded by the user, and starts the processing pipeline
+// with an sfm job that skips frame extraction.
+//
+// If a training config value is not provided, a default value is used, as with HandleIncomingVideo.
+//
+// Returns the scene ID if successful, error otherwise. Returns ErrBadImageArchive if the file is not a zip archive
+// of images, ErrImageSetTooLarge if it extracts to too many bytes, ErrBadBundleImage if an image cannot be read, and
+// ErrImageSizeMismatch if the images differ in size. Returns ErrVideoResolutionTooHigh, ErrVideoTooManyFrames, or
+// ErrVideoTooFewFrames if the images are outside the limits of the training mode. Returns the priority errors of
+// resolvePriority, ErrContentRejected or ErrScannerUnavailable if an image is rejected by the content scanner, and
+// ErrAtCapacity if uploads are rejected while the most jobs allowed are in flight, or the errors of checkUploadQuota
+// if the user is out of quota. Returns ErrUploadSizeLimit if the archive is over the upload limit of the user's tier,
+// which does not limit the duration of image sets. Nothing is stored for a rejected image set. The scene is uploaded into the workspace of
+// orgID if it is not nil, as with HandleIncomingVideo.
+func (s *ClientService) HandleIncomingImageSet(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	orgID primitive.ObjectID,
+	file *multipart.FileHeader,
+	trainingMode string,
+	outputTypes []string,
+	saveIterations []int,
+	totalIterations int,
+	sceneName string,
+	priority string,
+) (_ string, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.HandleIncomingImageSet", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	start := time.Now()
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		s.metrics.UploadDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	}()
+
+	if file == nil {
+		return "", NewValidationError("file not received", map[string]string{"file": "required"}, nil)
+	}
+
+	applyUploadDefaults(&sceneName, &trainingMode, &outputTypes, &saveIterations, &priority)
+	nerfConfig := &scene.NerfTrainingConfig{
+		TrainingMode:    trainingMode,
+		OutputTypes:     outputTypes,
+		SaveIterations:  saveIterations,
+		TotalIterations: totalIterations,
+	}
+	if err := s.trainingLimits.Check(nerfConfig); err != nil {
+		return "", err
+	}
+
+	// Validate the whole archive before anything is extracted
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	archive, err := zip.NewReader(src, file.Size)
+	if err != nil {
+		s.logger.Infof("Rejected image set upload %s: %v", file.Filename, err)
+		return "", fmt.Errorf("%w: %v", ErrBadImageArchive, err)
+	}
+	var width, height int
+	entries, err := imageSetEntries(archive)
+	if err == nil {
+		width, height, err = imageSetSize(entries)
+		if err == nil {
+			probe := &VideoProbe{Width: width, Height: height, FrameCount: len(entries)}
+			err = s.videoLimits[trainingMode].Check(probe)
+		}
+	}
+	if err != nil {
+		s.logger.Infof("Rejected image set upload %s: %v", file.Filename, err)
+		return "", err
+	}
+
+	if err := s.checkOrgUpload(ctx, userID, orgID); err != nil {
+		return "", err
+	}
+	if err := s.checkUploadQuota(ctx, userID, file.Size); err != nil {
+		return "", err
+	}
+	if err := s.CheckUploadSize(ctx, userID, file.Size); err != nil {
+		return "", err
+	}
+	if err := s.mqService.CheckAdmission(ctx); err != nil {
+		return "", err
+	}
+	priority, tier, err := s.resolvePriority(ctx, userID, priority)
+	if err != nil {
+		return "", err
+	}
+
+	sceneID := primitive.NewObjectID()
+
+	// Extract the images into the scene's layout. A rejected image set removes the whole scene directory, as nothing
+	// else is in it yet.
+	sceneDir := s.sceneManager.SceneDir(sceneID)
+	images, size, contentScan, err := s.storeImageSet(ctx, sceneID, entries)
+	if err != nil {
+		os.RemoveAll(sceneDir)
+		if errors.Is(err, ErrContentRejected) || errors.Is(err, ErrScannerUnavailable) {
+			s.logger.Infof("Rejected image set upload %s: %v", file.Filename, err)
+		}
+		return "", err
+	}
+
+	// Scenes wait in the grace period before their job is published, if one is configured
+	initialState := scene.StateQueued
+	if s.mqService.SFMGracePeriod() > 0 {
+		initialState = scene.StateGracePeriod
+	}
+
+	now := time.Now()
+	newScene := &scene.Scene{
+		ID:        sceneID,
+		InputType: scene.InputTypeImages,
+		Images:    images,
+		// There is no video, only the properties of the images as its frames
+		Video: &scene.Video{
+			Width:      width,
+			Height:     height,
+			FrameCount: len(images.FilePaths),
+			FileSize:   size,
+		},
+		Config: &scene.TrainingConfig{
+			NerfTrainingConfig: nerfConfig,
+			Priority:           priority,
+			Tier:               tier,
+		},
+		Status: &scene.SceneStatus{
+			State:     initialState,
+			UpdatedAt: now,
+			EnteredAt: map[scene.State]time.Time{initialState: now},
+		},
+		Name:        sceneName,
+		OrgID:       orgID,
+		Traceparent: tracing.Traceparent(ctx),
+		ContentScan: contentScan,
+	}
+
+	err = s.sceneManager.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.sceneManager.CreateScene(ctx, newScene); err != nil {
+			return err
+		}
+		owner, err := s.userManager.GetUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := owner.AddScene(sceneID); err != nil {
+			return err
+		}
+		if err := s.userManager.UpdateUser(ctx, owner); err != nil {
+			return err
+		}
+		return s.userManager.IncrementStorageUsed(ctx, userID, size)
+	})
+	if err != nil {
+		s.logger.Errorf("Failed to insert new scene into database: %v", err)
+		os.RemoveAll(sceneDir)
+		return "", err
+	}
+
+	// Start pipeline, only once the scene is committed, as HandleIncomingVideo does
+	if err := s.mqService.SubmitSFMJob(ctx, newScene); err != nil {
+		s.logger.Errorf("Failed to publish SFM job for scene %s, retrying: %v", sceneID.Hex(), err)
+		s.mqService.DeferSFMJob(ctx, sceneID)
+	}
+
+	s.createThumbnail(ctx, userID, newScene)
+	s.recordUpload(ctx, userID, sceneID, scene.InputTypeImages)
+
+	s.metrics.UploadsTotal.WithLabelValues(trainingMode).Inc()
+	s.metrics.UploadBytesTotal.WithLabelValues(trainingMode).Add(float64(size))
+	s.metrics.UploadSize.WithLabelValues(trainingMode).Observe(float64(size))
+
+	s.logger.Infof("Scene %s uploaded as an image set of %d images", sceneID.Hex(), len(images.FilePaths))
+	return sceneID.Hex(), nil
+}
+
+// storeImageSet extracts the images of a validated image set into the scene's layout and scans the extracted images,
+// and returns the scene's image set, the bytes stored, and the verdict of the scan.
+func (s *ClientService) storeImageSet(ctx context.Context, sceneID primitive.ObjectID, entries []*zip.File) (*scene.ImageSet, int64, *scene.ContentScan, error) {
+	if err := os.MkdirAll(s.sceneManager.RawImagesDir(sceneID), os.ModePerm); err != nil {
+		return nil, 0, nil, err
+	}
+
+	images := &scene.ImageSet{FilePaths: make([]string, len(entries))}
+	var size int64
+	var contentScan *scene.ContentScan
+	for i, f := range entries {
+		imagePath, err := s.sceneManager.RawImagePath(sceneID, path.Base(f.Name))
+		if err != nil {
+			return nil, 0, nil, NewValidationError("invalid image file name", map[string]string{"file": "invalid image file name " + f.Name}, err)
+		}
+		written, err := extractImage(f, imagePath)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		imageScan, err := s.scanUpload(ctx, imagePath)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		contentScan = mergeContentScans(contentScan, imageScan)
+		size += written
+		images.FilePaths[i] = imagePath
+	}
+	return images, size, contentScan, nil
+}
+
+// checkSfmInput checks that the input sfm runs on is still stored for a scene, its video or its image set.
+//
+// Returns scene.ErrVideoNotFound if the scene has no stored input, i.e it was uploaded with pre-computed sfm output, or
+// its video was removed by the retention policy.
+func checkSfmInput(sc *scene.Scene) error {
+	if sc.UploadedInputType() == scene.InputTypeImages {
+		if sc.Images == nil || len(sc.Images.FilePaths) == 0 {
+			return scene.ErrVideoNotFound
+		}
+		for _, imagePath := range sc.Images.FilePaths {
+			if _, err := os.Stat(imagePath); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if sc.Video == nil || sc.Video.FilePath == "" {
+		return scene.ErrVideoNotFound
+	}
+	if sc.Video.DeletedAt != nil {
+		return fmt.Errorf("%w: removed by the retention policy", scene.ErrVideoNotFound)
+	}
+	_, err := os.Stat(sc.Video.FilePath)
+	return err
+}
@@ -0,0 +1,241 @@
+// This file contains the comparison of two save iterations of a scene, so users can judge whether training further
+// was worth it, i.e whether 30k iterations look better than 7k.
+//
+// The outputs of both iterations are listed side by side with their sizes and when they were saved, along with the
+// training time each iteration took to reach. A comparison frame puts the same frame of the rendered video of both
+// iterations next to each other. Frames are extracted from the videos the workers rendered, so they are only
+// available for scenes trained with the video output type, once both iterations were saved.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Comparison frame settings
+const (
+	// comparisonFrameHeight is the height of each side of a comparison frame.
+	comparisonFrameHeight = 480
+	// comparisonFrameQuality is the ffmpeg JPEG quality scale of comparison frames, from 2 (best) to 31 (worst).
+	comparisonFrameQuality = 3
+	// comparisonFrameTimeout bounds the extraction of a comparison frame.
+	comparisonFrameTimeout = 30 * time.Second
+)
+
+var (
+	// ErrIterationNotSaved is returned when comparing an iteration the scene's training config does not save.
+	ErrIterationNotSaved = errors.New("iteration is not a save iteration of the scene")
+	// ErrSameIteration is returned when comparing an iteration with itself.
+	ErrSameIteration = errors.New("cannot compare an iteration with itself")
+	// ErrComparisonFrameUnavailable is returned when a comparison frame is requested for iterations that do not both
+	// have a rendered video.
+	ErrComparisonFrameUnavailable = errors.New("both iterations must have a rendered video to compare frames")
+)
+
+// IterationOutput is an output file of a save iteration. Missing outputs are listed with Exists unset.
+type IterationOutput struct {
+	Exists  bool       `json:"exists"`
+	Size    int64      `json:"size,omitempty"`
+	SavedAt *time.Time `json:"saved_at,omitempty"`
+	SHA256  string     `json:"sha256,omitempty"`
+}
+
+// IterationSummary sums up a save iteration of a scene.
+//
+// SavedAt is when the first of its outputs was saved, and TrainingSeconds how long after training started that was,
+// both unset until an output was saved.
+type IterationSummary struct {
+	Iteration       int                        `json:"iteration"`
+	Outputs         map[string]IterationOutput `json:"outputs"`
+	Size            int64                      `json:"size"`
+	SavedAt         *time.Time                 `json:"saved_at,omitempty"`
+	TrainingSeconds float64                    `json:"training_seconds,omitempty"`
+}
+
+// IterationComparison compares two save iterations of a scene. The deltas are those of To less those of From, and
+// TrainingSecondsDelta is only set once both were saved.
+type IterationComparison struct {
+	SceneID              string           `json:"scene_id"`
+	From                 IterationSummary `json:"from"`
+	To                   IterationSummary `json:"to"`
+	IterationsDelta      int              `json:"iterations_delta"`
+	SizeDelta            int64            `json:"size_delta"`
+	TrainingSecondsDelta float64          `json:"training_seconds_delta,omitempty"`
+	// whether GetComparisonFrame can be called for these iterations
+	FramesAvailable bool `json:"frames_available"`
+}
+
+// CompareIterations compares two save iterations of a scene the user has access to, see the file comment.
+//
+// Returns ErrIterationNotSaved if either iteration is not a save iteration of the scene, ErrSameIteration if both are
+// the same, or error if the user does not have access to the scene or an error occurred.
+func (s *ClientService) CompareIterations(ctx context.Context, userID, sceneID primitive.ObjectID, from, to int) (_ *IterationComparison, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.CompareIterations", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Compare iterations request received")
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadMetadata); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+	if err := s.checkComparedIterations(ctx, sceneID, from, to); err != nil {
+		return nil, err
+	}
+
+	resources, err := s.sceneResources(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	var trainingStartedAt time.Time
+	if status.EnteredAt != nil {
+		trainingStartedAt = status.EnteredAt[scene.StateTraining]
+	}
+
+	comparison := &IterationComparison{
+		SceneID:         sceneID.Hex(),
+		From:            summarizeIteration(resources, from, trainingStartedAt),
+		To:              summarizeIteration(resources, to, trainingStartedAt),
+		IterationsDelta: to - from,
+	}
+	comparison.SizeDelta = comparison.To.Size - comparison.From.Size
+	if comparison.From.SavedAt != nil && comparison.To.SavedAt != nil {
+		comparison.TrainingSecondsDelta = comparison.To.SavedAt.Sub(*comparison.From.SavedAt).Seconds()
+	}
+	comparison.FramesAvailable = comparison.From.Outputs["video"].Exists && comparison.To.Outputs["video"].Exists
+	return comparison, nil
+}
+
+// checkComparedIterations checks that from and to are two different save iterations of a scene.
+func (s *ClientService) checkComparedIterations(ctx context.Context, sceneID primitive.ObjectID, from, to int) error {
+	if from == to {
+		return ErrSameIteration
+	}
+	config, err := s.trainingConfig(ctx, sceneID)
+	if err != nil {
+		return err
+	}
+	if config.NerfTrainingConfig == nil {
+		return ErrIterationNotSaved
+	}
+	for _, iteration := range []int{from, to} {
+		if !slices.Contains(config.NerfTrainingConfig.SaveIterations, iteration) {
+			return newError(ErrValidation, fmt.Sprintf("iteration %d is not a save iteration of the scene", iteration), ErrIterationNotSaved)
+		}
+	}
+	return nil
+}
+
+// summarizeIteration sums up an iteration from the output files of its scene. trainingStartedAt is when the scene
+// last started training, zero if unknown.
+func summarizeIteration(resources map[string]map[int]resourceStat, iteration int, trainingStartedAt time.Time) IterationSummary {
+	summary := IterationSummary{Iteration: iteration, Outputs: make(map[string]IterationOutput, len(resources))}
+	for outputType, iterations := range resources {
+		stat := iterations[iteration]
+		output := IterationOutput{Exists: stat.Exists}
+		if stat.Exists {
+			savedAt := stat.ModTime
+			output.Size, output.SavedAt, output.SHA256 = stat.Size, &savedAt, stat.SHA256
+			summary.Size += stat.Size
+			if summary.SavedAt == nil || savedAt.Before(*summary.SavedAt) {
+				summary.SavedAt = &savedAt
+			}
+		}
+		summary.Outputs[outputType] = output
+	}
+	if summary.SavedAt != nil && !trainingStartedAt.IsZero() && summary.SavedAt.After(trainingStartedAt) {
+		summary.TrainingSeconds = summary.SavedAt.Sub(trainingStartedAt).Seconds()
+	}
+	return summary
+}
+
+// GetComparisonFrame returns a JPEG with the frame at offset into the rendered video of iteration from on the left,
+// and that of iteration to on the right.
+//
+// Returns ErrComparisonFrameUnavailable if either iteration has no rendered video, the errors of CompareIterations for
+// invalid iterations, or error if the user does not have access to the scene or an error occurred.
+func (s *ClientService) GetComparisonFrame(ctx context.Context, userID, sceneID primitive.ObjectID, from, to int, offset time.Duration) (_ []byte, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetComparisonFrame", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get comparison frame request received")
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionDownload); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+	if err := s.checkComparedIterations(ctx, sceneID, from, to); err != nil {
+		return nil, err
+	}
+
+	nerf, err := s.sceneManager.GetNerf(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.restoreOutputs(ctx, sceneID, nerf, "video"); err != nil {
+		return nil, err
+	}
+	resources, err := s.sceneResources(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	if !resources["video"][from].Exists || !resources["video"][to].Exists {
+		return nil, ErrComparisonFrameUnavailable
+	}
+
+	fromPath, err := nerf.GetFilePathForTypeAndIter("video", from)
+	if err != nil {
+		return nil, err
+	}
+	toPath, err := nerf.GetFilePathForTypeAndIter("video", to)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, comparisonFrameTimeout)
+	defer cancel()
+	return extractComparisonFrame(ctx, fromPath, toPath, offset)
+}
+
+// extractComparisonFrame extracts the frame at offset into the videos at left and right with ffmpeg, scales both to
+// comparisonFrameHeight, and returns them side by side as a JPEG.
+func extractComparisonFrame(ctx context.Context, left, right string, offset time.Duration) ([]byte, error) {
+	at := fmt.Sprintf("%.3f", offset.Seconds())
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-loglevel", "error",
+		"-ss", at, "-i", left,
+		"-ss", at, "-i", right,
+		"-filter_complex", fmt.Sprintf("[0:v]scale=-2:%d[l];[1:v]scale=-2:%d[r];[l][r]hstack=inputs=2", comparisonFrameHeight, comparisonFrameHeight),
+		"-frames:v", "1", "-q:v", fmt.Sprint(comparisonFrameQuality),
+		"-f", "image2pipe", "-vcodec", "mjpeg", "-",
+	)
+	frame, err := cmd.Output()
+	if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, exitErr.Stderr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) == 0 {
+		// The offset is past the end of a video
+		return nil, newError(ErrValidation, "offset is past the end of the rendered video", ErrComparisonFrameUnavailable)
+	}
+	return frame, nil
+}
@@ -0,0 +1,75 @@
+// This file contains resolution of the priority a new scene's jobs are published with, and the tier of the job queues
+// they are published to.
+//
+// Each tier has job queues of its own, which workers consume in the order of broker.Tiers, so bulk uploads of the free
+// tier never hold up the jobs of paying users. Within a tier the job queues are priority queues, so a high priority job
+// is always consumed before a normal one. High priority jobs are published to the priority tier.
+//
+// To keep normal jobs from starving behind a steady stream of high priority ones, the number of high priority scenes
+// processing at once can be limited. Scenes over the limit are processed at normal priority in the standard tier, so as
+// long as the limit is below the number of workers, some workers are always left to consume normal jobs.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+var (
+	// ErrInvalidPriority is returned when a job priority is not one of scene.ValidPriorities.
+	ErrInvalidPriority = errors.New("invalid job priority")
+	// ErrPriorityNotAllowed is returned when a user requests a job priority above their maximum.
+	ErrPriorityNotAllowed = errors.New("job priority not allowed for user")
+)
+
+// SetMaxHighPriorityJobs changes the most high priority scenes processing at once. A value <= 0 does not limit them.
+// Scenes already processing keep their priority.
+func (s *ClientService) SetMaxHighPriorityJobs(limit int64) {
+	s.maxHighPriorityJobs.Store(limit)
+}
+
+// resolvePriority returns the priority a new scene of the user should be published with given the requested one, and
+// the tier whose job queues it is published to.
+//
+// Scenes are published to the tier of the user, except high priority scenes, which are published to the priority
+// tier. Users in the priority tier always publish at high priority. A high priority scene is downgraded to normal
+// priority in the standard tier while maxHighPriorityJobs high priority scenes are processing.
+// Returns ErrInvalidPriority if the priority is unknown, or ErrPriorityNotAllowed if the user may not request it.
+func (s *ClientService) resolvePriority(ctx context.Context, userID primitive.ObjectID, requested string) (string, string, error) {
+	if !scene.IsValidPriority(requested) {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidPriority, requested)
+	}
+
+	user, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if !user.CanRequestPriority(requested) {
+		return "", "", fmt.Errorf("%w: %s", ErrPriorityNotAllowed, requested)
+	}
+	tier := user.JobTier()
+	if requested == scene.PriorityNormal && tier != broker.TierPriority {
+		return requested, tier, nil
+	}
+
+	if limit := s.maxHighPriorityJobs.Load(); limit > 0 {
+		processing, err := s.sceneManager.CountProcessingWithPriority(ctx, scene.PriorityHigh)
+		if err != nil {
+			return "", "", err
+		}
+		if processing >= limit {
+			s.logger.Ctx(ctx).Infof("High priority limit of %d reached, user %s job published at normal priority",
+				limit, userID.Hex())
+			return scene.PriorityNormal, broker.TierStandard, nil
+		}
+	}
+
+	return scene.PriorityHigh, broker.TierPriority, nil
+}
@@ -0,0 +1,154 @@
+// This file contains the progress estimate of a job, for progress bars.
+//
+// The job is split into its sfm and training stages. Training progress is the share of iterations done, and the time
+// left is the iterations left at the moving average throughput of the worker (see scene.Progress.Smooth), falling
+// back to the worker's own estimate until a throughput is known. The sfm stage reports no iterations, so its progress
+// is derived from the time spent in the stage and the worker's estimate of the time left, if it gave one.
+// Scenes uploaded with pre-computed sfm output only count the training stage.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// sfmProgressShare is the share of a job's progress made by the sfm stage, the rest is made by the training stage.
+const sfmProgressShare = 0.2
+
+// JobProgress is the progress estimate of the job of a scene.
+//
+// Percent is the progress of the whole job, and StagePercent the progress of the current stage, both from 0 to 100.
+// ETASeconds is the estimated time left in the current stage and ETA when the stage is expected to end, both unset
+// while unknown, i.e before the worker reported progress. ETA is the current time once the estimate is overdue.
+// StageStartedAt is when the scene entered its current stage.
+type JobProgress struct {
+	State               scene.State `json:"state"`
+	Stage               string      `json:"stage,omitempty"`
+	StageStartedAt      *time.Time  `json:"stage_started_at,omitempty"`
+	Iteration           int         `json:"iteration,omitempty"`
+	TotalIterations     int         `json:"total_iterations,omitempty"`
+	IterationsPerSecond float64     `json:"iterations_per_second,omitempty"`
+	StagePercent        float64     `json:"stage_percent"`
+	Percent             float64     `json:"percent"`
+	ETASeconds          float64     `json:"eta_seconds,omitempty"`
+	ETA                 *time.Time  `json:"eta,omitempty"`
+}
+
+// GetJobProgress returns the progress estimate of the job of a scene, see the file comment. The latest progress
+// reported by a worker is used, even if not yet persisted.
+//
+// Returns (nil, error) if the user does not have access to the scene or an error occurred.
+func (s *ClientService) GetJobProgress(ctx context.Context, userID, sceneID primitive.ObjectID) (_ *JobProgress, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetJobProgress", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get job progress request received")
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadStatus); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil {
+		s.logger.Ctx(ctx).Info("Error getting scene status:", err.Error())
+		return nil, err
+	}
+	progress := status.Progress
+	if pending := s.mqService.pendingProgress(sceneID); pending != nil {
+		latest := *pending
+		latest.Smooth(status.Progress)
+		progress = &latest
+	}
+
+	sfm, err := s.sceneManager.GetSfm(ctx, sceneID)
+	if err != nil && !errors.Is(err, scene.ErrSfmNotFound) {
+		return nil, err
+	}
+	sfmShare := sfmProgressShare
+	if sfm != nil && sfm.Precomputed {
+		sfmShare = 0
+	}
+
+	return estimateJobProgress(status, progress, sfmShare, time.Now()), nil
+}
+
+// estimateJobProgress estimates the progress of a job in the given status at now, from the latest progress reported
+// by its worker, which may be nil. sfmShare is the share of the progress made by the sfm stage.
+func estimateJobProgress(status *scene.SceneStatus, progress *scene.Progress, sfmShare float64, now time.Time) *JobProgress {
+	jp := &JobProgress{State: status.State}
+
+	var stageState scene.State
+	switch status.State {
+	case scene.StateSfmRunning:
+		jp.Stage, stageState = metrics.StageSfm, scene.StateSfmRunning
+	case scene.StateSfmDone:
+		jp.Percent = 100 * sfmShare
+		return jp
+	case scene.StateTraining:
+		jp.Stage, stageState = metrics.StageTraining, scene.StateTraining
+	case scene.StateCompleted:
+		jp.StagePercent, jp.Percent = 100, 100
+		return jp
+	default:
+		// Waiting to be processed, or finished without completing
+		return jp
+	}
+	if startedAt, ok := status.EnteredAt[stageState]; ok {
+		jp.StageStartedAt = &startedAt
+	}
+
+	// Time left in the stage as of the report, and the stage's share of the job
+	var stageLeft time.Duration
+	stageShare, stageOffset := sfmShare, 0.0
+	if jp.Stage == metrics.StageTraining {
+		stageShare, stageOffset = 1-sfmShare, sfmShare
+	}
+
+	if progress != nil && progress.Stage == jp.Stage {
+		jp.Iteration = progress.Iteration
+		jp.TotalIterations = progress.TotalIterations
+		jp.IterationsPerSecond = progress.IterationsPerSecond
+
+		if progress.TotalIterations > 0 {
+			jp.StagePercent = 100 * math.Min(1, float64(progress.Iteration)/float64(progress.TotalIterations))
+			if progress.IterationsPerSecond > 0 {
+				left := float64(max(0, progress.TotalIterations-progress.Iteration)) / progress.IterationsPerSecond
+				stageLeft = time.Duration(left * float64(time.Second))
+			}
+		}
+		if stageLeft == 0 && progress.ETASeconds > 0 {
+			stageLeft = time.Duration(progress.ETASeconds * float64(time.Second))
+		}
+		if stageLeft > 0 {
+			if eta := progress.ReportedAt.Add(stageLeft); eta.After(now) {
+				jp.ETA = &eta
+				jp.ETASeconds = eta.Sub(now).Seconds()
+			} else {
+				// Overdue, the report is stale or the estimate was off
+				jp.ETA = &now
+			}
+			if progress.TotalIterations == 0 && jp.StageStartedAt != nil {
+				elapsed := progress.ReportedAt.Sub(*jp.StageStartedAt)
+				if elapsed > 0 {
+					jp.StagePercent = 100 * elapsed.Seconds() / (elapsed + stageLeft).Seconds()
+				}
+			}
+		}
+	}
+
+	jp.Percent = 100*stageOffset + jp.StagePercent*stageShare
+	return jp
+}
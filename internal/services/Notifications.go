@@ -0,0 +1,389 @@
+// This file contains the job notifications mailed to users when the training of one of their scenes completes or fails.
+//
+// Status events published to status streams (see StatusStream.go) are handed to Notifications, like they are to
+// Webhooks, and the terminal ones are mailed to the owner of the scene in the background. Mails are only sent to
+// verified accounts whose username is an email address, and users opt out of each kind of mail with their
+// user.NotificationPreferences. Events are handed over best effort: they are queued in a bounded buffer, dropped if it
+// is full, and a mail that fails to send is not retried.
+//
+// Subjects and bodies are rendered from text templates, one per event type, defining a "subject" and a "body"
+// template. The defaults can be replaced by files named after the event (i.e "training_complete.tmpl") in
+// NotificationConfig.TemplatesDir, rendered with a NotificationData.
+//
+// Mails go through a Mailer: SMTPMailer, which also covers Amazon SES through its SMTP interface, or SendGridMailer.
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Notification settings
+const (
+	// notificationBufferSize is the most events queued to be mailed, further events are dropped.
+	notificationBufferSize = 256
+	// notificationTimeout bounds rendering and sending a single mail.
+	notificationTimeout = 30 * time.Second
+	// sendGridEndpoint is the SendGrid v3 API endpoint mails are sent to.
+	sendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+)
+
+// defaultNotificationTemplates are the templates of each notified event, unless replaced, see the file comment.
+var defaultNotificationTemplates = map[string]string{
+	WebhookEventTrainingComplete: `{{define "subject"}}Your scene {{.SceneName}} is ready{{end}}
+{{- define "body"}}Hi {{.Username}},
+
+The training of your scene {{.SceneName}} completed on {{.Time.Format "Jan 2, 2006 at 15:04 MST"}}.
+{{- if .SceneURL}}
+
+You can view it at {{.SceneURL}}
+{{- end}}
+{{end}}`,
+	WebhookEventJobFailed: `{{define "subject"}}Processing of your scene {{.SceneName}} failed{{end}}
+{{- define "body"}}Hi {{.Username}},
+
+The processing of your scene {{.SceneName}} failed on {{.Time.Format "Jan 2, 2006 at 15:04 MST"}}.
+{{- if .Error}}
+
+Reason: {{.Error}}
+{{- end}}
+{{- if .SceneURL}}
+
+You can retry it at {{.SceneURL}}
+{{- end}}
+{{end}}`,
+}
+
+// NotificationConfig configures the job notifications mailed to users.
+type NotificationConfig struct {
+	// mailer used for notifications, nil logs them
+	Mailer Mailer
+	// link to a scene mailed to the user, with the scene ID appended. If empty, no link is mailed
+	SceneURL string
+	// directory of templates replacing the default ones, see the file comment. If empty, the defaults are used
+	TemplatesDir string
+}
+
+// NotificationData is what notification templates are rendered with.
+type NotificationData struct {
+	Username string
+	SceneID  string
+	// name of the scene, its ID if it has none
+	SceneName string
+	State     scene.State
+	// why the job failed, only set for failures
+	Error    string
+	SceneURL string
+	Time     time.Time
+}
+
+// notificationEvent is a status event of a scene waiting to be mailed.
+type notificationEvent struct {
+	sceneID primitive.ObjectID
+	event   JobStatusEvent
+}
+
+// Notifications mails users about their jobs in the background, see Notify.
+type Notifications struct {
+	sceneManager scene.SceneRepository
+	userManager  user.UserRepository
+	mailer       Mailer
+	sceneURL     string
+	templates    map[string]*template.Template
+	logger       *log.Logger
+	events       chan notificationEvent
+	// events dropped since the last warning
+	dropped   atomic.Int64
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewNotifications creates Notifications mailing users as configured, and starts mailing notified events. Close must
+// be called to stop it.
+//
+// Returns an error if a template in config.TemplatesDir cannot be parsed, or does not define a subject and a body.
+func NewNotifications(config NotificationConfig, sceneManager scene.SceneRepository, userManager user.UserRepository, logger *log.Logger) (*Notifications, error) {
+	templates, err := loadNotificationTemplates(config.TemplatesDir)
+	if err != nil {
+		return nil, err
+	}
+	mailer := config.Mailer
+	if mailer == nil {
+		mailer = NewLogMailer(logger)
+	}
+	n := &Notifications{
+		sceneManager: sceneManager,
+		userManager:  userManager,
+		mailer:       mailer,
+		sceneURL:     config.SceneURL,
+		templates:    templates,
+		logger:       logger,
+		events:       make(chan notificationEvent, notificationBufferSize),
+		stop:         make(chan struct{}),
+	}
+	n.wg.Add(1)
+	go n.run()
+	return n, nil
+}
+
+// loadNotificationTemplates parses the template of every notified event, from dir if it has one for the event.
+func loadNotificationTemplates(dir string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(defaultNotificationTemplates))
+	for event, text := range defaultNotificationTemplates {
+		if dir != "" {
+			b, err := os.ReadFile(filepath.Join(dir, event+".tmpl"))
+			if err == nil {
+				text = string(b)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+		t, err := template.New(event).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("notification template %s: %w", event, err)
+		}
+		if t.Lookup("subject") == nil || t.Lookup("body") == nil {
+			return nil, fmt.Errorf("notification template %s must define a subject and a body", event)
+		}
+		templates[event] = t
+	}
+	return templates, nil
+}
+
+// Notify queues a status event of a scene to be mailed to its owner. It never blocks, the event is dropped if the
+// queue is full. A nil Notifications mails nothing.
+func (n *Notifications) Notify(sceneID primitive.ObjectID, event JobStatusEvent) {
+	if n == nil || notificationEventType(event) == "" {
+		return
+	}
+	select {
+	case n.events <- notificationEvent{sceneID: sceneID, event: event}:
+	default:
+		n.dropped.Add(1)
+	}
+}
+
+// Close stops mailing notifications. Queued events are lost.
+func (n *Notifications) Close() {
+	if n == nil {
+		return
+	}
+	n.closeOnce.Do(func() { close(n.stop) })
+	n.wg.Wait()
+}
+
+// notificationEventType returns the event type of a status event that is mailed, or an empty string if it is not.
+func notificationEventType(event JobStatusEvent) string {
+	if event.Type != StatusEventStatus {
+		return ""
+	}
+	switch event.State {
+	case scene.StateCompleted:
+		return WebhookEventTrainingComplete
+	case scene.StateFailed:
+		return WebhookEventJobFailed
+	default:
+		return ""
+	}
+}
+
+// run mails queued events, until Notifications is closed.
+func (n *Notifications) run() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case e := <-n.events:
+			if dropped := n.dropped.Swap(0); dropped > 0 {
+				n.logger.Warnf("Dropped %d notifications, the notification buffer is full", dropped)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+			if err := n.send(ctx, e.sceneID, e.event); err != nil {
+				n.logger.Errorf("Failed to mail notification of scene %s: %v", e.sceneID.Hex(), err)
+			}
+			cancel()
+		}
+	}
+}
+
+// send mails an event of a scene to its owner, if their preferences and account allow it.
+func (n *Notifications) send(ctx context.Context, sceneID primitive.ObjectID, event JobStatusEvent) error {
+	eventType := notificationEventType(event)
+	owner, err := n.userManager.GetUserBySceneID(ctx, sceneID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		// The scene was deleted
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	preferences := owner.NotificationPreferences()
+	if (eventType == WebhookEventTrainingComplete && !preferences.TrainingComplete) ||
+		(eventType == WebhookEventJobFailed && !preferences.JobFailed) {
+		return nil
+	}
+	if owner.Disabled || !owner.IsVerified() {
+		return nil
+	}
+	address, err := mail.ParseAddress(owner.Username)
+	if err != nil {
+		n.logger.Debugf("Not mailing notification of scene %s, the username of its owner is not an email address", sceneID.Hex())
+		return nil
+	}
+
+	name, err := n.sceneManager.GetSceneName(ctx, sceneID)
+	if errors.Is(err, scene.ErrSceneNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = sceneID.Hex()
+	}
+	data := NotificationData{
+		Username:  owner.Username,
+		SceneID:   sceneID.Hex(),
+		SceneName: name,
+		State:     event.State,
+		Error:     event.Error,
+		Time:      event.Time,
+	}
+	if n.sceneURL != "" {
+		data.SceneURL = n.sceneURL + sceneID.Hex()
+	}
+
+	subject, body, err := n.render(eventType, data)
+	if err != nil {
+		return err
+	}
+	return n.mailer.Send(ctx, address.Address, subject, body)
+}
+
+// render renders the subject and body of the mail of an event type. Line breaks in the subject are replaced by
+// spaces, as they cannot be sent in a header.
+func (n *Notifications) render(eventType string, data NotificationData) (subject, body string, err error) {
+	t := n.templates[eventType]
+	var b strings.Builder
+	if err := t.ExecuteTemplate(&b, "subject", data); err != nil {
+		return "", "", err
+	}
+	subject = strings.Join(strings.Fields(b.String()), " ")
+	b.Reset()
+	if err := t.ExecuteTemplate(&b, "body", data); err != nil {
+		return "", "", err
+	}
+	return subject, b.String(), nil
+}
+
+// SendGridMailer is a Mailer that sends mails through the SendGrid v3 API.
+type SendGridMailer struct {
+	APIKey string
+	// sender address, which must be verified with SendGrid
+	From   string
+	client *http.Client
+}
+
+// NewSendGridMailer creates a SendGridMailer authenticating with apiKey.
+func NewSendGridMailer(apiKey, from string) *SendGridMailer {
+	return &SendGridMailer{APIKey: apiKey, From: from, client: &http.Client{Timeout: notificationTimeout}}
+}
+
+// Send sends the mail through the SendGrid API.
+func (m *SendGridMailer) Send(ctx context.Context, to, subject, body string) error {
+	type address struct {
+		Email string `json:"email"`
+	}
+	type content struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	payload, err := json.Marshal(struct {
+		Personalizations []struct {
+			To []address `json:"to"`
+		} `json:"personalizations"`
+		From    address   `json:"from"`
+		Subject string    `json:"subject"`
+		Content []content `json:"content"`
+	}{
+		Personalizations: []struct {
+			To []address `json:"to"`
+		}{{To: []address{{Email: to}}}},
+		From:    address{Email: m.From},
+		Subject: subject,
+		Content: []content{{Type: "text/plain", Value: body}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sendGridEndpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sendgrid responded with status %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// GetNotificationPreferences returns the notification preferences of a user.
+//
+// Returns (nil, error) if the user does not exist or an error occurred.
+func (s *ClientService) GetNotificationPreferences(ctx context.Context, userID primitive.ObjectID) (_ *user.NotificationPreferences, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetNotificationPreferences", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Get notification preferences request received")
+
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	preferences := u.NotificationPreferences()
+	return &preferences, nil
+}
+
+// SetNotificationPreferences replaces the notification preferences of a user.
+//
+// Returns an error if the user does not exist or an error occurred.
+func (s *ClientService) SetNotificationPreferences(ctx context.Context, userID primitive.ObjectID, preferences user.NotificationPreferences) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.SetNotificationPreferences", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Set notification preferences request received")
+
+	return s.userManager.SetNotificationPreferences(ctx, userID, preferences)
+}
@@ -0,0 +1,401 @@
+// This file contains logging in with an external account, i.e a Google or GitHub account, alongside passwords, see
+// OAuthProviders.go for the providers.
+//
+// A login starts at StartOAuthLogin, which returns the URL sending the user to the provider, and a state the client
+// keeps until the provider redirects back to RedirectURL with a code and the same state. The client must check that the
+// state it was redirected with is the one it kept, then hands both to OAuthLogin. The state is a JWT signed with the key
+// of access tokens, so nothing is stored while the user is at the provider. It names the provider, expires after
+// StateTTL, and carries the nonce the ID tokens of OpenID Connect providers must be issued for.
+//
+// The account at the provider is an identity of a local user, see user/Identities.go. Logging in with an identity no
+// user has creates a user without a password, named after the email of the account if the provider verified it. An
+// existing user is never linked by email, as the email of another provider's account proves nothing about it: if the
+// email is taken, the user must log in to that account and link the identity with StartOAuthLink and
+// LinkOAuthIdentity, whose state is bound to the user. A login through a provider starts a session like LoginUser.
+
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrOAuthProviderNotEnabled is returned when logging in with a provider that is not configured.
+	ErrOAuthProviderNotEnabled = errors.New("login provider not enabled")
+	// ErrInvalidOAuthState is returned when the state of a login was not issued by this server, is for another
+	// provider or user, or expired.
+	ErrInvalidOAuthState = errors.New("invalid or expired login state, start the login again")
+	// ErrOAuthEmailTaken is returned when logging in with an unlinked identity whose email is the username of a user.
+	ErrOAuthEmailTaken = errors.New("an account with this email exists, log in to it and link the provider")
+)
+
+// DefaultOAuthStateTTL is how long a login may take at the provider when none is configured.
+const DefaultOAuthStateTTL = 10 * time.Minute
+
+// oauthStateType is the "typ" claim of login states, so they are not accepted as access tokens, nor the reverse.
+const oauthStateType = "oauth_state"
+
+// OAuthClient is the client registered with a provider.
+type OAuthClient struct {
+	ClientID     string
+	ClientSecret string
+	// issuer of OAuthProviderOIDC, i.e "https://sso.example.edu/realms/lab", unused by other providers
+	Issuer string
+}
+
+// OAuthConfig configures logging in with external accounts.
+type OAuthConfig struct {
+	// page of the client providers redirect back to, registered with every provider
+	RedirectURL string
+	// how long a login may take at the provider, DefaultOAuthStateTTL if not set
+	StateTTL time.Duration
+	// clients of the enabled providers, keyed by OAuthProviderGoogle, OAuthProviderGitHub, or OAuthProviderOIDC
+	Providers map[string]OAuthClient
+}
+
+// oauthLogin is the enabled providers and their config.
+type oauthLogin struct {
+	config    OAuthConfig
+	providers map[string]oauthProvider
+}
+
+// OAuthAuthorization is the start of a login with a provider.
+type OAuthAuthorization struct {
+	// where to send the user to log in
+	URL string `json:"authorization_url"`
+	// to keep until the provider redirects back, and hand back with the code
+	State string `json:"state"`
+}
+
+// LinkedIdentities are the ways a user logs in.
+type LinkedIdentities struct {
+	HasPassword bool            `json:"has_password"`
+	Identities  []user.Identity `json:"identities"`
+}
+
+// SetOAuth enables logging in with the providers of config, replacing any previously enabled. Returns an error,
+// leaving the providers unchanged, if a provider is unknown or configured without a redirect URL.
+func (s *ClientService) SetOAuth(config OAuthConfig) error {
+	if len(config.Providers) == 0 {
+		s.oauth.Store(nil)
+		return nil
+	}
+	if config.RedirectURL == "" {
+		return errors.New("login providers require a redirect URL")
+	}
+	if config.StateTTL <= 0 {
+		config.StateTTL = DefaultOAuthStateTTL
+	}
+
+	httpClient := &http.Client{Timeout: oauthTimeout}
+	providers := make(map[string]oauthProvider, len(config.Providers))
+	for name, client := range config.Providers {
+		switch name {
+		case OAuthProviderGoogle:
+			providers[name] = newOIDCProvider(googleIssuer, client, httpClient)
+		case OAuthProviderGitHub:
+			providers[name] = &githubProvider{client: client, http: httpClient}
+		case OAuthProviderOIDC:
+			if client.Issuer == "" {
+				return fmt.Errorf("login provider %q requires an issuer", name)
+			}
+			providers[name] = newOIDCProvider(client.Issuer, client, httpClient)
+		default:
+			return fmt.Errorf("unknown login provider %q", name)
+		}
+	}
+	s.oauth.Store(&oauthLogin{config: config, providers: providers})
+	return nil
+}
+
+// OAuthProviders returns the names of the enabled providers, sorted.
+func (s *ClientService) OAuthProviders() []string {
+	login := s.oauth.Load()
+	if login == nil {
+		return []string{}
+	}
+	names := make([]string, 0, len(login.providers))
+	for name := range login.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// enabledOAuthProvider returns the enabled provider with the given name, and the config of logins.
+//
+// Returns ErrOAuthProviderNotEnabled if the provider is not enabled.
+func (s *ClientService) enabledOAuthProvider(name string) (oauthProvider, OAuthConfig, error) {
+	login := s.oauth.Load()
+	if login == nil {
+		return nil, OAuthConfig{}, ErrOAuthProviderNotEnabled
+	}
+	provider, ok := login.providers[name]
+	if !ok {
+		return nil, OAuthConfig{}, ErrOAuthProviderNotEnabled
+	}
+	return provider, login.config, nil
+}
+
+// startOAuth starts a login with a provider, for the user with the given ID to link the identity to, or to log in if
+// userID is nil.
+func (s *ClientService) startOAuth(ctx context.Context, providerName string, userID primitive.ObjectID) (*OAuthAuthorization, error) {
+	provider, config, err := s.enabledOAuthProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.tokens.Secret) == 0 {
+		return nil, errors.New("access token signing key not configured")
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	nonce := hex.EncodeToString(b)
+	claims := jwt.MapClaims{
+		"typ":   oauthStateType,
+		"prv":   providerName,
+		"nonce": nonce,
+		"exp":   time.Now().Add(config.StateTTL).Unix(),
+	}
+	if !userID.IsZero() {
+		claims["sub"] = userID.Hex()
+	}
+	state, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.tokens.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign login state: %w", err)
+	}
+
+	authorizationURL, err := provider.authorizationURL(ctx, config.RedirectURL, state, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &OAuthAuthorization{URL: authorizationURL, State: state}, nil
+}
+
+// completeOAuth checks the state of a login with a provider, for the user with the given ID or nil as it was started,
+// and exchanges its code for the identity that logged in.
+//
+// Returns ErrInvalidOAuthState if the state does not match, or the errors of the provider.
+func (s *ClientService) completeOAuth(ctx context.Context, providerName, code, state string, userID primitive.ObjectID) (*oauthIdentity, error) {
+	provider, config, err := s.enabledOAuthProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := jwt.Parse(state, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return s.tokens.Secret, nil
+	})
+	if err != nil || !parsed.Valid {
+		return nil, ErrInvalidOAuthState
+	}
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != oauthStateType || claims["prv"] != providerName {
+		return nil, ErrInvalidOAuthState
+	}
+	if _, ok := claims["exp"].(float64); !ok {
+		return nil, ErrInvalidOAuthState
+	}
+	// A state started to link an identity only completes for the same user, and one started to log in only logs in
+	subject, _ := claims["sub"].(string)
+	if (userID.IsZero() && subject != "") || (!userID.IsZero() && subject != userID.Hex()) {
+		return nil, ErrInvalidOAuthState
+	}
+	nonce, _ := claims["nonce"].(string)
+
+	return provider.identify(ctx, config.RedirectURL, code, nonce)
+}
+
+// StartOAuthLogin starts logging in with a provider, see OAuth.go.
+//
+// Returns ErrOAuthProviderNotEnabled if the provider is not enabled.
+func (s *ClientService) StartOAuthLogin(ctx context.Context, provider string) (_ *OAuthAuthorization, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.StartOAuthLogin", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Start OAuth login request received")
+
+	return s.startOAuth(ctx, provider, primitive.NilObjectID)
+}
+
+// OAuthLogin completes a login with a provider, with the code and state the provider redirected back with, and starts
+// a session of the user with the identity that logged in, creating the user if no user has it.
+//
+// Returns ErrInvalidOAuthState if the state was not issued by StartOAuthLogin for the provider or expired,
+// ErrOAuthFailed if the provider rejects the code, ErrOAuthEmailTaken if a new user would take the username of
+// another, or the errors of LoginUser for disabled or unverified accounts.
+func (s *ClientService) OAuthLogin(ctx context.Context, provider, code, state string) (_ *TokenPair, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.OAuthLogin", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("OAuth login request received")
+
+	identity, err := s.completeOAuth(ctx, provider, code, state, primitive.NilObjectID)
+	if err != nil {
+		s.audit.Record(ctx, primitive.NilObjectID, primitive.NilObjectID, audit.ActionLogin, audit.OutcomeDenied, provider+": "+err.Error())
+		return nil, err
+	}
+
+	u, err := s.userManager.GetUserByIdentity(ctx, provider, identity.Subject)
+	if errors.Is(err, user.ErrUserNotFound) {
+		u, err = s.createOAuthUser(ctx, provider, identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Disabled {
+		s.audit.Record(ctx, u.ID, primitive.NilObjectID, audit.ActionLogin, audit.OutcomeDenied, user.ErrAccountDisabled.Error())
+		return nil, user.ErrAccountDisabled
+	}
+	if s.verification.Enabled && s.verification.RequireForLogin && !u.IsVerified() {
+		s.audit.Record(ctx, u.ID, primitive.NilObjectID, audit.ActionLogin, audit.OutcomeDenied, ErrEmailNotVerified.Error())
+		return nil, ErrEmailNotVerified
+	}
+
+	tokens, err := s.issueTokens(ctx, u.ID, primitive.NewObjectID(), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Record(ctx, u.ID, primitive.NilObjectID, audit.ActionLogin, audit.OutcomeAllowed, provider)
+	return tokens, nil
+}
+
+// createOAuthUser creates a user without a password for an identity no user has. The user is named after the
+// verified email of the identity, or else after the provider and the login or subject of the account.
+//
+// Returns ErrOAuthEmailTaken if the email is the username of another user.
+func (s *ClientService) createOAuthUser(ctx context.Context, provider string, identity *oauthIdentity) (*user.User, error) {
+	linked := user.NewIdentity(provider, identity.Subject, identity.Email)
+	if identity.Email != "" {
+		u, err := s.userManager.GenerateExternalUser(ctx, identity.Email, linked)
+		if errors.Is(err, user.ErrUsernameTaken) {
+			return nil, ErrOAuthEmailTaken
+		}
+		if !errors.Is(err, user.ErrInvalidUsername) {
+			return s.createdOAuthUser(ctx, linked, u, err)
+		}
+	}
+
+	// Logins are shown rather than the subject where there is one, and the subject settles a taken login
+	usernames := []string{provider + "-" + identity.Subject}
+	if identity.Login != "" {
+		usernames = []string{provider + "-" + identity.Login, provider + "-" + identity.Subject}
+	}
+	var err error
+	for _, username := range usernames {
+		var u *user.User
+		u, err = s.userManager.GenerateExternalUser(ctx, username, linked)
+		if !errors.Is(err, user.ErrUsernameTaken) && !errors.Is(err, user.ErrInvalidUsername) {
+			return s.createdOAuthUser(ctx, linked, u, err)
+		}
+	}
+	return nil, err
+}
+
+// createdOAuthUser returns the result of creating a user for an identity. A concurrent login with the same identity
+// may have created its user first, which is then returned.
+func (s *ClientService) createdOAuthUser(ctx context.Context, identity *user.Identity, u *user.User, err error) (*user.User, error) {
+	if errors.Is(err, user.ErrIdentityTaken) {
+		return s.userManager.GetUserByIdentity(ctx, identity.Provider, identity.Subject)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Ctx(ctx).Infof("Created user %s for a %s login", u.ID.Hex(), identity.Provider)
+	return u, nil
+}
+
+// StartOAuthLink starts linking an identity of a provider to the user with the given ID, see OAuth.go.
+//
+// Returns ErrOAuthProviderNotEnabled if the provider is not enabled.
+func (s *ClientService) StartOAuthLink(ctx context.Context, userID primitive.ObjectID, provider string) (_ *OAuthAuthorization, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.StartOAuthLink", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Start OAuth link request received")
+
+	if _, err := s.userManager.GetUserByID(ctx, userID); err != nil {
+		return nil, err
+	}
+	return s.startOAuth(ctx, provider, userID)
+}
+
+// LinkOAuthIdentity completes linking an identity to the user with the given ID, with the code and state the
+// provider redirected back with. The user can log in with the identity from then on.
+//
+// Returns ErrInvalidOAuthState if the state was not issued by StartOAuthLink for the user and provider or expired,
+// ErrOAuthFailed if the provider rejects the code, user.ErrIdentityExists if the user already has an identity of the
+// provider, or user.ErrIdentityTaken if the identity is linked to another user.
+func (s *ClientService) LinkOAuthIdentity(ctx context.Context, userID primitive.ObjectID, provider, code, state string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.LinkOAuthIdentity", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Link OAuth identity request received")
+
+	identity, err := s.completeOAuth(ctx, provider, code, state, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.AddIdentity(ctx, userID, user.NewIdentity(provider, identity.Subject, identity.Email)); err != nil {
+		s.audit.Record(ctx, userID, primitive.NilObjectID, audit.ActionLinkIdentity, audit.OutcomeDenied, provider+": "+err.Error())
+		return err
+	}
+	s.logger.Ctx(ctx).Infof("Linked a %s identity to user %s", provider, userID.Hex())
+	s.audit.Record(ctx, userID, primitive.NilObjectID, audit.ActionLinkIdentity, audit.OutcomeAllowed, provider)
+	return nil
+}
+
+// ListIdentities returns the identities linked to the user with the given ID, and whether the user has a password.
+func (s *ClientService) ListIdentities(ctx context.Context, userID primitive.ObjectID) (_ *LinkedIdentities, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.ListIdentities", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	identities := u.Identities
+	if identities == nil {
+		identities = []user.Identity{}
+	}
+	return &LinkedIdentities{HasPassword: u.HasPassword(), Identities: identities}, nil
+}
+
+// UnlinkOAuthIdentity unlinks the identity of a provider from the user with the given ID. The user's sessions are
+// kept, including those started with the identity.
+//
+// Returns user.ErrIdentityNotFound if the user has no identity of the provider, or user.ErrLastLoginMethod if the
+// user has no password nor another identity to log in with.
+func (s *ClientService) UnlinkOAuthIdentity(ctx context.Context, userID primitive.ObjectID, provider string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.UnlinkOAuthIdentity", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Unlink OAuth identity request received")
+
+	if err := s.userManager.RemoveIdentity(ctx, userID, provider); err != nil {
+		return err
+	}
+	s.logger.Ctx(ctx).Infof("Unlinked the %s identity of user %s", provider, userID.Hex())
+	s.audit.Record(ctx, userID, primitive.NilObjectID, audit.ActionUnlinkIdentity, audit.OutcomeAllowed, provider)
+	return nil
+}
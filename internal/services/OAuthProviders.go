@@ -0,0 +1,404 @@
+// This file contains the providers users log in with through OAuth.go: OpenID Connect providers, i.e Google or the
+// single sign-on of a lab, and GitHub, which only implements OAuth 2.0.
+//
+// Both exchange the authorization code the provider redirected the user back with at the provider's token endpoint,
+// authenticated with the client secret. OpenID Connect providers are found through the discovery document of their
+// issuer, and the ID token they return is verified against the signing keys the issuer publishes, which are cached and
+// refetched when a token is signed with an unknown key. GitHub is asked for the account through its API instead.
+
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	// ErrOAuthFailed is returned when a provider rejects a login, or returns an identity that cannot be verified.
+	ErrOAuthFailed = errors.New("login with the provider failed")
+	// ErrOAuthProviderUnavailable is returned when a provider cannot be reached, or fails.
+	ErrOAuthProviderUnavailable = errors.New("login provider unavailable, try again later")
+)
+
+// Declarations for supported login providers
+const (
+	OAuthProviderGoogle = "google"
+	OAuthProviderGitHub = "github"
+	// an OpenID Connect provider with a configured issuer
+	OAuthProviderOIDC = "oidc"
+)
+
+// googleIssuer is the OpenID Connect issuer of Google accounts.
+const googleIssuer = "https://accounts.google.com"
+
+// GitHub OAuth endpoints
+const (
+	githubAuthorizeURL = "https://github.com/login/oauth/authorize"
+	githubTokenURL     = "https://github.com/login/oauth/access_token"
+	githubAPIURL       = "https://api.github.com"
+)
+
+// Provider requests
+const (
+	// oauthTimeout bounds every request to a provider.
+	oauthTimeout = 10 * time.Second
+	// oauthMaxResponseSize is the most bytes read of a provider response.
+	oauthMaxResponseSize = 1024 * 1024
+	// jwksRefreshInterval is the least time between fetches of the signing keys of an issuer, so tokens signed with
+	// unknown keys cannot make the server hammer the issuer.
+	jwksRefreshInterval = time.Minute
+	// idTokenLeeway is the clock skew tolerated when checking the expiry of ID tokens.
+	idTokenLeeway = time.Minute
+)
+
+// oauthIdentity is the account a user logged in with at a provider.
+type oauthIdentity struct {
+	// ID of the account at the provider, which never changes
+	Subject string
+	// email of the account, "" unless the provider verified it
+	Email string
+	// name of the account, i.e a GitHub login, "" if the provider has none
+	Login string
+}
+
+// oauthProvider is a provider users log in with.
+type oauthProvider interface {
+	// authorizationURL returns the URL sending the user to log in at the provider, which redirects back to
+	// redirectURL with a code and state. nonce is bound to the ID token of OpenID Connect providers.
+	authorizationURL(ctx context.Context, redirectURL, state, nonce string) (string, error)
+	// identify exchanges the code of a login for the identity of the account that logged in.
+	identify(ctx context.Context, redirectURL, code, nonce string) (*oauthIdentity, error)
+}
+
+// oauthTokenResponse is the response of a token endpoint.
+type oauthTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// oauthRequest sends req and decodes its JSON response into v.
+//
+// Returns ErrOAuthProviderUnavailable if the provider cannot be reached or fails, or ErrOAuthFailed if it refuses the
+// request.
+func oauthRequest(client *http.Client, req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOAuthProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oauthMaxResponseSize))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOAuthProviderUnavailable, err)
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%w: %s responded %d", ErrOAuthProviderUnavailable, req.URL.Host, resp.StatusCode)
+	}
+	if resp.StatusCode >= 400 {
+		// Token endpoints describe the error in the body, see oauthTokenResponse
+		var failure oauthTokenResponse
+		if json.Unmarshal(body, &failure) == nil && failure.Error != "" {
+			return fmt.Errorf("%w: %s", ErrOAuthFailed, failure.Error)
+		}
+		return fmt.Errorf("%w: %s responded %d", ErrOAuthFailed, req.URL.Host, resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: invalid response of %s: %v", ErrOAuthProviderUnavailable, req.URL.Host, err)
+	}
+	return nil
+}
+
+// exchangeCode exchanges the code of a login at a token endpoint.
+func exchangeCode(ctx context.Context, httpClient *http.Client, tokenURL string, client OAuthClient, redirectURL, code string) (*oauthTokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {client.ClientID},
+		"client_secret": {client.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var tokens oauthTokenResponse
+	if err := oauthRequest(httpClient, req, &tokens); err != nil {
+		return nil, err
+	}
+	// GitHub reports errors with a 200 response
+	if tokens.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrOAuthFailed, tokens.Error)
+	}
+	return &tokens, nil
+}
+
+// oidcDiscovery is the part of the discovery document of an OpenID Connect issuer used to log in.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider is an OpenID Connect provider.
+type oidcProvider struct {
+	issuer string
+	client OAuthClient
+	http   *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+	// when keys were last fetched
+	keysFetchedAt time.Time
+}
+
+// newOIDCProvider creates the provider of an OpenID Connect issuer. Its discovery document is fetched on first use.
+func newOIDCProvider(issuer string, client OAuthClient, httpClient *http.Client) *oidcProvider {
+	return &oidcProvider{issuer: strings.TrimSuffix(issuer, "/"), client: client, http: httpClient}
+}
+
+// discover returns the discovery document of the issuer, fetching it once.
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var discovery oidcDiscovery
+	if err := oauthRequest(p.http, req, &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("%w: discovery document of %s is for issuer %s", ErrOAuthProviderUnavailable, p.issuer, discovery.Issuer)
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// authorizationURL implements oauthProvider.
+func (p *oidcProvider) authorizationURL(ctx context.Context, redirectURL, state, nonce string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.client.ClientID},
+		"redirect_uri":  {redirectURL},
+		"scope":         {"openid email profile"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	return discovery.AuthorizationEndpoint + "?" + query.Encode(), nil
+}
+
+// identify implements oauthProvider. The identity is read from the verified ID token.
+func (p *oidcProvider) identify(ctx context.Context, redirectURL, code, nonce string) (*oauthIdentity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := exchangeCode(ctx, p.http, discovery.TokenEndpoint, p.client, redirectURL, code)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no ID token in the token response", ErrOAuthFailed)
+	}
+	claims, err := p.verifyIDToken(ctx, discovery, tokens.IDToken, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID token: %v", ErrOAuthFailed, err)
+	}
+
+	identity := &oauthIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: ID token has no subject", ErrOAuthFailed)
+	}
+	// Some issuers send email_verified as a string
+	if verified := claims["email_verified"]; verified == true || verified == "true" {
+		identity.Email, _ = claims["email"].(string)
+	}
+	identity.Login, _ = claims["preferred_username"].(string)
+	return identity, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry, and nonce of an ID token, and returns its claims.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, discovery *oidcDiscovery, idToken, nonce string) (jwt.MapClaims, error) {
+	// Expiry is checked below with some leeway, as ID tokens are issued by another clock
+	parser := jwt.Parser{ValidMethods: []string{"RS256"}, SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, discovery, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Google issues tokens both with and without the scheme of its issuer
+	issuer, _ := claims["iss"].(string)
+	if issuer != discovery.Issuer && "https://"+issuer != discovery.Issuer {
+		return nil, fmt.Errorf("issued by %q", issuer)
+	}
+	if !audienceContains(claims["aud"], p.client.ClientID) {
+		return nil, errors.New("issued to another client")
+	}
+	expiry, ok := claims["exp"].(float64)
+	if !ok || time.Now().After(time.Unix(int64(expiry), 0).Add(idTokenLeeway)) {
+		return nil, errors.New("expired")
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("nonce does not match the login")
+	}
+	return claims, nil
+}
+
+// audienceContains checks if the "aud" claim of a token, a string or an array of strings, contains clientID.
+func audienceContains(aud any, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []any:
+		return slices.Contains(aud, any(clientID))
+	}
+	return false
+}
+
+// jwk is an RSA key of a JSON Web Key Set.
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// key returns the signing key of the issuer with the given ID, fetching the keys of the issuer if it is not known.
+func (p *oidcProvider) key(ctx context.Context, discovery *oidcDiscovery, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discovery.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := oauthRequest(p.http, req, &set); err != nil {
+		return nil, err
+	}
+	p.keysFetchedAt = time.Now()
+	p.keys = make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		p.keys[k.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// githubProvider is GitHub, which only implements OAuth 2.0. The identity is read from the API with the access token.
+type githubProvider struct {
+	client OAuthClient
+	http   *http.Client
+}
+
+// authorizationURL implements oauthProvider. GitHub has no ID tokens, so nonce is not used.
+func (p *githubProvider) authorizationURL(_ context.Context, redirectURL, state, _ string) (string, error) {
+	query := url.Values{
+		"client_id":    {p.client.ClientID},
+		"redirect_uri": {redirectURL},
+		"scope":        {"read:user user:email"},
+		"state":        {state},
+	}
+	return githubAuthorizeURL + "?" + query.Encode(), nil
+}
+
+// identify implements oauthProvider. The email is the primary email of the account, if GitHub verified it.
+func (p *githubProvider) identify(ctx context.Context, redirectURL, code, _ string) (*oauthIdentity, error) {
+	tokens, err := exchangeCode(ctx, p.http, githubTokenURL, p.client, redirectURL, code)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.AccessToken == "" {
+		return nil, fmt.Errorf("%w: no access token in the token response", ErrOAuthFailed)
+	}
+
+	var account struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := p.get(ctx, tokens.AccessToken, "/user", &account); err != nil {
+		return nil, err
+	}
+	if account.ID == 0 {
+		return nil, fmt.Errorf("%w: GitHub returned no account", ErrOAuthFailed)
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.get(ctx, tokens.AccessToken, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &oauthIdentity{Subject: strconv.FormatInt(account.ID, 10), Login: account.Login}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			identity.Email = email.Email
+		}
+	}
+	return identity, nil
+}
+
+// get reads a resource of the GitHub API with the access token of a login.
+func (p *githubProvider) get(ctx context.Context, accessToken, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, githubAPIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	return oauthRequest(p.http, req, v)
+}
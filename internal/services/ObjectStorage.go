@@ -0,0 +1,194 @@
+// This file contains the mirroring of scene outputs to the configured object store, see the storage package.
+//
+// Scene files are still written to the local disk first, as workers exchange them with the server through worker-data
+// URLs. Once a scene's outputs are saved they are copied to the store, and the local copy becomes a cache: a server
+// without the files on disk (i.e a new replica behind a load balancer) restores an output from the store the first
+// time it is requested. Signed resource URLs of mirrored outputs point to the store directly when it can sign URLs,
+// so downloads bypass the web servers entirely. Deleting a scene deletes its objects as well.
+//
+// Without a configured store, none of this applies and scene files only live on disk.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/storage"
+)
+
+// nerfOutputPaths returns the paths of every output file of a nerf, of every type and iteration.
+func nerfOutputPaths(nerf *scene.Nerf) []string {
+	paths := make([]string, 0)
+	for _, filePaths := range []map[int]string{
+		nerf.ModelFilePathsMap,
+		nerf.SplatCloudFilePathsMap,
+		nerf.PointCloudFilePathsMap,
+		nerf.VideoFilePathsMap,
+		nerf.CheckpointFilePathsMap,
+	} {
+		for _, path := range filePaths {
+			paths = append(paths, path)
+		}
+	}
+	for _, filePaths := range nerf.ExportFilePathsMap {
+		for _, path := range filePaths {
+			paths = append(paths, path)
+		}
+	}
+	for _, render := range nerf.Renders {
+		if render.FilePath != "" {
+			paths = append(paths, render.FilePath)
+		}
+	}
+	return paths
+}
+
+// storeOutputs copies the output files of a scene to the object store. Failures are logged rather than returned, as
+// the outputs remain available from the local disk.
+func (s *AMPQService) storeOutputs(ctx context.Context, sceneID primitive.ObjectID, nerf *scene.Nerf) {
+	if s.store == nil {
+		return
+	}
+	for _, path := range nerfOutputPaths(nerf) {
+		if err := storeFile(ctx, s.store, s.sceneManager, sceneID, path); err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to store output %s of scene %s: %v", path, sceneID.Hex(), err)
+		}
+	}
+}
+
+// storeFile copies a file of a scene's directory to the object store, under its ObjectKey.
+func storeFile(ctx context.Context, store storage.Store, sm scene.SceneRepository, sceneID primitive.ObjectID, path string) error {
+	key, err := sm.ObjectKey(sceneID, path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, key, file, stat.Size(), mime.TypeByExtension(filepath.Ext(path)))
+}
+
+// restoreOutputs downloads the output files of the given type of a scene that are missing from the local disk but
+// present in the object store, so GetSceneOutput can serve them. Outputs missing from both are left to Reconcile.
+func (s *ClientService) restoreOutputs(ctx context.Context, sceneID primitive.ObjectID, nerf *scene.Nerf, outputType string) error {
+	if s.store == nil {
+		return nil
+	}
+	filePaths, err := nerf.GetFilePathsForType(outputType)
+	if err != nil {
+		return err
+	}
+	for _, path := range filePaths {
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		err := s.restoreFile(ctx, sceneID, path)
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s from object storage: %w", path, err)
+		}
+		s.sceneCache.InvalidateOutputs(ctx, sceneID)
+	}
+	return nil
+}
+
+// restoreFile downloads a file of a scene's directory from the object store. Concurrent restores of the same file
+// are serialized, and the file only appears once completely downloaded.
+func (s *ClientService) restoreFile(ctx context.Context, sceneID primitive.ObjectID, path string) error {
+	key, err := s.sceneManager.ObjectKey(sceneID, path)
+	if err != nil {
+		return err
+	}
+
+	lock, _ := s.restoreLocks.LoadOrStore(path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Restored by a concurrent request while waiting
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	body, info, err := s.store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size >= 0 && written != info.Size {
+		return fmt.Errorf("restored %d bytes of %s, expected %d", written, key, info.Size)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	s.logger.Ctx(ctx).Infof("Restored %s of scene %s from object storage", filepath.Base(path), sceneID.Hex())
+	return nil
+}
+
+// storedOutputURL returns a URL of the object store giving access to an output file for ttl, or an empty string if
+// the store cannot sign URLs or does not hold the file, in which case a signed resource URL of this server is used.
+func (s *ClientService) storedOutputURL(ctx context.Context, sceneID primitive.ObjectID, path string, ttl time.Duration) (string, error) {
+	if s.store == nil {
+		return "", nil
+	}
+	key, err := s.sceneManager.ObjectKey(sceneID, path)
+	if err != nil {
+		// Converted outputs live outside of the scene's directory, and are never stored
+		return "", nil
+	}
+	if _, err := s.store.Stat(ctx, key); errors.Is(err, storage.ErrObjectNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	signedURL, err := s.store.SignedURL(ctx, key, min(ttl, storage.MaxSignedURLTTL))
+	if errors.Is(err, storage.ErrSignedURLUnsupported) {
+		return "", nil
+	}
+	return signedURL, err
+}
+
+// deleteStoredScene deletes every object of a scene from the object store.
+func (s *ClientService) deleteStoredScene(ctx context.Context, sceneID primitive.ObjectID) error {
+	if s.store == nil {
+		return nil
+	}
+	return s.store.DeletePrefix(ctx, s.sceneManager.SceneKeyPrefix(sceneID))
+}
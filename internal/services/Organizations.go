@@ -0,0 +1,455 @@
+// This file contains organizations, team workspaces whose members share the scenes uploaded into them.
+//
+// Any user may create an organization, becoming its first admin. Admins invite users by username in a role, and
+// invited users join by accepting. Scenes uploaded into an organization's workspace stay owned by their uploader, who
+// is charged for them, and members have access to them by their role, see orgActions. Admins may do anything the owner
+// may, members may also upload into the workspace, and train and edit its scenes, and viewers may only read them.
+//
+// Organization roles are cached like collaborator roles, prefixed so the two are not confused, and invalidated on the
+// scenes of the workspace when a member's role changes or they leave.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/org"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// MaxOrgNameLength is the longest organization name, in characters.
+const MaxOrgNameLength = 128
+
+// orgAccessRolePrefix prefixes the cached access roles of organization members, see cacheAccessRole.
+const orgAccessRolePrefix = "org:"
+
+// orgActions maps the member roles below admin to the audited actions they may perform on the scenes of their
+// organization's workspace. Admins may perform every action.
+var orgActions = map[string][]string{
+	org.RoleViewer: {
+		audit.ActionReadMetadata,
+		audit.ActionReadStatus,
+		audit.ActionDownload,
+	},
+	org.RoleMember: {
+		audit.ActionReadMetadata,
+		audit.ActionReadStatus,
+		audit.ActionDownload,
+		audit.ActionCancel,
+		audit.ActionRetry,
+		audit.ActionRetrain,
+		audit.ActionExport,
+		audit.ActionRender,
+		audit.ActionEditMetadata,
+	},
+}
+
+// orgMemberMayPerform checks if a member of the given role may perform the given action on a scene of the workspace.
+func orgMemberMayPerform(role, action string) bool {
+	return role == org.RoleAdmin || slices.Contains(orgActions[role], action)
+}
+
+// orgAccessRoleMayPerform checks if a cached access role is that of an organization member who may perform the given
+// action.
+func orgAccessRoleMayPerform(accessRole, action string) bool {
+	role, ok := strings.CutPrefix(accessRole, orgAccessRolePrefix)
+	return ok && orgMemberMayPerform(role, action)
+}
+
+// OrgSummary is an organization as listed for a user: with their role in it, or the role they are invited in.
+type OrgSummary struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Invited   bool      `json:"invited"`
+	Members   int       `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrgMember is a member of an organization, or a user invited to it.
+type OrgMember struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// when the member joined, or the user was invited
+	Since time.Time `json:"since"`
+}
+
+// OrgDetails is an organization as shown to its members. Invitations are only shown to admins.
+type OrgDetails struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Role        string      `json:"role"`
+	Members     []OrgMember `json:"members"`
+	Invitations []OrgMember `json:"invitations,omitempty"`
+}
+
+// CreateOrg creates an organization named name, with the user as its admin.
+//
+// Returns ErrValidation if the name is empty or too long, or error if an error occurred.
+func (s *ClientService) CreateOrg(ctx context.Context, userID primitive.ObjectID, name string) (_ *OrgSummary, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.CreateOrg", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Create organization request received")
+
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > MaxOrgNameLength {
+		return nil, NewValidationError(
+			"invalid organization name",
+			map[string]string{"name": fmt.Sprintf("must be between 1 and %d characters long", MaxOrgNameLength)},
+			nil,
+		)
+	}
+
+	organization, err := s.orgManager.CreateOrg(ctx, name, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Ctx(ctx).Infof("User %s created organization %s", userID.Hex(), organization.ID.Hex())
+	return &OrgSummary{
+		ID:        organization.ID.Hex(),
+		Name:      organization.Name,
+		Role:      org.RoleAdmin,
+		Members:   len(organization.Members),
+		CreatedAt: organization.CreatedAt,
+	}, nil
+}
+
+// ListOrgs returns the organizations the user is a member of or invited to, oldest first.
+func (s *ClientService) ListOrgs(ctx context.Context, userID primitive.ObjectID) (_ []OrgSummary, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.ListOrgs", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	organizations, err := s.orgManager.ListUserOrgs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]OrgSummary, 0, len(organizations))
+	for _, organization := range organizations {
+		summary := OrgSummary{
+			ID:        organization.ID.Hex(),
+			Name:      organization.Name,
+			Members:   len(organization.Members),
+			CreatedAt: organization.CreatedAt,
+		}
+		if role, ok := organization.MemberRole(userID); ok {
+			summary.Role = role
+		} else {
+			for _, invitation := range organization.Invitations {
+				if invitation.UserID == userID {
+					summary.Role, summary.Invited = invitation.Role, true
+				}
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// GetOrg returns an organization the user is a member of, with its members, and its invitations for admins.
+//
+// Returns user.ErrUserNoAccess if the user is not a member, org.ErrOrgNotFound if it does not exist, or error if an
+// error occurred.
+func (s *ClientService) GetOrg(ctx context.Context, userID, orgID primitive.ObjectID) (_ *OrgDetails, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetOrg", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	organization, err := s.orgManager.GetOrg(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	role, ok := organization.MemberRole(userID)
+	if !ok {
+		return nil, user.ErrUserNoAccess
+	}
+
+	details := &OrgDetails{
+		ID:        organization.ID.Hex(),
+		Name:      organization.Name,
+		CreatedAt: organization.CreatedAt,
+		Role:      role,
+		Members:   make([]OrgMember, 0, len(organization.Members)),
+	}
+	for _, member := range organization.Members {
+		details.Members = append(details.Members, s.orgMember(ctx, member.UserID, member.Role, member.JoinedAt))
+	}
+	if role == org.RoleAdmin {
+		for _, invitation := range organization.Invitations {
+			details.Invitations = append(details.Invitations, s.orgMember(ctx, invitation.UserID, invitation.Role, invitation.InvitedAt))
+		}
+	}
+	return details, nil
+}
+
+// orgMember returns a member of an organization with their username, which is left empty if it cannot be read.
+func (s *ClientService) orgMember(ctx context.Context, userID primitive.ObjectID, role string, since time.Time) OrgMember {
+	member := OrgMember{UserID: userID.Hex(), Role: role, Since: since}
+	if u, err := s.userManager.GetUserByID(ctx, userID); err == nil {
+		member.Username = u.Username
+	} else {
+		s.logger.Ctx(ctx).Debugf("Failed to get username of organization member %s: %v", userID.Hex(), err)
+	}
+	return member
+}
+
+// DeleteOrg deletes an organization the user is an admin of. The scenes of its workspace stay with their owners.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin, org.ErrOrgNotFound if it does not exist, or error if an
+// error occurred.
+func (s *ClientService) DeleteOrg(ctx context.Context, userID, orgID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.DeleteOrg", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	organization, err := s.orgManager.GetOrg(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if role, _ := organization.MemberRole(userID); role != org.RoleAdmin {
+		return user.ErrUserNoAccess
+	}
+
+	// Access is revoked before the scenes leave the workspace, so the scenes to invalidate can still be found
+	memberIDs := make([]primitive.ObjectID, 0, len(organization.Members))
+	for _, member := range organization.Members {
+		memberIDs = append(memberIDs, member.UserID)
+	}
+	if err := s.orgManager.DeleteOrg(ctx, orgID); err != nil {
+		return err
+	}
+	s.invalidateOrgAccess(ctx, orgID, memberIDs...)
+	if err := s.sceneManager.RemoveOrgFromAll(ctx, orgID); err != nil {
+		return err
+	}
+	s.logger.Ctx(ctx).Infof("User %s deleted organization %s", userID.Hex(), orgID.Hex())
+	return nil
+}
+
+// InviteOrgMember invites the user named username to an organization the user is an admin of, in the given role.
+// Inviting a user again changes the role they are invited in.
+//
+// Returns org.ErrInvalidMemberRole if the role is not valid, org.ErrAlreadyMember if the user is a member,
+// user.ErrUserNoAccess if the inviting user is not an admin, or error if an error occurred.
+func (s *ClientService) InviteOrgMember(ctx context.Context, userID, orgID primitive.ObjectID, username, role string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.InviteOrgMember", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	if !org.IsValidMemberRole(role) {
+		return NewValidationError(
+			org.ErrInvalidMemberRole.Error(),
+			map[string]string{"role": "must be one of " + strings.Join([]string{org.RoleAdmin, org.RoleMember, org.RoleViewer}, ", ")},
+			org.ErrInvalidMemberRole,
+		)
+	}
+	if err := s.requireOrgRole(ctx, orgID, userID, org.RoleAdmin); err != nil {
+		return err
+	}
+	target, err := s.userManager.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	err = s.orgManager.SetInvitation(ctx, orgID, org.Invitation{
+		UserID:    target.ID,
+		Role:      role,
+		InvitedBy: userID,
+		InvitedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Ctx(ctx).Infof("User %s invited user %s to organization %s", userID.Hex(), target.ID.Hex(), orgID.Hex())
+	return nil
+}
+
+// AcceptOrgInvitation makes the user a member of an organization they were invited to, returning their role.
+//
+// Returns org.ErrInvitationNotFound if the user is not invited, org.ErrOrgNotFound if the organization does not exist,
+// or error if an error occurred.
+func (s *ClientService) AcceptOrgInvitation(ctx context.Context, userID, orgID primitive.ObjectID) (_ string, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AcceptOrgInvitation", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	role, err := s.orgManager.AcceptInvitation(ctx, orgID, userID)
+	if err != nil {
+		return "", err
+	}
+	s.logger.Ctx(ctx).Infof("User %s joined organization %s as %s", userID.Hex(), orgID.Hex(), role)
+	return role, nil
+}
+
+// RemoveOrgInvitation withdraws the invitation of the target user to an organization. Admins may withdraw any
+// invitation, and invited users decline theirs.
+//
+// Returns org.ErrInvitationNotFound if the target user is not invited, user.ErrUserNoAccess if the user is neither an
+// admin nor the target user, or error if an error occurred.
+func (s *ClientService) RemoveOrgInvitation(ctx context.Context, userID, orgID, targetID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RemoveOrgInvitation", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	if userID != targetID {
+		if err := s.requireOrgRole(ctx, orgID, userID, org.RoleAdmin); err != nil {
+			return err
+		}
+	}
+	return s.orgManager.RemoveInvitation(ctx, orgID, targetID)
+}
+
+// SetOrgMemberRole changes the role of a member of an organization the user is an admin of.
+//
+// Returns org.ErrInvalidMemberRole if the role is not valid, org.ErrMemberNotFound if the target user is not a member,
+// org.ErrLastAdmin if they are the last admin and would stop being one, user.ErrUserNoAccess if the user is not an
+// admin, or error if an error occurred.
+func (s *ClientService) SetOrgMemberRole(ctx context.Context, userID, orgID, targetID primitive.ObjectID, role string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.SetOrgMemberRole", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	if !org.IsValidMemberRole(role) {
+		return NewValidationError(
+			org.ErrInvalidMemberRole.Error(),
+			map[string]string{"role": "must be one of " + strings.Join([]string{org.RoleAdmin, org.RoleMember, org.RoleViewer}, ", ")},
+			org.ErrInvalidMemberRole,
+		)
+	}
+	if err := s.requireOrgRole(ctx, orgID, userID, org.RoleAdmin); err != nil {
+		return err
+	}
+	if err := s.orgManager.SetMemberRole(ctx, orgID, targetID, role); err != nil {
+		return err
+	}
+	s.invalidateOrgAccess(ctx, orgID, targetID)
+	return nil
+}
+
+// RemoveOrgMember removes the target user from an organization. Admins may remove any member, and members leave by
+// removing themselves. The scenes the member uploaded into the workspace stay in it.
+//
+// Returns org.ErrMemberNotFound if the target user is not a member, org.ErrLastAdmin if they are its last admin,
+// user.ErrUserNoAccess if the user is neither an admin nor the target user, or error if an error occurred.
+func (s *ClientService) RemoveOrgMember(ctx context.Context, userID, orgID, targetID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RemoveOrgMember", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	if userID != targetID {
+		if err := s.requireOrgRole(ctx, orgID, userID, org.RoleAdmin); err != nil {
+			return err
+		}
+	}
+	if err := s.orgManager.RemoveMember(ctx, orgID, targetID); err != nil {
+		return err
+	}
+	s.invalidateOrgAccess(ctx, orgID, targetID)
+	s.logger.Ctx(ctx).Infof("User %s removed user %s from organization %s", userID.Hex(), targetID.Hex(), orgID.Hex())
+	return nil
+}
+
+// ListOrgScenes returns a page of the scenes in the workspace of an organization the user is a member of, newest
+// first, summarized as in GetUserHistory.
+//
+// Returns ErrValidation if the stage is unknown, user.ErrUserNoAccess if the user is not a member, or error if an
+// error occurred.
+func (s *ClientService) ListOrgScenes(ctx context.Context, userID, orgID primitive.ObjectID, query HistoryQuery) (_ *HistoryPage, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.ListOrgScenes", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	if err := s.requireOrgRole(ctx, orgID, userID, org.RoleAdmin, org.RoleMember, org.RoleViewer); err != nil {
+		return nil, err
+	}
+	states, err := historyStatesOf(query.Stage)
+	if err != nil {
+		return nil, err
+	}
+	filter := scene.SceneListFilter{OrgID: orgID, States: states, OldestFirst: query.OldestFirst}
+	return s.scenesPage(ctx, filter, query.Page, query.PageSize)
+}
+
+// checkOrgUpload checks if the user may upload into the workspace of an organization, which admins and members may.
+// Uploads into no organization, a nil orgID, are always allowed.
+//
+// Returns user.ErrUserNoAccess if the user may not, org.ErrOrgNotFound if the organization does not exist, or error
+// if an error occurred.
+func (s *ClientService) checkOrgUpload(ctx context.Context, userID, orgID primitive.ObjectID) error {
+	if orgID.IsZero() {
+		return nil
+	}
+	return s.requireOrgRole(ctx, orgID, userID, org.RoleAdmin, org.RoleMember)
+}
+
+// requireOrgRole checks if the user is a member of an organization in one of the given roles.
+//
+// Returns user.ErrUserNoAccess if they are not, org.ErrOrgNotFound if the organization does not exist, or error if an
+// error occurred.
+func (s *ClientService) requireOrgRole(ctx context.Context, orgID, userID primitive.ObjectID, roles ...string) error {
+	role, err := s.orgManager.GetMemberRole(ctx, orgID, userID)
+	if errors.Is(err, org.ErrMemberNotFound) {
+		return user.ErrUserNoAccess
+	}
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(roles, role) {
+		return user.ErrUserNoAccess
+	}
+	return nil
+}
+
+// verifyOrgAccess checks if the given user is a member of the organization whose workspace the given scene is in, in
+// a role that allows the action. The role is only cached if it does, so it does not replace a collaborator role that
+// would.
+//
+// Returns nil if they are, user.ErrUserNoAccess if not, or error if an error occurred.
+func (s *ClientService) verifyOrgAccess(ctx context.Context, userID, sceneID primitive.ObjectID, action string) error {
+	orgID, err := s.sceneManager.GetSceneOrgID(ctx, sceneID)
+	if errors.Is(err, scene.ErrSceneNotFound) || (err == nil && orgID.IsZero()) {
+		return user.ErrUserNoAccess
+	}
+	if err != nil {
+		return err
+	}
+	role, err := s.orgManager.GetMemberRole(ctx, orgID, userID)
+	if errors.Is(err, org.ErrMemberNotFound) || errors.Is(err, org.ErrOrgNotFound) {
+		return user.ErrUserNoAccess
+	}
+	if err != nil {
+		return err
+	}
+	if !orgMemberMayPerform(role, action) {
+		return user.ErrUserNoAccess
+	}
+	s.cacheAccessRole(ctx, sceneID, userID, orgAccessRolePrefix+role)
+	return nil
+}
+
+// invalidateOrgAccess invalidates the cached access roles of the given users on the scenes of an organization's
+// workspace. Failures are logged, as cached roles expire anyway.
+func (s *ClientService) invalidateOrgAccess(ctx context.Context, orgID primitive.ObjectID, userIDs ...primitive.ObjectID) {
+	scenes, _, err := s.sceneManager.ListScenes(ctx, scene.SceneListFilter{OrgID: orgID, Trash: scene.TrashIncluded}, 0, 0)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to list scenes of organization %s to invalidate access: %v", orgID.Hex(), err)
+		return
+	}
+	for _, sc := range scenes {
+		s.sceneCache.InvalidateAccess(ctx, sc.ID, userIDs...)
+	}
+}
@@ -0,0 +1,214 @@
+// This file contains the transactional outbox jobs are published through, so a job is never lost between the database
+// writes it is published for and the message broker.
+//
+// PublishSFMJob and PublishNERFJob do not publish to the broker themselves. They write the job to the outbox in the
+// same transaction as appending the scene to its processing queues and moving it to its running state, so either all
+// of them are committed or none is. The outbox dispatcher then sends due messages to the broker, oldest first, and
+// marks them as sent. A message the broker does not accept is retried with a backoff, for as long as it takes.
+// Delivery is at least once: a message sent just before a crash, but not yet marked as sent, is sent again on restart,
+// so workers must tolerate duplicate jobs.
+//
+// Scenes left queued by a crash after their upload was committed, but before their job was written to the outbox,
+// are submitted again on start, see resumeQueuedJobs. Like admission control, this assumes a single web server.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/outbox"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/queue"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Outbox dispatcher settings
+const (
+	// outboxInterval is how often the outbox is checked for due messages, besides when a job is written to it.
+	outboxInterval = 5 * time.Second
+	// outboxBatchSize is the most messages read from the outbox at once.
+	outboxBatchSize = 100
+	// outboxMaxBackoff is the longest a message waits before it is sent again after failing.
+	outboxMaxBackoff = time.Minute
+	// outboxFlushTimeout is the longest the last pass sending due messages may take when shutting down. Messages not
+	// sent by then stay in the outbox, and are sent after the restart.
+	outboxFlushTimeout = 10 * time.Second
+)
+
+// enqueueJob writes a job to the outbox, appends its scene to the given processing queues, and moves the scene to the
+// given state, in one transaction. The dispatcher is woken to send the job right away.
+//
+// A scene already in the state, i.e one whose job is requeued, keeps it. A scene already in a queue is not added twice.
+func (s *AMPQService) enqueueJob(ctx context.Context, message *outbox.Message, queueNames []string, state scene.State) error {
+	transitioned := false
+	err := s.sceneManager.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.outboxManager.AddMessage(ctx, message); err != nil {
+			return fmt.Errorf("failed to write job to outbox: %v", err)
+		}
+		for _, queueName := range queueNames {
+			err := s.queueManager.AppendToQueue(ctx, queueName, message.SceneID)
+			if err != nil && !errors.Is(err, queue.ErrIDAlreadyInQueue) {
+				return fmt.Errorf("failed to append to %s: %v", queueName, err)
+			}
+		}
+		err := s.sceneManager.TransitionStatus(ctx, message.SceneID, state, "")
+		if err != nil && !errors.Is(err, scene.ErrInvalidStatusTransition) {
+			return err
+		}
+		transitioned = err == nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if transitioned {
+		s.statusEvents.publish(message.SceneID, JobStatusEvent{Type: StatusEventStatus, State: state, Time: time.Now()})
+	}
+	s.NotifyOutbox()
+	return nil
+}
+
+// NotifyOutbox wakes the outbox dispatcher to send due messages.
+func (s *AMPQService) NotifyOutbox() {
+	select {
+	case s.outboxReady <- struct{}{}:
+	default:
+	}
+}
+
+// runOutboxDispatcher sends due outbox messages whenever a job is written or outboxInterval passes, until the service
+// is shut down. Messages left by a restart are sent on the first pass, and messages written while shutting down are
+// flushed by a last pass, bounded by outboxFlushTimeout.
+func (s *AMPQService) runOutboxDispatcher() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	for {
+		s.dispatchOutbox(context.Background())
+		select {
+		case <-s.stopChan:
+			ctx, cancel := context.WithTimeout(context.Background(), outboxFlushTimeout)
+			s.dispatchOutbox(ctx)
+			cancel()
+			return
+		case <-ticker.C:
+		case <-s.outboxReady:
+		}
+	}
+}
+
+// dispatchOutbox sends the due outbox messages, oldest first. A message that fails is retried after a backoff, and
+// ends the pass, as the broker is most likely unreachable.
+func (s *AMPQService) dispatchOutbox(ctx context.Context) {
+	for {
+		messages, err := s.outboxManager.GetDueMessages(ctx, time.Now(), outboxBatchSize)
+		if err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to get due outbox messages: %v", err)
+			return
+		}
+
+		for _, message := range messages {
+			if err := s.sendOutboxMessage(ctx, message); err != nil {
+				backoff := min(time.Second<<min(message.Attempts, 6), outboxMaxBackoff)
+				s.logger.Ctx(ctx).Errorf("Failed to send %s job of scene %s, retrying in %s: %v", message.Queue, message.SceneID.Hex(), backoff, err)
+				if err := s.outboxManager.MarkFailed(ctx, message.ID, err, time.Now().Add(backoff)); err != nil {
+					s.logger.Ctx(ctx).Errorf("Failed to record failed outbox message %s: %v", message.ID.Hex(), err)
+				}
+				return
+			}
+		}
+		if len(messages) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// sendOutboxMessage publishes an outbox message to its queue, and marks it as sent.
+// The message is sent in the trace of the job it carries.
+func (s *AMPQService) sendOutboxMessage(ctx context.Context, message *outbox.Message) (err error) {
+	ctx = tracing.ContextWithTraceparent(ctx, message.Headers[tracing.TraceparentHeader])
+	ctx, span := tracing.Start(ctx, "send "+message.Queue, tracing.KindClient)
+	defer span.EndWithError(&err)
+	span.SetAttribute("messaging.system", s.mq.System())
+	span.SetAttribute("messaging.destination", message.Queue)
+	span.SetAttribute("scene.id", message.SceneID.Hex())
+
+	job := broker.Job{Body: message.Body, Priority: message.Priority, Tier: message.Tier, Headers: message.Headers}
+	publishStart := time.Now()
+	switch message.Queue {
+	case broker.QueueSfmIn:
+		err = s.mq.PublishSfmJob(ctx, job)
+	case broker.QueueNerfIn:
+		err = s.mq.PublishNerfJob(ctx, job)
+	default:
+		err = fmt.Errorf("unknown outbox queue %q", message.Queue)
+	}
+	s.metrics.JobPublishDuration.WithLabelValues(message.Stage).Observe(time.Since(publishStart).Seconds())
+	if err != nil {
+		s.metrics.AMQPPublishFailuresTotal.WithLabelValues(message.Queue).Inc()
+		return err
+	}
+	s.metrics.JobsPublishedTotal.WithLabelValues(message.Stage, message.TrainingMode).Inc()
+
+	if err := s.outboxManager.MarkSent(ctx, message.ID); err != nil {
+		// The job is sent again, which workers tolerate
+		return fmt.Errorf("failed to mark job as sent: %v", err)
+	}
+	return nil
+}
+
+// resumeQueuedJobs submits the sfm jobs of scenes that were left queued by a restart without a job in the outbox, i.e
+// when the server stopped between committing an upload and publishing its job. Scenes queued since the service
+// started are being submitted by their upload, and are left alone.
+func (s *AMPQService) resumeQueuedJobs() {
+	ctx := context.Background()
+	scenes, err := s.sceneManager.GetScenesByState(ctx, scene.StateQueued)
+	if err != nil {
+		s.logger.Errorf("Failed to resume queued jobs: %v", err)
+		return
+	}
+
+	resumed := 0
+	for _, queued := range scenes {
+		if !queued.Status.UpdatedAt.Before(s.startedAt) {
+			continue
+		}
+		pending, err := s.outboxManager.HasPendingMessage(ctx, queued.ID)
+		if err != nil {
+			s.logger.Errorf("Failed to check outbox of queued scene %s: %v", queued.ID.Hex(), err)
+			continue
+		}
+		if pending {
+			continue
+		}
+
+		if err := s.admitSFMJob(ctx, queued.ID); err != nil {
+			s.logger.Errorf("Failed to resume SFM job of queued scene %s, retrying: %v", queued.ID.Hex(), err)
+			s.DeferSFMJob(ctx, queued.ID)
+			continue
+		}
+		resumed++
+	}
+	if resumed > 0 {
+		s.logger.Infof("Resumed %d queued jobs", resumed)
+	}
+}
+
+// collectOutboxPending refreshes the outbox pending messages gauge. It is called whenever metrics are collected.
+func (s *AMPQService) collectOutboxPending() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pending, err := s.outboxManager.CountPending(ctx)
+	if err != nil {
+		s.logger.Warnf("Failed to count pending outbox messages for metrics: %v", err)
+		return
+	}
+	s.metrics.OutboxPendingMessages.Set(float64(pending))
+}
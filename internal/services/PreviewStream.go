@@ -0,0 +1,241 @@
+// This file contains live training previews of scenes, relayed to clients over WebSockets by the web layer.
+//
+// Workers render a low resolution frame every so often while training, and publish it to the broker's preview
+// exchange (broker.ExchangePreview). The body of a message is the encoded image, and its headers are:
+//
+//	{
+//	    "job-id": string (SceneManager.JobID),
+//	    "iteration": string (integer, the training iteration rendered),
+//	    "content-type": string (optional, defaults to "image/jpeg")
+//	}
+//
+// Every server receives every frame, and hands it to the viewers of its scene connected to it. Frames are never
+// stored. A viewer too slow to keep up skips to the latest frame, which is harmless as every frame is a full render.
+
+package services
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Headers of preview frames published by the workers
+const (
+	headerPreviewJobID       = "job-id"
+	headerPreviewIteration   = "iteration"
+	headerPreviewContentType = "content-type"
+)
+
+// previewFrameBuffer is how many frames a viewer may fall behind before older frames are dropped.
+const previewFrameBuffer = 2
+
+// maxPreviewFrameSize is the largest preview frame relayed. Larger frames are dropped, as previews are meant to be
+// low resolution.
+const maxPreviewFrameSize = 2 * 1024 * 1024
+
+// PreviewFrame is a frame rendered by a worker while training a scene.
+type PreviewFrame struct {
+	Iteration   int
+	ContentType string
+	Data        []byte
+	Time        time.Time
+}
+
+// previewBroker holds the viewers of the previews of each scene.
+type previewBroker struct {
+	mu          sync.Mutex
+	subscribers map[primitive.ObjectID]map[chan PreviewFrame]struct{}
+}
+
+// subscribe returns a channel receiving the preview frames of a scene, and a function to stop receiving them.
+func (b *previewBroker) subscribe(sceneID primitive.ObjectID) (<-chan PreviewFrame, func()) {
+	ch := make(chan PreviewFrame, previewFrameBuffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[primitive.ObjectID]map[chan PreviewFrame]struct{})
+	}
+	if b.subscribers[sceneID] == nil {
+		b.subscribers[sceneID] = make(map[chan PreviewFrame]struct{})
+	}
+	b.subscribers[sceneID][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[sceneID], ch)
+		if len(b.subscribers[sceneID]) == 0 {
+			delete(b.subscribers, sceneID)
+		}
+	}
+}
+
+// hasSubscribers checks if anyone views the previews of a scene.
+func (b *previewBroker) hasSubscribers(sceneID primitive.ObjectID) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers[sceneID]) > 0
+}
+
+// publish sends a frame to every viewer of a scene. A viewer whose buffer is full has its oldest frame dropped to
+// make room, so it always receives the latest frame without ever blocking the others.
+func (b *previewBroker) publish(sceneID primitive.ObjectID, frame PreviewFrame) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[sceneID] {
+		for {
+			select {
+			case ch <- frame:
+			default:
+				select {
+				case <-ch:
+				default:
+				}
+				continue
+			}
+			break
+		}
+	}
+}
+
+// SubscribePreviews returns a channel receiving the preview frames of a scene as they are relayed, and a function to
+// stop receiving them.
+func (s *AMPQService) SubscribePreviews(sceneID primitive.ObjectID) (<-chan PreviewFrame, func()) {
+	return s.previews.subscribe(sceneID)
+}
+
+// runPreviewRelay subscribes to the preview exchange, subscribing again whenever the subscription fails, until ctx is
+// done.
+func (s *AMPQService) runPreviewRelay(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		s.logger.Ctx(ctx).Infof("Started relaying previews from %s", broker.ExchangePreview)
+		err := s.mq.SubscribeBroadcast(ctx, broker.ExchangePreview, s.relayPreview)
+		if err == nil || ctx.Err() != nil {
+			s.logger.Ctx(ctx).Info("Stopping preview relay")
+			return
+		}
+
+		s.logger.Ctx(ctx).Errorf("Error in preview relay: %v. Reconnecting in 5 seconds...", err)
+		select {
+		case <-ctx.Done():
+			s.logger.Ctx(ctx).Info("Stopping preview relay")
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// relayPreview hands a preview frame published by a worker to the viewers of its scene connected to this server.
+// Malformed frames and frames of scenes nobody views are dropped.
+func (s *AMPQService) relayPreview(body []byte, headers map[string]string) {
+	sceneID, err := s.sceneManager.ParseJobID(headers[headerPreviewJobID])
+	if err != nil {
+		s.logger.Debugf("Dropping preview frame with invalid job ID %q", headers[headerPreviewJobID])
+		return
+	}
+	if !s.previews.hasSubscribers(sceneID) {
+		return
+	}
+	if len(body) == 0 || len(body) > maxPreviewFrameSize {
+		s.logger.Debugf("Dropping preview frame of scene %s of %d bytes", sceneID.Hex(), len(body))
+		return
+	}
+	iteration, err := strconv.Atoi(headers[headerPreviewIteration])
+	if err != nil {
+		s.logger.Debugf("Dropping preview frame of scene %s with invalid iteration %q", sceneID.Hex(), headers[headerPreviewIteration])
+		return
+	}
+	contentType := headers[headerPreviewContentType]
+	if contentType == "" {
+		contentType = "image/jpeg"
+	}
+
+	s.previews.publish(sceneID, PreviewFrame{
+		Iteration:   iteration,
+		ContentType: contentType,
+		Data:        body,
+		Time:        time.Now(),
+	})
+}
+
+// GetPreviewStream returns a stream of the preview frames rendered while training a scene. The stream is closed once
+// the scene reaches a terminal state or ctx is done. The caller must cancel ctx once it stops reading, and should read
+// promptly, as frames it falls behind on are skipped.
+//
+// Returns ErrSceneNotTraining if the scene already reached a terminal state, or error if the user does not have access
+// to the scene, or the scene has no status.
+func (s *ClientService) GetPreviewStream(ctx context.Context, userID, sceneID primitive.ObjectID) (_ <-chan PreviewFrame, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetPreviewStream", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get preview stream request received")
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadStatus); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+
+	// Subscribing first, so the end of training is not missed between reading the status and subscribing
+	statusEvents, unsubscribeStatus := s.mqService.SubscribeStatus(sceneID)
+	frames, unsubscribeFrames := s.mqService.SubscribePreviews(sceneID)
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil {
+		unsubscribeStatus()
+		unsubscribeFrames()
+		return nil, err
+	}
+	if status.State.IsTerminal() {
+		unsubscribeStatus()
+		unsubscribeFrames()
+		return nil, ErrSceneNotTraining
+	}
+
+	out := make(chan PreviewFrame)
+	go func() {
+		defer close(out)
+		defer unsubscribeStatus()
+		defer unsubscribeFrames()
+
+		ticker := time.NewTicker(statusPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case frame := <-frames:
+				select {
+				case out <- frame:
+				case <-ctx.Done():
+					return
+				}
+			case event := <-statusEvents:
+				if event.Type == StatusEventStatus && event.State.IsTerminal() {
+					return
+				}
+			case <-ticker.C:
+				current, err := s.sceneManager.GetStatus(ctx, sceneID)
+				if err != nil {
+					s.logger.Ctx(ctx).Debugf("Ending preview stream of scene %s: %v", sceneID.Hex(), err)
+					return
+				}
+				if current.State.IsTerminal() {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,185 @@
+// This file contains processing time statistics for completed jobs.
+//
+// Durations are recorded by the AMPQService when a scene completes, and are grouped by training mode and a rough
+// input size bucket so estimates for a new job are based on jobs that looked like it.
+
+package services
+
+import (
+	"context"
+	"math"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// processingStatsSampleLimit is the number of most recently completed scenes considered when computing statistics.
+const processingStatsSampleLimit = 1000
+
+// Declarations for input size buckets, based on the size of the uploaded video
+const (
+	SizeBucketSmall  = "small"
+	SizeBucketMedium = "medium"
+	SizeBucketLarge  = "large"
+)
+
+// Upper bounds (exclusive) of the small and medium input size buckets
+const (
+	smallInputMaxBytes  = 50 << 20
+	mediumInputMaxBytes = 250 << 20
+)
+
+// SizeBucket returns the input size bucket of a video of the given size.
+func SizeBucket(videoBytes int64) string {
+	switch {
+	case videoBytes < smallInputMaxBytes:
+		return SizeBucketSmall
+	case videoBytes < mediumInputMaxBytes:
+		return SizeBucketMedium
+	default:
+		return SizeBucketLarge
+	}
+}
+
+// DurationStats summarizes a set of stage durations, in seconds.
+type DurationStats struct {
+	AvgSeconds float64 `json:"avg_seconds"`
+	P50Seconds float64 `json:"p50_seconds"`
+	P90Seconds float64 `json:"p90_seconds"`
+}
+
+// ProcessingTimeGroup holds the processing time statistics of completed jobs sharing a training mode and size bucket.
+type ProcessingTimeGroup struct {
+	TrainingMode string        `json:"training_mode"`
+	SizeBucket   string        `json:"size_bucket"`
+	Count        int           `json:"count"`
+	Sfm          DurationStats `json:"sfm"`
+	Training     DurationStats `json:"training"`
+}
+
+// ProcessingTimeStats holds processing time statistics per group and across all completed jobs.
+type ProcessingTimeStats struct {
+	Groups  []ProcessingTimeGroup `json:"groups"`
+	Overall ProcessingTimeGroup   `json:"overall"`
+}
+
+// Lookup returns the statistics of the group matching trainingMode and sizeBucket.
+// Returns false if no completed job matches.
+func (st *ProcessingTimeStats) Lookup(trainingMode, sizeBucket string) (ProcessingTimeGroup, bool) {
+	for _, group := range st.Groups {
+		if group.TrainingMode == trainingMode && group.SizeBucket == sizeBucket {
+			return group, true
+		}
+	}
+	return ProcessingTimeGroup{}, false
+}
+
+// Expected returns the expected total processing time of a job in this group, based on average stage durations.
+func (g ProcessingTimeGroup) Expected() time.Duration {
+	seconds := g.Sfm.AvgSeconds + g.Training.AvgSeconds
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// GetProcessingTimeStats returns processing time statistics over recently completed jobs. Only available to admins.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin.
+func (s *ClientService) GetProcessingTimeStats(ctx context.Context, adminUserID primitive.ObjectID) (_ ProcessingTimeStats, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetProcessingTimeStats", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return ProcessingTimeStats{}, err
+	}
+	return s.processingTimeStats(ctx)
+}
+
+// processingTimeStats computes processing time statistics without an access check.
+// Internally used by job estimates, which are available to every user.
+func (s *ClientService) processingTimeStats(ctx context.Context) (ProcessingTimeStats, error) {
+	scenes, err := s.sceneManager.GetRecentlyCompletedScenes(ctx, processingStatsSampleLimit)
+	if err != nil {
+		return ProcessingTimeStats{}, err
+	}
+
+	type groupKey struct{ mode, bucket string }
+	type samples struct{ sfm, training []float64 }
+
+	grouped := make(map[groupKey]*samples)
+	order := make([]groupKey, 0)
+	all := &samples{}
+
+	for _, sc := range scenes {
+		if sc.Status == nil || sc.Config == nil || sc.Config.NerfTrainingConfig == nil {
+			continue
+		}
+
+		var videoBytes int64
+		if sc.Video != nil {
+			videoBytes = sc.Video.FileSize
+		}
+		key := groupKey{sc.Config.NerfTrainingConfig.TrainingMode, SizeBucket(videoBytes)}
+
+		group, ok := grouped[key]
+		if !ok {
+			group = &samples{}
+			grouped[key] = group
+			order = append(order, key)
+		}
+
+		group.sfm = append(group.sfm, sc.Status.SfmSeconds)
+		group.training = append(group.training, sc.Status.TrainingSeconds)
+		all.sfm = append(all.sfm, sc.Status.SfmSeconds)
+		all.training = append(all.training, sc.Status.TrainingSeconds)
+	}
+
+	stats := ProcessingTimeStats{
+		Groups: make([]ProcessingTimeGroup, 0, len(order)),
+		Overall: ProcessingTimeGroup{
+			Count:    len(all.training),
+			Sfm:      summarizeDurations(all.sfm),
+			Training: summarizeDurations(all.training),
+		},
+	}
+	for _, key := range order {
+		group := grouped[key]
+		stats.Groups = append(stats.Groups, ProcessingTimeGroup{
+			TrainingMode: key.mode,
+			SizeBucket:   key.bucket,
+			Count:        len(group.training),
+			Sfm:          summarizeDurations(group.sfm),
+			Training:     summarizeDurations(group.training),
+		})
+	}
+
+	return stats, nil
+}
+
+// summarizeDurations computes the average and percentiles of the given durations in seconds.
+func summarizeDurations(seconds []float64) DurationStats {
+	if len(seconds) == 0 {
+		return DurationStats{}
+	}
+
+	sorted := slices.Clone(seconds)
+	slices.Sort(sorted)
+
+	var total float64
+	for _, v := range sorted {
+		total += v
+	}
+
+	return DurationStats{
+		AvgSeconds: total / float64(len(sorted)),
+		P50Seconds: percentile(sorted, 0.5),
+		P90Seconds: percentile(sorted, 0.9),
+	}
+}
+
+// percentile returns the nearest-rank percentile p (0-1) of sorted, which must be non-empty and sorted ascending.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
@@ -0,0 +1,160 @@
+// This file contains the persistence of progress reported by workers, so clients that (re)connect get the latest
+// progress of a scene right away, instead of waiting for the next report.
+//
+// Workers publish progress reports to the 'progress-out' queue. Reports are kept in memory and written to the scene's
+// status at most once every progressWriteInterval, coalescing the reports of a fast training job into a single write.
+// LatestProgress reads the unwritten report first, so readers never see progress older than the last report.
+// Progress is best effort: malformed reports are dropped rather than requeued, and reports for scenes that are no
+// longer processing are discarded when written. Kept reports are also published to status streams, see StatusStream.go.
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+// progressWriteInterval is how often reported progress is written to the database.
+const progressWriteInterval = 2 * time.Second
+
+// progressTracker holds the progress reports not yet written to the database, by scene.
+type progressTracker struct {
+	mu      sync.Mutex
+	pending map[primitive.ObjectID]*scene.Progress
+}
+
+// processProgress processes a message from the 'progress-out' queue.
+//
+// The expected message format is:
+//
+//	{
+//	    "id": string (SceneManager.JobID),
+//	    "stage": "sfm" | "training",
+//	    "iteration": int, (training only)
+//	    "total_iterations": int, (training only)
+//	    "eta_seconds": float (optional)
+//	}
+func (s *AMPQService) processProgress(msg *broker.Delivery) error {
+	var data struct {
+		SceneID         string  `json:"id"`
+		Stage           string  `json:"stage"`
+		Iteration       int     `json:"iteration"`
+		TotalIterations int     `json:"total_iterations"`
+		ETASeconds      float64 `json:"eta_seconds"`
+	}
+	if err := json.Unmarshal(msg.Body, &data); err != nil {
+		s.logger.Warnf("Dropping malformed progress report: %v", err)
+		return nil
+	}
+	sceneID, err := s.sceneManager.ParseJobID(data.SceneID)
+	if err != nil {
+		s.logger.Warnf("Dropping progress report with invalid ID %q: %v", data.SceneID, err)
+		return nil
+	}
+	if data.Stage != metrics.StageSfm && data.Stage != metrics.StageTraining {
+		s.logger.Warnf("Dropping progress report of scene %s with unknown stage %q", sceneID.Hex(), data.Stage)
+		return nil
+	}
+	if data.Iteration < 0 || data.TotalIterations < 0 || data.ETASeconds < 0 {
+		s.logger.Warnf("Dropping progress report of scene %s with negative values", sceneID.Hex())
+		return nil
+	}
+
+	progress := &scene.Progress{
+		Stage:           data.Stage,
+		Iteration:       data.Iteration,
+		TotalIterations: data.TotalIterations,
+		ETASeconds:      data.ETASeconds,
+		ReportedAt:      time.Now(),
+	}
+	if s.progress.record(sceneID, progress) {
+		s.statusEvents.publish(sceneID, JobStatusEvent{Type: StatusEventProgress, Progress: progress, Time: progress.ReportedAt})
+	}
+	return nil
+}
+
+// record keeps a report to be written. Of the reports of the same stage, the one furthest along is kept, as reports
+// may arrive out of order. Reports whether the report was kept.
+func (t *progressTracker) record(sceneID primitive.ObjectID, progress *scene.Progress) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending == nil {
+		t.pending = make(map[primitive.ObjectID]*scene.Progress)
+	}
+	if current, ok := t.pending[sceneID]; ok && current.Stage == progress.Stage && current.Iteration > progress.Iteration {
+		return false
+	}
+	t.pending[sceneID] = progress
+	return true
+}
+
+// get returns the unwritten report of a scene, or nil if there is none.
+func (t *progressTracker) get(sceneID primitive.ObjectID) *scene.Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pending[sceneID]
+}
+
+// take returns every unwritten report, and forgets them.
+func (t *progressTracker) take() map[primitive.ObjectID]*scene.Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending
+	t.pending = nil
+	return pending
+}
+
+// runProgressWriter writes reported progress every progressWriteInterval, until the service is shut down.
+func (s *AMPQService) runProgressWriter() {
+	ticker := time.NewTicker(progressWriteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			s.writeProgress(context.Background())
+			return
+		case <-ticker.C:
+			s.writeProgress(context.Background())
+		}
+	}
+}
+
+// writeProgress writes every unwritten report to its scene's status.
+func (s *AMPQService) writeProgress(ctx context.Context) {
+	for sceneID, progress := range s.progress.take() {
+		err := s.sceneManager.SetLatestProgress(ctx, sceneID, progress)
+		if errors.Is(err, scene.ErrSceneNotFound) {
+			s.logger.Ctx(ctx).Debugf("Dropped progress of scene %s, which is not processing", sceneID.Hex())
+		} else if err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to write progress of scene %s: %v", sceneID.Hex(), err)
+		}
+	}
+}
+
+// pendingProgress returns the latest progress reported for a scene if it is not yet written to the database, or nil.
+func (s *AMPQService) pendingProgress(sceneID primitive.ObjectID) *scene.Progress {
+	return s.progress.get(sceneID)
+}
+
+// LatestProgress returns the latest progress reported for a scene, including a report not yet written to the
+// database. Returns nil if no progress was reported.
+func (s *AMPQService) LatestProgress(ctx context.Context, sceneID primitive.ObjectID) (*scene.Progress, error) {
+	if progress := s.pendingProgress(sceneID); progress != nil {
+		return progress, nil
+	}
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	return status.Progress, nil
+}
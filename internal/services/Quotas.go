@@ -0,0 +1,228 @@
+// This file contains the per-user quotas of the ClientService.
+//
+// Uploads are refused once a user has the most scenes processing at once, would store more bytes than allowed, or
+// made the most uploads allowed for the day. Output downloads are refused once the user downloaded the most bytes
+// allowed for the day. Days are UTC days, and daily usage is counted in the usage collection, see usage.UsageManager.
+//
+// Quotas are checked before the work they limit, and usage is only counted once it succeeded, so concurrent requests
+// of the same user may go slightly over a limit. A download in flight when the limit is reached is finished. Admins
+// are not limited. Admins may override the quotas of a single user, see AdminSetUserQuota.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/usage"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrConcurrentJobLimit is returned when uploading while the user has the most scenes processing at once.
+	ErrConcurrentJobLimit = errors.New("too many scenes processing, wait for one to finish")
+	// ErrStorageQuotaExceeded is returned when an upload would store more bytes than the user is allowed.
+	ErrStorageQuotaExceeded = errors.New("storage quota exceeded, delete scenes to free space")
+	// ErrDailyUploadLimit is returned when uploading after the user made the most uploads allowed for the day.
+	ErrDailyUploadLimit = errors.New("daily upload limit reached")
+	// ErrDailyDownloadLimit is returned when downloading after the user downloaded the most bytes allowed for the day.
+	ErrDailyDownloadLimit = errors.New("daily download limit reached")
+)
+
+// QuotaConfig configures the per-user quotas. A limit <= 0 does not limit.
+type QuotaConfig struct {
+	// most scenes of a user processing at once
+	MaxConcurrentJobs int64
+	// most bytes stored on behalf of a user, see user.User.StorageUsed
+	MaxStorageBytes int64
+	// most uploads of a user per day
+	MaxUploadsPerDay int64
+	// most output bytes downloaded by a user per day
+	MaxDownloadBytesPerDay int64
+}
+
+// SetQuotas changes the per-user quotas. A zero value does not limit users. Overrides of users are kept.
+func (s *ClientService) SetQuotas(quotas QuotaConfig) {
+	s.quotas.Store(&quotas)
+}
+
+// quotasFor returns the quotas of a user: the server's quotas with the user's overrides applied. Admins are not limited.
+func (s *ClientService) quotasFor(u *user.User) QuotaConfig {
+	if u.IsAdmin() {
+		return QuotaConfig{}
+	}
+	q := *s.quotas.Load()
+	if o := u.QuotaOverrides; o != nil {
+		if o.MaxConcurrentJobs != nil {
+			q.MaxConcurrentJobs = *o.MaxConcurrentJobs
+		}
+		if o.MaxStorageBytes != nil {
+			q.MaxStorageBytes = *o.MaxStorageBytes
+		}
+		if o.MaxUploadsPerDay != nil {
+			q.MaxUploadsPerDay = *o.MaxUploadsPerDay
+		}
+		if o.MaxDownloadBytesPerDay != nil {
+			q.MaxDownloadBytesPerDay = *o.MaxDownloadBytesPerDay
+		}
+	}
+	return q
+}
+
+// QuotaUsage is the use of one quota. Remaining is omitted for quotas without a limit.
+type QuotaUsage struct {
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+	Remaining *int64 `json:"remaining,omitempty"`
+}
+
+// newQuotaUsage returns the use of a quota with the given limit.
+func newQuotaUsage(limit, used int64) QuotaUsage {
+	q := QuotaUsage{Limit: limit, Used: used}
+	if limit > 0 {
+		remaining := max(limit-used, 0)
+		q.Remaining = &remaining
+	}
+	return q
+}
+
+// Quota is the allowance of a user, as returned by GetQuota. Daily quotas start over at ResetsAt.
+type Quota struct {
+	Unlimited          bool       `json:"unlimited"`
+	ConcurrentJobs     QuotaUsage `json:"concurrent_jobs"`
+	StorageBytes       QuotaUsage `json:"storage_bytes"`
+	UploadsToday       QuotaUsage `json:"uploads_today"`
+	DownloadBytesToday QuotaUsage `json:"download_bytes_today"`
+	ResetsAt           time.Time  `json:"resets_at"`
+}
+
+// GetQuota returns the limits of the user with the given ID, and how much of each they used.
+// Admins are reported as unlimited, with zero limits.
+func (s *ClientService) GetQuota(ctx context.Context, userID primitive.ObjectID) (_ *Quota, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetQuota", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Get quota request received")
+
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.quotaOf(ctx, u)
+}
+
+// quotaOf returns the limits of a user, and how much of each they used.
+func (s *ClientService) quotaOf(ctx context.Context, u *user.User) (*Quota, error) {
+	processing, err := s.sceneManager.CountProcessingAmong(ctx, u.SceneIDs)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	today, err := s.usageManager.GetUsage(ctx, u.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	_, resetsAt := usage.Day(now)
+
+	limits := s.quotasFor(u)
+	return &Quota{
+		Unlimited:          u.IsAdmin(),
+		ConcurrentJobs:     newQuotaUsage(limits.MaxConcurrentJobs, processing),
+		StorageBytes:       newQuotaUsage(limits.MaxStorageBytes, u.StorageUsed),
+		UploadsToday:       newQuotaUsage(limits.MaxUploadsPerDay, today.Uploads),
+		DownloadBytesToday: newQuotaUsage(limits.MaxDownloadBytesPerDay, today.DownloadBytes),
+		ResetsAt:           resetsAt,
+	}, nil
+}
+
+// checkUploadQuota checks that the user may upload size more bytes, and start another job.
+//
+// Returns ErrConcurrentJobLimit, ErrStorageQuotaExceeded or ErrDailyUploadLimit if a quota would be exceeded.
+func (s *ClientService) checkUploadQuota(ctx context.Context, userID primitive.ObjectID, size int64) error {
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	q := s.quotasFor(u)
+
+	if q.MaxConcurrentJobs > 0 {
+		processing, err := s.sceneManager.CountProcessingAmong(ctx, u.SceneIDs)
+		if err != nil {
+			return err
+		}
+		if processing >= q.MaxConcurrentJobs {
+			return ErrConcurrentJobLimit
+		}
+	}
+	if q.MaxStorageBytes > 0 && u.StorageUsed+size > q.MaxStorageBytes {
+		return ErrStorageQuotaExceeded
+	}
+	if q.MaxUploadsPerDay > 0 {
+		now := time.Now()
+		today, err := s.usageManager.GetUsage(ctx, userID, now)
+		if err != nil {
+			return err
+		}
+		if today.Uploads >= q.MaxUploadsPerDay {
+			return dailyLimitError(ErrDailyUploadLimit, now)
+		}
+	}
+	return nil
+}
+
+// recordUpload counts a successful upload of the user towards their daily uploads, and records it in the audit log
+// with what was uploaded, i.e "video". Failing to count it does not fail the upload.
+func (s *ClientService) recordUpload(ctx context.Context, userID, sceneID primitive.ObjectID, uploaded string) {
+	s.audit.Record(ctx, userID, sceneID, audit.ActionUpload, audit.OutcomeAllowed, uploaded)
+	if err := s.usageManager.AddUploads(ctx, userID, time.Now(), 1); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to count upload of user %s: %v", userID.Hex(), err)
+	}
+}
+
+// checkDownloadQuota checks that the user has not downloaded the most bytes allowed for the day.
+//
+// Returns ErrDailyDownloadLimit, with the time until the limit resets, if they have.
+func (s *ClientService) checkDownloadQuota(ctx context.Context, userID primitive.ObjectID) error {
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	limit := s.quotasFor(u).MaxDownloadBytesPerDay
+	if limit <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	today, err := s.usageManager.GetUsage(ctx, userID, now)
+	if err != nil {
+		return err
+	}
+	if today.DownloadBytes >= limit {
+		return dailyLimitError(ErrDailyDownloadLimit, now)
+	}
+	return nil
+}
+
+// RecordDownload counts output bytes sent to the user towards their daily downloads. Failing to count them is only
+// logged. Downloads through share links are not counted, as they are not made by a user.
+func (s *ClientService) RecordDownload(ctx context.Context, userID primitive.ObjectID, bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+	if err := s.usageManager.AddDownloadBytes(ctx, userID, time.Now(), bytes); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to count download of user %s: %v", userID.Hex(), err)
+	}
+}
+
+// dailyLimitError returns a quota error for a daily limit, telling clients to retry once the day ends.
+func dailyLimitError(cause error, now time.Time) error {
+	_, resetsAt := usage.Day(now)
+	e := newError(ErrQuotaExceeded, cause.Error(), cause)
+	e.RetryAfter = resetsAt.Sub(now)
+	return e
+}
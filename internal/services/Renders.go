@@ -0,0 +1,485 @@
+// This file contains renders of trained scenes along a camera path, so users can share flythrough videos of a scene
+// without rendering them locally, see scene/Renders.go.
+//
+// A render is requested with RequestRender, which publishes a job to the render worker on the 'render-in' queue and
+// records the render as pending. The worker downloads the latest iteration of the first output type of
+// scene.RenderSources the scene has, renders the camera path, and reports the video on the 'render-out' queue, after
+// which it is saved with the nerf output of the scene and served by GetRenderVideo. Unlike exports, every request is
+// a new render, so the number of renders pending for a scene at once is bounded by maxPendingRenders.
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Camera path limits
+const (
+	// maxRenderFrames is the most frames a render may have, 2 minutes at 30 fps.
+	maxRenderFrames = 3600
+	// maxRenderKeyframes is the most keyframes a camera spline may have.
+	maxRenderKeyframes = 256
+	maxRenderWidth     = 1920
+	maxRenderHeight    = 1080
+	maxRenderFPS       = 60
+	// defaults of camera paths that do not set them
+	defaultRenderFPS    = 30
+	defaultRenderWidth  = 1280
+	defaultRenderHeight = 720
+	// maxPendingRenders is the most renders of a scene that may be pending at once.
+	maxPendingRenders = 4
+)
+
+var (
+	// ErrNothingToRender is returned when a scene has no output that can be rendered.
+	ErrNothingToRender = errors.New("scene has no output that can be rendered")
+	// ErrRenderNotReady is returned when downloading the video of a render that is not done.
+	ErrRenderNotReady = errors.New("render is not done")
+	// ErrTooManyRenders is returned when requesting a render of a scene that has maxPendingRenders pending.
+	ErrTooManyRenders = errors.New("too many pending renders for this scene")
+)
+
+// checkCameraPath checks that a camera path can be rendered, and fills in the defaults of its unset settings.
+//
+// Returns a validation error, wrapping scene.ErrInvalidCameraPath, naming the invalid fields.
+func checkCameraPath(path *scene.CameraPath) error {
+	if path.FPS == 0 {
+		path.FPS = defaultRenderFPS
+	}
+	if path.Width == 0 && path.Height == 0 {
+		path.Width, path.Height = defaultRenderWidth, defaultRenderHeight
+	}
+
+	fields := make(map[string]string)
+	if path.FPS < 1 || path.FPS > maxRenderFPS {
+		fields["fps"] = fmt.Sprintf("must be between 1 and %d", maxRenderFPS)
+	}
+	if path.Width < 1 || path.Width > maxRenderWidth {
+		fields["width"] = fmt.Sprintf("must be between 1 and %d", maxRenderWidth)
+	}
+	if path.Height < 1 || path.Height > maxRenderHeight {
+		fields["height"] = fmt.Sprintf("must be between 1 and %d", maxRenderHeight)
+	}
+
+	switch {
+	case len(path.Poses) > 0 && path.Spline != nil:
+		fields["poses"] = "cannot be set along with spline"
+	case len(path.Poses) == 0 && path.Spline == nil:
+		fields["poses"] = "either poses or spline is required"
+	case path.Spline != nil:
+		spline := path.Spline
+		if spline.Interpolation == "" {
+			spline.Interpolation = scene.InterpolationCatmullRom
+		}
+		if spline.Interpolation != scene.InterpolationLinear && spline.Interpolation != scene.InterpolationCatmullRom {
+			fields["spline.interpolation"] = "must be one of " + strings.Join([]string{scene.InterpolationLinear, scene.InterpolationCatmullRom}, ", ")
+		}
+		if len(spline.Keyframes) < 2 || len(spline.Keyframes) > maxRenderKeyframes {
+			fields["spline.keyframes"] = fmt.Sprintf("must have between 2 and %d keyframes", maxRenderKeyframes)
+		} else if badPose := invalidPose(spline.Keyframes); badPose >= 0 {
+			fields["spline.keyframes"] = fmt.Sprintf("keyframe %d must have a finite position, a non zero rotation, and a fov below 180", badPose)
+		}
+		if !(spline.Duration > 0) {
+			fields["spline.duration"] = "must be positive"
+		}
+	default:
+		if badPose := invalidPose(path.Poses); badPose >= 0 {
+			fields["poses"] = fmt.Sprintf("pose %d must have a finite position, a non zero rotation, and a fov below 180", badPose)
+		}
+	}
+	if len(fields) == 0 && path.FrameCount() > maxRenderFrames {
+		fields["poses"] = fmt.Sprintf("the path must have at most %d frames", maxRenderFrames)
+	}
+
+	if len(fields) > 0 {
+		return NewValidationError(scene.ErrInvalidCameraPath.Error(), fields, scene.ErrInvalidCameraPath)
+	}
+	return nil
+}
+
+// invalidPose returns the index of the first pose that cannot be rendered, or -1 if every pose can.
+func invalidPose(poses []scene.CameraPose) int {
+	for i, pose := range poses {
+		norm := 0.0
+		for _, v := range pose.Rotation {
+			norm += v * v
+		}
+		valid := norm > 0 && !math.IsInf(norm, 0) && !math.IsNaN(norm) && pose.FOV >= 0 && pose.FOV < 180
+		for _, v := range pose.Position {
+			valid = valid && !math.IsInf(v, 0) && !math.IsNaN(v)
+		}
+		if !valid {
+			return i
+		}
+	}
+	return -1
+}
+
+// RequestRender renders a trained scene along a camera path, from the given iteration of the first output type of
+// scene.RenderSources the scene has, or its latest iteration if iteration is 0. The render is done by the render
+// worker, and its video is served by GetRenderVideo once done.
+//
+// Returns the render, which is pending until the worker reports the video. Returns a validation error if the path
+// cannot be rendered, see checkCameraPath, scene.ErrInvalidOpOnProcessingScene if the scene is still processing,
+// ErrNothingToRender if it has no output to render from, or ErrTooManyRenders if it has maxPendingRenders pending.
+func (s *ClientService) RequestRender(ctx context.Context, userID, sceneID primitive.ObjectID, path scene.CameraPath, iteration int) (_ *scene.Render, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RequestRender", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Request render request received")
+
+	if err := checkCameraPath(&path); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionRender); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+
+	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	if currentScene.Status != nil && !currentScene.Status.State.IsTerminal() {
+		return nil, scene.ErrInvalidOpOnProcessingScene
+	}
+	if currentScene.Nerf == nil {
+		return nil, ErrNothingToRender
+	}
+	pending := 0
+	for _, render := range currentScene.Nerf.Renders {
+		if render.State == scene.RenderStatePending {
+			pending++
+		}
+	}
+	if pending >= maxPendingRenders {
+		return nil, ErrTooManyRenders
+	}
+
+	// Render from the first source output type with the iteration
+	sourceType := ""
+	for _, outputType := range scene.RenderSources {
+		completed, err := currentScene.Nerf.CompletedIterations(outputType)
+		if err != nil {
+			return nil, err
+		}
+		if len(completed) == 0 {
+			continue
+		}
+		if iteration == 0 {
+			sourceType, iteration = outputType, completed[len(completed)-1]
+			break
+		}
+		if slices.Contains(completed, iteration) {
+			sourceType = outputType
+			break
+		}
+	}
+	if sourceType == "" {
+		if iteration != 0 {
+			return nil, fmt.Errorf("%w at iteration %d", ErrNothingToRender, iteration)
+		}
+		return nil, ErrNothingToRender
+	}
+
+	sourcePath, err := currentScene.Nerf.GetFilePathForTypeAndIter(sourceType, iteration)
+	if err != nil {
+		return nil, err
+	}
+	// The worker downloads the source from this server, so it must be on disk
+	if err := s.restoreOutputs(ctx, sceneID, currentScene.Nerf, sourceType); err != nil {
+		return nil, err
+	}
+
+	render := &scene.Render{
+		ID:          primitive.NewObjectID(),
+		State:       scene.RenderStatePending,
+		SourceType:  sourceType,
+		Iteration:   iteration,
+		Path:        path,
+		RequestedAt: time.Now(),
+	}
+	if err := s.sceneManager.SetRender(ctx, sceneID, render); err != nil {
+		return nil, err
+	}
+	if err := s.mqService.PublishRenderJob(ctx, sceneID, render, sourcePath); err != nil {
+		render.State = scene.RenderStateFailed
+		render.Error = err.Error()
+		render.CompletedAt = time.Now()
+		if err := s.sceneManager.SetRender(ctx, sceneID, render); err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to mark render of scene %s as failed: %v", sceneID.Hex(), err)
+		}
+		return nil, err
+	}
+
+	s.logger.Ctx(ctx).Infof("Rendering %d frames of %s iteration %d of scene %s", path.FrameCount(), sourceType, iteration, sceneID.Hex())
+	return render, nil
+}
+
+// ListRenders returns the renders of the current training run of a scene, most recently requested first.
+//
+// Returns error if the user does not have access to the scene or an error occurred.
+func (s *ClientService) ListRenders(ctx context.Context, userID, sceneID primitive.ObjectID) (_ []*scene.Render, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.ListRenders", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("List renders request received")
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadStatus); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+
+	nerf, err := s.sceneManager.GetNerf(ctx, sceneID)
+	if errors.Is(err, scene.ErrNerfNotFound) {
+		return make([]*scene.Render, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	renders := make([]*scene.Render, 0, len(nerf.Renders))
+	for _, render := range nerf.Renders {
+		renders = append(renders, render)
+	}
+	slices.SortFunc(renders, func(a, b *scene.Render) int {
+		return b.RequestedAt.Compare(a.RequestedAt)
+	})
+	return renders, nil
+}
+
+// GetRender returns a render of a scene.
+//
+// Returns scene.ErrRenderNotFound if the scene has no such render, or error if the user does not have access to the
+// scene or an error occurred.
+func (s *ClientService) GetRender(ctx context.Context, userID, sceneID, renderID primitive.ObjectID) (_ *scene.Render, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetRender", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get render request received")
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadStatus); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+	return s.sceneManager.GetRender(ctx, sceneID, renderID)
+}
+
+// GetRenderVideo returns the video of a done render of a scene. The bytes sent should be counted with RecordDownload.
+//
+// Returns ErrRenderNotReady if the render is not done, scene.ErrRenderNotFound if the scene has no such render,
+// ErrDailyDownloadLimit if the user downloaded the most bytes allowed for the day, or error if the user does not have
+// access to the scene or an error occurred.
+func (s *ClientService) GetRenderVideo(ctx context.Context, userID, sceneID, renderID primitive.ObjectID) (_ *SceneOutput, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetRenderVideo", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get render video request received")
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionDownload); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+	if err := s.checkDownloadQuota(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	render, err := s.sceneManager.GetRender(ctx, sceneID, renderID)
+	if err != nil {
+		return nil, err
+	}
+	if render.State != scene.RenderStateDone || render.FilePath == "" {
+		return nil, ErrRenderNotReady
+	}
+	if _, err := os.Stat(render.FilePath); errors.Is(err, os.ErrNotExist) && s.store != nil {
+		if err := s.restoreFile(ctx, sceneID, render.FilePath); err != nil {
+			return nil, fmt.Errorf("failed to restore %s from object storage: %w", render.FilePath, err)
+		}
+	}
+
+	sceneName, err := s.sceneManager.GetSceneName(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	return &SceneOutput{
+		Path:      render.FilePath,
+		Iteration: render.Iteration,
+		Final:     true,
+		FileName:  outputFileName(sceneName, sceneID, "render", render.Iteration, "mp4"),
+		Viewable:  true,
+	}, nil
+}
+
+// PublishRenderJob publishes the job rendering a camera path of a scene from the source output of a render, to the
+// 'render-in' queue. As with export jobs, render jobs are published directly rather than through the outbox.
+//
+// Returns an error if the job could not be published.
+func (s *AMPQService) PublishRenderJob(ctx context.Context, sceneID primitive.ObjectID, render *scene.Render, sourcePath string) (err error) {
+	ctx, span := tracing.Start(ctx, "publish render-in", tracing.KindProducer)
+	defer span.EndWithError(&err)
+	span.SetAttribute("messaging.system", s.mq.System())
+	span.SetAttribute("messaging.destination", broker.QueueRenderIn)
+	span.SetAttribute("scene.id", sceneID.Hex())
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex(), log.FieldJobID, s.sceneManager.JobID(sceneID))
+
+	jobMap := map[string]interface{}{
+		"id":          s.sceneManager.JobID(sceneID),
+		"render_id":   render.ID.Hex(),
+		"source_type": render.SourceType,
+		"iteration":   render.Iteration,
+		"file_path":   s.toAPIUrl(sourcePath),
+		"camera_path": render.Path,
+	}
+	headers := traceJob(span, jobMap)
+
+	jobJson, err := json.Marshal(jobMap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal render job: %v", err)
+	}
+	if err := s.mq.PublishRenderJob(ctx, broker.Job{Body: jobJson, Headers: headers}); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to publish render job: %v", err)
+		return fmt.Errorf("failed to publish render job: %v", err)
+	}
+	return nil
+}
+
+// processRenderJob processes a message from the 'render-out' queue, saving the video of a render, or recording why
+// rendering failed.
+//
+// Output for a render that is no longer pending, i.e because the scene was trained again since, is dropped.
+// The expected message format is:
+//
+//	{
+//	    "id": string (SceneManager.JobID),
+//	    "render_id": string,
+//	    "file_path": string (url, empty if rendering failed),
+//	    "error": string (optional, why rendering failed)
+//	}
+func (s *AMPQService) processRenderJob(d *broker.Delivery) (err error) {
+	var data struct {
+		SceneID     string `json:"id"`
+		RenderID    string `json:"render_id"`
+		FilePath    string `json:"file_path"`
+		Error       string `json:"error"`
+		Traceparent string `json:"traceparent"`
+	}
+	if err := json.Unmarshal(d.Body, &data); err != nil {
+		return fmt.Errorf("failed to unmarshal render worker data: %w", err)
+	}
+	sceneID, err := s.sceneManager.ParseJobID(data.SceneID)
+	if err != nil {
+		return fmt.Errorf("invalid ID format: %v", err)
+	}
+	renderID, err := primitive.ObjectIDFromHex(data.RenderID)
+	if err != nil {
+		return fmt.Errorf("invalid render ID format: %v", err)
+	}
+
+	ctx, span := tracing.Start(consumeContext(d, data.Traceparent), "process render-out", tracing.KindConsumer)
+	defer span.EndWithError(&err)
+	span.SetAttribute("messaging.system", s.mq.System())
+	span.SetAttribute("messaging.source", broker.QueueRenderOut)
+	span.SetAttribute("scene.id", sceneID.Hex())
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex(), log.FieldJobID, data.SceneID, log.FieldQueue, broker.QueueRenderOut)
+
+	if s.isAbandoned(ctx, sceneID) {
+		s.logger.Ctx(ctx).Infof("Dropping render output for deleted or cancelled scene %s", sceneID.Hex())
+		return nil
+	}
+
+	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return fmt.Errorf("failed to get scene: %v", err)
+	}
+	if currentScene.Nerf == nil {
+		s.logger.Ctx(ctx).Infof("Dropping render output for scene %s without nerf output", sceneID.Hex())
+		return nil
+	}
+	render := currentScene.Nerf.Renders[renderID.Hex()]
+	if render == nil || render.State != scene.RenderStatePending {
+		s.logger.Ctx(ctx).Infof("Dropping stale render output %s for scene %s", data.RenderID, sceneID.Hex())
+		return nil
+	}
+
+	render.CompletedAt = time.Now()
+	if data.Error != "" || data.FilePath == "" {
+		render.State = scene.RenderStateFailed
+		render.Error = data.Error
+		if render.Error == "" {
+			render.Error = "render worker returned no video"
+		}
+		s.logger.Ctx(ctx).Warnf("Render %s of scene %s failed: %s", data.RenderID, sceneID.Hex(), render.Error)
+		return s.sceneManager.SetRender(ctx, sceneID, render)
+	}
+
+	filePath := s.sceneManager.RenderPath(sceneID, currentScene.CurrentVersion(), renderID)
+	_, written, err := downloadFile(filePath, data.FilePath)
+	if err != nil {
+		return err
+	}
+	s.metrics.OutputBytesTotal.WithLabelValues("render").Add(float64(written))
+	render.State = scene.RenderStateDone
+	render.FilePath = filePath
+	render.Size = written
+	if err := s.sceneManager.SetRender(ctx, sceneID, render); err != nil {
+		return fmt.Errorf("failed to set render: %v", err)
+	}
+	s.addStorageUsage(ctx, sceneID, written)
+	if s.store != nil {
+		if err := storeFile(ctx, s.store, s.sceneManager, sceneID, filePath); err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to store render %s of scene %s: %v", filePath, sceneID.Hex(), err)
+		}
+	}
+
+	s.logger.Ctx(ctx).Infof("Rendered %s of scene %s", data.RenderID, sceneID.Hex())
+	return nil
+}
+
+// failDeadLetteredRender fails the render a dead-lettered 'render-out' message was for, if it is still pending.
+func (s *AMPQService) failDeadLetteredRender(ctx context.Context, sceneID primitive.ObjectID, body []byte, reason string) error {
+	var data struct {
+		RenderID string `json:"render_id"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		s.logger.Ctx(ctx).Warnf("Dropping malformed dead-lettered render message of scene %s", sceneID.Hex())
+		return nil
+	}
+	renderID, err := primitive.ObjectIDFromHex(data.RenderID)
+	if err != nil {
+		s.logger.Ctx(ctx).Warnf("Dropping dead-lettered render message of scene %s without a valid render ID", sceneID.Hex())
+		return nil
+	}
+	render, err := s.sceneManager.GetRender(ctx, sceneID, renderID)
+	if errors.Is(err, scene.ErrRenderNotFound) || errors.Is(err, scene.ErrNerfNotFound) || errors.Is(err, scene.ErrSceneNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get render: %v", err)
+	}
+	if render.State != scene.RenderStatePending {
+		return nil
+	}
+	render.State = scene.RenderStateFailed
+	render.Error = reason
+	render.CompletedAt = time.Now()
+	return s.sceneManager.SetRender(ctx, sceneID, render)
+}
@@ -0,0 +1,249 @@
+// This file contains download manifests, which describe how to fetch an output file in parallel chunks.
+//
+// A manifest lists the byte range and CRC-32C of every chunk, so clients can fetch chunks concurrently, verify each
+// one, and re-fetch only chunks that failed or arrived corrupted. The manifest carries the ETag the file is served
+// with, which clients send as If-Range when resuming so a changed file is never mixed with chunks of the old one.
+//
+// Checksumming reads the whole file, so checksums are cached in a sidecar file next to the output, keyed by the
+// file's version and the chunk size. Manifests are only given for final outputs, which are never written to again.
+//
+// Final outputs are served with a strong ETag derived from the SHA-256 of their content, see ContentETag, which is
+// cached in the same sidecar. Unlike an ETag derived from the file's size and modification time, it survives the file
+// being copied or restored from a backup, so clients keep their cached copy of a multi-hundred-MB splat.
+
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// ManifestChecksumAlgorithm is the checksum of each chunk in a ResourceManifest.
+const ManifestChecksumAlgorithm = "crc32c"
+
+// manifestSidecarSuffix is appended to the path of an output file to get the path of its checksum sidecar.
+const manifestSidecarSuffix = ".chunks.json"
+
+// maxSidecarChunkSizes is the most chunk sizes a sidecar caches checksums for. Checksums for further chunk sizes are
+// still computed, but not cached, so a client cycling through chunk sizes cannot grow the sidecar without bound.
+const maxSidecarChunkSizes = 8
+
+// crc32cTable is the CRC-32C (Castagnoli) table, which is hardware accelerated on most platforms.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ManifestChunk is a single chunk of a ResourceManifest, fetched with the chunk query parameter or a Range request.
+type ManifestChunk struct {
+	Index int `json:"index"`
+	// inclusive byte range of the chunk
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// ResourceManifest describes how to download an output file of a scene in chunks, see GetResourceManifest.
+type ResourceManifest struct {
+	SceneID    string `json:"scene_id"`
+	OutputType string `json:"output_type"`
+	Iteration  int    `json:"iteration"`
+	Size       int64  `json:"size"`
+	ChunkSize  int64  `json:"chunk_size"`
+	// ETag the file is served with, to send as If-Range when resuming
+	ETag              string          `json:"etag"`
+	ChecksumAlgorithm string          `json:"checksum_algorithm"`
+	Chunks            []ManifestChunk `json:"chunks"`
+}
+
+// manifestSidecar is the checksum cache stored next to an output file.
+type manifestSidecar struct {
+	// version of the file the checksums were computed for, see fileVersion
+	Version string `json:"version"`
+	// hex checksums of each chunk, by chunk size
+	Checksums map[int64][]string `json:"checksums"`
+	// hex SHA-256 of the whole file, see ContentETag
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// GetResourceManifest returns a manifest describing how to download an output file of a scene in chunks of chunkSize.
+// If iteration is empty, the manifest is for the latest completed iteration, which the manifest names so clients can
+// request it explicitly. chunkSize is resolved with ResolveChunkSize.
+//
+// Returns ErrResourceNotFinal if the output is still being written, or (nil, error) if the user does not have access
+// to the scene or an error occurred.
+func (s *ClientService) GetResourceManifest(ctx context.Context, userID, sceneID primitive.ObjectID, resourceType, iteration string, chunkSize int64) (_ *ResourceManifest, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetResourceManifest", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex(), log.FieldResourceType, resourceType)
+
+	output, err := s.GetSceneOutput(ctx, userID, sceneID, resourceType, iteration, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	if !output.Final {
+		return nil, ErrResourceNotFinal
+	}
+
+	chunkSize = s.ResolveChunkSize(chunkSize)
+	stat, err := os.Stat(output.Path)
+	if err != nil {
+		return nil, err
+	}
+	checksums, err := s.chunkChecksums(output.Path, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	etag, err := s.ContentETag(output.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, _ := ChunkLayout(stat.Size(), chunkSize)
+	if len(checksums) != chunks {
+		return nil, ErrResourceChanged
+	}
+	manifest := &ResourceManifest{
+		SceneID:           sceneID.Hex(),
+		OutputType:        resourceType,
+		Iteration:         output.Iteration,
+		Size:              stat.Size(),
+		ChunkSize:         chunkSize,
+		ETag:              etag,
+		ChecksumAlgorithm: ManifestChecksumAlgorithm,
+		Chunks:            make([]ManifestChunk, chunks),
+	}
+	for i := range manifest.Chunks {
+		start, end, err := ChunkRange(stat.Size(), chunkSize, i)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Chunks[i] = ManifestChunk{Index: i, Start: start, End: end, Size: end - start + 1, Checksum: checksums[i]}
+	}
+	return manifest, nil
+}
+
+// chunkChecksums returns the checksum of each chunk of the file at path, reading them from its sidecar if they were
+// computed for the file's current version, and computing and caching them otherwise.
+func (s *ClientService) chunkChecksums(path string, chunkSize int64) ([]string, error) {
+	// Serialize checksumming per file, so concurrent manifest requests read the file once
+	lock, _ := s.manifestLocks.LoadOrStore(path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	version, err := fileVersion(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sidecarPath := path + manifestSidecarSuffix
+	sidecar := readSidecar(sidecarPath, version)
+	if checksums, ok := sidecar.Checksums[chunkSize]; ok {
+		return checksums, nil
+	}
+
+	checksums, err := computeChunkChecksums(path, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sidecar.Checksums) < maxSidecarChunkSizes {
+		sidecar.Checksums[chunkSize] = checksums
+		if err := writeSidecar(sidecarPath, sidecar); err != nil {
+			s.logger.Errorf("Failed to cache chunk checksums of %s: %v", path, err)
+		}
+	}
+	return checksums, nil
+}
+
+// ContentETag returns the strong ETag of the file at path, the quoted hex SHA-256 of its content. The hash is read from
+// the file's sidecar if it was computed for the file's current version, and computed and cached otherwise.
+//
+// Hashing reads the whole file, so it should only be used for final outputs, which are never written to again.
+func (s *ClientService) ContentETag(path string) (string, error) {
+	lock, _ := s.manifestLocks.LoadOrStore(path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	version, err := fileVersion(path)
+	if err != nil {
+		return "", err
+	}
+
+	sidecarPath := path + manifestSidecarSuffix
+	sidecar := readSidecar(sidecarPath, version)
+	if sidecar.SHA256 == "" {
+		sidecar.SHA256, err = fileChecksum(path)
+		if err != nil {
+			return "", err
+		}
+		if err := writeSidecar(sidecarPath, sidecar); err != nil {
+			s.logger.Errorf("Failed to cache content hash of %s: %v", path, err)
+		}
+	}
+	return fmt.Sprintf(`"%s"`, sidecar.SHA256), nil
+}
+
+// readSidecar reads the sidecar at path, or returns an empty one if it is missing, unreadable, or was written for
+// another version of the file. The caller must hold the file's manifest lock.
+func readSidecar(path, version string) *manifestSidecar {
+	if data, err := os.ReadFile(path); err == nil {
+		var cached manifestSidecar
+		if err := json.Unmarshal(data, &cached); err == nil && cached.Version == version && cached.Checksums != nil {
+			return &cached
+		}
+	}
+	return &manifestSidecar{Version: version, Checksums: make(map[int64][]string)}
+}
+
+// computeChunkChecksums reads the file at path once, and returns the hex CRC-32C of each chunk of chunkSize bytes.
+func computeChunkChecksums(path string, chunkSize int64) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	checksums := make([]string, 0)
+	for {
+		hasher := crc32.New(crc32cTable)
+		n, err := io.CopyN(hasher, file, chunkSize)
+		if n > 0 {
+			checksums = append(checksums, hex.EncodeToString(hasher.Sum(nil)))
+		}
+		if errors.Is(err, io.EOF) {
+			return checksums, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// writeSidecar writes a sidecar through a temporary file, so a concurrent reader never sees a partial sidecar.
+func writeSidecar(path string, sidecar *manifestSidecar) error {
+	data, err := json.Marshal(sidecar)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+// This file contains signed resource URLs, which give time limited access to a single output file without a session.
+//
+// A signed URL names the user it was issued to, the scene, the output type, a concrete iteration, and the version of
+// the file when the URL was issued. All of these and the expiry are covered by an HMAC-SHA256 signature, so none of
+// them can be changed without invalidating the URL. As the version pins the file contents, the response to a signed
+// URL never changes, and browsers and CDNs may cache it as immutable until the URL expires.
+
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrInvalidResourceSignature is returned when a signed resource URL was not issued by this server, or was altered.
+	ErrInvalidResourceSignature = errors.New("invalid resource URL signature")
+	// ErrResourceURLExpired is returned when a signed resource URL is used after its expiry.
+	ErrResourceURLExpired = errors.New("resource URL expired")
+	// ErrResourceChanged is returned when the file of a signed resource URL changed since the URL was issued.
+	ErrResourceChanged = errors.New("resource changed since the URL was issued, request a new URL")
+	// ErrResourceNotFinal is returned when requesting a signed URL for an output that is still being written.
+	ErrResourceNotFinal = errors.New("resource is still being written")
+)
+
+// Signed resource URL lifetimes
+const (
+	// DefaultResourceURLTTL is used when no lifetime is requested.
+	DefaultResourceURLTTL = time.Hour
+	// MaxResourceURLTTL is the longest lifetime a signed resource URL may have.
+	MaxResourceURLTTL = 7 * 24 * time.Hour
+)
+
+// ResourceURLPrefix is the path under which signed resources are served.
+const ResourceURLPrefix = "/resource"
+
+// SignedResource is the resource a signed URL gives access to.
+type SignedResource struct {
+	// user the URL was issued to, whose access to the scene is checked when the URL is used
+	UserID     primitive.ObjectID
+	SceneID    primitive.ObjectID
+	OutputType string
+	Iteration  int
+	// version of the file when the URL was issued, see fileVersion
+	Version string
+	Expires time.Time
+}
+
+// Path returns the URL path of the resource, without the query holding the user, version, expiry, and signature.
+func (r *SignedResource) Path() string {
+	return fmt.Sprintf("%s/%s/%s/%d", ResourceURLPrefix, r.SceneID.Hex(), url.PathEscape(r.OutputType), r.Iteration)
+}
+
+// signature returns the hex HMAC-SHA256 of every field of the resource.
+func (r *SignedResource) signature(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "v1\n%s\n%s\n%s\n%d\n%s\n%d",
+		r.UserID.Hex(), r.SceneID.Hex(), r.OutputType, r.Iteration, r.Version, r.Expires.Unix())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ResourceURL is a signed resource URL, as returned by GenerateResourceURL.
+type ResourceURL struct {
+	// path and query of the URL relative to the server, or an absolute URL of the object store, see storedOutputURL
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// fileVersion identifies the contents of a file by its size and modification time.
+func fileVersion(path string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x-%x", stat.Size(), stat.ModTime().UnixNano()), nil
+}
+
+// GenerateResourceURL returns a signed URL that gives access to an output file of a scene for ttl, without a session.
+// If iteration is empty, the URL is for the latest completed iteration. A ttl <= 0 uses DefaultResourceURLTTL.
+//
+// The expiry is rounded up to the minute, so URLs issued close together are identical and share CDN cache entries.
+// Outputs mirrored to an object store that can sign URLs are given a URL of the store instead.
+// Returns ErrResourceNotFinal if the output is still being written, or ErrValidation if ttl exceeds MaxResourceURLTTL.
+func (s *ClientService) GenerateResourceURL(ctx context.Context, userID, sceneID primitive.ObjectID, resourceType, iteration string, ttl time.Duration) (_ *ResourceURL, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GenerateResourceURL", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex(), log.FieldResourceType, resourceType)
+
+	if len(s.resourceURLKey) == 0 {
+		return nil, errors.New("resource URL signing key not configured")
+	}
+	if ttl <= 0 {
+		ttl = DefaultResourceURLTTL
+	}
+	if ttl > MaxResourceURLTTL {
+		return nil, NewValidationError(
+			fmt.Sprintf("URL lifetime may be at most %s", MaxResourceURLTTL),
+			map[string]string{"ttl": fmt.Sprintf("must be at most %d seconds", int(MaxResourceURLTTL.Seconds()))},
+			nil,
+		)
+	}
+
+	output, err := s.GetSceneOutput(ctx, userID, sceneID, resourceType, iteration, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	if !output.Final {
+		return nil, ErrResourceNotFinal
+	}
+	version, err := fileVersion(output.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := time.Now().Add(ttl).Add(time.Minute - 1).Truncate(time.Minute)
+	storedURL, err := s.storedOutputURL(ctx, sceneID, output.Path, time.Until(expires))
+	if err != nil {
+		return nil, err
+	}
+	if storedURL != "" {
+		return &ResourceURL{URL: storedURL, ExpiresAt: expires}, nil
+	}
+
+	resource := &SignedResource{
+		UserID:     userID,
+		SceneID:    sceneID,
+		OutputType: resourceType,
+		Iteration:  output.Iteration,
+		Version:    version,
+		Expires:    expires,
+	}
+	query := url.Values{
+		"uid": {userID.Hex()},
+		"v":   {version},
+		"exp": {strconv.FormatInt(resource.Expires.Unix(), 10)},
+		"sig": {resource.signature(s.resourceURLKey)},
+	}
+	return &ResourceURL{URL: resource.Path() + "?" + query.Encode(), ExpiresAt: resource.Expires}, nil
+}
+
+// ResolveSignedResource validates the signature and expiry of a signed resource URL, and returns the output file it
+// gives access to. The user the URL was issued to must still have access to the scene.
+//
+// Returns ErrInvalidResourceSignature if the signature does not match, ErrResourceURLExpired if the URL expired, or
+// ErrResourceChanged if the file no longer has the version the URL was issued for.
+func (s *ClientService) ResolveSignedResource(ctx context.Context, resource *SignedResource, signature string) (_ *SceneOutput, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.ResolveSignedResource", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	if len(s.resourceURLKey) == 0 || !hmac.Equal([]byte(signature), []byte(resource.signature(s.resourceURLKey))) {
+		return nil, ErrInvalidResourceSignature
+	}
+	if time.Now().After(resource.Expires) {
+		return nil, ErrResourceURLExpired
+	}
+
+	output, err := s.GetSceneOutput(ctx, resource.UserID, resource.SceneID, resource.OutputType, strconv.Itoa(resource.Iteration), "", 0)
+	if err != nil {
+		return nil, err
+	}
+	version, err := fileVersion(output.Path)
+	if err != nil {
+		return nil, err
+	}
+	if version != resource.Version {
+		return nil, ErrResourceChanged
+	}
+	return output, nil
+}
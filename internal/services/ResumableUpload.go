@@ -0,0 +1,552 @@
+// This file contains resumable video uploads, for videos too large to send reliably in a single request.
+//
+// An upload is started with its size and the training config of its scene, and the video is then appended in chunks,
+// each at the offset the upload has reached. A chunk lost to a dropped connection is sent again from the offset
+// reported by GetUpload. Chunks are staged in uploadStagingDir, outside of any scene's directory, so partial uploads
+// are never mistaken for scene files. Once every byte is received, CompleteUpload moves the video into the layout of
+// a new scene, which is then validated and created like a single request upload, and only then is its sfm job
+// published.
+//
+// Uploads expire after the TTL set with SetUploadSessionTTL (DefaultUploadSessionTTL unless set) without receiving a
+// chunk, and expired uploads are removed periodically.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/upload"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/worker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrUploadOffsetMismatch is returned when a chunk is not sent at the offset the upload has reached.
+	ErrUploadOffsetMismatch = errors.New("chunk offset does not match the bytes received")
+	// ErrUploadExceedsSize is returned when a chunk would make an upload larger than the size it was started with.
+	ErrUploadExceedsSize = errors.New("chunk exceeds the size of the upload")
+	// ErrUploadIncomplete is returned when an upload is completed before every byte was received.
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+	// ErrUploadInUse is returned when a chunk is sent, or the upload is completed, while another request is doing so.
+	ErrUploadInUse = errors.New("upload is in use by another request")
+)
+
+// Resumable upload lifetimes and limits
+const (
+	// DefaultUploadSessionTTL is how long an upload lives without receiving a chunk, unless changed with
+	// SetUploadSessionTTL.
+	DefaultUploadSessionTTL = 24 * time.Hour
+	// MaxResumableUploadSize is the largest video that may be uploaded in chunks.
+	MaxResumableUploadSize int64 = 4 * 1024 * 1024 * 1024
+	// uploadCleanupInterval is how often expired uploads are removed.
+	uploadCleanupInterval = 10 * time.Minute
+)
+
+// uploadStagingDir is the directory holding the received bytes of every upload in progress.
+var uploadStagingDir = filepath.Join(scene.DataDir(), "uploads")
+
+// SetDataDir moves the directory holding every scene file and upload in progress, see scene.SetDataDir. It must be
+// called before any service is created.
+func SetDataDir(dir string) {
+	scene.SetDataDir(dir)
+	uploadStagingDir = filepath.Join(dir, "uploads")
+}
+
+// UploadStatus is the progress of a resumable upload. The next chunk is to be sent at Offset.
+type UploadStatus struct {
+	UploadID  string    `json:"upload_id"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// set when an upload is started while no worker is available to process it, see WorkerAvailabilityWarning
+	Warning string `json:"warning,omitempty"`
+}
+
+// SetUploadSessionTTL changes how long an upload lives without receiving a chunk. A value <= 0 uses
+// DefaultUploadSessionTTL. Uploads already started keep their expiry until their next chunk.
+func (s *ClientService) SetUploadSessionTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultUploadSessionTTL
+	}
+	s.uploadSessionTTL.Store(int64(ttl))
+}
+
+// uploadTTL returns how long an upload lives without receiving a chunk, see SetUploadSessionTTL.
+func (s *ClientService) uploadTTL() time.Duration {
+	if ttl := time.Duration(s.uploadSessionTTL.Load()); ttl > 0 {
+		return ttl
+	}
+	return DefaultUploadSessionTTL
+}
+
+// stagedUploadPath returns the path the received bytes of an upload are staged at.
+func stagedUploadPath(uploadID primitive.ObjectID) string {
+	return filepath.Join(uploadStagingDir, uploadID.Hex()+".part")
+}
+
+// lockUpload marks an upload as in use by the calling request. Returns false if another request is using it.
+func (s *ClientService) lockUpload(uploadID primitive.ObjectID) bool {
+	_, inUse := s.uploadLocks.LoadOrStore(uploadID, struct{}{})
+	return !inUse
+}
+
+// unlockUpload releases an upload locked with lockUpload.
+func (s *ClientService) unlockUpload(uploadID primitive.ObjectID) {
+	s.uploadLocks.Delete(uploadID)
+}
+
+// uploadSession returns an unexpired upload of the user, and the number of bytes it has received.
+// Returns upload.ErrUploadNotFound if the upload does not exist, expired, or belongs to another user.
+func (s *ClientService) uploadSession(ctx context.Context, userID, uploadID primitive.ObjectID) (*upload.Session, int64, error) {
+	session, err := s.uploadManager.GetSession(ctx, uploadID)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Other users' uploads are reported as missing, so upload IDs cannot be probed
+	if session.UserID != userID || time.Now().After(session.ExpiresAt) {
+		return nil, 0, upload.ErrUploadNotFound
+	}
+	info, err := os.Stat(stagedUploadPath(uploadID))
+	if err != nil {
+		return nil, 0, err
+	}
+	return session, info.Size(), nil
+}
+
+// removeUpload removes the staged bytes and the session of an upload.
+func (s *ClientService) removeUpload(ctx context.Context, uploadID primitive.ObjectID) error {
+	if err := os.Remove(stagedUploadPath(uploadID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err := s.uploadManager.DeleteSession(ctx, uploadID)
+	if err != nil && !errors.Is(err, upload.ErrUploadNotFound) {
+		return err
+	}
+	return nil
+}
+
+// removeUserUploads removes every upload of a user, waiting for requests using them to finish, and returns the
+// number of staged bytes reclaimed.
+func (s *ClientService) removeUserUploads(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	sessions, err := s.uploadManager.GetUserSessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var reclaimed int64
+	for _, session := range sessions {
+		for !s.lockUpload(session.ID) {
+			select {
+			case <-ctx.Done():
+				return reclaimed, ctx.Err()
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		if info, err := os.Stat(stagedUploadPath(session.ID)); err == nil {
+			reclaimed += info.Size()
+		}
+		err := s.removeUpload(ctx, session.ID)
+		s.unlockUpload(session.ID)
+		if err != nil {
+			return reclaimed, err
+		}
+	}
+	return reclaimed, nil
+}
+
+// checkStagedVideo checks the container signature of a staged upload once enough bytes have been received to
+// identify it. Uploads too short to identify are only rejected when complete is set.
+//
+// Returns ErrBadVideoContent if the upload is not an MP4 file.
+func checkStagedVideo(path string, complete bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, mp4SniffLen)
+	n, err := io.ReadFull(f, head)
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		if !complete {
+			return nil
+		}
+	} else if err != nil {
+		return err
+	}
+	if !isMP4Signature(head[:n]) {
+		return ErrBadVideoContent
+	}
+	return nil
+}
+
+// StartUpload starts a resumable upload of a video of the given size, to be trained with the given config once the
+// upload is completed. If a training config value is not provided, a default value is used, as with
+// HandleIncomingVideo.
+//
+// The config and priority are checked up front, so an upload that would be rejected for them is never sent, and the
+// returned status warns if no worker is available to process the video, see WorkerAvailabilityWarning. Returns
+// ErrValidation if the file is not an .mp4 file or its size is not between 1 and MaxResumableUploadSize, the priority
+// errors of resolvePriority, ErrAtCapacity if uploads are rejected while the most jobs allowed are in flight, and the
+// errors of checkUploadQuota if the user is out of quota, or ErrUploadSizeLimit if size is over the upload limit of
+// the user's tier. The video's duration is checked against the tier on completion. The scene is uploaded into the workspace of orgID if it is
+// not nil, as with HandleIncomingVideo, which is checked again on completion.
+func (s *ClientService) StartUpload(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	orgID primitive.ObjectID,
+	fileName string,
+	size int64,
+	trainingMode string,
+	outputTypes []string,
+	saveIterations []int,
+	totalIterations int,
+	frameSampleRate int,
+	targetFrameCount int,
+	sceneName string,
+	priority string,
+) (_ *UploadStatus, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.StartUpload", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	if filepath.Ext(fileName) != ".mp4" {
+		return nil, NewValidationError("improper file extension", map[string]string{"file_name": "must be an .mp4 file"}, nil)
+	}
+	if size <= 0 || size > MaxResumableUploadSize {
+		return nil, NewValidationError(
+			fmt.Sprintf("upload size must be between 1 and %d bytes", MaxResumableUploadSize),
+			map[string]string{"size": fmt.Sprintf("must be between 1 and %d", MaxResumableUploadSize)},
+			nil,
+		)
+	}
+
+	applyUploadDefaults(&sceneName, &trainingMode, &outputTypes, &saveIterations, &priority)
+	nerfConfig := &scene.NerfTrainingConfig{
+		TrainingMode:     trainingMode,
+		OutputTypes:      outputTypes,
+		SaveIterations:   saveIterations,
+		TotalIterations:  totalIterations,
+		FrameSampleRate:  frameSampleRate,
+		TargetFrameCount: targetFrameCount,
+	}
+	if err := s.trainingLimits.Check(nerfConfig); err != nil {
+		return nil, err
+	}
+	// The priority is resolved again on completion, as the number of high priority jobs will have changed by then
+	if _, _, err := s.resolvePriority(ctx, userID, priority); err != nil {
+		return nil, err
+	}
+	if err := s.checkOrgUpload(ctx, userID, orgID); err != nil {
+		return nil, err
+	}
+	if err := s.checkUploadQuota(ctx, userID, size); err != nil {
+		return nil, err
+	}
+	if err := s.CheckUploadSize(ctx, userID, size); err != nil {
+		return nil, err
+	}
+	if err := s.mqService.CheckAdmission(ctx); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &upload.Session{
+		ID:               primitive.NewObjectID(),
+		UserID:           userID,
+		FileName:         fileName,
+		Size:             size,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(s.uploadTTL()),
+		TrainingMode:     trainingMode,
+		OutputTypes:      outputTypes,
+		SaveIterations:   saveIterations,
+		TotalIterations:  totalIterations,
+		FrameSampleRate:  frameSampleRate,
+		TargetFrameCount: targetFrameCount,
+		SceneName:        sceneName,
+		Priority:         priority,
+		OrgID:            orgID,
+	}
+
+	if err := os.MkdirAll(uploadStagingDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	staged, err := os.Create(stagedUploadPath(session.ID))
+	if err != nil {
+		return nil, err
+	}
+	staged.Close()
+	if err := s.uploadManager.CreateSession(ctx, session); err != nil {
+		os.Remove(stagedUploadPath(session.ID))
+		return nil, err
+	}
+
+	s.logger.Infof("Started upload %s of %d bytes", session.ID.Hex(), size)
+	return &UploadStatus{
+		UploadID:  session.ID.Hex(),
+		Size:      size,
+		ExpiresAt: session.ExpiresAt,
+		Warning:   s.WorkerAvailabilityWarning(ctx, worker.KindSfm, worker.KindNerf),
+	}, nil
+}
+
+// GetUpload returns the progress of an upload of the user, i.e to find the offset to resume it from.
+//
+// Returns upload.ErrUploadNotFound if the upload does not exist, expired, or belongs to another user.
+func (s *ClientService) GetUpload(ctx context.Context, userID, uploadID primitive.ObjectID) (_ *UploadStatus, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetUpload", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	session, received, err := s.uploadSession(ctx, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadStatus{UploadID: uploadID.Hex(), Size: session.Size, Offset: received, ExpiresAt: session.ExpiresAt}, nil
+}
+
+// AppendUpload appends a chunk to an upload of the user at the given offset, which must be the number of bytes the
+// upload has received, and extends the upload's expiry. Returns the progress of the upload.
+//
+// Returns ErrUploadOffsetMismatch if offset is not the number of bytes received, ErrUploadExceedsSize if the chunk
+// would exceed the upload's size, ErrUploadInUse if another request is using the upload, or upload.ErrUploadNotFound
+// if the upload does not exist, expired, or belongs to another user. Returns ErrBadVideoContent, and removes the upload,
+// if its first bytes show it is not an MP4 file.
+func (s *ClientService) AppendUpload(ctx context.Context, userID, uploadID primitive.ObjectID, offset int64, chunk io.Reader) (_ *UploadStatus, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AppendUpload", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if !s.lockUpload(uploadID) {
+		return nil, ErrUploadInUse
+	}
+	defer s.unlockUpload(uploadID)
+
+	session, received, err := s.uploadSession(ctx, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if offset != received {
+		return nil, fmt.Errorf("%w: upload is at offset %d", ErrUploadOffsetMismatch, received)
+	}
+
+	path := stagedUploadPath(uploadID)
+	staged, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	// One byte past the remaining size is read, to tell a chunk that fits exactly from one that is too large
+	remaining := session.Size - received
+	written, err := io.Copy(staged, io.LimitReader(chunk, remaining+1))
+	if closeErr := staged.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > remaining {
+		err = fmt.Errorf("%w: %d bytes remaining", ErrUploadExceedsSize, remaining)
+	}
+	if err != nil {
+		// The upload is left as it was before the chunk, so the chunk can be sent again
+		if truncErr := os.Truncate(path, received); truncErr != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to truncate upload %s after a failed chunk: %v", uploadID.Hex(), truncErr)
+		}
+		return nil, err
+	}
+
+	if received < mp4SniffLen {
+		if err := checkStagedVideo(path, false); err != nil {
+			if errors.Is(err, ErrBadVideoContent) {
+				s.logger.Ctx(ctx).Infof("Rejected upload %s: %v", uploadID.Hex(), err)
+				if err := s.removeUpload(ctx, uploadID); err != nil {
+					s.logger.Ctx(ctx).Errorf("Failed to remove rejected upload %s: %v", uploadID.Hex(), err)
+				}
+			}
+			return nil, err
+		}
+	}
+
+	expiresAt := time.Now().Add(s.uploadTTL())
+	if err := s.uploadManager.ExtendSession(ctx, uploadID, expiresAt); err != nil {
+		return nil, err
+	}
+	return &UploadStatus{UploadID: uploadID.Hex(), Size: session.Size, Offset: received + written, ExpiresAt: expiresAt}, nil
+}
+
+// CompleteUpload finalizes an upload of the user that has received every byte. The video is moved into a new scene,
+// which is validated and created as HandleIncomingVideo describes, and its sfm job is published. The upload is gone
+// afterwards, whether the video was accepted or rejected.
+//
+// Returns the scene ID if successful. Returns ErrUploadIncomplete if bytes are missing, ErrUploadInUse if another
+// request is using the upload, ErrAtCapacity if uploads are rejected while the most jobs allowed are in flight, in
+// which case the upload is kept and can be completed later, or any error of HandleIncomingVideo for a rejected video.
+func (s *ClientService) CompleteUpload(ctx context.Context, userID, uploadID primitive.ObjectID) (_ string, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.CompleteUpload", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	start := time.Now()
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		s.metrics.UploadDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	}()
+
+	return s.completeUpload(ctx, userID, uploadID, nil)
+}
+
+// completeUpload finalizes an upload as CompleteUpload describes. If configure is not nil, it is called with the
+// session before the scene is created, to replace the training config the upload was started with.
+func (s *ClientService) completeUpload(ctx context.Context, userID, uploadID primitive.ObjectID, configure func(*upload.Session)) (string, error) {
+	if !s.lockUpload(uploadID) {
+		return "", ErrUploadInUse
+	}
+	defer s.unlockUpload(uploadID)
+
+	session, received, err := s.uploadSession(ctx, userID, uploadID)
+	if err != nil {
+		return "", err
+	}
+	if configure != nil {
+		configure(session)
+	}
+	if received != session.Size {
+		return "", fmt.Errorf("%w: received %d of %d bytes", ErrUploadIncomplete, received, session.Size)
+	}
+
+	path := stagedUploadPath(uploadID)
+	if err := checkStagedVideo(path, true); err != nil {
+		if errors.Is(err, ErrBadVideoContent) {
+			s.logger.Ctx(ctx).Infof("Rejected upload %s: %v", uploadID.Hex(), err)
+			if err := s.removeUpload(ctx, uploadID); err != nil {
+				s.logger.Ctx(ctx).Errorf("Failed to remove rejected upload %s: %v", uploadID.Hex(), err)
+			}
+		}
+		return "", err
+	}
+	if err := s.checkOrgUpload(ctx, userID, session.OrgID); err != nil {
+		return "", err
+	}
+	if err := s.checkUploadQuota(ctx, userID, session.Size); err != nil {
+		return "", err
+	}
+	if err := s.mqService.CheckAdmission(ctx); err != nil {
+		return "", err
+	}
+
+	// Move the video into the layout of its scene, after which the upload no longer exists
+	sceneID := primitive.NewObjectID()
+	videoFilePath := s.sceneManager.RawVideoPath(sceneID)
+	if err := os.MkdirAll(filepath.Dir(videoFilePath), os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := os.Rename(path, videoFilePath); err != nil {
+		os.RemoveAll(s.sceneManager.SceneDir(sceneID))
+		return "", err
+	}
+	if err := s.removeUpload(ctx, uploadID); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to remove completed upload %s: %v", uploadID.Hex(), err)
+	}
+
+	// The video was received in chunks, so its checksum is only computed once complete
+	videoSHA256, err := fileSHA256(videoFilePath)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to compute checksum of upload %s: %v", uploadID.Hex(), err)
+	}
+
+	return s.createVideoScene(ctx, userID, session.OrgID, sceneID, session.FileName, session.Size, videoSHA256, session.TrainingMode,
+		session.OutputTypes, session.SaveIterations, session.TotalIterations, session.FrameSampleRate,
+		session.TargetFrameCount, session.SceneName, session.Priority, false)
+}
+
+// AbortUpload removes an upload of the user, and every byte it received.
+//
+// Returns ErrUploadInUse if another request is using the upload, or upload.ErrUploadNotFound if the upload does not
+// exist, expired, or belongs to another user.
+func (s *ClientService) AbortUpload(ctx context.Context, userID, uploadID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.AbortUpload", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if !s.lockUpload(uploadID) {
+		return ErrUploadInUse
+	}
+	defer s.unlockUpload(uploadID)
+
+	if _, _, err := s.uploadSession(ctx, userID, uploadID); err != nil {
+		return err
+	}
+	return s.removeUpload(ctx, uploadID)
+}
+
+// runUploadCleanup removes expired uploads every uploadCleanupInterval.
+func (s *ClientService) runUploadCleanup() {
+	ticker := time.NewTicker(uploadCleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.removeExpiredUploads(context.Background())
+	}
+}
+
+// removeExpiredUploads removes every expired upload that no request is using, and logs the number of staged bytes
+// reclaimed. An upload that received a chunk since it was listed is kept, as the chunk extended its expiry.
+func (s *ClientService) removeExpiredUploads(ctx context.Context) {
+	sessions, err := s.uploadManager.GetExpiredSessions(ctx, time.Now())
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to get expired uploads: %v", err)
+		return
+	}
+
+	var removed int
+	var reclaimed int64
+	for _, session := range sessions {
+		if !s.lockUpload(session.ID) {
+			continue
+		}
+		size, err := s.removeExpiredUpload(ctx, session.ID)
+		s.unlockUpload(session.ID)
+		if err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to remove expired upload %s: %v", session.ID.Hex(), err)
+			continue
+		}
+		if size >= 0 {
+			removed++
+			reclaimed += size
+		}
+	}
+	if removed > 0 {
+		s.logger.Ctx(ctx).Infof("Removed %d expired uploads, reclaimed %d bytes", removed, reclaimed)
+	}
+}
+
+// removeExpiredUpload removes an upload locked by the caller if it is still expired, and returns the number of staged
+// bytes reclaimed, or -1 if the upload was kept.
+func (s *ClientService) removeExpiredUpload(ctx context.Context, uploadID primitive.ObjectID) (int64, error) {
+	// The upload is read again under the lock, as a chunk may have extended it since it was listed
+	session, err := s.uploadManager.GetSession(ctx, uploadID)
+	if errors.Is(err, upload.ErrUploadNotFound) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if time.Now().Before(session.ExpiresAt) {
+		return -1, nil
+	}
+
+	var size int64
+	if info, err := os.Stat(stagedUploadPath(uploadID)); err == nil {
+		size = info.Size()
+	}
+	if err := s.removeUpload(ctx, uploadID); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
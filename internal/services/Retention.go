@@ -0,0 +1,273 @@
+// This file contains the retention policy, which removes data that is no longer needed on a schedule.
+//
+// Four rules are applied, each disabled unless configured:
+//   - raw videos are removed once their scene completed training RawVideoRetention ago. The scene and its outputs are
+//     kept, but can no longer run sfm again, see checkSfmInput.
+//   - failed scenes are deleted, with every file, once they failed FailedSceneRetention ago.
+//   - scenes in the trash are deleted, with every file, once they were trashed TrashRetention ago, see Trash.go.
+//   - files on disk that belong to no scene document are removed, see scene.SceneManager.Reconcile.
+//
+// Space reclaimed from a scene is released from the storage of its owner. In dry run mode the policy only reports and
+// logs what it would remove. PreviewRetention reports what the next run would remove, whatever the mode, so admins can
+// check a policy before enabling it. Runs should only be scheduled on one server, as concurrent runs could both delete
+// the same failed scene, releasing its storage twice.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// DefaultRetentionInterval is used when RetentionConfig.Interval is not set.
+const DefaultRetentionInterval = time.Hour
+
+// RetentionConfig configures the retention policy. A zero value removes nothing.
+type RetentionConfig struct {
+	// how long after its scene completed a raw video is kept, <= 0 keeps raw videos
+	RawVideoRetention time.Duration
+	// how long after failing a failed scene is kept, <= 0 keeps failed scenes
+	FailedSceneRetention time.Duration
+	// how long after being trashed a scene is kept in the trash and may be restored, <= 0 keeps trashed scenes
+	TrashRetention time.Duration
+	// remove files on disk that belong to no scene document
+	PurgeOrphanedFiles bool
+	// only report what would be removed
+	DryRun bool
+	// run the policy every Interval on this server, see the file comment
+	Schedule bool
+	Interval time.Duration
+}
+
+// enabled reports whether any rule is configured.
+func (c RetentionConfig) enabled() bool {
+	return c.RawVideoRetention > 0 || c.FailedSceneRetention > 0 || c.TrashRetention > 0 || c.PurgeOrphanedFiles
+}
+
+// RetainedItem is something the retention policy removed, or would remove in a dry run.
+type RetainedItem struct {
+	SceneID primitive.ObjectID `json:"scene_id"`
+	Path    string             `json:"path,omitempty"`
+	// when the scene finished, or was trashed, which the retention period counts from
+	FinishedAt time.Time `json:"finished_at"`
+	Bytes      int64     `json:"bytes"`
+	Removed    bool      `json:"removed"`
+}
+
+// RetentionReport is the result of a run of the retention policy.
+type RetentionReport struct {
+	DryRun        bool                 `json:"dry_run"`
+	RanAt         time.Time            `json:"ran_at"`
+	RawVideos     []RetainedItem       `json:"raw_videos"`
+	FailedScenes  []RetainedItem       `json:"failed_scenes"`
+	TrashedScenes []RetainedItem       `json:"trashed_scenes"`
+	OrphanedFiles []scene.OrphanedFile `json:"orphaned_files"`
+	// bytes removed, or that would be removed in a dry run, orphaned files excluded
+	Bytes int64 `json:"bytes"`
+	// non fatal errors, i.e a file that could not be removed
+	Errors []string `json:"errors"`
+}
+
+// retentionPolicy holds the retention policy of a ClientService, and the report of its last scheduled run.
+type retentionPolicy struct {
+	mu         sync.Mutex
+	config     RetentionConfig
+	lastReport *RetentionReport
+	// closed to wake the scheduler up when the config changes
+	changed chan struct{}
+}
+
+// SetRetention changes the retention policy. The next scheduled run uses the new policy.
+func (s *ClientService) SetRetention(config RetentionConfig) {
+	s.retention.mu.Lock()
+	defer s.retention.mu.Unlock()
+	s.retention.config = config
+	if s.retention.changed != nil {
+		close(s.retention.changed)
+	}
+	s.retention.changed = make(chan struct{})
+}
+
+// retentionConfig returns the retention policy, and the channel closed when it changes.
+func (s *ClientService) retentionConfig() (RetentionConfig, chan struct{}) {
+	s.retention.mu.Lock()
+	defer s.retention.mu.Unlock()
+	if s.retention.changed == nil {
+		s.retention.changed = make(chan struct{})
+	}
+	return s.retention.config, s.retention.changed
+}
+
+// runRetention applies the retention policy every RetentionConfig.Interval on servers scheduling it.
+func (s *ClientService) runRetention() {
+	for {
+		config, changed := s.retentionConfig()
+		if !config.Schedule || !config.enabled() {
+			<-changed
+			continue
+		}
+		interval := config.Interval
+		if interval <= 0 {
+			interval = DefaultRetentionInterval
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(interval):
+			ctx := context.Background()
+			report, err := s.applyRetention(ctx, config, config.DryRun)
+			if err != nil {
+				s.logger.Ctx(ctx).Errorf("Failed to apply retention policy: %v", err)
+				continue
+			}
+			s.retention.mu.Lock()
+			s.retention.lastReport = report
+			s.retention.mu.Unlock()
+		}
+	}
+}
+
+// PreviewRetention reports what the next run of the retention policy would remove, without removing anything, along
+// with the report of the last scheduled run, nil if none ran yet.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin.
+func (s *ClientService) PreviewRetention(ctx context.Context, adminUserID primitive.ObjectID) (next, last *RetentionReport, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.PreviewRetention", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return nil, nil, err
+	}
+
+	config, _ := s.retentionConfig()
+	next, err = s.applyRetention(ctx, config, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.retention.mu.Lock()
+	last = s.retention.lastReport
+	s.retention.mu.Unlock()
+	return next, last, nil
+}
+
+// applyRetention applies the rules of config at the current time, only reporting what would be removed if dryRun is
+// set. Failing to remove one item is reported, and does not stop the run.
+func (s *ClientService) applyRetention(ctx context.Context, config RetentionConfig, dryRun bool) (*RetentionReport, error) {
+	now := time.Now()
+	report := &RetentionReport{
+		DryRun:        dryRun,
+		RanAt:         now,
+		RawVideos:     make([]RetainedItem, 0),
+		FailedScenes:  make([]RetainedItem, 0),
+		TrashedScenes: make([]RetainedItem, 0),
+		OrphanedFiles: make([]scene.OrphanedFile, 0),
+		Errors:        make([]string, 0),
+	}
+
+	if config.RawVideoRetention > 0 {
+		scenes, err := s.sceneManager.GetScenesWithRawVideoCompletedBefore(ctx, now.Add(-config.RawVideoRetention))
+		if err != nil {
+			return nil, err
+		}
+		for _, sc := range scenes {
+			item := RetainedItem{SceneID: sc.ID, Path: sc.Video.FilePath, FinishedAt: sc.Status.UpdatedAt}
+			item.Bytes, err = pathSize(sc.Video.FilePath)
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			if !dryRun {
+				if err := s.removeRawVideo(ctx, sc, item.Bytes, now); err != nil {
+					report.Errors = append(report.Errors, err.Error())
+					continue
+				}
+				item.Removed = true
+			}
+			report.RawVideos = append(report.RawVideos, item)
+			report.Bytes += item.Bytes
+		}
+	}
+
+	if config.FailedSceneRetention > 0 {
+		scenes, err := s.sceneManager.GetScenesFinishedBefore(ctx, scene.StateFailed, now.Add(-config.FailedSceneRetention))
+		if err != nil {
+			return nil, err
+		}
+		for _, sc := range scenes {
+			item := RetainedItem{SceneID: sc.ID, FinishedAt: sc.Status.UpdatedAt}
+			if dryRun {
+				item.Bytes, err = s.sceneStorageUsage(ctx, sc.ID)
+			} else {
+				item.Bytes, err = s.deleteOwnedScene(ctx, sc.ID, false)
+				item.Removed = err == nil
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			report.FailedScenes = append(report.FailedScenes, item)
+			report.Bytes += item.Bytes
+		}
+	}
+
+	if config.TrashRetention > 0 {
+		scenes, err := s.sceneManager.GetScenesTrashedBefore(ctx, now.Add(-config.TrashRetention))
+		if err != nil {
+			return nil, err
+		}
+		for _, sc := range scenes {
+			item := RetainedItem{SceneID: sc.ID, FinishedAt: *sc.TrashedAt}
+			if dryRun {
+				item.Bytes, err = s.sceneStorageUsage(ctx, sc.ID)
+			} else {
+				item.Bytes, err = s.deleteOwnedScene(ctx, sc.ID, true)
+				item.Removed = err == nil
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			report.TrashedScenes = append(report.TrashedScenes, item)
+			report.Bytes += item.Bytes
+		}
+	}
+
+	if config.PurgeOrphanedFiles {
+		reconciled, err := s.sceneManager.Reconcile(ctx, scene.ReconcileOptions{DryRun: dryRun, FilesOnly: true})
+		if err != nil {
+			return nil, err
+		}
+		report.OrphanedFiles = reconciled.OrphanedFiles
+		report.Errors = append(report.Errors, reconciled.Errors...)
+	}
+
+	s.logger.Ctx(ctx).Infof("Retention policy found %d raw videos, %d failed scenes, %d trashed scenes, and %d orphaned files, %d bytes (dry run: %t)",
+		len(report.RawVideos), len(report.FailedScenes), len(report.TrashedScenes), len(report.OrphanedFiles), report.Bytes, dryRun)
+	return report, nil
+}
+
+// removeRawVideo removes the raw video of a completed scene, and releases its size from the storage of the scene's
+// owner. The video is marked as removed first, so a scene that is retrained meanwhile is skipped rather than losing
+// its video while it runs sfm.
+func (s *ClientService) removeRawVideo(ctx context.Context, sc *scene.Scene, size int64, now time.Time) error {
+	if err := s.sceneManager.MarkRawVideoDeleted(ctx, sc.ID, now); err != nil {
+		return err
+	}
+	s.sceneCache.InvalidateScene(ctx, sc.ID)
+	if err := os.Remove(sc.Video.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := s.addOwnerStorage(ctx, sc.ID, -size); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to remove %d bytes from storage of owner of scene %s: %v", size, sc.ID.Hex(), err)
+	}
+	s.logger.Ctx(ctx).Infof("Removed raw video of scene %s, %d bytes reclaimed", sc.ID.Hex(), size)
+	return nil
+}
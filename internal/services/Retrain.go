@@ -0,0 +1,256 @@
+// This file contains retraining of existing scenes with a new training config, and retrying failed or cancelled
+// scenes with their existing one, without re-uploading the video.
+//
+// Retraining a completed scene starts a new training run of it, see scene/Versions.go: the config, status and nerf
+// output of the previous run are kept as a previous version, and the new run saves its output in its own directory, so
+// iterations of the old and new runs never mix in the scene metadata or output listings. Retrying, and retraining a
+// scene whose run failed or was cancelled, replace the previous run instead, deleting its output from disk.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrSfmFailed is returned when retraining a scene whose sfm stage never produced output.
+	ErrSfmFailed = errors.New("scene sfm failed, upload the video again")
+)
+
+// RetrainScene trains an existing scene again with newConfig, and returns the version of the new training run. The
+// nerf output of a completed scene is kept as a previous version, that of a failed or cancelled one is replaced.
+//
+// The scene's sfm output is reused and only the training job is published, unless rerunSfm is set or the scene has
+// no sfm output, in which case processing restarts from sfm with the stored video or image set.
+//
+// Frame sampling only applies when sfm is run again, and is checked against the frames of the stored video. It does
+// not apply to image sets.
+//
+// Returns scene.ErrInvalidOpOnProcessingScene if the scene is still processing, ErrSfmFailed if its sfm failed,
+// scene.ErrInvalidTrainingConfig if newConfig is invalid, ErrTooFewSampledFrames if the frame sampling leaves too few
+// frames of the video, or one of the priority errors of resolvePriority.
+func (s *ClientService) RetrainScene(ctx context.Context, userID, sceneID primitive.ObjectID, newConfig *scene.TrainingConfig, rerunSfm bool) (_ int, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RetrainScene", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionRetrain); err != nil {
+		return 0, err
+	}
+
+	if newConfig == nil {
+		return 0, scene.ErrInvalidTrainingConfig
+	}
+	if err := s.trainingLimits.Check(newConfig.NerfTrainingConfig); err != nil {
+		return 0, err
+	}
+
+	requested := newConfig.Priority
+	if requested == "" {
+		requested = scene.PriorityNormal
+	}
+	priority, tier, err := s.resolvePriority(ctx, userID, requested)
+	if err != nil {
+		return 0, err
+	}
+	config := &scene.TrainingConfig{
+		SfmTrainingConfig:  newConfig.SfmTrainingConfig,
+		NerfTrainingConfig: newConfig.NerfTrainingConfig,
+		Priority:           priority,
+		Tier:               tier,
+	}
+
+	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return 0, err
+	}
+	if currentScene.Status != nil && !currentScene.Status.State.IsTerminal() {
+		return 0, scene.ErrInvalidOpOnProcessingScene
+	}
+
+	hasSfm := currentScene.Sfm != nil && len(currentScene.Sfm.Frames) > 0
+	if !hasSfm && currentScene.Status != nil && currentScene.Status.State == scene.StateFailed {
+		return 0, ErrSfmFailed
+	}
+	rerunSfm = rerunSfm || !hasSfm
+	if rerunSfm {
+		// Scenes uploaded with pre-computed sfm output have no video to run sfm on
+		if err := checkSfmInput(currentScene); err != nil {
+			return 0, err
+		}
+		if currentScene.UploadedInputType() == scene.InputTypeImages && config.NerfTrainingConfig.HasFrameSampling() {
+			return 0, NewValidationError(
+				"frame sampling does not apply to image sets",
+				map[string]string{"frame_sample_rate": "not allowed for image sets", "target_frame_count": "not allowed for image sets"},
+				scene.ErrInvalidTrainingConfig,
+			)
+		}
+		limits := s.videoLimits[config.NerfTrainingConfig.TrainingMode]
+		if err := limits.CheckSampling(config.NerfTrainingConfig, currentScene.Video.FrameCount); err != nil {
+			return 0, err
+		}
+	} else if config.NerfTrainingConfig.HasFrameSampling() {
+		return 0, NewValidationError(
+			"frame sampling only applies when sfm is run again",
+			map[string]string{"rerun_sfm": "must be true to change frame sampling"},
+			scene.ErrInvalidTrainingConfig,
+		)
+	}
+
+	newRun := currentScene.Status != nil && currentScene.Status.State == scene.StateCompleted && currentScene.Nerf != nil
+	version, err := s.restartScene(ctx, currentScene, config, rerunSfm, newRun)
+	if err != nil {
+		return 0, err
+	}
+
+	s.logger.Ctx(ctx).Infof("Retraining scene %s as version %d (rerun sfm: %t)", sceneID.Hex(), version, rerunSfm)
+	return version, nil
+}
+
+// RetryJob processes a failed or cancelled scene again with its existing training config, without re-uploading the
+// video. Processing resumes from the last stage that succeeded: only the training job is published if the scene has
+// sfm output, and sfm is run again from the stored video or image set otherwise.
+//
+// Returns scene.ErrInvalidOpOnProcessingScene if the scene is still processing, scene.ErrInvalidStatusTransition if
+// it has completed, or scene.ErrVideoNotFound if sfm has to run again and the scene has no stored video or images.
+func (s *ClientService) RetryJob(ctx context.Context, userID, sceneID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RetryJob", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionRetry); err != nil {
+		return err
+	}
+
+	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return err
+	}
+	if currentScene.Status == nil || !currentScene.Status.State.IsTerminal() {
+		return scene.ErrInvalidOpOnProcessingScene
+	}
+	if currentScene.Status.State == scene.StateCompleted {
+		return newError(ErrConflict, "completed scenes cannot be retried, retrain them instead", scene.ErrInvalidStatusTransition)
+	}
+	if currentScene.Config == nil || currentScene.Config.NerfTrainingConfig == nil {
+		return scene.ErrTrainingConfigNotFound
+	}
+
+	rerunSfm := currentScene.Sfm == nil || len(currentScene.Sfm.Frames) == 0
+	if rerunSfm {
+		if err := checkSfmInput(currentScene); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.restartScene(ctx, currentScene, currentScene.Config, rerunSfm, false); err != nil {
+		return err
+	}
+
+	s.logger.Ctx(ctx).Infof("Retrying scene %s (rerun sfm: %t)", sceneID.Hex(), rerunSfm)
+	return nil
+}
+
+// restartScene restarts a scene in a terminal state with config, and publishes the sfm job if rerunSfm is set or the
+// training job otherwise. If newRun is set, the current run is kept as a previous version and the scene restarts as a
+// new run, otherwise the output of the current run is removed. If publishing fails, the scene is marked as failed.
+//
+// Returns the version of the restarted run.
+func (s *ClientService) restartScene(ctx context.Context, current *scene.Scene, config *scene.TrainingConfig, rerunSfm, newRun bool) (int, error) {
+	sceneID := current.ID
+	state := scene.StateSfmDone
+	if rerunSfm {
+		state = scene.StateQueued
+	}
+	version := current.CurrentVersion()
+	var err error
+	if newRun {
+		version, err = s.sceneManager.RestartSceneVersion(ctx, sceneID, config, state)
+	} else {
+		err = s.sceneManager.RestartScene(ctx, sceneID, config, state)
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.sceneCache.InvalidateScene(ctx, sceneID)
+
+	s.removePreviousRun(ctx, sceneID, version, !newRun, rerunSfm)
+
+	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err == nil {
+		if rerunSfm {
+			err = s.mqService.PublishSFMJob(ctx, currentScene)
+		} else {
+			err = s.mqService.PublishNERFJob(ctx, currentScene)
+		}
+	}
+	if err != nil {
+		stage := metrics.StageTraining
+		if rerunSfm {
+			stage = metrics.StageSfm
+		}
+		s.logger.Ctx(ctx).Errorf("Failed to publish job for restarted scene %s: %v", sceneID.Hex(), err)
+		s.metrics.JobsFailedTotal.WithLabelValues(stage, config.NerfTrainingConfig.TrainingMode).Inc()
+		if err := s.sceneManager.TransitionStatus(ctx, sceneID, scene.StateFailed, err.Error()); err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to mark scene %s as failed: %v", sceneID.Hex(), err)
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// removePreviousRun deletes the output of a scene's previous run from disk, and removes its size from the storage
+// of the scene's owner. The nerf output of training run version is only deleted if removeOutputs is set, and the sfm
+// output only if removeSfm is set, when sfm is run again.
+//
+// Failures are logged rather than returned, as the scene has already been restarted.
+func (s *ClientService) removePreviousRun(ctx context.Context, sceneID primitive.ObjectID, version int, removeOutputs, removeSfm bool) {
+	// Scenes not yet migrated to the per scene layout may still have output in the legacy directories
+	legacySfm, legacyNerf := s.sceneManager.LegacyStoragePaths(sceneID)
+	paths := make([]string, 0)
+	if removeOutputs {
+		paths = append(paths, s.sceneManager.RunOutputsDir(sceneID, version))
+		if version <= 1 {
+			paths = append(paths, legacyNerf)
+		}
+	}
+	if removeSfm {
+		paths = append(paths, s.sceneManager.SfmDir(sceneID), legacySfm)
+	}
+	if len(paths) == 0 {
+		return
+	}
+
+	var reclaimed int64
+	for _, path := range paths {
+		size, err := pathSize(path)
+		if err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to size previous output %s: %v", path, err)
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to remove previous output %s: %v", path, err)
+			continue
+		}
+		reclaimed += size
+	}
+
+	owner, err := s.userManager.GetUserBySceneID(ctx, sceneID)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to find owner of scene %s: %v", sceneID.Hex(), err)
+		return
+	}
+	if err := s.userManager.IncrementStorageUsed(ctx, owner.ID, -reclaimed); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to remove %d bytes from storage of user %s: %v", reclaimed, owner.ID.Hex(), err)
+	}
+}
@@ -0,0 +1,208 @@
+// This file contains SceneCache, the cache of scene values read on most requests.
+//
+// Four values are cached per scene: the training config, the files of its outputs as found on disk (from which the
+// metadata route computes chunk layouts), the role each user who was granted access has on it, and whether it is in the
+// trash. Entries are invalidated when the value changes: outputs when a worker reports new ones or files are restored
+// from the object store, the config and outputs when the scene is retrained or deleted, roles when the scene is shared
+// or unshared, and the trash when the scene is moved to or restored from it.
+// Every entry also expires after the configured TTL, which bounds how stale a value can be when the change happened
+// elsewhere, i.e on another server using the in-process cache, or an admin changing a user directly.
+// Besides scene values, the cache holds whether each account may use its access tokens, see VerifySession.
+//
+// The cache is an optimization only. A failing cache is logged and read through to the database.
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/cache"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+// DefaultSceneCacheTTL is how long scene values are cached unless configured otherwise.
+const DefaultSceneCacheTTL = 30 * time.Second
+
+// accessRoleOwner is the cached access role of a scene's owner, who may perform every action.
+const accessRoleOwner = "owner"
+
+// resourceStat is the cached state of an output file.
+type resourceStat struct {
+	Exists  bool      `json:"exists"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// SceneCache caches scene values, see the file comment.
+type SceneCache struct {
+	cache  cache.Cache
+	ttl    time.Duration
+	logger *log.Logger
+}
+
+// NewSceneCache creates a SceneCache keeping entries in c for ttl, DefaultSceneCacheTTL if ttl <= 0.
+func NewSceneCache(c cache.Cache, ttl time.Duration, logger *log.Logger) *SceneCache {
+	if ttl <= 0 {
+		ttl = DefaultSceneCacheTTL
+	}
+	return &SceneCache{cache: c, ttl: ttl, logger: logger}
+}
+
+func configKey(sceneID primitive.ObjectID) string {
+	return "vidgonerf:scene:" + sceneID.Hex() + ":config"
+}
+
+func resourcesKey(sceneID primitive.ObjectID) string {
+	return "vidgonerf:scene:" + sceneID.Hex() + ":resources"
+}
+
+func accessKey(sceneID, userID primitive.ObjectID) string {
+	return "vidgonerf:scene:" + sceneID.Hex() + ":access:" + userID.Hex()
+}
+
+func trashKey(sceneID primitive.ObjectID) string {
+	return "vidgonerf:scene:" + sceneID.Hex() + ":trash"
+}
+
+// get decodes the entry cached under key into value, and reports whether there was one.
+func (c *SceneCache) get(ctx context.Context, key string, value any) bool {
+	data, err := c.cache.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, cache.ErrMiss) {
+			c.logger.Ctx(ctx).Warnf("Failed to read cache entry %s: %v", key, err)
+		}
+		return false
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		c.logger.Ctx(ctx).Warnf("Dropping malformed cache entry %s: %v", key, err)
+		c.delete(ctx, key)
+		return false
+	}
+	return true
+}
+
+// set caches value under key.
+func (c *SceneCache) set(ctx context.Context, key string, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		c.logger.Ctx(ctx).Errorf("Failed to encode cache entry %s: %v", key, err)
+		return
+	}
+	if err := c.cache.Set(ctx, key, data, c.ttl); err != nil {
+		c.logger.Ctx(ctx).Warnf("Failed to write cache entry %s: %v", key, err)
+	}
+}
+
+// delete removes the entries cached under keys.
+func (c *SceneCache) delete(ctx context.Context, keys ...string) {
+	if err := c.cache.Delete(ctx, keys...); err != nil {
+		c.logger.Ctx(ctx).Warnf("Failed to invalidate cache entries %v: %v", keys, err)
+	}
+}
+
+// InvalidateOutputs drops the cached output files of a scene, after they changed.
+func (c *SceneCache) InvalidateOutputs(ctx context.Context, sceneID primitive.ObjectID) {
+	c.delete(ctx, resourcesKey(sceneID))
+}
+
+// InvalidateScene drops the cached config and output files of a scene, after it was retrained or deleted.
+func (c *SceneCache) InvalidateScene(ctx context.Context, sceneID primitive.ObjectID) {
+	c.delete(ctx, configKey(sceneID), resourcesKey(sceneID))
+}
+
+// InvalidateAccess drops the cached roles of the given users on a scene, after their access changed.
+func (c *SceneCache) InvalidateAccess(ctx context.Context, sceneID primitive.ObjectID, userIDs ...primitive.ObjectID) {
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = accessKey(sceneID, userID)
+	}
+	c.delete(ctx, keys...)
+}
+
+// InvalidateTrash drops whether a scene is in the trash, after it was moved to or restored from it.
+func (c *SceneCache) InvalidateTrash(ctx context.Context, sceneID primitive.ObjectID) {
+	c.delete(ctx, trashKey(sceneID))
+}
+
+// trainingConfig returns the training config of a scene, cached.
+func (s *ClientService) trainingConfig(ctx context.Context, sceneID primitive.ObjectID) (*scene.TrainingConfig, error) {
+	var config scene.TrainingConfig
+	if s.sceneCache.get(ctx, configKey(sceneID), &config) {
+		return &config, nil
+	}
+	cfg, err := s.sceneManager.GetTrainingConfig(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	s.sceneCache.set(ctx, configKey(sceneID), cfg)
+	return cfg, nil
+}
+
+// sceneResources returns the state of every output file of a scene by output type and iteration, cached. Files are
+// stat'ed on disk, and include those of export formats.
+func (s *ClientService) sceneResources(ctx context.Context, sceneID primitive.ObjectID) (map[string]map[int]resourceStat, error) {
+	var resources map[string]map[int]resourceStat
+	if s.sceneCache.get(ctx, resourcesKey(sceneID), &resources) {
+		return resources, nil
+	}
+
+	nerf, err := s.sceneManager.GetNerf(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	config, err := s.trainingConfig(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err = statResources(config, nerf)
+	if err != nil {
+		return nil, err
+	}
+	s.sceneCache.set(ctx, resourcesKey(sceneID), resources)
+	return resources, nil
+}
+
+// statResources stats every output file of nerf on disk, by output type and iteration.
+func statResources(config *scene.TrainingConfig, nerf *scene.Nerf) (map[string]map[int]resourceStat, error) {
+	resources := make(map[string]map[int]resourceStat)
+	for _, outputType := range sceneOutputTypes(config, nerf) {
+		iterFilePaths, err := nerf.GetFilePathsForType(outputType)
+		if err != nil {
+			return nil, err
+		}
+		resources[outputType] = make(map[int]resourceStat, len(iterFilePaths))
+		for iteration, path := range iterFilePaths {
+			stat := resourceStat{}
+			if fileInfo, err := os.Stat(path); err == nil {
+				stat = resourceStat{Exists: true, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}
+				if outputType == scene.OutputTypeCheckpoint {
+					stat.SHA256 = nerf.CheckpointChecksums[iteration]
+				}
+			}
+			resources[outputType][iteration] = stat
+		}
+	}
+	return resources, nil
+}
+
+// cachedAccessRole returns the cached role of a user on a scene, accessRoleOwner or a collaborator role, and whether
+// there was one.
+func (s *ClientService) cachedAccessRole(ctx context.Context, sceneID, userID primitive.ObjectID) (string, bool) {
+	var role string
+	ok := s.sceneCache.get(ctx, accessKey(sceneID, userID), &role)
+	return role, ok
+}
+
+// cacheAccessRole caches the role of a user on a scene.
+func (s *ClientService) cacheAccessRole(ctx context.Context, sceneID, userID primitive.ObjectID, role string) {
+	s.sceneCache.set(ctx, accessKey(sceneID, userID), role)
+}
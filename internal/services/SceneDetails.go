@@ -0,0 +1,117 @@
+// This file contains the detail view of a single scene, which gathers what clients would otherwise piece together from
+// the metadata, status, and config of the scene in one call.
+
+package services
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// VideoDetails are the probed properties of a scene's video. Scenes uploaded with pre-computed sfm output or as an image
+// set report the properties of their images, and no duration or frame rate.
+type VideoDetails struct {
+	Width      int   `json:"width"`
+	Height     int   `json:"height"`
+	FPS        int   `json:"fps"`
+	Duration   int   `json:"duration"`
+	FrameCount int   `json:"frame_count"`
+	FileSize   int64 `json:"file_size"`
+}
+
+// OutputProgress is the progress of a single output type, by save iteration.
+type OutputProgress struct {
+	// iterations whose output is stored, sorted ascending
+	Completed []int `json:"completed"`
+	// configured save iterations whose output is not stored yet, sorted ascending
+	Pending []int `json:"pending"`
+}
+
+// SceneDetails is the full detail view of a scene, see GetScene.
+type SceneDetails struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// what was uploaded, see scene.Scene.InputType
+	InputType string                `json:"input_type"`
+	Config    *scene.TrainingConfig `json:"config"`
+	// omitted for scenes without a video
+	Video      *VideoDetails      `json:"video,omitempty"`
+	Status     *scene.SceneStatus `json:"status"`
+	SfmSkipped bool               `json:"sfm_skipped"`
+	// progress of each configured output type
+	Outputs map[string]OutputProgress `json:"outputs"`
+}
+
+// GetScene returns the details of the given scene: its name, creation time, training config, video properties,
+// status, and which save iterations of each output type are complete or still pending.
+//
+// Returns (nil, error) if the user does not have access to the scene, scene.ErrSceneNotFound if it does not exist, or
+// error if an error occurred.
+func (s *ClientService) GetScene(ctx context.Context, userID, sceneID primitive.ObjectID) (_ *SceneDetails, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetScene", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadMetadata); err != nil {
+		return nil, err
+	}
+
+	sc, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	if sc.Config == nil || sc.Config.NerfTrainingConfig == nil {
+		return nil, scene.ErrTrainingConfigNotFound
+	}
+
+	details := &SceneDetails{
+		ID:          sceneID.Hex(),
+		Name:        sc.Name,
+		Description: sc.Description,
+		Tags:        sc.Tags,
+		CreatedAt:   sceneID.Timestamp(),
+		InputType:   sc.UploadedInputType(),
+		Config:      sc.Config,
+		Status:      sc.Status,
+		SfmSkipped:  sc.Sfm != nil && sc.Sfm.Precomputed,
+		Outputs:     make(map[string]OutputProgress, len(sc.Config.NerfTrainingConfig.OutputTypes)),
+	}
+	if sc.Video != nil {
+		details.Video = &VideoDetails{
+			Width:      sc.Video.Width,
+			Height:     sc.Video.Height,
+			FPS:        sc.Video.FPS,
+			Duration:   sc.Video.Duration,
+			FrameCount: sc.Video.FrameCount,
+			FileSize:   sc.Video.FileSize,
+		}
+	}
+
+	saveIterations := slices.Sorted(slices.Values(sc.Config.NerfTrainingConfig.SaveIterations))
+	for _, outputType := range sc.Config.NerfTrainingConfig.OutputTypes {
+		completed, err := s.sceneManager.GetCompletedIterations(ctx, sceneID, outputType)
+		if err != nil {
+			return nil, err
+		}
+		pending := make([]int, 0)
+		for _, iteration := range saveIterations {
+			if !slices.Contains(completed, iteration) {
+				pending = append(pending, iteration)
+			}
+		}
+		details.Outputs[outputType] = OutputProgress{Completed: completed, Pending: pending}
+	}
+
+	return details, nil
+}
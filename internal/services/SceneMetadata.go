@@ -0,0 +1,119 @@
+// This file contains the editing of a scene's user metadata: its name, description, and tags.
+//
+// The name is set at upload, and the description and tags are empty until edited. Only the owner (and admins) may
+// edit them, as they are how the owner organizes their history. Tags are normalized to lower case without surrounding
+// whitespace, and duplicates are dropped, so filtering and display do not depend on how a tag was typed.
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Limits of a scene's user metadata, in characters
+const (
+	MaxSceneNameLength        = 128
+	MaxSceneDescriptionLength = 2000
+	MaxSceneTagLength         = 32
+	MaxSceneTags              = 20
+)
+
+// SceneMetadataEdit is the user metadata of a scene, as returned once edited.
+type SceneMetadataEdit struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+// UpdateSceneMetadata renames a scene, and sets its description and tags. Nil fields of update are left unchanged,
+// and an empty, non-nil Tags removes every tag. Returns the scene's metadata after the update.
+//
+// Returns ErrValidation if the name is empty, or a field is over its limit, user.ErrUserNoAccess if the user does not
+// own the scene, scene.ErrSceneNotFound if it does not exist, or error if an error occurred.
+func (s *ClientService) UpdateSceneMetadata(ctx context.Context, userID, sceneID primitive.ObjectID, update scene.SceneMetadataUpdate) (_ *SceneMetadataEdit, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.UpdateSceneMetadata", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Update scene metadata request received")
+
+	fields := make(map[string]string)
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if name == "" {
+			fields["name"] = "must not be empty"
+		} else if utf8.RuneCountInString(name) > MaxSceneNameLength {
+			fields["name"] = fmt.Sprintf("must be at most %d characters long", MaxSceneNameLength)
+		}
+		update.Name = &name
+	}
+	if update.Description != nil {
+		description := strings.TrimSpace(*update.Description)
+		if utf8.RuneCountInString(description) > MaxSceneDescriptionLength {
+			fields["description"] = fmt.Sprintf("must be at most %d characters long", MaxSceneDescriptionLength)
+		}
+		update.Description = &description
+	}
+	if update.Tags != nil {
+		tags, problem := normalizeSceneTags(update.Tags)
+		if problem != "" {
+			fields["tags"] = problem
+		}
+		update.Tags = tags
+	}
+	if len(fields) > 0 {
+		return nil, NewValidationError("invalid scene metadata", fields, nil)
+	}
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionEditMetadata); err != nil {
+		return nil, err
+	}
+	if err := s.sceneManager.UpdateSceneMetadata(ctx, sceneID, update); err != nil {
+		return nil, err
+	}
+
+	sc, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Ctx(ctx).Info("Scene metadata updated")
+	return &SceneMetadataEdit{
+		ID:          sceneID.Hex(),
+		Name:        sc.Name,
+		Description: sc.Description,
+		Tags:        append(make([]string, 0, len(sc.Tags)), sc.Tags...),
+	}, nil
+}
+
+// normalizeSceneTags normalizes tags, keeping the order they were first given in. Returns the problem of the tags,
+// empty if there is none.
+func normalizeSceneTags(tags []string) ([]string, string) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		switch {
+		case tag == "":
+			return nil, "must not contain empty tags"
+		case utf8.RuneCountInString(tag) > MaxSceneTagLength:
+			return nil, fmt.Sprintf("must each be at most %d characters long", MaxSceneTagLength)
+		case !slices.Contains(normalized, tag):
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > MaxSceneTags {
+		return nil, fmt.Sprintf("must have at most %d tags", MaxSceneTags)
+	}
+	return normalized, ""
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+func TestUpdateSceneMetadata(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := newTestUser(t, s, "alice")
+	bob := newTestUser(t, s, "bob")
+	sc := newTestScene(t, s, alice, "garden")
+
+	name, description := " Garden ", "the back garden"
+	edit, err := s.UpdateSceneMetadata(ctx, alice.ID, sc.ID, scene.SceneMetadataUpdate{
+		Name:        &name,
+		Description: &description,
+		Tags:        []string{"Outdoor", " outdoor", "plants"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit.Name != "Garden" || edit.Description != description || !slices.Equal(edit.Tags, []string{"outdoor", "plants"}) {
+		t.Errorf("edited metadata: %+v", edit)
+	}
+
+	// Omitted fields are kept
+	edit, err = s.UpdateSceneMetadata(ctx, alice.ID, sc.ID, scene.SceneMetadataUpdate{Tags: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit.Name != "Garden" || edit.Description != description || len(edit.Tags) != 0 {
+		t.Errorf("metadata after removing the tags: %+v", edit)
+	}
+
+	empty, long := " ", strings.Repeat("a", MaxSceneNameLength+1)
+	for _, update := range []scene.SceneMetadataUpdate{
+		{Name: &empty},
+		{Name: &long},
+		{Tags: []string{"ok", ""}},
+	} {
+		if _, err := s.UpdateSceneMetadata(ctx, alice.ID, sc.ID, update); !errors.Is(err, ErrValidation) {
+			t.Errorf("invalid update %+v: got %v, want ErrValidation", update, err)
+		}
+	}
+
+	if _, err := s.UpdateSceneMetadata(ctx, bob.ID, sc.ID, scene.SceneMetadataUpdate{Name: &name}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("update by another user: got %v, want ErrForbidden", err)
+	}
+}
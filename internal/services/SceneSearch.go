@@ -0,0 +1,74 @@
+// This file contains the search of a user's scenes by their name, description, and tags.
+//
+// Searches cover the same scenes as the user's history, and return the same summary of each. The text is matched
+// against whole words, see scene.SceneManager.EnsureIndexes, and results are sorted best match first. Tags are
+// matched exactly after the normalization they are stored with, see normalizeSceneTags, and a scene must have every
+// tag searched for.
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// MaxSearchTextLength is the longest search text, in characters.
+const MaxSearchTextLength = 256
+
+// SceneSearchQuery selects a page of the scenes matching a search. Page is 1-indexed.
+type SceneSearchQuery struct {
+	// words to match against the name, description, and tags of scenes. Empty matches every scene
+	Text string
+	// tags every matched scene has
+	Tags []string
+	// only scenes in this stage, one of the HistoryStage constants. Empty matches every stage
+	Stage    string
+	Page     int
+	PageSize int
+}
+
+// SearchScenes returns a page of the user's scenes matching the query, summarized as in GetUserHistory. Scenes are
+// sorted best match first when searching by text, and newest first otherwise.
+//
+// Returns ErrValidation if the query has neither text nor tags, the text is too long, a tag is invalid, or the stage
+// is unknown, or user.ErrUserNotFound if the user does not exist.
+func (s *ClientService) SearchScenes(ctx context.Context, userID primitive.ObjectID, query SceneSearchQuery) (_ *HistoryPage, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.SearchScenes", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Search scenes request received")
+
+	text := strings.TrimSpace(query.Text)
+	if utf8.RuneCountInString(text) > MaxSearchTextLength {
+		return nil, NewValidationError(
+			"search text is too long",
+			map[string]string{"q": fmt.Sprintf("must be at most %d characters long", MaxSearchTextLength)},
+			nil,
+		)
+	}
+	tags, problem := normalizeSceneTags(query.Tags)
+	if problem != "" {
+		return nil, NewValidationError("invalid search tags", map[string]string{"tags": problem}, nil)
+	}
+	if text == "" && len(tags) == 0 {
+		return nil, NewValidationError(
+			"search text or tags are required",
+			map[string]string{"q": "is required unless tags are given"},
+			nil,
+		)
+	}
+	states, err := historyStatesOf(query.Stage)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := scene.SceneListFilter{Text: text, Tags: tags, States: states}
+	return s.userScenesPage(ctx, userID, filter, query.Page, query.PageSize)
+}
@@ -0,0 +1,83 @@
+// This file contains access to the previous training runs of scenes, see scene/Versions.go.
+//
+// The metadata and output of a scene are those of its current run unless a version is requested. Previous runs are
+// read from the scene document and stat'ed on every request rather than cached, as they are rarely requested once a
+// scene was retrained. Their outputs are always final, as nothing writes to them anymore.
+
+package services
+
+import (
+	"context"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+// HistoryVersion is a training run of a scene in a user's history.
+type HistoryVersion struct {
+	Version         int                   `json:"version"`
+	Stage           string                `json:"stage"`
+	State           scene.State           `json:"state,omitempty"`
+	LatestIteration int                   `json:"latest_iteration"`
+	Config          *HistoryConfigSummary `json:"config,omitempty"`
+}
+
+// previousRun returns the previous training run of a scene with the given version, or nil if version is 0 or that of
+// the current run, which is read as usual.
+//
+// Returns scene.ErrRunNotFound if the scene has no such run, or scene.ErrNerfNotFound if the run has no output.
+func (s *ClientService) previousRun(ctx context.Context, sceneID primitive.ObjectID, version int) (*scene.TrainingRun, error) {
+	if version == 0 {
+		return nil, nil
+	}
+	sc, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	if version == sc.CurrentVersion() {
+		return nil, nil
+	}
+	run, err := sc.Run(version)
+	if err != nil {
+		return nil, err
+	}
+	if run.Nerf == nil || run.Config == nil {
+		return nil, scene.ErrNerfNotFound
+	}
+	return run, nil
+}
+
+// runResources returns the state of every output file of a training run of a scene, as sceneResources does for the
+// current run. version 0 selects the current run.
+func (s *ClientService) runResources(ctx context.Context, sceneID primitive.ObjectID, version int) (map[string]map[int]resourceStat, error) {
+	run, err := s.previousRun(ctx, sceneID, version)
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return s.sceneResources(ctx, sceneID)
+	}
+	return statResources(run.Config, run.Nerf)
+}
+
+// historyVersions summarizes every training run of a scene, oldest first, or returns nil for scenes that were never
+// retrained as a new run.
+func historyVersions(sc *scene.Scene) []HistoryVersion {
+	if len(sc.Runs) == 0 {
+		return nil
+	}
+	runs := append(slices.Clone(sc.Runs), scene.TrainingRun{Version: sc.CurrentVersion(), Config: sc.Config, Status: sc.Status})
+	versions := make([]HistoryVersion, 0, len(runs))
+	for _, run := range runs {
+		version := HistoryVersion{Version: run.Version, Config: historyConfigSummary(run.Config)}
+		if run.Status != nil {
+			version.State = run.Status.State
+			version.Stage = historyStageOf(run.Status.State)
+			version.LatestIteration = run.Status.LatestIteration
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
@@ -0,0 +1,158 @@
+// This file contains the sessions of users, i.e the logins whose refresh tokens can still be exchanged, see Tokens.go.
+//
+// A session is the family of refresh tokens rotated from one login, and is identified by the family ID. Only the
+// current token of a family can be exchanged, so the sessions of a user are read from their unused, unexpired tokens,
+// which record the client they were last issued to. Revoking a session revokes its refresh token, so a stolen token
+// can be invalidated without changing the password. The access tokens of a revoked session are not stored, and stay
+// valid until they expire.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// ErrSessionNotFound is returned when a user has no active session with the given ID.
+var ErrSessionNotFound = errors.New("session not found")
+
+// maxUserAgentLength is the most bytes of a user agent recorded with a session.
+const maxUserAgentLength = 256
+
+// userAgentKey is the context key of the user agent recorded with sessions.
+type userAgentKey struct{}
+
+// WithUserAgent returns a copy of ctx carrying the user agent of the client making a request, which the sessions
+// started or refreshed with it record.
+func WithUserAgent(ctx context.Context, userAgent string) context.Context {
+	return context.WithValue(ctx, userAgentKey{}, userAgent)
+}
+
+// userAgent returns the user agent carried by ctx, truncated to maxUserAgentLength, or "" if there is none.
+func userAgent(ctx context.Context) string {
+	userAgent, _ := ctx.Value(userAgentKey{}).(string)
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return userAgent
+}
+
+// Session is an active login of a user.
+type Session struct {
+	ID primitive.ObjectID `json:"id"`
+	// when the user logged in
+	StartedAt time.Time `json:"started_at"`
+	// when the session last refreshed its tokens, or logged in
+	LastUsedAt time.Time `json:"last_used_at"`
+	// when the session ends unless it refreshes its tokens
+	ExpiresAt time.Time `json:"expires_at"`
+	// client that last used the session
+	UserAgent string `json:"user_agent,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	// whether the session is that of the request
+	Current bool `json:"current"`
+}
+
+// ListSessions returns the active sessions of the user with the given ID, most recently used first. The session with
+// the ID currentSessionID, that of the request's access token, is marked as current.
+func (s *ClientService) ListSessions(ctx context.Context, userID, currentSessionID primitive.ObjectID) (_ []Session, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.ListSessions", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("List sessions request received")
+
+	if _, err := s.userManager.GetUserByID(ctx, userID); err != nil {
+		return nil, err
+	}
+	tokens, err := s.tokenManager.ListActiveTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(tokens))
+	for _, t := range tokens {
+		startedAt := t.LoginAt
+		if startedAt.IsZero() {
+			startedAt = t.CreatedAt
+		}
+		sessions = append(sessions, Session{
+			ID:         t.FamilyID,
+			StartedAt:  startedAt,
+			LastUsedAt: t.CreatedAt,
+			ExpiresAt:  t.ExpiresAt,
+			UserAgent:  t.UserAgent,
+			IP:         t.IP,
+			Current:    !currentSessionID.IsZero() && t.FamilyID == currentSessionID,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession logs out a session of the user with the given ID, so its refresh token can not be exchanged anymore.
+//
+// Returns ErrSessionNotFound if the user has no active session with the given ID.
+func (s *ClientService) RevokeSession(ctx context.Context, userID, sessionID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RevokeSession", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Revoke session request received")
+
+	revoked, err := s.tokenManager.RevokeUserFamily(ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrSessionNotFound
+	}
+	s.sceneCache.delete(ctx, sessionActiveKey(sessionID))
+	s.logger.Ctx(ctx).Infof("Revoked session %s of user %s", sessionID.Hex(), userID.Hex())
+	s.audit.Record(ctx, userID, primitive.NilObjectID, audit.ActionRevokeSession, audit.OutcomeAllowed, sessionID.Hex())
+	return nil
+}
+
+// RevokeAllSessions logs out every session of the user with the given ID, i.e "log out everywhere", except the
+// session with the ID keepSessionID if it is not nil. Returns how many sessions were revoked.
+func (s *ClientService) RevokeAllSessions(ctx context.Context, userID, keepSessionID primitive.ObjectID) (_ int64, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RevokeAllSessions", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Revoke all sessions request received")
+
+	if _, err := s.userManager.GetUserByID(ctx, userID); err != nil {
+		return 0, err
+	}
+	// The sessions are listed first to drop them from the cache, see VerifySession
+	active, err := s.tokenManager.ListActiveTokens(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	var revoked int64
+	if keepSessionID.IsZero() {
+		revoked, err = s.tokenManager.RevokeUser(ctx, userID)
+	} else {
+		revoked, err = s.tokenManager.RevokeUserExcept(ctx, userID, keepSessionID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range active {
+		if t.FamilyID != keepSessionID {
+			s.sceneCache.delete(ctx, sessionActiveKey(t.FamilyID))
+		}
+	}
+
+	detail := "every session"
+	if !keepSessionID.IsZero() {
+		detail = fmt.Sprintf("every session except %s", keepSessionID.Hex())
+	}
+	s.logger.Ctx(ctx).Infof("Revoked %d sessions of user %s", revoked, userID.Hex())
+	s.audit.Record(ctx, userID, primitive.NilObjectID, audit.ActionRevokeAllSessions, audit.OutcomeAllowed, detail)
+	return revoked, nil
+}
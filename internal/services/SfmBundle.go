@@ -0,0 +1,484 @@
+// This file contains uploads of pre-computed sfm output, for users who already recovered camera poses (i.e with COLMAP)
+// and only want the scene trained.
+//
+// A bundle is a set of PNG or JPEG images and a camera poses file in the transforms.json format of instant-ngp and
+// nerfstudio. The bundle is validated in full before anything is stored, so an invalid bundle leaves nothing behind and
+// publishes nothing. A valid bundle is stored in the scene's layout like sfm-worker output, and the scene is created in
+// scene.StateSfmDone with its sfm output marked as pre-computed, so the training job is published right away.
+//
+// The transform matrices are passed to the nerf-worker as they are uploaded, so they must follow the conventions of
+// the sfm-worker's output.
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrMalformedPoses is returned when the camera poses file of a bundle is not valid JSON.
+	ErrMalformedPoses = errors.New("camera poses file is not valid JSON")
+	// ErrMissingPoseField is returned when the camera poses file of a bundle is missing a required field.
+	ErrMissingPoseField = errors.New("camera poses file is missing a required field")
+	// ErrInvalidPoseField is returned when a field of the camera poses file of a bundle has an invalid value.
+	ErrInvalidPoseField = errors.New("camera poses file has an invalid value")
+	// ErrBundleImageMismatch is returned when the images of a bundle do not match the frames of its camera poses.
+	ErrBundleImageMismatch = errors.New("images do not match the camera poses")
+	// ErrBadBundleImage is returned when an image of a bundle is not a readable PNG or JPEG image.
+	ErrBadBundleImage = errors.New("image is not a valid PNG or JPEG image")
+)
+
+// maxPosesFileSize is the largest camera poses file accepted.
+const maxPosesFileSize = 16 * 1024 * 1024
+
+// bundleImageExts are the image file extensions accepted in a bundle.
+var bundleImageExts = []string{".png", ".jpg", ".jpeg"}
+
+// cameraPoses is the transforms.json camera poses file of a bundle. Pointers tell missing fields from zero values.
+//
+// The focal length is given either in pixels with fl_x, or as the horizontal field of view with camera_angle_x. fl_y
+// defaults to fl_x, and the principal point defaults to the image center.
+type cameraPoses struct {
+	Width           *int           `json:"w"`
+	Height          *int           `json:"h"`
+	FocalX          *float64       `json:"fl_x"`
+	FocalY          *float64       `json:"fl_y"`
+	CameraAngleX    *float64       `json:"camera_angle_x"`
+	CenterX         *float64       `json:"cx"`
+	CenterY         *float64       `json:"cy"`
+	WhiteBackground bool           `json:"white_background"`
+	Frames          []*cameraFrame `json:"frames"`
+}
+
+// cameraFrame is a single frame of a cameraPoses file. FilePath names the frame's image, relative to the poses file.
+type cameraFrame struct {
+	FilePath        string      `json:"file_path"`
+	TransformMatrix [][]float64 `json:"transform_matrix"`
+}
+
+// parseCameraPoses reads and validates a camera poses file, and returns it with its intrinsic matrix.
+//
+// Returns ErrMalformedPoses, ErrMissingPoseField, or ErrInvalidPoseField if the file is invalid.
+func parseCameraPoses(r io.Reader) (*cameraPoses, [][]float64, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxPosesFileSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > maxPosesFileSize {
+		return nil, nil, fmt.Errorf("%w: file is larger than %d bytes", ErrInvalidPoseField, maxPosesFileSize)
+	}
+
+	var poses cameraPoses
+	if err := json.Unmarshal(data, &poses); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrMalformedPoses, err)
+	}
+
+	switch {
+	case poses.Width == nil:
+		return nil, nil, fmt.Errorf("%w: w", ErrMissingPoseField)
+	case poses.Height == nil:
+		return nil, nil, fmt.Errorf("%w: h", ErrMissingPoseField)
+	case poses.FocalX == nil && poses.CameraAngleX == nil:
+		return nil, nil, fmt.Errorf("%w: fl_x or camera_angle_x", ErrMissingPoseField)
+	case len(poses.Frames) == 0:
+		return nil, nil, fmt.Errorf("%w: frames", ErrMissingPoseField)
+	case *poses.Width <= 0 || *poses.Height <= 0:
+		return nil, nil, fmt.Errorf("%w: w and h must be positive", ErrInvalidPoseField)
+	}
+
+	width, height := float64(*poses.Width), float64(*poses.Height)
+	var fx float64
+	if poses.FocalX != nil {
+		fx = *poses.FocalX
+	} else {
+		if *poses.CameraAngleX <= 0 || *poses.CameraAngleX >= math.Pi {
+			return nil, nil, fmt.Errorf("%w: camera_angle_x must be between 0 and pi", ErrInvalidPoseField)
+		}
+		fx = 0.5 * width / math.Tan(0.5**poses.CameraAngleX)
+	}
+	fy, cx, cy := fx, width/2, height/2
+	if poses.FocalY != nil {
+		fy = *poses.FocalY
+	}
+	if poses.CenterX != nil {
+		cx = *poses.CenterX
+	}
+	if poses.CenterY != nil {
+		cy = *poses.CenterY
+	}
+	if fx <= 0 || fy <= 0 {
+		return nil, nil, fmt.Errorf("%w: focal length must be positive", ErrInvalidPoseField)
+	}
+
+	for i, frame := range poses.Frames {
+		if frame == nil || frame.FilePath == "" {
+			return nil, nil, fmt.Errorf("%w: frames[%d].file_path", ErrMissingPoseField, i)
+		}
+		if frame.TransformMatrix == nil {
+			return nil, nil, fmt.Errorf("%w: frames[%d].transform_matrix", ErrMissingPoseField, i)
+		}
+		if !isMatrix(frame.TransformMatrix, 4, 4) {
+			return nil, nil, fmt.Errorf("%w: frames[%d].transform_matrix must be 4x4", ErrInvalidPoseField, i)
+		}
+	}
+
+	intrinsic := [][]float64{{fx, 0, cx}, {0, fy, cy}, {0, 0, 1}}
+	return &poses, intrinsic, nil
+}
+
+// isMatrix checks if m has the given number of rows and columns, and only finite values.
+func isMatrix(m [][]float64, rows, cols int) bool {
+	if len(m) != rows {
+		return false
+	}
+	for _, row := range m {
+		if len(row) != cols {
+			return false
+		}
+		for _, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchBundleImages pairs every frame of poses with the uploaded image it names, in frame order. Frames name images by
+// file name, with or without extension, as datasets exported by different tools do both. Every image must be used by
+// exactly one frame.
+//
+// Returns ErrBundleImageMismatch if a frame names no uploaded image, or the image count does not match the frames.
+func matchBundleImages(poses *cameraPoses, images []*multipart.FileHeader) ([]*multipart.FileHeader, error) {
+	if len(images) != len(poses.Frames) {
+		return nil, fmt.Errorf("%w: %d images for %d frames", ErrBundleImageMismatch, len(images), len(poses.Frames))
+	}
+
+	byName := make(map[string]*multipart.FileHeader, len(images))
+	byStem := make(map[string]*multipart.FileHeader, len(images))
+	for _, img := range images {
+		name := filepath.Base(img.Filename)
+		if _, ok := byName[name]; ok {
+			return nil, fmt.Errorf("%w: image %s uploaded twice", ErrBundleImageMismatch, name)
+		}
+		byName[name] = img
+		byStem[strings.TrimSuffix(name, filepath.Ext(name))] = img
+	}
+
+	matched := make([]*multipart.FileHeader, len(poses.Frames))
+	used := make(map[*multipart.FileHeader]bool, len(images))
+	for i, frame := range poses.Frames {
+		name := filepath.Base(filepath.ToSlash(frame.FilePath))
+		img, ok := byName[name]
+		if !ok {
+			img, ok = byStem[name]
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: no image for frame %s", ErrBundleImageMismatch, frame.FilePath)
+		}
+		if used[img] {
+			return nil, fmt.Errorf("%w: image %s used by more than one frame", ErrBundleImageMismatch, img.Filename)
+		}
+		used[img] = true
+		matched[i] = img
+	}
+	return matched, nil
+}
+
+// checkBundleImage checks that an uploaded image is a PNG or JPEG image of the given size, reading only its header.
+//
+// Returns ErrBadBundleImage if it is not a readable image, or ErrBundleImageMismatch if its size does not match.
+func checkBundleImage(file *multipart.FileHeader, width, height int) error {
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if !slices.Contains(bundleImageExts, ext) {
+		return fmt.Errorf("%w: %s must be a .png, .jpg, or .jpeg file", ErrBadBundleImage, file.Filename)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	config, _, err := image.DecodeConfig(src)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBadBundleImage, file.Filename)
+	}
+	if config.Width != width || config.Height != height {
+		return fmt.Errorf("%w: %s is %dx%d, camera poses are for %dx%d",
+			ErrBundleImageMismatch, file.Filename, config.Width, config.Height, width, height)
+	}
+	return nil
+}
+
+// saveUploadedFile copies an uploaded file to path, and returns the number of bytes written.
+func saveUploadedFile(file *multipart.FileHeader, path string) (int64, error) {
+	src, err := file.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// HandleIncomingBundle processes images and a camera poses file uploaded by the user, and publishes the scene's
+// training job, skipping the sfm stage.
+//
+// If a training config value is not provided, a default value is used, as with HandleIncomingVideo. Frame sampling
+// does not apply, as every uploaded image is used.
+//
+// Returns the scene ID if successful, error otherwise. Returns ErrMalformedPoses, ErrMissingPoseField, or
+// ErrInvalidPoseField if the camera poses are invalid, ErrBundleImageMismatch if the images do not match them, and
+// ErrBadBundleImage if an image cannot be read. Returns ErrVideoResolutionTooHigh, ErrVideoTooManyFrames, or
+// ErrVideoTooFewFrames if the images are outside the limits of the training mode. Returns the priority errors of
+// resolvePriority, ErrContentRejected or ErrScannerUnavailable if an image is rejected by the content scanner, and
+// ErrAtCapacity if uploads are rejected while the most jobs allowed are in flight, or the errors of checkUploadQuota
+// if the user is out of quota. Nothing is stored or published for a rejected bundle. The scene is uploaded into the
+// workspace of orgID if it is not nil, as with HandleIncomingVideo.
+//
+// The training job is published directly, bypassing the grace period and admission queue like a retrained scene. If it
+// fails to publish, it is retried and the upload still succeeds, see AMPQService.DeferTrainingJob.
+func (s *ClientService) HandleIncomingBundle(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	orgID primitive.ObjectID,
+	posesFile *multipart.FileHeader,
+	images []*multipart.FileHeader,
+	trainingMode string,
+	outputTypes []string,
+	saveIterations []int,
+	totalIterations int,
+	sceneName string,
+	priority string,
+) (_ string, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.HandleIncomingBundle", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	start := time.Now()
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		s.metrics.UploadDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	}()
+
+	if posesFile == nil {
+		return "", NewValidationError("camera poses not received", map[string]string{"poses": "required"}, nil)
+	}
+	if len(images) == 0 {
+		return "", NewValidationError("images not received", map[string]string{"images": "required"}, nil)
+	}
+
+	applyUploadDefaults(&sceneName, &trainingMode, &outputTypes, &saveIterations, &priority)
+	nerfConfig := &scene.NerfTrainingConfig{
+		TrainingMode:    trainingMode,
+		OutputTypes:     outputTypes,
+		SaveIterations:  saveIterations,
+		TotalIterations: totalIterations,
+	}
+	if err := s.trainingLimits.Check(nerfConfig); err != nil {
+		return "", err
+	}
+
+	// Validate the whole bundle before anything is stored
+	src, err := posesFile.Open()
+	if err != nil {
+		return "", err
+	}
+	poses, intrinsic, err := parseCameraPoses(src)
+	src.Close()
+	if err != nil {
+		s.logger.Infof("Rejected bundle upload: %v", err)
+		return "", err
+	}
+
+	width, height := *poses.Width, *poses.Height
+	probe := &VideoProbe{Width: width, Height: height, FrameCount: len(poses.Frames)}
+	if err := s.videoLimits[trainingMode].Check(probe); err != nil {
+		s.logger.Infof("Rejected bundle upload: %v", err)
+		return "", err
+	}
+
+	frameImages, err := matchBundleImages(poses, images)
+	if err != nil {
+		s.logger.Infof("Rejected bundle upload: %v", err)
+		return "", err
+	}
+	for _, img := range frameImages {
+		if err := checkBundleImage(img, width, height); err != nil {
+			s.logger.Infof("Rejected bundle upload: %v", err)
+			return "", err
+		}
+	}
+
+	uploadSize := posesFile.Size
+	for _, img := range frameImages {
+		uploadSize += img.Size
+	}
+	if err := s.checkOrgUpload(ctx, userID, orgID); err != nil {
+		return "", err
+	}
+	if err := s.checkUploadQuota(ctx, userID, uploadSize); err != nil {
+		return "", err
+	}
+	if err := s.mqService.CheckAdmission(ctx); err != nil {
+		return "", err
+	}
+	priority, tier, err := s.resolvePriority(ctx, userID, priority)
+	if err != nil {
+		return "", err
+	}
+
+	sceneID := primitive.NewObjectID()
+
+	// Store the bundle in the scene's layout. A rejected bundle removes the whole scene directory, as nothing else is in it yet.
+	sceneDir := s.sceneManager.SceneDir(sceneID)
+	sfm, bundleSize, contentScan, err := s.storeBundle(ctx, sceneID, posesFile, poses, frameImages, intrinsic)
+	if err != nil {
+		os.RemoveAll(sceneDir)
+		if errors.Is(err, ErrContentRejected) || errors.Is(err, ErrScannerUnavailable) {
+			s.logger.Infof("Rejected bundle upload: %v", err)
+		}
+		return "", err
+	}
+
+	now := time.Now()
+	newScene := &scene.Scene{
+		ID: sceneID,
+		// There is no video, only the properties of the images it would have been sampled to
+		Video: &scene.Video{
+			Width:      width,
+			Height:     height,
+			FrameCount: len(frameImages),
+			FileSize:   bundleSize,
+		},
+		Sfm: sfm,
+		Config: &scene.TrainingConfig{
+			NerfTrainingConfig: nerfConfig,
+			Priority:           priority,
+			Tier:               tier,
+		},
+		Status: &scene.SceneStatus{
+			State:     scene.StateSfmDone,
+			UpdatedAt: now,
+			EnteredAt: map[scene.State]time.Time{scene.StateSfmDone: now},
+		},
+		Name:        sceneName,
+		OrgID:       orgID,
+		Traceparent: tracing.Traceparent(ctx),
+		ContentScan: contentScan,
+	}
+
+	err = s.sceneManager.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.sceneManager.CreateScene(ctx, newScene); err != nil {
+			return err
+		}
+		owner, err := s.userManager.GetUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := owner.AddScene(sceneID); err != nil {
+			return err
+		}
+		if err := s.userManager.UpdateUser(ctx, owner); err != nil {
+			return err
+		}
+		return s.userManager.IncrementStorageUsed(ctx, userID, bundleSize)
+	})
+	if err != nil {
+		s.logger.Errorf("Failed to insert new scene into database: %v", err)
+		os.RemoveAll(sceneDir)
+		return "", err
+	}
+
+	s.recordUpload(ctx, userID, sceneID, "bundle")
+	s.metrics.UploadsTotal.WithLabelValues(trainingMode).Inc()
+	s.metrics.UploadBytesTotal.WithLabelValues(trainingMode).Add(float64(bundleSize))
+	s.metrics.UploadSize.WithLabelValues(trainingMode).Observe(float64(bundleSize))
+
+	// The scene is committed, so a job that fails to publish is retried rather than failing the upload
+	if err := s.mqService.PublishTrainingJob(ctx, newScene); err != nil {
+		s.logger.Errorf("Failed to publish training job for scene %s, retrying: %v", sceneID.Hex(), err)
+		s.mqService.DeferTrainingJob(ctx, sceneID)
+	}
+
+	s.logger.Infof("Scene %s uploaded with %d pre-computed camera poses", sceneID.Hex(), len(frameImages))
+	return sceneID.Hex(), nil
+}
+
+// storeBundle stores a validated bundle in the scene's layout and scans the stored images, and returns the scene's
+// sfm output, the bytes stored, and the verdict of the scan. Frames are stored as sfm-worker output is, and served
+// from the same URLs.
+func (s *ClientService) storeBundle(ctx context.Context, sceneID primitive.ObjectID, posesFile *multipart.FileHeader, poses *cameraPoses, frameImages []*multipart.FileHeader, intrinsic [][]float64) (*scene.Sfm, int64, *scene.ContentScan, error) {
+	posesPath := s.sceneManager.RawPosesPath(sceneID)
+	if err := os.MkdirAll(filepath.Dir(posesPath), os.ModePerm); err != nil {
+		return nil, 0, nil, err
+	}
+	if err := os.MkdirAll(s.sceneManager.SfmDir(sceneID), os.ModePerm); err != nil {
+		return nil, 0, nil, err
+	}
+
+	size, err := saveUploadedFile(posesFile, posesPath)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	sfm := &scene.Sfm{
+		IntrinsicMatrix: intrinsic,
+		Frames:          make([]scene.Frame, len(frameImages)),
+		WhiteBackground: poses.WhiteBackground,
+		Precomputed:     true,
+	}
+	var contentScan *scene.ContentScan
+	for i, img := range frameImages {
+		framePath, err := s.sceneManager.SfmFramePath(sceneID, filepath.Base(img.Filename))
+		if err != nil {
+			return nil, 0, nil, NewValidationError("invalid image file name", map[string]string{"images": "invalid file name " + img.Filename}, err)
+		}
+		written, err := saveUploadedFile(img, framePath)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		frameScan, err := s.scanUpload(ctx, framePath)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		contentScan = mergeContentScans(contentScan, frameScan)
+		size += written
+
+		sfm.Frames[i] = scene.Frame{
+			FilePath:        s.mqService.toAPIUrl(framePath),
+			ExtrinsicMatrix: poses.Frames[i].TransformMatrix,
+		}
+	}
+	return sfm, size, contentScan, nil
+}
@@ -0,0 +1,269 @@
+// This file contains public share links, which give anyone holding one read-only access to a scene's metadata and
+// outputs without an account.
+//
+// A share link token names the scene, the link, and optionally an expiry, all covered by an HMAC-SHA256 signature made
+// with the resource URL key, as upload tokens are. The link itself is stored on the scene (see scene.ShareLink), and a
+// token is only accepted while its link is, so revoking a link invalidates its token immediately. Tokens are derived
+// from the stored link, so listing a scene's links returns the same tokens that were handed out.
+//
+// Access through a share link is recorded in the audit log without a user, with the link ID as detail.
+
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrInvalidShareLink is returned when a share link token was not issued by this server, was altered, or its link
+	// was revoked.
+	ErrInvalidShareLink = errors.New("invalid share link")
+	// ErrShareLinkExpired is returned when a share link is used after its expiry.
+	ErrShareLinkExpired = errors.New("share link expired")
+	// ErrTooManyShareLinks is returned when creating a share link for a scene that already has MaxShareLinks.
+	ErrTooManyShareLinks = errors.New("scene has too many share links, revoke one first")
+)
+
+// Share link limits
+const (
+	// MaxShareLinkTTL is the longest lifetime an expiring share link may have.
+	MaxShareLinkTTL = 365 * 24 * time.Hour
+	// MaxShareLinks is the most share links a scene may have at once.
+	MaxShareLinks = 20
+)
+
+// shareLinkClaims is the signed payload of a share link token.
+type shareLinkClaims struct {
+	SceneID primitive.ObjectID `json:"sid"`
+	LinkID  primitive.ObjectID `json:"lid"`
+	Expires int64              `json:"exp,omitempty"`
+}
+
+// ShareLinkToken is a share link of a scene, along with its token.
+type ShareLinkToken struct {
+	scene.ShareLink
+	Token string `json:"token"`
+}
+
+// shareLinkSignature returns the hex HMAC-SHA256 of an encoded share link payload. As with uploadTokenSignature, the
+// signed text is prefixed so signatures made for another purpose with the same key are never valid share links.
+func shareLinkSignature(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "share-v1\n%s", payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// shareLinkToken returns the signed token of a share link of the given scene.
+func (s *ClientService) shareLinkToken(sceneID primitive.ObjectID, link scene.ShareLink) (string, error) {
+	claims := shareLinkClaims{SceneID: sceneID, LinkID: link.ID}
+	if !link.ExpiresAt.IsZero() {
+		claims.Expires = link.ExpiresAt.Unix()
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + shareLinkSignature(s.resourceURLKey, payload), nil
+}
+
+// CreateShareLink creates a share link giving read-only access to a scene the user owns, without an account.
+// A ttl <= 0 creates a link that never expires.
+//
+// Returns ErrValidation if ttl exceeds MaxShareLinkTTL, ErrTooManyShareLinks if the scene already has MaxShareLinks,
+// or error if the user does not own the scene or an error occurred.
+func (s *ClientService) CreateShareLink(ctx context.Context, userID, sceneID primitive.ObjectID, ttl time.Duration) (_ *ShareLinkToken, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.CreateShareLink", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Create share link request received")
+
+	if len(s.resourceURLKey) == 0 {
+		return nil, errors.New("share link signing key not configured")
+	}
+	if ttl > MaxShareLinkTTL {
+		return nil, NewValidationError(
+			fmt.Sprintf("share link lifetime may be at most %s", MaxShareLinkTTL),
+			map[string]string{"ttl": fmt.Sprintf("must be at most %d seconds", int(MaxShareLinkTTL.Seconds()))},
+			nil,
+		)
+	}
+
+	// Verify user access to scene
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionShare); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+
+	links, err := s.sceneManager.GetShareLinks(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) >= MaxShareLinks {
+		return nil, newError(ErrQuotaExceeded, ErrTooManyShareLinks.Error(), ErrTooManyShareLinks)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	link := scene.ShareLink{
+		ID:        primitive.NewObjectID(),
+		CreatedBy: userID,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		link.ExpiresAt = now.Add(ttl)
+	}
+	token, err := s.shareLinkToken(sceneID, link)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sceneManager.AddShareLink(ctx, sceneID, link); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to add share link to scene %s: %v", sceneID.Hex(), err)
+		return nil, err
+	}
+
+	s.logger.Ctx(ctx).Infof("Created share link %s for scene %s", link.ID.Hex(), sceneID.Hex())
+	return &ShareLinkToken{ShareLink: link, Token: token}, nil
+}
+
+// GetShareLinks returns the share links of a scene the user owns, with their tokens.
+//
+// Returns error if the user does not own the scene or an error occurred.
+func (s *ClientService) GetShareLinks(ctx context.Context, userID, sceneID primitive.ObjectID) (_ []ShareLinkToken, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetShareLinks", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get share links request received")
+
+	// Verify user access to scene
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionShare); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+
+	links, err := s.sceneManager.GetShareLinks(ctx, sceneID)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]ShareLinkToken, 0, len(links))
+	for _, link := range links {
+		token, err := s.shareLinkToken(sceneID, link)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, ShareLinkToken{ShareLink: link, Token: token})
+	}
+	return tokens, nil
+}
+
+// RevokeShareLink revokes a share link of a scene the user owns. Its token is rejected from then on.
+//
+// Returns scene.ErrShareLinkNotFound if the scene has no such link, or error if the user does not own the scene or an
+// error occurred.
+func (s *ClientService) RevokeShareLink(ctx context.Context, userID, sceneID, linkID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RevokeShareLink", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Revoke share link request received")
+
+	// Verify user access to scene
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionShare); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return err
+	}
+
+	if err := s.sceneManager.RemoveShareLink(ctx, sceneID, linkID); err != nil {
+		s.logger.Ctx(ctx).Info("Failed to revoke share link:", err.Error())
+		return err
+	}
+
+	s.logger.Ctx(ctx).Infof("Revoked share link %s of scene %s", linkID.Hex(), sceneID.Hex())
+	return nil
+}
+
+// resolveShareLink validates the signature and expiry of a share link token, and that its link has not been revoked.
+// The attempt to perform action with the token is recorded in the audit log.
+//
+// Returns the ID of the scene the token gives access to. Returns ErrInvalidShareLink if the token is malformed, its
+// signature does not match, its link was revoked, or its scene is in the trash, or ErrShareLinkExpired if it expired.
+func (s *ClientService) resolveShareLink(ctx context.Context, token, action string) (primitive.ObjectID, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || len(s.resourceURLKey) == 0 || !hmac.Equal([]byte(signature), []byte(shareLinkSignature(s.resourceURLKey, payload))) {
+		return primitive.NilObjectID, ErrInvalidShareLink
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidShareLink
+	}
+	var claims shareLinkClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return primitive.NilObjectID, ErrInvalidShareLink
+	}
+
+	detail := "share link " + claims.LinkID.Hex()
+	if claims.Expires != 0 && time.Now().After(time.Unix(claims.Expires, 0)) {
+		s.audit.Record(ctx, primitive.NilObjectID, claims.SceneID, action, audit.OutcomeDenied, detail+": "+ErrShareLinkExpired.Error())
+		return primitive.NilObjectID, ErrShareLinkExpired
+	}
+
+	_, err = s.sceneManager.GetShareLink(ctx, claims.SceneID, claims.LinkID)
+	if err == nil {
+		err = s.verifyNotTrashed(ctx, claims.SceneID)
+	}
+	if errors.Is(err, scene.ErrShareLinkNotFound) || errors.Is(err, scene.ErrSceneNotFound) || errors.Is(err, scene.ErrSceneInTrash) {
+		err = ErrInvalidShareLink
+	}
+	if err != nil {
+		s.audit.Record(ctx, primitive.NilObjectID, claims.SceneID, action, audit.OutcomeDenied, detail+": "+err.Error())
+		return primitive.NilObjectID, err
+	}
+
+	s.audit.Record(ctx, primitive.NilObjectID, claims.SceneID, action, audit.OutcomeAllowed, detail)
+	return claims.SceneID, nil
+}
+
+// GetSharedSceneMetadata returns the metadata of the scene a share link token gives access to, as GetSceneMetadata does.
+//
+// Returns ErrInvalidShareLink or ErrShareLinkExpired if the token is not valid, or error if an error occurred.
+func (s *ClientService) GetSharedSceneMetadata(ctx context.Context, token string, chunkSize int64) (_ *SceneMetadata, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetSharedSceneMetadata", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	sceneID, err := s.resolveShareLink(ctx, token, audit.ActionReadMetadata)
+	if err != nil {
+		return nil, err
+	}
+	return s.sceneMetadata(ctx, sceneID, 0, s.ResolveChunkSize(chunkSize), "")
+}
+
+// GetSharedSceneOutput returns an output file of the scene a share link token gives access to, as GetSceneOutput does.
+//
+// Returns ErrInvalidShareLink or ErrShareLinkExpired if the token is not valid, or error if an error occurred.
+func (s *ClientService) GetSharedSceneOutput(ctx context.Context, token, outputType, iteration, format string) (_ *SceneOutput, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetSharedSceneOutput", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	sceneID, err := s.resolveShareLink(ctx, token, audit.ActionDownload)
+	if err != nil {
+		return nil, err
+	}
+	return s.sceneOutput(ctx, sceneID, outputType, iteration, format, 0)
+}
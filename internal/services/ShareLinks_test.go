@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+func TestRevokeShareLink(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := newTestUser(t, s, "alice")
+	bob := newTestUser(t, s, "bob")
+	sc := newTestScene(t, s, alice, "garden")
+
+	revoked, err := s.CreateShareLink(ctx, alice.ID, sc.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := s.CreateShareLink(ctx, alice.ID, sc.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []*ShareLinkToken{revoked, kept} {
+		if sceneID, err := s.resolveShareLink(ctx, link.Token, audit.ActionReadMetadata); err != nil || sceneID != sc.ID {
+			t.Fatalf("active link: got scene %s, %v, want scene %s", sceneID.Hex(), err, sc.ID.Hex())
+		}
+	}
+
+	// Only the owner revokes links
+	if err := s.RevokeShareLink(ctx, bob.ID, sc.ID, revoked.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("revoked by another user: got %v, want ErrForbidden", err)
+	}
+	if err := s.RevokeShareLink(ctx, alice.ID, sc.ID, revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.resolveShareLink(ctx, revoked.Token, audit.ActionReadMetadata); !errors.Is(err, ErrInvalidShareLink) {
+		t.Errorf("revoked link: got %v, want ErrInvalidShareLink", err)
+	}
+	if err := s.RevokeShareLink(ctx, alice.ID, sc.ID, revoked.ID); !errors.Is(err, ErrNotFound) || !errors.Is(err, scene.ErrShareLinkNotFound) {
+		t.Errorf("revoked twice: got %v, want ErrNotFound wrapping scene.ErrShareLinkNotFound", err)
+	}
+
+	// Other links of the scene keep working, and are the only ones listed
+	if _, err := s.resolveShareLink(ctx, kept.Token, audit.ActionReadMetadata); err != nil {
+		t.Errorf("other link: got %v, want nil", err)
+	}
+	links, err := s.GetShareLinks(ctx, alice.ID, sc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].ID != kept.ID || links[0].Token != kept.Token {
+		t.Errorf("got links %+v, want only %s", links, kept.ID.Hex())
+	}
+
+	tampered := kept.Token[:len(kept.Token)-1] + "0"
+	if tampered == kept.Token {
+		tampered = kept.Token[:len(kept.Token)-1] + "1"
+	}
+	if _, err := s.resolveShareLink(ctx, tampered, audit.ActionReadMetadata); !errors.Is(err, ErrInvalidShareLink) {
+		t.Errorf("tampered token: got %v, want ErrInvalidShareLink", err)
+	}
+}
@@ -0,0 +1,179 @@
+// This file contains the sharing of scenes with collaborators, and what each collaborator role may do.
+//
+// Viewers may read a scene's metadata and status and download its outputs. Editors may also cancel and retry its
+// jobs. Only the owner (and admins) may delete or retrain a scene, edit its name, description, and tags, read its audit
+// log, or change who it is shared with.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrCannotShareWithOwner is returned when a scene is shared with the user that owns it.
+	ErrCannotShareWithOwner = errors.New("cannot share a scene with its owner")
+)
+
+// collaboratorActions maps collaborator roles to the audited actions they may perform on a scene.
+var collaboratorActions = map[string][]string{
+	scene.CollaboratorRoleViewer: {
+		audit.ActionReadMetadata,
+		audit.ActionReadStatus,
+		audit.ActionDownload,
+	},
+	scene.CollaboratorRoleEditor: {
+		audit.ActionReadMetadata,
+		audit.ActionReadStatus,
+		audit.ActionDownload,
+		audit.ActionCancel,
+		audit.ActionRetry,
+		audit.ActionExport,
+		audit.ActionRender,
+	},
+}
+
+// collaboratorMayPerform checks if a collaborator of the given role may perform the given action.
+func collaboratorMayPerform(role, action string) bool {
+	return slices.Contains(collaboratorActions[role], action)
+}
+
+// ShareScene shares a scene the user owns with the user named targetUsername, in the given collaborator role.
+// Sharing a scene again with the same user changes their role.
+//
+// Returns scene.ErrInvalidCollaboratorRole if the role is not valid, ErrCannotShareWithOwner if the target user
+// owns the scene, or error if the user does not own the scene or an error occurred.
+func (s *ClientService) ShareScene(ctx context.Context, ownerID, sceneID primitive.ObjectID, targetUsername, role string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.ShareScene", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Share scene request received")
+
+	if !scene.IsValidCollaboratorRole(role) {
+		return NewValidationError(
+			scene.ErrInvalidCollaboratorRole.Error(),
+			map[string]string{"role": "must be " + scene.CollaboratorRoleViewer + " or " + scene.CollaboratorRoleEditor},
+			scene.ErrInvalidCollaboratorRole,
+		)
+	}
+
+	// Verify user access to scene
+	if err := s.authorize(ctx, ownerID, sceneID, audit.ActionShare); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return err
+	}
+
+	target, err := s.userManager.GetUserByUsername(ctx, targetUsername)
+	if err != nil {
+		s.logger.Ctx(ctx).Info("Failed to get share target:", err.Error())
+		return err
+	}
+	if slices.Contains(target.SceneIDs, sceneID) {
+		return ErrCannotShareWithOwner
+	}
+
+	if err := s.sceneManager.SetCollaborator(ctx, sceneID, target.ID, role); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to share scene %s with user %s: %v", sceneID.Hex(), target.ID.Hex(), err)
+		return err
+	}
+	s.sceneCache.InvalidateAccess(ctx, sceneID, target.ID)
+	s.audit.record(ctx, &audit.Event{
+		UserID:       ownerID,
+		SceneID:      sceneID,
+		TargetUserID: target.ID,
+		Action:       audit.ActionShareWith,
+		Outcome:      audit.OutcomeAllowed,
+		Detail:       role,
+	})
+
+	s.logger.Ctx(ctx).Infof("Shared scene %s with user %s as %s", sceneID.Hex(), target.ID.Hex(), role)
+	return nil
+}
+
+// UnshareScene stops sharing a scene the user owns with the user named targetUsername.
+//
+// Returns scene.ErrCollaboratorNotFound if the scene is not shared with the target user,
+// or error if the user does not own the scene or an error occurred.
+func (s *ClientService) UnshareScene(ctx context.Context, ownerID, sceneID primitive.ObjectID, targetUsername string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.UnshareScene", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Unshare scene request received")
+
+	// Verify user access to scene
+	if err := s.authorize(ctx, ownerID, sceneID, audit.ActionShare); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return err
+	}
+
+	target, err := s.userManager.GetUserByUsername(ctx, targetUsername)
+	if err != nil {
+		s.logger.Ctx(ctx).Info("Failed to get share target:", err.Error())
+		return err
+	}
+
+	if err := s.sceneManager.RemoveCollaborator(ctx, sceneID, target.ID); err != nil {
+		s.logger.Ctx(ctx).Info("Failed to unshare scene:", err.Error())
+		return err
+	}
+	s.sceneCache.InvalidateAccess(ctx, sceneID, target.ID)
+	s.audit.record(ctx, &audit.Event{
+		UserID:       ownerID,
+		SceneID:      sceneID,
+		TargetUserID: target.ID,
+		Action:       audit.ActionUnshare,
+		Outcome:      audit.OutcomeAllowed,
+	})
+
+	s.logger.Ctx(ctx).Infof("Stopped sharing scene %s with user %s", sceneID.Hex(), target.ID.Hex())
+	return nil
+}
+
+// GetSceneCollaborators returns the users a scene the user owns is shared with.
+//
+// Returns error if the user does not own the scene or an error occurred.
+func (s *ClientService) GetSceneCollaborators(ctx context.Context, ownerID, sceneID primitive.ObjectID) (_ []scene.Collaborator, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetSceneCollaborators", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get scene collaborators request received")
+
+	// Verify user access to scene
+	if err := s.authorize(ctx, ownerID, sceneID, audit.ActionShare); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+
+	return s.sceneManager.GetCollaborators(ctx, sceneID)
+}
+
+// verifyCollaboratorAccess checks if the given user is a collaborator on the given scene whose role allows the action.
+//
+// Returns nil if it does, user.ErrUserNoAccess if not, or error if an error occurred.
+func (s *ClientService) verifyCollaboratorAccess(ctx context.Context, userID, sceneID primitive.ObjectID, action string) error {
+	role, err := s.sceneManager.GetCollaboratorRole(ctx, sceneID, userID)
+	if errors.Is(err, scene.ErrCollaboratorNotFound) || errors.Is(err, scene.ErrSceneNotFound) {
+		return user.ErrUserNoAccess
+	}
+	if err != nil {
+		return err
+	}
+	s.cacheAccessRole(ctx, sceneID, userID, role)
+	if !collaboratorMayPerform(role, action) {
+		return user.ErrUserNoAccess
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+)
+
+func TestShareScene(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := newTestUser(t, s, "alice")
+	bob := newTestUser(t, s, "bob")
+	sc := newTestScene(t, s, alice, "garden")
+
+	if err := s.ShareScene(ctx, alice.ID, sc.ID, "bob", "owner"); !errors.Is(err, ErrValidation) {
+		t.Errorf("invalid role: got %v, want ErrValidation", err)
+	}
+	if err := s.ShareScene(ctx, alice.ID, sc.ID, "alice", scene.CollaboratorRoleViewer); !errors.Is(err, ErrValidation) {
+		t.Errorf("sharing with the owner: got %v, want ErrValidation", err)
+	}
+	if err := s.ShareScene(ctx, bob.ID, sc.ID, "bob", scene.CollaboratorRoleViewer); !errors.Is(err, ErrForbidden) {
+		t.Errorf("sharing another user's scene: got %v, want ErrForbidden", err)
+	}
+	if err := s.ShareScene(ctx, alice.ID, sc.ID, "nobody", scene.CollaboratorRoleViewer); !errors.Is(err, ErrNotFound) {
+		t.Errorf("sharing with a missing user: got %v, want ErrNotFound", err)
+	}
+
+	if err := s.ShareScene(ctx, alice.ID, sc.ID, "bob", scene.CollaboratorRoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	collaborators, err := s.GetSceneCollaborators(ctx, alice.ID, sc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collaborators) != 1 || collaborators[0].UserID != bob.ID || collaborators[0].Role != scene.CollaboratorRoleViewer {
+		t.Errorf("collaborators: %+v", collaborators)
+	}
+	if err := s.authorize(ctx, bob.ID, sc.ID, audit.ActionDownload); err != nil {
+		t.Errorf("download by a viewer: %v", err)
+	}
+	if err := s.authorize(ctx, bob.ID, sc.ID, audit.ActionCancel); !errors.Is(err, user.ErrUserNoAccess) {
+		t.Errorf("cancel by a viewer: got %v, want user.ErrUserNoAccess", err)
+	}
+
+	// Sharing again changes the role, and the cached access of the collaborator
+	if err := s.ShareScene(ctx, alice.ID, sc.ID, "bob", scene.CollaboratorRoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.authorize(ctx, bob.ID, sc.ID, audit.ActionCancel); err != nil {
+		t.Errorf("cancel by an editor: %v", err)
+	}
+	if err := s.authorize(ctx, bob.ID, sc.ID, audit.ActionEditMetadata); !errors.Is(err, user.ErrUserNoAccess) {
+		t.Errorf("metadata edit by an editor: got %v, want user.ErrUserNoAccess", err)
+	}
+
+	if err := s.UnshareScene(ctx, alice.ID, sc.ID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.authorize(ctx, bob.ID, sc.ID, audit.ActionDownload); !errors.Is(err, user.ErrUserNoAccess) {
+		t.Errorf("download after unsharing: got %v, want user.ErrUserNoAccess", err)
+	}
+	if err := s.UnshareScene(ctx, alice.ID, sc.ID, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unsharing twice: got %v, want ErrNotFound", err)
+	}
+}
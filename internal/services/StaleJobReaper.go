@@ -0,0 +1,122 @@
+// This file contains the stale job reaper, which fails scenes whose worker died without reporting completion or failure.
+//
+// A scene in sfm_running or training is stale once it has neither changed state nor reported progress (i.e training
+// output of a save iteration) for the timeout of its stage, see scene.SceneStatus.LastActivity. Stale jobs are
+// requeued up to ReaperConfig.MaxRequeues times, then the scene is marked as failed with a "timed out" reason, which
+// frees its admission slot and is reported by GetJobError. Every reaped job is logged.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+// DefaultReapInterval is used when ReaperConfig.Interval is not set.
+const DefaultReapInterval = time.Minute
+
+// ReaperConfig configures the stale job reaper. A zero value does not reap.
+type ReaperConfig struct {
+	// longest a scene may stay in sfm_running without progress, <= 0 does not reap the stage
+	SfmTimeout time.Duration
+	// longest a scene may stay in training without progress, <= 0 does not reap the stage
+	TrainingTimeout time.Duration
+	// times a timed out job is requeued before its scene is failed, 0 fails it right away
+	MaxRequeues int
+	// how often to look for stale jobs
+	Interval time.Duration
+}
+
+// enabled reports whether any stage is reaped.
+func (c ReaperConfig) enabled() bool {
+	return c.SfmTimeout > 0 || c.TrainingTimeout > 0
+}
+
+// runReaper reaps stale jobs every ReaperConfig.Interval, until the service is shut down.
+func (s *AMPQService) runReaper() {
+	if !s.reaper.enabled() {
+		return
+	}
+	interval := s.reaper.Interval
+	if interval <= 0 {
+		interval = DefaultReapInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.reapStaleJobs(context.Background())
+		}
+	}
+}
+
+// reapStaleJobs requeues or fails every scene stale in a reaped stage.
+func (s *AMPQService) reapStaleJobs(ctx context.Context) {
+	stages := []struct {
+		state   scene.State
+		stage   string
+		timeout time.Duration
+	}{
+		{scene.StateSfmRunning, metrics.StageSfm, s.reaper.SfmTimeout},
+		{scene.StateTraining, metrics.StageTraining, s.reaper.TrainingTimeout},
+	}
+
+	for _, st := range stages {
+		if st.timeout <= 0 {
+			continue
+		}
+		stale, err := s.sceneManager.GetStaleScenes(ctx, st.state, time.Now().Add(-st.timeout))
+		if err != nil {
+			s.logger.Ctx(ctx).Errorf("Failed to get stale %s scenes: %v", st.state, err)
+			continue
+		}
+		for _, sc := range stale {
+			s.reapStaleJob(ctx, sc, st.state, st.stage, st.timeout)
+		}
+	}
+}
+
+// reapStaleJob requeues the job of a stale scene if it has requeues left, and fails the scene otherwise.
+func (s *AMPQService) reapStaleJob(ctx context.Context, sc *scene.Scene, state scene.State, stage string, timeout time.Duration) {
+	idle := time.Since(sc.Status.LastActivity()).Round(time.Second)
+
+	if sc.Status.TimeoutRequeues < s.reaper.MaxRequeues {
+		err := s.sceneManager.MarkTimeoutRequeue(ctx, sc.ID, state)
+		if errors.Is(err, scene.ErrInvalidStatusTransition) {
+			// Moved on since it was listed
+			return
+		}
+		if err == nil {
+			err = s.RequeueJob(ctx, sc.ID)
+		}
+		if err == nil {
+			s.logger.Ctx(ctx).Warnf("Reaped scene %s: no progress in %s for %s, requeued (%d of %d)",
+				sc.ID.Hex(), state, idle, sc.Status.TimeoutRequeues+1, s.reaper.MaxRequeues)
+			return
+		}
+		s.logger.Ctx(ctx).Errorf("Failed to requeue timed out scene %s, failing it: %v", sc.ID.Hex(), err)
+	}
+
+	err := s.failJob(ctx, sc, &scene.JobFailure{
+		Stage:    stage,
+		Reason:   fmt.Sprintf("timed out: no progress in %s for %s", state, timeout),
+		Attempts: sc.Status.TimeoutRequeues + 1,
+		FailedAt: time.Now(),
+	})
+	if err != nil {
+		if !errors.Is(err, scene.ErrInvalidStatusTransition) {
+			s.logger.Ctx(ctx).Errorf("Failed to fail timed out scene %s: %v", sc.ID.Hex(), err)
+		}
+		return
+	}
+	s.logger.Ctx(ctx).Warnf("Reaped scene %s: no progress in %s for %s, marked as failed", sc.ID.Hex(), state, idle)
+}
@@ -0,0 +1,197 @@
+// This file contains live status streams of scenes, relayed to clients as Server-Sent Events by the web layer.
+//
+// Progress reports of workers and state changes made by the AMPQService are published to the subscribers of the
+// scene as they happen. State changes made elsewhere (i.e a cancellation, or the stale job reaper) are picked up by
+// polling the stored status every statusPollInterval. A stream starts with the current status and progress, and ends
+// once the scene reaches a terminal state. Events are dropped for subscribers too slow to keep up, which is harmless
+// as every event carries the full latest state of its type.
+//
+// Every published event is also handed to the webhooks of the scene, see Webhooks.go, and to the notifications mailed
+// to its owner, see Notifications.go.
+
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Status event types
+const (
+	StatusEventStatus   = "status"
+	StatusEventProgress = "progress"
+)
+
+// statusPollInterval is how often a status stream checks the stored state for changes not published to it.
+const statusPollInterval = 5 * time.Second
+
+// statusEventBuffer is how many events a subscriber may fall behind before events are dropped.
+const statusEventBuffer = 16
+
+// JobStatusEvent is a single event of a status stream. Status events carry State and Error, progress events carry Progress.
+type JobStatusEvent struct {
+	Type     string          `json:"type"`
+	State    scene.State     `json:"state,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Progress *scene.Progress `json:"progress,omitempty"`
+	Time     time.Time       `json:"time"`
+}
+
+// statusBroker holds the subscribers to the events of each scene.
+type statusBroker struct {
+	mu          sync.Mutex
+	subscribers map[primitive.ObjectID]map[chan JobStatusEvent]struct{}
+	// called with every published event if set, must not block
+	notify func(primitive.ObjectID, JobStatusEvent)
+}
+
+// notifyStatus returns the notify function of a statusBroker, handing events to webhooks and notifications. Either
+// may be nil.
+func notifyStatus(webhooks *Webhooks, notifications *Notifications) func(primitive.ObjectID, JobStatusEvent) {
+	return func(sceneID primitive.ObjectID, event JobStatusEvent) {
+		webhooks.Notify(sceneID, event)
+		notifications.Notify(sceneID, event)
+	}
+}
+
+// subscribe returns a channel receiving the events of a scene, and a function to stop receiving them.
+func (b *statusBroker) subscribe(sceneID primitive.ObjectID) (<-chan JobStatusEvent, func()) {
+	ch := make(chan JobStatusEvent, statusEventBuffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[primitive.ObjectID]map[chan JobStatusEvent]struct{})
+	}
+	if b.subscribers[sceneID] == nil {
+		b.subscribers[sceneID] = make(map[chan JobStatusEvent]struct{})
+	}
+	b.subscribers[sceneID][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[sceneID], ch)
+		if len(b.subscribers[sceneID]) == 0 {
+			delete(b.subscribers, sceneID)
+		}
+	}
+}
+
+// publish sends an event to every subscriber of a scene, without waiting for slow subscribers.
+func (b *statusBroker) publish(sceneID primitive.ObjectID, event JobStatusEvent) {
+	if b.notify != nil {
+		b.notify(sceneID, event)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[sceneID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// SubscribeStatus returns a channel receiving the progress reports and state changes of a scene as they are
+// processed, and a function to stop receiving them. See GetJobStatusStream for a complete stream.
+func (s *AMPQService) SubscribeStatus(sceneID primitive.ObjectID) (<-chan JobStatusEvent, func()) {
+	return s.statusEvents.subscribe(sceneID)
+}
+
+// GetJobStatusStream returns a stream of the status and progress events of a scene. The stream starts with the
+// current status (and progress, if any was reported), and is closed once the scene reaches a terminal state or ctx is
+// done. The caller must cancel ctx once it stops reading.
+//
+// Returns error if the user does not have access to the scene, or the scene has no status.
+func (s *ClientService) GetJobStatusStream(ctx context.Context, userID, sceneID primitive.ObjectID) (_ <-chan JobStatusEvent, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetJobStatusStream", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Get job status stream request received")
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionReadStatus); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return nil, err
+	}
+
+	// Subscribing first, so no event is missed between reading the status and subscribing
+	events, unsubscribe := s.mqService.SubscribeStatus(sceneID)
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil {
+		unsubscribe()
+		return nil, err
+	}
+	progress, err := s.mqService.LatestProgress(ctx, sceneID)
+	if err != nil {
+		unsubscribe()
+		return nil, err
+	}
+
+	out := make(chan JobStatusEvent, statusEventBuffer)
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+
+		send := func(event JobStatusEvent) bool {
+			select {
+			case out <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		state := status.State
+		if !send(JobStatusEvent{Type: StatusEventStatus, State: state, Error: status.Error, Time: time.Now()}) {
+			return
+		}
+		if progress != nil && !state.IsTerminal() {
+			if !send(JobStatusEvent{Type: StatusEventProgress, Progress: progress, Time: progress.ReportedAt}) {
+				return
+			}
+		}
+
+		ticker := time.NewTicker(statusPollInterval)
+		defer ticker.Stop()
+		for !state.IsTerminal() {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				if event.Type == StatusEventStatus {
+					if event.State == state {
+						continue
+					}
+					state = event.State
+				}
+				if !send(event) {
+					return
+				}
+			case <-ticker.C:
+				current, err := s.sceneManager.GetStatus(ctx, sceneID)
+				if err != nil {
+					s.logger.Ctx(ctx).Debugf("Ending status stream of scene %s: %v", sceneID.Hex(), err)
+					return
+				}
+				if current.State == state {
+					continue
+				}
+				state = current.State
+				if !send(JobStatusEvent{Type: StatusEventStatus, State: state, Error: current.Error, Time: time.Now()}) {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,164 @@
+// This file contains the generation of scene thumbnails.
+//
+// A JPEG thumbnail is extracted from a scene's uploaded input as soon as the scene is created, so clients can show
+// it while the scene is still queued. Once training renders a video, the thumbnail is regenerated from the latest
+// rendered video on its next request, so it shows the trained scene rather than the raw capture.
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Thumbnail generation settings
+const (
+	// thumbnailWidth is the largest width of a thumbnail. Smaller inputs are not upscaled.
+	thumbnailWidth = 480
+	// thumbnailQuality is the ffmpeg JPEG quality scale of thumbnails, from 2 (best) to 31 (worst).
+	thumbnailQuality = 4
+	// thumbnailCandidateFrames is how many consecutive frames ffmpeg picks the most representative thumbnail from.
+	thumbnailCandidateFrames = 50
+)
+
+// thumbnailSource returns the file a scene's thumbnail should be extracted from, and the offset into it to extract at.
+//
+// The latest rendered video output is preferred, then the uploaded video, then the first image of an uploaded image
+// set. Videos are sampled from their middle, as the first frames of captures are often shaky or blurred.
+// Returns ok false if the scene has no such file on disk, i.e it was uploaded with pre-computed camera poses.
+func thumbnailSource(sc *scene.Scene) (path string, offset time.Duration, ok bool) {
+	if sc.Nerf != nil && len(sc.Nerf.VideoFilePathsMap) > 0 {
+		iterations := make([]int, 0, len(sc.Nerf.VideoFilePathsMap))
+		for iteration := range sc.Nerf.VideoFilePathsMap {
+			iterations = append(iterations, iteration)
+		}
+		slices.Sort(iterations)
+		for i := len(iterations) - 1; i >= 0; i-- {
+			path := sc.Nerf.VideoFilePathsMap[iterations[i]]
+			if _, err := os.Stat(path); err == nil {
+				return path, 0, true
+			}
+		}
+	}
+
+	switch sc.UploadedInputType() {
+	case scene.InputTypeImages:
+		if sc.Images != nil && len(sc.Images.FilePaths) > 0 {
+			return sc.Images.FilePaths[0], 0, true
+		}
+	default:
+		if sc.Video != nil && sc.Video.FilePath != "" {
+			if _, err := os.Stat(sc.Video.FilePath); err == nil {
+				return sc.Video.FilePath, time.Duration(sc.Video.Duration) * time.Second / 2, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+// createThumbnail extracts the thumbnail of a newly created scene from its uploaded input, and adds its size to the
+// storage of ownerID. Failures are only logged, as a missing thumbnail is generated again on request.
+func (s *ClientService) createThumbnail(ctx context.Context, ownerID primitive.ObjectID, sc *scene.Scene) {
+	source, offset, ok := thumbnailSource(sc)
+	if !ok {
+		return
+	}
+
+	size, err := generateThumbnail(ctx, source, offset, s.sceneManager.ThumbnailPath(sc.ID))
+	if err != nil {
+		s.logger.Ctx(ctx).Warnf("Failed to generate thumbnail for scene %s: %v", sc.ID.Hex(), err)
+		return
+	}
+	if err := s.userManager.IncrementStorageUsed(ctx, ownerID, size); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to add thumbnail size to storage of user %s: %v", ownerID.Hex(), err)
+	}
+}
+
+// refreshThumbnail returns the path of a scene's thumbnail, generating it if it is missing or older than the file it
+// should be extracted from, i.e once a newer video has been rendered. The change in size is charged to the scene's owner.
+//
+// Returns ("", nil) if the scene has neither a thumbnail nor a file to extract one from.
+func (s *ClientService) refreshThumbnail(ctx context.Context, sc *scene.Scene) (string, error) {
+	thumbnailPath := s.sceneManager.ThumbnailPath(sc.ID)
+
+	// Serialize generation per scene
+	lock, _ := s.thumbnailLocks.LoadOrStore(sc.ID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	var oldSize int64
+	thumbnail, statErr := os.Stat(thumbnailPath)
+	if statErr == nil {
+		oldSize = thumbnail.Size()
+	}
+
+	source, offset, ok := thumbnailSource(sc)
+	if !ok {
+		if statErr != nil {
+			return "", nil
+		}
+		return thumbnailPath, nil
+	}
+	if statErr == nil {
+		if info, err := os.Stat(source); err == nil && !info.ModTime().After(thumbnail.ModTime()) {
+			return thumbnailPath, nil
+		}
+	}
+
+	size, err := generateThumbnail(ctx, source, offset, thumbnailPath)
+	if err != nil {
+		// A stale thumbnail is better than none
+		if statErr == nil {
+			s.logger.Ctx(ctx).Warnf("Failed to regenerate thumbnail for scene %s, serving the previous one: %v", sc.ID.Hex(), err)
+			return thumbnailPath, nil
+		}
+		return "", err
+	}
+
+	if err := s.addOwnerStorage(ctx, sc.ID, size-oldSize); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to add thumbnail size to storage of the owner of scene %s: %v", sc.ID.Hex(), err)
+	}
+
+	s.logger.Ctx(ctx).Debugf("Generated thumbnail for scene %s from %s", sc.ID.Hex(), source)
+	return thumbnailPath, nil
+}
+
+// generateThumbnail extracts a JPEG thumbnail at outPath from the video or image at source using ffmpeg, picking the
+// most representative of the frames following offset. Like generatePreviewClip, it writes to a temporary file first,
+// so a failed run never leaves a partial thumbnail behind.
+//
+// Returns the size in bytes of the generated thumbnail.
+func generateThumbnail(ctx context.Context, source string, offset time.Duration, outPath string) (int64, error) {
+	tmpPath := outPath + ".tmp.jpg"
+	defer os.Remove(tmpPath)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y", "-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", offset.Seconds()),
+		"-i", source,
+		"-vf", fmt.Sprintf("thumbnail=%d,scale='min(%d,iw)':-2", thumbnailCandidateFrames, thumbnailWidth),
+		"-frames:v", "1", "-q:v", fmt.Sprint(thumbnailQuality),
+		tmpPath,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("ffmpeg failed: %v: %s", err, output)
+	}
+
+	if err := os.Rename(tmpPath, outPath); err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(outPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
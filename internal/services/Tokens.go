@@ -0,0 +1,310 @@
+// This file contains the issuing and verification of session tokens.
+//
+// A login is given a short lived JWT access token, sent as a Bearer token with every request, and a long lived
+// refresh token, exchanged at RefreshTokens for a new pair once the access token expires. Refresh tokens are single
+// use and rotate on every exchange. Exchanging a refresh token that was already used means it was stolen (either the
+// thief or the user used it first), so every token rotated from the same login is revoked, logging both out.
+//
+// Refresh tokens are random and only their SHA-256 is stored, as for verification tokens. Changing the password,
+// deleting the account, or an admin disabling it revokes the user's refresh tokens. Access tokens are not stored, so
+// they stay valid until they expire, which AccessTTL keeps short, unless the account was disabled or deleted, or their
+// session was logged out or revoked, see VerifySession.
+//
+// The tokens rotated from the same login are a session, identified by their family ID, which access tokens carry in
+// their "sid" claim. Users list and revoke their sessions, see Sessions.go.
+
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/token"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrInvalidAccessToken is returned when an access token was not issued by this server, was altered, or expired.
+	ErrInvalidAccessToken = errors.New("invalid or expired access token")
+	// ErrInvalidRefreshToken is returned when a refresh token was not issued by this server, or expired.
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token is used again, after which its whole family is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token already used, log in again")
+	// ErrSessionRevoked is returned when using an access token whose session was logged out or revoked.
+	ErrSessionRevoked = errors.New("session was logged out, log in again")
+)
+
+// Token lifetimes used when none are configured
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// accessTokenType is the "typ" claim of access tokens, so other JWTs signed with the same key are not accepted.
+const accessTokenType = "access"
+
+// TokenConfig configures the session tokens issued by the ClientService.
+type TokenConfig struct {
+	// HMAC key of access tokens
+	Secret []byte
+	// lifetime of access tokens, DefaultAccessTokenTTL if not set
+	AccessTTL time.Duration
+	// lifetime of refresh tokens, DefaultRefreshTokenTTL if not set. Each rotation starts a new lifetime
+	RefreshTTL time.Duration
+}
+
+// accessTTL returns the lifetime of access tokens.
+func (c TokenConfig) accessTTL() time.Duration {
+	if c.AccessTTL <= 0 {
+		return DefaultAccessTokenTTL
+	}
+	return c.AccessTTL
+}
+
+// refreshTTL returns the lifetime of refresh tokens.
+func (c TokenConfig) refreshTTL() time.Duration {
+	if c.RefreshTTL <= 0 {
+		return DefaultRefreshTokenTTL
+	}
+	return c.RefreshTTL
+}
+
+// TokenPair is the access and refresh token of a session, as returned by LoginUser and RefreshTokens.
+type TokenPair struct {
+	AccessToken  string `json:"jwtToken"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	// lifetime of the access token, in seconds
+	ExpiresIn int `json:"expires_in"`
+}
+
+// issueTokens returns a new access token and a new refresh token of the given family for a user, whose login was made
+// at loginAt. The refresh token records the client of the request, see WithClientIP and WithUserAgent.
+func (s *ClientService) issueTokens(ctx context.Context, userID, familyID primitive.ObjectID, loginAt time.Time) (*TokenPair, error) {
+	if len(s.tokens.Secret) == 0 {
+		return nil, errors.New("access token signing key not configured")
+	}
+
+	now := time.Now()
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": userID.Hex(),
+		"sid": familyID.Hex(),
+		"typ": accessTokenType,
+		"iat": now.Unix(),
+		"exp": now.Add(s.tokens.accessTTL()).Unix(),
+	}).SignedString(s.tokens.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	refreshToken := hex.EncodeToString(b)
+	err = s.tokenManager.CreateToken(ctx, &token.RefreshToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashVerificationToken(refreshToken),
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.tokens.refreshTTL()),
+		LoginAt:   loginAt,
+		UserAgent: userAgent(ctx),
+		IP:        clientIP(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.tokens.accessTTL().Seconds()),
+	}, nil
+}
+
+// VerifyAccessToken checks the signature, expiry, and type of an access token, and returns the ID of its user.
+// The existence of the user is not checked, as every ClientService method checks it anyway.
+//
+// Returns ErrInvalidAccessToken if the token is not a valid access token issued by this server, or expired.
+func (s *ClientService) VerifyAccessToken(accessToken string) (primitive.ObjectID, error) {
+	userID, _, err := s.VerifyAccessTokenSession(accessToken)
+	return userID, err
+}
+
+// VerifyAccessTokenSession checks an access token like VerifyAccessToken, and returns the ID of its user and of the
+// session it was issued to. The session ID is nil for tokens issued before sessions were recorded in them.
+func (s *ClientService) VerifyAccessTokenSession(accessToken string) (_, _ primitive.ObjectID, err error) {
+	defer classifyError(&err)
+
+	parsed, err := jwt.Parse(accessToken, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return s.tokens.Secret, nil
+	})
+	if err != nil || !parsed.Valid {
+		return primitive.NilObjectID, primitive.NilObjectID, newError(ErrUnauthorized, ErrInvalidAccessToken.Error(), err)
+	}
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != accessTokenType {
+		return primitive.NilObjectID, primitive.NilObjectID, ErrInvalidAccessToken
+	}
+	// Expiry is only checked by Parse if present, and tokens issued before it was required never expire
+	if _, ok := claims["exp"].(float64); !ok {
+		return primitive.NilObjectID, primitive.NilObjectID, ErrInvalidAccessToken
+	}
+	subject, _ := claims["sub"].(string)
+	userID, err := primitive.ObjectIDFromHex(subject)
+	if err != nil {
+		return primitive.NilObjectID, primitive.NilObjectID, ErrInvalidAccessToken
+	}
+	// A malformed session ID only loses the session, the token itself is valid
+	sessionID, _ := claims["sid"].(string)
+	familyID, _ := primitive.ObjectIDFromHex(sessionID)
+	return userID, familyID, nil
+}
+
+// accountActiveKey is the cache key of whether a user's account may use its access tokens.
+func accountActiveKey(userID primitive.ObjectID) string {
+	return "vidgonerf:user:" + userID.Hex() + ":active"
+}
+
+// sessionActiveKey is the cache key of whether a session, i.e a refresh token family, was neither logged out nor
+// revoked.
+func sessionActiveKey(sessionID primitive.ObjectID) string {
+	return "vidgonerf:session:" + sessionID.Hex() + ":active"
+}
+
+// VerifySession checks that the account and session an access token verified by VerifyAccessTokenSession was issued
+// to may still use it, i.e the account was neither disabled nor deleted since, and the session has an active refresh
+// token. Tokens issued before sessions were recorded in them (with a nil sessionID) only have their account checked.
+// Accounts and sessions that may are cached for the TTL of the SceneCache, so disabling an account or revoking a
+// session takes effect within it on other servers, and right away on the server that did it.
+//
+// Returns ErrInvalidAccessToken if the account was deleted, user.ErrAccountDisabled if it was disabled, or
+// ErrSessionRevoked if the session was logged out, revoked, or expired.
+func (s *ClientService) VerifySession(ctx context.Context, userID, sessionID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+
+	if err := s.verifyAccountActive(ctx, userID); err != nil {
+		return err
+	}
+	if sessionID.IsZero() {
+		return nil
+	}
+
+	var active bool
+	if s.sceneCache.get(ctx, sessionActiveKey(sessionID), &active) && active {
+		return nil
+	}
+	active, err = s.tokenManager.FamilyActive(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if !active {
+		return ErrSessionRevoked
+	}
+	s.sceneCache.set(ctx, sessionActiveKey(sessionID), true)
+	return nil
+}
+
+// verifyAccountActive checks that a user's account was neither disabled nor deleted, see VerifySession.
+func (s *ClientService) verifyAccountActive(ctx context.Context, userID primitive.ObjectID) error {
+	var active bool
+	if s.sceneCache.get(ctx, accountActiveKey(userID), &active) && active {
+		return nil
+	}
+
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return ErrInvalidAccessToken
+	}
+	if err != nil {
+		return err
+	}
+	if u.Disabled {
+		return user.ErrAccountDisabled
+	}
+	s.sceneCache.set(ctx, accountActiveKey(userID), true)
+	return nil
+}
+
+// RefreshTokens exchanges a refresh token for a new access token and refresh token. The given refresh token can not
+// be used again.
+//
+// Returns ErrInvalidRefreshToken if the token is unknown or expired, or ErrRefreshTokenReused if it was already used,
+// in which case every token of the same login is revoked.
+func (s *ClientService) RefreshTokens(ctx context.Context, refreshToken string) (_ *TokenPair, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RefreshTokens", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	consumed, err := s.tokenManager.ConsumeToken(ctx, hashVerificationToken(refreshToken))
+	if errors.Is(err, token.ErrTokenNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if errors.Is(err, token.ErrTokenAlreadyUsed) {
+		revoked, err := s.tokenManager.RevokeFamily(ctx, consumed.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+		s.sceneCache.delete(ctx, sessionActiveKey(consumed.FamilyID))
+		s.logger.Ctx(ctx).Warnf("Reused refresh token of user %s, revoked %d tokens of its family", consumed.UserID.Hex(), revoked)
+		s.audit.Record(ctx, consumed.UserID, primitive.NilObjectID, audit.ActionLogin, audit.OutcomeDenied, "reused refresh token")
+		return nil, ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(consumed.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// The account may have been deleted or disabled since the token was issued
+	u, err := s.userManager.GetUserByID(ctx, consumed.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u.Disabled {
+		return nil, user.ErrAccountDisabled
+	}
+	loginAt := consumed.LoginAt
+	if loginAt.IsZero() {
+		loginAt = consumed.CreatedAt
+	}
+	return s.issueTokens(ctx, consumed.UserID, consumed.FamilyID, loginAt)
+}
+
+// RevokeRefreshToken logs a session out, revoking the given refresh token and every token of the same login.
+// Revoking an unknown or already revoked token is not an error, so logging out can always be retried.
+func (s *ClientService) RevokeRefreshToken(ctx context.Context, refreshToken string) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RevokeRefreshToken", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	consumed, err := s.tokenManager.ConsumeToken(ctx, hashVerificationToken(refreshToken))
+	if errors.Is(err, token.ErrTokenNotFound) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, token.ErrTokenAlreadyUsed) {
+		return err
+	}
+	if _, err := s.tokenManager.RevokeFamily(ctx, consumed.FamilyID); err != nil {
+		return err
+	}
+	s.sceneCache.delete(ctx, sessionActiveKey(consumed.FamilyID))
+	return nil
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+)
+
+func TestVerifySessionDisabledAccount(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := newTestUser(t, s, "alice")
+
+	if err := s.VerifySession(ctx, alice.ID, primitive.NilObjectID); err != nil {
+		t.Fatalf("active account: got %v, want nil", err)
+	}
+
+	// The account is cached as active until the entry is dropped, as AdminSetUserDisabled does
+	if err := s.userManager.SetDisabled(ctx, alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifySession(ctx, alice.ID, primitive.NilObjectID); err != nil {
+		t.Fatalf("cached account: got %v, want nil", err)
+	}
+	s.sceneCache.delete(ctx, accountActiveKey(alice.ID))
+	if err := s.VerifySession(ctx, alice.ID, primitive.NilObjectID); !errors.Is(err, ErrForbidden) || !errors.Is(err, user.ErrAccountDisabled) {
+		t.Errorf("disabled account: got %v, want ErrForbidden wrapping user.ErrAccountDisabled", err)
+	}
+
+	if err := s.VerifySession(ctx, primitive.NewObjectID(), primitive.NilObjectID); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("deleted account: got %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestRefreshTokensRotation(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := newTestUser(t, s, "alice")
+
+	login, err := s.LoginUser(ctx, "alice", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, sessionID, err := s.VerifyAccessTokenSession(login.AccessToken)
+	if err != nil || userID != alice.ID || sessionID.IsZero() {
+		t.Fatalf("login access token: got user %s session %s, %v", userID.Hex(), sessionID.Hex(), err)
+	}
+
+	rotated, err := s.RefreshTokens(ctx, login.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if rotated.RefreshToken == login.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+	_, rotatedSessionID, err := s.VerifyAccessTokenSession(rotated.AccessToken)
+	if err != nil || rotatedSessionID != sessionID {
+		t.Errorf("rotated access token: got session %s, %v, want session %s", rotatedSessionID.Hex(), err, sessionID.Hex())
+	}
+
+	// The rotated token is the session's only active token
+	sessions, err := s.ListSessions(ctx, alice.ID, sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != sessionID || !sessions[0].Current {
+		t.Errorf("got sessions %+v, want the current session %s only", sessions, sessionID.Hex())
+	}
+
+	if _, err := s.RefreshTokens(ctx, "unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("unknown token: got %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := newTestUser(t, s, "alice")
+
+	stolen, err := s.LoginUser(ctx, "alice", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.LoginUser(ctx, "alice", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := s.RefreshTokens(ctx, stolen.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Using the consumed token again revokes every token of its login, including the one rotated from it
+	if _, err := s.RefreshTokens(ctx, stolen.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reused token: got %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := s.RefreshTokens(ctx, rotated.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("token rotated from the reused token: got %v, want ErrRefreshTokenReused", err)
+	}
+
+	// Other logins of the user are kept
+	if _, err := s.RefreshTokens(ctx, other.RefreshToken); err != nil {
+		t.Errorf("other login: got %v, want nil", err)
+	}
+	sessions, err := s.ListSessions(ctx, alice.ID, primitive.NilObjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Errorf("got %d sessions, want 1", len(sessions))
+	}
+}
+
+func TestVerifySessionRevoked(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	alice := newTestUser(t, s, "alice")
+
+	loggedOut, err := s.LoginUser(ctx, "alice", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := s.LoginUser(ctx, "alice", testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, loggedOutID, _ := s.VerifyAccessTokenSession(loggedOut.AccessToken)
+	_, revokedID, _ := s.VerifyAccessTokenSession(revoked.AccessToken)
+	for _, sessionID := range []primitive.ObjectID{loggedOutID, revokedID} {
+		if err := s.VerifySession(ctx, alice.ID, sessionID); err != nil {
+			t.Fatalf("active session: got %v, want nil", err)
+		}
+	}
+
+	// A rotated session stays active
+	if _, err := s.RefreshTokens(ctx, loggedOut.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	s.sceneCache.delete(ctx, sessionActiveKey(loggedOutID))
+	if err := s.VerifySession(ctx, alice.ID, loggedOutID); err != nil {
+		t.Errorf("rotated session: got %v, want nil", err)
+	}
+
+	if err := s.RevokeSession(ctx, alice.ID, revokedID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifySession(ctx, alice.ID, revokedID); !errors.Is(err, ErrUnauthorized) || !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("revoked session: got %v, want ErrUnauthorized wrapping ErrSessionRevoked", err)
+	}
+	if _, err := s.RevokeAllSessions(ctx, alice.ID, primitive.NilObjectID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.VerifySession(ctx, alice.ID, loggedOutID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("logged out session: got %v, want ErrSessionRevoked", err)
+	}
+}
@@ -0,0 +1,210 @@
+// This file contains dry-run estimates of the runtime, cost, and output size of a training job.
+//
+// Estimates are computed from the video properties and training config with linear coefficients per training mode,
+// which can be tuned against observed job durations (see GetProcessingTimeStats) without a code change. Every figure
+// is given as an expected value with low and high bounds, widened by the mode's uncertainty, and the durations
+// observed for similar completed jobs are included when there are any. Estimating never creates a scene or
+// publishes a job.
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// EstimationCoefficients are the coefficients of the training estimate of one training mode.
+type EstimationCoefficients struct {
+	SfmBaseSeconds     float64 `json:"sfm_base_seconds"`
+	SfmSecondsPerFrame float64 `json:"sfm_seconds_per_frame"`
+	// fixed training overhead, i.e loading the sfm output
+	TrainingBaseSeconds         float64 `json:"training_base_seconds"`
+	TrainingSecondsPerIteration float64 `json:"training_seconds_per_iteration"`
+	TrainingSecondsPerFrame     float64 `json:"training_seconds_per_frame"`
+	// time to write the outputs of each save iteration
+	SecondsPerSave float64 `json:"seconds_per_save"`
+	// size of each output file by output type
+	OutputBytes map[string]OutputSizeCoefficients `json:"output_bytes"`
+	// cost of an hour of processing, 0 does not estimate cost
+	CostPerHour float64 `json:"cost_per_hour"`
+	// relative error of the estimate, i.e 0.5 bounds estimates between 1/1.5 and 1.5 times the expected value
+	Uncertainty float64 `json:"uncertainty"`
+}
+
+// OutputSizeCoefficients estimate the size of a single output file.
+type OutputSizeCoefficients struct {
+	BaseBytes     int64 `json:"base_bytes"`
+	BytesPerFrame int64 `json:"bytes_per_frame"`
+}
+
+// DefaultEstimationCoefficients returns the default estimation coefficients of each training mode.
+func DefaultEstimationCoefficients() map[string]EstimationCoefficients {
+	return map[string]EstimationCoefficients{
+		scene.TrainingModeGaussian: {
+			SfmBaseSeconds:              30,
+			SfmSecondsPerFrame:          1.5,
+			TrainingBaseSeconds:         60,
+			TrainingSecondsPerIteration: 0.05,
+			TrainingSecondsPerFrame:     0.2,
+			SecondsPerSave:              10,
+			OutputBytes: map[string]OutputSizeCoefficients{
+				"splat_cloud":              {BaseBytes: 50 << 20, BytesPerFrame: 100 << 10},
+				"point_cloud":              {BaseBytes: 5 << 20, BytesPerFrame: 20 << 10},
+				"video":                    {BaseBytes: 5 << 20},
+				scene.OutputTypeCheckpoint: {BaseBytes: 200 << 20, BytesPerFrame: 200 << 10},
+			},
+			Uncertainty: 0.5,
+		},
+		scene.TrainingModeTensorf: {
+			SfmBaseSeconds:              30,
+			SfmSecondsPerFrame:          1.5,
+			TrainingBaseSeconds:         60,
+			TrainingSecondsPerIteration: 0.03,
+			TrainingSecondsPerFrame:     0.1,
+			SecondsPerSave:              15,
+			OutputBytes: map[string]OutputSizeCoefficients{
+				"model":                    {BaseBytes: 300 << 20},
+				"video":                    {BaseBytes: 5 << 20},
+				scene.OutputTypeCheckpoint: {BaseBytes: 300 << 20},
+			},
+			Uncertainty: 0.5,
+		},
+	}
+}
+
+// VideoProperties are the properties of a video to estimate training for, as probed on the client.
+type VideoProperties struct {
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	FPS             float64 `json:"fps"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// estimated from duration and frame rate if not set
+	FrameCount int   `json:"frame_count"`
+	FileSize   int64 `json:"file_size"`
+}
+
+// TrainingEstimateRequest is a training job to estimate.
+type TrainingEstimateRequest struct {
+	Video  VideoProperties
+	Config *scene.NerfTrainingConfig
+}
+
+// EstimateRange is an estimated value with its lower and upper bounds.
+type EstimateRange struct {
+	Expected float64 `json:"expected"`
+	Low      float64 `json:"low"`
+	High     float64 `json:"high"`
+}
+
+// TrainingEstimate is the estimated runtime, cost, and output size of a training job.
+type TrainingEstimate struct {
+	// always true, as a reminder that none of the figures are guaranteed
+	IsEstimate bool `json:"is_estimate"`
+	// frames the sfm-worker is expected to extract, after frame sampling
+	Frames          int           `json:"frames"`
+	SfmSeconds      EstimateRange `json:"sfm_seconds"`
+	TrainingSeconds EstimateRange `json:"training_seconds"`
+	TotalSeconds    EstimateRange `json:"total_seconds"`
+	// omitted if no cost per hour is configured
+	Cost *EstimateRange `json:"cost,omitempty"`
+	// total size of the files of each output type, across every save iteration
+	OutputBytes      map[string]EstimateRange `json:"output_bytes"`
+	TotalOutputBytes EstimateRange            `json:"total_output_bytes"`
+	// durations of completed jobs of the same training mode and input size, omitted if there are none
+	Observed *ProcessingTimeGroup `json:"observed,omitempty"`
+}
+
+// newEstimateRange bounds an expected value by the given relative uncertainty.
+func newEstimateRange(expected, uncertainty float64) EstimateRange {
+	return EstimateRange{Expected: expected, Low: expected / (1 + uncertainty), High: expected * (1 + uncertainty)}
+}
+
+// EstimateTraining estimates the runtime, cost, and output size of training the given config on a video with the given
+// properties. Nothing is created or published.
+//
+// Returns scene.ErrInvalidTrainingConfig if the config is invalid, or one of the ErrVideoToo* errors or
+// ErrTooFewSampledFrames if the video would be rejected on upload.
+func (s *ClientService) EstimateTraining(ctx context.Context, userID primitive.ObjectID, req *TrainingEstimateRequest) (_ *TrainingEstimate, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.EstimateTraining", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if _, err := s.userManager.GetUserByID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	if err := s.trainingLimits.Check(req.Config); err != nil {
+		return nil, err
+	}
+	coefficients, ok := s.estimation[req.Config.TrainingMode]
+	if !ok {
+		return nil, fmt.Errorf("%w: no estimate for training mode %s", scene.ErrInvalidTrainingConfig, req.Config.TrainingMode)
+	}
+
+	// Reject what an upload of the video would be rejected for, as no estimate of it is meaningful
+	probe := &VideoProbe{
+		Width:      req.Video.Width,
+		Height:     req.Video.Height,
+		FPS:        req.Video.FPS,
+		Duration:   time.Duration(req.Video.DurationSeconds * float64(time.Second)),
+		FrameCount: req.Video.FrameCount,
+	}
+	if probe.FrameCount <= 0 {
+		probe.FrameCount = int(req.Video.DurationSeconds * req.Video.FPS)
+	}
+	limits := s.videoLimits[req.Config.TrainingMode]
+	if err := limits.Check(probe); err != nil {
+		return nil, err
+	}
+	if err := limits.CheckSampling(req.Config, probe.FrameCount); err != nil {
+		return nil, err
+	}
+
+	frames := req.Config.SampledFrameCount(probe.FrameCount)
+	saves := len(req.Config.SaveIterations)
+	u := coefficients.Uncertainty
+
+	sfmSeconds := coefficients.SfmBaseSeconds + coefficients.SfmSecondsPerFrame*float64(frames)
+	trainingSeconds := coefficients.TrainingBaseSeconds +
+		coefficients.TrainingSecondsPerIteration*float64(req.Config.TotalIterations) +
+		coefficients.TrainingSecondsPerFrame*float64(frames) +
+		coefficients.SecondsPerSave*float64(saves)
+	totalSeconds := sfmSeconds + trainingSeconds
+
+	estimate := &TrainingEstimate{
+		IsEstimate:      true,
+		Frames:          frames,
+		SfmSeconds:      newEstimateRange(sfmSeconds, u),
+		TrainingSeconds: newEstimateRange(trainingSeconds, u),
+		TotalSeconds:    newEstimateRange(totalSeconds, u),
+		OutputBytes:     make(map[string]EstimateRange, len(req.Config.OutputTypes)),
+	}
+	if coefficients.CostPerHour > 0 {
+		cost := newEstimateRange(totalSeconds/3600*coefficients.CostPerHour, u)
+		estimate.Cost = &cost
+	}
+
+	var totalBytes float64
+	for _, outputType := range req.Config.OutputTypes {
+		size := coefficients.OutputBytes[outputType]
+		bytes := float64(size.BaseBytes+size.BytesPerFrame*int64(frames)) * float64(saves)
+		estimate.OutputBytes[outputType] = newEstimateRange(math.Round(bytes), u)
+		totalBytes += bytes
+	}
+	estimate.TotalOutputBytes = newEstimateRange(math.Round(totalBytes), u)
+
+	// Observed durations are a reference only, the estimate does not depend on them
+	if stats, err := s.processingTimeStats(ctx); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to get processing time stats for estimate: %v", err)
+	} else if group, ok := stats.Lookup(req.Config.TrainingMode, SizeBucket(req.Video.FileSize)); ok {
+		estimate.Observed = &group
+	}
+
+	return estimate, nil
+}
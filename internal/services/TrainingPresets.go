@@ -0,0 +1,153 @@
+// This file contains the named training config presets, and the deployment's limits on training configs.
+//
+// A preset fills in the training mode, output types, save iterations, and total iterations a request leaves unset,
+// so clients can ask for i.e "fast-preview" without knowing the iteration counts behind it. Values a request sets
+// override those of its preset. Every training config is checked against TrainingLimits before anything is stored or
+// published, whether it came from a preset or not.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+var (
+	// ErrUnknownTrainingPreset is returned when a request names a training preset that does not exist.
+	ErrUnknownTrainingPreset = errors.New("unknown training preset")
+)
+
+// TrainingPreset is a named training config.
+type TrainingPreset struct {
+	Name            string   `json:"name"`
+	Description     string   `json:"description"`
+	TrainingMode    string   `json:"training_mode"`
+	OutputTypes     []string `json:"output_types"`
+	SaveIterations  []int    `json:"save_iterations"`
+	TotalIterations int      `json:"total_iterations"`
+}
+
+// TrainingPresets are the training presets requests can name, see LookupTrainingPreset.
+var TrainingPresets = []TrainingPreset{
+	{
+		Name:            "fast-preview",
+		Description:     "A short training run rendering a preview video, to check the capture before training it in full",
+		TrainingMode:    scene.TrainingModeGaussian,
+		OutputTypes:     []string{"video"},
+		SaveIterations:  []int{7000},
+		TotalIterations: 7000,
+	},
+	{
+		Name:            "high-quality",
+		Description:     "A full training run saving every output type, with an intermediate save",
+		TrainingMode:    scene.TrainingModeGaussian,
+		OutputTypes:     []string{"splat_cloud", "point_cloud", "video"},
+		SaveIterations:  []int{7000, 30000},
+		TotalIterations: 30000,
+	},
+	{
+		Name:            "splat-only",
+		Description:     "A full training run saving only the final splat cloud, for viewers",
+		TrainingMode:    scene.TrainingModeGaussian,
+		OutputTypes:     []string{"splat_cloud"},
+		SaveIterations:  []int{30000},
+		TotalIterations: 30000,
+	},
+}
+
+// LookupTrainingPreset returns the training preset with the given name.
+//
+// Returns ErrUnknownTrainingPreset if there is none.
+func LookupTrainingPreset(name string) (*TrainingPreset, error) {
+	for i := range TrainingPresets {
+		if TrainingPresets[i].Name == name {
+			return &TrainingPresets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownTrainingPreset, name)
+}
+
+// Apply replaces the given training config values that are unset by those of the preset.
+func (p *TrainingPreset) Apply(trainingMode *string, outputTypes *[]string, saveIterations *[]int, totalIterations *int) {
+	if *trainingMode == "" {
+		*trainingMode = p.TrainingMode
+	}
+	if len(*outputTypes) == 0 {
+		*outputTypes = slices.Clone(p.OutputTypes)
+	}
+	if len(*saveIterations) == 0 {
+		*saveIterations = slices.Clone(p.SaveIterations)
+	}
+	if *totalIterations == 0 {
+		*totalIterations = p.TotalIterations
+	}
+}
+
+// TrainingLimits are the deployment's bounds on training configs, on top of those of scene.NerfTrainingConfig.Validate.
+//
+// MaxTotalIterations is the most iterations a scene can be trained for. A value <= 0, or above
+// scene.MaxTotalIterations, uses scene.MaxTotalIterations. MaxSaveIterations is the most iterations outputs can be
+// saved at. A value <= 0 does not limit them.
+type TrainingLimits struct {
+	MaxTotalIterations int `json:"max_total_iterations"`
+	MaxSaveIterations  int `json:"max_save_iterations,omitempty"`
+}
+
+// maxTotalIterations returns the most iterations a scene can be trained for.
+func (l TrainingLimits) maxTotalIterations() int {
+	if l.MaxTotalIterations <= 0 || l.MaxTotalIterations > scene.MaxTotalIterations {
+		return scene.MaxTotalIterations
+	}
+	return l.MaxTotalIterations
+}
+
+// Check validates config, and checks it is within the limits.
+//
+// Returns scene.ErrInvalidTrainingConfig, stating the offending value, otherwise.
+func (l TrainingLimits) Check(config *scene.NerfTrainingConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if maxIterations := l.maxTotalIterations(); config.TotalIterations > maxIterations {
+		return fmt.Errorf("%w: total iterations must be at most %d", scene.ErrInvalidTrainingConfig, maxIterations)
+	}
+	if l.MaxSaveIterations > 0 && len(config.SaveIterations) > l.MaxSaveIterations {
+		return fmt.Errorf("%w: outputs can be saved at most at %d iterations", scene.ErrInvalidTrainingConfig, l.MaxSaveIterations)
+	}
+	return nil
+}
+
+// TrainingPresetList lists the training presets available, and the limits training configs are checked against.
+type TrainingPresetList struct {
+	Presets []TrainingPreset `json:"presets"`
+	Limits  TrainingLimits   `json:"limits"`
+}
+
+// GetTrainingPresets returns the training presets that are within the deployment's limits, and the limits.
+func (s *ClientService) GetTrainingPresets(ctx context.Context) *TrainingPresetList {
+	list := &TrainingPresetList{
+		Presets: make([]TrainingPreset, 0, len(TrainingPresets)),
+		Limits: TrainingLimits{
+			MaxTotalIterations: s.trainingLimits.maxTotalIterations(),
+			MaxSaveIterations:  max(s.trainingLimits.MaxSaveIterations, 0),
+		},
+	}
+	for _, preset := range TrainingPresets {
+		config := &scene.NerfTrainingConfig{
+			TrainingMode:    preset.TrainingMode,
+			OutputTypes:     preset.OutputTypes,
+			SaveIterations:  preset.SaveIterations,
+			TotalIterations: preset.TotalIterations,
+		}
+		if err := s.trainingLimits.Check(config); err != nil {
+			s.logger.Ctx(ctx).Debugf("Training preset %s is not within the limits: %v", preset.Name, err)
+			continue
+		}
+		list.Presets = append(list.Presets, preset)
+	}
+	return list
+}
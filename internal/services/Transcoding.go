@@ -0,0 +1,113 @@
+// This file contains conversion of stored nerf output to formats that can be viewed directly in browsers.
+//
+// Which conversions exist for an output type is declared in scene.OutputConversions, and how each target format is
+// produced is declared in transcodeArgs. Converted copies are cached next to the stored file, so each conversion
+// only runs once per file. Conversions are killed after transcodeTimeout, so a request never blocks indefinitely.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+var (
+	// ErrUnsupportedFormat is returned when an output cannot be served in the requested format.
+	ErrUnsupportedFormat = errors.New("output not available in requested format")
+	// ErrTranscodeTimeout is returned when converting an output takes longer than transcodeTimeout.
+	ErrTranscodeTimeout = errors.New("output conversion timed out")
+)
+
+// transcodeTimeout is the longest a single conversion may run.
+const transcodeTimeout = 5 * time.Minute
+
+// transcodeArgs are the ffmpeg output arguments used to produce each target format.
+var transcodeArgs = map[string][]string{
+	"mp4":  {"-c:v", "libx264", "-pix_fmt", "yuv420p", "-movflags", "+faststart", "-an"},
+	"webm": {"-c:v", "libvpx-vp9", "-b:v", "0", "-crf", "33", "-deadline", "realtime", "-cpu-used", "8", "-an"},
+	"gif":  {"-vf", "fps=12,scale=480:-2:flags=lanczos", "-loop", "0"},
+}
+
+// convertOutput returns the path of the output stored at storedPath in the requested format, converting it and caching
+// the converted copy if needed. An empty format, or the stored format, returns storedPath unchanged.
+//
+// The owner of sceneID is charged for the storage used by a new converted copy.
+// Returns ErrUnsupportedFormat, listing the available formats, if the conversion is not supported.
+func (s *ClientService) convertOutput(ctx context.Context, sceneID primitive.ObjectID, outputType, storedPath, format string) (string, error) {
+	formats := scene.AvailableFormats(outputType, storedPath)
+	if format == "" || format == formats[0] {
+		return storedPath, nil
+	}
+
+	args, ok := transcodeArgs[format]
+	if !ok || !slices.Contains(formats, format) {
+		return "", fmt.Errorf("%w: %s output is available as %s", ErrUnsupportedFormat, outputType, strings.Join(formats, ", "))
+	}
+
+	convertedPath := strings.TrimSuffix(storedPath, filepath.Ext(storedPath)) + "." + format
+
+	// Serialize conversion per converted file
+	lock, _ := s.transcodeLocks.LoadOrStore(convertedPath, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if _, err := os.Stat(convertedPath); err == nil {
+		s.logger.Ctx(ctx).Debug("Serving cached conversion: ", convertedPath)
+		return convertedPath, nil
+	}
+
+	size, err := transcodeFile(ctx, storedPath, convertedPath, args)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to convert %s to %s: %v", storedPath, format, err)
+		return "", err
+	}
+	if err := s.addOwnerStorage(ctx, sceneID, size); err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to add conversion size to storage of the owner of scene %s: %v", sceneID.Hex(), err)
+	}
+
+	return convertedPath, nil
+}
+
+// transcodeFile converts inPath to outPath with ffmpeg, using the given output arguments.
+// The output is written to a temporary file first, so a failed or timed out run never leaves a partial file behind.
+//
+// Returns the size in bytes of the converted file. Returns ErrTranscodeTimeout if ffmpeg runs longer than transcodeTimeout.
+func transcodeFile(ctx context.Context, inPath, outPath string, args []string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, transcodeTimeout)
+	defer cancel()
+
+	// Keep the real extension last, as ffmpeg picks the container from it
+	tmpPath := strings.TrimSuffix(outPath, filepath.Ext(outPath)) + ".tmp" + filepath.Ext(outPath)
+	defer os.Remove(tmpPath)
+
+	cmdArgs := append([]string{"-y", "-loglevel", "error", "-i", inPath}, args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(cmdArgs, tmpPath)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return 0, ErrTranscodeTimeout
+		}
+		return 0, fmt.Errorf("ffmpeg failed: %v: %s", err, output)
+	}
+
+	if err := os.Rename(tmpPath, outPath); err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(outPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
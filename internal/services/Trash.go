@@ -0,0 +1,139 @@
+// This file contains the trash, which deleted scenes are moved to before they are deleted permanently.
+//
+// DeleteScene moves a scene to the trash unless asked to delete it permanently. Scenes in the trash are hidden from
+// listings, and every action on them is refused with scene.ErrSceneInTrash but deleting and restoring them, so their
+// outputs, share links and signed URLs stop working. Their files are kept, and still count towards the storage of
+// their owner. RestoreScene takes a scene out of the trash within RetentionConfig.TrashRetention of trashing it, and
+// the retention policy permanently deletes scenes that stayed in the trash for longer, see Retention.go. Without a
+// trash retention, scenes stay in the trash until deleted from it.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrTrashExpired is returned when restoring a scene that has been in the trash for longer than the trash retention.
+	ErrTrashExpired = errors.New("scene has been in the trash for too long to be restored")
+)
+
+// trashActions are the audited actions that may be performed on scenes in the trash.
+var trashActions = []string{audit.ActionDelete, audit.ActionRestore}
+
+// SceneDeletion reports the outcome of DeleteScene.
+type SceneDeletion struct {
+	// whether the scene was moved to the trash, rather than deleted permanently
+	Trashed bool `json:"trashed"`
+	// when a trashed scene is deleted permanently, unset if it stays in the trash until deleted from it
+	PurgeAt        *time.Time `json:"purge_at,omitempty"`
+	BytesReclaimed int64      `json:"bytes_reclaimed"`
+}
+
+// verifyNotTrashed checks that a scene is not in the trash, cached.
+//
+// Returns scene.ErrSceneInTrash if it is, or error if an error occurred.
+func (s *ClientService) verifyNotTrashed(ctx context.Context, sceneID primitive.ObjectID) error {
+	var trashed bool
+	if !s.sceneCache.get(ctx, trashKey(sceneID), &trashed) {
+		trashedAt, err := s.sceneManager.GetTrashedAt(ctx, sceneID)
+		if err != nil {
+			return err
+		}
+		trashed = trashedAt != nil
+		s.sceneCache.set(ctx, trashKey(sceneID), trashed)
+	}
+	if trashed {
+		return scene.ErrSceneInTrash
+	}
+	return nil
+}
+
+// trashScene moves a scene to the trash. A scene that is still processing is cancelled first if cancelProcessing is
+// set, and cannot be trashed otherwise, as with deleteSceneData.
+func (s *ClientService) trashScene(ctx context.Context, sceneID primitive.ObjectID, cancelProcessing bool) (*SceneDeletion, error) {
+	status, err := s.sceneManager.GetStatus(ctx, sceneID)
+	if err != nil && !errors.Is(err, scene.ErrStatusNotFound) {
+		return nil, err
+	}
+	if status != nil && !status.State.IsTerminal() {
+		if !cancelProcessing {
+			return nil, scene.ErrInvalidOpOnProcessingScene
+		}
+		if err := s.cancelScene(ctx, sceneID); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	if err := s.sceneManager.TrashScene(ctx, sceneID, now); err != nil {
+		return nil, err
+	}
+	s.sceneCache.InvalidateTrash(ctx, sceneID)
+
+	deletion := &SceneDeletion{Trashed: true}
+	if config, _ := s.retentionConfig(); config.TrashRetention > 0 {
+		purgeAt := now.Add(config.TrashRetention)
+		deletion.PurgeAt = &purgeAt
+	}
+	s.logger.Ctx(ctx).Infof("Moved scene %s to the trash", sceneID.Hex())
+	return deletion, nil
+}
+
+// RestoreScene takes a scene the user has access to out of the trash.
+//
+// Returns scene.ErrSceneNotInTrash if the scene is not in the trash, ErrTrashExpired if it has been in the trash for
+// longer than the trash retention, or error if the user does not have access to the scene or an error occurred.
+func (s *ClientService) RestoreScene(ctx context.Context, userID, sceneID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.RestoreScene", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	ctx = log.WithFields(ctx, log.FieldSceneID, sceneID.Hex())
+	s.logger.Ctx(ctx).Debug("Restore scene request received")
+
+	if err := s.authorize(ctx, userID, sceneID, audit.ActionRestore); err != nil {
+		s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+		return err
+	}
+
+	trashedAt, err := s.sceneManager.GetTrashedAt(ctx, sceneID)
+	if err != nil {
+		return err
+	}
+	if trashedAt == nil {
+		return scene.ErrSceneNotInTrash
+	}
+	if config, _ := s.retentionConfig(); config.TrashRetention > 0 && time.Since(*trashedAt) > config.TrashRetention {
+		return ErrTrashExpired
+	}
+
+	if err := s.sceneManager.RestoreScene(ctx, sceneID); err != nil {
+		return err
+	}
+	s.sceneCache.InvalidateTrash(ctx, sceneID)
+	s.logger.Ctx(ctx).Infof("Restored scene %s from the trash", sceneID.Hex())
+	return nil
+}
+
+// GetTrash returns a page of the user's scenes in the trash, summarized as in the user's history, most recently
+// created first unless query.OldestFirst is set. query.Stage is ignored.
+//
+// Returns user.ErrUserNotFound if the user does not exist.
+func (s *ClientService) GetTrash(ctx context.Context, userID primitive.ObjectID, query HistoryQuery) (_ *HistoryPage, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetTrash", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Get trash request received")
+
+	filter := scene.SceneListFilter{Trash: scene.TrashOnly, OldestFirst: query.OldestFirst}
+	return s.userScenesPage(ctx, userID, filter, query.Page, query.PageSize)
+}
@@ -0,0 +1,153 @@
+// This file contains the upload limits of user tiers, see user.User.JobTier.
+//
+// Unlike the limits of training modes, see VideoLimits, these limit what a user may upload at all: the size of the
+// uploaded file, and the duration of a video. The size is checked against the declared size of an upload before it is
+// read, and again while it is copied to storage, so a body larger than it claims is cut off at the limit rather than
+// stored in full. The duration can only be checked once the video is stored, and a video over the limit is removed.
+//
+// Uploads over a limit are rejected with ErrTooLarge, with the limit of the user's tier in Error.Limit. Admins are
+// not limited.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/broker"
+)
+
+var (
+	// ErrUploadSizeLimit is returned when an upload is larger than the limit of the user's tier.
+	ErrUploadSizeLimit = errors.New("upload size exceeds the limit of your tier")
+	// ErrUploadDurationLimit is returned when an uploaded video is longer than the limit of the user's tier.
+	ErrUploadDurationLimit = errors.New("video duration exceeds the limit of your tier")
+)
+
+// UploadLimits are the bounds on the uploads of a user tier. A zero maximum disables that check.
+type UploadLimits struct {
+	// most bytes of an uploaded file
+	MaxSize int64
+	// longest uploaded video
+	MaxDuration time.Duration
+}
+
+// DefaultUploadLimits returns the default limits for each user tier.
+func DefaultUploadLimits() map[string]UploadLimits {
+	return map[string]UploadLimits{
+		broker.TierFree: {
+			MaxSize:     1024 * 1024 * 1024,
+			MaxDuration: time.Minute,
+		},
+		broker.TierStandard: {
+			MaxSize:     2 * 1024 * 1024 * 1024,
+			MaxDuration: 3 * time.Minute,
+		},
+		broker.TierPriority: {
+			MaxSize: MaxResumableUploadSize,
+		},
+	}
+}
+
+// uploadLimitsFor returns the tier of the user with the given ID and the upload limits of that tier. Admins get zero
+// limits.
+func (s *ClientService) uploadLimitsFor(ctx context.Context, userID primitive.ObjectID) (string, UploadLimits, error) {
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", UploadLimits{}, err
+	}
+	tier := u.JobTier()
+	if u.IsAdmin() {
+		return tier, UploadLimits{}, nil
+	}
+	return tier, s.uploadLimits[tier], nil
+}
+
+// CheckUploadSize checks that the user with the given ID may upload a file of size bytes, i.e the declared length of
+// a request body, before it is read.
+//
+// Returns ErrUploadSizeLimit if size is over the limit of the user's tier.
+func (s *ClientService) CheckUploadSize(ctx context.Context, userID primitive.ObjectID, size int64) (err error) {
+	defer classifyError(&err)
+	tier, limits, err := s.uploadLimitsFor(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if limits.MaxSize > 0 && size > limits.MaxSize {
+		return uploadSizeError(tier, limits.MaxSize, size)
+	}
+	return nil
+}
+
+// checkUploadDuration checks that a video of the given duration is within the limits of the user with the given ID.
+//
+// Returns ErrUploadDurationLimit if it is longer than the limit of the user's tier.
+func (s *ClientService) checkUploadDuration(ctx context.Context, userID primitive.ObjectID, duration time.Duration) error {
+	tier, limits, err := s.uploadLimitsFor(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if limits.MaxDuration > 0 && duration > limits.MaxDuration {
+		return &Error{
+			Kind:    ErrTooLarge,
+			Message: fmt.Sprintf("%s: limit of the %s tier is %s", ErrUploadDurationLimit, tier, limits.MaxDuration),
+			Limit:   map[string]any{"tier": tier, "max_duration_seconds": int64(limits.MaxDuration.Seconds())},
+			Err:     fmt.Errorf("%w: %s", ErrUploadDurationLimit, duration),
+		}
+	}
+	return nil
+}
+
+// uploadSizeError returns the ErrUploadSizeLimit error of an upload of size bytes over the limit of a tier. size is 0
+// if it is not known, as when a body is cut off at the limit.
+func uploadSizeError(tier string, maxSize, size int64) error {
+	cause := fmt.Errorf("%w: received more than %d bytes", ErrUploadSizeLimit, maxSize)
+	if size > 0 {
+		cause = fmt.Errorf("%w: received %d bytes", ErrUploadSizeLimit, size)
+	}
+	return &Error{
+		Kind:    ErrTooLarge,
+		Message: fmt.Sprintf("%s: limit of the %s tier is %d bytes", ErrUploadSizeLimit, tier, maxSize),
+		Limit:   map[string]any{"tier": tier, "max_size_bytes": maxSize},
+		Err:     cause,
+	}
+}
+
+// sizeLimitReader reads from r until more than max bytes were read, and fails with err from then on.
+type sizeLimitReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+	err error
+}
+
+// limitUploadSize returns a reader of r that fails with the ErrUploadSizeLimit error of tier once more than the
+// limit of the tier was read, or r itself if the tier does not limit the size of uploads.
+func limitUploadSize(r io.Reader, tier string, limits UploadLimits) io.Reader {
+	if limits.MaxSize <= 0 {
+		return r
+	}
+	return &sizeLimitReader{r: r, max: limits.MaxSize, err: uploadSizeError(tier, limits.MaxSize, 0)}
+}
+
+// Read implements io.Reader. The read that crosses the limit fails, so a copy is aborted before the excess is written.
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.n > l.max {
+		return 0, l.err
+	}
+	// Read at most one byte past the limit, which is enough to tell that it was crossed
+	if remaining := l.max - l.n + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return 0, l.err
+	}
+	return n, err
+}
@@ -0,0 +1,189 @@
+// This file contains signed upload tokens, which authorize a single user to upload directly from a browser without
+// sending their session token with the upload.
+//
+// A token names the user it was issued to, the most bytes the upload may have, the file extensions it may have, and
+// its expiry, all covered by an HMAC-SHA256 signature. The upload handler accepts the token in place of a session, so
+// the upload can be sent straight from the browser (i.e to an upload host) while the session stays with the API. The
+// constraints are checked against the received file when the upload is finalized, so a client cannot exceed them by
+// misreporting the size up front.
+
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrInvalidUploadToken is returned when an upload token was not issued by this server, or was altered.
+	ErrInvalidUploadToken = errors.New("invalid upload token")
+	// ErrUploadTokenExpired is returned when an upload token is used after its expiry.
+	ErrUploadTokenExpired = errors.New("upload token expired")
+	// ErrUploadTooLarge is returned when an upload is larger than its upload token allows.
+	ErrUploadTooLarge = errors.New("upload is larger than the upload token allows")
+	// ErrUploadExtensionNotAllowed is returned when an upload has a file extension its upload token does not allow.
+	ErrUploadExtensionNotAllowed = errors.New("upload file extension not allowed by the upload token")
+)
+
+// Upload token lifetimes and limits
+const (
+	// DefaultUploadTokenTTL is used when no lifetime is requested.
+	DefaultUploadTokenTTL = 15 * time.Minute
+	// MaxUploadTokenTTL is the longest lifetime an upload token may have.
+	MaxUploadTokenTTL = time.Hour
+	// MaxUploadTokenSize is the largest upload an upload token may allow.
+	MaxUploadTokenSize int64 = 4 * 1024 * 1024 * 1024
+)
+
+// uploadTokenExtensions are the file extensions of uploads authorized by upload tokens.
+var uploadTokenExtensions = []string{".mp4", ".zip"}
+
+// UploadAuthorization is the upload an upload token authorizes.
+type UploadAuthorization struct {
+	UserID     primitive.ObjectID `json:"uid"`
+	MaxSize    int64              `json:"max"`
+	Extensions []string           `json:"ext"`
+	Expires    int64              `json:"exp"`
+}
+
+// UploadToken is a signed upload token, as returned by GenerateUploadToken.
+type UploadToken struct {
+	Token      string    `json:"token"`
+	MaxSize    int64     `json:"max_size"`
+	Extensions []string  `json:"extensions"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// uploadTokenSignature returns the hex HMAC-SHA256 of an encoded upload authorization. The signed text is prefixed,
+// so a signature made for another purpose with the same key (i.e a signed resource URL) is never a valid token.
+func uploadTokenSignature(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "upload-v1\n%s", payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateUploadToken returns a signed token authorizing the user to upload a video of at most maxSize bytes within
+// ttl, without a session. A ttl <= 0 uses DefaultUploadTokenTTL.
+//
+// Returns ErrValidation if maxSize exceeds MaxUploadTokenSize or ttl exceeds MaxUploadTokenTTL.
+func (s *ClientService) GenerateUploadToken(ctx context.Context, userID primitive.ObjectID, maxSize int64, ttl time.Duration) (_ *UploadToken, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GenerateUploadToken", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	if len(s.resourceURLKey) == 0 {
+		return nil, errors.New("upload token signing key not configured")
+	}
+	if ttl <= 0 {
+		ttl = DefaultUploadTokenTTL
+	}
+	if ttl > MaxUploadTokenTTL {
+		return nil, NewValidationError(
+			fmt.Sprintf("upload token lifetime may be at most %s", MaxUploadTokenTTL),
+			map[string]string{"ttl": fmt.Sprintf("must be at most %d seconds", int(MaxUploadTokenTTL.Seconds()))},
+			nil,
+		)
+	}
+	if maxSize <= 0 || maxSize > MaxUploadTokenSize {
+		return nil, NewValidationError(
+			fmt.Sprintf("upload size must be between 1 and %d bytes", MaxUploadTokenSize),
+			map[string]string{"max_size": fmt.Sprintf("must be between 1 and %d", MaxUploadTokenSize)},
+			nil,
+		)
+	}
+	if _, err := s.userManager.GetUserByID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	authorization := &UploadAuthorization{
+		UserID:     userID,
+		MaxSize:    maxSize,
+		Extensions: uploadTokenExtensions,
+		Expires:    expires.Unix(),
+	}
+	data, err := json.Marshal(authorization)
+	if err != nil {
+		return nil, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	return &UploadToken{
+		Token:      payload + "." + uploadTokenSignature(s.resourceURLKey, payload),
+		MaxSize:    maxSize,
+		Extensions: authorization.Extensions,
+		ExpiresAt:  expires,
+	}, nil
+}
+
+// VerifyUploadToken validates the signature and expiry of an upload token, and returns the upload it authorizes.
+// The user it was issued to must still exist, and not be disabled.
+//
+// Returns ErrInvalidUploadToken if the token is malformed or its signature does not match, or ErrUploadTokenExpired if
+// it expired.
+func (s *ClientService) VerifyUploadToken(ctx context.Context, token string) (_ *UploadAuthorization, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.VerifyUploadToken", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || len(s.resourceURLKey) == 0 || !hmac.Equal([]byte(signature), []byte(uploadTokenSignature(s.resourceURLKey, payload))) {
+		return nil, ErrInvalidUploadToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidUploadToken
+	}
+	var authorization UploadAuthorization
+	if err := json.Unmarshal(data, &authorization); err != nil {
+		return nil, ErrInvalidUploadToken
+	}
+	if time.Now().After(time.Unix(authorization.Expires, 0)) {
+		return nil, ErrUploadTokenExpired
+	}
+
+	u, err := s.userManager.GetUserByID(ctx, authorization.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u.Disabled {
+		return nil, user.ErrAccountDisabled
+	}
+	return &authorization, nil
+}
+
+// CheckUploadConstraints checks a received file against the upload its upload token authorizes. The size is the size
+// of the file as received, not as reported by the client.
+//
+// Returns ErrUploadTooLarge or ErrUploadExtensionNotAllowed if the file is outside the token's constraints.
+func (s *ClientService) CheckUploadConstraints(authorization *UploadAuthorization, file *multipart.FileHeader) (err error) {
+	defer classifyError(&err)
+	if file == nil {
+		return NewValidationError("file not received", map[string]string{"file": "required"}, nil)
+	}
+
+	if !slices.Contains(authorization.Extensions, strings.ToLower(filepath.Ext(file.Filename))) {
+		return fmt.Errorf("%w: allowed are %s", ErrUploadExtensionNotAllowed, strings.Join(authorization.Extensions, ", "))
+	}
+	if file.Size > authorization.MaxSize {
+		return fmt.Errorf("%w: received %d bytes, limit is %d", ErrUploadTooLarge, file.Size, authorization.MaxSize)
+	}
+	return nil
+}
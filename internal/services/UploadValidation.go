@@ -0,0 +1,84 @@
+// This file contains validation applied to uploaded files while they are being copied, so obviously invalid uploads
+// are rejected as early as possible instead of after the whole file has been written to storage.
+//
+// The checks here are intentionally cheap and only look at the first few bytes of the stream. They are not a
+// substitute for full validation of the file once it is stored.
+
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+)
+
+var (
+	// ErrBadVideoContent is returned when the content of an uploaded file is clearly not a supported video container.
+	ErrBadVideoContent = errors.New("uploaded file content is not a valid video")
+)
+
+// mp4SniffLen is the number of leading bytes needed to identify an MP4 (ISO base media) file:
+// a 4 byte box size followed by a 4 byte box type.
+const mp4SniffLen = 8
+
+// mp4TopLevelBoxes are the box types an MP4 file may start with. Well formed files start with 'ftyp',
+// but some encoders emit padding or media boxes first.
+var mp4TopLevelBoxes = [][]byte{
+	[]byte("ftyp"),
+	[]byte("moov"),
+	[]byte("mdat"),
+	[]byte("free"),
+	[]byte("skip"),
+	[]byte("wide"),
+}
+
+// mp4SniffReader wraps an upload stream and fails with ErrBadVideoContent as soon as the leading bytes show the
+// stream is not an MP4 file. All bytes are passed through unchanged.
+type mp4SniffReader struct {
+	r       io.Reader
+	head    []byte
+	checked bool
+}
+
+// newMP4SniffReader returns a reader that validates the MP4 container signature of r as it is read.
+func newMP4SniffReader(r io.Reader) *mp4SniffReader {
+	return &mp4SniffReader{r: r, head: make([]byte, 0, mp4SniffLen)}
+}
+
+// Read implements io.Reader. Once enough bytes have been seen to identify the container, or the stream ends before
+// then, the signature is checked and any further reads fail with ErrBadVideoContent if it does not match.
+func (m *mp4SniffReader) Read(p []byte) (int, error) {
+	if m.checked && m.head == nil {
+		return 0, ErrBadVideoContent
+	}
+
+	n, err := m.r.Read(p)
+
+	if !m.checked {
+		need := mp4SniffLen - len(m.head)
+		m.head = append(m.head, p[:min(n, need)]...)
+
+		if len(m.head) == mp4SniffLen || err == io.EOF {
+			m.checked = true
+			if !isMP4Signature(m.head) {
+				// nil head marks the stream as rejected
+				m.head = nil
+				return n, ErrBadVideoContent
+			}
+		}
+	}
+
+	return n, err
+}
+
+// isMP4Signature checks if head starts with a valid MP4 box header.
+func isMP4Signature(head []byte) bool {
+	if len(head) < mp4SniffLen {
+		return false
+	}
+	boxType := head[4:8]
+	return slices.ContainsFunc(mp4TopLevelBoxes, func(box []byte) bool {
+		return bytes.Equal(box, boxType)
+	})
+}
@@ -0,0 +1,222 @@
+// This file contains the paginated scene history of a user, with a summary of each scene for history listings.
+//
+// Scenes are listed from the user's scene list only, newest first by default. The states of the processing pipeline
+// are grouped into the coarser stages clients display (see historyStages), which is also what the history is
+// filtered by.
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Page size limits for scene history listings
+const (
+	DefaultHistoryPageSize = 20
+	MaxHistoryPageSize     = 100
+)
+
+// Stages of the scene history, each grouping one or more scene states
+const (
+	HistoryStageQueued    = "queued"
+	HistoryStageTraining  = "training"
+	HistoryStageDone      = "done"
+	HistoryStageFailed    = "failed"
+	HistoryStageCancelled = "cancelled"
+)
+
+// historyStages maps each history stage to the scene states it groups.
+var historyStages = map[string][]scene.State{
+	HistoryStageQueued:    {scene.StateGracePeriod, scene.StatePendingAdmission, scene.StateQueued},
+	HistoryStageTraining:  {scene.StateSfmRunning, scene.StateSfmDone, scene.StateTraining},
+	HistoryStageDone:      {scene.StateCompleted},
+	HistoryStageFailed:    {scene.StateFailed},
+	HistoryStageCancelled: {scene.StateCancelled},
+}
+
+// historyStageOf returns the history stage of a scene state, or an empty string for an unknown state.
+func historyStageOf(state scene.State) string {
+	for stage, states := range historyStages {
+		for _, s := range states {
+			if s == state {
+				return stage
+			}
+		}
+	}
+	return ""
+}
+
+// HistoryQuery selects a page of a user's scene history. Page is 1-indexed.
+type HistoryQuery struct {
+	// only scenes in this stage, one of the HistoryStage constants. Empty lists every scene
+	Stage       string
+	Page        int
+	PageSize    int
+	OldestFirst bool
+}
+
+// HistoryConfigSummary is the part of a scene's training config shown in history listings.
+type HistoryConfigSummary struct {
+	TrainingMode    string   `json:"training_mode,omitempty"`
+	OutputTypes     []string `json:"output_types,omitempty"`
+	TotalIterations int      `json:"total_iterations,omitempty"`
+	SaveIterations  []int    `json:"save_iterations,omitempty"`
+	Priority        string   `json:"priority"`
+}
+
+// HistoryScene is a single scene in a user's history.
+type HistoryScene struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// history stage, see historyStages, and the exact state it was derived from
+	Stage           string                `json:"stage"`
+	State           scene.State           `json:"state,omitempty"`
+	LatestIteration int                   `json:"latest_iteration"`
+	Config          *HistoryConfigSummary `json:"config,omitempty"`
+	// why the scene failed, only set in the failed stage, see GetJobError
+	Error   string            `json:"error,omitempty"`
+	Failure *scene.JobFailure `json:"failure,omitempty"`
+	// when the scene was moved to the trash, only set in trash listings, see Trash.go
+	TrashedAt *time.Time `json:"trashed_at,omitempty"`
+	// the current training run, and every run of scenes that were retrained as new runs, see SceneVersions.go
+	Version  int              `json:"version"`
+	Versions []HistoryVersion `json:"versions,omitempty"`
+}
+
+// HistoryPage is a page of a user's scene history.
+type HistoryPage struct {
+	Scenes   []HistoryScene `json:"scenes"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	Total    int64          `json:"total"`
+}
+
+// GetUserHistory returns a page of the user's scenes with their name, description, tags, creation time, stage, and
+// training config, and why they failed for failed scenes. Scenes retrained as new runs list each run as a version.
+// Scenes are sorted by creation time, newest first unless query.OldestFirst is set, and can be filtered by stage.
+//
+// Returns ErrValidation if the stage is unknown, or user.ErrUserNotFound if the user does not exist.
+func (s *ClientService) GetUserHistory(ctx context.Context, userID primitive.ObjectID, query HistoryQuery) (_ *HistoryPage, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetUserHistory", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Get user history request received")
+
+	states, err := historyStatesOf(query.Stage)
+	if err != nil {
+		return nil, err
+	}
+	filter := scene.SceneListFilter{States: states, OldestFirst: query.OldestFirst}
+	return s.userScenesPage(ctx, userID, filter, query.Page, query.PageSize)
+}
+
+// historyStatesOf returns the scene states of a history stage, nil for the empty stage, which lists every scene.
+// Returns ErrValidation if the stage is unknown.
+func historyStatesOf(stage string) ([]scene.State, error) {
+	if stage == "" {
+		return nil, nil
+	}
+	states, ok := historyStages[stage]
+	if !ok {
+		stages := make([]string, 0, len(historyStages))
+		for stage := range historyStages {
+			stages = append(stages, stage)
+		}
+		slices.Sort(stages)
+		return nil, NewValidationError(
+			fmt.Sprintf("invalid stage %q", stage),
+			map[string]string{"stage": "must be one of " + strings.Join(stages, ", ")},
+			nil,
+		)
+	}
+	return states, nil
+}
+
+// userScenesPage returns a page of the user's scenes matching filter, see scenesPage.
+func (s *ClientService) userScenesPage(ctx context.Context, userID primitive.ObjectID, filter scene.SceneListFilter, page, pageSize int) (*HistoryPage, error) {
+	u, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	filter.IDs = append(make([]primitive.ObjectID, 0, len(u.SceneIDs)), u.SceneIDs...)
+	return s.scenesPage(ctx, filter, page, pageSize)
+}
+
+// scenesPage returns a page of the scenes matching filter, summarized as in the user's history. Page and pageSize
+// default when not set, and pageSize is capped at MaxHistoryPageSize.
+func (s *ClientService) scenesPage(ctx context.Context, filter scene.SceneListFilter, page, pageSize int) (*HistoryPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = DefaultHistoryPageSize
+	}
+	pageSize = min(pageSize, MaxHistoryPageSize)
+
+	skip := int64(page-1) * int64(pageSize)
+	scenes, total, err := s.sceneManager.ListScenes(ctx, filter, skip, int64(pageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &HistoryPage{
+		Scenes:   make([]HistoryScene, 0, len(scenes)),
+		Page:     page,
+		PageSize: pageSize,
+		Total:    total,
+	}
+	for _, sc := range scenes {
+		entry := HistoryScene{
+			ID:          sc.ID.Hex(),
+			Name:        sc.Name,
+			Description: sc.Description,
+			Tags:        sc.Tags,
+			CreatedAt:   sc.ID.Timestamp(),
+			TrashedAt:   sc.TrashedAt,
+			Version:     sc.CurrentVersion(),
+			Versions:    historyVersions(sc),
+			Config:      historyConfigSummary(sc.Config),
+		}
+		if sc.Status != nil {
+			entry.State = sc.Status.State
+			entry.Stage = historyStageOf(sc.Status.State)
+			entry.LatestIteration = sc.Status.LatestIteration
+			if sc.Status.State == scene.StateFailed {
+				entry.Error = sc.Status.Error
+				entry.Failure = sc.Status.Failure
+			}
+		}
+		result.Scenes = append(result.Scenes, entry)
+	}
+	return result, nil
+}
+
+// historyConfigSummary summarizes a training config for history listings, nil if config is nil.
+func historyConfigSummary(config *scene.TrainingConfig) *HistoryConfigSummary {
+	if config == nil {
+		return nil
+	}
+	summary := &HistoryConfigSummary{Priority: scene.PriorityNormal}
+	if config.Priority != "" {
+		summary.Priority = config.Priority
+	}
+	if nerf := config.NerfTrainingConfig; nerf != nil {
+		summary.TrainingMode = nerf.TrainingMode
+		summary.OutputTypes = nerf.OutputTypes
+		summary.TotalIterations = nerf.TotalIterations
+		summary.SaveIterations = nerf.SaveIterations
+	}
+	return summary
+}
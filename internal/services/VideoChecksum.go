@@ -0,0 +1,144 @@
+// This file contains the checksums of uploaded videos, and the reuse of sfm output across scenes of the same video.
+//
+// The SHA-256 of every uploaded video is computed as it is stored, checked against the checksum the client sent if any,
+// and stored with the scene's video. When the user opts in, a video identical to one of their own scenes that already
+// has sfm output reuses that output instead of running the sfm stage again. The frames are copied into the new scene's
+// layout, hard linked where the file system allows, so deleting either scene never affects the other. Reuse requires
+// the same frame sampling, as it decides which frames the sfm output has, and is skipped when the frames are not on
+// this server's disk, in which case the new scene is processed from the start.
+
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+var (
+	// ErrChecksumMismatch is returned when the SHA-256 of an uploaded video does not match the checksum sent with it.
+	ErrChecksumMismatch = errors.New("uploaded video does not match its checksum")
+)
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// reuseSfm copies the sfm output of the newest scene of the user whose video has the given SHA-256 and that was
+// sampled with the same frame sampling as config, into the layout of sceneID. Scenes whose frames cannot be copied are
+// skipped.
+//
+// Returns the sfm output of sceneID and the bytes copied, or nil if there is none to reuse.
+func (s *ClientService) reuseSfm(ctx context.Context, userID, sceneID primitive.ObjectID, videoSHA256 string, config *scene.NerfTrainingConfig) (*scene.Sfm, int64) {
+	owner, err := s.userManager.GetUserByID(ctx, userID)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to get user to reuse sfm output: %v", err)
+		return nil, 0
+	}
+	candidates, err := s.sceneManager.FindScenesWithVideo(ctx, owner.SceneIDs, videoSHA256)
+	if err != nil {
+		s.logger.Ctx(ctx).Errorf("Failed to find scenes to reuse sfm output of: %v", err)
+		return nil, 0
+	}
+
+	for _, source := range candidates {
+		if source.Config == nil || source.Config.NerfTrainingConfig == nil ||
+			source.Config.NerfTrainingConfig.FrameSampleRate != config.FrameSampleRate ||
+			source.Config.NerfTrainingConfig.TargetFrameCount != config.TargetFrameCount {
+			continue
+		}
+
+		sfm, size, err := s.copySfm(source, sceneID)
+		if err != nil {
+			s.logger.Ctx(ctx).Warnf("Failed to reuse sfm output of scene %s: %v", source.ID.Hex(), err)
+			os.RemoveAll(s.sceneManager.SfmDir(sceneID))
+			continue
+		}
+		return sfm, size
+	}
+	return nil, 0
+}
+
+// copySfm copies the sfm frames of source into the layout of sceneID, and returns the sfm output of sceneID and the
+// bytes copied.
+func (s *ClientService) copySfm(source *scene.Scene, sceneID primitive.ObjectID) (*scene.Sfm, int64, error) {
+	if err := os.MkdirAll(s.sceneManager.SfmDir(sceneID), os.ModePerm); err != nil {
+		return nil, 0, err
+	}
+
+	sourceID := source.ID
+	sfm := &scene.Sfm{
+		IntrinsicMatrix: source.Sfm.IntrinsicMatrix,
+		Frames:          make([]scene.Frame, len(source.Sfm.Frames)),
+		WhiteBackground: source.Sfm.WhiteBackground,
+		Precomputed:     true,
+		ReusedFrom:      &sourceID,
+	}
+	var size int64
+	for i, frame := range source.Sfm.Frames {
+		src, err := frameLocalPath(frame.FilePath)
+		if err != nil {
+			return nil, 0, err
+		}
+		dst, err := s.sceneManager.SfmFramePath(sceneID, filepath.Base(src))
+		if err != nil {
+			return nil, 0, err
+		}
+		written, err := linkOrCopyFile(src, dst)
+		if err != nil {
+			return nil, 0, err
+		}
+		size += written
+
+		sfm.Frames[i] = scene.Frame{
+			FilePath:        s.mqService.toAPIUrl(dst),
+			ExtrinsicMatrix: frame.ExtrinsicMatrix,
+		}
+	}
+	return sfm, size, nil
+}
+
+// linkOrCopyFile hard links src to dst, or copies it if it cannot be linked, and returns the size of the file.
+func linkOrCopyFile(src, dst string) (int64, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return info.Size(), nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
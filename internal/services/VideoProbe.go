@@ -0,0 +1,222 @@
+// This file contains probing of uploaded videos with ffprobe, and the limits uploads are checked against.
+//
+// Limits are looked up by training mode, as some modes need far more GPU memory and time per frame than others.
+// Videos outside the limits are rejected before a scene is created, instead of hogging the worker queue for hours or
+// failing in the sfm-worker after waiting in it.
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+)
+
+var (
+	// ErrVideoProbeFailed is returned when an uploaded video cannot be probed, i.e, it is corrupted or has no video stream.
+	ErrVideoProbeFailed = errors.New("failed to read video properties")
+	// ErrUnsupportedVideoCodec is returned when an uploaded video is encoded with a codec its training mode does not allow.
+	ErrUnsupportedVideoCodec = errors.New("video codec not supported")
+	// ErrVideoTooLong is returned when an uploaded video is longer than the limit of its training mode.
+	ErrVideoTooLong = errors.New("video duration exceeds limit")
+	// ErrVideoResolutionTooHigh is returned when an uploaded video has a higher resolution than the limit of its training mode.
+	ErrVideoResolutionTooHigh = errors.New("video resolution exceeds limit")
+	// ErrVideoTooManyFrames is returned when an uploaded video has more frames than the limit of its training mode.
+	ErrVideoTooManyFrames = errors.New("video frame count exceeds limit")
+	// ErrVideoTooFewFrames is returned when an uploaded video has too few frames for sfm to recover camera poses.
+	ErrVideoTooFewFrames = errors.New("video frame count below minimum")
+	// ErrTooFewSampledFrames is returned when the frame sampling of a training config leaves too few frames of a video
+	// for sfm to recover camera poses.
+	ErrTooFewSampledFrames = errors.New("frame sampling leaves too few frames")
+)
+
+// VideoLimits are the bounds an uploaded video must be within. A zero maximum disables that check.
+//
+// Resolution is bounded by edge length rather than width and height, so portrait and landscape videos are treated alike.
+// AllowedCodecs are the ffprobe codec names (i.e "h264") accepted, and any codec is accepted if it is empty.
+type VideoLimits struct {
+	AllowedCodecs []string
+	MaxDuration   time.Duration
+	MaxLongEdge   int
+	MaxShortEdge  int
+	MaxFrames     int
+	MinFrames     int
+}
+
+// DefaultVideoCodecs are the video codecs the sfm-worker's ffmpeg build can decode.
+var DefaultVideoCodecs = []string{"h264", "hevc", "mpeg4", "vp9", "av1"}
+
+// DefaultVideoLimits returns the default limits for each training mode.
+func DefaultVideoLimits() map[string]VideoLimits {
+	return map[string]VideoLimits{
+		scene.TrainingModeGaussian: {
+			AllowedCodecs: DefaultVideoCodecs,
+			MaxDuration:   3 * time.Minute,
+			MaxLongEdge:   1920,
+			MaxShortEdge:  1080,
+			MaxFrames:     5400,
+			MinFrames:     30,
+		},
+		scene.TrainingModeTensorf: {
+			AllowedCodecs: DefaultVideoCodecs,
+			MaxDuration:   2 * time.Minute,
+			MaxLongEdge:   1280,
+			MaxShortEdge:  720,
+			MaxFrames:     3600,
+			MinFrames:     30,
+		},
+	}
+}
+
+// VideoProbe holds the properties of a video stream, as reported by ffprobe. Codec is empty for probes that do not
+// come from a video, i.e the images of an image set.
+type VideoProbe struct {
+	Codec      string
+	Width      int
+	Height     int
+	FPS        float64
+	Duration   time.Duration
+	FrameCount int
+}
+
+// probeVideo reads the properties of the first video stream of the file at path with ffprobe.
+//
+// The frame count is read from the container when available, and estimated from duration and frame rate otherwise.
+// Returns ErrVideoProbeFailed if the file has no readable video stream.
+func probeVideo(ctx context.Context, path string) (*VideoProbe, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,width,height,r_frame_rate,nb_frames,duration:format=duration",
+		"-of", "json",
+		path,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVideoProbeFailed, err)
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecName  string `json:"codec_name"`
+			Width      int    `json:"width"`
+			Height     int    `json:"height"`
+			RFrameRate string `json:"r_frame_rate"`
+			NbFrames   string `json:"nb_frames"`
+			Duration   string `json:"duration"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVideoProbeFailed, err)
+	}
+	if len(result.Streams) == 0 || result.Streams[0].Width == 0 || result.Streams[0].Height == 0 {
+		return nil, fmt.Errorf("%w: no video stream", ErrVideoProbeFailed)
+	}
+	stream := result.Streams[0]
+
+	// Stream duration is missing for some containers, fall back to the container duration
+	seconds, err := strconv.ParseFloat(stream.Duration, 64)
+	if err != nil {
+		seconds, _ = strconv.ParseFloat(result.Format.Duration, 64)
+	}
+
+	probe := &VideoProbe{
+		Codec:    stream.CodecName,
+		Width:    stream.Width,
+		Height:   stream.Height,
+		FPS:      parseFrameRate(stream.RFrameRate),
+		Duration: time.Duration(seconds * float64(time.Second)),
+	}
+
+	probe.FrameCount, err = strconv.Atoi(stream.NbFrames)
+	if err != nil || probe.FrameCount == 0 {
+		probe.FrameCount = int(seconds * probe.FPS)
+	}
+
+	return probe, nil
+}
+
+// parseFrameRate parses an ffprobe rational frame rate (i.e, "30000/1001"). Returns 0 if it is malformed.
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// Check returns an error stating the detected value and the limit of the first bound the probed video is outside of.
+func (l VideoLimits) Check(probe *VideoProbe) error {
+	longEdge, shortEdge := max(probe.Width, probe.Height), min(probe.Width, probe.Height)
+
+	switch {
+	case probe.Codec != "" && len(l.AllowedCodecs) > 0 && !slices.Contains(l.AllowedCodecs, probe.Codec):
+		return fmt.Errorf("%w: detected %s, allowed are %s, re-encode the video (i.e as H.264) and upload it again",
+			ErrUnsupportedVideoCodec, probe.Codec, strings.Join(l.AllowedCodecs, ", "))
+	case l.MaxDuration > 0 && probe.Duration > l.MaxDuration:
+		return fmt.Errorf("%w: detected %s, limit is %s",
+			ErrVideoTooLong, probe.Duration.Round(time.Second), l.MaxDuration)
+	case (l.MaxLongEdge > 0 && longEdge > l.MaxLongEdge) || (l.MaxShortEdge > 0 && shortEdge > l.MaxShortEdge):
+		return fmt.Errorf("%w: detected %dx%d, limit is %dx%d",
+			ErrVideoResolutionTooHigh, probe.Width, probe.Height, l.MaxLongEdge, l.MaxShortEdge)
+	case l.MaxFrames > 0 && probe.FrameCount > l.MaxFrames:
+		return fmt.Errorf("%w: detected %d frames, limit is %d",
+			ErrVideoTooManyFrames, probe.FrameCount, l.MaxFrames)
+	case probe.FrameCount < l.MinFrames:
+		return fmt.Errorf("%w: detected %d frames, minimum is %d",
+			ErrVideoTooFewFrames, probe.FrameCount, l.MinFrames)
+	}
+	return nil
+}
+
+// CheckSampling returns an error if the frame sampling of config is not sensible for a video of frameCount frames:
+// an invalid combination of options, a target frame count above the frames in the video, or sampling that leaves fewer
+// than MinFrames frames. Configs that do not override frame sampling always pass, as the sfm-worker picks its own.
+func (l VideoLimits) CheckSampling(config *scene.NerfTrainingConfig, frameCount int) error {
+	if err := config.ValidateFrameSampling(); err != nil {
+		return err
+	}
+	if !config.HasFrameSampling() {
+		return nil
+	}
+	if config.TargetFrameCount > frameCount {
+		return NewValidationError(
+			fmt.Sprintf("target frame count %d exceeds the %d frames of the video", config.TargetFrameCount, frameCount),
+			map[string]string{"target_frame_count": fmt.Sprintf("must be at most %d", frameCount)},
+			scene.ErrInvalidTrainingConfig,
+		)
+	}
+
+	sampled := config.SampledFrameCount(frameCount)
+	if sampled >= l.MinFrames {
+		return nil
+	}
+	field, hint := "target_frame_count", fmt.Sprintf("must be at least %d", l.MinFrames)
+	if config.FrameSampleRate > 0 {
+		field, hint = "frame_sample_rate", fmt.Sprintf("must be at most %d", max(frameCount/max(l.MinFrames, 1), 1))
+	}
+	return NewValidationError(
+		fmt.Sprintf("%s: %d of %d frames sampled, minimum is %d", ErrTooFewSampledFrames, sampled, frameCount, l.MinFrames),
+		map[string]string{field: hint},
+		ErrTooFewSampledFrames,
+	)
+}
@@ -0,0 +1,557 @@
+// This file contains the webhooks users register to be told about the jobs of their scenes.
+//
+// Status events published to status streams (see StatusStream.go) are also handed to Webhooks, which turns the ones
+// webhooks care about into deliveries, one per subscribed webhook, stored in the webhook_deliveries collection.
+// Training progress is sent at most once every webhookProgressInterval per scene. A background dispatcher POSTs due
+// deliveries, and retries those that are not accepted with a 2xx response with an exponential backoff, until
+// webhookMaxAttempts attempts failed. Deliveries are kept for inspection for a week after they finished.
+//
+// Payloads are signed with the secret of the webhook, which is only shown when the webhook is created. The signature
+// is sent in the X-Webhook-Signature header as "t=<unix time>,v1=<hex HMAC-SHA256 of '<unix time>.<body>'>", so
+// receivers can reject forged and replayed deliveries. Delivery is at least once, and events may arrive out of order.
+//
+// Events are handed over best effort, like audit events: they are queued in a bounded buffer, and dropped if it is
+// full. Webhooks are not sent to private, loopback or link-local addresses unless WebhookConfig allows it.
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/webhook"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+var (
+	// ErrInvalidWebhookURL is returned when registering a webhook whose URL is not an absolute http(s) URL.
+	ErrInvalidWebhookURL = errors.New("webhook URL must be an absolute http or https URL")
+	// ErrInvalidWebhookEvent is returned when registering a webhook for an unknown event type.
+	ErrInvalidWebhookEvent = errors.New("invalid webhook event")
+	// ErrTooManyWebhooks is returned when registering a webhook for a user that has maxWebhooksPerUser webhooks.
+	ErrTooManyWebhooks = errors.New("too many webhooks, remove one first")
+	// errWebhookAddressNotAllowed is returned when a webhook URL resolves to an address webhooks may not be sent to.
+	errWebhookAddressNotAllowed = errors.New("webhook address not allowed")
+)
+
+// Webhook event types
+const (
+	WebhookEventSfmStarted       = "sfm_started"
+	WebhookEventSfmComplete      = "sfm_complete"
+	WebhookEventTrainingStarted  = "training_started"
+	WebhookEventTrainingProgress = "training_progress"
+	WebhookEventTrainingComplete = "training_complete"
+	WebhookEventJobFailed        = "job_failed"
+)
+
+// ValidWebhookEvents lists the event types webhooks may subscribe to.
+var ValidWebhookEvents = []string{
+	WebhookEventSfmStarted,
+	WebhookEventSfmComplete,
+	WebhookEventTrainingStarted,
+	WebhookEventTrainingProgress,
+	WebhookEventTrainingComplete,
+	WebhookEventJobFailed,
+}
+
+// Webhook delivery settings
+const (
+	// webhookBufferSize is the most events queued to be turned into deliveries, further events are dropped.
+	webhookBufferSize = 1024
+	// webhookInterval is how often due deliveries are checked for, besides when deliveries are added.
+	webhookInterval = 5 * time.Second
+	// webhookBatchSize is the most deliveries read at once.
+	webhookBatchSize = 100
+	// webhookConcurrency is the most deliveries sent at once.
+	webhookConcurrency = 8
+	// webhookTimeout bounds a single attempt.
+	webhookTimeout = 10 * time.Second
+	// webhookMaxAttempts is how often a delivery is attempted before it is given up on.
+	webhookMaxAttempts = 8
+	// webhookBaseBackoff is the wait after the first failed attempt, doubled after each further one.
+	webhookBaseBackoff = 30 * time.Second
+	// webhookMaxBackoff is the longest wait between attempts.
+	webhookMaxBackoff = time.Hour
+	// webhookProgressInterval is the least time between two training_progress deliveries of the same scene.
+	webhookProgressInterval = 30 * time.Second
+	// maxWebhooksPerUser is the most webhooks a user may have at once.
+	maxWebhooksPerUser = 10
+	// maxWebhookDeliveries is the most deliveries returned by GetWebhookDeliveries.
+	maxWebhookDeliveries = 100
+)
+
+// Webhook request headers
+const (
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// WebhookConfig configures the delivery of webhooks.
+type WebhookConfig struct {
+	// send webhooks to private, loopback and link-local addresses, i.e for development
+	AllowPrivateNetworks bool
+}
+
+// WebhookPayload is the JSON body of a webhook delivery. Status events carry State and Error, progress events carry
+// Progress.
+type WebhookPayload struct {
+	ID       primitive.ObjectID `json:"id"`
+	Event    string             `json:"event"`
+	SceneID  primitive.ObjectID `json:"scene_id"`
+	State    scene.State        `json:"state,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	Progress *scene.Progress    `json:"progress,omitempty"`
+	Time     time.Time          `json:"time"`
+}
+
+// webhookEvent is a status event of a scene waiting to be turned into deliveries.
+type webhookEvent struct {
+	sceneID primitive.ObjectID
+	event   JobStatusEvent
+}
+
+// Webhooks turns status events into webhook deliveries and sends them in the background, see Notify.
+type Webhooks struct {
+	manager     *webhook.WebhookManager
+	userManager user.UserRepository
+	client      *http.Client
+	metrics     *metrics.Metrics
+	logger      *log.Logger
+	events      chan webhookEvent
+	// events dropped since the last warning
+	dropped atomic.Int64
+	// wakes the dispatcher
+	ready chan struct{}
+	// when the last training_progress deliveries of each scene were added, only used by the enqueuer
+	lastProgress map[primitive.ObjectID]time.Time
+	stop         chan struct{}
+	wg           sync.WaitGroup
+	closeOnce    sync.Once
+}
+
+// NewWebhooks creates Webhooks storing deliveries with manager, and starts turning events into deliveries and sending
+// them. Close must be called to stop it.
+func NewWebhooks(manager *webhook.WebhookManager, userManager user.UserRepository, config WebhookConfig, m *metrics.Metrics, logger *log.Logger) *Webhooks {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !config.AllowPrivateNetworks {
+		dialer.Control = refusePrivateAddresses
+	}
+	w := &Webhooks{
+		manager:     manager,
+		userManager: userManager,
+		client: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			// A redirect is an answer like any other, following it could lead to an address that is not allowed
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		metrics:      m,
+		logger:       logger,
+		events:       make(chan webhookEvent, webhookBufferSize),
+		ready:        make(chan struct{}, 1),
+		lastProgress: make(map[primitive.ObjectID]time.Time),
+		stop:         make(chan struct{}),
+	}
+	w.wg.Add(2)
+	go w.runEnqueuer()
+	go w.runDispatcher()
+	return w
+}
+
+// refusePrivateAddresses refuses connections to private, loopback, link-local and unspecified addresses. It is called
+// with the resolved address, so host names resolving to such addresses are refused too.
+func refusePrivateAddresses(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", errWebhookAddressNotAllowed, host)
+	}
+	return nil
+}
+
+// Notify queues a status event of a scene to be sent to the webhooks subscribed to it. It never blocks, the event is
+// dropped if the queue is full. A nil Webhooks sends nothing.
+func (w *Webhooks) Notify(sceneID primitive.ObjectID, event JobStatusEvent) {
+	if w == nil {
+		return
+	}
+	select {
+	case w.events <- webhookEvent{sceneID: sceneID, event: event}:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// Close stops sending webhooks. Queued events that were not turned into deliveries are lost, pending deliveries are
+// sent once the server starts again.
+func (w *Webhooks) Close() {
+	if w == nil {
+		return
+	}
+	w.closeOnce.Do(func() { close(w.stop) })
+	w.wg.Wait()
+}
+
+// wake wakes the dispatcher to send due deliveries.
+func (w *Webhooks) wake() {
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// webhookEventType returns the webhook event type of a status event, or an empty string if webhooks are not told
+// about it.
+func webhookEventType(event JobStatusEvent) string {
+	if event.Type == StatusEventProgress {
+		if event.Progress != nil && event.Progress.Stage == metrics.StageTraining {
+			return WebhookEventTrainingProgress
+		}
+		return ""
+	}
+	switch event.State {
+	case scene.StateSfmRunning:
+		return WebhookEventSfmStarted
+	case scene.StateSfmDone:
+		return WebhookEventSfmComplete
+	case scene.StateTraining:
+		return WebhookEventTrainingStarted
+	case scene.StateCompleted:
+		return WebhookEventTrainingComplete
+	case scene.StateFailed:
+		return WebhookEventJobFailed
+	default:
+		return ""
+	}
+}
+
+// runEnqueuer turns queued events into deliveries, until Webhooks is closed.
+func (w *Webhooks) runEnqueuer() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case e := <-w.events:
+			if dropped := w.dropped.Swap(0); dropped > 0 {
+				w.logger.Warnf("Dropped %d webhook events, the webhook buffer is full", dropped)
+			}
+			if err := w.enqueue(context.Background(), e.sceneID, e.event); err != nil {
+				w.logger.Errorf("Failed to queue webhooks of scene %s: %v", e.sceneID.Hex(), err)
+			}
+		}
+	}
+}
+
+// enqueue adds a delivery of an event for every webhook subscribed to it.
+func (w *Webhooks) enqueue(ctx context.Context, sceneID primitive.ObjectID, event JobStatusEvent) error {
+	eventType := webhookEventType(event)
+	if eventType == "" {
+		return nil
+	}
+	if eventType == WebhookEventTrainingProgress {
+		if time.Since(w.lastProgress[sceneID]) < webhookProgressInterval {
+			return nil
+		}
+		w.lastProgress[sceneID] = time.Now()
+	} else if event.State.IsTerminal() {
+		delete(w.lastProgress, sceneID)
+	}
+
+	owner, err := w.userManager.GetUserBySceneID(ctx, sceneID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		// The scene was deleted
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	webhooks, err := w.manager.GetSubscribedWebhooks(ctx, owner.ID, sceneID, eventType)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]*webhook.Delivery, 0, len(webhooks))
+	for _, hook := range webhooks {
+		payload, err := json.Marshal(WebhookPayload{
+			ID:       primitive.NewObjectID(),
+			Event:    eventType,
+			SceneID:  sceneID,
+			State:    event.State,
+			Error:    event.Error,
+			Progress: event.Progress,
+			Time:     event.Time,
+		})
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, &webhook.Delivery{
+			ID:            primitive.NewObjectID(),
+			WebhookID:     hook.ID,
+			UserID:        hook.UserID,
+			SceneID:       sceneID,
+			Event:         eventType,
+			Payload:       payload,
+			Status:        webhook.DeliveryPending,
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		})
+	}
+	if err := w.manager.AddDeliveries(ctx, deliveries); err != nil {
+		return err
+	}
+	w.wake()
+	return nil
+}
+
+// runDispatcher sends due deliveries whenever deliveries are added or webhookInterval passes, until Webhooks is
+// closed. Deliveries left pending by a restart are sent on the first pass.
+func (w *Webhooks) runDispatcher() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(webhookInterval)
+	defer ticker.Stop()
+
+	for {
+		w.dispatch(context.Background())
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		case <-w.ready:
+		}
+	}
+}
+
+// dispatch sends the due deliveries, webhookConcurrency at a time.
+func (w *Webhooks) dispatch(ctx context.Context) {
+	for {
+		deliveries, err := w.manager.GetDueDeliveries(ctx, time.Now(), webhookBatchSize)
+		if err != nil {
+			w.logger.Errorf("Failed to get due webhook deliveries: %v", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, webhookConcurrency)
+		for _, d := range deliveries {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				w.deliver(ctx, d)
+			}()
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// deliver makes an attempt to send a delivery, and records its outcome.
+func (w *Webhooks) deliver(ctx context.Context, d *webhook.Delivery) {
+	hook, err := w.manager.GetWebhook(ctx, d.UserID, d.WebhookID)
+	if errors.Is(err, webhook.ErrWebhookNotFound) {
+		// Removed since the delivery was read, along with its deliveries
+		return
+	}
+	if err != nil {
+		w.logger.Errorf("Failed to get webhook %s: %v", d.WebhookID.Hex(), err)
+		return
+	}
+
+	statusCode, sendErr := w.send(ctx, hook, d)
+	status, next := webhook.DeliveryDelivered, time.Time{}
+	switch {
+	case sendErr == nil:
+		w.metrics.WebhookDeliveriesTotal.WithLabelValues("delivered").Inc()
+	case d.Attempts+1 >= webhookMaxAttempts:
+		status = webhook.DeliveryFailed
+		w.metrics.WebhookDeliveriesTotal.WithLabelValues("failed").Inc()
+		w.logger.Warnf("Giving up on %s delivery %s to webhook %s after %d attempts: %v",
+			d.Event, d.ID.Hex(), hook.ID.Hex(), d.Attempts+1, sendErr)
+	default:
+		status = webhook.DeliveryPending
+		next = time.Now().Add(min(webhookBaseBackoff<<min(d.Attempts, 16), webhookMaxBackoff))
+		w.metrics.WebhookDeliveriesTotal.WithLabelValues("retried").Inc()
+		w.logger.Debugf("Failed %s delivery %s to webhook %s, retrying at %s: %v", d.Event, d.ID.Hex(), hook.ID.Hex(), next, sendErr)
+	}
+
+	if err := w.manager.RecordAttempt(ctx, d.ID, status, statusCode, sendErr, next); err != nil {
+		w.logger.Errorf("Failed to record attempt of webhook delivery %s: %v", d.ID.Hex(), err)
+	}
+}
+
+// send POSTs a delivery to its webhook, signed with the webhook's secret. Returns the response status code, zero if
+// no response was received, and an error unless the delivery was accepted with a 2xx response.
+func (w *Webhooks) send(ctx context.Context, hook *webhook.Webhook, d *webhook.Delivery) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "POST webhook", tracing.KindClient)
+	defer span.EndWithError(&err)
+	span.SetAttribute("webhook.event", d.Event)
+	span.SetAttribute("scene.id", d.SceneID.Hex())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "VidGoNerf-Webhooks")
+	req.Header.Set(webhookEventHeader, d.Event)
+	req.Header.Set(webhookDeliveryHeader, d.ID.Hex())
+	req.Header.Set(webhookSignatureHeader, signWebhookPayload(hook.Secret, time.Now(), d.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhookPayload returns the X-Webhook-Signature header of a payload sent at the given time.
+func signWebhookPayload(secret string, at time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreatedWebhook is a newly created webhook. Secret is the only copy of the signing secret shown to the user.
+type CreatedWebhook struct {
+	*webhook.Webhook
+	Secret string `json:"secret"`
+}
+
+// CreateWebhook registers a webhook of the user with the given ID for the given event types, all of them if none are
+// given. If sceneID is not nil, the webhook only receives the events of that scene, which the user must have access
+// to. Otherwise it receives the events of every scene the user owns.
+//
+// Returns ErrInvalidWebhookURL or ErrInvalidWebhookEvent if the URL or an event type is not valid, ErrTooManyWebhooks
+// if the user has too many webhooks, or error if the user does not have access to the scene or an error occurred.
+func (s *ClientService) CreateWebhook(ctx context.Context, userID, sceneID primitive.ObjectID, rawURL string, events []string) (_ *CreatedWebhook, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.CreateWebhook", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	s.logger.Ctx(ctx).Debug("Create webhook request received")
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, NewValidationError(ErrInvalidWebhookURL.Error(), map[string]string{"url": "must be an absolute http or https URL"}, ErrInvalidWebhookURL)
+	}
+	for _, event := range events {
+		if !slices.Contains(ValidWebhookEvents, event) {
+			return nil, NewValidationError(
+				ErrInvalidWebhookEvent.Error(),
+				map[string]string{"events": "unknown event " + event},
+				ErrInvalidWebhookEvent,
+			)
+		}
+	}
+	if !sceneID.IsZero() {
+		if err := s.authorize(ctx, userID, sceneID, audit.ActionReadStatus); err != nil {
+			s.logger.Ctx(ctx).Info("Invalid user ID access:", err.Error())
+			return nil, err
+		}
+	}
+
+	count, err := s.webhooks.manager.CountUserWebhooks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxWebhooksPerUser {
+		return nil, ErrTooManyWebhooks
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	hook := &webhook.Webhook{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		SceneID:   sceneID,
+		URL:       parsed.String(),
+		Events:    events,
+		Secret:    hex.EncodeToString(b),
+		CreatedAt: time.Now(),
+	}
+	if err := s.webhooks.manager.CreateWebhook(ctx, hook); err != nil {
+		return nil, err
+	}
+
+	s.logger.Ctx(ctx).Infof("Created webhook %s for user %s", hook.ID.Hex(), userID.Hex())
+	return &CreatedWebhook{Webhook: hook, Secret: hook.Secret}, nil
+}
+
+// ListWebhooks returns the webhooks of the user with the given ID. Their secrets are not included.
+func (s *ClientService) ListWebhooks(ctx context.Context, userID primitive.ObjectID) (_ []*webhook.Webhook, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.ListWebhooks", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	return s.webhooks.manager.GetUserWebhooks(ctx, userID)
+}
+
+// DeleteWebhook removes a webhook of the user with the given ID, and its deliveries, including those not yet sent.
+//
+// Returns webhook.ErrWebhookNotFound if the user has no webhook with the given ID.
+func (s *ClientService) DeleteWebhook(ctx context.Context, userID, webhookID primitive.ObjectID) (err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.DeleteWebhook", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.webhooks.manager.DeleteWebhook(ctx, userID, webhookID); err != nil {
+		return err
+	}
+	s.logger.Ctx(ctx).Infof("Deleted webhook %s of user %s", webhookID.Hex(), userID.Hex())
+	return nil
+}
+
+// GetWebhookDeliveries returns the most recent deliveries of a webhook of the user with the given ID, newest first,
+// with the outcome of their last attempt.
+//
+// Returns webhook.ErrWebhookNotFound if the user has no webhook with the given ID.
+func (s *ClientService) GetWebhookDeliveries(ctx context.Context, userID, webhookID primitive.ObjectID) (_ []*webhook.Delivery, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetWebhookDeliveries", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if _, err := s.webhooks.manager.GetWebhook(ctx, userID, webhookID); err != nil {
+		return nil, err
+	}
+	return s.webhooks.manager.GetWebhookDeliveries(ctx, webhookID, maxWebhookDeliveries)
+}
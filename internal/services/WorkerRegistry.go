@@ -0,0 +1,204 @@
+// This file contains the registry of GPU workers, which lets admins see the capacity of the cluster and users be warned
+// when their upload will wait for a worker.
+//
+// Workers register with the worker key on startup, and then send a heartbeat with their capacity every
+// WorkerHeartbeatInterval. A worker is available while its last heartbeat is within WorkerHeartbeatTimeout, and its
+// registration is removed once it sent none for workerRegistrationTTL, so a dead worker shows as unavailable for a
+// while before disappearing. Jobs are still delivered through the message broker, the registry only tracks liveness.
+
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/worker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// Heartbeat timing of registered workers
+const (
+	// WorkerHeartbeatInterval is how often workers are asked to send a heartbeat.
+	WorkerHeartbeatInterval = 30 * time.Second
+	// WorkerHeartbeatTimeout is how long after its last heartbeat a worker is considered unavailable.
+	WorkerHeartbeatTimeout = 3 * WorkerHeartbeatInterval
+	// workerRegistrationTTL is how long the registration of a worker that stopped sending heartbeats is kept.
+	workerRegistrationTTL = 24 * time.Hour
+)
+
+// WorkerRegistration is what a worker registers with. The ID is chosen by the worker, see worker.Worker.
+type WorkerRegistration struct {
+	ID       string
+	Kind     string
+	Hostname string
+	Version  string
+	Capacity worker.Capacity
+}
+
+// WorkerRegistered is returned to a registered worker, telling it how often to send heartbeats.
+type WorkerRegistered struct {
+	ID                       string `json:"id"`
+	HeartbeatIntervalSeconds int    `json:"heartbeat_interval_seconds"`
+}
+
+// WorkerStatus is a registered worker, and if it is available.
+type WorkerStatus struct {
+	*worker.Worker
+	Available bool `json:"available"`
+}
+
+// WorkerKindStatus sums up the workers of a kind. Only available workers count towards the slots and active jobs.
+type WorkerKindStatus struct {
+	Registered int `json:"registered"`
+	Available  int `json:"available"`
+	Slots      int `json:"slots"`
+	ActiveJobs int `json:"active_jobs"`
+}
+
+// ClusterStatus is the state of every registered worker, summed up by kind.
+type ClusterStatus struct {
+	Kinds     map[string]WorkerKindStatus `json:"kinds"`
+	Workers   []WorkerStatus              `json:"workers"`
+	CheckedAt time.Time                   `json:"checked_at"`
+}
+
+// RegisterWorker registers a worker, replacing any registration with the same ID, i.e from before a restart.
+//
+// Returns worker.ErrInvalidWorkerKind if the kind is not one of the worker kinds, or error if an error occurred.
+func (s *WorkerService) RegisterWorker(ctx context.Context, registration WorkerRegistration) (_ *WorkerRegistered, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "WorkerService.RegisterWorker", tracing.KindInternal)
+	defer span.EndWithError(&err)
+
+	if !worker.IsValidKind(registration.Kind) {
+		return nil, NewValidationError(
+			worker.ErrInvalidWorkerKind.Error(),
+			map[string]string{"kind": "must be one of " + worker.KindSfm + ", " + worker.KindNerf},
+			worker.ErrInvalidWorkerKind,
+		)
+	}
+
+	now := time.Now().UTC()
+	err = s.workerManager.RegisterWorker(ctx, &worker.Worker{
+		ID:            registration.ID,
+		Kind:          registration.Kind,
+		Hostname:      registration.Hostname,
+		Version:       registration.Version,
+		RegisteredAt:  now,
+		LastHeartbeat: now,
+		Capacity:      registration.Capacity,
+		ExpiresAt:     now.Add(workerRegistrationTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Ctx(ctx).Infof("Registered %s worker %s with %d slots", registration.Kind, registration.ID, registration.Capacity.Slots)
+	return &WorkerRegistered{ID: registration.ID, HeartbeatIntervalSeconds: int(WorkerHeartbeatInterval.Seconds())}, nil
+}
+
+// RecordWorkerHeartbeat records a heartbeat of a registered worker with its current capacity.
+//
+// Returns worker.ErrWorkerNotFound if the worker is not registered, in which case it should register again, or error
+// if an error occurred.
+func (s *WorkerService) RecordWorkerHeartbeat(ctx context.Context, id string, capacity worker.Capacity) (err error) {
+	defer classifyError(&err)
+	now := time.Now().UTC()
+	return s.workerManager.RecordHeartbeat(ctx, id, capacity, now, now.Add(workerRegistrationTTL))
+}
+
+// DeregisterWorker removes the registration of a worker, i.e as it shuts down.
+//
+// Returns worker.ErrWorkerNotFound if the worker is not registered, or error if an error occurred.
+func (s *WorkerService) DeregisterWorker(ctx context.Context, id string) (err error) {
+	defer classifyError(&err)
+	if err := s.workerManager.DeregisterWorker(ctx, id); err != nil {
+		return err
+	}
+	s.logger.Ctx(ctx).Infof("Deregistered worker %s", id)
+	return nil
+}
+
+// collectWorkersAvailable refreshes the available workers gauge, by kind.
+func (s *WorkerService) collectWorkersAvailable() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	since := time.Now().Add(-WorkerHeartbeatTimeout)
+	for _, kind := range []string{worker.KindSfm, worker.KindNerf} {
+		available, err := s.workerManager.CountLiveWorkers(ctx, kind, since)
+		if err != nil {
+			s.logger.Warnf("Failed to count available %s workers for metrics: %v", kind, err)
+			return
+		}
+		s.metrics.WorkersAvailable.WithLabelValues(kind).Set(float64(available))
+	}
+}
+
+// GetClusterStatus returns the state of every registered worker, and the available workers and capacity by kind.
+//
+// Returns user.ErrUserNoAccess if the user is not an admin, ErrWorkerAPIDisabled if workers cannot register, or error
+// if an error occurred.
+func (s *ClientService) GetClusterStatus(ctx context.Context, adminUserID primitive.ObjectID) (_ *ClusterStatus, err error) {
+	defer classifyError(&err)
+	ctx, span := tracing.Start(ctx, "ClientService.GetClusterStatus", tracing.KindInternal)
+	defer span.EndWithError(&err)
+	if err := s.verifyAdmin(ctx, adminUserID); err != nil {
+		return nil, err
+	}
+	if s.workerManager == nil {
+		return nil, ErrWorkerAPIDisabled
+	}
+
+	workers, err := s.workerManager.ListWorkers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	status := &ClusterStatus{
+		Kinds:     map[string]WorkerKindStatus{worker.KindSfm: {}, worker.KindNerf: {}},
+		Workers:   make([]WorkerStatus, 0, len(workers)),
+		CheckedAt: now.UTC(),
+	}
+	for _, w := range workers {
+		available := now.Sub(w.LastHeartbeat) <= WorkerHeartbeatTimeout
+		status.Workers = append(status.Workers, WorkerStatus{Worker: w, Available: available})
+
+		kind := status.Kinds[w.Kind]
+		kind.Registered++
+		if available {
+			kind.Available++
+			kind.Slots += w.Capacity.Slots
+			kind.ActiveJobs += w.Capacity.ActiveJobs
+		}
+		status.Kinds[w.Kind] = kind
+	}
+	return status, nil
+}
+
+// WorkerAvailabilityWarning returns a warning for the user if no worker of one of the given kinds is available, so
+// their scene would wait in the queue until one is, or an empty string if workers are available. No warning is given if
+// workers cannot register, or their availability cannot be read, as the upload is accepted either way.
+func (s *ClientService) WorkerAvailabilityWarning(ctx context.Context, kinds ...string) string {
+	if s.workerManager == nil {
+		return ""
+	}
+	since := time.Now().Add(-WorkerHeartbeatTimeout)
+	var unavailable []string
+	for _, kind := range kinds {
+		available, err := s.workerManager.CountLiveWorkers(ctx, kind, since)
+		if err != nil {
+			s.logger.Ctx(ctx).Warnf("Failed to count available %s workers: %v", kind, err)
+			return ""
+		}
+		if available == 0 {
+			unavailable = append(unavailable, kind)
+		}
+	}
+	if len(unavailable) == 0 {
+		return ""
+	}
+	return "No " + strings.Join(unavailable, " or ") + " workers are available. The scene will wait in the queue until one is."
+}
@@ -0,0 +1,300 @@
+// This file contains the worker facing API, which lets GPU workers push their outputs to the server instead of the
+// server downloading them from worker URLs. Workers also register and send heartbeats with it, see WorkerRegistry.go.
+//
+// Workers authenticate with a shared key. An output file, one per output type and iteration, is uploaded in chunks,
+// each at the offset the file has reached, so a chunk lost to a dropped connection is sent again from the offset
+// reported by GetOutputUpload. Chunks are staged next to the final path of the output, and the last chunk moves the
+// file into place and records it in the scene's Nerf document right away, so finished iterations can be downloaded
+// while training continues. The nerf-out message that completes the scene lists uploaded outputs without a URL, see
+// AMPQService.processNERFJob.
+
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/worker"
+)
+
+var (
+	// ErrWorkerAPIDisabled is returned when a worker request is made while no worker key is configured.
+	ErrWorkerAPIDisabled = errors.New("worker API is disabled")
+	// ErrInvalidWorkerKey is returned when a worker request carries a missing or incorrect worker key.
+	ErrInvalidWorkerKey = errors.New("invalid worker key")
+	// ErrSceneNotTraining is returned when outputs are uploaded for a scene that is not training.
+	ErrSceneNotTraining = errors.New("scene is not training")
+	// ErrOutputNotExpected is returned when an output type or iteration is uploaded that the scene's config did not ask for.
+	ErrOutputNotExpected = errors.New("output not expected by the training config")
+	// ErrOutputTooLarge is returned when a chunk would make an output file larger than MaxWorkerOutputSize.
+	ErrOutputTooLarge = errors.New("output file too large")
+)
+
+// MaxWorkerOutputSize is the largest output file a worker may upload.
+const MaxWorkerOutputSize int64 = 8 * 1024 * 1024 * 1024
+
+// stagedOutputSuffix is appended to the final path of an output to get the path it is staged at.
+const stagedOutputSuffix = ".part"
+
+// OutputUploadStatus is the progress of an output upload. The next chunk is to be sent at Offset.
+// Complete is set once the output has been moved into place and recorded in the scene.
+type OutputUploadStatus struct {
+	SceneID    string `json:"scene_id"`
+	OutputType string `json:"output_type"`
+	Iteration  int    `json:"iteration"`
+	Offset     int64  `json:"offset"`
+	Complete   bool   `json:"complete"`
+}
+
+// WorkerService serves the requests of GPU workers.
+type WorkerService struct {
+	sceneManager scene.SceneRepository
+	userManager  user.UserRepository
+	sceneCache   *SceneCache
+	metrics      *metrics.Metrics
+	logger       *log.Logger
+	// registered workers, see WorkerRegistry.go
+	workerManager *worker.WorkerManager
+	// shared key workers authenticate with, the API is disabled if empty
+	workerKey []byte
+	// outputs that have a chunk being written, see lockOutput
+	outputLocks sync.Map
+}
+
+// NewWorkerService creates a new WorkerService. workerKey is the key workers authenticate with, and disables the worker
+// API if empty. sceneCache has the cached output files of a scene dropped whenever a worker completes an output.
+// workerManager stores the workers that register, whose availability is exported as a metric if the API is enabled.
+func NewWorkerService(sceneManager scene.SceneRepository, userManager user.UserRepository, sceneCache *SceneCache, workerManager *worker.WorkerManager, workerKey []byte, m *metrics.Metrics, logger *log.Logger) *WorkerService {
+	service := &WorkerService{
+		sceneManager:  sceneManager,
+		userManager:   userManager,
+		sceneCache:    sceneCache,
+		workerManager: workerManager,
+		metrics:       m,
+		logger:        logger,
+		workerKey:     workerKey,
+	}
+	if len(workerKey) > 0 {
+		m.OnCollect(service.collectWorkersAvailable)
+	}
+	return service
+}
+
+// VerifyWorkerKey checks the key a worker request was made with.
+//
+// Returns ErrWorkerAPIDisabled if no worker key is configured, or ErrInvalidWorkerKey if key does not match it.
+func (s *WorkerService) VerifyWorkerKey(key string) (err error) {
+	defer classifyError(&err)
+	if len(s.workerKey) == 0 {
+		return ErrWorkerAPIDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(key), s.workerKey) != 1 {
+		return ErrInvalidWorkerKey
+	}
+	return nil
+}
+
+// outputLockKey identifies a single output file of a scene in outputLocks.
+type outputLockKey struct {
+	sceneID    primitive.ObjectID
+	outputType string
+	iteration  int
+}
+
+// lockOutput marks an output as in use by the calling request. Returns false if another request is using it.
+func (s *WorkerService) lockOutput(key outputLockKey) bool {
+	_, inUse := s.outputLocks.LoadOrStore(key, struct{}{})
+	return !inUse
+}
+
+// unlockOutput releases an output locked with lockOutput.
+func (s *WorkerService) unlockOutput(key outputLockKey) {
+	s.outputLocks.Delete(key)
+}
+
+// expectedOutputPath checks that the scene of a job is training and asked for the given output, and returns the scene
+// ID and the path the output file is stored at. jobID is the job ID of the scene, see SceneManager.JobID.
+//
+// Returns ErrSceneNotTraining if the scene is not training, ErrOutputNotExpected if its config did not ask for the
+// output, or scene.ErrSceneNotFound if the scene does not exist or jobID belongs to another environment.
+func (s *WorkerService) expectedOutputPath(ctx context.Context, jobID, outputType string, iteration int, fileName string) (primitive.ObjectID, string, error) {
+	sceneID, err := s.sceneManager.ParseJobID(jobID)
+	if err != nil {
+		return primitive.NilObjectID, "", fmt.Errorf("%w: %v", scene.ErrSceneNotFound, err)
+	}
+
+	currentScene, err := s.sceneManager.GetScene(ctx, sceneID)
+	if err != nil {
+		return primitive.NilObjectID, "", err
+	}
+	if currentScene.Status == nil || (currentScene.Status.State != scene.StateSfmDone && currentScene.Status.State != scene.StateTraining) {
+		return primitive.NilObjectID, "", ErrSceneNotTraining
+	}
+	if currentScene.Config == nil || currentScene.Config.NerfTrainingConfig == nil {
+		return primitive.NilObjectID, "", scene.ErrTrainingConfigNotFound
+	}
+
+	config := currentScene.Config.NerfTrainingConfig
+	if !slices.Contains(config.OutputTypes, outputType) {
+		return primitive.NilObjectID, "", fmt.Errorf("%w: output type %s", ErrOutputNotExpected, outputType)
+	}
+	if !slices.Contains(config.SaveIterations, iteration) {
+		return primitive.NilObjectID, "", fmt.Errorf("%w: iteration %d", ErrOutputNotExpected, iteration)
+	}
+
+	path, err := s.sceneManager.OutputPath(sceneID, currentScene.CurrentVersion(), outputType, iteration, fileName)
+	return sceneID, path, err
+}
+
+// GetOutputUpload returns the progress of uploading an output file of the training scene of a job.
+//
+// Returns any error of expectedOutputPath if the output cannot be uploaded.
+func (s *WorkerService) GetOutputUpload(ctx context.Context, jobID, outputType string, iteration int, fileName string) (_ *OutputUploadStatus, err error) {
+	defer classifyError(&err)
+	sceneID, path, err := s.expectedOutputPath(ctx, jobID, outputType, iteration, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &OutputUploadStatus{SceneID: sceneID.Hex(), OutputType: outputType, Iteration: iteration}
+	info, err := os.Stat(path + stagedOutputSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	status.Offset = info.Size()
+	return status, nil
+}
+
+// AppendOutput appends a chunk to an output file of the training scene of a job, at offset. Once the final chunk is received,
+// the file is moved into place, recorded in the scene's Nerf document, and charged to the storage of the scene's
+// owner. Uploading an output that was already completed replaces it.
+//
+// Returns ErrUploadOffsetMismatch if offset is not the number of bytes received, ErrOutputTooLarge if the chunk would
+// make the file larger than MaxWorkerOutputSize, ErrUploadInUse if another request is writing the same output, or any
+// error of expectedOutputPath if the output cannot be uploaded.
+func (s *WorkerService) AppendOutput(
+	ctx context.Context,
+	jobID string,
+	outputType string,
+	iteration int,
+	fileName string,
+	offset int64,
+	chunk io.Reader,
+	final bool,
+) (_ *OutputUploadStatus, err error) {
+	defer classifyError(&err)
+	sceneID, path, err := s.expectedOutputPath(ctx, jobID, outputType, iteration, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	key := outputLockKey{sceneID: sceneID, outputType: outputType, iteration: iteration}
+	if !s.lockOutput(key) {
+		return nil, ErrUploadInUse
+	}
+	defer s.unlockOutput(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	stagedPath := path + stagedOutputSuffix
+	staged, err := os.OpenFile(stagedPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := staged.Stat()
+	if err != nil {
+		staged.Close()
+		return nil, err
+	}
+	received := info.Size()
+	if offset != received {
+		staged.Close()
+		return nil, fmt.Errorf("%w: output is at offset %d", ErrUploadOffsetMismatch, received)
+	}
+
+	// One byte past the remaining size is read, to tell a chunk that fits exactly from one that is too large
+	remaining := MaxWorkerOutputSize - received
+	written, err := io.Copy(staged, io.LimitReader(chunk, remaining+1))
+	if closeErr := staged.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > remaining {
+		err = fmt.Errorf("%w: limit is %d bytes", ErrOutputTooLarge, MaxWorkerOutputSize)
+	}
+	if err != nil {
+		// The output is left as it was before the chunk, so the chunk can be sent again
+		if truncErr := os.Truncate(stagedPath, received); truncErr != nil {
+			s.logger.Errorf("Failed to truncate output %s after a failed chunk: %v", stagedPath, truncErr)
+		}
+		return nil, err
+	}
+
+	status := &OutputUploadStatus{SceneID: sceneID.Hex(), OutputType: outputType, Iteration: iteration, Offset: received + written}
+	if !final {
+		return status, nil
+	}
+
+	if err := s.completeOutput(ctx, sceneID, outputType, iteration, path, status.Offset); err != nil {
+		return nil, err
+	}
+	status.Complete = true
+	return status, nil
+}
+
+// completeOutput moves a fully staged output file into place and records it in the scene.
+func (s *WorkerService) completeOutput(ctx context.Context, sceneID primitive.ObjectID, outputType string, iteration int, path string, size int64) error {
+	var checksum string
+	if outputType == scene.OutputTypeCheckpoint {
+		sum, err := fileChecksum(path + stagedOutputSuffix)
+		if err != nil {
+			return err
+		}
+		checksum = sum
+	}
+
+	// A replaced output is no longer charged to the owner
+	var replaced int64
+	if info, err := os.Stat(path); err == nil {
+		replaced = info.Size()
+	}
+	if err := os.Rename(path+stagedOutputSuffix, path); err != nil {
+		return err
+	}
+
+	if err := s.sceneManager.SetOutputFile(ctx, sceneID, outputType, iteration, path, checksum); err != nil {
+		return err
+	}
+	s.sceneCache.InvalidateOutputs(ctx, sceneID)
+	if err := s.sceneManager.SetLatestIteration(ctx, sceneID, iteration); err != nil {
+		return err
+	}
+	s.metrics.OutputBytesTotal.WithLabelValues(outputType).Add(float64(size))
+
+	owner, err := s.userManager.GetUserBySceneID(ctx, sceneID)
+	if err != nil {
+		s.logger.Errorf("Failed to find owner of scene %s for storage accounting: %v", sceneID.Hex(), err)
+	} else if err := s.userManager.IncrementStorageUsed(ctx, owner.ID, size-replaced); err != nil {
+		s.logger.Errorf("Failed to add %d bytes to storage of user %s: %v", size-replaced, owner.ID.Hex(), err)
+	}
+
+	s.logger.Infof("Worker uploaded %s output for iteration %d of scene %s (%d bytes)", outputType, iteration, sceneID.Hex(), size)
+	return nil
+}
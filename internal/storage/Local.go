@@ -0,0 +1,134 @@
+// This file contains LocalStore, which keeps objects as files under a root directory.
+// Objects are written to a temporary file first and renamed into place, so readers never see a partial object.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LocalStore keeps objects as files under a root directory, at the path of their key.
+type LocalStore struct {
+	root string
+}
+
+// NewLocalStore creates a LocalStore rooted at root, creating the directory if needed.
+func NewLocalStore(root string) (*LocalStore, error) {
+	if root == "" {
+		return nil, errors.New("local storage requires a root directory")
+	}
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &LocalStore{root: root}, nil
+}
+
+// path returns the file an object is stored in.
+func (s *LocalStore) path(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// localInfo describes the object stored in a file.
+func localInfo(key string, stat os.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:     key,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+		ETag:    fmt.Sprintf("%x-%x", stat.Size(), stat.ModTime().UnixNano()),
+	}
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("wrote %d bytes of object %s, expected %d", written, key, size)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStore) Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, localInfo(key, stat), nil
+}
+
+func (s *LocalStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return localInfo(key, stat), nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) DeletePrefix(ctx context.Context, prefix string) error {
+	if err := validatePrefix(prefix); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(s.root, filepath.FromSlash(prefix)))
+}
+
+func (s *LocalStore) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return "", ErrSignedURLUnsupported
+}
@@ -0,0 +1,376 @@
+// This file contains S3Store, which keeps objects in a bucket of an S3 compatible object store.
+//
+// Requests are signed with AWS signature version 4 and use path style URLs (<endpoint>/<bucket>/<key>), which AWS S3,
+// MinIO, and the XML API of Google Cloud Storage all accept. Payloads are not hashed (UNSIGNED-PAYLOAD), so uploads
+// are streamed; endpoints should use https so the payload is still protected in transit.
+
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config is the connection to an S3 compatible bucket.
+type S3Config struct {
+	// base URL of the service, i.e "https://s3.eu-west-1.amazonaws.com" or "http://minio:9000"
+	Endpoint string
+	Region   string
+	Bucket   string
+	// access key, i.e of an IAM user, a MinIO user, or the HMAC key of a GCS service account
+	AccessKeyID     string
+	SecretAccessKey string
+	// prepended to every key, so several deployments can share a bucket. Empty uses the bucket root
+	Prefix string
+}
+
+// S3Store keeps objects in a bucket of an S3 compatible object store.
+type S3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// Signature version 4 constants
+const (
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3Service        = "s3"
+	s3UnsignedBody   = "UNSIGNED-PAYLOAD"
+	s3TimeFormat     = "20060102T150405Z"
+	s3DateFormat     = "20060102"
+	s3MaxErrorLength = 4096
+)
+
+// NewS3Store creates an S3Store for the bucket of cfg. No request is made, so an unreachable store is only reported
+// when first used.
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Region == "" || cfg.Bucket == "" {
+		return nil, errors.New("object storage requires an endpoint, region, and bucket")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("object storage requires an access key")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid object storage endpoint %q", cfg.Endpoint)
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	return &S3Store{cfg: cfg, endpoint: endpoint, client: &http.Client{}}, nil
+}
+
+// objectKey returns the key of an object within the bucket, including the configured prefix.
+func (s *S3Store) objectKey(key string) string {
+	if s.cfg.Prefix == "" {
+		return key
+	}
+	return s.cfg.Prefix + "/" + key
+}
+
+// objectURL returns the URL of an object, or of the bucket if key is empty, with the given query.
+func (s *S3Store) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket
+	if key != "" {
+		u.Path += "/" + s.objectKey(key)
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3EscapeQuery(query)
+	return &u
+}
+
+// do signs and sends a request. Responses with an error status are returned as errors, ErrObjectNotFound for 404.
+func (s *S3Store) do(ctx context.Context, method string, u *url.URL, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now().UTC(), s3UnsignedBody)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrObjectNotFound
+	}
+	return nil, s3ResponseError(resp)
+}
+
+// s3ResponseError returns the error of a failed response, with the code and message of its XML error body.
+func s3ResponseError(resp *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, s3MaxErrorLength))
+	if err := xml.Unmarshal(data, &body); err != nil || body.Code == "" {
+		return fmt.Errorf("object storage request failed: %s", resp.Status)
+	}
+	return fmt.Errorf("object storage request failed: %s: %s: %s", resp.Status, body.Code, body.Message)
+}
+
+// s3Info describes an object from the headers of a response.
+func s3Info(key string, resp *http.Response) *ObjectInfo {
+	info := &ObjectInfo{Key: key, Size: resp.ContentLength, ETag: strings.Trim(resp.Header.Get("ETag"), `"`)}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if size == 0 {
+		// A nil body is sent without a Content-Length, which S3 rejects
+		r = http.NoBody
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(key, nil), r, size, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	if err := validateKey(key); err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key, nil), nil, 0, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, s3Info(key, resp), nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(key, nil), nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return s3Info(key, resp), nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key, nil), nil, 0, nil)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// DeletePrefix lists the objects under prefix a page at a time, and deletes them one by one. Objects written under
+// the prefix while deleting may be left behind.
+func (s *S3Store) DeletePrefix(ctx context.Context, prefix string) error {
+	if err := validatePrefix(prefix); err != nil {
+		return err
+	}
+
+	fullPrefix := s.objectKey(prefix)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {fullPrefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL("", query), nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decode object listing: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			key := object.Key
+			if s.cfg.Prefix != "" {
+				key = strings.TrimPrefix(key, s.cfg.Prefix+"/")
+			}
+			if err := s.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// SignedURL returns a presigned GET URL of the object. The object is not checked to exist.
+func (s *S3Store) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	if ttl <= 0 || ttl > MaxSignedURLTTL {
+		return "", fmt.Errorf("signed URL lifetime must be between 1s and %s", MaxSignedURLTTL)
+	}
+
+	return s.presign(s.objectURL(key, nil), time.Now().UTC(), ttl), nil
+}
+
+// presign returns u with the signature version 4 query parameters of a GET request made at now and valid for ttl.
+func (s *S3Store) presign(u *url.URL, now time.Time, ttl time.Duration) string {
+	query := url.Values{
+		"X-Amz-Algorithm":     {s3Algorithm},
+		"X-Amz-Credential":    {s.cfg.AccessKeyID + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format(s3TimeFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	signed := *u
+	signed.RawQuery = s3EscapeQuery(query)
+	canonical := s3CanonicalRequest(http.MethodGet, &signed, nil, []string{"host"}, s3UnsignedBody)
+	query.Set("X-Amz-Signature", s.signature(now, canonical))
+	signed.RawQuery = s3EscapeQuery(query)
+	return signed.String()
+}
+
+// sign adds the signature version 4 Authorization header to a request, made at now with a payload of the given hex
+// SHA-256 hash, or s3UnsignedBody.
+func (s *S3Store) sign(req *http.Request, now time.Time, payloadHash string) {
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := []string{"host"}
+	for name := range req.Header {
+		signed = append(signed, strings.ToLower(name))
+	}
+	sort.Strings(signed)
+
+	canonical := s3CanonicalRequest(req.Method, req.URL, req.Header, signed, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.cfg.AccessKeyID, s.scope(now), strings.Join(signed, ";"), s.signature(now, canonical)))
+}
+
+// scope returns the credential scope of a signature made at now.
+func (s *S3Store) scope(now time.Time) string {
+	return fmt.Sprintf("%s/%s/%s/aws4_request", now.Format(s3DateFormat), s.cfg.Region, s3Service)
+}
+
+// signature returns the hex signature of a canonical request made at now.
+func (s *S3Store) signature(now time.Time, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{s3Algorithm, now.Format(s3TimeFormat), s.scope(now), hex.EncodeToString(hash[:])}, "\n")
+
+	key := s3HMAC([]byte("AWS4"+s.cfg.SecretAccessKey), now.Format(s3DateFormat))
+	key = s3HMAC(key, s.cfg.Region)
+	key = s3HMAC(key, s3Service)
+	key = s3HMAC(key, "aws4_request")
+	return hex.EncodeToString(s3HMAC(key, stringToSign))
+}
+
+// s3CanonicalRequest returns the canonical form of a request, over the given lowercase sorted signed headers.
+func s3CanonicalRequest(method string, u *url.URL, header http.Header, signed []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signed {
+		value := u.Host
+		if name != "host" {
+			value = strings.Join(header.Values(name), ",")
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	return strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		headers.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters of RFC 3986, and "/" if keepSlash is set, as
+// required by signature version 4.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(path string) string {
+	return s3Escape(path, true)
+}
+
+// s3EscapeQuery encodes a query sorted by name, as required by signature version 4.
+func s3EscapeQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(query))
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
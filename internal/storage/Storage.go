@@ -0,0 +1,134 @@
+// This file contains the Store interface, and the creation of a store from its configuration.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrObjectNotFound is returned when no object is stored under a key.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrInvalidKey is returned when a key is empty, absolute, or has empty, "." or ".." segments.
+	ErrInvalidKey = errors.New("invalid object key")
+	// ErrSignedURLUnsupported is returned by stores that cannot hand out signed URLs, i.e LocalStore.
+	ErrSignedURLUnsupported = errors.New("store does not support signed URLs")
+	// ErrUnknownBackend is returned when the configured backend is not one of the supported backends.
+	ErrUnknownBackend = errors.New("unknown storage backend")
+)
+
+// Supported storage backends
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+	BackendMinIO = "minio"
+	BackendGCS   = "gcs"
+)
+
+// MaxSignedURLTTL is the longest lifetime of a signed URL, the limit of S3 signature version 4.
+const MaxSignedURLTTL = 7 * 24 * time.Hour
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	// opaque version of the contents, changes whenever the object is rewritten
+	ETag string
+}
+
+// Store is a blob store. Implementations must be safe for concurrent use.
+type Store interface {
+	// Put stores size bytes of r under key, replacing any object stored under it. The object is only visible once
+	// completely written.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns the contents of the object stored under key. The caller must close it.
+	// Returns ErrObjectNotFound if there is no such object.
+	Open(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// Stat describes the object stored under key. Returns ErrObjectNotFound if there is no such object.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Delete removes the object stored under key. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every object whose key starts with prefix, which must end with a "/".
+	DeletePrefix(ctx context.Context, prefix string) error
+	// SignedURL returns a URL giving anyone read access to the object stored under key for ttl.
+	// Returns ErrSignedURLUnsupported if the store cannot sign URLs.
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// Config selects and configures the store scene files are kept in.
+type Config struct {
+	// one of the Backend constants, empty keeps scene files on the server's disk only
+	Backend string
+	// root directory of BackendLocal, i.e a volume shared by every server
+	LocalRoot string
+	// connection of BackendS3, BackendMinIO, and BackendGCS
+	S3 S3Config
+}
+
+// Enabled reports whether a store is configured.
+func (c Config) Enabled() bool {
+	return c.Backend != ""
+}
+
+// New creates the store selected by cfg. Returns a nil Store if no backend is configured.
+//
+// BackendGCS defaults to the storage.googleapis.com endpoint, and authenticates with an HMAC key of a service
+// account, as it goes through the S3 compatible XML API of Google Cloud Storage (see doc.go). BackendMinIO requires
+// an endpoint.
+//
+// Returns ErrUnknownBackend if the backend is not supported.
+func New(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case BackendLocal:
+		return NewLocalStore(cfg.LocalRoot)
+	case BackendS3:
+		if cfg.S3.Endpoint == "" && cfg.S3.Region != "" {
+			cfg.S3.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.S3.Region)
+		}
+		return NewS3Store(cfg.S3)
+	case BackendMinIO:
+		if cfg.S3.Endpoint == "" {
+			return nil, errors.New("minio storage requires an endpoint")
+		}
+		return NewS3Store(cfg.S3)
+	case BackendGCS:
+		if cfg.S3.Endpoint == "" {
+			cfg.S3.Endpoint = "https://storage.googleapis.com"
+		}
+		if cfg.S3.Region == "" {
+			cfg.S3.Region = "auto"
+		}
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownBackend, cfg.Backend)
+	}
+}
+
+// validateKey checks that a key is a relative, slash separated path without empty, "." or ".." segments.
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.ContainsAny(key, "\\\x00") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+// validatePrefix checks that a prefix is a valid key followed by a "/".
+func validatePrefix(prefix string) error {
+	if !strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("%w: prefix %q must end with /", ErrInvalidKey, prefix)
+	}
+	return validateKey(strings.TrimSuffix(prefix, "/"))
+}
@@ -0,0 +1,9 @@
+// Package storage contains the blob stores scene files are kept in, behind the Store interface.
+// LocalStore keeps objects on a local or mounted file system, and S3Store keeps them in an S3 compatible object
+// store, which covers AWS S3, MinIO, and Google Cloud Storage through its XML interoperability API.
+//
+// Google Cloud Storage is not supported natively: the bucket is reached through the XML API with the HMAC key of a
+// service account, so service account JSON keys and workload identity cannot be used, and signed URLs are signed
+// with the HMAC key rather than by the service account.
+// Objects are addressed by slash separated keys, i.e "scenes/<job id>/outputs/splat_cloud/iteration_7000/out.splat".
+package storage
@@ -0,0 +1,77 @@
+// This file contains the exporter sending ended spans to an OpenTelemetry collector, with the OTLP/HTTP exporter and
+// the batch span processor of the OpenTelemetry SDK.
+//
+// As with the audit log, spans are exported best effort: they are queued in a bounded buffer and sent in batches by a
+// background goroutine, so tracing never blocks or fails a request. When the buffer is full, spans are dropped.
+// Failed exports are logged as errors.
+
+package tracing
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+// Buffering of exported spans
+const (
+	// exportBufferSize is the most spans queued for export, further spans are dropped.
+	exportBufferSize = 2048
+	// exportBatchSize is the most spans sent at once.
+	exportBatchSize = 256
+	// exportInterval is the longest a span waits in the queue before it is sent.
+	exportInterval = 5 * time.Second
+	// exportTimeout bounds a single export request, and sending the queued spans on Close.
+	exportTimeout = 10 * time.Second
+)
+
+// instrumentationScope names this package as the instrumentation that produced the spans.
+const instrumentationScope = "github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+
+// Exporter sends ended spans to an OpenTelemetry collector in the background.
+type Exporter struct {
+	processor sdktrace.SpanProcessor
+	resource  *resource.Resource
+}
+
+// NewExporter creates an Exporter sending the spans of the named service to the OTLP/HTTP endpoint of a collector,
+// i.e "http://otel-collector:4318", and starts its background sender.
+// Close must be called to send the spans still queued on shutdown.
+func NewExporter(endpoint, serviceName string, logger *log.Logger) *Exporter {
+	// Errors of the SDK, i.e failed exports, are only reported to its global error handler
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Errorf("Failed to export spans: %v", err)
+	}))
+
+	// The HTTP client has no connection to start, it sends a request per batch
+	exporter := otlptrace.NewUnstarted(otlptracehttp.NewClient(
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"),
+		otlptracehttp.WithTimeout(exportTimeout),
+	))
+	return &Exporter{
+		processor: sdktrace.NewBatchSpanProcessor(exporter,
+			sdktrace.WithMaxQueueSize(exportBufferSize),
+			sdktrace.WithMaxExportBatchSize(exportBatchSize),
+			sdktrace.WithBatchTimeout(exportInterval),
+			sdktrace.WithExportTimeout(exportTimeout),
+		),
+		resource: resource.NewSchemaless(attribute.String("service.name", serviceName)),
+	}
+}
+
+// Close stops exporting, and waits up to exportTimeout for the queued spans to be sent.
+// Spans ended once Close is called are dropped, so SetTracer(nil) should be called first.
+func (e *Exporter) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	e.processor.Shutdown(ctx)
+}
@@ -0,0 +1,69 @@
+// This file contains the MongoDB command monitor starting a client span for every command sent by the managers.
+//
+// The driver passes the context of the operation to the monitor, so a command's span is the child of the span of the
+// request or job it was sent for.
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// WrapMongoMonitor returns a command monitor that starts a span for every MongoDB command, and then calls next,
+// i.e the monitor of metrics.NewMongoMonitor. It should be set on the options of the MongoDB client with SetMonitor.
+func WrapMongoMonitor(next *event.CommandMonitor) *event.CommandMonitor {
+	// spans of in-flight commands, by request ID
+	var spans sync.Map
+
+	end := func(requestID int64, err error) {
+		if v, ok := spans.LoadAndDelete(requestID); ok {
+			span := v.(*Span)
+			span.RecordError(err)
+			span.End()
+		}
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			name := e.CommandName
+			// The value of a command's first element is the collection it targets, i.e {"find": "scenes", ...}
+			var collection string
+			if elements, err := e.Command.Elements(); err == nil && len(elements) > 0 {
+				collection, _ = elements[0].Value().StringValueOK()
+			}
+			if collection != "" {
+				name += " " + collection
+			}
+
+			if _, span := Start(ctx, name, KindClient); span != nil {
+				span.SetAttribute("db.system", "mongodb")
+				span.SetAttribute("db.name", e.DatabaseName)
+				span.SetAttribute("db.operation", e.CommandName)
+				if collection != "" {
+					span.SetAttribute("db.mongodb.collection", collection)
+				}
+				spans.Store(e.RequestID, span)
+			}
+
+			if next != nil && next.Started != nil {
+				next.Started(ctx, e)
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			end(e.RequestID, nil)
+			if next != nil && next.Succeeded != nil {
+				next.Succeeded(ctx, e)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			end(e.RequestID, errors.New(e.Failure))
+			if next != nil && next.Failed != nil {
+				next.Failed(ctx, e)
+			}
+		},
+	}
+}
@@ -0,0 +1,76 @@
+// This file contains the propagation of span contexts between services, in the W3C Trace Context "traceparent"
+// format: "00-<32 hex trace ID>-<16 hex span ID>-<2 hex flags>", where flag 01 marks a sampled trace.
+//
+// The traceparent is sent in the "traceparent" header of HTTP requests and AMQP messages, and in the "traceparent"
+// field of the jobs published to the workers, so workers that do not read message headers can still continue a trace.
+
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+)
+
+// TraceparentHeader is the name of the HTTP header, AMQP message header, and job field carrying a traceparent.
+const TraceparentHeader = "traceparent"
+
+// Traceparent returns the span context in the traceparent format, or "" if it is not valid.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a span context in the traceparent format.
+// Versions other than 00 are accepted as long as they start with its fields, as the specification requires.
+//
+// Returns false if value is not a valid traceparent.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if len(parts[1]) != 2*len(sc.TraceID) || len(parts[2]) != 2*len(sc.SpanID) || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// Traceparent returns the traceparent of the span ctx carries, or "" if it carries none.
+func Traceparent(ctx context.Context) string {
+	sc, _ := SpanContextFromContext(ctx)
+	return sc.Traceparent()
+}
+
+// ContextWithTraceparent returns a copy of ctx carrying the span context of a traceparent, i.e received from another
+// service. ctx is returned unchanged if traceparent is not valid.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	sc, ok := ParseTraceparent(traceparent)
+	if !ok {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
@@ -0,0 +1,183 @@
+// This file contains the Tracer and the spans it starts, on top of the tracer provider of the OpenTelemetry SDK.
+//
+// Remote parents (i.e a traceparent received from a client or a worker) are carried by contexts as remote span
+// contexts of the SDK, see ContextWithSpanContext, so they are handled the same way as local parents.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanKind describes the relationship of a span to the services it involves, numbered as in OTLP.
+type SpanKind int
+
+// Declarations for span kinds
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+	KindProducer SpanKind = 4
+	KindConsumer SpanKind = 5
+)
+
+// TraceID identifies a trace, which is every span caused by a single operation across all services.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// SpanContext is the part of a span that is propagated to its children.
+// A trace that is not Sampled is still propagated, so every service agrees not to export it.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid checks if the span context identifies a span, i.e it is not the zero value.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// spanContextOf converts a span context of the SDK.
+func spanContextOf(sc trace.SpanContext) SpanContext {
+	return SpanContext{TraceID: TraceID(sc.TraceID()), SpanID: SpanID(sc.SpanID()), Sampled: sc.IsSampled()}
+}
+
+// Tracer starts spans, and exports them once they end if their trace is sampled.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer creates a Tracer exporting spans with exporter.
+// sampleRatio is the fraction of new traces that are sampled, traces continued from a parent keep its decision.
+func NewTracer(sampleRatio float64, exporter *Exporter) *Tracer {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithSpanProcessor(exporter.processor),
+		sdktrace.WithResource(exporter.resource),
+	)
+	return &Tracer{tracer: provider.Tracer(instrumentationScope)}
+}
+
+// defaultTracer is the Tracer used by Start, nil while tracing is disabled.
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetTracer sets the Tracer used by Start. A nil Tracer disables tracing.
+func SetTracer(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Span is an operation within a trace. A nil Span is valid, and its methods do nothing.
+type Span struct {
+	span trace.Span
+}
+
+// ContextWithSpanContext returns a copy of ctx carrying sc as a remote parent, so spans started from it are its
+// children.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	var flags trace.TraceFlags
+	if sc.Sampled {
+		flags = trace.FlagsSampled
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID(sc.TraceID),
+		SpanID:     trace.SpanID(sc.SpanID),
+		TraceFlags: flags,
+		Remote:     true,
+	}))
+}
+
+// SpanContextFromContext returns the span context ctx carries, and whether it carries one.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc := trace.SpanContextFromContext(ctx)
+	return spanContextOf(sc), sc.IsValid()
+}
+
+// Start starts a span named name, child of the span ctx carries, or the root of a new trace if it carries none.
+// The returned context carries the new span. Start returns ctx and a nil Span if tracing is disabled.
+// The span must be ended with End or EndWithError.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	t := defaultTracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKind(kind)))
+	return ctx, &Span{span: span}
+}
+
+// SpanContext returns the span context of the span, the zero value for a nil Span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return spanContextOf(s.span.SpanContext())
+}
+
+// SetName renames the span, i.e once the route of a request is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.span.SetName(name)
+}
+
+// SetAttribute sets an attribute of the span. Values other than strings, bools, ints, int64s and float64s are
+// formatted as strings.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	var kv attribute.KeyValue
+	switch value := value.(type) {
+	case string:
+		kv = attribute.String(key, value)
+	case bool:
+		kv = attribute.Bool(key, value)
+	case int:
+		kv = attribute.Int(key, value)
+	case int64:
+		kv = attribute.Int64(key, value)
+	case float64:
+		kv = attribute.Float64(key, value)
+	default:
+		kv = attribute.String(key, fmt.Sprint(value))
+	}
+	s.span.SetAttributes(kv)
+}
+
+// RecordError marks the span as failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End ends the span, and exports it if its trace is sampled. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+// EndWithError records the error err points to, if any, and ends the span.
+// It is meant to be deferred by functions with a named error return, i.e defer span.EndWithError(&err).
+func (s *Span) EndWithError(err *error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.RecordError(*err)
+	}
+	s.End()
+}
@@ -0,0 +1,7 @@
+// Package tracing contains distributed tracing, a thin layer over the OpenTelemetry SDK.
+// Spans are started from a context with Start, which parents them to the span the context carries, and propagated
+// between services in the W3C Trace Context "traceparent" format. Ended spans are exported in batches to an
+// OpenTelemetry collector over OTLP/HTTP.
+// There should be a single Tracer, set with SetTracer on startup. Until one is set, Start returns nil spans, whose
+// methods do nothing, so tracing costs nothing when it is disabled.
+package tracing
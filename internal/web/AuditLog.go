@@ -0,0 +1,92 @@
+// This file contains the handlers reading the audit trail, see services/Audit.go: the paginated audit log of admins,
+// and the activity of a user. The audit log of a single scene is read with getSceneAuditLog.
+
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
+)
+
+// clientIP is the middleware stamping the request's user context with the client address, which the audit events
+// recorded for the request carry, and with the user agent, which the sessions started or refreshed by it record.
+func (s *WebServer) clientIP(c *fiber.Ctx) error {
+	ctx := services.WithClientIP(c.UserContext(), c.IP())
+	c.SetUserContext(services.WithUserAgent(ctx, c.Get(fiber.HeaderUserAgent)))
+	return c.Next()
+}
+
+// adminGetAuditLog handles the request to get a page of the audit log of every user. It is an admin only route.
+//
+// The user can optionally filter events with query parameters `user_id` (who made them), `scene_id`, `target_user_id`
+// (who they were made on), `action`, `outcome` (allowed or denied), and `since` and `until` (RFC 3339 times), and
+// specify `page` (1-indexed) and `page_size` to page through them, newest first.
+func (s *WebServer) adminGetAuditLog(c *fiber.Ctx) error {
+	s.logger.Debug("Admin get audit log request received")
+
+	var req AdminGetAuditLogRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Admin get audit log request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	// The IDs and times were validated, so parsing them cannot fail
+	query := services.AuditQuery{Action: req.Action, Outcome: req.Outcome, Page: req.Page, PageSize: req.PageSize}
+	query.UserID, _ = parseOptionalObjectID(req.UserID)
+	query.SceneID, _ = parseOptionalObjectID(req.SceneID)
+	query.TargetUserID, _ = parseOptionalObjectID(req.TargetUserID)
+	if req.Since != "" {
+		query.Since, _ = time.Parse(time.RFC3339, req.Since)
+	}
+	if req.Until != "" {
+		query.Until, _ = time.Parse(time.RFC3339, req.Until)
+	}
+
+	page, err := s.clientService.GetAuditLog(c.UserContext(), userID, query)
+	if err != nil {
+		s.logger.Debug("Failed to get audit log: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(page)
+}
+
+// getMyActivity handles the request to get a page of the audit events made by the user, i.e their logins, uploads, and
+// operations on scenes. It is a JWT protected route.
+//
+// The user can optionally specify query parameters `page` (1-indexed) and `page_size` to page through them, newest
+// first.
+func (s *WebServer) getMyActivity(c *fiber.Ctx) error {
+	s.logger.Debug("Get my activity request received")
+
+	var req GetMyActivityRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get my activity request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	page, err := s.clientService.GetMyActivity(c.UserContext(), userID, req.Page, req.PageSize)
+	if err != nil {
+		s.logger.Debug("Failed to get activity: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(page)
+}
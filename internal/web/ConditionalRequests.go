@@ -0,0 +1,145 @@
+// This file contains the handling of HTTP conditional request headers for served files and metadata.
+//
+// Final outputs are identified by a strong ETag, the SHA-256 of their content, which is computed once per file and
+// cached, see services.ClientService.ContentETag. Other files (i.e outputs still being written, thumbnails) are
+// identified by a weak ETag computed from their size and modification time, so no file content has to be read to
+// validate a cached copy. Metadata responses are identified by the SHA-256 of their body.
+//
+// If-None-Match and If-Modified-Since yield 304 Not Modified, so web viewers revalidating a multi-hundred-MB splat do
+// not download it again unless it changed.
+
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// fileETag returns the weak ETag of a file, derived from its size and modification time.
+func fileETag(stat os.FileInfo) string {
+	return fmt.Sprintf(`W/"%x-%x"`, stat.Size(), stat.ModTime().UnixNano())
+}
+
+// contentETag returns the strong ETag of a response body, derived from its SHA-256.
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// setFileValidators sets the ETag and Last-Modified headers of a file response. Last-Modified is omitted if modTime is
+// zero.
+func setFileValidators(c *fiber.Ctx, etag string, modTime time.Time) {
+	c.Set("ETag", etag)
+	if !modTime.IsZero() {
+		c.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+// isNotModified checks If-None-Match and If-Modified-Since, and reports whether the client's cached copy is current.
+//
+// As in RFC 9110, If-Modified-Since is only considered when If-None-Match is absent. It is also ignored if modTime is
+// zero, since there is no Last-Modified to compare it to.
+func isNotModified(c *fiber.Ctx, etag string, modTime time.Time) bool {
+	if ifNoneMatch := c.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || etagsMatch(candidate, etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince := c.Get("If-Modified-Since"); ifModifiedSince != "" && !modTime.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		// HTTP dates have second precision
+		return !modTime.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// ifRangeMatches checks the If-Range header, and reports whether a Range request may be honored.
+// If the header is absent, the range is always honored. Otherwise the full file must be sent if the file has
+// changed since the client's copy, identified either by an ETag or by a Last-Modified date.
+func ifRangeMatches(c *fiber.Ctx, etag string, modTime time.Time) bool {
+	ifRange := strings.TrimSpace(c.Get("If-Range"))
+	if ifRange == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etagsMatch(ifRange, etag)
+	}
+
+	date, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	return modTime.Truncate(time.Second).Equal(date)
+}
+
+// etagsMatch compares two ETags using weak comparison, ignoring the W/ prefix.
+func etagsMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// errRangeNotSatisfiable is returned by parseByteRange when a range lies entirely outside of the file.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseByteRange parses a single range of a Range header against a file of the given size, and returns the first and
+// last byte of the range, clamped to the file. Both the `start-end`, `start-`, and suffix `-length` forms are accepted.
+//
+// ok is false when the header should be ignored and the full file sent: the header is absent, malformed, or requests
+// several ranges, which are not supported. Returns errRangeNotSatisfiable if the range does not overlap the file.
+func parseByteRange(header string, fileSize int64) (start, end int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	if first == "" {
+		// Suffix range, the last bytes of the file
+		length, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || length < 0 {
+			return 0, 0, false, nil
+		}
+		if length == 0 || fileSize == 0 {
+			return 0, 0, true, errRangeNotSatisfiable
+		}
+		return max(fileSize-length, 0), fileSize - 1, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end = fileSize - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		end = min(end, fileSize-1)
+	}
+	if start >= fileSize {
+		return 0, 0, true, errRangeNotSatisfiable
+	}
+	return start, end, true, nil
+}
@@ -0,0 +1,99 @@
+// This file contains the mapping of service errors to HTTP responses.
+//
+// Handlers pass any error they get from the ClientService (or from request validation) to sendError, which picks the
+// status code from the error kind and responds with the client safe message only. The full error, including its
+// internal cause, is logged.
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
+)
+
+// errorStatuses maps service error kinds to HTTP status codes.
+var errorStatuses = map[error]int{
+	services.ErrNotFound:      http.StatusNotFound,
+	services.ErrUnauthorized:  http.StatusUnauthorized,
+	services.ErrForbidden:     http.StatusForbidden,
+	services.ErrValidation:    http.StatusBadRequest,
+	services.ErrConflict:      http.StatusConflict,
+	services.ErrQuotaExceeded: http.StatusForbidden,
+	services.ErrTooLarge:      http.StatusRequestEntityTooLarge,
+	services.ErrRateLimited:   http.StatusTooManyRequests,
+	services.ErrUpstream:      http.StatusServiceUnavailable,
+	services.ErrInternal:      http.StatusInternalServerError,
+}
+
+// errorResponse returns the HTTP status and JSON body for an error. The body holds the client safe message under
+// "error", the per field problems of validation errors under "fields", the seconds to wait before retrying under
+// "retry_after", and the limit that was exceeded under "limit".
+func errorResponse(err error) (int, fiber.Map) {
+	svcErr := services.AsError(err)
+
+	status, ok := errorStatuses[svcErr.Kind]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+
+	body := fiber.Map{"error": svcErr.Message}
+	if len(svcErr.Fields) > 0 {
+		body["fields"] = svcErr.Fields
+	}
+	if svcErr.RetryAfter > 0 {
+		body["retry_after"] = retryAfterSeconds(svcErr.RetryAfter)
+	}
+	if len(svcErr.Limit) > 0 {
+		body["limit"] = svcErr.Limit
+	}
+	return status, body
+}
+
+// sendError logs err with its internal cause, and responds with its status code and client safe message.
+// Errors that tell clients when to retry also set the Retry-After header.
+func (s *WebServer) sendError(c *fiber.Ctx, err error) error {
+	if retryAfter := services.AsError(err).RetryAfter; retryAfter > 0 {
+		c.Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+	}
+	status, body := errorResponse(err)
+	logger := s.logger.Ctx(c.UserContext())
+	if status >= http.StatusInternalServerError {
+		logger.Errorf("%s %s failed: %v", c.Method(), c.Path(), err)
+	} else {
+		logger.Debugf("%s %s failed: %v", c.Method(), c.Path(), err)
+	}
+	return c.Status(status).JSON(body)
+}
+
+// retryAfterSeconds rounds a retry delay up to whole seconds, as used by the Retry-After header.
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// validationError converts a request validation error into a services validation error. Failed struct validations
+// are reported per field, other errors (i.e, a malformed body) are reported as is.
+func validationError(err error) error {
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return services.NewValidationError(err.Error(), nil, err)
+	}
+
+	fields := make(map[string]string, len(fieldErrors))
+	for _, fe := range fieldErrors {
+		if fe.Param() != "" {
+			fields[fe.Field()] = fmt.Sprintf("failed %s=%s validation", fe.Tag(), fe.Param())
+		} else {
+			fields[fe.Field()] = fmt.Sprintf("failed %s validation", fe.Tag())
+		}
+	}
+	return services.NewValidationError("invalid request", fields, err)
+}
@@ -0,0 +1,80 @@
+// This file contains the idempotency of mutating routes, see services.Idempotency.
+//
+// Clients opt in by sending an Idempotency-Key header. The request is fingerprinted by its method, path, and body, and
+// responses up to the server errors are stored and replayed with the Idempotent-Replayed header set. Multipart bodies
+// are left out of the fingerprint, as clients pick a new boundary each time they build the request, so the retry of an
+// upload would never match.
+
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/idempotency"
+)
+
+// idempotencyKeyHeader is the header carrying the idempotency key of a request.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentReplayedHeader is set on responses replayed from an earlier request with the same idempotency key.
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+// idempotent is a middleware that performs the request at most once per idempotency key, see the file comment.
+// Requests without an Idempotency-Key header are performed as is. It must run after the authentication middlewares,
+// as keys are scoped to the user.
+func (s *WebServer) idempotent(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(idempotencyKeyHeader)
+		if key == "" || s.idempotency == nil {
+			return handler(c)
+		}
+
+		userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+		if err != nil {
+			s.logger.Debug("Invalid user ID: ", err.Error())
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+		}
+
+		request, replay, err := s.idempotency.Begin(c.UserContext(), userID, key, requestFingerprint(c))
+		if err != nil {
+			return s.sendError(c, err)
+		}
+		if replay != nil {
+			c.Set(idempotentReplayedHeader, "true")
+			if replay.ContentType != "" {
+				c.Set(fiber.HeaderContentType, replay.ContentType)
+			}
+			return c.Status(replay.StatusCode).Send(replay.Body)
+		}
+
+		err = handler(c)
+		status := c.Response().StatusCode()
+		if err != nil || status >= http.StatusInternalServerError || c.Response().IsBodyStream() {
+			request.Release(c.UserContext())
+			return err
+		}
+		request.Complete(c.UserContext(), idempotency.Response{
+			StatusCode:  status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Body:        append([]byte(nil), c.Response().Body()...),
+		})
+		return nil
+	}
+}
+
+// requestFingerprint returns the hash of the method, path, query, and body of a request. Multipart bodies are left
+// out, see the file comment.
+func requestFingerprint(c *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Method() + " " + c.OriginalURL() + "\n"))
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		hash.Write(c.Body())
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -21,13 +21,26 @@ type LoginRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
 type RegisterRequest struct {
 	Username string `json:"username" validate:"required"`
 	Password string `json:"password" validate:"required"`
 }
 
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Username string `json:"username" validate:"required"`
+}
+
 type UpdatePasswordRequest struct {
-	OldPassword string `json:"old_password" validate:"required"`
+	// may be omitted by users who have no password yet
+	OldPassword string `json:"old_password"`
 	NewPassword string `json:"new_password" validate:"required"`
 }
 
@@ -37,44 +50,509 @@ type UpdateUsernameRequest struct {
 }
 
 type DeleteSceneRequest struct {
-	SceneID string `params:"scene_id" validate:"required"`
+	SceneID   string `params:"scene_id" validate:"required"`
+	Cancel    bool   `query:"cancel"`
+	Permanent bool   `query:"permanent"`
+}
+
+type RestoreSceneRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
+type GetTrashRequest struct {
+	Page     int    `query:"page" validate:"omitempty,min=1"`
+	PageSize int    `query:"page_size" validate:"omitempty,min=1,max=100"`
+	Sort     string `query:"sort" validate:"omitempty,oneof=newest oldest"`
+}
+
+type DeleteOldScenesRequest struct {
+	OlderThan int64  `json:"older_than" validate:"required,min=1"`
+	State     string `json:"state" validate:"omitempty,oneof=completed failed cancelled"`
+	OwnerID   string `json:"owner_id" validate:"omitempty,hexadecimal,len=24"`
+}
+
+type CancelJobRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
+type RetryJobRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
+type RetrainSceneRequest struct {
+	SceneID          string   `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	TrainingMode     string   `json:"training_mode" validate:"required,oneof=gaussian tensorf"`
+	OutputTypes      []string `json:"output_types" validate:"required,min=1,dive,validOutputType"`
+	SaveIterations   []int    `json:"save_iterations" validate:"required,dive,min=1,max=30000"`
+	TotalIterations  int      `json:"total_iterations" validate:"required,min=1,max=30000"`
+	FrameSampleRate  int      `json:"frame_sample_rate" validate:"omitempty,min=1"`
+	TargetFrameCount int      `json:"target_frame_count" validate:"omitempty,min=1,excluded_with=FrameSampleRate"`
+	Priority         string   `json:"priority" validate:"omitempty,oneof=normal high"`
+	RerunSfm         bool     `json:"rerun_sfm"`
+	Preset           string   `json:"preset"`
+}
+
+type EstimateTrainingRequest struct {
+	TrainingMode     string   `json:"training_mode" validate:"required,oneof=gaussian tensorf"`
+	OutputTypes      []string `json:"output_types" validate:"required,min=1,dive,validOutputType"`
+	SaveIterations   []int    `json:"save_iterations" validate:"required,dive,min=1,max=30000"`
+	TotalIterations  int      `json:"total_iterations" validate:"required,min=1,max=30000"`
+	FrameSampleRate  int      `json:"frame_sample_rate" validate:"omitempty,min=1"`
+	TargetFrameCount int      `json:"target_frame_count" validate:"omitempty,min=1,excluded_with=FrameSampleRate"`
+	Width            int      `json:"width" validate:"required,min=1"`
+	Height           int      `json:"height" validate:"required,min=1"`
+	FPS              float64  `json:"fps" validate:"required,gt=0"`
+	DurationSeconds  float64  `json:"duration_seconds" validate:"required,gt=0"`
+	FrameCount       int      `json:"frame_count" validate:"omitempty,min=1"`
+	FileSize         int64    `json:"file_size" validate:"omitempty,min=1"`
+	Preset           string   `json:"preset"`
+}
+
+type GetUserHistoryRequest struct {
+	Stage    string `query:"stage" validate:"omitempty,oneof=queued training done failed cancelled"`
+	Page     int    `query:"page" validate:"omitempty,min=1"`
+	PageSize int    `query:"page_size" validate:"omitempty,min=1,max=100"`
+	Sort     string `query:"sort" validate:"omitempty,oneof=newest oldest"`
+}
+
+type SearchScenesRequest struct {
+	Query    string `query:"q" validate:"omitempty,max=256"`
+	Tags     string `query:"tags" validate:"omitempty,max=1024"`
+	Stage    string `query:"stage" validate:"omitempty,oneof=queued training done failed cancelled"`
+	Page     int    `query:"page" validate:"omitempty,min=1"`
+	PageSize int    `query:"page_size" validate:"omitempty,min=1,max=100"`
+}
+
+type AdminListScenesRequest struct {
+	State    string `query:"state" validate:"omitempty,oneof=grace_period pending_admission queued sfm_running sfm_done training completed failed cancelled"`
+	Owner    string `query:"owner"`
+	Page     int    `query:"page" validate:"omitempty,min=1"`
+	PageSize int    `query:"page_size" validate:"omitempty,min=1,max=100"`
+}
+
+type AdminSceneRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
+type AdminSetAdmissionRequest struct {
+	MaxInFlightJobs *int64 `json:"max_in_flight_jobs" validate:"required,min=0"`
+}
+
+type AdminSetThrottleRequest struct {
+	ConnectionBytesPerSecond *int64   `json:"connection_bytes_per_second" validate:"required,min=0"`
+	UserBytesPerSecond       *int64   `json:"user_bytes_per_second" validate:"required,min=0"`
+	ExemptNetworks           []string `json:"exempt_networks" validate:"omitempty,max=64,dive,cidr"`
+}
+
+type AdminListUsersRequest struct {
+	Page     int `query:"page" validate:"omitempty,min=1"`
+	PageSize int `query:"page_size" validate:"omitempty,min=1,max=100"`
+}
+
+type AdminUserRequest struct {
+	UserID string `params:"user_id" validate:"required,hexadecimal,len=24"`
+}
+
+type AdminSetUserQuotaRequest struct {
+	MaxConcurrentJobs      *int64 `json:"max_concurrent_jobs" validate:"omitempty,min=0"`
+	MaxStorageBytes        *int64 `json:"max_storage_bytes" validate:"omitempty,min=0"`
+	MaxUploadsPerDay       *int64 `json:"max_uploads_per_day" validate:"omitempty,min=0"`
+	MaxDownloadBytesPerDay *int64 `json:"max_download_bytes_per_day" validate:"omitempty,min=0"`
+}
+
+type AdminSetUserTierRequest struct {
+	Tier string `json:"tier" validate:"required,oneof=priority standard free"`
 }
 
 type DeleteUserRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+type UpdateNotificationPreferencesRequest struct {
+	TrainingComplete *bool `json:"training_complete" validate:"required"`
+	JobFailed        *bool `json:"job_failed" validate:"required"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=64"`
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=upload read admin"`
+}
+
+type RevokeAPIKeyRequest struct {
+	KeyID string `params:"key_id" validate:"required,hexadecimal,len=24"`
+}
+
+type RevokeSessionRequest struct {
+	SessionID string `params:"session_id" validate:"required,hexadecimal,len=24"`
+}
+
+type RevokeAllSessionsRequest struct {
+	KeepCurrent bool `json:"keep_current"`
+}
+
+type OAuthProviderRequest struct {
+	Provider string `params:"provider" validate:"required,oneof=google github oidc"`
+}
+
+type OAuthCallbackRequest struct {
+	Provider string `params:"provider" validate:"required,oneof=google github oidc"`
+	Code     string `json:"code" validate:"required,max=2048"`
+	State    string `json:"state" validate:"required,max=4096"`
+}
+
+type CreateWebhookRequest struct {
+	URL     string   `json:"url" validate:"required,url,max=2048"`
+	Events  []string `json:"events" validate:"omitempty,dive,oneof=sfm_started sfm_complete training_started training_progress training_complete job_failed"`
+	SceneID string   `json:"scene_id" validate:"omitempty,hexadecimal,len=24"`
+}
+
+type WebhookRequest struct {
+	WebhookID string `params:"webhook_id" validate:"required,hexadecimal,len=24"`
+}
+
 type NewSceneRequest struct {
-	File            *multipart.FileHeader `form:"file" validate:"required"`
-	TrainingMode    string                `form:"training_mode" validate:"required,oneof=gaussian tensorf"`
-	OutputTypes     []string              `form:"output_types" validate:"required,dive,validOutputType"`
-	SaveIterations  []int                 `form:"save_iterations" validate:"required,dive,min=1,max=30000"`
-	TotalIterations int                   `form:"total_iterations" validate:"required,min=1,max=30000"`
-	SceneName       string                `form:"scene_name"`
+	File             *multipart.FileHeader `form:"file" validate:"required"`
+	TrainingMode     string                `form:"training_mode" validate:"required,oneof=gaussian tensorf"`
+	OutputTypes      []string              `form:"output_types" validate:"required,dive,validOutputType"`
+	SaveIterations   []int                 `form:"save_iterations" validate:"required,dive,min=1,max=30000"`
+	TotalIterations  int                   `form:"total_iterations" validate:"required,min=1,max=30000"`
+	FrameSampleRate  int                   `form:"frame_sample_rate" validate:"omitempty,min=1"`
+	TargetFrameCount int                   `form:"target_frame_count" validate:"omitempty,min=1,excluded_with=FrameSampleRate"`
+	SceneName        string                `form:"scene_name"`
+	Priority         string                `form:"priority" validate:"omitempty,oneof=normal high"`
+	SHA256           string                `form:"sha256" validate:"omitempty,hexadecimal,len=64"`
+	ReuseSfm         bool                  `form:"reuse_sfm"`
+	Preset           string                `form:"preset"`
+	OrgID            string                `form:"org_id" validate:"omitempty,hexadecimal,len=24"`
+}
+
+type NewSceneBatchRequest struct {
+	Files            []*multipart.FileHeader `form:"files" validate:"required_without=UploadIDs,max=50"`
+	UploadIDs        []string                `form:"upload_ids" validate:"omitempty,max=50,dive,hexadecimal,len=24"`
+	BatchName        string                  `form:"batch_name" validate:"omitempty,max=128"`
+	TrainingMode     string                  `form:"training_mode" validate:"required,oneof=gaussian tensorf"`
+	OutputTypes      []string                `form:"output_types" validate:"required,dive,validOutputType"`
+	SaveIterations   []int                   `form:"save_iterations" validate:"required,dive,min=1,max=30000"`
+	TotalIterations  int                     `form:"total_iterations" validate:"required,min=1,max=30000"`
+	FrameSampleRate  int                     `form:"frame_sample_rate" validate:"omitempty,min=1"`
+	TargetFrameCount int                     `form:"target_frame_count" validate:"omitempty,min=1,excluded_with=FrameSampleRate"`
+	Priority         string                  `form:"priority" validate:"omitempty,oneof=normal high"`
+	ReuseSfm         bool                    `form:"reuse_sfm"`
+	Preset           string                  `form:"preset"`
+	OrgID            string                  `form:"org_id" validate:"omitempty,hexadecimal,len=24"`
+}
+
+type BatchRequest struct {
+	BatchID string `params:"batch_id" validate:"required,hexadecimal,len=24"`
+}
+
+type NewSceneBundleRequest struct {
+	Poses           *multipart.FileHeader   `form:"poses" validate:"required"`
+	Images          []*multipart.FileHeader `form:"images" validate:"required,min=1"`
+	TrainingMode    string                  `form:"training_mode" validate:"required,oneof=gaussian tensorf"`
+	OutputTypes     []string                `form:"output_types" validate:"required,dive,validOutputType"`
+	SaveIterations  []int                   `form:"save_iterations" validate:"required,dive,min=1,max=30000"`
+	TotalIterations int                     `form:"total_iterations" validate:"required,min=1,max=30000"`
+	SceneName       string                  `form:"scene_name"`
+	Priority        string                  `form:"priority" validate:"omitempty,oneof=normal high"`
+	Preset          string                  `form:"preset"`
+	OrgID           string                  `form:"org_id" validate:"omitempty,hexadecimal,len=24"`
+}
+
+type StartUploadRequest struct {
+	FileName         string   `json:"file_name" validate:"required"`
+	Size             int64    `json:"size" validate:"required,min=1"`
+	TrainingMode     string   `json:"training_mode" validate:"required,oneof=gaussian tensorf"`
+	OutputTypes      []string `json:"output_types" validate:"required,dive,validOutputType"`
+	SaveIterations   []int    `json:"save_iterations" validate:"required,dive,min=1,max=30000"`
+	TotalIterations  int      `json:"total_iterations" validate:"required,min=1,max=30000"`
+	FrameSampleRate  int      `json:"frame_sample_rate" validate:"omitempty,min=1"`
+	TargetFrameCount int      `json:"target_frame_count" validate:"omitempty,min=1,excluded_with=FrameSampleRate"`
+	SceneName        string   `json:"scene_name"`
+	Priority         string   `json:"priority" validate:"omitempty,oneof=normal high"`
+	Preset           string   `json:"preset"`
+	OrgID            string   `json:"org_id" validate:"omitempty,hexadecimal,len=24"`
+}
+
+type UploadRequest struct {
+	UploadID string `params:"upload_id" validate:"required,hexadecimal,len=24"`
+}
+
+type WorkerRequest struct {
+	WorkerID string `params:"worker_id" validate:"required,max=128"`
+}
+
+type WorkerGPU struct {
+	Name        string  `json:"name" validate:"max=128"`
+	MemoryTotal int64   `json:"memory_total" validate:"min=0"`
+	MemoryUsed  int64   `json:"memory_used" validate:"min=0"`
+	Utilization float64 `json:"utilization" validate:"min=0,max=1"`
+}
+
+type WorkerCapacity struct {
+	Slots      int         `json:"slots" validate:"min=0"`
+	ActiveJobs int         `json:"active_jobs" validate:"min=0"`
+	GPUs       []WorkerGPU `json:"gpus" validate:"omitempty,max=64,dive"`
+}
+
+type RegisterWorkerRequest struct {
+	WorkerID string         `params:"worker_id" validate:"required,max=128"`
+	Kind     string         `json:"kind" validate:"required,oneof=sfm nerf"`
+	Hostname string         `json:"hostname" validate:"omitempty,max=255"`
+	Version  string         `json:"version" validate:"omitempty,max=64"`
+	Capacity WorkerCapacity `json:"capacity"`
+}
+
+type WorkerHeartbeatRequest struct {
+	WorkerID string         `params:"worker_id" validate:"required,max=128"`
+	Capacity WorkerCapacity `json:"capacity"`
+}
+
+type WorkerOutputRequest struct {
+	JobID      string `params:"job_id" validate:"required"`
+	OutputType string `params:"output_type" validate:"required,oneof=model splat_cloud point_cloud video checkpoint"`
+	Iteration  int    `params:"iteration" validate:"required,min=1"`
+	FileName   string `params:"file_name" validate:"required"`
+}
+
+type GenerateUploadTokenRequest struct {
+	MaxSize int64 `json:"max_size" validate:"required,min=1"`
+	TTL     int64 `json:"ttl" validate:"omitempty,min=1"`
 }
 
 type GetSceneMetadataRequest struct {
-	SceneID string `params:"scene_id" validate:"required"`
+	SceneID   string `params:"scene_id" validate:"required"`
+	ChunkSize int64  `query:"chunk_size" validate:"omitempty,min=1"`
+	Version   int    `query:"version" validate:"omitempty,min=1"`
+}
+
+type GetBatchSceneMetadataRequest struct {
+	SceneIDs   []string `json:"scene_ids" validate:"required,min=1,max=50,dive,hexadecimal,len=24"`
+	OutputType string   `json:"output_type" validate:"omitempty,oneof=splat_cloud point_cloud video model checkpoint ply gltf obj splat"`
+	ChunkSize  int64    `json:"chunk_size" validate:"omitempty,min=1"`
+}
+
+type ExportSceneRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	Format  string `json:"format" validate:"required,oneof=ply gltf obj splat"`
 }
 
 type GetSceneOutputRequest struct {
 	SceneID    string `params:"scene_id" validate:"required"`
-	OutputType string `params:"output_type" validate:"required,oneof=splat_cloud point_cloud video model"`
+	OutputType string `params:"output_type" validate:"required,oneof=splat_cloud point_cloud video model checkpoint ply gltf obj splat"`
 	Iteration  string `query:"iteration"`
+	Chunk      string `query:"chunk" validate:"omitempty,numeric"`
+	ChunkSize  int64  `query:"chunk_size" validate:"omitempty,min=1"`
+	Format     string `query:"format" validate:"omitempty,alphanum,max=8"`
+	Version    int    `query:"version" validate:"omitempty,min=1"`
+}
+
+type GetSceneArchiveRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	Format  string `query:"format" validate:"omitempty,oneof=zip tar.gz"`
+}
+
+type GetResourceManifestRequest struct {
+	SceneID    string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	OutputType string `params:"output_type" validate:"required,oneof=splat_cloud point_cloud video model checkpoint ply gltf obj splat"`
+	Iteration  string `query:"iteration" validate:"omitempty,numeric"`
+	ChunkSize  int64  `query:"chunk_size" validate:"omitempty,min=1"`
+}
+
+type GetResourceURLRequest struct {
+	SceneID    string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	OutputType string `params:"output_type" validate:"required,oneof=splat_cloud point_cloud video model checkpoint ply gltf obj splat"`
+	Iteration  string `query:"iteration" validate:"omitempty,numeric"`
+	TTL        int64  `query:"ttl" validate:"omitempty,min=1"`
+}
+
+type GetSignedResourceRequest struct {
+	SceneID    string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	OutputType string `params:"output_type" validate:"required"`
+	Iteration  int    `params:"iteration" validate:"min=0"`
+	UserID     string `query:"uid" validate:"required,hexadecimal,len=24"`
+	Version    string `query:"v" validate:"required"`
+	Expires    int64  `query:"exp" validate:"required"`
+	Signature  string `query:"sig" validate:"required,hexadecimal"`
 }
 
 type GetSceneThumbnailRequest struct {
 	SceneID string `params:"scene_id" validate:"required"`
 }
 
+type GetScenePreviewRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
+type CompareIterationsRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	From    int    `query:"from" validate:"required,min=1"`
+	To      int    `query:"to" validate:"required,min=1"`
+}
+
+type GetComparisonFrameRequest struct {
+	SceneID string  `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	From    int     `query:"from" validate:"required,min=1"`
+	To      int     `query:"to" validate:"required,min=1"`
+	At      float64 `query:"at" validate:"omitempty,min=0"`
+}
+
+type RenderPose struct {
+	Position [3]float64 `json:"position"`
+	Rotation [4]float64 `json:"rotation"`
+	FOV      float64    `json:"fov" validate:"min=0,lt=180"`
+}
+
+type RenderSpline struct {
+	Keyframes     []RenderPose `json:"keyframes" validate:"required,min=2,max=256,dive"`
+	Interpolation string       `json:"interpolation" validate:"omitempty,oneof=linear catmull_rom"`
+	Duration      float64      `json:"duration" validate:"gt=0,max=600"`
+	Loop          bool         `json:"loop"`
+}
+
+type RenderSceneRequest struct {
+	SceneID   string        `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	Poses     []RenderPose  `json:"poses" validate:"required_without=Spline,excluded_with=Spline,max=3600,dive"`
+	Spline    *RenderSpline `json:"spline"`
+	FPS       int           `json:"fps" validate:"omitempty,min=1,max=60"`
+	Width     int           `json:"width" validate:"omitempty,min=1,max=1920"`
+	Height    int           `json:"height" validate:"omitempty,min=1,max=1080"`
+	Iteration int           `json:"iteration" validate:"omitempty,min=1"`
+}
+
+type GetRendersRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
+type GetRenderRequest struct {
+	SceneID  string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	RenderID string `params:"render_id" validate:"required,hexadecimal,len=24"`
+}
+
 type GetSceneNameRequest struct {
 	SceneID string `params:"scene_id" validate:"required"`
 }
 
+type GetSceneAuditLogRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
+type AdminGetAuditLogRequest struct {
+	UserID       string `query:"user_id" validate:"omitempty,hexadecimal,len=24"`
+	SceneID      string `query:"scene_id" validate:"omitempty,hexadecimal,len=24"`
+	TargetUserID string `query:"target_user_id" validate:"omitempty,hexadecimal,len=24"`
+	Action       string `query:"action" validate:"omitempty,max=64"`
+	Outcome      string `query:"outcome" validate:"omitempty,oneof=allowed denied"`
+	Since        string `query:"since" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	Until        string `query:"until" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	Page         int    `query:"page" validate:"omitempty,min=1"`
+	PageSize     int    `query:"page_size" validate:"omitempty,min=1,max=100"`
+}
+
+type GetMyActivityRequest struct {
+	Page     int `query:"page" validate:"omitempty,min=1"`
+	PageSize int `query:"page_size" validate:"omitempty,min=1,max=100"`
+}
+
+type ShareSceneRequest struct {
+	SceneID  string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	Username string `json:"username" validate:"required"`
+	Role     string `json:"role" validate:"required,oneof=viewer editor"`
+}
+
+type UnshareSceneRequest struct {
+	SceneID  string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	Username string `json:"username" validate:"required"`
+}
+
+type GetSceneCollaboratorsRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
+type CreateShareLinkRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	TTL     int64  `json:"ttl" validate:"omitempty,min=1"`
+}
+
+type GetShareLinksRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
+type RevokeShareLinkRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	LinkID  string `params:"link_id" validate:"required,hexadecimal,len=24"`
+}
+
+type CreateOrgRequest struct {
+	Name string `json:"name" validate:"required,max=128"`
+}
+
+type OrgRequest struct {
+	OrgID string `params:"org_id" validate:"required,hexadecimal,len=24"`
+}
+
+type InviteOrgMemberRequest struct {
+	OrgID    string `params:"org_id" validate:"required,hexadecimal,len=24"`
+	Username string `json:"username" validate:"required"`
+	Role     string `json:"role" validate:"required,oneof=admin member viewer"`
+}
+
+type OrgMemberRequest struct {
+	OrgID  string `params:"org_id" validate:"required,hexadecimal,len=24"`
+	UserID string `params:"user_id" validate:"required,hexadecimal,len=24"`
+}
+
+type SetOrgMemberRoleRequest struct {
+	OrgID  string `params:"org_id" validate:"required,hexadecimal,len=24"`
+	UserID string `params:"user_id" validate:"required,hexadecimal,len=24"`
+	Role   string `json:"role" validate:"required,oneof=admin member viewer"`
+}
+
+type ListOrgScenesRequest struct {
+	OrgID    string `params:"org_id" validate:"required,hexadecimal,len=24"`
+	Stage    string `query:"stage" validate:"omitempty,oneof=queued training done failed cancelled"`
+	Page     int    `query:"page" validate:"omitempty,min=1"`
+	PageSize int    `query:"page_size" validate:"omitempty,min=1,max=100"`
+	Sort     string `query:"sort" validate:"omitempty,oneof=newest oldest"`
+}
+
+type GetSharedSceneMetadataRequest struct {
+	Token     string `params:"token" validate:"required"`
+	ChunkSize int64  `query:"chunk_size" validate:"omitempty,min=1"`
+}
+
+type GetSharedSceneOutputRequest struct {
+	Token      string `params:"token" validate:"required"`
+	OutputType string `params:"output_type" validate:"required,oneof=splat_cloud point_cloud video model checkpoint ply gltf obj splat"`
+	Iteration  string `query:"iteration"`
+	Chunk      string `query:"chunk" validate:"omitempty,numeric"`
+	ChunkSize  int64  `query:"chunk_size" validate:"omitempty,min=1"`
+	Format     string `query:"format" validate:"omitempty,alphanum,max=8"`
+}
+
 type GetSceneProgressRequest struct {
 	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
 }
 
+type GetSceneRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
+type UpdateSceneMetadataRequest struct {
+	SceneID     string   `params:"scene_id" validate:"required,hexadecimal,len=24"`
+	Name        *string  `json:"name" validate:"omitempty,min=1,max=128"`
+	Description *string  `json:"description" validate:"omitempty,max=2000"`
+	Tags        []string `json:"tags" validate:"omitempty,max=20,dive,min=1,max=32"`
+}
+
+type GetSceneStatusRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
+type GetJobErrorRequest struct {
+	SceneID string `params:"scene_id" validate:"required,hexadecimal,len=24"`
+}
+
 type GetWorkerDataRequest struct {
 	Path string `params:"path" validate:"required"`
 }
\ No newline at end of file
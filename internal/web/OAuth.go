@@ -0,0 +1,169 @@
+// This file contains the handlers of logging in with external accounts, see services/OAuth.go: starting and completing
+// a login with a provider, and linking the accounts of providers to a user.
+
+package web
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// listOAuthProviders handles the request to list the providers users may log in with, so clients only offer those.
+func (s *WebServer) listOAuthProviders(c *fiber.Ctx) error {
+	s.logger.Debug("List OAuth providers request received")
+	return c.Status(http.StatusOK).JSON(fiber.Map{"providers": s.clientService.OAuthProviders()})
+}
+
+// startOAuthLogin handles the request to start logging in with a provider. The response holds the URL to send the
+// user to, and the state to keep until the provider redirects back.
+//
+// It expects path parameter `provider`, one of google, github, or oidc.
+func (s *WebServer) startOAuthLogin(c *fiber.Ctx) error {
+	s.logger.Debug("Start OAuth login request received")
+
+	var req OAuthProviderRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Start OAuth login request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	authorization, err := s.clientService.StartOAuthLogin(c.UserContext(), req.Provider)
+	if err != nil {
+		s.logger.Debug("Failed to start OAuth login: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(authorization)
+}
+
+// oauthLogin handles the request to complete a login with a provider, with the code and state it redirected back
+// with. The response holds the tokens of the session, as for loginUser.
+//
+// It expects path parameter `provider` and a JSON payload with the following format:
+//
+//	{
+//	    "code": "code of the redirect",
+//	    "state": "state of the redirect"
+//	}
+func (s *WebServer) oauthLogin(c *fiber.Ctx) error {
+	s.logger.Debug("OAuth login request received")
+
+	var req OAuthCallbackRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("OAuth login request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	tokens, err := s.clientService.OAuthLogin(c.UserContext(), req.Provider, req.Code, req.State)
+	if err != nil {
+		s.logger.Debug("OAuth login failed: ", err.Error())
+		return s.sendError(c, err)
+	}
+	s.logger.Debug("User logged in with ", req.Provider)
+
+	return c.Status(http.StatusOK).JSON(tokens)
+}
+
+// listIdentities handles the request to list the providers linked to the user, and whether the user has a password.
+// It is a JWT protected route.
+func (s *WebServer) listIdentities(c *fiber.Ctx) error {
+	s.logger.Debug("List identities request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	identities, err := s.clientService.ListIdentities(c.UserContext(), userID)
+	if err != nil {
+		s.logger.Debug("Failed to list identities: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(identities)
+}
+
+// startOAuthLink handles the request to start linking an account of a provider to the user. The response is that of
+// startOAuthLogin, but the state only completes for this user, at linkIdentity. It is a JWT protected route.
+//
+// It expects path parameter `provider`.
+func (s *WebServer) startOAuthLink(c *fiber.Ctx) error {
+	s.logger.Debug("Start OAuth link request received")
+
+	var req OAuthProviderRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Start OAuth link request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	authorization, err := s.clientService.StartOAuthLink(c.UserContext(), userID, req.Provider)
+	if err != nil {
+		s.logger.Debug("Failed to start OAuth link: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(authorization)
+}
+
+// linkIdentity handles the request to complete linking an account of a provider to the user, with the code and state
+// it redirected back with. It is a JWT protected route.
+//
+// It expects path parameter `provider` and the JSON payload of oauthLogin.
+func (s *WebServer) linkIdentity(c *fiber.Ctx) error {
+	s.logger.Debug("Link identity request received")
+
+	var req OAuthCallbackRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Link identity request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	if err := s.clientService.LinkOAuthIdentity(c.UserContext(), userID, req.Provider, req.Code, req.State); err != nil {
+		s.logger.Debug("Failed to link identity: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Identity linked"})
+}
+
+// unlinkIdentity handles the request to unlink the account of a provider from the user. Users without a password
+// must keep one provider to log in with. It is a JWT protected route.
+//
+// It expects path parameter `provider`.
+func (s *WebServer) unlinkIdentity(c *fiber.Ctx) error {
+	s.logger.Debug("Unlink identity request received")
+
+	var req OAuthProviderRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Unlink identity request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	if err := s.clientService.UnlinkOAuthIdentity(c.UserContext(), userID, req.Provider); err != nil {
+		s.logger.Debug("Failed to unlink identity: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Identity unlinked"})
+}
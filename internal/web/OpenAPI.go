@@ -0,0 +1,694 @@
+// This file contains the OpenAPI 3 specification of the REST API, generated from the handler layer.
+//
+// The specification is built once the routes are set up, from the registered routes and apiOperations, which tells
+// the summary, request struct, and authorization of each. Parameters and bodies are read from the request structs of
+// IncomingRequests.go: `params` fields are path parameters, `query` fields query parameters, `json` fields properties
+// of a JSON body, and `form` fields parts of a multipart body. Their `validate` tags are translated to schema
+// constraints, so the specification and ValidateRequest agree on what a valid request is. Routes missing from
+// apiOperations are still listed, with only their path parameters.
+//
+// The specification is served on /openapi.json, and browsed with Swagger UI on /docs. The assets of Swagger UI are the
+// copy of swagger-ui-dist embedded by github.com/swaggo/files/v2, served under /docs too, so the page loads no script
+// from a third party. Requests are checked against it by validateAgainstSpec, see SpecValidation.go.
+
+package web
+
+import (
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	swaggerFiles "github.com/swaggo/files/v2"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
+)
+
+// openAPIVersion is the version of the specification format, which Swagger UI and most generators support.
+const openAPIVersion = "3.0.3"
+
+// Security scheme names
+const (
+	securityBearer      = "bearerAuth"
+	securityAPIKey      = "apiKeyAuth"
+	securityQueryToken  = "accessTokenQuery"
+	securityUploadToken = "uploadTokenAuth"
+	securityWorkerKey   = "workerKeyAuth"
+)
+
+// Authorizations of routes, any one scheme of which authorizes a request
+var (
+	authToken         = []string{securityBearer}
+	authTokenOrAPIKey = []string{securityBearer, securityAPIKey}
+	authStream        = []string{securityBearer, securityQueryToken}
+	authUploadToken   = []string{securityUploadToken}
+	authWorker        = []string{securityWorkerKey}
+)
+
+// apiOperation describes a route for the specification.
+type apiOperation struct {
+	summary string
+	// request struct the handler reads, nil if it reads none
+	request any
+	// security schemes authorizing the route, none if it is public or authorized otherwise (i.e by a signature)
+	security []string
+	// content type of a body the handler reads as is, rather than through request
+	rawBody string
+}
+
+// apiOperations describes each route, by method and path as registered in SetupRoutes.
+var apiOperations = map[string]apiOperation{
+	// Account
+	"POST /user/account/login":                          {summary: "Log in, returning an access token and a refresh token", request: LoginRequest{}},
+	"POST /user/account/token/refresh":                  {summary: "Exchange a refresh token for new tokens", request: RefreshTokenRequest{}},
+	"POST /user/account/logout":                         {summary: "Revoke a refresh token", request: RefreshTokenRequest{}},
+	"GET /user/account/oauth":                           {summary: "List the providers users may log in with"},
+	"GET /user/account/oauth/:provider/authorize":       {summary: "Start logging in with a provider", request: OAuthProviderRequest{}},
+	"POST /user/account/oauth/:provider/login":          {summary: "Log in with the code a provider redirected back with, returning an access token and a refresh token", request: OAuthCallbackRequest{}},
+	"POST /user/account/register":                       {summary: "Register a user", request: RegisterRequest{}},
+	"POST /user/account/verify":                         {summary: "Verify the email address of a user", request: VerifyEmailRequest{}},
+	"POST /user/account/verify/resend":                  {summary: "Send a new email verification link", request: ResendVerificationRequest{}},
+	"PATCH /user/account/update/username":               {summary: "Change the username", request: UpdateUsernameRequest{}, security: authToken},
+	"PATCH /user/account/update/password":               {summary: "Change the password", request: UpdatePasswordRequest{}, security: authToken},
+	"DELETE /user/account/delete":                       {summary: "Delete the account and every scene of the user", request: DeleteUserRequest{}, security: authToken},
+	"GET /user/account/notifications":                   {summary: "Get the notification preferences", security: authToken},
+	"PUT /user/account/notifications":                   {summary: "Choose the job notifications mailed to the user", request: UpdateNotificationPreferencesRequest{}, security: authToken},
+	"GET /user/account/api-keys":                        {summary: "List API keys", security: authToken},
+	"POST /user/account/api-keys":                       {summary: "Create an API key", request: CreateAPIKeyRequest{}, security: authToken},
+	"DELETE /user/account/api-keys/:key_id":             {summary: "Revoke an API key", request: RevokeAPIKeyRequest{}, security: authToken},
+	"GET /user/account/sessions":                        {summary: "List the active sessions of the user", security: authToken},
+	"DELETE /user/account/sessions":                     {summary: "Log out every session, optionally except the current one", request: RevokeAllSessionsRequest{}, security: authToken},
+	"DELETE /user/account/sessions/:session_id":         {summary: "Log out a session", request: RevokeSessionRequest{}, security: authToken},
+	"GET /user/account/identities":                      {summary: "List the providers linked to the account", security: authToken},
+	"POST /user/account/identities/:provider/authorize": {summary: "Start linking an account of a provider", request: OAuthProviderRequest{}, security: authToken},
+	"POST /user/account/identities/:provider":           {summary: "Link an account of a provider with the code it redirected back with", request: OAuthCallbackRequest{}, security: authToken},
+	"DELETE /user/account/identities/:provider":         {summary: "Unlink the account of a provider", request: OAuthProviderRequest{}, security: authToken},
+	"GET /user/activity":                                {summary: "List the audit events made by the user, newest first", request: GetMyActivityRequest{}, security: authToken},
+	"GET /user/quota":                                   {summary: "Get the usage and limits of the user", security: authTokenOrAPIKey},
+	"GET /user/webhooks":                                {summary: "List webhooks", security: authTokenOrAPIKey},
+	"POST /user/webhooks":                               {summary: "Create a webhook", request: CreateWebhookRequest{}, security: authToken},
+	"DELETE /user/webhooks/:webhook_id":                 {summary: "Delete a webhook", request: WebhookRequest{}, security: authToken},
+	"GET /user/webhooks/:webhook_id/deliveries":         {summary: "List the recent deliveries of a webhook", request: WebhookRequest{}, security: authTokenOrAPIKey},
+
+	// Scenes
+	"DELETE /user/scene/delete/:scene_id":                    {summary: "Move a scene to the trash, or delete it permanently", request: DeleteSceneRequest{}, security: authToken},
+	"GET /user/scene/trash":                                  {summary: "List the scenes in the trash", request: GetTrashRequest{}, security: authTokenOrAPIKey},
+	"POST /user/scene/restore/:scene_id":                     {summary: "Restore a scene from the trash", request: RestoreSceneRequest{}, security: authToken},
+	"POST /user/scene/cleanup":                               {summary: "Delete the scenes older than a given age", request: DeleteOldScenesRequest{}, security: authToken},
+	"POST /user/scene/new":                                   {summary: "Upload a video or an image set (.zip) and start training a scene", request: NewSceneRequest{}, security: authTokenOrAPIKey},
+	"POST /user/scene/new/bundle":                            {summary: "Upload images with known camera poses and start training a scene", request: NewSceneBundleRequest{}, security: authTokenOrAPIKey},
+	"POST /user/scene/new/batch":                             {summary: "Upload several videos and start processing a scene for each", request: NewSceneBatchRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/batch/:batch_id":                        {summary: "Get the status of a batch upload and its scenes", request: BatchRequest{}, security: authTokenOrAPIKey},
+	"POST /user/upload/token":                                {summary: "Create an upload token for a direct upload", request: GenerateUploadTokenRequest{}, security: authTokenOrAPIKey},
+	"POST /user/scene/upload":                                {summary: "Start a resumable upload", request: StartUploadRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/upload/:upload_id":                      {summary: "Get the progress of a resumable upload", request: UploadRequest{}, security: authTokenOrAPIKey},
+	"PATCH /user/scene/upload/:upload_id":                    {summary: "Append a chunk to a resumable upload at the offset in the Upload-Offset header", request: UploadRequest{}, security: authTokenOrAPIKey, rawBody: "application/offset+octet-stream"},
+	"POST /user/scene/upload/:upload_id/complete":            {summary: "Complete a resumable upload and start training a scene", request: UploadRequest{}, security: authTokenOrAPIKey},
+	"DELETE /user/scene/upload/:upload_id":                   {summary: "Abort a resumable upload", request: UploadRequest{}, security: authTokenOrAPIKey},
+	"POST /upload/scene/new":                                 {summary: "Upload a video with an upload token and start training a scene", request: NewSceneRequest{}, security: authUploadToken},
+	"POST /user/scene/cancel/:scene_id":                      {summary: "Cancel the processing of a scene", request: CancelJobRequest{}, security: authToken},
+	"POST /user/scene/retry/:scene_id":                       {summary: "Retry a failed scene", request: RetryJobRequest{}, security: authTokenOrAPIKey},
+	"POST /user/scene/retrain/:scene_id":                     {summary: "Train a scene again with a new config", request: RetrainSceneRequest{}, security: authTokenOrAPIKey},
+	"POST /user/scene/estimate":                              {summary: "Estimate the duration and cost of training", request: EstimateTrainingRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/presets":                                {summary: "List training presets", security: authTokenOrAPIKey},
+	"POST /user/scene/export/:scene_id":                      {summary: "Export the outputs of a scene to another format", request: ExportSceneRequest{}, security: authTokenOrAPIKey},
+	"POST /user/scene/render/:scene_id":                      {summary: "Render a scene along a camera path into a video", request: RenderSceneRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/render/:scene_id":                       {summary: "List the renders of a scene", request: GetRendersRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/render/:scene_id/:render_id":            {summary: "Get the state of a render of a scene", request: GetRenderRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/render/:scene_id/:render_id/video":      {summary: "Download the video of a render of a scene", request: GetRenderRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/metadata/:scene_id":                     {summary: "Get the output files of a scene", request: GetSceneMetadataRequest{}, security: authTokenOrAPIKey},
+	"POST /user/scene/metadata/batch":                        {summary: "Get the output files of several scenes", request: GetBatchSceneMetadataRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/thumbnail/:scene_id":                    {summary: "Get the thumbnail of a scene", request: GetSceneThumbnailRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/preview/:scene_id":                      {summary: "Get the preview clip of a scene", request: GetScenePreviewRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/compare/:scene_id":                      {summary: "Compare the outputs of two save iterations of a scene", request: CompareIterationsRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/compare/:scene_id/frame":                {summary: "Get a frame of the rendered videos of two save iterations side by side", request: GetComparisonFrameRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/name/:scene_id":                         {summary: "Get the name of a scene", request: GetSceneNameRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/progress/:scene_id":                     {summary: "Get the training progress of a scene", request: GetSceneProgressRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/status/:scene_id":                       {summary: "Get the status of a scene", request: GetSceneStatusRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/status/:scene_id/progress":              {summary: "Get the percent complete and ETA of the job of a scene", request: GetSceneStatusRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/status/:scene_id/stream":                {summary: "Stream the status of a scene as Server-Sent Events", request: GetSceneStatusRequest{}, security: authStream},
+	"GET /user/scene/preview/:scene_id/stream":               {summary: "Watch a scene train over a WebSocket", request: GetSceneStatusRequest{}, security: authStream},
+	"GET /user/scene/error/:scene_id":                        {summary: "Get the error of a failed scene", request: GetJobErrorRequest{}, security: authTokenOrAPIKey},
+	"PATCH /user/scene/details/:scene_id":                    {summary: "Rename a scene, and set its description and tags", request: UpdateSceneMetadataRequest{}, security: authToken},
+	"GET /user/scene/details/:scene_id":                      {summary: "Get the details of a scene", request: GetSceneRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/history":                                {summary: "List the IDs of the scenes of the user", security: authTokenOrAPIKey},
+	"GET /user/scene/search":                                 {summary: "Search the scenes of the user by name, description, and tags", request: SearchScenesRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/list":                                   {summary: "List the scenes of the user, a page at a time", request: GetUserHistoryRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/audit/:scene_id":                        {summary: "Get the audit log of a scene", request: GetSceneAuditLogRequest{}, security: authToken},
+	"GET /user/scene/share/:scene_id":                        {summary: "List the collaborators of a scene", request: GetSceneCollaboratorsRequest{}, security: authToken},
+	"POST /user/scene/share/:scene_id":                       {summary: "Share a scene with a user", request: ShareSceneRequest{}, security: authToken},
+	"DELETE /user/scene/share/:scene_id":                     {summary: "Stop sharing a scene with a user", request: UnshareSceneRequest{}, security: authToken},
+	"GET /user/scene/share-link/:scene_id":                   {summary: "List the share links of a scene", request: GetShareLinksRequest{}, security: authToken},
+	"POST /user/scene/share-link/:scene_id":                  {summary: "Create a share link to a scene", request: CreateShareLinkRequest{}, security: authToken},
+	"DELETE /user/scene/share-link/:scene_id/:link_id":       {summary: "Revoke a share link", request: RevokeShareLinkRequest{}, security: authToken},
+	"POST /user/org":                                         {summary: "Create an organization", request: CreateOrgRequest{}, security: authToken},
+	"GET /user/org":                                          {summary: "List the organizations of the user, and those they are invited to", security: authToken},
+	"GET /user/org/:org_id":                                  {summary: "Get an organization with its members", request: OrgRequest{}, security: authToken},
+	"DELETE /user/org/:org_id":                               {summary: "Delete an organization", request: OrgRequest{}, security: authToken},
+	"POST /user/org/:org_id/invitations":                     {summary: "Invite a user to an organization", request: InviteOrgMemberRequest{}, security: authToken},
+	"DELETE /user/org/:org_id/invitations/:user_id":          {summary: "Withdraw or decline an invitation to an organization", request: OrgMemberRequest{}, security: authToken},
+	"POST /user/org/:org_id/join":                            {summary: "Accept an invitation to an organization", request: OrgRequest{}, security: authToken},
+	"PUT /user/org/:org_id/members/:user_id":                 {summary: "Change the role of a member of an organization", request: SetOrgMemberRoleRequest{}, security: authToken},
+	"DELETE /user/org/:org_id/members/:user_id":              {summary: "Remove a member from an organization", request: OrgMemberRequest{}, security: authToken},
+	"GET /user/org/:org_id/scenes":                           {summary: "List the scenes in the workspace of an organization", request: ListOrgScenesRequest{}, security: authToken},
+	"GET /user/scene/output/:output_type/:scene_id":          {summary: "Download an output of a scene, whole, by chunk, or by range", request: GetSceneOutputRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/output-url/:output_type/:scene_id":      {summary: "Create a signed URL to an output of a scene", request: GetResourceURLRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/output-manifest/:output_type/:scene_id": {summary: "Get the chunk checksums of an output of a scene", request: GetResourceManifestRequest{}, security: authTokenOrAPIKey},
+	"GET /user/scene/archive/:scene_id":                      {summary: "Download every output of a scene as one archive", request: GetSceneArchiveRequest{}, security: authTokenOrAPIKey},
+
+	// Signed resources and share links
+	"GET " + services.ResourceURLPrefix + "/:scene_id/:output_type/:iteration": {summary: "Download an output through a signed URL", request: GetSignedResourceRequest{}},
+	"GET /shared/:token/metadata":            {summary: "Get the output files of a shared scene", request: GetSharedSceneMetadataRequest{}},
+	"GET /shared/:token/output/:output_type": {summary: "Download an output of a shared scene", request: GetSharedSceneOutputRequest{}},
+
+	// Admin
+	"POST /admin/quota/reconcile":          {summary: "Reconcile the storage counters of every user, unless the dry_run query parameter is not false", security: authTokenOrAPIKey},
+	"GET /admin/retention":                 {summary: "Report what the next run of the retention policy would remove, and what the last run removed", security: authTokenOrAPIKey},
+	"POST /admin/storage/reconcile":        {summary: "Reconcile stored files with scenes, unless the dry_run query parameter is not false", security: authTokenOrAPIKey},
+	"GET /admin/stats/processing-time":     {summary: "Get processing time statistics", security: authTokenOrAPIKey},
+	"GET /admin/scenes":                    {summary: "List every scene, a page at a time", request: AdminListScenesRequest{}, security: authTokenOrAPIKey},
+	"POST /admin/scene/requeue/:scene_id":  {summary: "Requeue the job of a scene", request: AdminSceneRequest{}, security: authTokenOrAPIKey},
+	"POST /admin/scene/bump/:scene_id":     {summary: "Move the job of a scene to the priority tier", request: AdminSceneRequest{}, security: authTokenOrAPIKey},
+	"POST /admin/scene/cancel/:scene_id":   {summary: "Cancel the job of a scene", request: AdminSceneRequest{}, security: authTokenOrAPIKey},
+	"DELETE /admin/scene/delete/:scene_id": {summary: "Delete a scene", request: AdminSceneRequest{}, security: authTokenOrAPIKey},
+	"GET /admin/admission":                 {summary: "Get the admission control state", security: authTokenOrAPIKey},
+	"PUT /admin/admission":                 {summary: "Set the most jobs in flight", request: AdminSetAdmissionRequest{}, security: authTokenOrAPIKey},
+	"GET /admin/throttle":                  {summary: "Get the bandwidth throttling of downloads", security: authTokenOrAPIKey},
+	"PUT /admin/throttle":                  {summary: "Change the bandwidth throttling of downloads", request: AdminSetThrottleRequest{}, security: authTokenOrAPIKey},
+	"GET /admin/queues":                    {summary: "Get the depth of every queue", security: authTokenOrAPIKey},
+	"GET /admin/cluster":                   {summary: "Get the registered workers and the available capacity by kind", security: authTokenOrAPIKey},
+	"GET /admin/audit":                     {summary: "List the audit log of every user, a page at a time", request: AdminGetAuditLogRequest{}, security: authTokenOrAPIKey},
+	"GET /admin/users":                     {summary: "List every user, a page at a time", request: AdminListUsersRequest{}, security: authTokenOrAPIKey},
+	"POST /admin/user/disable/:user_id":    {summary: "Disable a user", request: AdminUserRequest{}, security: authTokenOrAPIKey},
+	"POST /admin/user/enable/:user_id":     {summary: "Enable a user", request: AdminUserRequest{}, security: authTokenOrAPIKey},
+	"PUT /admin/user/quota/:user_id":       {summary: "Override the quotas of a user", request: AdminSetUserQuotaRequest{}, security: authTokenOrAPIKey},
+	"PUT /admin/user/tier/:user_id":        {summary: "Set the job tier of a user", request: AdminSetUserTierRequest{}, security: authTokenOrAPIKey},
+
+	// Internal and worker routes
+	"GET /worker-data/*": {summary: "Download an input file of a job", request: GetWorkerDataRequest{}},
+	"GET /worker/output/:job_id/:output_type/:iteration/:file_name":   {summary: "Get the progress of an output upload", request: WorkerOutputRequest{}, security: authWorker},
+	"PATCH /worker/output/:job_id/:output_type/:iteration/:file_name": {summary: "Append a chunk to an output upload at the offset in the Upload-Offset header", request: WorkerOutputRequest{}, security: authWorker, rawBody: "application/offset+octet-stream"},
+	"PUT /worker/registry/:worker_id":                                 {summary: "Register a worker with its capacity", request: RegisterWorkerRequest{}, security: authWorker},
+	"POST /worker/registry/:worker_id/heartbeat":                      {summary: "Record a heartbeat of a registered worker with its capacity", request: WorkerHeartbeatRequest{}, security: authWorker},
+	"DELETE /worker/registry/:worker_id":                              {summary: "Deregister a worker", request: WorkerRequest{}, security: authWorker},
+
+	// Debug and documentation routes
+	"GET /routes":       {summary: "List the registered routes"},
+	"GET /health":       {summary: "Check the health of the server"},
+	"GET /healthz":      {summary: "Liveness probe, reporting the status of each dependency"},
+	"GET /readyz":       {summary: "Readiness probe, failing with 503 while a dependency is unavailable"},
+	"GET /metrics":      {summary: "Collect metrics in the Prometheus text format"},
+	"GET /openapi.json": {summary: "Get this specification"},
+	"GET /docs":         {summary: "Browse this specification with Swagger UI"},
+	"GET /docs/:file":   {summary: "Get an asset of Swagger UI"},
+}
+
+// presetFields are the training config fields filled in from a training preset before a request is validated, so
+// they are not required of requests naming a preset.
+var presetFields = map[string]bool{
+	"training_mode":    true,
+	"output_types":     true,
+	"save_iterations":  true,
+	"total_iterations": true,
+}
+
+// Patterns of the string validations of the validator package
+const (
+	patternHexadecimal = "^(0[xX])?[0-9a-fA-F]+$"
+	patternNumeric     = "^[-+]?[0-9]+(?:\\.[0-9]+)?$"
+	patternAlphanum    = "^[a-zA-Z0-9]+$"
+)
+
+type (
+	openAPIDocument struct {
+		OpenAPI    string                                  `json:"openapi"`
+		Info       openAPIInfo                             `json:"info"`
+		Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+		Components openAPIComponents                       `json:"components"`
+	}
+
+	openAPIInfo struct {
+		Title       string `json:"title"`
+		Description string `json:"description,omitempty"`
+		Version     string `json:"version"`
+	}
+
+	openAPIOperation struct {
+		Summary     string                      `json:"summary,omitempty"`
+		OperationID string                      `json:"operationId"`
+		Tags        []string                    `json:"tags,omitempty"`
+		Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+		RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+		Responses   map[string]*openAPIResponse `json:"responses"`
+		Security    []map[string][]string       `json:"security,omitempty"`
+	}
+
+	openAPIParameter struct {
+		Name     string         `json:"name"`
+		In       string         `json:"in"`
+		Required bool           `json:"required,omitempty"`
+		Schema   *openAPISchema `json:"schema"`
+	}
+
+	openAPIRequestBody struct {
+		Required bool                        `json:"required,omitempty"`
+		Content  map[string]openAPIMediaType `json:"content"`
+	}
+
+	openAPIMediaType struct {
+		Schema *openAPISchema `json:"schema"`
+	}
+
+	openAPIResponse struct {
+		Description string                      `json:"description"`
+		Content     map[string]openAPIMediaType `json:"content,omitempty"`
+	}
+
+	openAPIComponents struct {
+		Schemas         map[string]*openAPISchema         `json:"schemas"`
+		SecuritySchemes map[string]*openAPISecurityScheme `json:"securitySchemes"`
+	}
+
+	openAPISecurityScheme struct {
+		Type         string `json:"type"`
+		Description  string `json:"description,omitempty"`
+		Scheme       string `json:"scheme,omitempty"`
+		BearerFormat string `json:"bearerFormat,omitempty"`
+		Name         string `json:"name,omitempty"`
+		In           string `json:"in,omitempty"`
+	}
+
+	openAPISchema struct {
+		Ref              string                    `json:"$ref,omitempty"`
+		Type             string                    `json:"type,omitempty"`
+		Format           string                    `json:"format,omitempty"`
+		Enum             []string                  `json:"enum,omitempty"`
+		Minimum          *float64                  `json:"minimum,omitempty"`
+		Maximum          *float64                  `json:"maximum,omitempty"`
+		ExclusiveMinimum bool                      `json:"exclusiveMinimum,omitempty"`
+		MinLength        *int                      `json:"minLength,omitempty"`
+		MaxLength        *int                      `json:"maxLength,omitempty"`
+		MinItems         *int                      `json:"minItems,omitempty"`
+		MaxItems         *int                      `json:"maxItems,omitempty"`
+		Pattern          string                    `json:"pattern,omitempty"`
+		Items            *openAPISchema            `json:"items,omitempty"`
+		Properties       map[string]*openAPISchema `json:"properties,omitempty"`
+		Required         []string                  `json:"required,omitempty"`
+	}
+)
+
+// openAPISpec is the specification of the API, and the operations requests are checked against.
+type openAPISpec struct {
+	document   *openAPIDocument
+	operations []*specOperation
+}
+
+// specOperation is an operation of the specification, as matched to requests.
+type specOperation struct {
+	method   string
+	segments []string
+	query    []*openAPIParameter
+	// schema of a JSON body, nil if the operation takes none
+	body *openAPISchema
+}
+
+// newOpenAPISpec builds the specification of the given routes.
+func newOpenAPISpec(routes []fiber.Route) *openAPISpec {
+	spec := &openAPISpec{document: &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:       "VidGoNerf Web Server API",
+			Description: "Trains NeRF and Gaussian splatting scenes from videos. Errors share the Error schema.",
+			Version:     "1.0.0",
+		},
+		Paths: make(map[string]map[string]*openAPIOperation),
+		Components: openAPIComponents{
+			Schemas: map[string]*openAPISchema{
+				"Error": {
+					Type: "object",
+					Properties: map[string]*openAPISchema{
+						"error":       {Type: "string"},
+						"fields":      {Type: "object"},
+						"retry_after": {Type: "integer"},
+					},
+					Required: []string{"error"},
+				},
+			},
+			SecuritySchemes: map[string]*openAPISecurityScheme{
+				securityBearer:      {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "Access token returned by /user/account/login"},
+				securityAPIKey:      {Type: "apiKey", In: "header", Name: apiKeyHeader, Description: "API key, restricted to its scopes"},
+				securityQueryToken:  {Type: "apiKey", In: "query", Name: "access_token", Description: "Access token, for clients that cannot set headers"},
+				securityUploadToken: {Type: "apiKey", In: "header", Name: uploadTokenHeader, Description: "Upload token returned by /user/upload/token"},
+				securityWorkerKey:   {Type: "apiKey", In: "header", Name: workerKeyHeader, Description: "Key of the GPU workers"},
+			},
+		},
+	}}
+
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		if route.Method == http.MethodHead || route.Method == http.MethodOptions || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		operation, op := newSpecOperation(route.Method, route.Path, apiOperations[key])
+		path := openAPIPath(route.Path)
+		if spec.document.Paths[path] == nil {
+			spec.document.Paths[path] = make(map[string]*openAPIOperation)
+		}
+		spec.document.Paths[path][strings.ToLower(route.Method)] = operation
+		spec.operations = append(spec.operations, op)
+	}
+	return spec
+}
+
+// openAPIPath converts a route path to a specification path, i.e "/user/scene/:scene_id" to "/user/scene/{scene_id}".
+// A wildcard becomes the `path` parameter.
+func openAPIPath(routePath string) string {
+	segments := strings.Split(routePath, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			segments[i] = "{" + strings.TrimPrefix(segment, ":") + "}"
+		case segment == "*":
+			segments[i] = "{path}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// newSpecOperation builds the operation of a route, described by api.
+func newSpecOperation(method, routePath string, api apiOperation) (*openAPIOperation, *specOperation) {
+	operation := &openAPIOperation{
+		Summary:     api.summary,
+		OperationID: operationID(method, routePath),
+		Responses: map[string]*openAPIResponse{
+			"2XX": {Description: "Success"},
+			"default": {
+				Description: "Error",
+				Content: map[string]openAPIMediaType{
+					"application/json": {Schema: &openAPISchema{Ref: "#/components/schemas/Error"}},
+				},
+			},
+		},
+	}
+	if segments := strings.Split(strings.Trim(routePath, "/"), "/"); segments[0] != "" {
+		operation.Tags = []string{segments[0]}
+	}
+	for _, scheme := range api.security {
+		operation.Security = append(operation.Security, map[string][]string{scheme: {}})
+	}
+	op := &specOperation{method: method, segments: strings.Split(strings.Trim(routePath, "/"), "/")}
+
+	// Path parameters are listed even if the request struct does not read them
+	params := make(map[string]*openAPIParameter)
+	for _, segment := range op.segments {
+		name, ok := strings.CutPrefix(segment, ":")
+		if segment == "*" {
+			name, ok = "path", true
+		}
+		if ok {
+			params[name] = &openAPIParameter{Name: name, In: "path", Required: true, Schema: &openAPISchema{Type: "string"}}
+			operation.Parameters = append(operation.Parameters, params[name])
+		}
+	}
+
+	if api.request != nil {
+		requestType := reflect.TypeOf(api.request)
+		_, hasPreset := requestType.FieldByName("Preset")
+		body := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+		bodyType := ""
+		for i := 0; i < requestType.NumField(); i++ {
+			field := requestType.Field(i)
+			schema, required := fieldSchema(field.Type, field.Tag.Get("validate"))
+
+			var in, name string
+			for _, tag := range []string{"params", "query", "json", "form"} {
+				if value := field.Tag.Get(tag); value != "" {
+					in, name, _ = strings.Cut(value, ",")
+					break
+				}
+			}
+			if hasPreset && presetFields[name] {
+				required = false
+			}
+
+			switch in {
+			case "params":
+				if param, ok := params[name]; ok {
+					param.Schema = schema
+				}
+			case "query":
+				param := &openAPIParameter{Name: name, In: "query", Required: required, Schema: schema}
+				operation.Parameters = append(operation.Parameters, param)
+				op.query = append(op.query, param)
+			case "json", "form":
+				bodyType = in
+				body.Properties[name] = schema
+				if required {
+					body.Required = append(body.Required, name)
+				}
+			}
+		}
+
+		switch bodyType {
+		case "json":
+			operation.RequestBody = &openAPIRequestBody{
+				Required: len(body.Required) > 0,
+				Content:  map[string]openAPIMediaType{"application/json": {Schema: body}},
+			}
+			op.body = body
+		case "form":
+			// Multipart fields are sent as text, lists comma separated
+			for _, schema := range body.Properties {
+				if schema.Type == "array" && schema.Items.Format != "binary" {
+					*schema = openAPISchema{Type: "string", Pattern: "^[^,]+(,[^,]+)*$"}
+				}
+			}
+			operation.RequestBody = &openAPIRequestBody{
+				Required: true,
+				Content:  map[string]openAPIMediaType{"multipart/form-data": {Schema: body}},
+			}
+		}
+	}
+	if api.rawBody != "" {
+		operation.RequestBody = &openAPIRequestBody{
+			Required: true,
+			Content:  map[string]openAPIMediaType{api.rawBody: {Schema: &openAPISchema{Type: "string", Format: "binary"}}},
+		}
+	}
+	return operation, op
+}
+
+// operationID names an operation after its method and path, i.e "getUserSceneMetadataBySceneId".
+func operationID(method, routePath string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, segment := range strings.FieldsFunc(routePath, func(r rune) bool { return r == '/' }) {
+		name, isParam := strings.CutPrefix(segment, ":")
+		if segment == "*" {
+			name, isParam = "path", true
+		}
+		if isParam {
+			b.WriteString("By")
+		}
+		for _, word := range strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' || r == '.' }) {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
+
+var fileHeaderType = reflect.TypeOf(&multipart.FileHeader{})
+
+// fieldSchema returns the schema of a request struct field of type t, with the constraints of its validate tag, and
+// whether the tag requires it.
+func fieldSchema(t reflect.Type, validateTag string) (*openAPISchema, bool) {
+	schema := typeSchema(t)
+	required := false
+
+	// Rules after dive apply to the items of a list
+	target := schema
+	for _, rule := range strings.Split(validateTag, ",") {
+		name, value, _ := strings.Cut(rule, "=")
+		switch name {
+		case "dive":
+			if target.Items != nil {
+				target = target.Items
+			}
+		case "required":
+			if target == schema {
+				required = true
+			}
+		case "oneof":
+			target.Enum = strings.Fields(value)
+		case "min", "max", "len", "gt":
+			applyBound(target, name, value)
+		case "hexadecimal":
+			target.Pattern = patternHexadecimal
+		case "numeric":
+			target.Pattern = patternNumeric
+		case "alphanum":
+			target.Pattern = patternAlphanum
+		case "url":
+			target.Format = "uri"
+		}
+	}
+	return schema, required
+}
+
+// applyBound applies a min, max, len, or gt rule to a schema, which bounds the value of numbers, the length of strings,
+// and the items of lists.
+func applyBound(schema *openAPISchema, rule, value string) {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+	length := int(n)
+	switch schema.Type {
+	case "integer", "number":
+		switch rule {
+		case "min":
+			schema.Minimum = &n
+		case "max":
+			schema.Maximum = &n
+		case "len":
+			schema.Minimum, schema.Maximum = &n, &n
+		case "gt":
+			schema.Minimum, schema.ExclusiveMinimum = &n, true
+		}
+	case "string":
+		switch rule {
+		case "min":
+			schema.MinLength = &length
+		case "max":
+			schema.MaxLength = &length
+		case "len":
+			schema.MinLength, schema.MaxLength = &length, &length
+		}
+	case "array":
+		switch rule {
+		case "min":
+			schema.MinItems = &length
+		case "max":
+			schema.MaxItems = &length
+		case "len":
+			schema.MinItems, schema.MaxItems = &length, &length
+		}
+	}
+}
+
+// typeSchema returns the schema of a Go type, without constraints.
+func typeSchema(t reflect.Type) *openAPISchema {
+	if t == fileHeaderType {
+		return &openAPISchema{Type: "string", Format: "binary"}
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.Slice:
+		return &openAPISchema{Type: "array", Items: typeSchema(t.Elem())}
+	default:
+		return &openAPISchema{Type: "object"}
+	}
+}
+
+// match returns the operation of a request, nil if there is none. Paths are matched case insensitively, ignoring a
+// trailing slash, as the router does.
+func (spec *openAPISpec) match(method, path string) *specOperation {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, op := range spec.operations {
+		if op.method == method && matchSegments(op.segments, segments) {
+			return op
+		}
+	}
+	return nil
+}
+
+// matchSegments checks if the segments of a path match those of a route.
+func matchSegments(route, path []string) bool {
+	for i, segment := range route {
+		switch {
+		case segment == "*":
+			return true
+		case i >= len(path):
+			return false
+		case strings.HasPrefix(segment, ":"):
+			if path[i] == "" {
+				return false
+			}
+		case !strings.EqualFold(segment, path[i]):
+			return false
+		}
+	}
+	return len(route) == len(path)
+}
+
+// getOpenAPISpec handles the request to get the OpenAPI specification of the API.
+func (s *WebServer) getOpenAPISpec(c *fiber.Ctx) error {
+	return c.Status(http.StatusOK).JSON(s.openAPI.document)
+}
+
+// swaggerUIPage is the page of Swagger UI, browsing /openapi.json.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>VidGoNerf API</title>
+  <link rel="stylesheet" href="/docs/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="/docs/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
+
+// getDocs handles the request to browse the OpenAPI specification with Swagger UI.
+func (s *WebServer) getDocs(c *fiber.Ctx) error {
+	c.Set("Content-Type", "text/html; charset=utf-8")
+	return c.Status(http.StatusOK).SendString(swaggerUIPage)
+}
+
+// swaggerUIAssets maps the assets of Swagger UI that swaggerUIPage loads to their content types.
+var swaggerUIAssets = map[string]string{
+	"swagger-ui.css":       "text/css; charset=utf-8",
+	"swagger-ui-bundle.js": "text/javascript; charset=utf-8",
+}
+
+// getDocsAsset handles the request to get an asset of Swagger UI, read from the embedded swagger-ui-dist.
+func (s *WebServer) getDocsAsset(c *fiber.Ctx) error {
+	name := c.Params("file")
+	contentType, ok := swaggerUIAssets[name]
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "File Not Found"})
+	}
+	asset, err := fs.ReadFile(swaggerFiles.FS, name)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+	// The assets only change with the version of github.com/swaggo/files in go.mod
+	c.Set("Cache-Control", "public, max-age=86400")
+	c.Set("Content-Type", contentType)
+	return c.Status(http.StatusOK).Send(asset)
+}
@@ -0,0 +1,122 @@
+// This file contains the rate limiting of authenticated requests, keyed by user ID.
+//
+// Each user has a token bucket holding up to Burst tokens, refilled at RequestsPerSecond. A request takes one token,
+// and is refused with 429 Too Many Requests and a Retry-After header while the bucket is empty. Buckets are kept in
+// memory, so limits start over when the server restarts, and are per server. Buckets of idle users are dropped once
+// full, as a full bucket is the same as none.
+
+package web
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
+)
+
+// DefaultRateLimitBurst is used when RateLimitConfig.Burst is not set.
+const DefaultRateLimitBurst = 20
+
+// rateLimitPruneInterval is how often buckets of idle users are dropped.
+const rateLimitPruneInterval = time.Minute
+
+// RateLimitConfig configures the rate limiting of authenticated requests.
+type RateLimitConfig struct {
+	// requests per second each user may make on average, <= 0 does not limit
+	RequestsPerSecond float64
+	// most requests a user may make at once after being idle
+	Burst int
+}
+
+// tokenBucket is the token bucket of one user.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter holds the token buckets of every user that made a request recently.
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// newRateLimiter creates a rateLimiter for config, or returns nil if config does not limit requests.
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	if config.RequestsPerSecond <= 0 {
+		return nil
+	}
+	burst := config.Burst
+	if burst <= 0 {
+		burst = DefaultRateLimitBurst
+	}
+	return &rateLimiter{
+		rate:      config.RequestsPerSecond,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of key. Returns false, and how long until a token is available, if it is empty.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) >= rateLimitPruneInterval {
+		l.prune(now)
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that refilled since they were last used. Must be called with mu held.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// SetRateLimit changes the rate limit of authenticated requests. A zero value does not limit them.
+// Every user starts over with a full bucket.
+func (s *WebServer) SetRateLimit(config RateLimitConfig) {
+	s.limiter.Store(newRateLimiter(config))
+}
+
+// limitRate runs handler if the user with the given ID has not exceeded the rate limit, and refuses the request
+// otherwise. It is called by the authentication middlewares once the user is known.
+func (s *WebServer) limitRate(c *fiber.Ctx, userID string, handler fiber.Handler) error {
+	limiter := s.limiter.Load()
+	if limiter == nil {
+		return handler(c)
+	}
+	if ok, wait := limiter.allow(userID, time.Now()); !ok {
+		s.metrics.RateLimitedRequestsTotal.Inc()
+		return s.sendError(c, &services.Error{
+			Kind:       services.ErrRateLimited,
+			Message:    "too many requests, slow down",
+			RetryAfter: wait,
+		})
+	}
+	return handler(c)
+}
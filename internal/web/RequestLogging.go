@@ -0,0 +1,97 @@
+// This file contains the request IDs and access log of HTTP requests.
+//
+// Every request gets a request ID, taken from the client's X-Request-ID header if it sent a well formed one, and
+// generated otherwise. The ID is sent back in the response, and carried by the request's user context together with
+// the ID of the authenticated user once known, and the trace ID of traced requests, so every entry logged for the
+// request with log.Logger.Ctx carries them. Each request is logged once it completed, with its route, status, and
+// duration.
+
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+)
+
+// requestIDHeader is the header carrying the ID of a request.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from a client.
+const maxRequestIDLength = 128
+
+// validRequestID checks if a request ID sent by a client is safe to log and send back: not empty, not too long, and
+// made of letters, digits, and -_.: only.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// logRequests is the middleware assigning the request ID of a request, and logging the request once it completed.
+// Requests polled by infrastructure are not logged, like they are not traced.
+func (s *WebServer) logRequests(c *fiber.Ctx) error {
+	requestID := c.Get(requestIDHeader)
+	if !validRequestID(requestID) {
+		requestID = newRequestID()
+	}
+	c.Set(requestIDHeader, requestID)
+	c.SetUserContext(log.WithFields(c.UserContext(), log.FieldRequestID, requestID))
+
+	start := time.Now()
+	err := c.Next()
+	if untracedPaths[c.Path()] {
+		return err
+	}
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		// Fiber's error handler has not set the status yet
+		status = http.StatusInternalServerError
+		if fe, ok := err.(*fiber.Error); ok {
+			status = fe.Code
+		}
+	}
+	logger := s.logger.Ctx(c.UserContext()).With(
+		"method", c.Method(),
+		"route", c.Route().Path,
+		"status", status,
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+	if status >= http.StatusInternalServerError {
+		logger.Warn("Request failed")
+	} else {
+		logger.Debug("Request completed")
+	}
+	return err
+}
+
+// setUser records the ID of the user authenticating a request, in the "userID" local read by handlers, and in the
+// request's user context for logging.
+func (s *WebServer) setUser(c *fiber.Ctx, userID string) {
+	c.Locals("userID", userID)
+	c.SetUserContext(log.WithFields(c.UserContext(), log.FieldUserID, userID))
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 
 	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
 )
 
 var validate *validator.Validate
@@ -39,18 +40,40 @@ func ValidateRequest(c *fiber.Ctx, req interface{}) error {
             return err
         }
     case "POST", "PUT", "PATCH":
-        // For requests with potential body content
-        if err := c.BodyParser(req); err != nil {
-            return err
+        // For requests with potential body content. Action routes (i.e cancel) may have no body at all.
+        if len(c.Body()) > 0 {
+            if err := c.BodyParser(req); err != nil {
+                return err
+            }
         }
-        // Also parse query parameters for these methods if needed
+        // Also parse query and path parameters for these methods if needed
         if err := c.QueryParser(req); err != nil {
             return err
         }
+        if err := c.ParamsParser(req); err != nil {
+            return err
+        }
+    case "DELETE":
+        // Path parameters identify the resource, and a body is only sent for confirmation (i.e password)
+        if err := c.ParamsParser(req); err != nil {
+            return err
+        }
+        if len(c.Body()) > 0 {
+            if err := c.BodyParser(req); err != nil {
+                return err
+            }
+        }
     default:
         // Unsupported HTTP method
     }
 
+    // Training config values left unset are filled in from the named preset before they are checked
+    if r, ok := req.(presetRequest); ok {
+        if err := r.applyPreset(); err != nil {
+            return err
+        }
+    }
+
     return validate.Struct(req)
 }
 
@@ -73,43 +96,212 @@ func ParseNewSceneRequest(c *fiber.Ctx) (*NewSceneRequest, error) {
     // Parse other form fields
     req.TrainingMode = c.FormValue("training_mode")
     req.SceneName = c.FormValue("scene_name")
+    req.Priority = c.FormValue("priority")
+    req.SHA256 = c.FormValue("sha256")
+    req.Preset = c.FormValue("preset")
+    req.OrgID = c.FormValue("org_id")
+
+    // Parse total iterations, output types, and save iterations
+    req.TotalIterations, req.OutputTypes, req.SaveIterations, err = parseTrainingFormValues(c)
+    if err != nil {
+        return nil, err
+    }
+    if err := applyTrainingPreset(req.Preset, &req.TrainingMode, &req.OutputTypes, &req.SaveIterations, &req.TotalIterations); err != nil {
+        return nil, err
+    }
+
+    if value := c.FormValue("reuse_sfm"); value != "" {
+        req.ReuseSfm, err = strconv.ParseBool(value)
+        if err != nil {
+            return nil, errors.New("invalid reuse sfm")
+        }
+    }
+
+    // Parse frame sampling, both are optional and left at 0 when not provided
+    for field, dst := range map[string]*int{"frame_sample_rate": &req.FrameSampleRate, "target_frame_count": &req.TargetFrameCount} {
+        value := c.FormValue(field)
+        if value == "" {
+            continue
+        }
+        parsed, err := strconv.Atoi(value)
+        if err != nil {
+            return nil, errors.New("invalid " + strings.ReplaceAll(field, "_", " "))
+        }
+        *dst = parsed
+    }
+
+    // Validate the request
+    if err := validate.Struct(req); err != nil {
+        return nil, err
+    }
+
+    return &req, nil
+}
+
+// ParseNewSceneBundleRequest parses an upload of images and camera poses from a Fiber context, like ParseNewSceneRequest.
+//
+// Returns a NewSceneBundleRequest struct if successful, error otherwise.
+func ParseNewSceneBundleRequest(c *fiber.Ctx) (*NewSceneBundleRequest, error) {
+    var req NewSceneBundleRequest
+
+    // Handle file uploads, images are sent as repeated "images" parts
+    poses, err := c.FormFile("poses")
+    if err != nil {
+        return nil, errors.New("camera poses upload error: " + err.Error())
+    }
+    req.Poses = poses
+
+    form, err := c.MultipartForm()
+    if err != nil {
+        return nil, errors.New("images upload error: " + err.Error())
+    }
+    req.Images = form.File["images"]
+
+    // Parse other form fields
+    req.TrainingMode = c.FormValue("training_mode")
+    req.SceneName = c.FormValue("scene_name")
+    req.Priority = c.FormValue("priority")
+    req.Preset = c.FormValue("preset")
+    req.OrgID = c.FormValue("org_id")
+
+    req.TotalIterations, req.OutputTypes, req.SaveIterations, err = parseTrainingFormValues(c)
+    if err != nil {
+        return nil, err
+    }
+    if err := applyTrainingPreset(req.Preset, &req.TrainingMode, &req.OutputTypes, &req.SaveIterations, &req.TotalIterations); err != nil {
+        return nil, err
+    }
+
+    // Validate the request
+    if err := validate.Struct(req); err != nil {
+        return nil, err
+    }
+
+    return &req, nil
+}
+
+// ParseNewSceneBatchRequest parses a batch upload of videos from a Fiber context, like ParseNewSceneRequest.
+//
+// Returns a NewSceneBatchRequest struct if successful, error otherwise.
+func ParseNewSceneBatchRequest(c *fiber.Ctx) (*NewSceneBatchRequest, error) {
+    var req NewSceneBatchRequest
+
+    // Handle file uploads, videos are sent as repeated "files" parts
+    form, err := c.MultipartForm()
+    if err != nil {
+        return nil, errors.New("files upload error: " + err.Error())
+    }
+    req.Files = form.File["files"]
+
+    // Parse other form fields. Upload IDs are comma separated, like output types.
+    req.BatchName = c.FormValue("batch_name")
+    req.TrainingMode = c.FormValue("training_mode")
+    req.Priority = c.FormValue("priority")
+    req.Preset = c.FormValue("preset")
+    req.OrgID = c.FormValue("org_id")
+    if uploadIDs := c.FormValue("upload_ids"); uploadIDs != "" {
+        req.UploadIDs = strings.Split(uploadIDs, ",")
+    }
+
+    req.TotalIterations, req.OutputTypes, req.SaveIterations, err = parseTrainingFormValues(c)
+    if err != nil {
+        return nil, err
+    }
+    if err := applyTrainingPreset(req.Preset, &req.TrainingMode, &req.OutputTypes, &req.SaveIterations, &req.TotalIterations); err != nil {
+        return nil, err
+    }
+
+    if value := c.FormValue("reuse_sfm"); value != "" {
+        req.ReuseSfm, err = strconv.ParseBool(value)
+        if err != nil {
+            return nil, errors.New("invalid reuse sfm")
+        }
+    }
+
+    // Parse frame sampling, both are optional and left at 0 when not provided
+    for field, dst := range map[string]*int{"frame_sample_rate": &req.FrameSampleRate, "target_frame_count": &req.TargetFrameCount} {
+        value := c.FormValue(field)
+        if value == "" {
+            continue
+        }
+        parsed, err := strconv.Atoi(value)
+        if err != nil {
+            return nil, errors.New("invalid " + strings.ReplaceAll(field, "_", " "))
+        }
+        *dst = parsed
+    }
+
+    // Validate the request
+    if err := validate.Struct(req); err != nil {
+        return nil, err
+    }
 
-    // Parse total iterations
+    return &req, nil
+}
+
+// parseTrainingFormValues parses the total iterations, comma separated output types, and comma separated save
+// iterations form fields of an upload. Fields not provided are left empty.
+func parseTrainingFormValues(c *fiber.Ctx) (totalIterations int, outputTypes []string, saveIterations []int, err error) {
     totalIterationsStr := c.FormValue("total_iterations")
     if totalIterationsStr != "" {
-        totalIterations, err := strconv.Atoi(totalIterationsStr)
+        totalIterations, err = strconv.Atoi(totalIterationsStr)
         if err != nil {
-            return nil, errors.New("invalid total iterations")
+            return 0, nil, nil, errors.New("invalid total iterations")
         }
-        req.TotalIterations = totalIterations
     }
 
-    // Parse output types
     outputTypesStr := c.FormValue("output_types")
     if outputTypesStr != "" {
-        req.OutputTypes = strings.Split(outputTypesStr, ",")
+        outputTypes = strings.Split(outputTypesStr, ",")
     }
 
-    // Parse save iterations
     saveIterationsStr := c.FormValue("save_iterations")
     if saveIterationsStr != "" {
         saveIterationsSlice := strings.Split(saveIterationsStr, ",")
-        req.SaveIterations = make([]int, len(saveIterationsSlice))
+        saveIterations = make([]int, len(saveIterationsSlice))
         for i, s := range saveIterationsSlice {
             val, err := strconv.Atoi(strings.TrimSpace(s))
             if err != nil {
-                return nil, errors.New("invalid save iterations")
+                return 0, nil, nil, errors.New("invalid save iterations")
             }
-            req.SaveIterations[i] = val
+            saveIterations[i] = val
         }
     }
 
-    // Validate the request
-    if err := validate.Struct(req); err != nil {
-        return nil, err
-    }
+    return totalIterations, outputTypes, saveIterations, nil
+}
 
-    return &req, nil
+// presetRequest is a request whose training config values can be filled in from a named training preset.
+type presetRequest interface {
+    applyPreset() error
+}
+
+func (req *RetrainSceneRequest) applyPreset() error {
+    return applyTrainingPreset(req.Preset, &req.TrainingMode, &req.OutputTypes, &req.SaveIterations, &req.TotalIterations)
+}
+
+func (req *EstimateTrainingRequest) applyPreset() error {
+    return applyTrainingPreset(req.Preset, &req.TrainingMode, &req.OutputTypes, &req.SaveIterations, &req.TotalIterations)
+}
+
+func (req *StartUploadRequest) applyPreset() error {
+    return applyTrainingPreset(req.Preset, &req.TrainingMode, &req.OutputTypes, &req.SaveIterations, &req.TotalIterations)
+}
+
+// applyTrainingPreset fills in the training config values a request left unset from the named training preset. Nothing
+// is filled in if name is empty.
+//
+// Returns services.ErrUnknownTrainingPreset if there is no preset with the name.
+func applyTrainingPreset(name string, trainingMode *string, outputTypes *[]string, saveIterations *[]int, totalIterations *int) error {
+    if name == "" {
+        return nil
+    }
+    preset, err := services.LookupTrainingPreset(name)
+    if err != nil {
+        return err
+    }
+    preset.Apply(trainingMode, outputTypes, saveIterations, totalIterations)
+    return nil
 }
 
 // ValidateOutputType is a custom validator for output types in a VideoUploadRequest.
@@ -0,0 +1,79 @@
+// This file contains the writing of Server-Sent Events streams, as read by the browser EventSource API.
+//
+// EventSource cannot set request headers, so routes streaming events also accept the access token in the
+// `access_token` query parameter, see tokenFromQuery. A comment line is sent every sseHeartbeatInterval so proxies do
+// not close idle streams, and so a client that went away is noticed even while no events are sent.
+
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
+)
+
+// sseHeartbeatInterval is how often a comment line is sent on an idle stream.
+const sseHeartbeatInterval = 15 * time.Second
+
+// sseRetryMillis is how long clients wait before reconnecting to a stream that ended, in milliseconds.
+const sseRetryMillis = 5000
+
+// tokenFromQuery is a middleware that moves the `access_token` query parameter into the Authorization header, for
+// clients that cannot set headers. It must wrap tokenRequired. A header that is already set takes precedence.
+func (s *WebServer) tokenFromQuery(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token := c.Query("access_token"); token != "" && c.Get("Authorization") == "" {
+			c.Request().Header.Set("Authorization", "Bearer "+token)
+		}
+		return handler(c)
+	}
+}
+
+// sendEventStream streams events to the client as Server-Sent Events until the channel is closed or the client goes
+// away, after which cancel is called so the producer of the events stops.
+func (s *WebServer) sendEventStream(c *fiber.Ctx, events <-chan services.JobStatusEvent, cancel context.CancelFunc) error {
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-store")
+	c.Set("Connection", "keep-alive")
+	// Disables response buffering of nginx, which would hold events back
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		fmt.Fprintf(w, "retry: %d\n\n", sseRetryMillis)
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(sseHeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					s.logger.Errorf("Failed to encode status event: %v", err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+			// A failed flush means the client went away
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
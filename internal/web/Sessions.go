@@ -0,0 +1,113 @@
+// This file contains the handlers of the sessions of a user, see services/Sessions.go: listing the logins that can
+// still refresh their tokens, and logging out one or all of them.
+
+package web
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sessionIDLocal is the local holding the ID of the session of the request's access token, set by tokenRequired. It
+// is nil for access tokens issued before sessions were recorded in them.
+const sessionIDLocal = "sessionID"
+
+// currentSession returns the ID of the session of the request's access token, or nil if it has none.
+func currentSession(c *fiber.Ctx) primitive.ObjectID {
+	sessionID, _ := c.Locals(sessionIDLocal).(primitive.ObjectID)
+	return sessionID
+}
+
+// listSessions handles the request to list the active sessions of the user, most recently used first, marking the
+// session of the request as current. It is a JWT protected route.
+func (s *WebServer) listSessions(c *fiber.Ctx) error {
+	s.logger.Debug("List sessions request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sessions, err := s.clientService.ListSessions(c.UserContext(), userID, currentSession(c))
+	if err != nil {
+		s.logger.Debug("Failed to list sessions: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"sessions": sessions})
+}
+
+// revokeSession handles the request to log out a session of the user, so its refresh token can not be exchanged
+// anymore. It is a JWT protected route.
+//
+// It expects path parameter `session_id`.
+func (s *WebServer) revokeSession(c *fiber.Ctx) error {
+	s.logger.Debug("Revoke session request received")
+
+	var req RevokeSessionRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Revoke session request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(req.SessionID)
+	if err != nil {
+		s.logger.Debug("Invalid session ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid session ID"})
+	}
+
+	if err := s.clientService.RevokeSession(c.UserContext(), userID, sessionID); err != nil {
+		s.logger.Debug("Failed to revoke session: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Session revoked"})
+}
+
+// revokeAllSessions handles the request to log out every session of the user, i.e after a device was lost. It is a
+// JWT protected route.
+//
+// It accepts an optional JSON payload keeping the session of the request:
+//
+//	{
+//	    "keep_current": true
+//	}
+func (s *WebServer) revokeAllSessions(c *fiber.Ctx) error {
+	s.logger.Debug("Revoke all sessions request received")
+
+	var req RevokeAllSessionsRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Revoke all sessions request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var keep primitive.ObjectID
+	if req.KeepCurrent {
+		if keep = currentSession(c); keep.IsZero() {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "The access token has no session, log in again to keep it"})
+		}
+	}
+
+	revoked, err := s.clientService.RevokeAllSessions(c.UserContext(), userID, keep)
+	if err != nil {
+		s.logger.Debug("Failed to revoke sessions: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"revoked": revoked})
+}
@@ -0,0 +1,217 @@
+// This file contains the middleware checking requests against the OpenAPI specification, see OpenAPI.go.
+//
+// Query parameters and JSON bodies are checked against the schemas of the operation a request matches, before the
+// request is authorized. A property or parameter set to null, or empty, is treated as absent, and unknown ones are
+// ignored, so clients may send fields added in later versions. Requests matching no operation, and bodies that are
+// not JSON, are left to their handlers, which still validate the request struct with ValidateRequest.
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
+)
+
+// specPatterns are the compiled patterns of the specification.
+var specPatterns = map[string]*regexp.Regexp{
+	patternHexadecimal: regexp.MustCompile(patternHexadecimal),
+	patternNumeric:     regexp.MustCompile(patternNumeric),
+	patternAlphanum:    regexp.MustCompile(patternAlphanum),
+}
+
+// validateAgainstSpec is a middleware that responds 400 to requests whose query parameters or JSON body do not match
+// the specification, listing the problem of each field.
+func (s *WebServer) validateAgainstSpec(c *fiber.Ctx) error {
+	if s.openAPI == nil {
+		return c.Next()
+	}
+	op := s.openAPI.match(c.Method(), c.Path())
+	if op == nil {
+		return c.Next()
+	}
+
+	fields := make(map[string]string)
+	for _, param := range op.query {
+		value := c.Query(param.Name)
+		if value == "" {
+			if param.Required {
+				fields[param.Name] = "is required"
+			}
+			continue
+		}
+		if problem := checkQueryValue(param.Schema, value); problem != "" {
+			fields[param.Name] = problem
+		}
+	}
+
+	contentType := c.Get(fiber.HeaderContentType)
+	if op.body != nil && (contentType == "" || strings.HasPrefix(contentType, fiber.MIMEApplicationJSON)) {
+		checkJSONBody(op.body, c.Body(), fields)
+	}
+
+	if len(fields) > 0 {
+		return s.sendError(c, services.NewValidationError("request does not match the API specification", fields, nil))
+	}
+	return c.Next()
+}
+
+// checkJSONBody checks a JSON body against the schema of an object, adding the problem of each property to fields.
+// An empty body is checked as an empty object.
+func checkJSONBody(schema *openAPISchema, body []byte, fields map[string]string) {
+	var object map[string]any
+	if len(bytes.TrimSpace(body)) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			fields["body"] = "must be valid JSON"
+			return
+		}
+		var ok bool
+		if object, ok = value.(map[string]any); !ok {
+			fields["body"] = "must be a JSON object"
+			return
+		}
+	}
+
+	for _, name := range schema.Required {
+		if object[name] == nil {
+			fields[name] = "is required"
+		}
+	}
+	for name, value := range object {
+		property, ok := schema.Properties[name]
+		if !ok || value == nil {
+			continue
+		}
+		if problem := checkValue(property, value); problem != "" {
+			fields[name] = problem
+		}
+	}
+}
+
+// checkQueryValue checks the value of a query parameter against its schema, returning its problem, empty if there is
+// none.
+func checkQueryValue(schema *openAPISchema, value string) string {
+	switch schema.Type {
+	case "integer", "number":
+		return checkValue(schema, json.Number(value))
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "must be a boolean"
+		}
+		return ""
+	default:
+		return checkValue(schema, value)
+	}
+}
+
+// checkValue checks a decoded JSON value against its schema, returning its problem, empty if there is none.
+func checkValue(schema *openAPISchema, value any) string {
+	switch schema.Type {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return "must be a string"
+		}
+		return checkString(schema, s)
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return "must be an integer"
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return "must be an integer"
+		}
+		return checkNumber(schema, float64(i))
+	case "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			return "must be a number"
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return "must be a number"
+		}
+		return checkNumber(schema, f)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return "must be a boolean"
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return "must be an array"
+		}
+		if schema.MinItems != nil && len(items) < *schema.MinItems {
+			return fmt.Sprintf("must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(items) > *schema.MaxItems {
+			return fmt.Sprintf("must have at most %d items", *schema.MaxItems)
+		}
+		if schema.Items == nil {
+			return ""
+		}
+		for i, item := range items {
+			if problem := checkValue(schema.Items, item); problem != "" {
+				return fmt.Sprintf("item %d %s", i, problem)
+			}
+		}
+	case "object":
+		if _, ok := value.(map[string]any); !ok {
+			return "must be an object"
+		}
+	}
+	return ""
+}
+
+// checkString checks a string against the constraints of its schema.
+func checkString(schema *openAPISchema, s string) string {
+	if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, s) {
+		return "must be one of " + strings.Join(schema.Enum, ", ")
+	}
+	length := utf8.RuneCountInString(s)
+	if schema.MinLength != nil && length < *schema.MinLength {
+		return fmt.Sprintf("must be at least %d characters long", *schema.MinLength)
+	}
+	if schema.MaxLength != nil && length > *schema.MaxLength {
+		return fmt.Sprintf("must be at most %d characters long", *schema.MaxLength)
+	}
+	if pattern, ok := specPatterns[schema.Pattern]; ok && !pattern.MatchString(s) {
+		return "must match " + schema.Pattern
+	}
+	if schema.Format == "uri" {
+		if u, err := url.Parse(s); err != nil || u.Scheme == "" {
+			return "must be an absolute URI"
+		}
+	}
+	return ""
+}
+
+// checkNumber checks a number against the bounds of its schema.
+func checkNumber(schema *openAPISchema, n float64) string {
+	if min := schema.Minimum; min != nil {
+		if schema.ExclusiveMinimum && n <= *min {
+			return "must be greater than " + strconv.FormatFloat(*min, 'f', -1, 64)
+		}
+		if n < *min {
+			return "must be at least " + strconv.FormatFloat(*min, 'f', -1, 64)
+		}
+	}
+	if max := schema.Maximum; max != nil && n > *max {
+		return "must be at most " + strconv.FormatFloat(*max, 'f', -1, 64)
+	}
+	return ""
+}
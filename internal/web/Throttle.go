@@ -0,0 +1,291 @@
+// This file contains the bandwidth throttling of output downloads, so large downloads cannot saturate the uplink of
+// the server and starve uploads.
+//
+// Two limits apply, each disabled unless configured: every download is sent at most ConnectionBytesPerSecond, and all
+// downloads of a user together at most UserBytesPerSecond. Downloads through share links, which have no user, share
+// the limit of their client address. Both are token buckets of bytes, holding up to a second of their rate, and a
+// throttled body is sent as it is read through them rather than buffered.
+//
+// Clients in ExemptNetworks, i.e internal workers, are never throttled, nor are the worker routes. The limits are
+// changed at runtime by admins, and apply to the downloads started after the change.
+
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/audit"
+)
+
+// throttleReadSize is the most bytes a throttled body reads at once, so waits are spread over a download rather
+// than taken in large steps.
+const throttleReadSize = 32 * 1024
+
+// throttledDownloadLocal is the local holding the throttledDownload of an output response, see sendOutput.
+const throttledDownloadLocal = "throttledDownload"
+
+// ThrottleConfig configures the bandwidth throttling of output downloads.
+type ThrottleConfig struct {
+	// bytes per second each download may be sent at, <= 0 does not limit
+	ConnectionBytesPerSecond int64 `json:"connection_bytes_per_second"`
+	// bytes per second all downloads of a user together may be sent at, <= 0 does not limit
+	UserBytesPerSecond int64 `json:"user_bytes_per_second"`
+	// networks in CIDR notation whose clients are never throttled, i.e "10.0.0.0/8"
+	ExemptNetworks []string `json:"exempt_networks"`
+}
+
+// bandwidthBucket is a token bucket of bytes.
+type bandwidthBucket struct {
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+	tokens  float64
+	updated time.Time
+}
+
+// newBandwidthBucket creates a full bucket refilled at bytesPerSecond, holding up to a second of it.
+func newBandwidthBucket(bytesPerSecond int64) *bandwidthBucket {
+	rate := float64(bytesPerSecond)
+	return &bandwidthBucket{rate: rate, burst: rate, tokens: rate, updated: time.Now()}
+}
+
+// take takes n bytes from the bucket, and returns how long to wait before sending them. The bucket goes into debt
+// rather than refusing, so the readers sharing a bucket are slowed down in turn.
+func (b *bandwidthBucket) take(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+	b.updated = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// userBandwidth is the bucket shared by the downloads in progress of a user.
+type userBandwidth struct {
+	bucket    *bandwidthBucket
+	downloads int
+}
+
+// bandwidthThrottle holds the config of the throttling, and the buckets of the users downloading.
+type bandwidthThrottle struct {
+	config ThrottleConfig
+	exempt []*net.IPNet
+	mu     sync.Mutex
+	users  map[string]*userBandwidth
+}
+
+// newBandwidthThrottle creates a bandwidthThrottle for config, or returns nil if config does not limit downloads.
+// Returns an error if an exempt network is not in CIDR notation.
+func newBandwidthThrottle(config ThrottleConfig) (*bandwidthThrottle, error) {
+	exempt := make([]*net.IPNet, 0, len(config.ExemptNetworks))
+	for _, cidr := range config.ExemptNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exempt network %q: %w", cidr, err)
+		}
+		exempt = append(exempt, network)
+	}
+	if config.ConnectionBytesPerSecond <= 0 && config.UserBytesPerSecond <= 0 {
+		return nil, nil
+	}
+	return &bandwidthThrottle{config: config, exempt: exempt, users: make(map[string]*userBandwidth)}, nil
+}
+
+// exempts reports whether the client at ip is never throttled.
+func (t *bandwidthThrottle) exempts(ip net.IP) bool {
+	for _, network := range t.exempt {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire returns the buckets a new download of the user with the given key is sent through, and the function to
+// call once it is done.
+func (t *bandwidthThrottle) acquire(key string) ([]*bandwidthBucket, func()) {
+	buckets := make([]*bandwidthBucket, 0, 2)
+	if t.config.ConnectionBytesPerSecond > 0 {
+		buckets = append(buckets, newBandwidthBucket(t.config.ConnectionBytesPerSecond))
+	}
+	if t.config.UserBytesPerSecond <= 0 {
+		return buckets, func() {}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	user, ok := t.users[key]
+	if !ok {
+		user = &userBandwidth{bucket: newBandwidthBucket(t.config.UserBytesPerSecond)}
+		t.users[key] = user
+	}
+	user.downloads++
+	release := sync.OnceFunc(func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		// The bucket of a user is dropped with their last download, as the next one would find it refilled anyway
+		if user.downloads--; user.downloads == 0 {
+			delete(t.users, key)
+		}
+	})
+	return append(buckets, user.bucket), release
+}
+
+// downloads returns the number of users downloading through the throttle.
+func (t *bandwidthThrottle) downloads() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.users)
+}
+
+// throttledDownload is the throttle of an output response. sendFileSection streams the body through it, rather than
+// buffering it, when it is set in the throttledDownloadLocal local.
+type throttledDownload struct {
+	buckets []*bandwidthBucket
+	release func()
+	// called with the bytes sent once the body was sent, or failed to be
+	sent func(int64)
+	// whether the body is streamed, and sent is called, see sendOutput
+	streamed bool
+}
+
+// reader returns the body of n bytes of file from offset, read through the buckets of the download. Closing it
+// closes file.
+func (d *throttledDownload) reader(file *os.File, offset, n int64) io.ReadCloser {
+	d.streamed = true
+	return &throttledReader{r: io.NewSectionReader(file, offset, n), file: file, download: d}
+}
+
+// throttledReader reads a file section through the buckets of a throttledDownload.
+type throttledReader struct {
+	r        io.Reader
+	file     *os.File
+	download *throttledDownload
+	n        int64
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleReadSize {
+		p = p[:throttleReadSize]
+	}
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		tr.n += int64(n)
+		now := time.Now()
+		var wait time.Duration
+		for _, bucket := range tr.download.buckets {
+			wait = max(wait, bucket.take(n, now))
+		}
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+func (tr *throttledReader) Close() error {
+	err := tr.file.Close()
+	tr.download.release()
+	tr.download.sent(tr.n)
+	return err
+}
+
+// SetDownloadThrottle changes the bandwidth throttling of output downloads. A zero value does not throttle them.
+// Returns an error, leaving the throttling unchanged, if an exempt network is not in CIDR notation.
+func (s *WebServer) SetDownloadThrottle(config ThrottleConfig) error {
+	throttle, err := newBandwidthThrottle(config)
+	if err != nil {
+		return err
+	}
+	s.throttle.Store(throttle)
+	return nil
+}
+
+// throttleDownload returns the throttle of an output response of the user with the given ID, or of the client
+// address if userID is nil. Returns nil if downloads are not throttled, or the client is exempt.
+//
+// The bytes sent through the returned throttle are counted as downloads of outputType once the body was sent.
+func (s *WebServer) throttleDownload(c *fiber.Ctx, userID primitive.ObjectID, outputType string) *throttledDownload {
+	throttle := s.throttle.Load()
+	if throttle == nil || throttle.exempts(net.ParseIP(c.IP())) {
+		return nil
+	}
+	key := "ip:" + c.IP()
+	if !userID.IsZero() {
+		key = userID.Hex()
+	}
+	buckets, release := throttle.acquire(key)
+	return &throttledDownload{
+		buckets: buckets,
+		release: release,
+		sent: func(n int64) {
+			s.metrics.DownloadBytesTotal.WithLabelValues(outputType).Add(float64(n))
+			if !userID.IsZero() {
+				s.clientService.RecordDownload(context.Background(), userID, n)
+			}
+		},
+	}
+}
+
+// adminGetThrottle handles the request to get the bandwidth throttling of output downloads, and the number of users
+// downloading through it. It is an admin only route.
+func (s *WebServer) adminGetThrottle(c *fiber.Ctx) error {
+	s.logger.Debug("Admin get throttle request received")
+
+	config, downloading := ThrottleConfig{ExemptNetworks: make([]string, 0)}, 0
+	if throttle := s.throttle.Load(); throttle != nil {
+		config, downloading = throttle.config, throttle.downloads()
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"throttle": config, "users_downloading": downloading})
+}
+
+// adminSetThrottle handles the request to change the bandwidth throttling of output downloads, without a restart.
+// It is an admin only route.
+//
+// It expects a JSON body:
+//
+//	{
+//	    "connection_bytes_per_second": 10485760 (0 does not limit downloads),
+//	    "user_bytes_per_second": 20971520 (0 does not limit users),
+//	    "exempt_networks": ["10.0.0.0/8"] (optional)
+//	}
+func (s *WebServer) adminSetThrottle(c *fiber.Ctx) error {
+	s.logger.Debug("Admin set throttle request received")
+
+	var req AdminSetThrottleRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Admin set throttle request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	config := ThrottleConfig{
+		ConnectionBytesPerSecond: *req.ConnectionBytesPerSecond,
+		UserBytesPerSecond:       *req.UserBytesPerSecond,
+		ExemptNetworks:           req.ExemptNetworks,
+	}
+	if config.ExemptNetworks == nil {
+		config.ExemptNetworks = make([]string, 0)
+	}
+	if err := s.SetDownloadThrottle(config); err != nil {
+		s.logger.Debug("Failed to set throttle: ", err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	s.logger.Infof("Download throttle set to %d bytes/s per download, %d bytes/s per user, exempting %v",
+		config.ConnectionBytesPerSecond, config.UserBytesPerSecond, config.ExemptNetworks)
+	if userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string)); err == nil {
+		s.clientService.RecordAdminAction(c.UserContext(), userID, audit.ActionAdminSetThrottle, fmt.Sprintf(
+			"%d bytes/s per download, %d bytes/s per user", config.ConnectionBytesPerSecond, config.UserBytesPerSecond))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"throttle": config})
+}
@@ -0,0 +1,61 @@
+// This file contains the tracing of HTTP requests.
+//
+// Every request gets a server span, continuing the trace of the client's traceparent header if it sent one. The span
+// is carried by the request's user context, which handlers pass to the services, so the spans of the services, the
+// MongoDB commands, and the jobs published for the request are its children.
+
+package web
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
+)
+
+// untracedPaths are the paths polled by infrastructure, whose requests are not traced.
+var untracedPaths = map[string]bool{
+	"/health":  true,
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// traceRequests is the middleware starting the server span of a request.
+// The traceparent of the span is sent back in the response, so clients can look up the trace of a request.
+func (s *WebServer) traceRequests(c *fiber.Ctx) error {
+	if untracedPaths[c.Path()] {
+		return c.Next()
+	}
+
+	ctx := tracing.ContextWithTraceparent(c.UserContext(), c.Get(tracing.TraceparentHeader))
+	ctx, span := tracing.Start(ctx, c.Method(), tracing.KindServer)
+	if span == nil {
+		return c.Next()
+	}
+	traceID := span.SpanContext().TraceID
+	c.SetUserContext(log.WithFields(ctx, log.FieldTraceID, hex.EncodeToString(traceID[:])))
+	c.Set(tracing.TraceparentHeader, span.SpanContext().Traceparent())
+
+	err := c.Next()
+
+	// The route is only known once the request was routed
+	route := c.Route().Path
+	span.SetName(c.Method() + " " + route)
+	span.SetAttribute("http.method", c.Method())
+	span.SetAttribute("http.route", route)
+	status := c.Response().StatusCode()
+	span.SetAttribute("http.status_code", status)
+	if err != nil {
+		span.RecordError(err)
+	} else if status >= http.StatusInternalServerError {
+		span.RecordError(fmt.Errorf("responded with status %d", status))
+	}
+	span.End()
+
+	return err
+}
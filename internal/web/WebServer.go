@@ -8,34 +8,58 @@
 package web
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/golang-jwt/jwt"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
 	"github.com/NeRF-or-Nothing/go-web-server/internal/log"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/metrics"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/scene"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/user"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/models/worker"
+	"github.com/NeRF-or-Nothing/go-web-server/internal/tracing"
 )
 
 type WebServer struct {
-	jwtSecret     string
 	app           *fiber.App
 	clientService *services.ClientService
+	workerService *services.WorkerService
+	healthService *services.HealthService
+	idempotency   *services.Idempotency
+	metrics       *metrics.Metrics
 	logger        *log.Logger
+	// per-user rate limiting of authenticated requests, nil if not limited, see SetRateLimit
+	limiter atomic.Pointer[rateLimiter]
+	// bandwidth throttling of output downloads, nil if not throttled, see SetDownloadThrottle
+	throttle atomic.Pointer[bandwidthThrottle]
+	// specification of the API, built once the routes are set up
+	openAPI *openAPISpec
 }
 
-// NewWebServer creates a new WebServer instance.
-func NewWebServer(jwtSecret string, clientService *services.ClientService, logger *log.Logger) *WebServer {
+// NewWebServer creates a new WebServer instance. The registry of the given metrics is served on /metrics.
+// allowedOrigins is the comma separated list of origins allowed to make cross-origin requests, "*" allows any origin.
+// workerService serves the worker routes, see workerKeyRequired.
+// healthService checks the dependencies of the server for the liveness and readiness probes.
+// idempotency stores the responses of mutating routes by idempotency key, see Idempotency.go. nil ignores the keys.
+// rateLimit limits the requests of each authenticated user, see RateLimit.go. A zero value does not limit them.
+func NewWebServer(allowedOrigins string, clientService *services.ClientService, workerService *services.WorkerService, healthService *services.HealthService, idempotency *services.Idempotency, rateLimit RateLimitConfig, appMetrics *metrics.Metrics, logger *log.Logger) *WebServer {
 	logger.Debug("Creating new web server instance")
 
 	app := fiber.New(fiber.Config{
@@ -43,16 +67,24 @@ func NewWebServer(jwtSecret string, clientService *services.ClientService, logge
 		StreamRequestBody: true,     // Stream request body to disk
 	})
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowHeaders: "Authorization, Content-Type",
+		AllowOrigins: allowedOrigins,
+		AllowHeaders: "Authorization, Content-Type, " + uploadTokenHeader + ", " + apiKeyHeader + ", " + uploadOffsetHeader + ", " + idempotencyKeyHeader + ", " + tracing.TraceparentHeader + ", " + requestIDHeader,
+		// Lets cross-origin clients read the file name of downloaded outputs, the trace and ID of their requests, and
+		// whether a response was replayed
+		ExposeHeaders: "Content-Disposition, " + tracing.TraceparentHeader + ", " + requestIDHeader + ", " + idempotentReplayedHeader,
 	}))
 
-	return &WebServer{
-		jwtSecret:     jwtSecret,
+	s := &WebServer{
 		app:           app,
 		clientService: clientService,
+		workerService: workerService,
+		healthService: healthService,
+		idempotency:   idempotency,
+		metrics:       appMetrics,
 		logger:        logger,
 	}
+	s.SetRateLimit(rateLimit)
+	return s
 }
 
 // Run starts the web server on the given IP and port.
@@ -62,42 +94,190 @@ func (s *WebServer) Run(ip string, port int) error {
 	return s.app.Listen(ip + ":" + strconv.Itoa(port))
 }
 
+// Shutdown stops accepting connections, and waits up to timeout for in-flight requests to complete before closing
+// the remaining connections. Run returns once Shutdown was called.
+func (s *WebServer) Shutdown(timeout time.Duration) error {
+	return s.app.ShutdownWithTimeout(timeout)
+}
+
 // SetupRoutes sets up the routes for the web server.
 func (s *WebServer) SetupRoutes() {
+	// Registered before the routes, so they run for each of them
+	s.app.Use(s.logRequests)
+	s.app.Use(s.clientIP)
+	s.app.Use(s.traceRequests)
+	s.app.Use(s.validateAgainstSpec)
+
 	// External Account Routes
 	s.app.Post("/user/account/login", s.loginUser)
+	s.app.Post("/user/account/token/refresh", s.refreshTokens)
+	s.app.Post("/user/account/logout", s.logoutUser)
+	s.app.Get("/user/account/oauth", s.listOAuthProviders)
+	s.app.Get("/user/account/oauth/:provider/authorize", s.startOAuthLogin)
+	s.app.Post("/user/account/oauth/:provider/login", s.oauthLogin)
 	s.app.Post("/user/account/register", s.registerUser)
+	s.app.Post("/user/account/verify", s.verifyEmail)
+	s.app.Post("/user/account/verify/resend", s.resendVerification)
 	s.app.Patch("/user/account/update/username", s.tokenRequired(s.updateUserUsername))
 	s.app.Patch("/user/account/update/password", s.tokenRequired(s.updateUserPassword))
 	s.app.Delete("/user/account/delete", s.tokenRequired(s.deleteUser))
+	s.app.Get("/user/account/notifications", s.tokenRequired(s.getNotificationPreferences))
+	s.app.Put("/user/account/notifications", s.tokenRequired(s.updateNotificationPreferences))
+	s.app.Get("/user/account/api-keys", s.tokenRequired(s.listAPIKeys))
+	s.app.Post("/user/account/api-keys", s.tokenRequired(s.idempotent(s.createAPIKey)))
+	s.app.Delete("/user/account/api-keys/:key_id", s.tokenRequired(s.revokeAPIKey))
+	s.app.Get("/user/account/sessions", s.tokenRequired(s.listSessions))
+	s.app.Delete("/user/account/sessions", s.tokenRequired(s.revokeAllSessions))
+	s.app.Delete("/user/account/sessions/:session_id", s.tokenRequired(s.revokeSession))
+	s.app.Get("/user/account/identities", s.tokenRequired(s.listIdentities))
+	s.app.Post("/user/account/identities/:provider/authorize", s.tokenRequired(s.startOAuthLink))
+	s.app.Post("/user/account/identities/:provider", s.tokenRequired(s.linkIdentity))
+	s.app.Delete("/user/account/identities/:provider", s.tokenRequired(s.unlinkIdentity))
+	s.app.Get("/user/quota", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getQuota))
+	s.app.Get("/user/activity", s.tokenRequired(s.getMyActivity))
+	s.app.Get("/user/webhooks", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.listWebhooks))
+	s.app.Post("/user/webhooks", s.tokenRequired(s.idempotent(s.createWebhook)))
+	s.app.Delete("/user/webhooks/:webhook_id", s.tokenRequired(s.deleteWebhook))
+	s.app.Get("/user/webhooks/:webhook_id/deliveries", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getWebhookDeliveries))
 
 	// External Scene Routes
 	s.app.Delete("/user/scene/delete/:scene_id", s.tokenRequired(s.deleteUserScene))
-	s.app.Post("/user/scene/new", s.tokenRequired(s.postNewScene))
-	s.app.Get("/user/scene/metadata/:scene_id", s.tokenRequired(s.getSceneMetadata))
-	s.app.Get("/user/scene/thumbnail/:scene_id", s.tokenRequired(s.getSceneThumbnail))
-	s.app.Get("/user/scene/name/:scene_id", s.tokenRequired(s.getSceneName))
-	s.app.Get("/user/scene/progress/:scene_id", s.tokenRequired(s.getSceneProgress))
-	s.app.Get("/user/scene/history", s.tokenRequired(s.getUserSceneHistory))
-	s.app.Get("/user/scene/output/:output_type/:scene_id", s.tokenRequired(s.getSceneOutput))
+	s.app.Post("/user/scene/cleanup", s.tokenRequired(s.deleteOldScenes))
+	s.app.Get("/user/scene/trash", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getTrash))
+	s.app.Post("/user/scene/restore/:scene_id", s.tokenRequired(s.restoreScene))
+	s.app.Post("/user/scene/new", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.idempotent(s.postNewScene)))
+	s.app.Post("/user/scene/new/bundle", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.idempotent(s.postNewSceneBundle)))
+	s.app.Post("/user/scene/new/batch", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.idempotent(s.postNewSceneBatch)))
+	s.app.Get("/user/scene/batch/:batch_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getBatch))
+	s.app.Post("/user/upload/token", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.postUploadToken))
+	s.app.Post("/user/scene/upload", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.idempotent(s.startUpload)))
+	s.app.Get("/user/scene/upload/:upload_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.getUpload))
+	s.app.Patch("/user/scene/upload/:upload_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.appendUpload))
+	s.app.Post("/user/scene/upload/:upload_id/complete", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.idempotent(s.completeUpload)))
+	s.app.Delete("/user/scene/upload/:upload_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.abortUpload))
+
+	// Direct Upload Routes, authorized by an upload token instead of a session
+	s.app.Post("/upload/scene/new", s.uploadTokenRequired(s.idempotent(s.postNewScene)))
+	s.app.Post("/user/scene/cancel/:scene_id", s.tokenRequired(s.cancelJob))
+	s.app.Post("/user/scene/retry/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.idempotent(s.retryJob)))
+	s.app.Post("/user/scene/retrain/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.idempotent(s.retrainScene)))
+	s.app.Post("/user/scene/estimate", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.estimateTraining))
+	s.app.Get("/user/scene/presets", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getTrainingPresets))
+	s.app.Post("/user/scene/export/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.idempotent(s.exportScene)))
+	s.app.Post("/user/scene/render/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeUpload, s.idempotent(s.renderScene)))
+	s.app.Get("/user/scene/render/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getRenders))
+	s.app.Get("/user/scene/render/:scene_id/:render_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getRender))
+	s.app.Get("/user/scene/render/:scene_id/:render_id/video", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getRenderVideo))
+	s.app.Get("/user/scene/metadata/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getSceneMetadata))
+	s.app.Post("/user/scene/metadata/batch", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getBatchSceneMetadata))
+	s.app.Get("/user/scene/thumbnail/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getSceneThumbnail))
+	s.app.Get("/user/scene/preview/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getScenePreview))
+	s.app.Get("/user/scene/compare/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.compareIterations))
+	s.app.Get("/user/scene/compare/:scene_id/frame", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getComparisonFrame))
+	s.app.Get("/user/scene/name/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getSceneName))
+	s.app.Get("/user/scene/progress/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getSceneProgress))
+	s.app.Get("/user/scene/status/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getSceneStatus))
+	s.app.Get("/user/scene/status/:scene_id/progress", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getJobProgress))
+	s.app.Get("/user/scene/status/:scene_id/stream", s.tokenFromQuery(s.tokenRequired(s.streamSceneStatus)))
+	s.app.Get("/user/scene/preview/:scene_id/stream", s.tokenFromQuery(s.tokenRequired(s.streamScenePreview)))
+	s.app.Get("/user/scene/error/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getJobError))
+	s.app.Get("/user/scene/details/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getScene))
+	s.app.Patch("/user/scene/details/:scene_id", s.tokenRequired(s.updateSceneMetadata))
+	s.app.Get("/user/scene/history", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getUserSceneHistory))
+	s.app.Get("/user/scene/list", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getUserHistory))
+	s.app.Get("/user/scene/search", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.searchScenes))
+	s.app.Get("/user/scene/audit/:scene_id", s.tokenRequired(s.getSceneAuditLog))
+	s.app.Get("/user/scene/share/:scene_id", s.tokenRequired(s.getSceneCollaborators))
+	s.app.Post("/user/scene/share/:scene_id", s.tokenRequired(s.shareScene))
+	s.app.Delete("/user/scene/share/:scene_id", s.tokenRequired(s.unshareScene))
+	s.app.Get("/user/scene/share-link/:scene_id", s.tokenRequired(s.getShareLinks))
+	s.app.Post("/user/scene/share-link/:scene_id", s.tokenRequired(s.idempotent(s.createShareLink)))
+	s.app.Delete("/user/scene/share-link/:scene_id/:link_id", s.tokenRequired(s.revokeShareLink))
+	s.app.Post("/user/org", s.tokenRequired(s.idempotent(s.createOrg)))
+	s.app.Get("/user/org", s.tokenRequired(s.listOrgs))
+	s.app.Get("/user/org/:org_id", s.tokenRequired(s.getOrg))
+	s.app.Delete("/user/org/:org_id", s.tokenRequired(s.deleteOrg))
+	s.app.Post("/user/org/:org_id/invitations", s.tokenRequired(s.inviteOrgMember))
+	s.app.Delete("/user/org/:org_id/invitations/:user_id", s.tokenRequired(s.removeOrgInvitation))
+	s.app.Post("/user/org/:org_id/join", s.tokenRequired(s.joinOrg))
+	s.app.Put("/user/org/:org_id/members/:user_id", s.tokenRequired(s.setOrgMemberRole))
+	s.app.Delete("/user/org/:org_id/members/:user_id", s.tokenRequired(s.removeOrgMember))
+	s.app.Get("/user/org/:org_id/scenes", s.tokenRequired(s.listOrgScenes))
+	s.app.Get("/user/scene/output/:output_type/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getSceneOutput))
+	s.app.Get("/user/scene/output-url/:output_type/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getResourceURL))
+	s.app.Get("/user/scene/output-manifest/:output_type/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getResourceManifest))
+	s.app.Get("/user/scene/archive/:scene_id", s.tokenOrAPIKeyRequired(user.APIKeyScopeRead, s.getSceneArchive))
+
+	// Signed resource routes, authorized by the URL signature instead of a session
+	s.app.Get(services.ResourceURLPrefix+"/:scene_id/:output_type/:iteration", s.getSignedResource)
+
+	// Share link routes, authorized by the share link token instead of a session
+	s.app.Get("/shared/:token/metadata", s.getSharedSceneMetadata)
+	s.app.Get("/shared/:token/output/:output_type", s.getSharedSceneOutput)
+
+	// Admin routes
+	s.app.Post("/admin/quota/reconcile", s.adminRequired(s.reconcileQuotas))
+	s.app.Post("/admin/storage/reconcile", s.adminRequired(s.reconcileStorage))
+	s.app.Get("/admin/retention", s.adminRequired(s.adminPreviewRetention))
+	s.app.Get("/admin/stats/processing-time", s.adminRequired(s.getProcessingTimeStats))
+	s.app.Get("/admin/scenes", s.adminRequired(s.adminListScenes))
+	s.app.Post("/admin/scene/requeue/:scene_id", s.adminRequired(s.adminRequeueJob))
+	s.app.Post("/admin/scene/bump/:scene_id", s.adminRequired(s.adminBumpJob))
+	s.app.Post("/admin/scene/cancel/:scene_id", s.adminRequired(s.adminCancelJob))
+	s.app.Delete("/admin/scene/delete/:scene_id", s.adminRequired(s.adminDeleteScene))
+	s.app.Get("/admin/admission", s.adminRequired(s.adminGetAdmission))
+	s.app.Put("/admin/admission", s.adminRequired(s.adminSetAdmission))
+	s.app.Get("/admin/throttle", s.adminRequired(s.adminGetThrottle))
+	s.app.Put("/admin/throttle", s.adminRequired(s.adminSetThrottle))
+	s.app.Get("/admin/queues", s.adminRequired(s.adminGetQueues))
+	s.app.Get("/admin/cluster", s.adminRequired(s.adminGetClusterStatus))
+	s.app.Get("/admin/users", s.adminRequired(s.adminListUsers))
+	s.app.Get("/admin/audit", s.adminRequired(s.adminGetAuditLog))
+	s.app.Post("/admin/user/disable/:user_id", s.adminRequired(s.adminDisableUser))
+	s.app.Post("/admin/user/enable/:user_id", s.adminRequired(s.adminEnableUser))
+	s.app.Put("/admin/user/quota/:user_id", s.adminRequired(s.adminSetUserQuota))
+	s.app.Put("/admin/user/tier/:user_id", s.adminRequired(s.adminSetUserTier))
 
 	// Internal routes
 	s.app.Get("/worker-data/*", s.getWorkerData)
 
+	// Worker routes, authorized by the worker key instead of a session
+	s.app.Get("/worker/output/:job_id/:output_type/:iteration/:file_name", s.workerKeyRequired(s.getOutputUpload))
+	s.app.Patch("/worker/output/:job_id/:output_type/:iteration/:file_name", s.workerKeyRequired(s.appendOutput))
+	s.app.Put("/worker/registry/:worker_id", s.workerKeyRequired(s.registerWorker))
+	s.app.Post("/worker/registry/:worker_id/heartbeat", s.workerKeyRequired(s.sendWorkerHeartbeat))
+	s.app.Delete("/worker/registry/:worker_id", s.workerKeyRequired(s.deregisterWorker))
+
 	// Debug routes
 	s.app.Get("/routes", s.getRoutes)
 	s.app.Get("/health", s.healthCheck)
+	s.app.Get("/healthz", s.getLiveness)
+	s.app.Get("/readyz", s.getReadiness)
+	s.app.Get("/metrics", s.getMetrics)
+
+	// Documentation routes
+	s.app.Get("/openapi.json", s.getOpenAPISpec)
+	s.app.Get("/docs", s.getDocs)
+	s.app.Get("/docs/:file", s.getDocsAsset)
+
+	s.openAPI = newOpenAPISpec(s.app.GetRoutes(true))
 }
 
-// SetupFileStructure creates the necessary directories for storing data files.
+// SetupFileStructure creates the necessary directories for storing data files, under the configured data directory.
 // Due to docker volume mapping, this should be mostly redundant, but it is included for completeness.
 func (s *WebServer) SetupFileStructure() {
-	dataDir := "/data"
+	dataDir := scene.DataDir()
+	scenesDir := filepath.Join(dataDir, "scenes")
 	sfmDir := filepath.Join(dataDir, "sfm")
 	nerfDir := filepath.Join(dataDir, "nerf")
 	rawDir := filepath.Join(dataDir, "raw")
 
-	err := os.MkdirAll(sfmDir, os.ModePerm)
+	err := os.MkdirAll(scenesDir, os.ModePerm)
+	if err != nil {
+		s.logger.Info("Failed to create scenes directory:", err.Error())
+	}
+
+	err = os.MkdirAll(sfmDir, os.ModePerm)
 	if err != nil {
 		s.logger.Info("Failed to create sfm directory:", err.Error())
 	}
@@ -113,15 +293,15 @@ func (s *WebServer) SetupFileStructure() {
 	}
 }
 
-// tokenRequired is a middleware that checks for a valid JWT token in the Authorization header.
+// tokenRequired is a middleware that checks for a valid access token in the Authorization header.
 //
-// The token is expected to be in the format: `Bearer <token>`.   
-// A valid token will decode to a user ID (of type String(primitive.ObjectID)).
-// It is expected that the user ID is stored in the token's `sub` claim. 
+// The token is expected to be in the format: `Bearer <token>`, as issued by loginUser and refreshTokens.
+// Expired tokens are rejected, and should be replaced by exchanging the refresh token.
+// Tokens of accounts that were disabled or deleted, and of sessions that were logged out or revoked, since they were
+// issued are rejected, see ClientService.VerifySession.
+// Requests are rate limited per user once authenticated, see limitRate.
 //
-// Validation of the user's existence is not performed here.
-// and instead the user ID is stored in the fiber context for use in request handlers,
-// which is then validated by ClientService.
+// The user ID is stored in the fiber context for use in request handlers.
 func (s *WebServer) tokenRequired(handler fiber.Handler) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
@@ -130,36 +310,121 @@ func (s *WebServer) tokenRequired(handler fiber.Handler) fiber.Handler {
 			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Missing Authorization header"})
 		}
 
-		s.logger.Debugf("\nAuthorization header: %s", authHeader)
 		parts := strings.Split(authHeader, " ")
-
 		if len(parts) != 2 || parts[0] != "Bearer" {
 			s.logger.Debug("Invalid Authorization header format. Expected: `Bearer <token>`")
 			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid Authorization header format. Expected: `Bearer <token>`"})
 		}
 
-		tokenString := parts[1]
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(s.jwtSecret), nil
-		})
+		userID, sessionID, err := s.clientService.VerifyAccessTokenSession(parts[1])
+		if err != nil {
+			s.logger.Debug("Invalid token: ", err.Error())
+			return s.sendError(c, err)
+		}
+		if err := s.clientService.VerifySession(c.UserContext(), userID, sessionID); err != nil {
+			s.logger.Debug("Session verification failed: ", err.Error())
+			return s.sendError(c, err)
+		}
+
+		s.setUser(c, userID.Hex())
+		c.Locals(sessionIDLocal, sessionID)
+		return s.limitRate(c, userID.Hex(), handler)
+	}
+}
+
+// apiKeyHeader is the header carrying the API key of scripted requests.
+const apiKeyHeader = "X-API-Key"
+
+// tokenOrAPIKeyRequired is a middleware function that authorizes a request with an API key of the given scope in the
+// X-API-Key header, or otherwise with an access token like tokenRequired. Requests made with an API key are
+// restricted to the key's scopes, while access tokens may be used for every scope.
+func (s *WebServer) tokenOrAPIKeyRequired(scope string, handler fiber.Handler) fiber.Handler {
+	withToken := s.tokenRequired(handler)
+	return func(c *fiber.Ctx) error {
+		key := c.Get(apiKeyHeader)
+		if key == "" {
+			return withToken(c)
+		}
+
+		userID, err := s.clientService.VerifyAPIKey(c.UserContext(), key, scope)
+		if err != nil {
+			s.logger.Debug("API key verification failed: ", err.Error())
+			return s.sendError(c, err)
+		}
+
+		s.setUser(c, userID.Hex())
+		return s.limitRate(c, userID.Hex(), handler)
+	}
+}
+
+// adminRequired is a middleware function that authorizes a request like tokenOrAPIKeyRequired with the admin scope,
+// then refuses it unless the user is an admin whose account is not disabled. The admin methods of the ClientService
+// check the role again, so a route missing this middleware does not expose them.
+func (s *WebServer) adminRequired(handler fiber.Handler) fiber.Handler {
+	return s.tokenOrAPIKeyRequired(user.APIKeyScopeAdmin, func(c *fiber.Ctx) error {
+		userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+		if err != nil {
+			s.logger.Debug("Invalid user ID: ", err.Error())
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+		}
+		if err := s.clientService.RequireAdmin(c.UserContext(), userID); err != nil {
+			s.logger.Debug("Admin check failed: ", err.Error())
+			return s.sendError(c, err)
+		}
+		return handler(c)
+	})
+}
+
+// uploadTokenHeader is the header carrying the upload token of direct uploads.
+const uploadTokenHeader = "X-Upload-Token"
+
+// uploadFormOverhead is the most bytes a multipart upload body may have beyond the file it carries, for the other
+// form fields and part headers.
+const uploadFormOverhead int64 = 1024 * 1024
 
-		if err != nil || !token.Valid {
-			s.logger.Debug("Invalid token")
-			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+// uploadTokenRequired is a middleware function that authorizes a direct upload with the upload token in the
+// X-Upload-Token header or the `upload_token` query parameter, in place of a session.
+//
+// Bodies declared larger than the token allows are rejected before they are read. The handler must still check the
+// received file with ClientService.CheckUploadConstraints, using the authorization stored in the "uploadAuthorization"
+// local.
+func (s *WebServer) uploadTokenRequired(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := c.Get(uploadTokenHeader)
+		if token == "" {
+			token = c.Query("upload_token")
+		}
+		if token == "" {
+			s.logger.Debug("Missing upload token")
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Missing upload token"})
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			s.logger.Debug("Invalid token claims")
-			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token claims"})
+		authorization, err := s.clientService.VerifyUploadToken(c.UserContext(), token)
+		if err != nil {
+			return s.sendError(c, err)
 		}
-		userID, ok := claims["sub"].(string)
-		if !ok {
-			s.logger.Debug("Invalid user ID in token")
-			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID in token"})
+
+		if contentLength := int64(c.Request().Header.ContentLength()); contentLength > authorization.MaxSize+uploadFormOverhead {
+			return s.sendError(c, fmt.Errorf("%w: declared %d bytes, limit is %d", services.ErrUploadTooLarge, contentLength, authorization.MaxSize))
 		}
 
-		c.Locals("userID", userID)
+		s.setUser(c, authorization.UserID.Hex())
+		c.Locals("uploadAuthorization", authorization)
+		return s.limitRate(c, authorization.UserID.Hex(), handler)
+	}
+}
+
+// workerKeyHeader is the header carrying the key GPU workers authenticate with.
+const workerKeyHeader = "X-Worker-Key"
+
+// workerKeyRequired is a middleware function that authorizes a GPU worker with the worker key in the X-Worker-Key
+// header, in place of a session.
+func (s *WebServer) workerKeyRequired(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := s.workerService.VerifyWorkerKey(c.Get(workerKeyHeader)); err != nil {
+			s.logger.Debug("Worker key verification failed: ", err.Error())
+			return s.sendError(c, err)
+		}
 		return handler(c)
 	}
 }
@@ -177,28 +442,67 @@ func (s *WebServer) loginUser(c *fiber.Ctx) error {
 	var req LoginRequest
 	if err := ValidateRequest(c, &req); err != nil {
 		s.logger.Debug("Login request validation failed: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return s.sendError(c, validationError(err))
 	}
 	s.logger.Debug("Login request validated")
 
-	userID, err := s.clientService.LoginUser(context.TODO(), req.Username, req.Password)
+	tokens, err := s.clientService.LoginUser(c.UserContext(), req.Username, req.Password)
 	if err != nil {
 		s.logger.Debug("User login failed: ", err.Error())
-		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		return s.sendError(c, err)
 	}
 	s.logger.Debug("User logged in")
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": userID,
-	})
-	tokenString, err := token.SignedString([]byte(s.jwtSecret))
+	return c.Status(http.StatusOK).JSON(tokens)
+}
+
+// refreshTokens handles the request to exchange a refresh token for a new access token and refresh token.
+// The refresh token is single use, and must be replaced by the one in the response.
+//
+// It expects a JSON payload with the following format:
+//	{
+//	    "refresh_token": "refresh token"
+//	}
+func (s *WebServer) refreshTokens(c *fiber.Ctx) error {
+	s.logger.Debug("Refresh tokens request received")
+
+	var req RefreshTokenRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Refresh tokens request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	tokens, err := s.clientService.RefreshTokens(c.UserContext(), req.RefreshToken)
 	if err != nil {
-		s.logger.Debug("Failed to generate token")
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
+		s.logger.Debug("Failed to refresh tokens: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(tokens)
+}
+
+// logoutUser handles the logout request, revoking the refresh token of the session. The access token stays valid
+// until it expires, so clients should discard it as well.
+//
+// It expects a JSON payload with the following format:
+//	{
+//	    "refresh_token": "refresh token"
+//	}
+func (s *WebServer) logoutUser(c *fiber.Ctx) error {
+	s.logger.Debug("Logout request received")
+
+	var req RefreshTokenRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Logout request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
 	}
-	s.logger.Debugf("JWT token generated, userID %s\n", userID)
 
-	return c.Status(http.StatusOK).JSON(fiber.Map{"jwtToken": tokenString})
+	if err := s.clientService.RevokeRefreshToken(c.UserContext(), req.RefreshToken); err != nil {
+		s.logger.Debug("Failed to revoke refresh token: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.SendStatus(http.StatusNoContent)
 }
 
 // registerUser handles the registration request. 
@@ -214,17 +518,71 @@ func (s *WebServer) registerUser(c *fiber.Ctx) error {
 	var req RegisterRequest
 	if err := ValidateRequest(c, &req); err != nil {
 		s.logger.Debug("Register request validation failed: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "success": false})
+		status, body := errorResponse(validationError(err))
+		body["success"] = false
+		return c.Status(status).JSON(body)
 	}
 
-	err := s.clientService.RegisterUser(context.TODO(), req.Username, req.Password)
+	err := s.clientService.RegisterUser(c.UserContext(), req.Username, req.Password)
 	if err != nil {
 		s.logger.Debug("User registration failed: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "success": false})
+		status, body := errorResponse(err)
+		body["success"] = false
+		return c.Status(status).JSON(body)
 	}
 
 	s.logger.Debug("User registered successfully")
-	return c.Status(http.StatusCreated).JSON(fiber.Map{"success": true})
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"success":               true,
+		"verification_required": s.clientService.EmailVerificationEnabled(),
+	})
+}
+
+// verifyEmail handles the request to verify the email address of an account, with the token mailed on registration.
+//
+// It expects a JSON payload with the following format:
+//	{
+//	    "token": "token"
+//	}
+func (s *WebServer) verifyEmail(c *fiber.Ctx) error {
+	s.logger.Debug("Verify email request received")
+
+	var req VerifyEmailRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Verify email request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	if err := s.clientService.VerifyEmail(c.UserContext(), req.Token); err != nil {
+		s.logger.Debug("Email verification failed: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"success": true})
+}
+
+// resendVerification handles the request to mail a new verification token to an unverified account.
+// The response is the same whether or not the account exists.
+//
+// It expects a JSON payload with the following format:
+//	{
+//	    "username": "username"
+//	}
+func (s *WebServer) resendVerification(c *fiber.Ctx) error {
+	s.logger.Debug("Resend verification request received")
+
+	var req ResendVerificationRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Resend verification request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	if err := s.clientService.ResendVerification(c.UserContext(), req.Username); err != nil {
+		s.logger.Debug("Resending verification failed: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{"success": true})
 }
 
 // updateUserUsername handles the request to update the username of a user. It is a JWT protected route.
@@ -240,7 +598,7 @@ func (s *WebServer) updateUserUsername(c *fiber.Ctx) error {
 	var req UpdateUsernameRequest
 	if err := ValidateRequest(c, &req); err != nil {
 		s.logger.Debug("Update username request validation failed: ", err.Error())
-		return fiber.NewError(http.StatusBadRequest, err.Error())
+		return s.sendError(c, validationError(err))
 	}
 
 	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
@@ -249,20 +607,21 @@ func (s *WebServer) updateUserUsername(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest, "Invalid user ID")
 	}
 
-	err = s.clientService.UpdateUserUsername(context.TODO(), userID, req.Password, req.NewUsername)
+	err = s.clientService.UpdateUserUsername(c.UserContext(), userID, req.Password, req.NewUsername)
 	if err != nil {
 		s.logger.Debug("Failed to update username: ", err.Error())
-		return fiber.NewError(http.StatusBadRequest, err.Error())
+		return s.sendError(c, err)
 	}
 
 	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Username updated"})
 }
 
 // updateUserPassword handles the request to update the password of a user. It is a JWT protected route.
+// Users who log in with a provider and have no password yet set one without the old password.
 // It expects a JSON payload with the following format:
 //
 //	{
-//	    "old_password": "old_password",
+//	    "old_password": "old_password" (optional without a password),
 //	    "new_password": "new_password"
 //	}
 func (s *WebServer) updateUserPassword(c *fiber.Ctx) error {
@@ -270,7 +629,7 @@ func (s *WebServer) updateUserPassword(c *fiber.Ctx) error {
 
 	var req UpdatePasswordRequest
 	if err := ValidateRequest(c, &req); err != nil {
-		return fiber.NewError(http.StatusBadRequest, err.Error())
+		return s.sendError(c, validationError(err))
 	}
 
 	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
@@ -279,53 +638,29 @@ func (s *WebServer) updateUserPassword(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest, "Invalid user ID")
 	}
 
-	err = s.clientService.UpdateUserPassword(context.TODO(), userID, req.OldPassword, req.NewPassword)
+	err = s.clientService.UpdateUserPassword(c.UserContext(), userID, req.OldPassword, req.NewPassword)
 	if err != nil {
 		s.logger.Debug("Failed to update password: ", err.Error())
-		return fiber.NewError(http.StatusBadRequest, err.Error())
+		return s.sendError(c, err)
 	}
 
 	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Password updated"})
 }
 
-// Must be careful in implementing these two functions.
-// Figure our how to gracefully handle deletion of scenes since they might be processing.
-//
-// Planning: Confirmation at the client level. In progress scenes are denied deletion
-// (This should be redundant ui-wise since the client doesnt display )
-// If a completed scene is deleted, we do not need to delete the output files. FOR NOW. Instead just 
-// remove from DB.
+// deleteUserScene handles the request to move a scene to the trash, or to delete it and all of its files. It is a JWT
+// protected route.
 //
-// This allows us to implement a goroutine that periodically cleans up old output files. (we can just append to a list of files to delete)
+// It expects path parameter `scene_id` and optional query parameters `cancel` and `permanent`. Scenes that are still
+// processing are cancelled first if `cancel` is true, and cannot be deleted otherwise. Scenes are deleted permanently
+// if `permanent` is true or they are already in the trash.
 func (s *WebServer) deleteUserScene(c *fiber.Ctx) error {
-	return fiber.NewError(http.StatusNotImplemented, "Not implemented")
-}
-
-// Should deleting a user also delete all their scenes? How to handle this?
-// Do we need to expand auth for confirmation, or leave that at the client level?
-func (s *WebServer) deleteUser(c *fiber.Ctx) error {
-	return fiber.NewError(http.StatusNotImplemented, "Not implemented")
-}
+	s.logger.Debug("Delete scene request received")
 
-// postNewScene handles the new scene request. It is a JWT protected route.
-//
-// It expects a multipart form with the following fields:
-//   - file: required,
-//     the video file to upload
-//   - training_mode: optional,
-//     the training mode to use (gaussian or tensorf)
-//   - output_types: optional,
-//     a comma-separated list of output types to save (e.g. splat_cloud, point_cloud, etc.)
-//   - save_iterations: optional,
-//     a comma-separated list of iterations to save the output at (0 <= x <= 30000)
-//   - total_iterations: optional,
-//     the total number of iterations to run (0 <= x <= 30000)
-//   - scene_name: optional,
-//     the name of the scene
-func (s *WebServer) postNewScene(c *fiber.Ctx) error {
-	s.logger.Debug("New Scene Request received")
-	var req *NewSceneRequest
-	var err error
+	var req DeleteSceneRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Delete scene request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
 
 	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
 	if err != nil {
@@ -333,46 +668,40 @@ func (s *WebServer) postNewScene(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	req, err = ParseNewSceneRequest(c)
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
 	if err != nil {
-		s.logger.Debug("Video upload request parsing failed: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
-	}
-
-	if req.TrainingMode == "tensorf" {
-		s.logger.Debug("Tensorf training mode is now deprecated. Please use gaussian training mode.")
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Tensorf training mode is now deprecated. Please use gaussian training mode."})
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
 	}
 
-	sceneID, err := s.clientService.HandleIncomingVideo(
-		context.TODO(),
-		userID,
-		req.File,
-		req.TrainingMode,
-		req.OutputTypes,
-		req.SaveIterations,
-		req.TotalIterations,
-		req.SceneName,
-	)
+	deletion, err := s.clientService.DeleteScene(c.UserContext(), userID, sceneID, req.Cancel, req.Permanent)
 	if err != nil {
-		s.logger.Debug("Video processing failed:", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		s.logger.Debug("Failed to delete scene: ", err.Error())
+		return s.sendError(c, err)
 	}
 
-	s.logger.Debugf("Video received and processing scene %s. Check back later for updates.\n", sceneID)
-	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"id": sceneID, "message": "Video received and processing scene. Check back later for updates."})
+	message := "Scene deleted"
+	if deletion.Trashed {
+		message = "Scene moved to the trash"
+	}
+	return c.Status(http.StatusOK).JSON(fiber.Map{
+		"message":         message,
+		"trashed":         deletion.Trashed,
+		"purge_at":        deletion.PurgeAt,
+		"bytes_reclaimed": deletion.BytesReclaimed,
+	})
 }
 
-// getSceneMetadata handles the request to get the metadata for a scene. It is a JWT protected route.
+// restoreScene handles the request to take a scene out of the trash. It is a JWT protected route.
 //
 // It expects path parameter `scene_id`.
-func (s *WebServer) getSceneMetadata(c *fiber.Ctx) error {
-	s.logger.Debug("Get scene metadata request received")
+func (s *WebServer) restoreScene(c *fiber.Ctx) error {
+	s.logger.Debug("Restore scene request received")
 
-	var req GetSceneMetadataRequest
+	var req RestoreSceneRequest
 	if err := ValidateRequest(c, &req); err != nil {
-		s.logger.Debug("Get job data request validation failed: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		s.logger.Debug("Restore scene request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
 	}
 
 	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
@@ -383,29 +712,70 @@ func (s *WebServer) getSceneMetadata(c *fiber.Ctx) error {
 
 	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
 	if err != nil {
-		s.logger.Debug("Invalid job ID: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid job ID"})
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	if err := s.clientService.RestoreScene(c.UserContext(), userID, sceneID); err != nil {
+		s.logger.Debug("Failed to restore scene: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Scene restored"})
+}
+
+// getTrash handles the request to list the user's scenes in the trash. It is a JWT protected route.
+//
+// It optionally expects query parameters `page` (1-indexed), `page_size`, and `sort` (newest or oldest). The response
+// is a page of scenes as for /user/scene/list, with when each was trashed.
+func (s *WebServer) getTrash(c *fiber.Ctx) error {
+	s.logger.Debug("Get trash request received")
+
+	var req GetTrashRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get trash request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
 	}
 
-	sceneData, err := s.clientService.GetSceneMetadata(context.TODO(), userID, sceneID)
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
 	if err != nil {
-		s.logger.Debug("Failed to get job data: ", err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	sceneJson, err := json.Marshal(sceneData)
+	page, err := s.clientService.GetTrash(c.UserContext(), userID, services.HistoryQuery{
+		Page:        req.Page,
+		PageSize:    req.PageSize,
+		OldestFirst: req.Sort == "oldest",
+	})
 	if err != nil {
-		s.logger.Debug("Failed to marshal job data: ", err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		s.logger.Debug("Failed to get trash: ", err.Error())
+		return s.sendError(c, err)
 	}
 
-	s.logger.Debug(fmt.Sprintf("Job data retrieved successfully, data: %s", sceneJson))
-	return c.Status(http.StatusOK).Send(sceneJson)
+	return c.Status(http.StatusOK).JSON(page)
 }
 
-// getUserSceneHistory handles the request to get the history of scenes for a user. It is a JWT protected route.
-func (s *WebServer) getUserSceneHistory(c *fiber.Ctx) error {
-	s.logger.Debug("Get user history request received")
+// deleteOldScenes handles the request to delete every scene of a user older than a given age. It is a JWT protected route.
+//
+// It expects a JSON payload with the following format:
+//
+//	{
+//	    "older_than": 2592000, (seconds, delete scenes created longer ago)
+//	    "state": "completed", (optional, one of completed, failed, cancelled)
+//	    "owner_id": "user_id" (optional, admins only, delete the scenes of this user instead)
+//	}
+//
+// Scenes that are still processing are skipped. The response reports the scenes deleted and bytes reclaimed, and the
+// scenes skipped or failed to delete with the reason.
+func (s *WebServer) deleteOldScenes(c *fiber.Ctx) error {
+	s.logger.Debug("Delete old scenes request received")
+
+	var req DeleteOldScenesRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Delete old scenes request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
 
 	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
 	if err != nil {
@@ -413,26 +783,34 @@ func (s *WebServer) getUserSceneHistory(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	sceneIDList, err := s.clientService.GetUserSceneHistory(context.TODO(), userID)
+	opts := services.BulkDeleteOptions{State: scene.State(req.State)}
+	if req.OwnerID != "" {
+		opts.OwnerID, err = primitive.ObjectIDFromHex(req.OwnerID)
+		if err != nil {
+			s.logger.Debug("Invalid owner ID: ", err.Error())
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid owner ID"})
+		}
+	}
+
+	summary, err := s.clientService.DeleteScenesOlderThan(c.UserContext(), userID, time.Duration(req.OlderThan)*time.Second, opts)
 	if err != nil {
-		s.logger.Debug("Failed to get user history: ", err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		s.logger.Debug("Failed to delete old scenes: ", err.Error())
+		return s.sendError(c, err)
 	}
 
-	s.logger.Debug("User history retrieved successfully")
-	return c.Status(http.StatusOK).JSON(fiber.Map{"resources": sceneIDList})
+	return c.Status(http.StatusOK).JSON(summary)
 }
 
-// getSceneThumbnail handles the request to get the thumbnail for a scene. It is a JWT protected route.
+// cancelJob handles the request to cancel the processing of a scene. It is a JWT protected route.
 //
-// It expects path parameter `scene_id`
-func (s *WebServer) getSceneThumbnail(c *fiber.Ctx) error {
-	s.logger.Debug("Get scene thumbnail request received")
+// It expects path parameter `scene_id`. Scenes that have already finished processing cannot be cancelled.
+func (s *WebServer) cancelJob(c *fiber.Ctx) error {
+	s.logger.Debug("Cancel job request received")
 
-	var req GetSceneThumbnailRequest
+	var req CancelJobRequest
 	if err := ValidateRequest(c, &req); err != nil {
-		s.logger.Debug("Get scene thumbnail request validation failed: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		s.logger.Debug("Cancel job request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
 	}
 
 	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
@@ -447,38 +825,27 @@ func (s *WebServer) getSceneThumbnail(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
 	}
 
-	thumbnailPath, err := s.clientService.GetSceneThumbnailPath(context.TODO(), userID, sceneID)
-	if err != nil {
-		s.logger.Debug("Failed to get scene thumbnail: ", err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
-	}
-
-	thumbnailData, err := os.ReadFile(thumbnailPath)
+	err = s.clientService.CancelJob(c.UserContext(), userID, sceneID)
 	if err != nil {
-		s.logger.Debug("Failed to read thumbnail data: ", err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		s.logger.Debug("Failed to cancel job: ", err.Error())
+		return s.sendError(c, err)
 	}
 
-	s.logger.Debug("Scene thumbnail retrieved successfully")
-	return c.Status(http.StatusOK).Send(thumbnailData)
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Job cancelled"})
 }
 
-// getSceneName handles the request to get the name of a scene. It is a JWT protected route.
+// retryJob handles the request to process a failed or cancelled scene again with its existing config. It is a JWT
+// protected route.
 //
-// It expects path parameter `scene_id`.
-func (s *WebServer) getSceneName(c *fiber.Ctx) error {
-	s.logger.Debug("Get scene name request received")
+// It expects path parameter `scene_id`. Processing resumes from the last stage that succeeded, so the video does not
+// have to be uploaded again.
+func (s *WebServer) retryJob(c *fiber.Ctx) error {
+	s.logger.Debug("Retry job request received")
 
-	var req GetSceneNameRequest
+	var req RetryJobRequest
 	if err := ValidateRequest(c, &req); err != nil {
-		s.logger.Debug("Get scene name request validation failed: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
-	}
-
-	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
-	if err != nil {
-		s.logger.Debug("Invalid scene ID: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+		s.logger.Debug("Retry job request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
 	}
 
 	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
@@ -487,82 +854,3277 @@ func (s *WebServer) getSceneName(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	sceneName, err := s.clientService.GetSceneName(context.TODO(), userID, sceneID)
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
 	if err != nil {
-		s.logger.Debug("Failed to get scene name: ", err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
 	}
 
-	return c.Status(http.StatusOK).JSON(fiber.Map{"name": sceneName})
+	err = s.clientService.RetryJob(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to retry job: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{"message": "Job retry started"})
 }
 
-// getSceneOutput handles the request to get the output for a scene. It is a JWT protected route.
-// 
-// It expects a path parameters `scene_id` `output_type`.
-// 
-// The user can optionally specify a query parameter `iteration` to get the output at a specific iteration.
-// If the iteration is not specified, the latest output is given.
-func (s *WebServer) getSceneOutput(c *fiber.Ctx) error {
-	s.logger.Debug("Get scene output request received")
+// retrainScene handles the request to train an existing scene again with a new config. It is a JWT protected route.
+//
+// It expects path parameter `scene_id`, and a JSON payload with the following format:
+//
+//	{
+//	    "training_mode": "gaussian",
+//	    "output_types": ["splat_cloud", "video"],
+//	    "save_iterations": [7000, 30000],
+//	    "total_iterations": 30000,
+//	    "frame_sample_rate": 2, (optional, requires rerun_sfm)
+//	    "target_frame_count": 200, (optional, requires rerun_sfm, cannot be combined with frame_sample_rate)
+//	    "priority": "normal", (optional)
+//	    "rerun_sfm": false, (optional, reuses the existing sfm output by default)
+//	    "preset": "high-quality" (optional, fills in the training config values left out, see getTrainingPresets)
+//	}
+//
+// The training output of a completed scene is kept as a previous version, and the response carries the `version` of
+// the new training run. The output of a failed or cancelled scene is replaced. Scenes that are still processing cannot
+// be retrained.
+func (s *WebServer) retrainScene(c *fiber.Ctx) error {
+	s.logger.Debug("Retrain scene request received")
 
-	var req GetSceneOutputRequest
+	var req RetrainSceneRequest
 	if err := ValidateRequest(c, &req); err != nil {
-		s.logger.Debug("Get scene output request validation failed: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		s.logger.Debug("Retrain scene request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
 	}
 
-	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
-	if err != nil {
-		s.logger.Debug("Invalid scene ID: ", req.SceneID)
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	if req.TrainingMode == "tensorf" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Tensorf training mode is now deprecated. Please use gaussian training mode."})
 	}
 
 	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
 	if err != nil {
-		s.logger.Debug("Invalid user ID: ", c.Locals("userID").(string))
+		s.logger.Debug("Invalid user ID: ", err.Error())
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	outputPath, err := s.clientService.GetSceneOutputPath(context.TODO(), userID, sceneID, req.OutputType, req.Iteration)
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
 	if err != nil {
-		s.logger.Debugf("Failed to get scene output: ", err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
-	}
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	config := &scene.TrainingConfig{
+		NerfTrainingConfig: &scene.NerfTrainingConfig{
+			TrainingMode:     req.TrainingMode,
+			OutputTypes:      req.OutputTypes,
+			SaveIterations:   req.SaveIterations,
+			TotalIterations:  req.TotalIterations,
+			FrameSampleRate:  req.FrameSampleRate,
+			TargetFrameCount: req.TargetFrameCount,
+		},
+		Priority: req.Priority,
+	}
+
+	version, err := s.clientService.RetrainScene(c.UserContext(), userID, sceneID, config, req.RerunSfm)
+	if err != nil {
+		s.logger.Debug("Failed to retrain scene: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{"message": "Scene retraining started", "version": version})
+}
+
+// exportScene handles the request to export the latest output of a trained scene to an interchange format. It is a
+// JWT protected route.
+//
+// It expects path parameter `scene_id`, and a JSON payload with the following format:
+//
+//	{
+//	    "format": "ply" (one of "ply", "gltf", "obj", "splat")
+//	}
+//
+// The conversion is done by a worker, so the export is returned pending. Once done, the exported file is served by the
+// resource endpoints with the format as output type. Requesting an export that is pending or done returns it again.
+func (s *WebServer) exportScene(c *fiber.Ctx) error {
+	s.logger.Debug("Export scene request received")
+
+	var req ExportSceneRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Export scene request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	export, err := s.clientService.RequestExport(c.UserContext(), userID, sceneID, req.Format)
+	if err != nil {
+		s.logger.Debug("Failed to export scene: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{"export": export})
+}
+
+// renderScene handles the request to render a trained scene along a camera path into a video. It is a JWT protected
+// route.
+//
+// It expects path parameter `scene_id`, and a JSON payload with either a pose per frame or a spline through keyframes:
+//
+//	{
+//	    "poses": [{"position": [x, y, z], "rotation": [w, x, y, z], "fov": 60}, ...] (optional, fov optional),
+//	    "spline": {
+//	        "keyframes": [<pose>, ...] (at least 2),
+//	        "interpolation": "catmull_rom" (optional, or "linear"),
+//	        "duration": 10.0 (seconds),
+//	        "loop": false (optional)
+//	    } (optional),
+//	    "fps": 30 (optional),
+//	    "width": 1280 (optional),
+//	    "height": 720 (optional),
+//	    "iteration": 30000 (optional, the latest iteration otherwise)
+//	}
+//
+// Poses are in the world coordinates of the scene's sfm output. The render is done by a worker, so it is returned
+// pending. Once done, its video is served by /user/scene/render/:scene_id/:render_id/video.
+func (s *WebServer) renderScene(c *fiber.Ctx) error {
+	s.logger.Debug("Render scene request received")
+
+	var req RenderSceneRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Render scene request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	path := scene.CameraPath{FPS: req.FPS, Width: req.Width, Height: req.Height}
+	for _, pose := range req.Poses {
+		path.Poses = append(path.Poses, scene.CameraPose(pose))
+	}
+	if req.Spline != nil {
+		path.Spline = &scene.CameraSpline{
+			Interpolation: req.Spline.Interpolation,
+			Duration:      req.Spline.Duration,
+			Loop:          req.Spline.Loop,
+		}
+		for _, pose := range req.Spline.Keyframes {
+			path.Spline.Keyframes = append(path.Spline.Keyframes, scene.CameraPose(pose))
+		}
+	}
+
+	render, err := s.clientService.RequestRender(c.UserContext(), userID, sceneID, path, req.Iteration)
+	if err != nil {
+		s.logger.Debug("Failed to render scene: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{"render": render})
+}
+
+// getRenders handles the request to list the renders of a scene, most recent first. It is a JWT protected route.
+//
+// It expects path parameter `scene_id`.
+func (s *WebServer) getRenders(c *fiber.Ctx) error {
+	s.logger.Debug("Get renders request received")
+
+	var req GetRendersRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get renders request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	renders, err := s.clientService.ListRenders(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to list renders: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"renders": renders})
+}
+
+// getRender handles the request to get the state of a render of a scene. It is a JWT protected route.
+//
+// It expects path parameters `scene_id` and `render_id`.
+func (s *WebServer) getRender(c *fiber.Ctx) error {
+	s.logger.Debug("Get render request received")
+
+	var req GetRenderRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get render request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	renderID, err := primitive.ObjectIDFromHex(req.RenderID)
+	if err != nil {
+		s.logger.Debug("Invalid render ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid render ID"})
+	}
+
+	render, err := s.clientService.GetRender(c.UserContext(), userID, sceneID, renderID)
+	if err != nil {
+		s.logger.Debug("Failed to get render: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"render": render})
+}
+
+// getRenderVideo handles the request to download the video of a done render of a scene. It is a JWT protected route.
+//
+// It expects path parameters `scene_id` and `render_id`. The Range header is honored, so the video can be streamed.
+func (s *WebServer) getRenderVideo(c *fiber.Ctx) error {
+	s.logger.Debug("Get render video request received")
+
+	var req GetRenderRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get render video request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	renderID, err := primitive.ObjectIDFromHex(req.RenderID)
+	if err != nil {
+		s.logger.Debug("Invalid render ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid render ID"})
+	}
+
+	output, err := s.clientService.GetRenderVideo(c.UserContext(), userID, sceneID, renderID)
+	if err != nil {
+		s.logger.Debug("Failed to get render video: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	// A render's video is never rewritten once done
+	c.Set("Cache-Control", "private, max-age=31536000, immutable")
+	setContentDisposition(c, output)
+
+	return s.sendOutput(c, userID, "render", output.Path, "", 0, output.Final)
+}
+
+// getTrainingPresets handles the request for the named training presets, and the limits training configs are checked
+// against. It is a JWT protected route.
+//
+// A preset can be named with the `preset` field of uploads, retraining, and estimates, and fills in the training config
+// fields the request leaves out. Presets outside the limits of the deployment are not listed.
+func (s *WebServer) getTrainingPresets(c *fiber.Ctx) error {
+	s.logger.Debug("Training presets request received")
+	return c.Status(http.StatusOK).JSON(s.clientService.GetTrainingPresets(c.UserContext()))
+}
+
+// estimateTraining handles the request to estimate the runtime, cost, and output size of a training job before
+// uploading its video. It is a JWT protected route. Nothing is created or published.
+//
+// It expects a JSON payload with the following format:
+//
+//	{
+//	    "training_mode": "gaussian",
+//	    "output_types": ["splat_cloud", "video"],
+//	    "save_iterations": [7000, 30000],
+//	    "total_iterations": 30000,
+//	    "frame_sample_rate": 2, (optional)
+//	    "target_frame_count": 200, (optional, cannot be combined with frame_sample_rate)
+//	    "width": 1920,
+//	    "height": 1080,
+//	    "fps": 30,
+//	    "duration_seconds": 45.5,
+//	    "frame_count": 1365, (optional, estimated from duration and fps by default)
+//	    "file_size": 104857600, (optional, used to find similar completed jobs)
+//	    "preset": "fast-preview" (optional, fills in the training config values left out, see getTrainingPresets)
+//	}
+func (s *WebServer) estimateTraining(c *fiber.Ctx) error {
+	s.logger.Debug("Estimate training request received")
+
+	var req EstimateTrainingRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Estimate training request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	if req.TrainingMode == "tensorf" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Tensorf training mode is now deprecated. Please use gaussian training mode."})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	estimate, err := s.clientService.EstimateTraining(c.UserContext(), userID, &services.TrainingEstimateRequest{
+		Video: services.VideoProperties{
+			Width:           req.Width,
+			Height:          req.Height,
+			FPS:             req.FPS,
+			DurationSeconds: req.DurationSeconds,
+			FrameCount:      req.FrameCount,
+			FileSize:        req.FileSize,
+		},
+		Config: &scene.NerfTrainingConfig{
+			TrainingMode:     req.TrainingMode,
+			OutputTypes:      req.OutputTypes,
+			SaveIterations:   req.SaveIterations,
+			TotalIterations:  req.TotalIterations,
+			FrameSampleRate:  req.FrameSampleRate,
+			TargetFrameCount: req.TargetFrameCount,
+		},
+	})
+	if err != nil {
+		s.logger.Debug("Failed to estimate training: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(estimate)
+}
+
+// deleteUser handles the request to permanently delete the user's account and all of their scenes. It is a JWT protected route.
+//
+// It expects a JSON payload with the following format:
+//
+//	{
+//	    "password": "password"
+//	}
+func (s *WebServer) deleteUser(c *fiber.Ctx) error {
+	s.logger.Debug("Delete user request received")
+
+	var req DeleteUserRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Delete user request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	summary, err := s.clientService.DeleteUser(c.UserContext(), userID, req.Password)
+	if err != nil {
+		s.logger.Debug("Failed to delete user: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(summary)
+}
+
+// getQuota handles the request to get the quotas of the user, and how much of each they used. It is a JWT protected
+// route.
+func (s *WebServer) getQuota(c *fiber.Ctx) error {
+	s.logger.Debug("Get quota request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	quota, err := s.clientService.GetQuota(c.UserContext(), userID)
+	if err != nil {
+		s.logger.Debug("Failed to get quota: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(quota)
+}
+
+// getNotificationPreferences handles the request to get which job notifications are mailed to the user. It is a JWT
+// protected route.
+func (s *WebServer) getNotificationPreferences(c *fiber.Ctx) error {
+	s.logger.Debug("Get notification preferences request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	preferences, err := s.clientService.GetNotificationPreferences(c.UserContext(), userID)
+	if err != nil {
+		s.logger.Debug("Failed to get notification preferences: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(preferences)
+}
+
+// updateNotificationPreferences handles the request to choose which job notifications are mailed to the user. It is a
+// JWT protected route.
+//
+// It expects a JSON payload with the following format:
+//
+//	{
+//	    "training_complete": true,
+//	    "job_failed": false
+//	}
+func (s *WebServer) updateNotificationPreferences(c *fiber.Ctx) error {
+	s.logger.Debug("Update notification preferences request received")
+
+	var req UpdateNotificationPreferencesRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Update notification preferences request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	preferences := user.NotificationPreferences{TrainingComplete: *req.TrainingComplete, JobFailed: *req.JobFailed}
+	if err := s.clientService.SetNotificationPreferences(c.UserContext(), userID, preferences); err != nil {
+		s.logger.Debug("Failed to update notification preferences: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(preferences)
+}
+
+// listAPIKeys handles the request to list the API keys of the user. It is a JWT protected route.
+// The keys themselves are never returned, only their names, scopes and the start of each key.
+func (s *WebServer) listAPIKeys(c *fiber.Ctx) error {
+	s.logger.Debug("List API keys request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	keys, err := s.clientService.ListAPIKeys(c.UserContext(), userID)
+	if err != nil {
+		s.logger.Debug("Failed to list API keys: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"api_keys": keys})
+}
+
+// createAPIKey handles the request to create an API key. It is a JWT protected route.
+// The response holds the key, which is not shown again.
+//
+// It expects a JSON payload with the following format:
+//
+//	{
+//	    "name": "training script",
+//	    "scopes": ["upload", "read"]
+//	}
+func (s *WebServer) createAPIKey(c *fiber.Ctx) error {
+	s.logger.Debug("Create API key request received")
+
+	var req CreateAPIKeyRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Create API key request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	key, err := s.clientService.CreateAPIKey(c.UserContext(), userID, req.Name, req.Scopes)
+	if err != nil {
+		s.logger.Debug("Failed to create API key: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusCreated).JSON(key)
+}
+
+// revokeAPIKey handles the request to revoke an API key. It is a JWT protected route.
+//
+// It expects path parameter `key_id`.
+func (s *WebServer) revokeAPIKey(c *fiber.Ctx) error {
+	s.logger.Debug("Revoke API key request received")
+
+	var req RevokeAPIKeyRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Revoke API key request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	keyID, err := primitive.ObjectIDFromHex(req.KeyID)
+	if err != nil {
+		s.logger.Debug("Invalid key ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid key ID"})
+	}
+
+	if err := s.clientService.RevokeAPIKey(c.UserContext(), userID, keyID); err != nil {
+		s.logger.Debug("Failed to revoke API key: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "API key revoked"})
+}
+
+// listWebhooks handles the request to list the webhooks of the user. It is a protected route.
+// The signing secrets are never returned.
+func (s *WebServer) listWebhooks(c *fiber.Ctx) error {
+	s.logger.Debug("List webhooks request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	webhooks, err := s.clientService.ListWebhooks(c.UserContext(), userID)
+	if err != nil {
+		s.logger.Debug("Failed to list webhooks: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"webhooks": webhooks})
+}
+
+// createWebhook handles the request to register a webhook. It is a JWT protected route.
+// The response holds the signing secret of the webhook, which is not shown again.
+//
+// It expects a JSON payload with the following format:
+//
+//	{
+//	    "url": "https://example.com/hooks/vidgonerf",
+//	    "events": ["training_complete", "job_failed"], // optional, all events if omitted
+//	    "scene_id": "scene_id" // optional, every scene of the user if omitted
+//	}
+func (s *WebServer) createWebhook(c *fiber.Ctx) error {
+	s.logger.Debug("Create webhook request received")
+
+	var req CreateWebhookRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Create webhook request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID := primitive.NilObjectID
+	if req.SceneID != "" {
+		if sceneID, err = primitive.ObjectIDFromHex(req.SceneID); err != nil {
+			s.logger.Debug("Invalid scene ID: ", err.Error())
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+		}
+	}
+
+	webhook, err := s.clientService.CreateWebhook(c.UserContext(), userID, sceneID, req.URL, req.Events)
+	if err != nil {
+		s.logger.Debug("Failed to create webhook: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusCreated).JSON(webhook)
+}
+
+// deleteWebhook handles the request to remove a webhook. It is a JWT protected route.
+// Deliveries not yet sent are dropped.
+//
+// It expects path parameter `webhook_id`.
+func (s *WebServer) deleteWebhook(c *fiber.Ctx) error {
+	s.logger.Debug("Delete webhook request received")
+
+	var req WebhookRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Delete webhook request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	webhookID, err := primitive.ObjectIDFromHex(req.WebhookID)
+	if err != nil {
+		s.logger.Debug("Invalid webhook ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid webhook ID"})
+	}
+
+	if err := s.clientService.DeleteWebhook(c.UserContext(), userID, webhookID); err != nil {
+		s.logger.Debug("Failed to delete webhook: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Webhook deleted"})
+}
+
+// getWebhookDeliveries handles the request to list the recent deliveries of a webhook, with the outcome of their
+// last attempt. It is a protected route.
+//
+// It expects path parameter `webhook_id`.
+func (s *WebServer) getWebhookDeliveries(c *fiber.Ctx) error {
+	s.logger.Debug("Get webhook deliveries request received")
+
+	var req WebhookRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get webhook deliveries request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	webhookID, err := primitive.ObjectIDFromHex(req.WebhookID)
+	if err != nil {
+		s.logger.Debug("Invalid webhook ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid webhook ID"})
+	}
+
+	deliveries, err := s.clientService.GetWebhookDeliveries(c.UserContext(), userID, webhookID)
+	if err != nil {
+		s.logger.Debug("Failed to get webhook deliveries: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"deliveries": deliveries})
+}
+
+// postNewScene handles the new scene request. It is a JWT protected route.
+//
+// It expects a multipart form with the following fields:
+//   - file: required,
+//     the .mp4 video file to upload, or a .zip archive of the PNG or JPEG images of an image set
+//   - training_mode: optional,
+//     the training mode to use (gaussian or tensorf)
+//   - output_types: optional,
+//     a comma-separated list of output types to save (e.g. splat_cloud, point_cloud, etc.)
+//   - save_iterations: optional,
+//     a comma-separated list of iterations to save the output at (0 <= x <= 30000)
+//   - total_iterations: optional,
+//     the total number of iterations to run (0 <= x <= 30000)
+//   - frame_sample_rate: optional, not allowed for image sets,
+//     extract every Nth frame of the video for sfm, instead of the sfm-worker's default
+//   - target_frame_count: optional,
+//     extract about this many frames spread over the video for sfm. Cannot be combined with frame_sample_rate
+//   - scene_name: optional,
+//     the name of the scene
+//   - priority: optional,
+//     the job priority, "normal" (default) or "high". High priority is only allowed for users whose tier permits it.
+//   - sha256: optional, not allowed for image sets,
+//     the hex SHA-256 of the video. The upload is rejected if the received video does not match it
+//   - reuse_sfm: optional, not allowed for image sets,
+//     "true" to reuse the sfm output of a scene of the user with the same video and frame sampling, if there is one
+//   - preset: optional,
+//     the name of a training preset filling in the training config fields left out, see getTrainingPresets
+//   - org_id: optional,
+//     the ID of an organization to upload the scene into the workspace of, which the user must be an admin or member of
+//
+// Uploads over the size or duration limit of the user's tier are rejected with 413, and the limit under "limit".
+func (s *WebServer) postNewScene(c *fiber.Ctx) error {
+	s.logger.Debug("New Scene Request received")
+	var req *NewSceneRequest
+	var err error
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	// Bodies declared larger than the user's tier allows are rejected before the form is read
+	if contentLength := int64(c.Request().Header.ContentLength()); contentLength > uploadFormOverhead {
+		if err := s.clientService.CheckUploadSize(c.UserContext(), userID, contentLength-uploadFormOverhead); err != nil {
+			return s.sendError(c, err)
+		}
+	}
+
+	req, err = ParseNewSceneRequest(c)
+	if err != nil {
+		s.logger.Debug("Video upload request parsing failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	// Direct uploads are checked against the constraints of their upload token once the file is received
+	if authorization, ok := c.Locals("uploadAuthorization").(*services.UploadAuthorization); ok {
+		if err := s.clientService.CheckUploadConstraints(authorization, req.File); err != nil {
+			return s.sendError(c, err)
+		}
+	}
+
+	if req.TrainingMode == "tensorf" {
+		s.logger.Debug("Tensorf training mode is now deprecated. Please use gaussian training mode.")
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Tensorf training mode is now deprecated. Please use gaussian training mode."})
+	}
+
+	orgID, err := parseOptionalObjectID(req.OrgID)
+	if err != nil {
+		s.logger.Debug("Invalid organization ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid organization ID"})
+	}
+
+	sceneID, err := s.clientService.HandleIncomingVideo(
+		c.UserContext(),
+		userID,
+		orgID,
+		req.File,
+		req.TrainingMode,
+		req.OutputTypes,
+		req.SaveIterations,
+		req.TotalIterations,
+		req.FrameSampleRate,
+		req.TargetFrameCount,
+		req.SceneName,
+		req.Priority,
+		req.SHA256,
+		req.ReuseSfm,
+	)
+	if err != nil {
+		s.logger.Debug("Video processing failed:", err.Error())
+		return s.sendError(c, err)
+	}
+
+	s.logger.Debugf("Video received and processing scene %s. Check back later for updates.\n", sceneID)
+	return s.sceneAccepted(c, sceneID, "Video received and processing scene. Check back later for updates.", worker.KindSfm, worker.KindNerf)
+}
+
+// sceneAccepted responds to an accepted upload of a scene, warning if no worker of the given kinds is available to
+// process it, see ClientService.WorkerAvailabilityWarning.
+func (s *WebServer) sceneAccepted(c *fiber.Ctx, sceneID, message string, kinds ...string) error {
+	response := fiber.Map{"id": sceneID, "message": message}
+	if warning := s.clientService.WorkerAvailabilityWarning(c.UserContext(), kinds...); warning != "" {
+		response["warning"] = warning
+	}
+	return c.Status(fiber.StatusAccepted).JSON(response)
+}
+
+// postUploadToken handles the request for an upload token, which authorizes a direct upload from the browser to
+// /upload/scene/new without the session token. It is a JWT protected route.
+//
+// It expects a JSON body with `max_size`, the size of the upload in bytes, and optionally `ttl`, the lifetime of the
+// token in seconds.
+func (s *WebServer) postUploadToken(c *fiber.Ctx) error {
+	s.logger.Debug("Upload token request received")
+
+	var req GenerateUploadTokenRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Upload token request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	token, err := s.clientService.GenerateUploadToken(c.UserContext(), userID, req.MaxSize, time.Duration(req.TTL)*time.Second)
+	if err != nil {
+		s.logger.Debug("Failed to generate upload token: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(token)
+}
+
+// uploadOffsetHeader is the header carrying the offset of a chunk of a resumable upload.
+const uploadOffsetHeader = "Upload-Offset"
+
+// startUpload handles the request to start a resumable video upload, for videos too large to send in a single request.
+// It is a JWT protected route.
+//
+// It expects a JSON payload with the following format:
+//
+//	{
+//	    "file_name": "video.mp4",
+//	    "size": 4294967296, (bytes)
+//	    "training_mode": "gaussian",
+//	    "output_types": ["splat_cloud"],
+//	    "save_iterations": [7000, 30000],
+//	    "total_iterations": 30000,
+//	    "frame_sample_rate": 2, (optional, or "target_frame_count")
+//	    "scene_name": "name", (optional)
+//	    "priority": "normal", (optional)
+//	    "preset": "splat-only", (optional, fills in the training config values left out, see getTrainingPresets)
+//	    "org_id": "..." (optional, the organization to upload the scene into the workspace of, as in postNewScene)
+//	}
+//
+// The video is then sent in chunks with PATCH /user/scene/upload/:upload_id, and the scene is created with
+// POST /user/scene/upload/:upload_id/complete.
+func (s *WebServer) startUpload(c *fiber.Ctx) error {
+	s.logger.Debug("Start upload request received")
+
+	var req StartUploadRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Start upload request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	if req.TrainingMode == "tensorf" {
+		s.logger.Debug("Tensorf training mode is now deprecated. Please use gaussian training mode.")
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Tensorf training mode is now deprecated. Please use gaussian training mode."})
+	}
+
+	orgID, err := parseOptionalObjectID(req.OrgID)
+	if err != nil {
+		s.logger.Debug("Invalid organization ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid organization ID"})
+	}
+
+	status, err := s.clientService.StartUpload(
+		c.UserContext(),
+		userID,
+		orgID,
+		req.FileName,
+		req.Size,
+		req.TrainingMode,
+		req.OutputTypes,
+		req.SaveIterations,
+		req.TotalIterations,
+		req.FrameSampleRate,
+		req.TargetFrameCount,
+		req.SceneName,
+		req.Priority,
+	)
+	if err != nil {
+		s.logger.Debug("Failed to start upload: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusCreated).JSON(status)
+}
+
+// getUpload handles the request to get the progress of a resumable upload, i.e to find the offset to resume it from.
+// It is a JWT protected route.
+//
+// It expects path parameter `upload_id`.
+func (s *WebServer) getUpload(c *fiber.Ctx) error {
+	s.logger.Debug("Get upload request received")
+
+	var req UploadRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get upload request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, uploadID, err := s.uploadIDs(c, req.UploadID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	status, err := s.clientService.GetUpload(c.UserContext(), userID, uploadID)
+	if err != nil {
+		s.logger.Debug("Failed to get upload: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// appendUpload handles the request to append a chunk to a resumable upload. It is a JWT protected route.
+//
+// It expects path parameter `upload_id`, the `Upload-Offset` header with the number of bytes the upload has received,
+// and the raw bytes of the chunk as the body. Chunks are limited by the body limit of the server.
+func (s *WebServer) appendUpload(c *fiber.Ctx) error {
+	s.logger.Debug("Append upload request received")
+
+	// The body is the chunk, so only the path parameters are parsed
+	req := UploadRequest{UploadID: c.Params("upload_id")}
+	if err := validate.Struct(req); err != nil {
+		s.logger.Debug("Append upload request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+	offset, err := strconv.ParseInt(c.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		s.logger.Debug("Invalid upload offset: ", c.Get(uploadOffsetHeader))
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or missing " + uploadOffsetHeader + " header"})
+	}
+
+	userID, uploadID, err := s.uploadIDs(c, req.UploadID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	status, err := s.clientService.AppendUpload(c.UserContext(), userID, uploadID, offset, bytes.NewReader(c.Body()))
+	if err != nil {
+		s.logger.Debug("Failed to append to upload: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	c.Set(uploadOffsetHeader, strconv.FormatInt(status.Offset, 10))
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// completeUpload handles the request to complete a resumable upload that has received every byte, which creates the
+// scene and starts processing it. It is a JWT protected route.
+//
+// It expects path parameter `upload_id`.
+func (s *WebServer) completeUpload(c *fiber.Ctx) error {
+	s.logger.Debug("Complete upload request received")
+
+	var req UploadRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Complete upload request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, uploadID, err := s.uploadIDs(c, req.UploadID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	sceneID, err := s.clientService.CompleteUpload(c.UserContext(), userID, uploadID)
+	if err != nil {
+		s.logger.Debug("Failed to complete upload: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	s.logger.Debugf("Upload completed and processing scene %s. Check back later for updates.\n", sceneID)
+	return s.sceneAccepted(c, sceneID, "Video received and processing scene. Check back later for updates.", worker.KindSfm, worker.KindNerf)
+}
+
+// abortUpload handles the request to abort a resumable upload, removing every byte it received. It is a JWT protected route.
+//
+// It expects path parameter `upload_id`.
+func (s *WebServer) abortUpload(c *fiber.Ctx) error {
+	s.logger.Debug("Abort upload request received")
+
+	var req UploadRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Abort upload request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, uploadID, err := s.uploadIDs(c, req.UploadID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := s.clientService.AbortUpload(c.UserContext(), userID, uploadID); err != nil {
+		s.logger.Debug("Failed to abort upload: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Upload aborted"})
+}
+
+// uploadIDs parses the user ID of the session and the given upload ID of a resumable upload request.
+func (s *WebServer) uploadIDs(c *fiber.Ctx, uploadIDHex string) (primitive.ObjectID, primitive.ObjectID, error) {
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return primitive.NilObjectID, primitive.NilObjectID, errors.New("Invalid user ID")
+	}
+	uploadID, err := primitive.ObjectIDFromHex(uploadIDHex)
+	if err != nil {
+		s.logger.Debug("Invalid upload ID: ", err.Error())
+		return primitive.NilObjectID, primitive.NilObjectID, errors.New("Invalid upload ID")
+	}
+	return userID, uploadID, nil
+}
+
+// postNewSceneBundle handles the request to create a new scene from images and pre-computed camera poses, which skips
+// the sfm stage. It is a JWT protected route.
+//
+// It expects a multipart form with a `poses` file in the transforms.json format, repeated `images` files, and the
+// training config fields of postNewScene, except frame sampling, and optionally its `org_id`.
+func (s *WebServer) postNewSceneBundle(c *fiber.Ctx) error {
+	s.logger.Debug("New Scene Bundle Request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	req, err := ParseNewSceneBundleRequest(c)
+	if err != nil {
+		s.logger.Debug("Bundle upload request parsing failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	if req.TrainingMode == "tensorf" {
+		s.logger.Debug("Tensorf training mode is now deprecated. Please use gaussian training mode.")
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Tensorf training mode is now deprecated. Please use gaussian training mode."})
+	}
+
+	orgID, err := parseOptionalObjectID(req.OrgID)
+	if err != nil {
+		s.logger.Debug("Invalid organization ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid organization ID"})
+	}
+
+	sceneID, err := s.clientService.HandleIncomingBundle(
+		c.UserContext(),
+		userID,
+		orgID,
+		req.Poses,
+		req.Images,
+		req.TrainingMode,
+		req.OutputTypes,
+		req.SaveIterations,
+		req.TotalIterations,
+		req.SceneName,
+		req.Priority,
+	)
+	if err != nil {
+		s.logger.Debug("Bundle processing failed:", err.Error())
+		return s.sendError(c, err)
+	}
+
+	s.logger.Debugf("Bundle received and training scene %s. Check back later for updates.\n", sceneID)
+	return s.sceneAccepted(c, sceneID, "Bundle received and training scene. Check back later for updates.", worker.KindNerf)
+}
+
+// postNewSceneBatch handles the request to create a scene for each of several videos, with one training config. It is
+// a JWT protected route. The response holds the ID of the batch, whose status is polled with getBatch, and the status
+// of each video. Videos that are rejected do not reject the others, unless every video is rejected.
+//
+// It expects a multipart form with repeated `files` videos, and optionally `upload_ids`, a comma-separated list of
+// resumable uploads that received every byte, in place of or besides them. At most 50 videos are accepted in total.
+// The body limit applies to the whole request, so videos that do not fit in it together are sent as resumable uploads.
+// The scenes are named after their file, prefixed by `batch_name` if set. The other fields are those of postNewScene,
+// except `scene_name` and `sha256`, and apply to every video, including those of the uploads.
+func (s *WebServer) postNewSceneBatch(c *fiber.Ctx) error {
+	s.logger.Debug("New Scene Batch Request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	req, err := ParseNewSceneBatchRequest(c)
+	if err != nil {
+		s.logger.Debug("Batch upload request parsing failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	if req.TrainingMode == "tensorf" {
+		s.logger.Debug("Tensorf training mode is now deprecated. Please use gaussian training mode.")
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Tensorf training mode is now deprecated. Please use gaussian training mode."})
+	}
+
+	orgID, err := parseOptionalObjectID(req.OrgID)
+	if err != nil {
+		s.logger.Debug("Invalid organization ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid organization ID"})
+	}
+	uploadIDs := make([]primitive.ObjectID, len(req.UploadIDs))
+	for i, id := range req.UploadIDs {
+		if uploadIDs[i], err = primitive.ObjectIDFromHex(id); err != nil {
+			s.logger.Debug("Invalid upload ID: ", err.Error())
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid upload ID"})
+		}
+	}
+
+	status, err := s.clientService.HandleIncomingBatch(c.UserContext(), userID, orgID, services.BatchUpload{
+		Name:             req.BatchName,
+		Files:            req.Files,
+		UploadIDs:        uploadIDs,
+		TrainingMode:     req.TrainingMode,
+		OutputTypes:      req.OutputTypes,
+		SaveIterations:   req.SaveIterations,
+		TotalIterations:  req.TotalIterations,
+		FrameSampleRate:  req.FrameSampleRate,
+		TargetFrameCount: req.TargetFrameCount,
+		Priority:         req.Priority,
+		ReuseSfm:         req.ReuseSfm,
+	})
+	if err != nil {
+		s.logger.Debug("Batch processing failed:", err.Error())
+		return s.sendError(c, err)
+	}
+
+	s.logger.Debugf("Batch %s received and processing %d of %d scenes.\n", status.ID, status.Total-status.Rejected, status.Total)
+	response := fiber.Map{"id": status.ID, "message": "Videos received and processing scenes. Check back later for updates.", "batch": status}
+	if warning := s.clientService.WorkerAvailabilityWarning(c.UserContext(), worker.KindSfm, worker.KindNerf); warning != "" {
+		response["warning"] = warning
+	}
+	return c.Status(fiber.StatusAccepted).JSON(response)
+}
+
+// getBatch handles the request to get the status of a batch upload, and of each of its scenes. It is a JWT protected
+// route.
+//
+// It expects path parameter `batch_id`.
+func (s *WebServer) getBatch(c *fiber.Ctx) error {
+	s.logger.Debug("Get batch request received")
+
+	var req BatchRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get batch request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+	batchID, err := primitive.ObjectIDFromHex(req.BatchID)
+	if err != nil {
+		s.logger.Debug("Invalid batch ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid batch ID"})
+	}
+
+	status, err := s.clientService.GetBatch(c.UserContext(), userID, batchID)
+	if err != nil {
+		s.logger.Debug("Failed to get batch: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// getSceneMetadata handles the request to get the metadata for a scene. It is a JWT protected route.
+//
+// It expects path parameter `scene_id`.
+// The user can optionally specify a query parameter `chunk_size` (bytes) used to compute chunk counts.
+// The chunk size actually used is echoed back in the response.
+// The user can optionally specify a query parameter `version` to get the metadata of a previous training run of the
+// scene, see /user/scene/retrain. The current run is used otherwise.
+//
+// The response carries an ETag and Last-Modified, and If-None-Match or If-Modified-Since yield 304 Not Modified while
+// the outputs of the scene are unchanged.
+func (s *WebServer) getSceneMetadata(c *fiber.Ctx) error {
+	s.logger.Debug("Get scene metadata request received")
+
+	var req GetSceneMetadataRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get job data request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid job ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid job ID"})
+	}
+
+	sceneData, err := s.clientService.GetSceneMetadata(c.UserContext(), userID, sceneID, req.ChunkSize, req.Version)
+	if err != nil {
+		s.logger.Debug("Failed to get job data: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	sceneJson, err := json.Marshal(sceneData)
+	if err != nil {
+		s.logger.Debug("Failed to marshal job data: ", err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	s.logger.Debug(fmt.Sprintf("Job data retrieved successfully, data: %s", sceneJson))
+	return sendMetadata(c, sceneJson, sceneData.ModifiedAt)
+}
+
+// getBatchSceneMetadata handles the request to get the metadata of many scenes at once. It is a JWT protected route.
+//
+// It expects a JSON payload with the following format:
+//
+//	{
+//	    "scene_ids": ["scene_id", ...], (at most services.MaxBatchMetadataScenes)
+//	    "output_type": "video", (optional, only include this output type)
+//	    "chunk_size": 1048576 (optional)
+//	}
+//
+// The response maps each scene ID to its metadata, or to the error for that scene (i.e, not found).
+func (s *WebServer) getBatchSceneMetadata(c *fiber.Ctx) error {
+	s.logger.Debug("Get batch scene metadata request received")
+
+	var req GetBatchSceneMetadataRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get batch scene metadata request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneIDs := make([]primitive.ObjectID, 0, len(req.SceneIDs))
+	for _, hex := range req.SceneIDs {
+		sceneID, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			s.logger.Debug("Invalid scene ID: ", err.Error())
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID " + hex})
+		}
+		sceneIDs = append(sceneIDs, sceneID)
+	}
+
+	results, err := s.clientService.GetBatchSceneMetadata(c.UserContext(), userID, sceneIDs, req.OutputType, req.ChunkSize)
+	if err != nil {
+		s.logger.Debug("Failed to get batch scene metadata: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"scenes": results})
+}
+
+// getUserSceneHistory handles the request to get the history of scenes for a user. It is a JWT protected route.
+func (s *WebServer) getUserSceneHistory(c *fiber.Ctx) error {
+	s.logger.Debug("Get user history request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneIDList, err := s.clientService.GetUserSceneHistory(c.UserContext(), userID)
+	if err != nil {
+		s.logger.Debug("Failed to get user history: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	s.logger.Debug("User history retrieved successfully")
+	return c.Status(http.StatusOK).JSON(fiber.Map{"resources": sceneIDList})
+}
+
+// getUserHistory handles the request to get a page of the user's scenes, with a summary of each. It is a JWT protected
+// route.
+//
+// It optionally expects query parameters `stage` (one of queued, training, done, failed, cancelled), `page`
+// (1-indexed), `page_size`, and `sort` (newest, the default, or oldest).
+func (s *WebServer) getUserHistory(c *fiber.Ctx) error {
+	s.logger.Debug("Get user history page request received")
+
+	var req GetUserHistoryRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get user history request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	page, err := s.clientService.GetUserHistory(c.UserContext(), userID, services.HistoryQuery{
+		Stage:       req.Stage,
+		Page:        req.Page,
+		PageSize:    req.PageSize,
+		OldestFirst: req.Sort == "oldest",
+	})
+	if err != nil {
+		s.logger.Debug("Failed to get user history: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(page)
+}
+
+// searchScenes handles the request to search the user's scenes by name, description, and tags. It is a JWT protected
+// route.
+//
+// It expects query parameter `q`, the words to search for, and/or `tags`, a comma separated list of tags every
+// matched scene has. It optionally expects `stage` (one of queued, training, done, failed, cancelled), `page`
+// (1-indexed), and `page_size`. The response is a page of scenes as for /user/scene/list, best match first.
+func (s *WebServer) searchScenes(c *fiber.Ctx) error {
+	s.logger.Debug("Search scenes request received")
+
+	var req SearchScenesRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Search scenes request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var tags []string
+	if req.Tags != "" {
+		tags = strings.Split(req.Tags, ",")
+	}
+	page, err := s.clientService.SearchScenes(c.UserContext(), userID, services.SceneSearchQuery{
+		Text:     req.Query,
+		Tags:     tags,
+		Stage:    req.Stage,
+		Page:     req.Page,
+		PageSize: req.PageSize,
+	})
+	if err != nil {
+		s.logger.Debug("Failed to search scenes: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(page)
+}
+
+// getSceneThumbnail handles the request to get the thumbnail for a scene. It is a JWT protected route.
+//
+// It expects path parameter `scene_id`.
+// The response is a JPEG, or a PNG for scenes without a video or images to extract one from. It carries an ETag, and
+// requests with a matching If-None-Match header get 304 Not Modified.
+func (s *WebServer) getSceneThumbnail(c *fiber.Ctx) error {
+	s.logger.Debug("Get scene thumbnail request received")
+
+	var req GetSceneThumbnailRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get scene thumbnail request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	thumbnailPath, err := s.clientService.GetSceneThumbnailPath(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to get scene thumbnail: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	// Thumbnails are replaced once a newer video is rendered, so clients must revalidate their cached copy
+	c.Set("Cache-Control", "private, no-cache")
+	s.logger.Debug("Scene thumbnail retrieved successfully")
+	return s.sendFileWithRangeSupport(c, thumbnailPath, "")
+}
+
+// compareIterations handles the request to compare two save iterations of a scene. It is a JWT protected route.
+//
+// It expects path parameter `scene_id` and query parameters `from` and `to`, two save iterations of the scene.
+// The response lists the outputs of both iterations side by side, with the differences in size and training time, and
+// whether a comparison frame can be requested.
+func (s *WebServer) compareIterations(c *fiber.Ctx) error {
+	s.logger.Debug("Compare iterations request received")
+
+	var req CompareIterationsRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Compare iterations request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	comparison, err := s.clientService.CompareIterations(c.UserContext(), userID, sceneID, req.From, req.To)
+	if err != nil {
+		s.logger.Debug("Failed to compare iterations: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(comparison)
+}
+
+// getComparisonFrame handles the request to get the same frame of the rendered videos of two save iterations of a
+// scene side by side. It is a JWT protected route.
+//
+// It expects path parameter `scene_id` and query parameters `from` and `to`, two save iterations of the scene.
+// The user can optionally specify a query parameter `at`, the offset of the frame into the videos in seconds.
+// The response is a JPEG with iteration `from` on the left and iteration `to` on the right.
+func (s *WebServer) getComparisonFrame(c *fiber.Ctx) error {
+	s.logger.Debug("Get comparison frame request received")
+
+	var req GetComparisonFrameRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get comparison frame request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	offset := time.Duration(req.At * float64(time.Second))
+	frame, err := s.clientService.GetComparisonFrame(c.UserContext(), userID, sceneID, req.From, req.To, offset)
+	if err != nil {
+		s.logger.Debug("Failed to get comparison frame: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	c.Set(fiber.HeaderContentType, "image/jpeg")
+	c.Set("Cache-Control", "private, no-cache")
+	return c.Status(http.StatusOK).Send(frame)
+}
+
+// getScenePreview handles the request to get a looping preview clip for a scene. It is a JWT protected route.
+//
+// It expects path parameter `scene_id`.
+// The response is an MP4 with Range support, or the thumbnail if the scene does not yet have enough rendered frames for
+// a clip.
+func (s *WebServer) getScenePreview(c *fiber.Ctx) error {
+	s.logger.Debug("Get scene preview request received")
+
+	var req GetScenePreviewRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get scene preview request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	previewPath, err := s.clientService.GetScenePreviewPath(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to get scene preview: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return s.sendFileWithRangeSupport(c, previewPath, "")
+}
+
+// getSceneName handles the request to get the name of a scene. It is a JWT protected route.
+//
+// It expects path parameter `scene_id`.
+func (s *WebServer) getSceneName(c *fiber.Ctx) error {
+	s.logger.Debug("Get scene name request received")
+
+	var req GetSceneNameRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get scene name request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneName, err := s.clientService.GetSceneName(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to get scene name: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"name": sceneName})
+}
+
+// getSceneAuditLog handles the request to get the access audit log of a scene. It is a JWT protected route, only
+// allowed for the scene's owner and admins.
+//
+// It expects path parameter `scene_id`.
+func (s *WebServer) getSceneAuditLog(c *fiber.Ctx) error {
+	s.logger.Debug("Get scene audit log request received")
+
+	var req GetSceneAuditLogRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get scene audit log request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	events, err := s.clientService.GetSceneAuditLog(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to get scene audit log: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"events": events})
+}
+
+// shareScene handles the request to share a scene with another user, or change their role on it. It is a JWT protected
+// route, only allowed for the scene's owner and admins.
+//
+// It expects path parameter `scene_id`, and a JSON payload with the following format:
+//
+//	{
+//	    "username": "collaborator@example.com",
+//	    "role": "viewer" (or "editor")
+//	}
+//
+// Viewers may read the scene's metadata and status and download its outputs, editors may also cancel and retry its jobs.
+func (s *WebServer) shareScene(c *fiber.Ctx) error {
+	s.logger.Debug("Share scene request received")
+
+	var req ShareSceneRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Share scene request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	err = s.clientService.ShareScene(c.UserContext(), userID, sceneID, req.Username, req.Role)
+	if err != nil {
+		s.logger.Debug("Failed to share scene: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Scene shared"})
+}
+
+// unshareScene handles the request to stop sharing a scene with another user. It is a JWT protected route, only allowed
+// for the scene's owner and admins.
+//
+// It expects path parameter `scene_id`, and a JSON payload with the `username` of the collaborator to remove.
+func (s *WebServer) unshareScene(c *fiber.Ctx) error {
+	s.logger.Debug("Unshare scene request received")
+
+	var req UnshareSceneRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Unshare scene request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	err = s.clientService.UnshareScene(c.UserContext(), userID, sceneID, req.Username)
+	if err != nil {
+		s.logger.Debug("Failed to unshare scene: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Scene unshared"})
+}
+
+// getSceneCollaborators handles the request to list the users a scene is shared with. It is a JWT protected route, only
+// allowed for the scene's owner and admins.
+//
+// It expects path parameter `scene_id`.
+func (s *WebServer) getSceneCollaborators(c *fiber.Ctx) error {
+	s.logger.Debug("Get scene collaborators request received")
+
+	var req GetSceneCollaboratorsRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get scene collaborators request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	collaborators, err := s.clientService.GetSceneCollaborators(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to get scene collaborators: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"collaborators": collaborators})
+}
+
+// createShareLink handles the request to create a public share link of a scene. It is a JWT protected route, only
+// allowed for the scene's owner and admins.
+//
+// It expects path parameter `scene_id`, and optionally a JSON payload with the lifetime of the link in seconds:
+//
+//	{
+//	    "ttl": 604800 (optional, the link never expires if not given)
+//	}
+//
+// The returned token gives anyone holding it read-only access through the /shared routes, until it expires or is revoked.
+func (s *WebServer) createShareLink(c *fiber.Ctx) error {
+	s.logger.Debug("Create share link request received")
+
+	var req CreateShareLinkRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Create share link request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	link, err := s.clientService.CreateShareLink(c.UserContext(), userID, sceneID, time.Duration(req.TTL)*time.Second)
+	if err != nil {
+		s.logger.Debug("Failed to create share link: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusCreated).JSON(link)
+}
+
+// getShareLinks handles the request to list the public share links of a scene, with their tokens. It is a JWT protected
+// route, only allowed for the scene's owner and admins.
+//
+// It expects path parameter `scene_id`.
+func (s *WebServer) getShareLinks(c *fiber.Ctx) error {
+	s.logger.Debug("Get share links request received")
+
+	var req GetShareLinksRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get share links request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	links, err := s.clientService.GetShareLinks(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to get share links: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"share_links": links})
+}
+
+// revokeShareLink handles the request to revoke a public share link of a scene. It is a JWT protected route, only
+// allowed for the scene's owner and admins.
+//
+// It expects path parameters `scene_id` and `link_id`.
+func (s *WebServer) revokeShareLink(c *fiber.Ctx) error {
+	s.logger.Debug("Revoke share link request received")
+
+	var req RevokeShareLinkRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Revoke share link request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	linkID, err := primitive.ObjectIDFromHex(req.LinkID)
+	if err != nil {
+		s.logger.Debug("Invalid link ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid link ID"})
+	}
+
+	err = s.clientService.RevokeShareLink(c.UserContext(), userID, sceneID, linkID)
+	if err != nil {
+		s.logger.Debug("Failed to revoke share link: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Share link revoked"})
+}
+
+// createOrg handles the request to create an organization, with the user as its admin. It is a JWT protected route.
+//
+// It expects a JSON payload with the `name` of the organization.
+func (s *WebServer) createOrg(c *fiber.Ctx) error {
+	s.logger.Debug("Create organization request received")
+
+	var req CreateOrgRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Create organization request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	summary, err := s.clientService.CreateOrg(c.UserContext(), userID, req.Name)
+	if err != nil {
+		s.logger.Debug("Failed to create organization: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusCreated).JSON(summary)
+}
+
+// listOrgs handles the request to list the organizations the user is a member of or invited to. It is a JWT protected
+// route.
+func (s *WebServer) listOrgs(c *fiber.Ctx) error {
+	s.logger.Debug("List organizations request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	orgs, err := s.clientService.ListOrgs(c.UserContext(), userID)
+	if err != nil {
+		s.logger.Debug("Failed to list organizations: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"organizations": orgs})
+}
+
+// getOrg handles the request to get an organization with its members. It is a JWT protected route, only allowed for
+// its members. Pending invitations are only included for admins.
+//
+// It expects path parameter `org_id`.
+func (s *WebServer) getOrg(c *fiber.Ctx) error {
+	s.logger.Debug("Get organization request received")
+
+	var req OrgRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get organization request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, orgID, err := s.orgIDs(c, req.OrgID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	details, err := s.clientService.GetOrg(c.UserContext(), userID, orgID)
+	if err != nil {
+		s.logger.Debug("Failed to get organization: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(details)
+}
+
+// deleteOrg handles the request to delete an organization. It is a JWT protected route, only allowed for its admins.
+// The scenes of its workspace stay with their owners.
+//
+// It expects path parameter `org_id`.
+func (s *WebServer) deleteOrg(c *fiber.Ctx) error {
+	s.logger.Debug("Delete organization request received")
+
+	var req OrgRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Delete organization request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, orgID, err := s.orgIDs(c, req.OrgID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := s.clientService.DeleteOrg(c.UserContext(), userID, orgID); err != nil {
+		s.logger.Debug("Failed to delete organization: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Organization deleted"})
+}
+
+// inviteOrgMember handles the request to invite a user to an organization, or change the role they are invited in. It
+// is a JWT protected route, only allowed for the organization's admins.
+//
+// It expects path parameter `org_id`, and a JSON payload with the following format:
+//
+//	{
+//	    "username": "member@example.com",
+//	    "role": "member" (or "admin", "viewer")
+//	}
+//
+// Admins may do anything with the workspace's scenes, members may also upload into the workspace and train and edit
+// its scenes, and viewers may only read them.
+func (s *WebServer) inviteOrgMember(c *fiber.Ctx) error {
+	s.logger.Debug("Invite organization member request received")
+
+	var req InviteOrgMemberRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Invite organization member request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, orgID, err := s.orgIDs(c, req.OrgID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	err = s.clientService.InviteOrgMember(c.UserContext(), userID, orgID, req.Username, req.Role)
+	if err != nil {
+		s.logger.Debug("Failed to invite organization member: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "User invited"})
+}
+
+// removeOrgInvitation handles the request to withdraw or decline an invitation to an organization. It is a JWT protected
+// route, allowed for the organization's admins and the invited user.
+//
+// It expects path parameters `org_id` and `user_id`, the ID of the invited user.
+func (s *WebServer) removeOrgInvitation(c *fiber.Ctx) error {
+	s.logger.Debug("Remove organization invitation request received")
+
+	var req OrgMemberRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Remove organization invitation request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, orgID, err := s.orgIDs(c, req.OrgID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	targetID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		s.logger.Debug("Invalid invited user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid invited user ID"})
+	}
+
+	if err := s.clientService.RemoveOrgInvitation(c.UserContext(), userID, orgID, targetID); err != nil {
+		s.logger.Debug("Failed to remove organization invitation: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Invitation removed"})
+}
+
+// joinOrg handles the request to accept an invitation to an organization. It is a JWT protected route.
+//
+// It expects path parameter `org_id`. The response holds the `role` the user joined in.
+func (s *WebServer) joinOrg(c *fiber.Ctx) error {
+	s.logger.Debug("Join organization request received")
+
+	var req OrgRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Join organization request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, orgID, err := s.orgIDs(c, req.OrgID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	role, err := s.clientService.AcceptOrgInvitation(c.UserContext(), userID, orgID)
+	if err != nil {
+		s.logger.Debug("Failed to join organization: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Joined organization", "role": role})
+}
+
+// setOrgMemberRole handles the request to change the role of a member of an organization. It is a JWT protected route,
+// only allowed for the organization's admins. The last admin cannot be demoted.
+//
+// It expects path parameters `org_id` and `user_id`, and a JSON payload with the new `role`.
+func (s *WebServer) setOrgMemberRole(c *fiber.Ctx) error {
+	s.logger.Debug("Set organization member role request received")
+
+	var req SetOrgMemberRoleRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Set organization member role request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, orgID, err := s.orgIDs(c, req.OrgID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	targetID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		s.logger.Debug("Invalid member ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid member ID"})
+	}
+
+	if err := s.clientService.SetOrgMemberRole(c.UserContext(), userID, orgID, targetID, req.Role); err != nil {
+		s.logger.Debug("Failed to set organization member role: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Member role updated"})
+}
+
+// removeOrgMember handles the request to remove a member from an organization. It is a JWT protected route, allowed for
+// the organization's admins, and for members leaving it. The last admin cannot leave.
+//
+// It expects path parameters `org_id` and `user_id`.
+func (s *WebServer) removeOrgMember(c *fiber.Ctx) error {
+	s.logger.Debug("Remove organization member request received")
+
+	var req OrgMemberRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Remove organization member request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, orgID, err := s.orgIDs(c, req.OrgID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	targetID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		s.logger.Debug("Invalid member ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid member ID"})
+	}
+
+	if err := s.clientService.RemoveOrgMember(c.UserContext(), userID, orgID, targetID); err != nil {
+		s.logger.Debug("Failed to remove organization member: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Member removed"})
+}
+
+// listOrgScenes handles the request to get a page of the scenes in an organization's workspace, summarized as in
+// getUserHistory. It is a JWT protected route, only allowed for the organization's members.
+//
+// It expects path parameter `org_id`, and optionally the query parameters of getUserHistory.
+func (s *WebServer) listOrgScenes(c *fiber.Ctx) error {
+	s.logger.Debug("List organization scenes request received")
+
+	var req ListOrgScenesRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("List organization scenes request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, orgID, err := s.orgIDs(c, req.OrgID)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	page, err := s.clientService.ListOrgScenes(c.UserContext(), userID, orgID, services.HistoryQuery{
+		Stage:       req.Stage,
+		Page:        req.Page,
+		PageSize:    req.PageSize,
+		OldestFirst: req.Sort == "oldest",
+	})
+	if err != nil {
+		s.logger.Debug("Failed to list organization scenes: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(page)
+}
+
+// orgIDs parses the user ID of the session and the given organization ID of an organization request.
+func (s *WebServer) orgIDs(c *fiber.Ctx, orgIDHex string) (primitive.ObjectID, primitive.ObjectID, error) {
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return primitive.NilObjectID, primitive.NilObjectID, errors.New("Invalid user ID")
+	}
+	orgID, err := primitive.ObjectIDFromHex(orgIDHex)
+	if err != nil {
+		s.logger.Debug("Invalid organization ID: ", err.Error())
+		return primitive.NilObjectID, primitive.NilObjectID, errors.New("Invalid organization ID")
+	}
+	return userID, orgID, nil
+}
+
+// parseOptionalObjectID parses an optional ID, returning the nil ID if it is empty.
+func parseOptionalObjectID(hex string) (primitive.ObjectID, error) {
+	if hex == "" {
+		return primitive.NilObjectID, nil
+	}
+	return primitive.ObjectIDFromHex(hex)
+}
+
+// getSharedSceneMetadata handles the request to get the metadata of a scene through a share link. It is authorized by
+// the share link token instead of a session.
+//
+// It expects path parameter `token`, and optionally query parameter `chunk_size` as in getSceneMetadata.
+func (s *WebServer) getSharedSceneMetadata(c *fiber.Ctx) error {
+	s.logger.Debug("Get shared scene metadata request received")
+
+	var req GetSharedSceneMetadataRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get shared scene metadata request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	metadata, err := s.clientService.GetSharedSceneMetadata(c.UserContext(), req.Token, req.ChunkSize)
+	if err != nil {
+		s.logger.Debug("Failed to get shared scene metadata: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		s.logger.Debug("Failed to marshal shared scene metadata: ", err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return sendMetadata(c, body, metadata.ModifiedAt)
+}
+
+// getSharedSceneOutput handles the request to get an output of a scene through a share link. It is authorized by the
+// share link token instead of a session.
+//
+// It expects path parameters `token` and `output_type`, and accepts the same query parameters as getSceneOutput.
+// Responses must be revalidated, so a revoked link stops working for cached copies too.
+func (s *WebServer) getSharedSceneOutput(c *fiber.Ctx) error {
+	s.logger.Debug("Get shared scene output request received")
+
+	var req GetSharedSceneOutputRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get shared scene output request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	output, err := s.clientService.GetSharedSceneOutput(c.UserContext(), req.Token, req.OutputType, req.Iteration, req.Format)
+	if err != nil {
+		s.logger.Debug("Failed to get shared scene output: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	if output.Final {
+		c.Set("Cache-Control", "public, no-cache")
+	} else {
+		c.Set("Cache-Control", "no-store")
+	}
+	setContentDisposition(c, output)
+
+	return s.sendOutput(c, primitive.NilObjectID, req.OutputType, output.Path, req.Chunk, req.ChunkSize, output.Final)
+}
+
+// getSceneOutput handles the request to get the output for a scene. It is a JWT protected route.
+// 
+// It expects a path parameters `scene_id` `output_type`.
+// 
+// The user can optionally specify a query parameter `iteration` to get the output at a specific iteration.
+// If the iteration is not specified, the latest output is given.
+//
+// The user can optionally specify query parameters `chunk` (0-indexed) and `chunk_size` to get a single chunk
+// of the output, using the same chunk layout reported by the metadata route. Otherwise the Range header is honored.
+//
+// The user can optionally specify a query parameter `format` to get the output converted to a browser friendly format
+// (i.e, `webm` for video). Unsupported conversions respond with the formats that are available.
+//
+// The user can optionally specify a query parameter `version` to get the output of a previous training run of the
+// scene, see /user/scene/retrain. The current run is used otherwise.
+func (s *WebServer) getSceneOutput(c *fiber.Ctx) error {
+	s.logger.Debug("Get scene output request received")
+
+	var req GetSceneOutputRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get scene output request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", req.SceneID)
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", c.Locals("userID").(string))
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	output, err := s.clientService.GetSceneOutput(c.UserContext(), userID, sceneID, req.OutputType, req.Iteration, req.Format, req.Version)
+	if err != nil {
+		s.logger.Debugf("Failed to get scene output: ", err.Error())
+		return s.sendError(c, err)
+	}
+	outputPath := output.Path
+
+	// Output of a given iteration is never rewritten once final, but the latest output changes as training progresses
+	switch {
+	case !output.Final:
+		c.Set("Cache-Control", "no-store")
+	case req.Iteration != "":
+		c.Set("Cache-Control", "private, max-age=31536000, immutable")
+	default:
+		c.Set("Cache-Control", "private, no-cache")
+	}
+	setContentDisposition(c, output)
+
+	return s.sendOutput(c, userID, req.OutputType, outputPath, req.Chunk, req.ChunkSize, output.Final)
+}
+
+// getSceneArchive handles the request to download every output of a scene as one archive. It is a JWT protected route.
+//
+// It expects path parameter `scene_id`, and optionally query parameter `format`, either `zip` (default) or `tar.gz`.
+//
+// The archive is generated while it is sent, with chunked transfer encoding, so it has no Content-Length and cannot be
+// requested in ranges. It holds the outputs of every completed iteration, under a directory per output type.
+func (s *WebServer) getSceneArchive(c *fiber.Ctx) error {
+	s.logger.Debug("Get scene archive request received")
+
+	var req GetSceneArchiveRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get scene archive request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", req.SceneID)
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", c.Locals("userID").(string))
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	archive, err := s.clientService.GetSceneArchive(c.UserContext(), userID, sceneID, req.Format)
+	if err != nil {
+		s.logger.Debug("Failed to get scene archive: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	c.Set("Content-Type", archive.ContentType)
+	c.Set("Cache-Control", "no-store")
+	if header := mime.FormatMediaType("attachment", map[string]string{"filename": archive.FileName}); header != "" {
+		c.Set("Content-Disposition", header)
+	}
+
+	// The archive is written to the pipe as the client reads it, and the response body is sent as it is read from
+	// the pipe. The response is sent after this handler returns, so the bytes sent are counted once it is written.
+	pr, pw := io.Pipe()
+	go func() {
+		counter := &countingWriter{w: pw}
+		err := archive.Write(counter)
+		if err != nil {
+			s.logger.Errorf("Failed to write archive of scene %s: %v", sceneID.Hex(), err)
+		}
+		pw.CloseWithError(err)
+
+		s.metrics.DownloadBytesTotal.WithLabelValues("archive").Add(float64(counter.n))
+		s.clientService.RecordDownload(context.Background(), userID, counter.n)
+	}()
+	c.Status(http.StatusOK)
+	c.Context().SetBodyStream(pr, -1)
+	return nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// sendOutput sends an output file, either the single chunk given by the `chunk` and `chunk_size` query parameters,
+// or with Range support if no chunk was requested. The bytes sent are counted by output type, and towards the daily
+// downloads of the user unless userID is nil.
+//
+// Final outputs are sent with the strong ETag of their content, so clients keep their cached copy for as long as the
+// content is unchanged. Outputs still being written, or whose content could not be hashed, get a weak ETag instead.
+func (s *WebServer) sendOutput(c *fiber.Ctx, userID primitive.ObjectID, outputType, outputPath, chunkParam string, chunkSize int64, final bool) error {
+	var etag string
+	if final {
+		var err error
+		etag, err = s.clientService.ContentETag(outputPath)
+		if err != nil {
+			s.logger.Errorf("Failed to get content ETag of %s: %v", outputPath, err)
+		}
+	}
+
+	var chunk int
+	if chunkParam != "" {
+		var err error
+		chunk, err = strconv.Atoi(chunkParam)
+		if err != nil {
+			s.logger.Debug("Invalid chunk: ", chunkParam)
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid chunk"})
+		}
+	}
+
+	// A throttled body is streamed, and counted once sent rather than here, see Throttle.go
+	download := s.throttleDownload(c, userID, outputType)
+	if download != nil {
+		c.Locals(throttledDownloadLocal, download)
+	}
+
+	var err error
+	if chunkParam != "" {
+		err = s.sendFileChunk(c, outputPath, chunk, s.clientService.ResolveChunkSize(chunkSize), etag)
+	} else {
+		err = s.sendFileWithRangeSupport(c, outputPath, etag)
+	}
+
+	if download != nil && download.streamed {
+		return err
+	}
+	if download != nil {
+		download.release()
+	}
+	s.countDownload(c, userID, outputType)
+	return err
+}
+
+// sendMetadata sends a JSON metadata body with a strong ETag of its content, and Last-Modified set to modTime unless
+// it is zero. Responses must be revalidated, and yield 304 Not Modified while the metadata is unchanged.
+func sendMetadata(c *fiber.Ctx, body []byte, modTime time.Time) error {
+	etag := contentETag(body)
+	c.Set("Cache-Control", "private, no-cache")
+	setFileValidators(c, etag, modTime)
+	if isNotModified(c, etag, modTime) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	c.Set("Content-Type", fiber.MIMEApplicationJSON)
+	return c.Status(http.StatusOK).Send(body)
+}
+
+// countDownload adds the body of a successful output response to the downloaded bytes of its output type, and of the
+// user unless userID is nil.
+func (s *WebServer) countDownload(c *fiber.Ctx, userID primitive.ObjectID, outputType string) {
+	if status := c.Response().StatusCode(); status == fiber.StatusOK || status == fiber.StatusPartialContent {
+		bytes := len(c.Response().Body())
+		s.metrics.DownloadBytesTotal.WithLabelValues(outputType).Add(float64(bytes))
+		if !userID.IsZero() {
+			s.clientService.RecordDownload(c.UserContext(), userID, int64(bytes))
+		}
+	}
+}
+
+// getResourceManifest handles the request to get the download manifest of an output of a scene. It is a JWT
+// protected route.
+//
+// It expects path parameters `scene_id` `output_type`, and optionally query parameters `iteration` (the latest
+// completed iteration if not given) and `chunk_size` (bytes).
+//
+// The manifest lists the byte range and checksum of every chunk, which are then fetched from getSceneOutput with the
+// same iteration and chunk size. Outputs that are still being written have no manifest.
+func (s *WebServer) getResourceManifest(c *fiber.Ctx) error {
+	s.logger.Debug("Get resource manifest request received")
+
+	var req GetResourceManifestRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get resource manifest request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", req.SceneID)
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", c.Locals("userID").(string))
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	manifest, err := s.clientService.GetResourceManifest(c.UserContext(), userID, sceneID, req.OutputType, req.Iteration, req.ChunkSize)
+	if err != nil {
+		s.logger.Debug("Failed to get resource manifest: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	if req.Iteration != "" {
+		c.Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		c.Set("Cache-Control", "private, no-cache")
+	}
+	return c.Status(http.StatusOK).JSON(manifest)
+}
+
+// getResourceURL handles the request to get a signed URL for the output of a scene. It is a JWT protected route.
+//
+// It expects path parameters `scene_id` `output_type`, and optionally query parameters `iteration` (the latest
+// completed iteration if not given) and `ttl` (the lifetime of the URL in seconds).
+//
+// The URL gives access to the output without a session until it expires, and can be handed to browsers and CDNs.
+// Outputs that are still being written have no signed URL.
+func (s *WebServer) getResourceURL(c *fiber.Ctx) error {
+	s.logger.Debug("Get resource URL request received")
+
+	var req GetResourceURLRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get resource URL request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", req.SceneID)
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", c.Locals("userID").(string))
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	ttl := time.Duration(req.TTL) * time.Second
+	resourceURL, err := s.clientService.GenerateResourceURL(c.UserContext(), userID, sceneID, req.OutputType, req.Iteration, ttl)
+	if err != nil {
+		s.logger.Debug("Failed to generate resource URL: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(resourceURL)
+}
+
+// getSignedResource handles the request to get an output through a signed URL, see getResourceURL.
+// It is not JWT protected, access is granted by the signature of the URL instead.
+//
+// The file of a signed URL never changes, so the response may be cached publicly until the URL expires.
+// The Range header and conditional requests are honored.
+func (s *WebServer) getSignedResource(c *fiber.Ctx) error {
+	s.logger.Debug("Get signed resource request received")
+
+	var req GetSignedResourceRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get signed resource request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	// Malformed IDs cannot carry a valid signature
+	sceneID, sceneErr := primitive.ObjectIDFromHex(req.SceneID)
+	userID, userErr := primitive.ObjectIDFromHex(req.UserID)
+	if sceneErr != nil || userErr != nil {
+		return s.sendError(c, services.ErrInvalidResourceSignature)
+	}
+
+	resource := &services.SignedResource{
+		UserID:     userID,
+		SceneID:    sceneID,
+		OutputType: req.OutputType,
+		Iteration:  req.Iteration,
+		Version:    req.Version,
+		Expires:    time.Unix(req.Expires, 0),
+	}
+	output, err := s.clientService.ResolveSignedResource(c.UserContext(), resource, req.Signature)
+	if err != nil {
+		s.logger.Debug("Failed to resolve signed resource: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	maxAge := max(int(time.Until(resource.Expires).Seconds()), 0)
+	c.Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", maxAge))
+	setContentDisposition(c, output)
+	return s.sendOutput(c, userID, resource.OutputType, output.Path, "", 0, output.Final)
+}
+
+// getSceneProgress handles the request to get the progress of a scene. It is a JWT protected route.
+//
+// It expects a path parameter `scene_id`.
+func (s *WebServer) getSceneProgress(c *fiber.Ctx) error {
+	s.logger.Debug("Get scene progress request received")
+
+	var req GetSceneProgressRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get scene progress request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	progress, err := s.clientService.GetSceneProgress(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to get scene progress: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(progress)
+}
+
+// getScene handles the request to get the details of a scene, i.e its config, video, status, and output progress.
+// It is a JWT protected route.
+//
+// It expects a path parameter `scene_id`.
+func (s *WebServer) getScene(c *fiber.Ctx) error {
+	s.logger.Debug("Get scene details request received")
+
+	var req GetSceneRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get scene details request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	details, err := s.clientService.GetScene(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to get scene details: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(details)
+}
+
+// updateSceneMetadata handles the request to rename a scene, and set its description and tags. It is a JWT protected
+// route, only allowed for the scene's owner and admins.
+//
+// It expects path parameter `scene_id`, and a JSON payload with any of the following fields, omitted fields are left
+// unchanged:
+//
+//	{
+//	    "name": "Living room",
+//	    "description": "Captured with a phone, 60 fps",
+//	    "tags": ["indoor", "phone"] (an empty list removes every tag)
+//	}
+//
+// Responds with the scene's name, description, and tags after the update.
+func (s *WebServer) updateSceneMetadata(c *fiber.Ctx) error {
+	s.logger.Debug("Update scene metadata request received")
+
+	var req UpdateSceneMetadataRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Update scene metadata request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	update := scene.SceneMetadataUpdate{Name: req.Name, Description: req.Description, Tags: req.Tags}
+	metadata, err := s.clientService.UpdateSceneMetadata(c.UserContext(), userID, sceneID, update)
+	if err != nil {
+		s.logger.Debug("Failed to update scene metadata: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(metadata)
+}
+
+// getSceneStatus handles the request to get the processing status of a scene. It is a JWT protected route.
+//
+// It expects a path parameter `scene_id`.
+func (s *WebServer) getSceneStatus(c *fiber.Ctx) error {
+	s.logger.Debug("Get scene status request received")
+
+	var req GetSceneStatusRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get scene status request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	status, err := s.clientService.GetSceneStatus(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to get scene status: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// getJobProgress handles the request to get the progress estimate of the job of a scene, i.e its percent complete and
+// ETA. It is a JWT protected route.
+//
+// It expects a path parameter `scene_id`.
+func (s *WebServer) getJobProgress(c *fiber.Ctx) error {
+	s.logger.Debug("Get job progress request received")
+
+	var req GetSceneStatusRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get job progress request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	progress, err := s.clientService.GetJobProgress(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to get job progress: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(progress)
+}
+
+// getJobError handles the request to get why the job of a failed scene failed. It is a JWT protected route.
+//
+// It expects a path parameter `scene_id`. Responds with 404 Not Found if the scene has not failed.
+func (s *WebServer) getJobError(c *fiber.Ctx) error {
+	s.logger.Debug("Get job error request received")
+
+	var req GetJobErrorRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get job error request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	jobError, err := s.clientService.GetJobError(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to get job error: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(jobError)
+}
+
+// streamSceneStatus handles the request to stream the status of a scene as Server-Sent Events. It is a JWT protected
+// route, which also accepts the access token in the `access_token` query parameter for EventSource clients.
+//
+// It expects a path parameter `scene_id`.
+//
+// `status` events carry the state of the scene (and the error of a failed scene), `progress` events carry the latest
+// progress report of the worker. The stream starts with the current status and ends once the scene finishes.
+func (s *WebServer) streamSceneStatus(c *fiber.Ctx) error {
+	s.logger.Debug("Stream scene status request received")
+
+	var req GetSceneStatusRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Stream scene status request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	// The stream outlives the handler, so it is cancelled by sendEventStream rather than by the request
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := s.clientService.GetJobStatusStream(ctx, userID, sceneID)
+	if err != nil {
+		cancel()
+		s.logger.Debug("Failed to stream scene status: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return s.sendEventStream(c, events, cancel)
+}
+
+// streamScenePreview handles the request to watch a scene train, as a WebSocket streaming the low resolution frames the
+// worker renders. It is a JWT protected route, which also accepts the access token in the `access_token` query
+// parameter for browser clients.
+//
+// It expects a path parameter `scene_id`.
+//
+// Each frame is sent as a text message `{"type": "preview", "iteration", "content_type", "size", "time"}` followed
+// by a binary message holding the image. Frames a client is too slow for are skipped. The socket is closed with
+// status 1000 once the scene finishes. Requests that are not a WebSocket handshake are rejected with 426, and scenes
+// that already finished with 409.
+func (s *WebServer) streamScenePreview(c *fiber.Ctx) error {
+	s.logger.Debug("Stream scene preview request received")
+
+	var req GetSceneStatusRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Stream scene preview request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	if !isWebSocketUpgrade(c) {
+		return sendUpgradeRequired(c)
+	}
+
+	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
+	if err != nil {
+		s.logger.Debug("Invalid scene ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	// The stream outlives the handler, so it is cancelled by sendPreviewStream rather than by the request
+	ctx, cancel := context.WithCancel(context.Background())
+	frames, err := s.clientService.GetPreviewStream(ctx, userID, sceneID)
+	if err != nil {
+		cancel()
+		s.logger.Debug("Failed to stream scene preview: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return s.sendPreviewStream(c, frames, cancel)
+}
+
+// reconcileQuotas handles the request to reconcile every user's storage counter against actual usage.
+// It is a JWT protected, admin only route.
+//
+// The user can optionally specify a query parameter `dry_run`. It defaults to true, so counters are
+// only corrected when `dry_run=false` is given explicitly.
+func (s *WebServer) reconcileQuotas(c *fiber.Ctx) error {
+	s.logger.Debug("Reconcile quotas request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	dryRun := c.QueryBool("dry_run", true)
+
+	report, err := s.clientService.ReconcileAllQuotas(c.UserContext(), userID, dryRun)
+	if err != nil {
+		s.logger.Debug("Failed to reconcile quotas: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(report)
+}
+
+// reconcileStorage handles the request to find and remove orphaned files and scene documents. It is an admin only route.
+//
+// The user can optionally specify a query parameter `dry_run` (default true). Orphans are only removed
+// when `dry_run=false` is given explicitly.
+func (s *WebServer) reconcileStorage(c *fiber.Ctx) error {
+	s.logger.Debug("Reconcile storage request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	dryRun := c.QueryBool("dry_run", true)
+
+	report, err := s.clientService.AdminReconcileStorage(c.UserContext(), userID, dryRun)
+	if err != nil {
+		s.logger.Debug("Failed to reconcile storage: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(report)
+}
+
+// adminPreviewRetention handles the request to report what the next run of the retention policy would remove, and
+// what the last scheduled run removed. Nothing is removed. It is an admin only route.
+func (s *WebServer) adminPreviewRetention(c *fiber.Ctx) error {
+	s.logger.Debug("Preview retention request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	next, last, err := s.clientService.PreviewRetention(c.UserContext(), userID)
+	if err != nil {
+		s.logger.Debug("Failed to preview retention: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"next_run": next, "last_run": last})
+}
+
+// getProcessingTimeStats handles the request to get processing time statistics of completed jobs.
+func (s *WebServer) getProcessingTimeStats(c *fiber.Ctx) error {
+	s.logger.Debug("Get processing time stats request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	stats, err := s.clientService.GetProcessingTimeStats(c.UserContext(), userID)
+	if err != nil {
+		s.logger.Debug("Failed to get processing time stats: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(stats)
+}
+
+// adminListScenes handles the request to list scenes across all users. It is an admin only route.
+//
+// The user can optionally specify query parameters `state` and `owner` (username) to filter the scenes,
+// and `page` (1-indexed) and `page_size` to page through them.
+func (s *WebServer) adminListScenes(c *fiber.Ctx) error {
+	s.logger.Debug("Admin list scenes request received")
+
+	var req AdminListScenesRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Admin list scenes request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	page, err := s.clientService.AdminListScenes(c.UserContext(), userID, services.AdminSceneQuery{
+		State:    scene.State(req.State),
+		Owner:    req.Owner,
+		Page:     req.Page,
+		PageSize: req.PageSize,
+	})
+	if err != nil {
+		s.logger.Debug("Failed to list scenes: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(page)
+}
+
+// adminRequeueJob handles the request to republish the job of any processing scene. It is an admin only route.
+//
+// It expects path parameter `scene_id`.
+func (s *WebServer) adminRequeueJob(c *fiber.Ctx) error {
+	s.logger.Debug("Admin requeue job request received")
+
+	userID, sceneID, err := s.parseAdminSceneRequest(c)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+
+	err = s.clientService.AdminRequeueJob(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to requeue job: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Job requeued"})
+}
+
+// adminBumpJob handles the request to move any processing scene to the priority tier, and republish its job there. It
+// is an admin only route.
+//
+// It expects path parameter `scene_id`.
+func (s *WebServer) adminBumpJob(c *fiber.Ctx) error {
+	s.logger.Debug("Admin bump job request received")
+
+	userID, sceneID, err := s.parseAdminSceneRequest(c)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+
+	err = s.clientService.AdminBumpJob(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to bump job: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Job bumped"})
+}
+
+// adminCancelJob handles the request to cancel the processing of any scene. It is an admin only route.
+//
+// It expects path parameter `scene_id`.
+func (s *WebServer) adminCancelJob(c *fiber.Ctx) error {
+	s.logger.Debug("Admin cancel job request received")
+
+	userID, sceneID, err := s.parseAdminSceneRequest(c)
+	if err != nil {
+		return s.sendError(c, err)
+	}
 
-	return s.sendFileWithRangeSupport(c, outputPath)
+	err = s.clientService.AdminCancelJob(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to cancel job: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Job cancelled"})
 }
 
-// getSceneProgress handles the request to get the progress of a scene. It is a JWT protected route.
+// adminDeleteScene handles the request to permanently delete any scene, cancelling it first if it is processing.
+// It is an admin only route.
 //
-// It expects a path parameter `scene_id`.
-func (s *WebServer) getSceneProgress(c *fiber.Ctx) error {
-	s.logger.Debug("Get scene progress request received")
+// It expects path parameter `scene_id`.
+func (s *WebServer) adminDeleteScene(c *fiber.Ctx) error {
+	s.logger.Debug("Admin delete scene request received")
 
-	var req GetSceneProgressRequest
+	userID, sceneID, err := s.parseAdminSceneRequest(c)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+
+	reclaimed, err := s.clientService.AdminDeleteScene(c.UserContext(), userID, sceneID)
+	if err != nil {
+		s.logger.Debug("Failed to delete scene: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Scene deleted", "bytes_reclaimed": reclaimed})
+}
+
+// adminGetAdmission handles the request to get the state of admission control: the most jobs allowed in flight, and
+// the number of jobs in flight and scenes pending admission. It is an admin only route.
+func (s *WebServer) adminGetAdmission(c *fiber.Ctx) error {
+	s.logger.Debug("Admin get admission request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	status, err := s.clientService.AdminGetAdmission(c.UserContext(), userID)
+	if err != nil {
+		s.logger.Debug("Failed to get admission status: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// adminSetAdmission handles the request to change the most jobs allowed in flight, without a restart. It is an admin
+// only route.
+//
+// It expects a JSON body:
+//
+//	{
+//	    "max_in_flight_jobs": 8 (0 does not limit them)
+//	}
+func (s *WebServer) adminSetAdmission(c *fiber.Ctx) error {
+	s.logger.Debug("Admin set admission request received")
+
+	var req AdminSetAdmissionRequest
 	if err := ValidateRequest(c, &req); err != nil {
-		s.logger.Debug("Get scene progress request validation failed: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		s.logger.Debug("Admin set admission request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	status, err := s.clientService.AdminSetMaxInFlightJobs(c.UserContext(), userID, *req.MaxInFlightJobs)
+	if err != nil {
+		s.logger.Debug("Failed to set admission: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// parseAdminSceneRequest validates an AdminSceneRequest, and returns the requesting user ID and target scene ID.
+func (s *WebServer) parseAdminSceneRequest(c *fiber.Ctx) (primitive.ObjectID, primitive.ObjectID, error) {
+	var req AdminSceneRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Admin scene request validation failed: ", err.Error())
+		return primitive.NilObjectID, primitive.NilObjectID, validationError(err)
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return primitive.NilObjectID, primitive.NilObjectID, services.NewValidationError("Invalid user ID", nil, err)
 	}
 
 	sceneID, err := primitive.ObjectIDFromHex(req.SceneID)
 	if err != nil {
 		s.logger.Debug("Invalid scene ID: ", err.Error())
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid scene ID"})
+		return primitive.NilObjectID, primitive.NilObjectID, services.NewValidationError("Invalid scene ID", nil, err)
 	}
 
+	return userID, sceneID, nil
+}
+
+// adminGetQueues handles the request to get the depth of the broker queues and processing queue lists, the jobs
+// waiting in the outbox, and the state of admission control. It is an admin only route.
+func (s *WebServer) adminGetQueues(c *fiber.Ctx) error {
+	s.logger.Debug("Admin get queues request received")
+
 	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
 	if err != nil {
 		s.logger.Debug("Invalid user ID: ", err.Error())
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	progress, err := s.clientService.GetSceneProgress(context.TODO(), userID, sceneID)
+	status, err := s.clientService.AdminGetQueues(c.UserContext(), userID)
 	if err != nil {
-		s.logger.Debug("Failed to get scene progress: ", err.Error())
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		s.logger.Debug("Failed to get queues: ", err.Error())
+		return s.sendError(c, err)
 	}
 
-	return c.Status(http.StatusOK).JSON(progress)
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// adminGetClusterStatus handles the request to get the registered workers, whether each is available, and the available
+// workers and capacity by kind. It is an admin only route.
+func (s *WebServer) adminGetClusterStatus(c *fiber.Ctx) error {
+	s.logger.Debug("Admin get cluster status request received")
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	status, err := s.clientService.GetClusterStatus(c.UserContext(), userID)
+	if err != nil {
+		s.logger.Debug("Failed to get cluster status: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// adminListUsers handles the request to list every user. It is an admin only route.
+//
+// The user can optionally specify query parameters `page` (1-indexed) and `page_size` to page through them.
+func (s *WebServer) adminListUsers(c *fiber.Ctx) error {
+	s.logger.Debug("Admin list users request received")
+
+	var req AdminListUsersRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Admin list users request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	userID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	page, err := s.clientService.AdminListUsers(c.UserContext(), userID, services.AdminUserQuery{
+		Page:     req.Page,
+		PageSize: req.PageSize,
+	})
+	if err != nil {
+		s.logger.Debug("Failed to list users: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(page)
+}
+
+// adminDisableUser handles the request to disable any account. It is an admin only route.
+//
+// It expects path parameter `user_id`.
+func (s *WebServer) adminDisableUser(c *fiber.Ctx) error {
+	s.logger.Debug("Admin disable user request received")
+	return s.adminSetUserDisabled(c, true)
+}
+
+// adminEnableUser handles the request to re-enable a disabled account. It is an admin only route.
+//
+// It expects path parameter `user_id`.
+func (s *WebServer) adminEnableUser(c *fiber.Ctx) error {
+	s.logger.Debug("Admin enable user request received")
+	return s.adminSetUserDisabled(c, false)
+}
+
+// adminSetUserDisabled disables or re-enables the account named by the `user_id` path parameter.
+func (s *WebServer) adminSetUserDisabled(c *fiber.Ctx, disabled bool) error {
+	adminID, userID, err := s.parseAdminUserRequest(c)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+
+	if err := s.clientService.AdminSetUserDisabled(c.UserContext(), adminID, userID, disabled); err != nil {
+		s.logger.Debug("Failed to set user disabled: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	if disabled {
+		return c.Status(http.StatusOK).JSON(fiber.Map{"message": "User disabled"})
+	}
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "User enabled"})
+}
+
+// adminSetUserQuota handles the request to override the quotas of any user. It is an admin only route.
+// The response holds the user's resulting quota.
+//
+// It expects path parameter `user_id`, and a JSON payload with the following format, where omitted limits keep the
+// server's limits and a limit of 0 does not limit the user:
+//
+//	{
+//	    "max_concurrent_jobs": 4,
+//	    "max_storage_bytes": 10737418240,
+//	    "max_uploads_per_day": 50,
+//	    "max_download_bytes_per_day": 0
+//	}
+func (s *WebServer) adminSetUserQuota(c *fiber.Ctx) error {
+	s.logger.Debug("Admin set user quota request received")
+
+	var req AdminSetUserQuotaRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Admin set user quota request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	adminID, userID, err := s.parseAdminUserRequest(c)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+
+	quota, err := s.clientService.AdminSetUserQuota(c.UserContext(), adminID, userID, &user.QuotaOverrides{
+		MaxConcurrentJobs:      req.MaxConcurrentJobs,
+		MaxStorageBytes:        req.MaxStorageBytes,
+		MaxUploadsPerDay:       req.MaxUploadsPerDay,
+		MaxDownloadBytesPerDay: req.MaxDownloadBytesPerDay,
+	})
+	if err != nil {
+		s.logger.Debug("Failed to set user quota: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(quota)
+}
+
+// adminSetUserTier handles the request to set the job tier of any user, which the jobs of their new scenes are
+// published to. It is an admin only route.
+//
+// It expects path parameter `user_id`, and a JSON payload with the following format:
+//
+//	{
+//	    "tier": "priority" (or "standard", "free")
+//	}
+func (s *WebServer) adminSetUserTier(c *fiber.Ctx) error {
+	s.logger.Debug("Admin set user tier request received")
+
+	var req AdminSetUserTierRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Admin set user tier request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	adminID, userID, err := s.parseAdminUserRequest(c)
+	if err != nil {
+		return s.sendError(c, err)
+	}
+
+	if err := s.clientService.AdminSetUserTier(c.UserContext(), adminID, userID, req.Tier); err != nil {
+		s.logger.Debug("Failed to set user tier: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "User tier set", "tier": req.Tier})
+}
+
+// parseAdminUserRequest validates an AdminUserRequest, and returns the requesting user ID and target user ID.
+func (s *WebServer) parseAdminUserRequest(c *fiber.Ctx) (primitive.ObjectID, primitive.ObjectID, error) {
+	var req AdminUserRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Admin user request validation failed: ", err.Error())
+		return primitive.NilObjectID, primitive.NilObjectID, validationError(err)
+	}
+
+	adminID, err := primitive.ObjectIDFromHex(c.Locals("userID").(string))
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return primitive.NilObjectID, primitive.NilObjectID, services.NewValidationError("Invalid user ID", nil, err)
+	}
+
+	userID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		s.logger.Debug("Invalid user ID: ", err.Error())
+		return primitive.NilObjectID, primitive.NilObjectID, services.NewValidationError("Invalid user ID", nil, err)
+	}
+
+	return adminID, userID, nil
 }
 
 // getWorkerData handles the request to send data between workers. It is an internal route.
@@ -589,6 +4151,154 @@ func (s *WebServer) getWorkerData(c *fiber.Ctx) error {
 	return c.SendFile(fullPath)
 }
 
+// getOutputUpload handles the request to get the progress of uploading an output file. It is a worker route.
+//
+// It expects path parameters `job_id`, `output_type`, `iteration`, and `file_name`. The next chunk of the output is
+// to be sent at the returned offset, which is also set in the `Upload-Offset` header.
+func (s *WebServer) getOutputUpload(c *fiber.Ctx) error {
+	s.logger.Debug("Get output upload request received")
+
+	var req WorkerOutputRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Get output upload request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	status, err := s.workerService.GetOutputUpload(c.UserContext(), req.JobID, req.OutputType, req.Iteration, req.FileName)
+	if err != nil {
+		s.logger.Debug("Failed to get output upload: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	c.Set(uploadOffsetHeader, strconv.FormatInt(status.Offset, 10))
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// appendOutput handles the request to append a chunk to an output file of a training scene. It is a worker route.
+//
+// It expects path parameters `job_id`, `output_type`, `iteration`, and `file_name`, the `Upload-Offset` header with
+// the number of bytes the output has received, optional query parameter `final` set on the last chunk, and the raw
+// bytes of the chunk as the body. Chunks are limited by the body limit of the server. Once the final chunk is received,
+// the output is recorded in the scene and can be downloaded.
+func (s *WebServer) appendOutput(c *fiber.Ctx) error {
+	s.logger.Debug("Append output request received")
+
+	// The body is the chunk, so only the path parameters are parsed
+	var req WorkerOutputRequest
+	if err := c.ParamsParser(&req); err != nil {
+		return s.sendError(c, validationError(err))
+	}
+	if err := validate.Struct(req); err != nil {
+		s.logger.Debug("Append output request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+	offset, err := strconv.ParseInt(c.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		s.logger.Debug("Invalid upload offset: ", c.Get(uploadOffsetHeader))
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or missing " + uploadOffsetHeader + " header"})
+	}
+
+	final := c.QueryBool("final")
+	status, err := s.workerService.AppendOutput(c.UserContext(), req.JobID, req.OutputType, req.Iteration, req.FileName, offset, bytes.NewReader(c.Body()), final)
+	if err != nil {
+		s.logger.Debug("Failed to append to output: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	c.Set(uploadOffsetHeader, strconv.FormatInt(status.Offset, 10))
+	return c.Status(http.StatusOK).JSON(status)
+}
+
+// registerWorker handles the request of a GPU worker to register, replacing its registration from before a restart.
+// It is a worker route.
+//
+// It expects path parameter `worker_id`, chosen by the worker (i.e its hostname), and a JSON payload with the
+// following format:
+//
+//	{
+//	    "kind": "sfm", (or "nerf")
+//	    "hostname": "gpu-node-1", (optional)
+//	    "version": "1.4.0", (optional)
+//	    "capacity": {
+//	        "slots": 2,
+//	        "active_jobs": 0,
+//	        "gpus": [{"name": "RTX 4090", "memory_total": 25757220864, "memory_used": 0, "utilization": 0}]
+//	    }
+//	}
+//
+// The response holds the interval in seconds the worker is to send heartbeats at, see sendWorkerHeartbeat.
+func (s *WebServer) registerWorker(c *fiber.Ctx) error {
+	s.logger.Debug("Register worker request received")
+
+	var req RegisterWorkerRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Register worker request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	registered, err := s.workerService.RegisterWorker(c.UserContext(), services.WorkerRegistration{
+		ID:       req.WorkerID,
+		Kind:     req.Kind,
+		Hostname: req.Hostname,
+		Version:  req.Version,
+		Capacity: workerCapacity(req.Capacity),
+	})
+	if err != nil {
+		s.logger.Debug("Failed to register worker: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(registered)
+}
+
+// sendWorkerHeartbeat handles the heartbeat of a registered worker, with its current capacity. It is a worker route.
+//
+// It expects path parameter `worker_id`, and a JSON payload with the `capacity` of registerWorker. A worker that is
+// not registered, i.e because it sent no heartbeat for too long, gets 404 Not Found and is to register again.
+func (s *WebServer) sendWorkerHeartbeat(c *fiber.Ctx) error {
+	var req WorkerHeartbeatRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Worker heartbeat request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	if err := s.workerService.RecordWorkerHeartbeat(c.UserContext(), req.WorkerID, workerCapacity(req.Capacity)); err != nil {
+		s.logger.Debug("Failed to record worker heartbeat: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// deregisterWorker handles the request of a worker to deregister, i.e as it shuts down. It is a worker route.
+//
+// It expects path parameter `worker_id`.
+func (s *WebServer) deregisterWorker(c *fiber.Ctx) error {
+	s.logger.Debug("Deregister worker request received")
+
+	var req WorkerRequest
+	if err := ValidateRequest(c, &req); err != nil {
+		s.logger.Debug("Deregister worker request validation failed: ", err.Error())
+		return s.sendError(c, validationError(err))
+	}
+
+	if err := s.workerService.DeregisterWorker(c.UserContext(), req.WorkerID); err != nil {
+		s.logger.Debug("Failed to deregister worker: ", err.Error())
+		return s.sendError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(fiber.Map{"message": "Worker deregistered"})
+}
+
+// workerCapacity converts the capacity of a worker request to the capacity stored in the worker registry.
+func workerCapacity(capacity WorkerCapacity) worker.Capacity {
+	gpus := make([]worker.GPU, 0, len(capacity.GPUs))
+	for _, gpu := range capacity.GPUs {
+		gpus = append(gpus, worker.GPU(gpu))
+	}
+	return worker.Capacity{Slots: capacity.Slots, ActiveJobs: capacity.ActiveJobs, GPUs: gpus}
+}
+
 // getRoutes handles the request to get the list of routes available on the server.
 func (s *WebServer) getRoutes(c *fiber.Ctx) error {
 	s.logger.Debug("Get routes request received")
@@ -602,12 +4312,46 @@ func (s *WebServer) healthCheck(c *fiber.Ctx) error {
 	return c.SendString("OK")
 }
 
+// getLiveness handles the liveness probe, which checks every dependency of the server and reports the status of each.
+// It responds with 503 Service Unavailable only if a restart could fix a failing check, see services.HealthService.
+func (s *WebServer) getLiveness(c *fiber.Ctx) error {
+	return sendHealthReport(c, s.healthService.Liveness(c.UserContext()))
+}
+
+// getReadiness handles the readiness probe, which checks every dependency of the server and reports the status of
+// each. It responds with 503 Service Unavailable if any check failed, so no requests are routed to the server.
+func (s *WebServer) getReadiness(c *fiber.Ctx) error {
+	return sendHealthReport(c, s.healthService.Readiness(c.UserContext()))
+}
+
+// sendHealthReport sends a health report, with 200 OK if the probe passed and 503 Service Unavailable otherwise.
+func sendHealthReport(c *fiber.Ctx, report *services.HealthReport) error {
+	c.Set("Cache-Control", "no-store")
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+	}
+	return c.Status(status).JSON(report)
+}
+
+
+// getMetrics handles the request to collect application metrics, in the Prometheus exposition format the scraper
+// negotiates.
+func (s *WebServer) getMetrics(c *fiber.Ctx) error {
+	return adaptor.HTTPHandler(s.metrics.Handler())(c)
+}
 
 // sendFileWithRangeSupport sends a file with support for the Range header.
 // Call this function from any handler which you suspect needs to handle large files.
 //
-// This function trusts the Range header and does not perform any validation on the range values.
-func (s *WebServer) sendFileWithRangeSupport(c *fiber.Ctx, filePath string) error {
+// Conditional requests are supported: If-None-Match and If-Modified-Since may yield 304 Not Modified, and a Range
+// guarded by If-Range is only honored if the file is unchanged.
+//
+// A single byte range is supported, see parseByteRange. Malformed and multi-range headers are ignored and the full
+// file is sent, while a range outside of the file yields 416 Range Not Satisfiable.
+//
+// etag is the ETag to send the file with, or empty to use the weak fileETag.
+func (s *WebServer) sendFileWithRangeSupport(c *fiber.Ctx, filePath, etag string) error {
     file, err := os.Open(filePath)
     if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to open file"})
@@ -619,37 +4363,87 @@ func (s *WebServer) sendFileWithRangeSupport(c *fiber.Ctx, filePath string) erro
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get file info"})
     }
 
+    if etag == "" {
+        etag = fileETag(stat)
+    }
+    setFileValidators(c, etag, stat.ModTime())
+    if isNotModified(c, etag, stat.ModTime()) {
+        return c.SendStatus(fiber.StatusNotModified)
+    }
+
     fileSize := stat.Size()
-    contentLength := fileSize
-    start := int64(0)
-    end := fileSize - 1
 
+    // A Range is only honored if the file is unchanged since the client's partial copy, otherwise send it in full
     rangeHeader := c.Get("Range")
-    if rangeHeader != "" {
-        if strings.HasPrefix(rangeHeader, "bytes=") {
-            rangeHeader = rangeHeader[6:]
-            rangeParts := strings.Split(rangeHeader, "-")
-            if len(rangeParts) == 2 {
-                start, _ = strconv.ParseInt(rangeParts[0], 10, 64)
-                if rangeParts[1] != "" {
-                    end, _ = strconv.ParseInt(rangeParts[1], 10, 64)
-                }
-            }
-        }
+    if !ifRangeMatches(c, etag, stat.ModTime()) {
+        rangeHeader = ""
     }
-
-    if start >= fileSize || start > end || end >= fileSize {
+    start, end, partial, err := parseByteRange(rangeHeader, fileSize)
+    if err != nil {
+        c.Set("Content-Range", fmt.Sprintf("bytes */%d", fileSize))
         return c.Status(fiber.StatusRequestedRangeNotSatisfiable).SendString("Invalid range")
     }
+    if !partial {
+        start, end = 0, fileSize-1
+    }
+
+    return s.sendFileSection(c, file, filePath, start, end, fileSize, partial)
+}
+
+// sendFileChunk sends a single chunk of a file, using the same chunk layout as ClientService.GetSceneMetadata.
+// The response is always 206 Partial Content, with the matching Content-Range. etag is as in sendFileWithRangeSupport.
+func (s *WebServer) sendFileChunk(c *fiber.Ctx, filePath string, chunk int, chunkSize int64, etag string) error {
+    file, err := os.Open(filePath)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to open file"})
+    }
+    defer file.Close()
+
+    stat, err := file.Stat()
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get file info"})
+    }
+
+    if etag == "" {
+        etag = fileETag(stat)
+    }
+    setFileValidators(c, etag, stat.ModTime())
+    if isNotModified(c, etag, stat.ModTime()) {
+        return c.SendStatus(fiber.StatusNotModified)
+    }
+
+    start, end, err := services.ChunkRange(stat.Size(), chunkSize, chunk)
+    if err != nil {
+        return c.Status(fiber.StatusRequestedRangeNotSatisfiable).JSON(fiber.Map{"error": err.Error()})
+    }
+
+    return s.sendFileSection(c, file, filePath, start, end, stat.Size(), true)
+}
+
+// setContentDisposition sets the Content-Disposition of a served output, so browsers save it under its human
+// readable file name. Viewable outputs are displayed inline, everything else is downloaded as an attachment.
+// Non-ASCII file names are encoded as in RFC 6266.
+func setContentDisposition(c *fiber.Ctx, output *services.SceneOutput) {
+	disposition := "attachment"
+	if output.Viewable {
+		disposition = "inline"
+	}
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": output.FileName}); header != "" {
+		c.Set("Content-Disposition", header)
+	}
+}
 
-    contentLength = end - start + 1
+// sendFileSection sends the inclusive byte range [start, end] of an open file. The range must already be validated.
+// partial selects between 206 Partial Content and 200 OK.
+func (s *WebServer) sendFileSection(c *fiber.Ctx, file *os.File, filePath string, start, end, fileSize int64, partial bool) error {
+    contentLength := end - start + 1
 
-    c.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
     c.Set("Accept-Ranges", "bytes")
     c.Set("Content-Length", fmt.Sprintf("%d", contentLength))
 
-    // Set the appropriate status code
-    if rangeHeader != "" {
+    // Set the appropriate status code, Content-Range only describes partial responses
+    if partial {
+        c.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
         c.Status(fiber.StatusPartialContent)
     } else {
         c.Status(fiber.StatusOK)
@@ -658,8 +4452,18 @@ func (s *WebServer) sendFileWithRangeSupport(c *fiber.Ctx, filePath string) erro
     // Set the Content-Type header based on the file extension
     c.Type(filepath.Ext(filePath))
 
+    // A throttled body is read from a handle of its own, as the caller closes file once this returns
+    if download, ok := c.Locals(throttledDownloadLocal).(*throttledDownload); ok {
+        section, err := os.Open(filePath)
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to open file"})
+        }
+        c.Context().SetBodyStream(download.reader(section, start, contentLength), int(contentLength))
+        return nil
+    }
+
     // Seek to the start position in the file
-    _, err = file.Seek(start, io.SeekStart)
+    _, err := file.Seek(start, io.SeekStart)
     if err != nil {
         return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to seek file"})
     }
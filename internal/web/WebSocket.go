@@ -0,0 +1,183 @@
+// This file contains the WebSocket connections streaming live training previews to the browser, served with
+// github.com/fasthttp/websocket on the fasthttp connection of the request.
+//
+// The server sends text and binary messages, and only reads control frames from the client, whose data messages are
+// discarded. No compression or subprotocol is negotiated. As with Server-Sent Events, the browser WebSocket API cannot
+// set request headers, so routes upgrading to a WebSocket accept the access token in the `access_token` query
+// parameter, see tokenFromQuery. The connection is authenticated once, when upgrading.
+//
+// The server pings the client every wsPingInterval, and drops a client that sent nothing, not even a pong, for two
+// intervals by the time of the next ping. A write that does not complete within wsWriteTimeout drops the client too, so a stalled client cannot
+// hold a connection open forever. Slower clients are handled by the producer, which skips frames rather than queue
+// them, see services.GetPreviewStream.
+
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+
+	"github.com/NeRF-or-Nothing/go-web-server/internal/services"
+)
+
+// wsPingInterval is how often the client is pinged.
+const wsPingInterval = 30 * time.Second
+
+// wsWriteTimeout is how long a message may take to be written before the client is dropped.
+const wsWriteTimeout = 10 * time.Second
+
+// wsMaxClientPayload is the largest message read from the client, which only has control frames to send.
+const wsMaxClientPayload = 4096
+
+// wsUpgrader completes the handshake of WebSocket routes.
+var wsUpgrader = websocket.FastHTTPUpgrader{
+	// The access token is sent in the query rather than by a cookie, so any origin may connect, as with CORS requests
+	CheckOrigin: func(*fasthttp.RequestCtx) bool { return true },
+}
+
+// previewMessage is the text message sent before the binary message holding each preview frame.
+type previewMessage struct {
+	Type        string    `json:"type"`
+	Iteration   int       `json:"iteration"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	Time        time.Time `json:"time"`
+}
+
+// isWebSocketUpgrade checks if the request asks to upgrade to a WebSocket. The rest of the handshake is checked when
+// upgrading.
+func isWebSocketUpgrade(c *fiber.Ctx) bool {
+	return c.Method() == fiber.MethodGet && websocket.FastHTTPIsWebSocketUpgrade(c.Context())
+}
+
+// sendUpgradeRequired rejects a request to a WebSocket route that is not a WebSocket handshake.
+func sendUpgradeRequired(c *fiber.Ctx) error {
+	c.Set("Sec-WebSocket-Version", "13")
+	c.Set("Upgrade", "websocket")
+	return c.Status(http.StatusUpgradeRequired).JSON(fiber.Map{"error": "WebSocket upgrade required"})
+}
+
+// readControl reads from the client until it closes the connection or fails, discarding data messages, and stores the
+// time each frame is received in lastRead. Pings are answered and the close frame of the client echoed by the handlers
+// of the connection.
+func readControl(ws *websocket.Conn, lastRead *atomic.Int64) error {
+	ws.SetReadLimit(wsMaxClientPayload)
+	answerPing := ws.PingHandler()
+	ws.SetPingHandler(func(data string) error {
+		lastRead.Store(time.Now().UnixNano())
+		return answerPing(data)
+	})
+	ws.SetPongHandler(func(string) error {
+		lastRead.Store(time.Now().UnixNano())
+		return nil
+	})
+
+	for {
+		_, r, err := ws.NextReader()
+		if err != nil {
+			return err
+		}
+		// The read limit applies to the whole message only while it is read from a single reader
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+		lastRead.Store(time.Now().UnixNano())
+	}
+}
+
+// sendPreviewStream upgrades the request to a WebSocket, and streams preview frames to the client until the channel
+// is closed or the client goes away, after which cancel is called so the producer of the frames stops. The request
+// must be checked with isWebSocketUpgrade first.
+//
+// Each frame is sent as a text message holding a previewMessage, followed by a binary message holding the image.
+func (s *WebServer) sendPreviewStream(c *fiber.Ctx, frames <-chan services.PreviewFrame, cancel context.CancelFunc) error {
+	err := wsUpgrader.Upgrade(c.Context(), func(ws *websocket.Conn) {
+		defer cancel()
+
+		var lastRead atomic.Int64
+		lastRead.Store(time.Now().UnixNano())
+		closed := make(chan error, 1)
+		go func() {
+			closed <- readControl(ws, &lastRead)
+		}()
+		// fasthttp reuses the buffered reader of the connection once the handler returns, so readControl must have
+		// returned by then. It is given until closeDeadline to read the close frame of the client, which is already
+		// past unless the server started the close handshake.
+		closeDeadline := time.Now()
+		defer func() {
+			if closed == nil {
+				return
+			}
+			ws.SetReadDeadline(closeDeadline)
+			<-closed
+		}()
+
+		write := func(messageType int, data []byte) error {
+			if err := ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+				return err
+			}
+			return ws.WriteMessage(messageType, data)
+		}
+
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case frame, ok := <-frames:
+				if !ok {
+					closeDeadline = time.Now().Add(wsWriteTimeout)
+					message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "training ended")
+					ws.WriteControl(websocket.CloseMessage, message, closeDeadline)
+					return
+				}
+				message, err := json.Marshal(previewMessage{
+					Type:        "preview",
+					Iteration:   frame.Iteration,
+					ContentType: frame.ContentType,
+					Size:        len(frame.Data),
+					Time:        frame.Time,
+				})
+				if err != nil {
+					s.logger.Errorf("Failed to encode preview frame: %v", err)
+					continue
+				}
+				if err := write(websocket.TextMessage, message); err != nil {
+					return
+				}
+				if err := write(websocket.BinaryMessage, frame.Data); err != nil {
+					return
+				}
+			case <-ping.C:
+				if time.Since(time.Unix(0, lastRead.Load())) > 2*wsPingInterval {
+					s.logger.Debugf("Preview stream client timed out")
+					return
+				}
+				if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+					return
+				}
+			case err := <-closed:
+				closed = nil
+				var closeErr *websocket.CloseError
+				if !errors.As(err, &closeErr) {
+					s.logger.Debugf("Preview stream client went away: %v", err)
+				}
+				return
+			}
+		}
+	})
+	if err != nil {
+		// The handshake was rejected, and the response already holds the reason
+		cancel()
+		s.logger.Debugf("Failed to upgrade to a WebSocket: %v", err)
+	}
+	return nil
+}